@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+)
+
+// compareModels runs the standard prompt suite against models, a
+// comma-separated list or "configured", and prints how each performed. It
+// returns the exit code.
+func compareModels(models string, runs int) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	client, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel, llm.TransportConfig(cfg.LLMTransport))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	list := cfg.ConfiguredModels()
+	if models != "configured" {
+		list = strings.Split(models, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+	}
+
+	results := client.Benchmark(context.Background(), list, llm.StandardSuite, runs)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "MODEL\tCALLS\tERRORS\tMEAN MS\tP50 MS\tP95 MS\tTOKENS/S\tVALID JSON")
+	failed := false
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%.1f\t%.0f%%\n", result.Model, result.Calls, result.Errors,
+			result.MeanLatencyMS, result.P50LatencyMS, result.P95LatencyMS, result.TokensPerSecond, result.JSONValidRate*100)
+		failed = failed || result.Errors == result.Calls
+	}
+	table.Flush()
+	for _, result := range results {
+		if result.LastError != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", result.Model, result.LastError)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
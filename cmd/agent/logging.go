@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/redact"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newLogger builds the logger described by log_format and log_sampling,
+// logging at level, which starts at log_level. Secrets are removed from
+// everything logged by redactor.
+func newLogger(cfg *config.Config, level zap.AtomicLevel, redactor *redact.Redactor) (*zap.Logger, error) {
+	logLevel, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log_level: %w", err)
+	}
+
+	logConfig := zap.NewProductionConfig()
+	logConfig.Level = level
+	switch cfg.LogFormat {
+	case "", "json":
+	case "console":
+		logConfig.Encoding = "console"
+		logConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		logConfig.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	default:
+		return nil, fmt.Errorf("unknown log_format %q; use json or console", cfg.LogFormat)
+	}
+	logConfig.Sampling = nil
+	if cfg.LogSampling.Initial > 0 {
+		logConfig.Sampling = &zap.SamplingConfig{
+			Initial:    cfg.LogSampling.Initial,
+			Thereafter: cfg.LogSampling.Thereafter,
+		}
+	}
+	logger, err := logConfig.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redact.Core(core, redactor)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	level.SetLevel(logLevel)
+	return logger, nil
+}
+
+// newRedactor creates the redactor for the configured patterns and
+// credentials
+func newRedactor(cfg *config.Config) (*redact.Redactor, error) {
+	redactor, err := redact.New(cfg.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	redactor.SetSecrets(cfg.SecretValues())
+	return redactor, nil
+}
+
+// fatal reports a failure that happens before the configured logger exists
+func fatal(msg string, err error) {
+	logger, _ := zap.NewProduction()
+	logger.Fatal(msg, zap.Error(err))
+}
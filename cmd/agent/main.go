@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,6 +12,8 @@ import (
 
 	"spilot-agent/internal/agent"
 	"spilot-agent/internal/config"
+	"spilot-agent/internal/github"
+	"spilot-agent/internal/gitlab"
 	"spilot-agent/internal/llm"
 	"spilot-agent/internal/server"
 
@@ -17,34 +21,159 @@ import (
 )
 
 func main() {
-	// Initialize logger
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
+	validate := flag.Bool("validate-config", false, "check the configuration and exit")
+	compare := flag.String("compare-models", "", `benchmark comma-separated models, or "configured" ones, and exit`)
+	compareRuns := flag.Int("compare-runs", 1, "how many times -compare-models runs its prompt suite")
+	stdio := flag.Bool("stdio", false, "serve JSON-RPC on stdin/stdout for an editor instead of HTTP")
+	lsp := flag.Bool("lsp", false, "serve the Language Server Protocol on stdin/stdout instead of HTTP")
+	desktop := flag.Bool("desktop", false, "serve the web UI on localhost only and open it in the browser")
+	worker := flag.Bool("worker", false, "run tasks from the queue configured under queue instead of serving HTTP")
+	profile := flag.String("profile", "", "configuration profile to apply, e.g. dev or prod (default $SPILOT_PROFILE)")
+	flag.Parse()
+	if *profile != "" {
+		config.SelectProfile(*profile)
+	}
+	if *validate {
+		os.Exit(validateConfig())
+	}
+	if *compare != "" {
+		os.Exit(compareModels(*compare, *compareRuns))
+	}
 
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
+		fatal("Failed to load configuration", err)
 	}
 
+	// Initialize logger; its level follows log_level on reload and can be
+	// changed at run time through the admin API
+	level := zap.NewAtomicLevel()
+	redactor, err := newRedactor(cfg)
+	if err != nil {
+		fatal("Invalid redact_patterns", err)
+	}
+	logger, err := newLogger(cfg, level, redactor)
+	if err != nil {
+		fatal("Failed to initialize logger", err)
+	}
+	defer logger.Sync()
+
 	// Initialize LLM client
-	llmClient, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel)
+	llmClient, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel, llm.TransportConfig(cfg.LLMTransport))
 	if err != nil {
 		logger.Fatal("Failed to initialize LLM client", zap.Error(err))
 	}
+	checkModel(llmClient, cfg.DefaultModel, logger)
 
 	// Initialize agent system
-	agentSystem := agent.NewSystem(llmClient, logger)
+	agentSystem, err := agent.NewSystem(llmClient, cfg, redactor, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize agent system", zap.Error(err))
+	}
+
+	// A worker runs the tasks servers put on the queue; it serves no API
+	if *worker {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := agentSystem.Work(ctx)
+		stop()
+		agentSystem.Shutdown()
+		if err != nil {
+			logger.Fatal("Worker failed", zap.Error(err))
+		}
+		logger.Info("Worker exited")
+		return
+	}
+
+	// Reload safe settings on SIGHUP, when the config file changes or
+	// through the admin API
+	reload := &reloader{current: cfg, level: level, redactor: redactor, llm: llmClient, system: agentSystem, logger: logger}
+
+	// Issues and comments on GitHub become tasks when a webhook secret is set
+	gitHub, err := github.New(github.Config(cfg.GitHub), cfg.DataDir, agentSystem, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize GitHub integration", zap.Error(err))
+	}
+	// Failed GitLab pipelines are triaged when a webhook token is set
+	gitLab := gitlab.New(gitlab.Config(cfg.GitLab), cfg.DataDir, agentSystem, logger)
 
 	// Initialize HTTP server
-	srv := server.New(agentSystem, logger)
+	options := server.Options{
+		LogLevel:        level,
+		AccessLog:       cfg.AccessLog,
+		APIKeysRequired: cfg.APIKeysRequired,
+		UI:              cfg.UI || *desktop,
+		Admin: server.AdminOptions{
+			Listen: cfg.AdminListen(),
+			Token:  cfg.Admin.Token,
+			Reload: func() ([]string, []string, error) { return reload.reload("admin API") },
+		},
+	}
+	if gitHub != nil {
+		options.GitHub = gitHub
+	}
+	if gitLab != nil {
+		options.GitLab = gitLab
+	}
+	srv := server.New(agentSystem, options, logger)
 
-	// Start server in a goroutine
+	config.Watch(func() { reload.reload("file") })
+	go reload.refreshSecrets(cfg.Secrets.RefreshInterval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		logger.Info("Starting Spilot Agent server", zap.String("port", cfg.Port))
-		if err := srv.Start(cfg.Port); err != nil && err != http.ErrServerClosed {
+		for range hup {
+			reload.reload("SIGHUP")
+		}
+	}()
+
+	// An editor running the agent as its child process talks to it over
+	// stdin/stdout; no port is opened
+	if *stdio || *lsp {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		serve, protocol := srv.ServeStdio, "JSON-RPC"
+		if *lsp {
+			serve, protocol = srv.ServeLSP, "the Language Server Protocol"
+		}
+		logger.Info("Serving "+protocol+" on stdin/stdout", zap.String("profile", cfg.Profile))
+		err := serve(ctx, os.Stdin, os.Stdout)
+		stop()
+		agentSystem.Shutdown()
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Editor connection failed", zap.Error(err))
+		}
+		logger.Info("Server exited")
+		return
+	}
+
+	// The desktop mode is for one local user: nothing else can connect
+	if *desktop {
+		listener, err := net.Listen("tcp", "127.0.0.1:"+cfg.Port)
+		if err != nil {
 			logger.Fatal("Server failed to start", zap.Error(err))
 		}
+		url := "http://" + listener.Addr().String() + "/"
+		go func() {
+			logger.Info("Serving the Spilot UI", zap.String("url", url), zap.String("profile", cfg.Profile))
+			if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Server failed", zap.Error(err))
+			}
+		}()
+		if err := openBrowser(url); err != nil {
+			logger.Warn("Failed to open the browser; open the UI yourself", zap.String("url", url), zap.Error(err))
+		}
+	} else {
+		go func() {
+			logger.Info("Starting Spilot Agent server", zap.String("port", cfg.Port), zap.String("profile", cfg.Profile))
+			if err := srv.Start(cfg.Port); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Server failed to start", zap.Error(err))
+			}
+		}()
+	}
+	go func() {
+		if err := srv.StartAdmin(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Admin server failed to start", zap.Error(err))
+		}
 	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server
@@ -63,5 +192,8 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	// Stop background processes started by the agents
+	agentSystem.Shutdown()
+
 	logger.Info("Server exited")
 }
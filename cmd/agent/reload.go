@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+	"spilot-agent/internal/redact"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// reloader applies configuration changes to the running server, on SIGHUP
+// or when the configuration file is written
+type reloader struct {
+	mu      sync.Mutex
+	current *config.Config
+	level   zap.AtomicLevel
+	// redactor follows redact_patterns and the configured credentials
+	redactor *redact.Redactor
+	llm      *llm.GroqClient
+	system   *agent.System
+	logger   *zap.Logger
+}
+
+// reload re-reads the configuration and applies the keys that can change
+// while running, warning about changes that need a restart. It returns
+// the keys applied and those needing a restart.
+func (r *reloader) reload(trigger string) (applied, restart []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Reload()
+	if err != nil {
+		r.logger.Error("Failed to reload configuration; keeping the current one", zap.String("trigger", trigger), zap.Error(err))
+		return nil, nil, err
+	}
+	changed := config.Changed(r.current, cfg)
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err == nil {
+		// Redact new credentials before anything could log them
+		r.redactor.SetSecrets(append(r.current.SecretValues(), cfg.SecretValues()...))
+		err = r.redactor.SetPatterns(cfg.RedactPatterns)
+	}
+	if err == nil {
+		err = r.system.ApplyConfig(cfg, changed)
+	}
+	if err == nil && cfg.GroqAPIKey != r.current.GroqAPIKey {
+		err = r.llm.SetAPIKey(cfg.GroqAPIKey)
+	}
+	if err != nil {
+		r.logger.Error("Failed to reload configuration; keeping the current one", zap.String("trigger", trigger), zap.Error(err))
+		return nil, nil, err
+	}
+	// Keep a level set through the admin API unless log_level changed
+	if cfg.LogLevel != r.current.LogLevel {
+		r.level.SetLevel(level)
+	}
+
+	for _, key := range changed {
+		if config.Reloadable(key) {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	if len(restart) > 0 {
+		r.logger.Warn("Configuration changes need a restart to take effect", zap.Strings("keys", restart))
+	}
+	r.logger.Info("Reloaded configuration", zap.String("trigger", trigger), zap.Strings("applied", applied))
+	r.current = cfg
+	r.redactor.SetSecrets(cfg.SecretValues())
+	return applied, restart, nil
+}
+
+// refreshSecrets reloads the configuration every interval so that rotated
+// secrets are picked up. It does nothing if no value is a secret reference.
+func (r *reloader) refreshSecrets(interval time.Duration) {
+	if interval <= 0 || len(r.current.SecretKeys()) == 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		r.reload("secrets refresh")
+	}
+}
+
+// parseLogLevel parses a level name such as "debug" or "info"
+func parseLogLevel(name string) (zapcore.Level, error) {
+	if name == "" {
+		return zapcore.InfoLevel, nil
+	}
+	return zapcore.ParseLevel(name)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+
+	"go.uber.org/zap"
+)
+
+// modelCheckTimeout bounds asking the provider which models it serves
+const modelCheckTimeout = 10 * time.Second
+
+// validateConfig checks the configuration and that the provider serves
+// the default model, without starting the server. It prints what is wrong
+// and returns the exit code.
+func validateConfig() int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	client, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel, llm.TransportConfig(cfg.LLMTransport))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), modelCheckTimeout)
+	defer cancel()
+	if err := client.CheckModel(ctx, cfg.DefaultModel); err != nil {
+		fmt.Fprintf(os.Stderr, "default_model: %v\n", err)
+		return 1
+	}
+	fmt.Println("Configuration is valid")
+	return 0
+}
+
+// checkModel stops startup if the provider does not serve the default
+// model. A provider that cannot be reached only causes a warning.
+func checkModel(client *llm.GroqClient, model string, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), modelCheckTimeout)
+	defer cancel()
+	err := client.CheckModel(ctx, model)
+	switch {
+	case errors.Is(err, llm.ErrUnknownModel):
+		logger.Fatal("Invalid default_model; fix it in config.yaml or "+config.EnvName("default_model"), zap.Error(err))
+	case err != nil:
+		logger.Warn("Could not check default_model with the provider", zap.Error(err))
+	}
+}
@@ -0,0 +1,88 @@
+// Command loadtest measures the task pipeline with a mock LLM in place of
+// the provider, so performance regressions in plan parsing, queueing and
+// file operations show before a release.
+//
+// It makes requests through planning, approval and execution concurrently
+// and reports their latency:
+//
+//	go run ./cmd/loadtest -requests 500 -concurrency 32 -latency 200ms
+//
+// Benchmarks of each stage are the loadtest package's, for benchstat to
+// compare:
+//
+//	go test ./internal/loadtest -run '^$' -bench . -count 6 > new.txt
+//
+// The configuration is read as by the server, from config.yaml or -config,
+// with the workspace and data in a temporary directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/loadtest"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	requests := flag.Int("requests", 200, "how many requests the load test makes")
+	concurrency := flag.Int("concurrency", 16, "how many requests the load test makes at once")
+	latency := flag.Duration("latency", 100*time.Millisecond, "how long each mock LLM call takes in the load test")
+	files := flag.Int("files", 5, "how many files the plan of each load test request creates")
+	fileBytes := flag.Int("file-bytes", 4096, "the size of the files the load test creates")
+	configFile := flag.String("config", "", "configuration file (default config.yaml)")
+	flag.Parse()
+	if *configFile != "" {
+		config.SetFile(*configFile)
+	}
+
+	os.Exit(runLoadTest(loadtest.Options{Requests: *requests, Concurrency: *concurrency}, *latency, *files, *fileBytes))
+}
+
+// runLoadTest makes the requests of opts, interrupted by Ctrl-C, prints
+// the report and returns the exit code
+func runLoadTest(opts loadtest.Options, latency time.Duration, files, fileBytes int) int {
+	llm := loadtest.NewMockLLM(latency, loadtest.MockPlan(files, fileBytes))
+	h, err := loadtest.NewHarness(llm, newLogger())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		return 1
+	}
+	defer h.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("%d requests, %d at once, %s per LLM call, %d files of %d bytes each\n",
+		opts.Requests, opts.Concurrency, latency, files, fileBytes)
+	report := h.Run(ctx, opts)
+
+	fmt.Printf("completed  %d/%d in %s\n", report.Requests-report.Failed, report.Requests, report.Elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput %.1f requests/s\n", report.Throughput())
+	fmt.Printf("latency    p50 %s  p95 %s  p99 %s  max %s\n", report.P50.Round(time.Millisecond),
+		report.P95.Round(time.Millisecond), report.P99.Round(time.Millisecond), report.Max.Round(time.Millisecond))
+	fmt.Printf("LLM calls  %d\n", report.LLMCalls)
+	if report.Failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d requests failed; the first: %s\n", report.Failed, report.FirstError)
+		return 1
+	}
+	return 0
+}
+
+// newLogger logs problems only, so logging does not weigh on what is
+// measured
+func newLogger() *zap.Logger {
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	logConfig.DisableStacktrace = true
+	logger, err := logConfig.Build()
+	if err != nil {
+		return zap.NewNop()
+	}
+	return logger
+}
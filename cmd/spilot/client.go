@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// request is the body of agent API requests
+type request struct {
+	Request      string                 `json:"request,omitempty"`
+	Command      string                 `json:"command,omitempty"`
+	Args         string                 `json:"args,omitempty"`
+	Message      string                 `json:"message,omitempty"`
+	Title        string                 `json:"title,omitempty"`
+	WorkspaceDir string                 `json:"workspace_dir,omitempty"`
+	Model        string                 `json:"model,omitempty"`
+	TaskID       string                 `json:"task_id,omitempty"`
+	SessionID    string                 `json:"session_id,omitempty"`
+	Plan         string                 `json:"plan,omitempty"`
+	Token        string                 `json:"token,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	// ConfirmWrites holds each file write until it is approved
+	ConfirmWrites bool `json:"confirm_writes,omitempty"`
+}
+
+// response is the envelope of agent API responses
+type response struct {
+	Success bool                   `json:"success"`
+	Data    map[string]interface{} `json:"data"`
+	Error   string                 `json:"error"`
+	// raw is the response as received, for -json
+	raw []byte
+}
+
+// taskEvent is a progress notification from /api/tasks/events
+type taskEvent struct {
+	TaskID    string                 `json:"task_id"`
+	Type      string                 `json:"type"`
+	Data      map[string]interface{} `json:"data"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// client calls the agent API
+type client struct {
+	baseURL string
+	apiKey  string
+	user    string
+	http    *http.Client
+}
+
+func newClient(baseURL, apiKey, user string) *client {
+	// Tasks take as long as they take; the context bounds requests
+	return &client{baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey, user: user, http: &http.Client{}}
+}
+
+func (c *client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.user != "" {
+		req.Header.Set("X-Spilot-User", c.user)
+	}
+	return req, nil
+}
+
+// call sends body, if not nil, to path and decodes the response. A task
+// that failed is a response with Success false, not an error; errors are
+// for requests the server refused or could not handle.
+func (c *client) call(ctx context.Context, method, path string, body interface{}) (*response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := c.newRequest(ctx, method, path, reader)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &response{raw: raw}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		return result, fmt.Errorf("%s (%s)", result.Error, resp.Status)
+	}
+	return result, nil
+}
+
+// text fetches a plain-text resource such as a transcript
+func (c *client) text(ctx context.Context, path string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		var result response
+		if json.Unmarshal(raw, &result) == nil && result.Error != "" {
+			return "", fmt.Errorf("%s (%s)", result.Error, resp.Status)
+		}
+		return "", fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return string(raw), nil
+}
+
+// events subscribes to the task event stream. It returns once the server
+// accepted the subscription, so no event of a task started afterwards is
+// missed; the channel is closed when ctx ends or the stream breaks.
+func (c *client) events(ctx context.Context) (<-chan taskEvent, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/tasks/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("event stream: %s", resp.Status)
+	}
+
+	events := make(chan taskEvent, 64)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event taskEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// ofTask reports whether event belongs to the task with id or one of its
+// sub-tasks, which are numbered id.1, id.2 and so on
+func (e taskEvent) ofTask(id string) bool {
+	return e.TaskID == id || strings.HasPrefix(e.TaskID, id+".")
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// eventDrain is how long events are still read after a task's response
+// arrived, since the stream may deliver its last events a little later
+const eventDrain = 250 * time.Millisecond
+
+// resultKeys are the result fields printed as text, in this order; other
+// results are printed as JSON
+var resultKeys = []string{"message", "response", "answer", "explanation", "summary", "fix", "plan", "output"}
+
+// cli runs the subcommands against the agent API
+type cli struct {
+	client    *client
+	workspace string
+	model     string
+	json      bool
+}
+
+// run carries out a request through /api/process
+func (c *cli) run(ctx context.Context, args []string) int {
+	text := strings.Join(args, " ")
+	if text == "" {
+		fmt.Fprintln(os.Stderr, "usage: spilot run <request>")
+		return 2
+	}
+	return c.stream(ctx, "/api/process", request{Request: text, WorkspaceDir: c.workspace, Model: c.model})
+}
+
+// command runs one of the agent's slash commands with args, read from
+// stdin when there are none and fromStdin is set, so that output can be
+// piped in: go build ./... 2>&1 | spilot fix
+func (c *cli) command(ctx context.Context, command string, args []string, fromStdin bool) int {
+	text := strings.Join(args, " ")
+	if text == "" && fromStdin && !isTerminal(os.Stdin) {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spilot:", err)
+			return 1
+		}
+		text = string(input)
+	}
+	if strings.TrimSpace(text) == "" {
+		fmt.Fprintf(os.Stderr, "usage: spilot %s <arguments>\n", strings.TrimPrefix(command, "/"))
+		return 2
+	}
+	return c.stream(ctx, "/api/command", request{Command: command, Args: text, WorkspaceDir: c.workspace})
+}
+
+// stream sends a task request, printing the task's progress and command
+// output while it executes, then its result
+func (c *cli) stream(ctx context.Context, path string, req request) int {
+	req.TaskID = fmt.Sprintf("task_%d", time.Now().UnixNano())
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := c.client.events(streamCtx)
+	if err != nil {
+		// Progress is a nicety; the request works without it
+		fmt.Fprintln(os.Stderr, "spilot: no progress output:", err)
+	}
+	streamed := false
+	done := make(chan struct{})
+	if events == nil {
+		close(done)
+	} else {
+		go func() {
+			defer close(done)
+			for event := range events {
+				if event.ofTask(req.TaskID) {
+					streamed = c.printEvent(event, false) || streamed
+				}
+			}
+		}()
+	}
+
+	resp, err := c.client.call(ctx, http.MethodPost, path, req)
+	if events != nil {
+		time.Sleep(eventDrain)
+	}
+	cancel()
+	<-done
+	return c.printResult(resp, err, streamed)
+}
+
+// printEvent prints a task event on stderr, or command output where the
+// command wrote it. It reports whether the event was command output.
+func (c *cli) printEvent(event taskEvent, withTask bool) bool {
+	prefix := "==>"
+	if withTask {
+		prefix = fmt.Sprintf("[%s]", event.TaskID)
+	}
+	switch event.Type {
+	case "command_output":
+		chunk, _ := event.Data["chunk"].(string)
+		if event.Data["stream"] == "stderr" {
+			fmt.Fprint(os.Stderr, chunk)
+		} else {
+			fmt.Fprint(os.Stdout, chunk)
+		}
+		return true
+	case "task_started":
+		fmt.Fprintf(os.Stderr, "%s %v: %v\n", prefix, event.Data["agent"], event.Data["description"])
+	case "task_failed":
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", prefix, event.Data["error"])
+	case "task_completed":
+		if withTask {
+			fmt.Fprintf(os.Stderr, "%s completed\n", prefix)
+		}
+	case "approval_required":
+		fmt.Fprintf(os.Stderr, "%s approval required: %v\n", prefix, event.Data["approval_id"])
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s\n", prefix, strings.ReplaceAll(event.Type, "_", " "))
+	}
+	return false
+}
+
+// printResult prints a task's result and returns the exit code. Command
+// output already streamed is not repeated.
+func (c *cli) printResult(resp *response, err error, streamed bool) int {
+	if c.json && resp != nil {
+		os.Stdout.Write(resp.raw)
+		if err != nil || !resp.Success {
+			return 1
+		}
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "spilot:", err)
+		return 1
+	}
+
+	if text := resultText(resp.Data, streamed); text != "" {
+		fmt.Println(text)
+	}
+	if id, ok := resp.Data["approval_id"].(string); ok {
+		fmt.Fprintf(os.Stderr, "Approval %s is pending; approve it to execute the plan.\n", id)
+	}
+	if !resp.Success {
+		fmt.Fprintln(os.Stderr, "spilot:", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// resultText renders a result's text fields, or the whole result as JSON
+// if it has none. Output already streamed is left out.
+func resultText(data map[string]interface{}, streamed bool) string {
+	var parts []string
+	for _, key := range resultKeys {
+		if key == "output" && streamed {
+			continue
+		}
+		if text, ok := data[key].(string); ok && strings.TrimSpace(text) != "" {
+			parts = append(parts, strings.TrimRight(text, "\n"))
+		}
+	}
+	if len(parts) == 0 && len(data) > 0 && !streamed {
+		encoded, _ := json.MarshalIndent(data, "", "  ")
+		return string(encoded)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// chat sends one message, or chats interactively in a session until EOF
+// or /exit when no message is given
+func (c *cli) chat(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	session := flags.String("session", "", "continue the session with this ID")
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return c.say(ctx, strings.Join(flags.Args(), " "), *session)
+	}
+	if *session == "" {
+		resp, err := c.client.call(ctx, http.MethodPost, "/api/sessions", request{Title: "spilot chat", WorkspaceDir: c.workspace})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spilot:", err)
+			return 1
+		}
+		created, _ := resp.Data["session"].(map[string]interface{})
+		*session, _ = created["id"].(string)
+		fmt.Fprintf(os.Stderr, "Session %s; /exit to quit.\n", *session)
+	}
+
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		if !input.Scan() || ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr)
+			return 0
+		}
+		message := strings.TrimSpace(input.Text())
+		switch message {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return 0
+		}
+		c.say(ctx, message, *session)
+	}
+}
+
+// say sends a chat message and prints the reply
+func (c *cli) say(ctx context.Context, message, session string) int {
+	resp, err := c.client.call(ctx, http.MethodPost, "/api/chat", request{Message: message, SessionID: session})
+	return c.printResult(resp, err, false)
+}
+
+// tasks lists recent tasks, follows the events of all tasks, or prints the
+// transcript of one
+func (c *cli) tasks(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("tasks", flag.ExitOnError)
+	follow := flags.Bool("follow", false, "print the events of running tasks as they happen")
+	limit := flags.Int("limit", 20, "how many recent tasks to list")
+	flags.Parse(args)
+
+	switch {
+	case flags.NArg() > 0:
+		transcript, err := c.client.text(ctx, "/api/tasks/"+url.PathEscape(flags.Arg(0))+"/export")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spilot:", err)
+			return 1
+		}
+		fmt.Print(transcript)
+		return 0
+	case *follow:
+		events, err := c.client.events(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spilot:", err)
+			return 1
+		}
+		for event := range events {
+			c.printEvent(event, true)
+		}
+		return 0
+	}
+
+	query := url.Values{"type": {"task_created"}, "limit": {fmt.Sprint(*limit)}}
+	resp, err := c.client.call(ctx, http.MethodGet, "/api/events?"+query.Encode(), nil)
+	if c.json || err != nil {
+		return c.printResult(resp, err, false)
+	}
+	var listed struct {
+		Events []struct {
+			TaskID    string                 `json:"task_id"`
+			Data      map[string]interface{} `json:"data"`
+			Timestamp time.Time              `json:"timestamp"`
+		} `json:"events"`
+	}
+	if encoded, err := json.Marshal(resp.Data); err == nil {
+		json.Unmarshal(encoded, &listed)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STARTED\tTASK\tAGENT\tDESCRIPTION")
+	for _, event := range listed.Events {
+		fmt.Fprintf(table, "%s\t%s\t%v\t%v\n", event.Timestamp.Local().Format("2006-01-02 15:04:05"), event.TaskID, event.Data["agent"], event.Data["description"])
+	}
+	table.Flush()
+	return 0
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+	"spilot-agent/internal/redact"
+	"spilot-agent/internal/server"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// startLocal runs the agent in-process from the configuration, serving its
+// API on a loopback port so the client works the same as against a
+// server. It returns the API's URL and a function stopping the agent.
+func startLocal(profile string) (string, func(), error) {
+	if profile != "" {
+		config.SelectProfile(profile)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return "", nil, err
+	}
+	redactor, err := redact.New(cfg.RedactPatterns)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid redact_patterns: %w", err)
+	}
+	redactor.SetSecrets(cfg.SecretValues())
+
+	// Only problems are logged, so they do not drown the output
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	logConfig.DisableStacktrace = true
+	logger, err := logConfig.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redact.Core(core, redactor)
+	}))
+	if err != nil {
+		return "", nil, err
+	}
+
+	llmClient, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel, llm.TransportConfig(cfg.LLMTransport))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to initialize LLM client: %w", err)
+	}
+	agentSystem, err := agent.NewSystem(llmClient, cfg, redactor, logger)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to initialize agent system: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		agentSystem.Shutdown()
+		return "", nil, err
+	}
+	srv := server.New(agentSystem, server.Options{LogLevel: logConfig.Level}, logger)
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("In-process server stopped", zap.Error(err))
+		}
+	}()
+
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		agentSystem.Shutdown()
+		logger.Sync()
+	}
+	return "http://" + listener.Addr().String(), shutdown, nil
+}
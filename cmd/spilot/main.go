@@ -0,0 +1,97 @@
+// Command spilot is the command-line client of the Spilot agent. It talks
+// to a running server, or runs the agent in-process with -local.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: spilot [flags] <command> [arguments]
+
+Commands:
+  chat [-session id] [message]   chat with the agent; interactive without a message
+  run <request>                  carry out a request, e.g. "add a /health endpoint"
+  fix [error output]             fix an error; reads it from stdin if not given
+  explain <file or code>         explain a file or a piece of code
+  create-project <description>   scaffold a new project
+  tasks [-follow] [task id]      list recent tasks, follow their events or show one
+  tui                            chat in a terminal UI, approving each file change
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("spilot", flag.ExitOnError)
+	server := flags.String("server", envOr("SPILOT_SERVER", "http://localhost:8080"), "agent server URL (default $SPILOT_SERVER)")
+	apiKey := flags.String("api-key", os.Getenv("SPILOT_API_KEY"), "API key (default $SPILOT_API_KEY)")
+	user := flags.String("user", os.Getenv("SPILOT_USER"), "user the requests are made for (default $SPILOT_USER)")
+	workspace := flags.String("workspace", ".", "workspace directory")
+	model := flags.String("model", "", "model to use for run")
+	local := flags.Bool("local", false, "run the agent in-process instead of connecting to a server")
+	profile := flags.String("profile", "", "configuration profile for -local, e.g. dev")
+	jsonOutput := flags.Bool("json", false, "print raw JSON responses")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	baseURL := *server
+	if *local {
+		url, shutdown, err := startLocal(*profile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spilot:", err)
+			os.Exit(1)
+		}
+		defer shutdown()
+		baseURL = url
+	}
+	c := newClient(baseURL, *apiKey, *user)
+	cli := &cli{client: c, workspace: *workspace, model: *model, json: *jsonOutput}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	var code int
+	switch command {
+	case "chat":
+		code = cli.chat(ctx, args)
+	case "run":
+		code = cli.run(ctx, args)
+	case "fix":
+		code = cli.command(ctx, "/fix", args, true)
+	case "explain":
+		code = cli.command(ctx, "/explain", args, true)
+	case "create-project":
+		code = cli.command(ctx, "/create-project", args, false)
+	case "tasks":
+		code = cli.tasks(ctx, args)
+	case "tui":
+		code = cli.tui(ctx)
+	default:
+		fmt.Fprintf(os.Stderr, "spilot: unknown command %q\n\n", command)
+		flags.Usage()
+		code = 2
+	}
+	stop()
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	titleStyle   = lipgloss.NewStyle().Bold(true)
+	userStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("12")).Bold(true)
+	noteStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	hunkStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("14"))
+	promptStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Bold(true)
+)
+
+// stepStatus is the progress of one step of a plan
+type stepStatus int
+
+const (
+	stepPending stepStatus = iota
+	stepRunning
+	stepDone
+	stepFailed
+)
+
+var stepMarkers = map[stepStatus]string{stepPending: "[ ]", stepRunning: "[>]", stepDone: "[x]", stepFailed: "[!]"}
+
+// planStep is a task of the plan being executed
+type planStep struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	status      stepStatus
+}
+
+// decision is a change waiting for the user's y or n: a file write shown
+// as a diff, or the execution of a plan
+type decision struct {
+	approvalID string
+	title      string
+	body       string
+	// plan is set when the decision is about executing it
+	plan string
+}
+
+type (
+	sessionMsg struct {
+		id  string
+		err error
+	}
+	eventMsg       taskEvent
+	eventsEndedMsg struct{}
+	tickMsg        struct{}
+	resultMsg      struct {
+		resp *response
+		err  error
+	}
+	decidedMsg struct {
+		decision decision
+		approved bool
+		resp     *response
+		err      error
+	}
+)
+
+// tuiModel is the state of the terminal UI
+type tuiModel struct {
+	ctx    context.Context
+	cli    *cli
+	events <-chan taskEvent
+
+	session  string
+	input    textinput.Model
+	chat     viewport.Model
+	lines    []string
+	steps    []planStep
+	taskID   string
+	started  time.Time
+	pending  []decision
+	width    int
+	height   int
+	quitting bool
+}
+
+// tui chats with the agent in a terminal UI. Requests run with write
+// confirmation, so every file change is shown as a diff to approve first.
+func (c *cli) tui(ctx context.Context) int {
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "spilot: tui needs a terminal")
+		return 2
+	}
+	events, err := c.client.events(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "spilot:", err)
+		return 1
+	}
+
+	input := textinput.New()
+	input.Placeholder = "Ask for a change, or /fix, /explain, /test ... (/exit to quit)"
+	input.Prompt = "> "
+	input.Focus()
+	model := &tuiModel{ctx: ctx, cli: c, events: events, input: input, chat: viewport.New(80, 20)}
+
+	program := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithContext(ctx))
+	if _, err := program.Run(); err != nil && ctx.Err() == nil {
+		fmt.Fprintln(os.Stderr, "spilot:", err)
+		return 1
+	}
+	return 0
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(textinput.Blink, m.createSession(), m.nextEvent())
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.input.Width = msg.Width - 4
+		m.layout()
+		return m, nil
+
+	case tea.KeyMsg:
+		return m.key(msg)
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.chat, cmd = m.chat.Update(msg)
+		return m, cmd
+
+	case sessionMsg:
+		if msg.err != nil {
+			m.note(errorStyle, "No session, requests will not remember each other: "+msg.err.Error())
+		} else {
+			m.session = msg.id
+		}
+		return m, nil
+
+	case eventMsg:
+		m.event(taskEvent(msg))
+		return m, m.nextEvent()
+
+	case eventsEndedMsg:
+		if m.ctx.Err() == nil {
+			m.note(errorStyle, "Lost the connection to the event stream; progress is no longer shown.")
+		}
+		return m, nil
+
+	case tickMsg:
+		// Keeps the elapsed time of the running request current
+		if m.taskID == "" {
+			return m, nil
+		}
+		m.layout()
+		return m, tick()
+
+	case resultMsg:
+		return m, m.result(msg)
+
+	case decidedMsg:
+		return m, m.decided(msg)
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// key handles a key press: y or n while a decision is pending, scrolling,
+// and otherwise typing and sending requests
+func (m *tuiModel) key(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.quitting = true
+		return m, tea.Quit
+	case "pgup":
+		m.chat.HalfViewUp()
+		return m, nil
+	case "pgdown":
+		m.chat.HalfViewDown()
+		return m, nil
+	}
+
+	if len(m.pending) > 0 {
+		switch strings.ToLower(msg.String()) {
+		case "y":
+			return m, m.decide(m.pending[0], true)
+		case "n":
+			return m, m.decide(m.pending[0], false)
+		}
+		return m, nil
+	}
+
+	if msg.Type != tea.KeyEnter {
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+	text := strings.TrimSpace(m.input.Value())
+	if text == "" {
+		return m, nil
+	}
+	if text == "/exit" || text == "/quit" {
+		m.quitting = true
+		return m, tea.Quit
+	}
+	if m.taskID != "" {
+		m.note(noteStyle, "Still working on the last request.")
+		return m, nil
+	}
+	m.input.Reset()
+	m.say(userStyle.Render("you: ") + text)
+
+	req := request{WorkspaceDir: m.cli.workspace, Model: m.cli.model, SessionID: m.session, ConfirmWrites: true}
+	path := "/api/process"
+	if strings.HasPrefix(text, "/") {
+		command, args, _ := strings.Cut(text, " ")
+		req.Command, req.Args, path = command, args, "/api/command"
+	} else {
+		req.Request = text
+	}
+	m.steps = nil
+	return m, m.send(path, req)
+}
+
+// send starts a task request; its progress arrives as events
+func (m *tuiModel) send(path string, req request) tea.Cmd {
+	req.TaskID = fmt.Sprintf("task_%d", time.Now().UnixNano())
+	m.taskID, m.started = req.TaskID, time.Now()
+	m.layout()
+	return tea.Batch(tick(), func() tea.Msg {
+		resp, err := m.cli.client.call(m.ctx, http.MethodPost, path, req)
+		return resultMsg{resp: resp, err: err}
+	})
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return tickMsg{} })
+}
+
+// event shows the progress of the running request
+func (m *tuiModel) event(event taskEvent) {
+	if m.taskID == "" || !event.ofTask(m.taskID) {
+		return
+	}
+	step := m.stepOf(event.TaskID)
+	switch event.Type {
+	case "task_started":
+		if step != nil {
+			step.status = stepRunning
+		} else {
+			m.note(noteStyle, fmt.Sprintf("%v: %v", event.Data["agent"], event.Data["description"]))
+		}
+	case "task_completed":
+		if step != nil {
+			step.status = stepDone
+		}
+	case "task_failed":
+		if step != nil {
+			step.status = stepFailed
+		}
+		m.note(errorStyle, fmt.Sprintf("failed: %v", event.Data["error"]))
+	case "command_output":
+		chunk, _ := event.Data["chunk"].(string)
+		m.note(noteStyle, strings.TrimRight(chunk, "\n"))
+	case "approval_required":
+		if event.Data["kind"] == "file_write" {
+			id, _ := event.Data["approval_id"].(string)
+			diff, _ := event.Data["diff"].(string)
+			if diff == "" {
+				diff = "(no preview available)"
+			}
+			m.pending = append(m.pending, decision{approvalID: id, title: fmt.Sprintf("Write %v?", event.Data["path"]), body: colorDiff(diff)})
+		}
+	default:
+		m.note(noteStyle, strings.ReplaceAll(event.Type, "_", " "))
+	}
+	m.layout()
+}
+
+// stepOf returns the plan step executed as the task with id, which plans
+// number id.1, id.2 and so on after the request executing them
+func (m *tuiModel) stepOf(id string) *planStep {
+	suffix := strings.TrimPrefix(id, m.taskID+".")
+	if suffix == id || strings.Contains(suffix, ".") {
+		return nil
+	}
+	var n int
+	if _, err := fmt.Sscan(suffix, &n); err != nil || n < 1 || n > len(m.steps) {
+		return nil
+	}
+	return &m.steps[n-1]
+}
+
+// result shows a finished request, and asks to execute the plan it
+// produced, if any
+func (m *tuiModel) result(msg resultMsg) tea.Cmd {
+	m.taskID = ""
+	defer m.layout()
+	if msg.err != nil {
+		m.note(errorStyle, msg.err.Error())
+		return nil
+	}
+	if results, ok := msg.resp.Data["results"].([]interface{}); ok {
+		succeeded := 0
+		for _, result := range results {
+			if r, ok := result.(map[string]interface{}); ok && r["success"] == true {
+				succeeded++
+			}
+		}
+		m.say(fmt.Sprintf("spilot: plan finished, %d of %d steps succeeded.", succeeded, len(m.steps)))
+		return nil
+	}
+	if text := resultText(msg.resp.Data, false); text != "" {
+		m.say("spilot: " + text)
+	}
+	if !msg.resp.Success {
+		m.note(errorStyle, msg.resp.Error)
+	}
+
+	plan, _ := msg.resp.Data["plan"].(string)
+	id, _ := msg.resp.Data["approval_id"].(string)
+	if plan != "" && id != "" {
+		m.steps = parsePlan(plan)
+		var body strings.Builder
+		for i, step := range m.steps {
+			fmt.Fprintf(&body, "%d. %s (%s)\n", i+1, step.Description, step.Type)
+		}
+		m.pending = append(m.pending, decision{approvalID: id, title: "Execute this plan?", body: body.String(), plan: plan})
+	}
+	return nil
+}
+
+// decide approves or rejects the first pending decision
+func (m *tuiModel) decide(d decision, approve bool) tea.Cmd {
+	m.pending = m.pending[1:]
+	m.layout()
+	action := "reject"
+	if approve {
+		action = "approve"
+	}
+	return func() tea.Msg {
+		resp, err := m.cli.client.call(m.ctx, http.MethodPost, "/api/approvals/"+url.PathEscape(d.approvalID)+"/"+action, nil)
+		return decidedMsg{decision: d, approved: approve, resp: resp, err: err}
+	}
+}
+
+// decided notes a decision and executes an approved plan
+func (m *tuiModel) decided(msg decidedMsg) tea.Cmd {
+	defer m.layout()
+	if msg.err != nil {
+		m.note(errorStyle, msg.err.Error())
+		return nil
+	}
+	verdict := "Rejected"
+	if msg.approved {
+		verdict = "Approved"
+	}
+	m.note(noteStyle, verdict+": "+strings.TrimSuffix(msg.decision.title, "?"))
+	if msg.decision.plan == "" || !msg.approved {
+		if msg.decision.plan != "" {
+			m.steps = nil
+		}
+		return nil
+	}
+	token, _ := msg.resp.Data["execution_token"].(string)
+	return m.send("/api/plans/execute", request{Plan: msg.decision.plan, Token: token, WorkspaceDir: m.cli.workspace, ConfirmWrites: true})
+}
+
+func (m *tuiModel) createSession() tea.Cmd {
+	return func() tea.Msg {
+		resp, err := m.cli.client.call(m.ctx, http.MethodPost, "/api/sessions", request{Title: "spilot tui", WorkspaceDir: m.cli.workspace})
+		if err != nil {
+			return sessionMsg{err: err}
+		}
+		created, _ := resp.Data["session"].(map[string]interface{})
+		id, _ := created["id"].(string)
+		return sessionMsg{id: id}
+	}
+}
+
+func (m *tuiModel) nextEvent() tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-m.events
+		if !ok {
+			return eventsEndedMsg{}
+		}
+		return eventMsg(event)
+	}
+}
+
+// say adds a message to the chat pane
+func (m *tuiModel) say(text string) {
+	m.lines = append(m.lines, text)
+	m.layout()
+}
+
+// note adds a progress or error line to the chat pane
+func (m *tuiModel) note(style lipgloss.Style, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	m.lines = append(m.lines, style.Render(text))
+	m.layout()
+}
+
+// layout sizes the chat pane around the progress pane and input, and
+// shows the pending decision in it if there is one
+func (m *tuiModel) layout() {
+	if m.width == 0 {
+		return
+	}
+	m.chat.Width = m.width
+	m.chat.Height = max(m.height-lipgloss.Height(m.footer())-1, 3)
+
+	wrap := lipgloss.NewStyle().Width(m.width)
+	if len(m.pending) > 0 {
+		m.chat.SetContent(wrap.Render(m.pending[0].body))
+		m.chat.GotoTop()
+		return
+	}
+	m.chat.SetContent(wrap.Render(strings.Join(m.lines, "\n")))
+	m.chat.GotoBottom()
+}
+
+// footer renders the plan progress and the input or decision prompt
+func (m *tuiModel) footer() string {
+	var footer strings.Builder
+	if len(m.steps) > 0 && (m.taskID != "" || len(m.pending) == 0) {
+		for i, step := range m.steps {
+			fmt.Fprintf(&footer, "%s %d. %s\n", stepMarkers[step.status], i+1, step.Description)
+		}
+	}
+	if m.taskID != "" {
+		footer.WriteString(noteStyle.Render(fmt.Sprintf("working... %s", time.Since(m.started).Round(time.Second))) + "\n")
+	}
+	if len(m.pending) > 0 {
+		footer.WriteString(promptStyle.Render(m.pending[0].title + " [y/n]"))
+	} else {
+		footer.WriteString(m.input.View())
+	}
+	return footer.String()
+}
+
+func (m *tuiModel) View() string {
+	if m.quitting {
+		return ""
+	}
+	header := titleStyle.Render("spilot") + noteStyle.Render(" "+m.cli.workspace)
+	return header + "\n" + m.chat.View() + "\n" + m.footer()
+}
+
+// parsePlan reads the steps of a generated plan, a JSON array of tasks
+// that may be surrounded by prose
+func parsePlan(plan string) []planStep {
+	start, end := strings.Index(plan, "["), strings.LastIndex(plan, "]")
+	if start < 0 || end < start {
+		return nil
+	}
+	var steps []planStep
+	json.Unmarshal([]byte(plan[start:end+1]), &steps)
+	return steps
+}
+
+// colorDiff colors the added, removed and hunk lines of a unified diff
+func colorDiff(diff string) string {
+	lines := strings.Split(strings.TrimRight(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = titleStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = addedStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = removedStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = hunkStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
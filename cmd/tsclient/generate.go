@@ -0,0 +1,491 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// identifier matches the names usable as TypeScript properties unquoted
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// pathParam matches the parameters of a path template
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// generate returns the client of the API s describes
+func generate(s *spec) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by cmd/tsclient from the OpenAPI spec of the %s %s. DO NOT EDIT.\n", s.Info.Title, s.Info.Version)
+	b.WriteString("// Change internal/server/openapi.yaml and run `go generate ./internal/server` instead.\n\n")
+	fmt.Fprintf(&b, "/** The version of the API this client was generated for */\nexport const API_VERSION = '%s';\n\n", s.Info.Version)
+
+	for _, e := range s.Components.Schemas {
+		if err := writeSchema(&b, e.Name, e.Value); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", e.Name, err)
+		}
+	}
+
+	b.WriteString(runtime)
+	b.WriteString("\n/** SpilotClient calls the agent API */\nexport class SpilotClient extends BaseClient {\n")
+	for _, path := range s.Paths {
+		for _, method := range path.Value {
+			if err := writeOperation(&b, path.Name, strings.ToUpper(method.Name), method.Value); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method.Name), path.Name, err)
+			}
+		}
+	}
+	return []byte(strings.TrimSuffix(b.String(), "\n") + "}\n"), nil
+}
+
+// writeSchema declares a named schema as an interface when it is a plain
+// object, as a type otherwise
+func writeSchema(b *strings.Builder, name string, s *schema) error {
+	writeDoc(b, "", s.Description)
+	if s.Type == "object" && len(s.Properties) > 0 {
+		body, err := objectType(s, "")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "export interface %s %s\n\n", name, body)
+		return nil
+	}
+	t, err := tsType(s, "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "export type %s = %s;\n\n", name, t)
+	if s.Discriminator != nil {
+		fmt.Fprintf(b, "export type %sType = %s['%s'];\n\n", name, name, s.Discriminator.PropertyName)
+	}
+	return nil
+}
+
+// tsType returns the TypeScript type of s, indented by indent where it
+// spans lines
+func tsType(s *schema, indent string) (string, error) {
+	if s == nil {
+		return "unknown", nil
+	}
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case len(s.OneOf) > 0:
+		return combine(s.OneOf, " | ", indent)
+	case len(s.AllOf) > 0:
+		return combine(s.AllOf, " & ", indent)
+	case len(s.Enum) > 0:
+		values := make([]string, len(s.Enum))
+		for i, value := range s.Enum {
+			values[i] = quote(value)
+		}
+		return strings.Join(values, " | "), nil
+	}
+	switch s.Type {
+	case "string":
+		return "string", nil
+	case "integer", "number":
+		return "number", nil
+	case "boolean":
+		return "boolean", nil
+	case "array":
+		item, err := tsType(s.Items, indent)
+		if err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(item, "|&") {
+			item = "(" + item + ")"
+		}
+		return item + "[]", nil
+	case "object", "":
+		if len(s.Properties) > 0 {
+			return objectType(s, indent)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			value, err := tsType(s.AdditionalProperties.Schema, indent)
+			if err != nil {
+				return "", err
+			}
+			return "Record<string, " + value + ">", nil
+		}
+		return "Record<string, unknown>", nil
+	}
+	return "", fmt.Errorf("unsupported type %q", s.Type)
+}
+
+func combine(schemas []*schema, separator, indent string) (string, error) {
+	types := make([]string, len(schemas))
+	for i, s := range schemas {
+		t, err := tsType(s, indent)
+		if err != nil {
+			return "", err
+		}
+		types[i] = t
+	}
+	return strings.Join(types, separator), nil
+}
+
+// objectType returns the object literal type of s
+func objectType(s *schema, indent string) (string, error) {
+	var b strings.Builder
+	b.WriteString("{\n")
+	inner := indent + "  "
+	for _, p := range s.Properties {
+		t, err := tsType(p.Value, inner)
+		if err != nil {
+			return "", fmt.Errorf("property %s: %w", p.Name, err)
+		}
+		writeDoc(&b, inner, p.Value.Description)
+		optional := "?"
+		if s.required(p.Name) {
+			optional = ""
+		}
+		fmt.Fprintf(&b, "%s%s%s: %s;\n", inner, propertyName(p.Name), optional, t)
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Any {
+		fmt.Fprintf(&b, "%s[key: string]: unknown;\n", inner)
+	}
+	b.WriteString(indent + "}")
+	return b.String(), nil
+}
+
+// writeOperation writes the client method calling an operation
+func writeOperation(b *strings.Builder, path, method string, op *operation) error {
+	if op.OperationID == "" {
+		return fmt.Errorf("operationId is required")
+	}
+	var args, query []string
+	for _, p := range op.Parameters {
+		t, err := tsType(p.Schema, "    ")
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		switch p.In {
+		case "path":
+			args = append(args, fmt.Sprintf("%s: %s", p.Name, t))
+		case "query":
+			query = append(query, fmt.Sprintf("%s?: %s", propertyName(p.Name), t))
+		default:
+			return fmt.Errorf("parameter %s: %s parameters are not supported", p.Name, p.In)
+		}
+	}
+
+	body := "undefined"
+	if op.RequestBody != nil {
+		c := op.RequestBody.Content["application/json"]
+		if c == nil {
+			return fmt.Errorf("only JSON request bodies are supported")
+		}
+		t, err := tsType(c.Schema, "  ")
+		if err != nil {
+			return err
+		}
+		optional := "?"
+		if op.RequestBody.Required {
+			optional = ""
+		}
+		args = append(args, fmt.Sprintf("body%s: %s", optional, t))
+		body = "body"
+	}
+	queryArg := "undefined"
+	if len(query) > 0 {
+		args = append(args, fmt.Sprintf("query?: { %s }", strings.Join(query, "; ")))
+		queryArg = "query"
+	}
+	args = append(args, "options?: RequestOptions")
+
+	result, kind, err := responseType(op)
+	if err != nil {
+		return err
+	}
+	url := quote(path)
+	if pathParam.MatchString(path) {
+		url = "`" + pathParam.ReplaceAllString(path, "$${encodeURIComponent($1)}") + "`"
+	}
+
+	writeDoc(b, "  ", op.Summary)
+	switch kind {
+	case eventsResponse:
+		if method != "GET" {
+			return fmt.Errorf("event streams must be GET")
+		}
+		fmt.Fprintf(b, "  %s(%s): AsyncGenerator<%s> {\n", op.OperationID, strings.Join(args, ", "), result)
+		fmt.Fprintf(b, "    return this.stream<%s>(%s, %s, options);\n  }\n\n", result, url, queryArg)
+		return nil
+	case textResponse:
+		if method != "GET" {
+			return fmt.Errorf("text responses must be GET")
+		}
+		fmt.Fprintf(b, "  %s(%s): Promise<string> {\n", op.OperationID, strings.Join(args, ", "))
+		fmt.Fprintf(b, "    return this.text(%s, %s, options);\n  }\n\n", url, queryArg)
+		return nil
+	}
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(args, ", "), result)
+	fmt.Fprintf(b, "    return this.request<%s>('%s', %s, %s, %s, options);\n  }\n\n", result, method, url, body, queryArg)
+	return nil
+}
+
+// Kinds of successful responses
+const (
+	jsonResponse = iota
+	// eventsResponse is a stream of server-sent events
+	eventsResponse
+	// textResponse is plain text
+	textResponse
+)
+
+// responseType returns the type of an operation's successful response and
+// its kind
+func responseType(op *operation) (string, int, error) {
+	var codes []string
+	for _, r := range op.Responses {
+		if strings.HasPrefix(r.Name, "2") {
+			codes = append(codes, r.Name)
+		}
+	}
+	sort.Strings(codes)
+	if len(codes) == 0 {
+		return "", jsonResponse, fmt.Errorf("no successful response")
+	}
+	for _, r := range op.Responses {
+		if r.Name != codes[0] {
+			continue
+		}
+		if c := r.Value.Content["text/event-stream"]; c != nil {
+			t, err := tsType(c.Schema, "  ")
+			return t, eventsResponse, err
+		}
+		if c := r.Value.Content["application/json"]; c != nil {
+			t, err := tsType(c.Schema, "  ")
+			return t, jsonResponse, err
+		}
+		if r.Value.Content["text/plain"] != nil {
+			return "string", textResponse, nil
+		}
+	}
+	return "void", jsonResponse, nil
+}
+
+func writeDoc(b *strings.Builder, indent, doc string) {
+	doc = strings.TrimSpace(doc)
+	if doc == "" {
+		return
+	}
+	lines := strings.Split(doc, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, doc)
+		return
+	}
+	fmt.Fprintf(b, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(b, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s */\n", indent)
+}
+
+func propertyName(name string) string {
+	if identifier.MatchString(name) {
+		return name
+	}
+	return quote(name)
+}
+
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// runtime is the hand-written part of the client the generated methods
+// call
+const runtime = `/** The fetch implementations the client works with: the global fetch or node-fetch */
+export type FetchLike = (url: string, init: {
+  method: string;
+  headers: Record<string, string>;
+  body?: string;
+  signal?: AbortSignal;
+}) => Promise<FetchResponse>;
+
+export interface FetchResponse {
+  ok: boolean;
+  status: number;
+  statusText: string;
+  headers: { get(name: string): string | null };
+  text(): Promise<string>;
+  body: unknown;
+}
+
+export interface ClientOptions {
+  /** The server's URL, such as http://localhost:8080 */
+  baseUrl: string;
+  /** Sent as X-API-Key when the server requires API keys */
+  apiKey?: string;
+  /** Sent as X-Spilot-User: the user requests are made for */
+  user?: string;
+  /** Defaults to the global fetch */
+  fetch?: FetchLike;
+  /**
+   * Called once when the server implements another major version of the
+   * API than the client; by default a warning is logged
+   */
+  onVersionMismatch?: (serverVersion: string, clientVersion: string) => void;
+}
+
+export interface RequestOptions {
+  signal?: AbortSignal;
+}
+
+/** SpilotApiError is thrown for responses with an error status */
+export class SpilotApiError extends Error {
+  constructor(
+    readonly status: number,
+    /** The response's envelope, whose data some errors carry, such as a paused plan's results */
+    readonly response: Response | undefined,
+    message: string,
+  ) {
+    super(message);
+    this.name = 'SpilotApiError';
+  }
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+class BaseClient {
+  private readonly fetch: FetchLike;
+  private versionChecked = false;
+
+  constructor(private readonly options: ClientOptions) {
+    const fetchImpl = options.fetch ?? (globalThis.fetch as unknown as FetchLike | undefined);
+    if (!fetchImpl) {
+      throw new Error('SpilotClient needs a fetch implementation');
+    }
+    this.fetch = fetchImpl;
+  }
+
+  protected async request<T>(method: string, path: string, body: unknown, query: Query | undefined, options?: RequestOptions): Promise<T> {
+    const res = await this.send(method, path, body, query, options);
+    const text = await res.text();
+    let payload: unknown;
+    if (text) {
+      try {
+        payload = JSON.parse(text);
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+    }
+    if (!res.ok) {
+      const envelope = payload as Response | undefined;
+      throw new SpilotApiError(res.status, envelope, envelope?.error || ` + "`${res.status} ${res.statusText}`" + `);
+    }
+    return payload as T;
+  }
+
+  protected async text(path: string, query: Query | undefined, options?: RequestOptions): Promise<string> {
+    const res = await this.send('GET', path, undefined, query, options);
+    const text = await res.text();
+    if (!res.ok) {
+      let envelope: Response | undefined;
+      try {
+        envelope = JSON.parse(text) as Response;
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+      throw new SpilotApiError(res.status, envelope, envelope?.error || ` + "`${res.status} ${res.statusText}`" + `);
+    }
+    return text;
+  }
+
+  protected async *stream<T>(path: string, query: Query | undefined, options?: RequestOptions): AsyncGenerator<T> {
+    const res = await this.send('GET', path, undefined, query, options);
+    if (!res.ok) {
+      const text = await res.text();
+      let envelope: Response | undefined;
+      try {
+        envelope = JSON.parse(text) as Response;
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+      throw new SpilotApiError(res.status, envelope, envelope?.error || ` + "`${res.status} ${res.statusText}`" + `);
+    }
+    let buffer = '';
+    for await (const chunk of textChunks(res.body)) {
+      buffer += chunk.replace(/\r\n/g, '\n');
+      let end: number;
+      while ((end = buffer.indexOf('\n\n')) >= 0) {
+        const data = buffer.slice(0, end).split('\n')
+          .filter((line) => line.startsWith('data:'))
+          .map((line) => line.slice(5).trimStart())
+          .join('\n');
+        buffer = buffer.slice(end + 2);
+        if (data) {
+          yield JSON.parse(data) as T;
+        }
+      }
+    }
+  }
+
+  private async send(method: string, path: string, body: unknown, query: Query | undefined, options?: RequestOptions): Promise<FetchResponse> {
+    let url = this.options.baseUrl.replace(/\/+$/, '') + path;
+    const params = Object.entries(query ?? {})
+      .filter((entry): entry is [string, string | number | boolean] => entry[1] !== undefined)
+      .map(([name, value]) => ` + "`${encodeURIComponent(name)}=${encodeURIComponent(String(value))}`" + `);
+    if (params.length > 0) {
+      url += '?' + params.join('&');
+    }
+    const headers: Record<string, string> = { Accept: 'application/json' };
+    if (body !== undefined) {
+      headers['Content-Type'] = 'application/json';
+    }
+    if (this.options.apiKey) {
+      headers['X-API-Key'] = this.options.apiKey;
+    }
+    if (this.options.user) {
+      headers['X-Spilot-User'] = this.options.user;
+    }
+    const res = await this.fetch(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      signal: options?.signal,
+    });
+    this.checkVersion(res.headers.get('X-Spilot-API-Version'));
+    return res;
+  }
+
+  private checkVersion(serverVersion: string | null) {
+    if (this.versionChecked || !serverVersion) {
+      return;
+    }
+    this.versionChecked = true;
+    if (serverVersion.split('.')[0] === API_VERSION.split('.')[0]) {
+      return;
+    }
+    if (this.options.onVersionMismatch) {
+      this.options.onVersionMismatch(serverVersion, API_VERSION);
+    } else {
+      console.warn(` + "`Spilot server implements API ${serverVersion}, this client ${API_VERSION}`" + `);
+    }
+  }
+}
+
+/** textChunks decodes a web or Node.js response body as it arrives */
+async function* textChunks(body: unknown): AsyncGenerator<string> {
+  const decoder = new TextDecoder();
+  const web = body as { getReader?: () => ReadableStreamDefaultReader<Uint8Array> };
+  if (typeof web?.getReader === 'function') {
+    const reader = web.getReader();
+    try {
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) {
+          return;
+        }
+        yield decoder.decode(value, { stream: true });
+      }
+    } finally {
+      reader.releaseLock();
+    }
+  }
+  for await (const chunk of body as AsyncIterable<Uint8Array | string>) {
+    yield typeof chunk === 'string' ? chunk : decoder.decode(chunk, { stream: true });
+  }
+}
+`
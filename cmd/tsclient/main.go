@@ -0,0 +1,33 @@
+// Command tsclient generates the TypeScript client of the agent API, used
+// by the editor extension, from the server's OpenAPI spec:
+//
+//	go generate ./internal/server
+//
+// It understands the subset of OpenAPI the spec uses: local $refs, objects,
+// arrays, string enums, allOf, oneOf, path and query parameters, JSON
+// bodies and server-sent event streams.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	specPath := flag.String("spec", "openapi.yaml", "OpenAPI spec to read")
+	out := flag.String("out", "client.ts", "TypeScript file to write")
+	flag.Parse()
+
+	s, err := readSpec(*specPath)
+	if err == nil {
+		var code []byte
+		if code, err = generate(s); err == nil {
+			err = os.WriteFile(*out, code, 0644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tsclient:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// spec is the part of an OpenAPI 3 document the generator understands
+type spec struct {
+	Info struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Paths      named[named[*operation]] `yaml:"paths"`
+	Components struct {
+		Schemas named[*schema] `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type operation struct {
+	OperationID string       `yaml:"operationId"`
+	Summary     string       `yaml:"summary"`
+	Parameters  []*parameter `yaml:"parameters"`
+	RequestBody *struct {
+		Required bool                `yaml:"required"`
+		Content  map[string]*content `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses named[*response] `yaml:"responses"`
+}
+
+type parameter struct {
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Required    bool    `yaml:"required"`
+	Description string  `yaml:"description"`
+	Schema      *schema `yaml:"schema"`
+}
+
+type response struct {
+	Description string              `yaml:"description"`
+	Content     map[string]*content `yaml:"content"`
+}
+
+type content struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type schema struct {
+	Ref                  string         `yaml:"$ref"`
+	Description          string         `yaml:"description"`
+	Type                 string         `yaml:"type"`
+	Format               string         `yaml:"format"`
+	Enum                 []string       `yaml:"enum"`
+	Required             []string       `yaml:"required"`
+	Properties           named[*schema] `yaml:"properties"`
+	AdditionalProperties *additional    `yaml:"additionalProperties"`
+	Items                *schema        `yaml:"items"`
+	AllOf                []*schema      `yaml:"allOf"`
+	OneOf                []*schema      `yaml:"oneOf"`
+	Discriminator        *discriminator `yaml:"discriminator"`
+}
+
+type discriminator struct {
+	PropertyName string `yaml:"propertyName"`
+}
+
+// additional is additionalProperties: true, or the schema of the values
+type additional struct {
+	Any    bool
+	Schema *schema
+}
+
+func (a *additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Tag == "!!bool" {
+		return node.Decode(&a.Any)
+	}
+	return node.Decode(&a.Schema)
+}
+
+// named is a YAML mapping that keeps the order of its keys, so the
+// generated code follows the spec
+type named[T any] []entry[T]
+
+type entry[T any] struct {
+	Name  string
+	Value T
+}
+
+func (n *named[T]) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var value T
+		if err := node.Content[i+1].Decode(&value); err != nil {
+			return err
+		}
+		*n = append(*n, entry[T]{Name: node.Content[i].Value, Value: value})
+	}
+	return nil
+}
+
+func (s *schema) required(property string) bool {
+	for _, name := range s.Required {
+		if name == property {
+			return true
+		}
+	}
+	return false
+}
+
+// refName returns the schema name a local $ref points to
+func refName(ref string) (string, error) {
+	name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+	if !ok {
+		return "", fmt.Errorf("unsupported $ref %q: only #/components/schemas/ references are", ref)
+	}
+	return name, nil
+}
+
+func readSpec(path string) (*spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Info.Version == "" {
+		return nil, fmt.Errorf("%s: info.version is required", path)
+	}
+	return &s, nil
+}
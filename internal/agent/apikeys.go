@@ -0,0 +1,413 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// APIKeyScope is something an API key lets its holder do
+type APIKeyScope string
+
+const (
+	// ScopeChat allows chat and tasks that neither write files nor run
+	// commands
+	ScopeChat APIKeyScope = "chat"
+	// ScopeFiles allows writing files in the workspace and changing its git
+	// repository
+	ScopeFiles APIKeyScope = "files"
+	// ScopeTerminal allows running commands, processes and terminals
+	ScopeTerminal APIKeyScope = "terminal"
+	// ScopeAdmin allows the admin API and implies every other scope
+	ScopeAdmin APIKeyScope = "admin"
+)
+
+// apiKeyPrefix starts every API key so leaked keys are easy to spot
+const apiKeyPrefix = "spk_"
+
+// lastUsedInterval is how often a key's last use is saved, so authenticated
+// requests do not all write the key file
+const lastUsedInterval = time.Minute
+
+var (
+	// ErrInvalidAPIKey is returned for unknown or expired API keys
+	ErrInvalidAPIKey = errors.New("invalid or expired API key")
+	// ErrScopeDenied is returned when the caller's API key lacks the scope
+	// an action needs
+	ErrScopeDenied = errors.New("API key lacks the scope")
+)
+
+// ParseAPIKeyScope parses a scope name
+func ParseAPIKeyScope(name string) (APIKeyScope, error) {
+	switch scope := APIKeyScope(strings.ToLower(name)); scope {
+	case ScopeChat, ScopeFiles, ScopeTerminal, ScopeAdmin:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown API key scope %q; use chat, files, terminal or admin", name)
+	}
+}
+
+// APIKey describes an API key. Only a hash of the key itself is stored;
+// the key is shown once, when it is created.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Owner is the requester the key acts as; empty means the key's ID
+	Owner  string        `json:"owner,omitempty"`
+	Scopes []APIKeyScope `json:"scopes"`
+	// Hint is the start of the key, to tell keys apart
+	Hint       string     `json:"hint"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Requester returns the requester the key acts as
+func (k *APIKey) Requester() string {
+	if k.Owner != "" {
+		return k.Owner
+	}
+	return "key:" + k.ID
+}
+
+// Allows reports whether the key grants scope
+func (k *APIKey) Allows(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether the key has expired at now
+func (k *APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// public returns a copy of the key without its hash
+func (k *APIKey) public() *APIKey {
+	c := *k
+	c.Hash = ""
+	c.Scopes = append([]APIKeyScope(nil), k.Scopes...)
+	return &c
+}
+
+// APIKeyStore keeps API keys in DataDir/apikeys.json
+type APIKeyStore struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*APIKey
+	byHash map[string]*APIKey
+	// saved is when each key's last use was last written
+	saved  map[string]time.Time
+	sealer *Sealer
+	logger *zap.Logger
+}
+
+// NewAPIKeyStore loads the API keys kept in dataDir. An empty dataDir keeps
+// keys in memory only.
+func NewAPIKeyStore(dataDir string, sealer *Sealer, logger *zap.Logger) (*APIKeyStore, error) {
+	store := &APIKeyStore{
+		keys:   make(map[string]*APIKey),
+		byHash: make(map[string]*APIKey),
+		saved:  make(map[string]time.Time),
+		sealer: sealer,
+		logger: logger,
+	}
+	if dataDir == "" {
+		return store, nil
+	}
+	store.path = filepath.Join(dataDir, "apikeys.json")
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err == nil {
+		data, err = sealer.Open(data)
+	}
+	var keys []*APIKey
+	if err == nil {
+		err = json.Unmarshal(data, &keys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+	for _, key := range keys {
+		store.keys[key.ID] = key
+		store.byHash[key.Hash] = key
+	}
+	return store, nil
+}
+
+// Create makes a key with scopes, valid for ttl (zero for no expiry). It
+// returns the key's description and the key itself, which is not kept.
+func (s *APIKeyStore) Create(name, owner string, scopes []APIKeyScope, ttl time.Duration) (*APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("an API key needs at least one scope")
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(random)
+	now := time.Now()
+	key := &APIKey{
+		ID:        fmt.Sprintf("key_%d", now.UnixNano()),
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		Hint:      secret[:len(apiKeyPrefix)+6],
+		Hash:      hashAPIKey(secret),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	s.byHash[key.Hash] = key
+	if err := s.save(); err != nil {
+		delete(s.keys, key.ID)
+		delete(s.byHash, key.Hash)
+		return nil, "", err
+	}
+	return key.public(), secret, nil
+}
+
+// List returns every key, oldest first
+func (s *APIKeyStore) List() []*APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key.public())
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Get returns a key by ID
+func (s *APIKeyStore) Get(id string) (*APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, false
+	}
+	return key.public(), true
+}
+
+// APIKeyUpdate changes a key; nil fields are left as they are. A zero
+// ExpiresAt removes the expiry.
+type APIKeyUpdate struct {
+	Name      *string
+	Scopes    []APIKeyScope
+	ExpiresAt *time.Time
+}
+
+// Update changes the name, scopes or expiry of a key
+func (s *APIKeyStore) Update(id string, update APIKeyUpdate) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("API key %s not found", id)
+	}
+	previous := *key
+	if update.Name != nil {
+		key.Name = *update.Name
+	}
+	if update.Scopes != nil {
+		if len(update.Scopes) == 0 {
+			return nil, fmt.Errorf("an API key needs at least one scope")
+		}
+		key.Scopes = update.Scopes
+	}
+	if update.ExpiresAt != nil {
+		key.ExpiresAt = update.ExpiresAt
+		if update.ExpiresAt.IsZero() {
+			key.ExpiresAt = nil
+		}
+	}
+	if err := s.save(); err != nil {
+		*key = previous
+		return nil, err
+	}
+	return key.public(), nil
+}
+
+// Delete revokes a key
+func (s *APIKeyStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("API key %s not found", id)
+	}
+	delete(s.keys, id)
+	delete(s.byHash, key.Hash)
+	delete(s.saved, id)
+	if err := s.save(); err != nil {
+		s.keys[id] = key
+		s.byHash[key.Hash] = key
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the key matching secret, recording its use, or an
+// error wrapping ErrInvalidAPIKey
+func (s *APIKeyStore) Authenticate(secret string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.byHash[hashAPIKey(secret)]
+	now := time.Now()
+	if !ok || key.expired(now) {
+		return nil, ErrInvalidAPIKey
+	}
+	key.LastUsedAt = &now
+	if now.Sub(s.saved[key.ID]) >= lastUsedInterval {
+		s.saved[key.ID] = now
+		if err := s.save(); err != nil {
+			s.logger.Warn("Failed to record API key use", zap.String("key", key.ID), zap.Error(err))
+		}
+	}
+	return key.public(), nil
+}
+
+// save writes every key; the caller holds s.mu
+func (s *APIKeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err == nil {
+		data, err = s.sealer.Seal(data)
+	}
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half-written file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// hashAPIKey hashes a key for storage. Keys are random, so a plain hash
+// cannot be reversed by guessing.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyKey struct{}
+
+// ContextWithAPIKey records the API key a request was made with. Work done
+// under ctx is limited to the key's scopes.
+func ContextWithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+// APIKeyFrom returns the API key recorded in ctx, if any
+func APIKeyFrom(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyKey{}).(*APIKey)
+	return key
+}
+
+// RequireScope returns an error wrapping ErrScopeDenied if ctx carries an
+// API key without scope. Requests without a key are not limited.
+func RequireScope(ctx context.Context, scope APIKeyScope) error {
+	if key := APIKeyFrom(ctx); key != nil && !key.Allows(scope) {
+		return fmt.Errorf("%w %q", ErrScopeDenied, scope)
+	}
+	return nil
+}
+
+// scopedFileManager refuses file writes to API keys without the files scope
+type scopedFileManager struct {
+	FileManager
+	ctx context.Context
+}
+
+func (f *scopedFileManager) withContext(ctx context.Context) FileManager {
+	return &scopedFileManager{FileManager: fileManagerFor(ctx, f.FileManager), ctx: ctx}
+}
+
+func (f *scopedFileManager) check() error {
+	if f.ctx == nil {
+		return nil
+	}
+	return RequireScope(f.ctx, ScopeFiles)
+}
+
+func (f *scopedFileManager) CreateFile(path, content string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.FileManager.CreateFile(path, content)
+}
+
+func (f *scopedFileManager) UpdateFile(path, content string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.FileManager.UpdateFile(path, content)
+}
+
+func (f *scopedFileManager) DeleteFile(path string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.FileManager.DeleteFile(path)
+}
+
+// ScopedExecutor refuses commands to API keys without the terminal scope
+type ScopedExecutor struct {
+	CommandExecutor
+}
+
+// ExecuteCommand checks the scope and executes a single command
+func (e *ScopedExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return e.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream checks the scope and executes a single command,
+// streaming its output
+func (e *ScopedExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	if err := RequireScope(ctx, ScopeTerminal); err != nil {
+		return nil, err
+	}
+	return e.CommandExecutor.ExecuteCommandStream(ctx, command, workingDir, opts, onStdout, onStderr)
+}
+
+// ExecuteCommands checks the scope and executes multiple commands
+func (e *ScopedExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	if err := RequireScope(ctx, ScopeTerminal); err != nil {
+		return nil, err
+	}
+	return e.CommandExecutor.ExecuteCommands(ctx, commands, workingDir, opts)
+}
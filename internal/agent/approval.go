@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ApprovalStatus is the state of an approval request
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalConsumed ApprovalStatus = "consumed"
+)
+
+// Approval is a pending request for a user to allow a risky action
+type Approval struct {
+	ID         string                 `json:"id"`
+	TaskID     string                 `json:"task_id"`
+	Kind       string                 `json:"kind"`
+	Subject    string                 `json:"subject"`
+	Risk       *RiskAssessment        `json:"risk,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Status     ApprovalStatus         `json:"status"`
+	CreatedAt  time.Time              `json:"created_at"`
+	DecidedAt  time.Time              `json:"decided_at,omitempty"`
+	WorkingDir string                 `json:"working_dir,omitempty"`
+}
+
+// ApprovalStore keeps approval requests in memory
+type ApprovalStore struct {
+	mu        sync.Mutex
+	approvals map[string]*Approval
+	// decided are closed when the approval with their ID is decided
+	decided map[string]chan struct{}
+}
+
+// NewApprovalStore creates an empty approval store
+func NewApprovalStore() *ApprovalStore {
+	return &ApprovalStore{approvals: make(map[string]*Approval), decided: make(map[string]chan struct{})}
+}
+
+// Request records a new pending approval
+func (s *ApprovalStore) Request(approval *Approval) *Approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	approval.ID = fmt.Sprintf("approval_%d", time.Now().UnixNano())
+	approval.Status = ApprovalPending
+	approval.CreatedAt = time.Now()
+	s.approvals[approval.ID] = approval
+	return approval
+}
+
+// Get returns a copy of an approval by ID
+func (s *ApprovalStore) Get(id string) (*Approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *approval
+	return &copied, true
+}
+
+// List returns all approvals, newest first
+func (s *ApprovalStore) List() []*Approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*Approval, 0, len(s.approvals))
+	for _, approval := range s.approvals {
+		copied := *approval
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Decide approves or rejects a pending approval
+func (s *ApprovalStore) Decide(id string, approve bool) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil, fmt.Errorf("approval %s not found", id)
+	}
+	if approval.Status != ApprovalPending {
+		return nil, fmt.Errorf("approval %s is already %s", id, approval.Status)
+	}
+
+	approval.Status = ApprovalRejected
+	if approve {
+		approval.Status = ApprovalApproved
+	}
+	approval.DecidedAt = time.Now()
+	if decided, ok := s.decided[id]; ok {
+		close(decided)
+		delete(s.decided, id)
+	}
+	copied := *approval
+	return &copied, nil
+}
+
+// Wait blocks until the approval with id is decided or ctx ends, and
+// returns it
+func (s *ApprovalStore) Wait(ctx context.Context, id string) (*Approval, error) {
+	s.mu.Lock()
+	approval, ok := s.approvals[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("approval %s not found", id)
+	}
+	if approval.Status != ApprovalPending {
+		copied := *approval
+		s.mu.Unlock()
+		return &copied, nil
+	}
+	decided, ok := s.decided[id]
+	if !ok {
+		decided = make(chan struct{})
+		s.decided[id] = decided
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-decided:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	approval, _ = s.Get(id)
+	return approval, nil
+}
+
+// Consume marks an approved approval as used so it cannot be replayed
+func (s *ApprovalStore) Consume(id, kind string) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil, fmt.Errorf("approval %s not found", id)
+	}
+	if approval.Kind != kind {
+		return nil, fmt.Errorf("approval %s is for a %s, not a %s", id, approval.Kind, kind)
+	}
+	if approval.Status != ApprovalApproved {
+		return nil, fmt.Errorf("approval %s is %s, not approved", id, approval.Status)
+	}
+
+	approval.Status = ApprovalConsumed
+	copied := *approval
+	return &copied, nil
+}
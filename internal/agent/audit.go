@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditOutputBytes is how much command output is kept per audit entry
+const auditOutputBytes = 4096
+
+// CommandAuditEntry records a single executed command
+type CommandAuditEntry struct {
+	ID          string        `json:"id"`
+	TaskID      string        `json:"task_id,omitempty"`
+	Requester   string        `json:"requester,omitempty"`
+	Instruction string        `json:"instruction,omitempty"`
+	Command     string        `json:"command"`
+	WorkingDir  string        `json:"working_dir"`
+	Status      string        `json:"status"`
+	ExitCode    int           `json:"exit_code"`
+	Duration    time.Duration `json:"duration"`
+	Output      string        `json:"output,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	TaskID    string
+	Requester string
+	Since     time.Time
+	Limit     int
+}
+
+func (f AuditFilter) matches(entry *CommandAuditEntry) bool {
+	if f.TaskID != "" && entry.TaskID != f.TaskID {
+		return false
+	}
+	if f.Requester != "" && entry.Requester != f.Requester {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// CommandAuditLog is an append-only store of executed commands
+type CommandAuditLog interface {
+	Record(entry *CommandAuditEntry) error
+	Query(filter AuditFilter) ([]*CommandAuditEntry, error)
+}
+
+// FileAuditLog stores audit entries as JSON lines in a file opened in
+// append-only mode. With a sealer each line is encrypted on its own.
+type FileAuditLog struct {
+	mu     sync.Mutex
+	path   string
+	sealer *Sealer
+}
+
+// NewFileAuditLog creates an audit log at path, creating its directory
+func NewFileAuditLog(path string, sealer *Sealer) (*FileAuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	return &FileAuditLog{path: path, sealer: sealer}, nil
+}
+
+// Record appends an entry
+func (l *FileAuditLog) Record(entry *CommandAuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err == nil {
+		line, err = l.sealer.Seal(line)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Query returns matching entries, newest first
+func (l *FileAuditLog) Query(filter AuditFilter) ([]*CommandAuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []*CommandAuditEntry
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		line, err := l.sealer.Open(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		var entry CommandAuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		if filter.matches(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// Reverse to newest first, then apply the limit
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// AuditedExecutor records every command run through the wrapped executor
+type AuditedExecutor struct {
+	CommandExecutor
+	log     CommandAuditLog
+	onError func(error)
+}
+
+// NewAuditedExecutor wraps an executor with audit logging. onError is
+// called when an entry cannot be recorded; execution is never blocked.
+func NewAuditedExecutor(exec CommandExecutor, log CommandAuditLog, onError func(error)) *AuditedExecutor {
+	return &AuditedExecutor{CommandExecutor: exec, log: log, onError: onError}
+}
+
+// ExecuteCommand executes and audits a single command
+func (a *AuditedExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return a.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream executes and audits a single command, streaming its output
+func (a *AuditedExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	result, err := a.CommandExecutor.ExecuteCommandStream(ctx, command, workingDir, opts, onStdout, onStderr)
+	if result != nil {
+		a.record(ctx, result)
+	}
+	return result, err
+}
+
+// ExecuteCommands executes and audits multiple commands
+func (a *AuditedExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	var results []*Command
+
+	for _, command := range commands {
+		result, err := a.ExecuteCommand(ctx, command, workingDir, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if result.Status != "completed" {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+func (a *AuditedExecutor) record(ctx context.Context, result *Command) {
+	origin := commandOriginFrom(ctx)
+	entry := &CommandAuditEntry{
+		ID:          result.ID,
+		TaskID:      origin.TaskID,
+		Requester:   RequesterFrom(ctx),
+		Instruction: origin.Instruction,
+		Command:     result.Command,
+		WorkingDir:  result.WorkingDir,
+		Status:      result.Status,
+		ExitCode:    result.ExitCode,
+		Duration:    result.Duration,
+		Output:      truncateString(result.Output, auditOutputBytes),
+		Error:       truncateString(result.Error, auditOutputBytes),
+		Timestamp:   result.CreatedAt,
+	}
+	if err := a.log.Record(entry); err != nil && a.onError != nil {
+		a.onError(err)
+	}
+}
+
+type requesterKey struct{}
+
+// ContextWithRequester records who asked for the work done under ctx
+func ContextWithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// RequesterFrom returns the requester recorded in ctx, if any
+func RequesterFrom(ctx context.Context) string {
+	requester, _ := ctx.Value(requesterKey{}).(string)
+	return requester
+}
+
+// commandOrigin describes why a command is being executed
+type commandOrigin struct {
+	TaskID      string
+	Instruction string
+}
+
+type commandOriginKey struct{}
+
+// withCommandOrigin attaches the task and instruction a command was generated from
+func withCommandOrigin(ctx context.Context, taskID, instruction string) context.Context {
+	return context.WithValue(ctx, commandOriginKey{}, commandOrigin{TaskID: taskID, Instruction: instruction})
+}
+
+func commandOriginFrom(ctx context.Context) commandOrigin {
+	origin, _ := ctx.Value(commandOriginKey{}).(commandOrigin)
+	return origin
+}
+
+// truncateString cuts s to at most max bytes, marking the cut
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "\n...[truncated]"
+}
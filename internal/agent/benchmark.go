@@ -0,0 +1,355 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// benchmarkBaselineFile stores the baseline relative to the workspace root
+	benchmarkBaselineFile = ".spilot/bench/baseline.json"
+	// defaultRegressionThreshold is the ns/op increase, in percent, reported as a regression
+	defaultRegressionThreshold = 10.0
+	// maxProfiledRegressions bounds the regressed benchmarks that get profiled
+	maxProfiledRegressions = 3
+)
+
+// BenchmarkResult is one benchmark's measurements, averaged over runs
+type BenchmarkResult struct {
+	Name        string  `json:"name"`
+	Package     string  `json:"package"`
+	Runs        int     `json:"runs"`
+	Iterations  int64   `json:"iterations"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  float64 `json:"bytes_per_op,omitempty"`
+	AllocsPerOp float64 `json:"allocs_per_op,omitempty"`
+}
+
+// key identifies a benchmark across runs
+func (r BenchmarkResult) key() string {
+	return r.Package + "." + r.Name
+}
+
+// BenchmarkBaseline is a stored set of results to compare against
+type BenchmarkBaseline struct {
+	Commit    string            `json:"commit,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Results   []BenchmarkResult `json:"results"`
+}
+
+// BenchmarkComparison is a benchmark measured against its baseline
+type BenchmarkComparison struct {
+	Name           string  `json:"name"`
+	Package        string  `json:"package"`
+	BaselineNsOp   float64 `json:"baseline_ns_per_op"`
+	NsPerOp        float64 `json:"ns_per_op"`
+	DeltaPercent   float64 `json:"delta_percent"`
+	BaselineAllocs float64 `json:"baseline_allocs_per_op"`
+	AllocsPerOp    float64 `json:"allocs_per_op"`
+	Regressed      bool    `json:"regressed"`
+	// Profile is the top of the CPU profile of a regressed benchmark
+	Profile string `json:"profile,omitempty"`
+}
+
+// BenchmarkAgent runs Go benchmarks, compares them with a stored baseline
+// and suggests optimizations for regressions
+type BenchmarkAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	tools       *ToolRunner
+	logger      *zap.Logger
+}
+
+// NewBenchmarkAgent creates a new benchmark agent
+func NewBenchmarkAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, tools *ToolRunner, logger *zap.Logger) *BenchmarkAgentImpl {
+	return &BenchmarkAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		tools:       tools,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (b *BenchmarkAgentImpl) Type() AgentType {
+	return BenchmarkAgent
+}
+
+// Execute runs the benchmarks matching "pattern" (default all) in "target"
+// (default ./...) "count" times. Operations:
+//   - run: compare with the baseline; regressions beyond "threshold"
+//     percent are profiled and get LLM-suggested optimizations
+//   - baseline: store the results as the new baseline
+//
+// "fail_on_regression" fails the task when any benchmark regressed.
+func (b *BenchmarkAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	b.logger.Info("Benchmark agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	operation := stringField(task.Data, "operation")
+	if operation != "" && operation != "run" && operation != "baseline" {
+		return nil, fmt.Errorf("unknown benchmark operation: %s", operation)
+	}
+	if !fileExists(workspaceDir, "go.mod") {
+		return &TaskResult{Success: false, Error: "benchmarks are supported for Go modules only"}, nil
+	}
+
+	count := 3
+	if n, ok := task.Data["count"].(float64); ok && n >= 1 {
+		count = int(n)
+	}
+	results, command, err := b.runBenchmarks(ctx, workspaceDir, stringField(task.Data, "target"), stringField(task.Data, "pattern"), count)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	data := Fields{"command": command, "results": results}
+
+	baselinePath := filepath.Join(workspaceDir, benchmarkBaselineFile)
+	if operation == "baseline" {
+		baseline := BenchmarkBaseline{CreatedAt: time.Now(), Results: results}
+		if commit, err := b.tools.git(ctx, workspaceDir, "rev-parse", "--short", "HEAD"); err == nil {
+			baseline.Commit = strings.TrimSpace(commit)
+		}
+		content, err := json.MarshalIndent(baseline, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := fileManagerFor(ctx, b.fileManager).CreateFile(baselinePath, string(content)+"\n"); err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		data["baseline"] = benchmarkBaselineFile
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+
+	baseline, err := b.loadBaseline(ctx, baselinePath)
+	if err != nil {
+		// Without a baseline the results are still useful on their own
+		data["baseline_error"] = err.Error()
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+	threshold := defaultRegressionThreshold
+	if t, ok := task.Data["threshold"].(float64); ok && t > 0 {
+		threshold = t
+	}
+	comparisons := compareBenchmarks(baseline.Results, results, threshold)
+	data["baseline_commit"] = baseline.Commit
+	data["comparisons"] = comparisons
+
+	var regressed []*BenchmarkComparison
+	for i := range comparisons {
+		if comparisons[i].Regressed {
+			regressed = append(regressed, &comparisons[i])
+		}
+	}
+	data["regressions"] = len(regressed)
+	if len(regressed) > 0 {
+		for i, comparison := range regressed {
+			if i == maxProfiledRegressions {
+				break
+			}
+			comparison.Profile = b.profile(ctx, workspaceDir, comparison)
+		}
+		suggestions, err := b.suggest(ctx, regressed)
+		if err != nil {
+			b.logger.Warn("Failed to suggest optimizations", zap.Error(err))
+		} else {
+			data["suggestions"] = suggestions
+		}
+	}
+
+	result := &TaskResult{Success: true, Data: data}
+	if fail, _ := task.Data["fail_on_regression"].(bool); fail && len(regressed) > 0 {
+		result.Success = false
+		result.Error = fmt.Sprintf("%d benchmark(s) regressed by more than %.0f%%", len(regressed), threshold)
+	}
+	return result, nil
+}
+
+// runBenchmarks runs go test -bench and averages the results per benchmark
+func (b *BenchmarkAgentImpl) runBenchmarks(ctx context.Context, workspaceDir, target, pattern string, count int) ([]BenchmarkResult, string, error) {
+	shell := b.commandExec.DefaultShell()
+	if target == "" {
+		target = "./..."
+	}
+	if pattern == "" {
+		pattern = "."
+	}
+	command := fmt.Sprintf("go test -run %s -bench %s -benchmem -count %d %s", shell.Quote("^$"), shell.Quote(pattern), count, shell.Quote(target))
+
+	run, err := b.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil {
+		return nil, command, fmt.Errorf("failed to run benchmarks: %w", err)
+	}
+	results := parseBenchmarkOutput(run.Output)
+	if run.Status != "completed" {
+		return nil, command, fmt.Errorf("benchmark command %s: %s", run.Status, truncateString(strings.TrimSpace(run.Output+"\n"+run.Error), 2000))
+	}
+	if len(results) == 0 {
+		return nil, command, fmt.Errorf("no benchmarks matched %q in %s", pattern, target)
+	}
+	return results, command, nil
+}
+
+var benchmarkLine = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+(\d+)\s+([\d.]+) ns/op(.*)$`)
+
+// parseBenchmarkOutput parses go test -bench output, averaging repeated runs
+func parseBenchmarkOutput(output string) []BenchmarkResult {
+	var results []BenchmarkResult
+	index := make(map[string]int)
+	pkg := ""
+
+	scanner := newLineScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = rest
+			continue
+		}
+		m := benchmarkLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		iterations, _ := strconv.ParseInt(m[2], 10, 64)
+		nsPerOp, _ := strconv.ParseFloat(m[3], 64)
+		var bytesPerOp, allocsPerOp float64
+		fields := strings.Fields(m[4])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, _ := strconv.ParseFloat(fields[i], 64)
+			switch fields[i+1] {
+			case "B/op":
+				bytesPerOp = value
+			case "allocs/op":
+				allocsPerOp = value
+			}
+		}
+
+		run := BenchmarkResult{Name: m[1], Package: pkg}
+		i, seen := index[run.key()]
+		if !seen {
+			i = len(results)
+			index[run.key()] = i
+			results = append(results, run)
+		}
+		r := &results[i]
+		r.Runs++
+		r.Iterations += iterations
+		// Running means over the repeated -count runs
+		n := float64(r.Runs)
+		r.NsPerOp += (nsPerOp - r.NsPerOp) / n
+		r.BytesPerOp += (bytesPerOp - r.BytesPerOp) / n
+		r.AllocsPerOp += (allocsPerOp - r.AllocsPerOp) / n
+	}
+	return results
+}
+
+// compareBenchmarks compares results with the baseline; benchmarks missing
+// from the baseline are left out
+func compareBenchmarks(baseline, results []BenchmarkResult, threshold float64) []BenchmarkComparison {
+	previous := make(map[string]BenchmarkResult, len(baseline))
+	for _, r := range baseline {
+		previous[r.key()] = r
+	}
+
+	var comparisons []BenchmarkComparison
+	for _, r := range results {
+		base, ok := previous[r.key()]
+		if !ok || base.NsPerOp == 0 {
+			continue
+		}
+		delta := (r.NsPerOp - base.NsPerOp) / base.NsPerOp * 100
+		comparisons = append(comparisons, BenchmarkComparison{
+			Name:           r.Name,
+			Package:        r.Package,
+			BaselineNsOp:   base.NsPerOp,
+			NsPerOp:        r.NsPerOp,
+			DeltaPercent:   delta,
+			BaselineAllocs: base.AllocsPerOp,
+			AllocsPerOp:    r.AllocsPerOp,
+			Regressed:      delta > threshold,
+		})
+	}
+	sort.SliceStable(comparisons, func(i, j int) bool { return comparisons[i].DeltaPercent > comparisons[j].DeltaPercent })
+	return comparisons
+}
+
+func (b *BenchmarkAgentImpl) loadBaseline(ctx context.Context, path string) (*BenchmarkBaseline, error) {
+	if !fileManagerFor(ctx, b.fileManager).FileExists(path) {
+		return nil, fmt.Errorf("no baseline stored; run the baseline operation first")
+	}
+	content, err := fileManagerFor(ctx, b.fileManager).ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline BenchmarkBaseline
+	if err := json.Unmarshal([]byte(content), &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", benchmarkBaselineFile, err)
+	}
+	return &baseline, nil
+}
+
+// profile runs a single benchmark with CPU profiling and returns the top
+// of the profile, or "" if profiling failed
+func (b *BenchmarkAgentImpl) profile(ctx context.Context, workspaceDir string, comparison *BenchmarkComparison) string {
+	dir, err := os.MkdirTemp("", "spilot-bench-")
+	if err != nil {
+		return ""
+	}
+	defer os.RemoveAll(dir)
+
+	shell := b.commandExec.DefaultShell()
+	profile := filepath.Join(dir, "cpu.out")
+	command := fmt.Sprintf("go test -run %s -bench %s -cpuprofile %s -o %s %s",
+		shell.Quote("^$"), shell.Quote("^"+regexp.QuoteMeta(comparison.Name)+"$"), shell.Quote(profile),
+		shell.Quote(filepath.Join(dir, "bench.test")), shell.Quote(comparison.Package))
+	run, err := b.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil || run.Status != "completed" {
+		b.logger.Warn("Failed to profile benchmark", zap.String("benchmark", comparison.Name), zap.Error(err))
+		return ""
+	}
+	top, err := b.commandExec.ExecuteCommand(ctx, "go tool pprof -top -nodecount=25 "+shell.Quote(profile), workspaceDir, CommandOptions{})
+	if err != nil || top.Status != "completed" {
+		return ""
+	}
+	// Drop the header (binary, build ID, time) before the table
+	output := top.Output
+	if i := strings.Index(output, "Showing nodes"); i >= 0 {
+		output = output[i:]
+	}
+	return strings.TrimSpace(output)
+}
+
+// suggest asks the LLM for optimizations of the regressed benchmarks' hot paths
+func (b *BenchmarkAgentImpl) suggest(ctx context.Context, regressed []*BenchmarkComparison) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("These Go benchmarks regressed against the baseline:\n")
+	for _, c := range regressed {
+		fmt.Fprintf(&prompt, "\n%s (%s): %.0f ns/op -> %.0f ns/op (%+.1f%%), allocs/op %.0f -> %.0f\n",
+			c.Name, c.Package, c.BaselineNsOp, c.NsPerOp, c.DeltaPercent, c.BaselineAllocs, c.AllocsPerOp)
+		if c.Profile != "" {
+			fmt.Fprintf(&prompt, "CPU profile:\n%s\n", c.Profile)
+		}
+	}
+	prompt.WriteString("\nIdentify the likely hot paths behind each regression and suggest concrete optimizations, most impactful first.")
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a Go performance engineer who reads benchmark results and pprof output."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt.String()},
+	}
+	return b.llmClient.Chat(ctx, messages)
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrBlastRadius is returned for file writes and commands past one of the
+// limits of a plan execution
+var ErrBlastRadius = errors.New("plan blast-radius limit reached")
+
+// ApprovalBlastRadius is the kind of approvals for continuing a plan past
+// its blast-radius limits
+const ApprovalBlastRadius = "blast_radius"
+
+// BlastRadiusLimits cap what the tasks of one plan execution may change.
+// A limit of 0 leaves that quantity unlimited.
+type BlastRadiusLimits struct {
+	// MaxFiles is the number of distinct files created, updated or deleted
+	MaxFiles int
+	// MaxBytes is the total size of the file contents written
+	MaxBytes int64
+	// MaxCommands is the number of commands executed or started
+	MaxCommands int
+}
+
+func (l BlastRadiusLimits) unlimited() bool {
+	return l.MaxFiles <= 0 && l.MaxBytes <= 0 && l.MaxCommands <= 0
+}
+
+// BlastRadiusError reports a plan execution paused at a limit. Approving
+// the approval and executing the plan with the token it issues resumes the
+// plan at Task with the limits lifted.
+type BlastRadiusError struct {
+	Reason     string
+	Task       string
+	ApprovalID string
+}
+
+func (e *BlastRadiusError) Error() string {
+	return fmt.Sprintf("%s: %s; approve %s to continue", ErrBlastRadius, e.Reason, e.ApprovalID)
+}
+
+func (e *BlastRadiusError) Unwrap() error {
+	return ErrBlastRadius
+}
+
+// blastRadius counts the files, bytes and commands of one plan execution.
+// Once a limit is reached every further change is refused, so the plan
+// stops where it is.
+type blastRadius struct {
+	limits BlastRadiusLimits
+
+	mu       sync.Mutex
+	files    map[string]bool
+	bytes    int64
+	commands int
+	exceeded string
+	taskID   string
+}
+
+// newBlastRadius returns a counter for limits, or nil if nothing is limited
+func newBlastRadius(limits BlastRadiusLimits) *blastRadius {
+	if limits.unlimited() {
+		return nil
+	}
+	return &blastRadius{limits: limits, files: make(map[string]bool)}
+}
+
+type blastRadiusKey struct{}
+
+func withBlastRadius(ctx context.Context, b *blastRadius) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, blastRadiusKey{}, b)
+}
+
+func blastRadiusFrom(ctx context.Context) *blastRadius {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(blastRadiusKey{}).(*blastRadius)
+	return b
+}
+
+// file counts a write of size bytes to path
+func (b *blastRadius) file(ctx context.Context, path string, size int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exceeded != "" {
+		return fmt.Errorf("%w: %s", ErrBlastRadius, b.exceeded)
+	}
+	path = absPath(path)
+	if !b.files[path] && b.limits.MaxFiles > 0 && len(b.files) >= b.limits.MaxFiles {
+		return b.exceed(ctx, "more than %d files modified", b.limits.MaxFiles)
+	}
+	if b.limits.MaxBytes > 0 && b.bytes+int64(size) > b.limits.MaxBytes {
+		return b.exceed(ctx, "more than %d bytes written", b.limits.MaxBytes)
+	}
+	b.files[path] = true
+	b.bytes += int64(size)
+	return nil
+}
+
+// command counts n commands about to be executed
+func (b *blastRadius) command(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exceeded != "" {
+		return fmt.Errorf("%w: %s", ErrBlastRadius, b.exceeded)
+	}
+	if b.limits.MaxCommands > 0 && b.commands+n > b.limits.MaxCommands {
+		return b.exceed(ctx, "more than %d commands executed", b.limits.MaxCommands)
+	}
+	b.commands += n
+	return nil
+}
+
+// exceed records the limit reached, and the task reaching it, and returns
+// the error refusing the change. b.mu must be held.
+func (b *blastRadius) exceed(ctx context.Context, format string, args ...interface{}) error {
+	b.exceeded = fmt.Sprintf(format, args...)
+	b.taskID = commandOriginFrom(ctx).TaskID
+	return fmt.Errorf("%w: %s", ErrBlastRadius, b.exceeded)
+}
+
+// paused returns the limit reached and the ID of the task reaching it, or
+// "" if the execution stayed within its limits
+func (b *blastRadius) paused() (reason, taskID string) {
+	if b == nil {
+		return "", ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded, b.taskID
+}
+
+// usage summarises what the execution changed so far
+func (b *blastRadius) usage() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"files":    len(b.files),
+		"bytes":    b.bytes,
+		"commands": b.commands,
+	}
+}
+
+// blastRadiusFileManager counts file writes against the limits of the plan
+// execution of its context
+type blastRadiusFileManager struct {
+	FileManager
+	ctx context.Context
+}
+
+func (f *blastRadiusFileManager) withContext(ctx context.Context) FileManager {
+	return &blastRadiusFileManager{FileManager: fileManagerFor(ctx, f.FileManager), ctx: ctx}
+}
+
+func (f *blastRadiusFileManager) CreateFile(path, content string) error {
+	if err := blastRadiusFrom(f.ctx).file(f.ctx, path, len(content)); err != nil {
+		return err
+	}
+	return f.FileManager.CreateFile(path, content)
+}
+
+func (f *blastRadiusFileManager) UpdateFile(path, content string) error {
+	if err := blastRadiusFrom(f.ctx).file(f.ctx, path, len(content)); err != nil {
+		return err
+	}
+	return f.FileManager.UpdateFile(path, content)
+}
+
+func (f *blastRadiusFileManager) DeleteFile(path string) error {
+	if err := blastRadiusFrom(f.ctx).file(f.ctx, path, 0); err != nil {
+		return err
+	}
+	return f.FileManager.DeleteFile(path)
+}
+
+// BlastRadiusExecutor counts commands against the limits of the plan
+// execution of their context
+type BlastRadiusExecutor struct {
+	CommandExecutor
+}
+
+// ExecuteCommand counts and executes a single command
+func (e *BlastRadiusExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return e.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream counts and executes a single command, streaming its
+// output
+func (e *BlastRadiusExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	if err := blastRadiusFrom(ctx).command(ctx, 1); err != nil {
+		return nil, err
+	}
+	return e.CommandExecutor.ExecuteCommandStream(ctx, command, workingDir, opts, onStdout, onStderr)
+}
+
+// ExecuteCommands counts and executes multiple commands
+func (e *BlastRadiusExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	if err := blastRadiusFrom(ctx).command(ctx, len(commands)); err != nil {
+		return nil, err
+	}
+	return e.CommandExecutor.ExecuteCommands(ctx, commands, workingDir, opts)
+}
+
+// pauseAtBlastRadius asks for approval to continue a plan execution that
+// reached a limit at the task with taskID, one of tasks. The continuation
+// stays linked to the plan's issue, ticket.
+func (s *System) pauseAtBlastRadius(b *blastRadius, tasks []*Task, planHash, workspaceDir, ticket string) error {
+	reason, taskID := b.paused()
+	resumeFrom := 0
+	for i, task := range tasks {
+		if task.ID == taskID {
+			resumeFrom = i
+			break
+		}
+	}
+	data := b.usage()
+	data["plan_sha256"] = planHash
+	data["resume_from"] = resumeFrom
+	data["reason"] = reason
+	if ticket != "" {
+		data["ticket"] = ticket
+	}
+	approval := s.approvals.Request(&Approval{
+		TaskID:     taskID,
+		Kind:       ApprovalBlastRadius,
+		Subject:    fmt.Sprintf("Continue plan past its limit (%s) at task %d: %s", reason, resumeFrom+1, truncateString(tasks[resumeFrom].Description, 200)),
+		Data:       data,
+		WorkingDir: workspaceDir,
+	})
+	s.logger.Warn("Plan paused at blast-radius limit", zap.String("task_id", taskID), zap.String("reason", reason))
+	s.events.Publish(TaskEvent{
+		TaskID: taskID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "reason": reason},
+	})
+	return &BlastRadiusError{Reason: reason, Task: taskID, ApprovalID: approval.ID}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultFixCandidates is the number of alternative fixes requested when
+// the task does not set "alternatives"
+const defaultFixCandidates = 3
+
+// FixCandidate is one ranked way of fixing an error
+type FixCandidate struct {
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Confidence  float64     `json:"confidence"`
+	Risks       []string    `json:"risks,omitempty"`
+	Code        string      `json:"code,omitempty"`
+	Patches     []FilePatch `json:"patches,omitempty"`
+}
+
+// exportedGoDecl matches the declaration of an exported Go identifier
+var exportedGoDecl = regexp.MustCompile(`(?m)^\s*(func\s+(\([^)]*\)\s*)?|type\s+|var\s+|const\s+)[A-Z]\w*`)
+
+// generateCandidates asks the LLM for up to n alternative fixes, ranked by
+// confidence, each annotated with risk notes
+func (d *DebugAgentImpl) generateCandidates(ctx context.Context, errorOutput, fileContent, analysis string, n int) ([]FixCandidate, error) {
+	prompt := fmt.Sprintf(`Error output:
+%s
+
+Relevant source (numbered lines, the error line is marked with >):
+%s
+
+Analysis:
+%s
+
+Propose up to %d genuinely different fixes. Respond with only a JSON array, each element:
+{"title": "<short name>", "description": "<what it changes and why>", "confidence": <0.0-1.0 that it fixes the error>,
+ "risks": ["<e.g. changes public API, alters behavior for other callers>"],
+ "code": "<the corrected code>",
+ "patches": [{"path": "...", "search": "<exact existing text>", "replace": "<new text>"}]}`, fenceUntrusted("error output", errorOutput), fileContent, analysis, n)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert debugger. Offer alternative fixes with honest confidence estimates and risk notes."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate fix candidates: %w", err)
+	}
+
+	var candidates []FixCandidate
+	if err := json.Unmarshal([]byte(extractJSON(response)), &candidates); err != nil {
+		return nil, fmt.Errorf("failed to parse fix candidates: %w", err)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("LLM returned no fix candidates")
+	}
+
+	for i := range candidates {
+		candidates[i].Confidence = clampConfidence(candidates[i].Confidence)
+		candidates[i].Risks = append(candidates[i].Risks, patchRisks(candidates[i].Patches)...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, nil
+}
+
+// patchRisks derives risk notes the model may have missed from the patches themselves
+func patchRisks(patches []FilePatch) []string {
+	var risks []string
+	files := make(map[string]bool)
+	for _, patch := range patches {
+		files[patch.Path] = true
+		if patch.Search != "" && exportedGoDecl.MatchString(patch.Search) {
+			risks = append(risks, fmt.Sprintf("changes an exported declaration in %s (public API)", patch.Path))
+		}
+		if patch.Search == "" && patch.Content != "" {
+			risks = append(risks, fmt.Sprintf("rewrites all of %s", patch.Path))
+		}
+	}
+	if len(files) > 1 {
+		risks = append(risks, fmt.Sprintf("touches %d files", len(files)))
+	}
+	return risks
+}
+
+// clampConfidence keeps model-reported confidence within [0, 1]
+func clampConfidence(c float64) float64 {
+	switch {
+	case c < 0:
+		return 0
+	case c > 1:
+		return 1
+	default:
+		return c
+	}
+}
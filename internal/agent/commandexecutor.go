@@ -2,30 +2,120 @@ package agent
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"time"
 )
 
+// CommandOptions holds per-command execution settings
+type CommandOptions struct {
+	// Timeout overrides the executor's default timeout when non-zero
+	Timeout time.Duration
+	// Shell overrides the executor's default shell when set
+	Shell Shell
+	// Env holds extra environment variables for the command. They are
+	// added after filtering the inherited environment.
+	Env map[string]string
+}
+
+// ExecutorConfig configures a CommandExecutorImpl
+type ExecutorConfig struct {
+	// DefaultTimeout of zero means commands only stop when the caller's
+	// context is cancelled
+	DefaultTimeout time.Duration
+	DefaultShell   Shell
+	// EnvAllowlist and EnvDenylist are glob patterns (path.Match syntax)
+	// selecting which server environment variables commands inherit
+	EnvAllowlist []string
+	EnvDenylist  []string
+	Limits       ResourceLimits
+	// Egress restricts the network access of commands; nil leaves it open
+	Egress *Egress
+}
+
 // CommandExecutorImpl implements the CommandExecutor interface
-type CommandExecutorImpl struct{}
+type CommandExecutorImpl struct {
+	defaultTimeout time.Duration
+	defaultShell   Shell
+	envFilter      *envFilter
+	limits         ResourceLimits
+	egress         *Egress
+}
 
 // NewCommandExecutor creates a new command executor
-func NewCommandExecutor() CommandExecutor {
-	return &CommandExecutorImpl{}
+func NewCommandExecutor(cfg ExecutorConfig) CommandExecutor {
+	return &CommandExecutorImpl{
+		defaultTimeout: cfg.DefaultTimeout,
+		defaultShell:   cfg.DefaultShell,
+		envFilter:      newEnvFilter(cfg.EnvAllowlist, cfg.EnvDenylist),
+		limits:         cfg.Limits,
+		egress:         cfg.Egress,
+	}
+}
+
+// DefaultShell returns the shell used when a command does not choose one
+func (c *CommandExecutorImpl) DefaultShell() Shell {
+	return c.defaultShell
 }
 
 // ExecuteCommand executes a single command
-func (c *CommandExecutorImpl) ExecuteCommand(command, workingDir string) (*Command, error) {
-	cmd := exec.Command("sh", "-c", command)
+func (c *CommandExecutorImpl) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return c.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream executes a single command, passing output chunks to
+// onStdout and onStderr as they are produced. Either callback may be nil.
+// The full output is still captured in the returned Command.
+func (c *CommandExecutorImpl) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	shell := c.defaultShell
+	if opts.Shell != "" {
+		shell = opts.Shell
+	}
+
+	return c.run(ctx, command, workingDir, opts, func(ctx context.Context) (*exec.Cmd, error) {
+		cmd := shell.Command(ctx, c.limits.shellPrefix(shell)+command)
+		cmd.Env = c.envFilter.environ(c.egress.env(opts.Env))
+		return cmd, c.egress.apply(cmd)
+	}, onStdout, onStderr)
+}
+
+// run executes the process built by build, applying the timeout and
+// process-group handling shared by all executors. command is the
+// user-facing command recorded in the result.
+func (c *CommandExecutorImpl) run(ctx context.Context, command, workingDir string, opts CommandOptions, build func(ctx context.Context) (*exec.Cmd, error), onStdout, onStderr func(chunk string)) (*Command, error) {
+	timeout := c.defaultTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd, err := build(ctx)
+	if err != nil {
+		return nil, err
+	}
 	cmd.Dir = workingDir
+	// Kill the whole process group so children of the shell (npm, go build
+	// workers, ...) don't outlive a cancelled command
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
 
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	stdoutWriter := &streamWriter{buf: &stdout, limit: c.limits.MaxOutputBytes, onChunk: onStdout}
+	stderrWriter := &streamWriter{buf: &stderr, limit: c.limits.MaxOutputBytes, onChunk: onStderr}
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
 
 	startTime := time.Now()
-	err := cmd.Run()
+	err = cmd.Run()
 
 	result := &Command{
 		ID:         fmt.Sprintf("cmd_%d", startTime.UnixNano()),
@@ -34,10 +124,20 @@ func (c *CommandExecutorImpl) ExecuteCommand(command, workingDir string) (*Comma
 		Status:     "completed",
 		Output:     stdout.String(),
 		Error:      stderr.String(),
+		ExitCode:   exitCode(cmd, err),
+		Truncated:  stdoutWriter.truncated || stderrWriter.truncated,
+		Duration:   time.Since(startTime),
 		CreatedAt:  startTime,
 	}
 
-	if err != nil {
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = "timeout"
+		result.Error = fmt.Sprintf("command timed out after %s: %s", timeout, stderr.String())
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		result.Status = "cancelled"
+		result.Error = fmt.Sprintf("command cancelled: %s", stderr.String())
+	case err != nil:
 		result.Status = "failed"
 		result.Error = fmt.Sprintf("%s: %s", err.Error(), stderr.String())
 	}
@@ -46,21 +146,62 @@ func (c *CommandExecutorImpl) ExecuteCommand(command, workingDir string) (*Comma
 }
 
 // ExecuteCommands executes multiple commands
-func (c *CommandExecutorImpl) ExecuteCommands(commands []string, workingDir string) ([]*Command, error) {
+func (c *CommandExecutorImpl) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
 	var results []*Command
 
 	for _, command := range commands {
-		result, err := c.ExecuteCommand(command, workingDir)
+		result, err := c.ExecuteCommand(ctx, command, workingDir, opts)
 		if err != nil {
 			return results, err
 		}
 		results = append(results, result)
 
 		// If command failed, stop execution
-		if result.Status == "failed" {
+		if result.Status != "completed" {
 			break
 		}
 	}
 
 	return results, nil
 }
+
+// exitCode extracts the process exit code, or -1 if the process never exited normally
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// streamWriter captures output while forwarding each chunk to a callback.
+// Captured output stops at limit bytes (if set); streaming continues.
+type streamWriter struct {
+	buf       *bytes.Buffer
+	limit     int64
+	truncated bool
+	onChunk   func(chunk string)
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	captured := p
+	if w.limit > 0 {
+		remaining := w.limit - int64(w.buf.Len())
+		if remaining < int64(len(p)) {
+			if remaining < 0 {
+				remaining = 0
+			}
+			captured = p[:remaining]
+			w.truncated = true
+		}
+	}
+	w.buf.Write(captured)
+
+	if w.onChunk != nil && len(p) > 0 {
+		w.onChunk(string(p))
+	}
+	// Report the full length so the process isn't killed by a short write
+	return len(p), nil
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic starts every zstd frame. JSON never does, so files written
+// before compression was enabled are told apart and read as they are.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodec returns the encoder and decoder shared by the stores, created
+// on first use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// compress returns data compressed with zstd
+func compress(data []byte) []byte {
+	encoder, _ := zstdCodec()
+	return encoder.EncodeAll(data, make([]byte, 0, len(data)/4))
+}
+
+// decompress returns data decompressed if compress wrote it, or unchanged
+// otherwise
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, zstdMagic) {
+		return data, nil
+	}
+	_, decoder := zstdCodec()
+	out, err := decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return out, nil
+}
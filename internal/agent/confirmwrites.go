@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWriteRejected is returned for file writes the user declined, or did
+// not decide on in time
+var ErrWriteRejected = errors.New("file write rejected")
+
+// ApprovalFileWrite is the kind of approvals for single file writes of
+// requests made with write confirmation
+const ApprovalFileWrite = "file_write"
+
+// writeConfirmTimeout is how long a file write waits for the user
+const writeConfirmTimeout = 15 * time.Minute
+
+type confirmWritesKey struct{}
+
+// ContextWithWriteConfirmation makes every file write of the tasks executed
+// with ctx wait for the user to approve a diff preview of it, for clients
+// that show each change before it is made
+func ContextWithWriteConfirmation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, confirmWritesKey{}, true)
+}
+
+func confirmsWrites(ctx context.Context) bool {
+	confirm, _ := ctx.Value(confirmWritesKey{}).(bool)
+	return confirm
+}
+
+// confirmingFileManager holds file writes of requests made with write
+// confirmation until the user approves them
+type confirmingFileManager struct {
+	FileManager
+	approvals *ApprovalStore
+	events    *EventBus
+	ctx       context.Context
+	// tools runs git for the diff previews
+	tools *ToolRunner
+}
+
+func (f *confirmingFileManager) withContext(ctx context.Context) FileManager {
+	return &confirmingFileManager{FileManager: fileManagerFor(ctx, f.FileManager), approvals: f.approvals, events: f.events, ctx: ctx, tools: f.tools}
+}
+
+// confirm asks for approval to change path to content, or to delete it if
+// content is nil, and waits for the decision
+func (f *confirmingFileManager) confirm(path string, content *string) error {
+	if f.ctx == nil || !confirmsWrites(f.ctx) {
+		return nil
+	}
+	var before *string
+	if current, err := f.FileManager.ReadFile(path); err == nil {
+		before = &current
+	}
+	subject, after := "Write "+path, ""
+	if content == nil {
+		subject = "Delete " + path
+	} else {
+		after = *content
+	}
+	diff := previewDiff(f.ctx, f.tools, path, before, after)
+
+	taskID := commandOriginFrom(f.ctx).TaskID
+	approval := f.approvals.Request(&Approval{
+		TaskID:     taskID,
+		Kind:       ApprovalFileWrite,
+		Subject:    subject,
+		Data:       map[string]interface{}{"path": path, "diff": diff},
+		WorkingDir: workspaceFrom(f.ctx),
+	})
+	f.events.Publish(TaskEvent{
+		TaskID: taskID,
+		Type:   EventApprovalRequired,
+		Data: map[string]interface{}{
+			"approval_id": approval.ID,
+			"kind":        ApprovalFileWrite,
+			"path":        path,
+			"diff":        diff,
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(f.ctx, writeConfirmTimeout)
+	defer cancel()
+	decided, err := f.approvals.Wait(ctx, approval.ID)
+	if err != nil {
+		f.approvals.Decide(approval.ID, false)
+		return fmt.Errorf("%w: %s: no decision: %v", ErrWriteRejected, path, err)
+	}
+	if decided.Status != ApprovalApproved {
+		return fmt.Errorf("%w: %s", ErrWriteRejected, path)
+	}
+	return nil
+}
+
+func (f *confirmingFileManager) CreateFile(path, content string) error {
+	if err := f.confirm(path, &content); err != nil {
+		return err
+	}
+	return f.FileManager.CreateFile(path, content)
+}
+
+func (f *confirmingFileManager) UpdateFile(path, content string) error {
+	if err := f.confirm(path, &content); err != nil {
+		return err
+	}
+	return f.FileManager.UpdateFile(path, content)
+}
+
+func (f *confirmingFileManager) DeleteFile(path string) error {
+	if err := f.confirm(path, nil); err != nil {
+		return err
+	}
+	return f.FileManager.DeleteFile(path)
+}
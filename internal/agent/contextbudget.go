@@ -0,0 +1,275 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultContextTokens is the context budget for models without one
+	defaultContextTokens = 8000
+	// minSummaryTokens is the least room worth spending on a summary of
+	// context that did not fit
+	minSummaryTokens = 200
+	// maxSummaryInputChars caps the overflow sent to be summarized
+	maxSummaryInputChars = 48000
+)
+
+// Context piece priorities. Pieces are included highest first.
+const (
+	PriorityLow      = 10
+	PriorityNormal   = 50
+	PriorityHigh     = 80
+	PriorityCritical = 100
+)
+
+// ContextPiece is a candidate piece of prompt context: a file snippet, a
+// prior message, plan state and so on
+type ContextPiece struct {
+	// Label is written above the content, e.g. a file path and line
+	Label    string
+	Content  string
+	Priority int
+	// Required pieces are always included, truncated to the remaining
+	// budget if they do not fit whole
+	Required bool
+	// Summarize lets the piece be condensed with other overflow instead of
+	// being dropped when it does not fit
+	Summarize bool
+	// Untrusted pieces, read from files or command output, are fenced as
+	// data and checked for instructions aimed at the model
+	Untrusted bool
+}
+
+func (p ContextPiece) text() string {
+	content := p.Content
+	if p.Untrusted {
+		content = fenceUntrusted(p.Label, content)
+	}
+	if p.Label == "" {
+		return content + "\n"
+	}
+	return fmt.Sprintf("// %s\n%s\n", p.Label, content)
+}
+
+// ContextReport describes how a prompt's context was assembled
+type ContextReport struct {
+	BudgetTokens int      `json:"budget_tokens"`
+	UsedTokens   int      `json:"used_tokens"`
+	Included     []string `json:"included,omitempty"`
+	Truncated    []string `json:"truncated,omitempty"`
+	Summarized   []string `json:"summarized,omitempty"`
+	Dropped      []string `json:"dropped,omitempty"`
+	// Injections flag untrusted pieces that appear to instruct the model
+	Injections []InjectionWarning `json:"injections,omitempty"`
+}
+
+// ContextBudgets holds the context token budget of each model
+type ContextBudgets struct {
+	Default int
+	Models  map[string]int
+}
+
+// For returns the budget of model, or the default budget
+func (b ContextBudgets) For(model string) int {
+	if tokens, ok := b.Models[model]; ok && tokens > 0 {
+		return tokens
+	}
+	if b.Default > 0 {
+		return b.Default
+	}
+	return defaultContextTokens
+}
+
+// estimateTokens approximates the token count of text
+func estimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// ContextAssembler builds prompt context from candidate pieces within a
+// token budget. Pieces are taken by priority, in the order added among
+// equals; required pieces are truncated rather than dropped, and optional
+// pieces that do not fit are summarized by the LLM when they allow it.
+type ContextAssembler struct {
+	budget int
+	// llmClient summarizes overflow; nil drops it instead
+	llmClient LLMClient
+	pieces    []ContextPiece
+	// ordered emits pieces in the order added instead of by priority
+	ordered bool
+	logger  *zap.Logger
+}
+
+// NewContextAssembler creates an assembler for a budget in tokens
+func NewContextAssembler(budgetTokens int, llmClient LLMClient, logger *zap.Logger) *ContextAssembler {
+	return &ContextAssembler{budget: budgetTokens, llmClient: llmClient, logger: logger}
+}
+
+// Add adds a candidate piece; empty pieces are ignored
+func (a *ContextAssembler) Add(piece ContextPiece) {
+	if strings.TrimSpace(piece.Content) == "" {
+		return
+	}
+	a.pieces = append(a.pieces, piece)
+}
+
+// PreserveOrder makes Assemble emit the chosen pieces in the order they
+// were added, with any summary of overflow first, as suits conversation
+// history. Priorities still decide which pieces are chosen.
+func (a *ContextAssembler) PreserveOrder() {
+	a.ordered = true
+}
+
+// Len returns the number of candidate pieces
+func (a *ContextAssembler) Len() int {
+	return len(a.pieces)
+}
+
+// Assemble returns the context text and a report of what was included
+func (a *ContextAssembler) Assemble(ctx context.Context) (string, ContextReport) {
+	order := make([]int, len(a.pieces))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		pi, pj := a.pieces[order[i]], a.pieces[order[j]]
+		if pi.Required != pj.Required {
+			return pi.Required
+		}
+		return pi.Priority > pj.Priority
+	})
+
+	report := ContextReport{BudgetTokens: a.budget}
+	remaining := a.budget * charsPerToken
+	// chosen holds the text of each included piece by its index
+	chosen := make([]string, len(a.pieces))
+	var emitted []int
+	var overflow []ContextPiece
+	for _, i := range order {
+		piece := a.pieces[i]
+		text := piece.text()
+		switch {
+		case len(text) <= remaining:
+			chosen[i] = text
+			emitted = append(emitted, i)
+			remaining -= len(text)
+			report.Included = append(report.Included, piece.Label)
+		case piece.Required && remaining > 0:
+			chosen[i] = fitPieceTo(piece, remaining)
+			emitted = append(emitted, i)
+			remaining -= len(chosen[i])
+			report.Truncated = append(report.Truncated, piece.Label)
+		case piece.Summarize:
+			overflow = append(overflow, piece)
+		default:
+			report.Dropped = append(report.Dropped, piece.Label)
+			continue
+		}
+		// Summarized pieces reach the prompt too, so they are scanned as well
+		if piece.Untrusted {
+			report.Injections = append(report.Injections, detectInjection(piece.Label, piece.Content)...)
+		}
+	}
+
+	summary := ""
+	if len(overflow) > 0 {
+		if a.llmClient != nil && remaining/charsPerToken >= minSummaryTokens {
+			summary = a.summarize(ctx, overflow, remaining/charsPerToken)
+		}
+		if summary != "" {
+			for _, piece := range overflow {
+				if piece.Untrusted {
+					summary = fenceUntrusted("summary of files and output", summary)
+					break
+				}
+			}
+			summary = fitTo("// Summary of further context that did not fit\n"+summary+"\n", remaining)
+			for _, piece := range overflow {
+				report.Summarized = append(report.Summarized, piece.Label)
+			}
+		} else {
+			for _, piece := range overflow {
+				report.Dropped = append(report.Dropped, piece.Label)
+			}
+		}
+	}
+
+	if a.ordered {
+		sort.Ints(emitted)
+	}
+	var out strings.Builder
+	if a.ordered {
+		out.WriteString(summary)
+	}
+	for _, i := range emitted {
+		out.WriteString(chosen[i])
+	}
+	if !a.ordered {
+		out.WriteString(summary)
+	}
+
+	report.UsedTokens = estimateTokens(out.String())
+	if len(report.Truncated)+len(report.Summarized)+len(report.Dropped) > 0 {
+		a.logger.Debug("Prompt context exceeded its budget",
+			zap.Int("budget_tokens", a.budget),
+			zap.Int("truncated", len(report.Truncated)),
+			zap.Int("summarized", len(report.Summarized)),
+			zap.Int("dropped", len(report.Dropped)))
+	}
+	return out.String(), report
+}
+
+// fitTo truncates text to at most max bytes including the truncation marker
+func fitTo(text string, max int) string {
+	const marker = "\n...[truncated]\n"
+	if len(text) <= max {
+		return text
+	}
+	if max <= len(marker) {
+		return ""
+	}
+	return text[:max-len(marker)] + marker
+}
+
+// fitPieceTo truncates the text of a piece to at most max bytes, keeping
+// the fence around untrusted content intact
+func fitPieceTo(piece ContextPiece, max int) string {
+	if !piece.Untrusted {
+		return fitTo(piece.text(), max)
+	}
+	overhead := len(piece.text()) - len(piece.Content)
+	if max <= overhead {
+		return ""
+	}
+	piece.Content = fitTo(piece.Content, max-overhead)
+	return piece.text()
+}
+
+// summarize condenses overflow pieces to about maxTokens, keeping the
+// details an engineer would need
+func (a *ContextAssembler) summarize(ctx context.Context, pieces []ContextPiece, maxTokens int) string {
+	var input strings.Builder
+	for _, piece := range pieces {
+		if input.Len() >= maxSummaryInputChars {
+			break
+		}
+		input.WriteString(piece.text())
+	}
+	prompt := fmt.Sprintf(`Summarize the following context in at most %d words. Keep file paths, line numbers, identifiers, signatures and error messages exactly; drop boilerplate.
+
+%s`, maxTokens*3/4, truncateString(input.String(), maxSummaryInputChars))
+	summary, err := a.llmClient.Chat(ctx, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You condense source code and notes into dense, accurate summaries for another engineer."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	})
+	if err != nil {
+		a.logger.Debug("Failed to summarize prompt context", zap.Error(err))
+		return ""
+	}
+	return strings.TrimSpace(summary)
+}
@@ -0,0 +1,687 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+
+	// Database drivers
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// defaultMaxRows caps query results when a database doesn't set max_rows
+const defaultMaxRows = 100
+
+// DatabaseConfig describes a database connection
+type DatabaseConfig struct {
+	Driver   string
+	DSN      string
+	ReadOnly bool
+	MaxRows  int
+}
+
+// TableSchema describes a table and its columns
+type TableSchema struct {
+	Name    string         `json:"name"`
+	Columns []ColumnSchema `json:"columns"`
+}
+
+// ColumnSchema describes a table column
+type ColumnSchema struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// QueryResult holds the rows returned by a query
+type QueryResult struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Truncated bool                     `json:"truncated"`
+}
+
+// DatabaseAgent introspects configured databases, generates SQL and runs
+// it. Statements that may modify data are held for approval.
+type DatabaseAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	approvals   *ApprovalStore
+	events      *EventBus
+	databases   map[string]DatabaseConfig
+	logger      *zap.Logger
+
+	mu    sync.Mutex
+	conns map[string]*sql.DB
+}
+
+// NewDatabaseAgent creates a new database agent for the given connections
+func NewDatabaseAgent(llmClient LLMClient, fileManager FileManager, approvals *ApprovalStore, events *EventBus, databases map[string]DatabaseConfig, logger *zap.Logger) *DatabaseAgentImpl {
+	return &DatabaseAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		approvals:   approvals,
+		events:      events,
+		databases:   databases,
+		logger:      logger,
+		conns:       make(map[string]*sql.DB),
+	}
+}
+
+// Type returns the agent type
+func (d *DatabaseAgentImpl) Type() AgentType {
+	return DatabaseAgent
+}
+
+// Close closes all open database connections
+func (d *DatabaseAgentImpl) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, db := range d.conns {
+		db.Close()
+		delete(d.conns, name)
+	}
+}
+
+// Execute executes a database task on the connection named "database"
+// (optional when only one is configured). Operations:
+//   - schema: list tables and columns
+//   - query: run "sql", or SQL generated from "request"
+//   - migrate: generate up/down migration files from "request"
+//   - explain: explain the query plan of "sql" or "request"
+func (d *DatabaseAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	d.logger.Info("Database agent executing task", zap.String("task_id", task.ID))
+
+	// A previously approved statement runs exactly as it was approved
+	if approvalID, ok := task.Data["approval_id"].(string); ok && approvalID != "" {
+		approval, err := d.approvals.Consume(approvalID, "sql")
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		name, _ := approval.Data["database"].(string)
+		return d.execWrite(ctx, name, approval.Subject)
+	}
+
+	operation, ok := task.Data["operation"].(string)
+	if !ok {
+		return nil, fmt.Errorf("operation data not found in task")
+	}
+	name, cfg, err := d.resolveDatabase(stringField(task.Data, "database"))
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	switch operation {
+	case "schema":
+		tables, err := d.schema(ctx, name)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		return &TaskResult{Success: true, Data: Fields{"database": name, "tables": tables}}, nil
+	case "query":
+		return d.handleQuery(ctx, task, name, cfg)
+	case "migrate":
+		return d.handleMigrate(ctx, task, name, cfg)
+	case "explain":
+		return d.handleExplain(ctx, task, name, cfg)
+	default:
+		return nil, fmt.Errorf("unknown database operation: %s", operation)
+	}
+}
+
+// resolveDatabase picks the named connection, or the only/"default" one
+func (d *DatabaseAgentImpl) resolveDatabase(name string) (string, DatabaseConfig, error) {
+	if name == "" {
+		if _, ok := d.databases["default"]; ok {
+			name = "default"
+		} else if len(d.databases) == 1 {
+			for only := range d.databases {
+				name = only
+			}
+		}
+	}
+	cfg, ok := d.databases[name]
+	if !ok {
+		names := make([]string, 0, len(d.databases))
+		for n := range d.databases {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", DatabaseConfig{}, fmt.Errorf("unknown database %q (configured: %s)", name, strings.Join(names, ", "))
+	}
+	return name, cfg, nil
+}
+
+// conn returns a pooled connection for a configured database
+func (d *DatabaseAgentImpl) conn(name string) (*sql.DB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if db, ok := d.conns[name]; ok {
+		return db, nil
+	}
+	cfg, ok := d.databases[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+	var driver string
+	switch cfg.Driver {
+	case "postgres", "postgresql":
+		driver = "postgres"
+	case "mysql":
+		driver = "mysql"
+	case "sqlite", "sqlite3":
+		driver = "sqlite"
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+	}
+	db, err := sql.Open(driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", name, err)
+	}
+	db.SetMaxOpenConns(4)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	d.conns[name] = db
+	return db, nil
+}
+
+// schema introspects the tables and columns of a database
+func (d *DatabaseAgentImpl) schema(ctx context.Context, name string) ([]TableSchema, error) {
+	db, err := d.conn(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var query string
+	switch d.databases[name].Driver {
+	case "sqlite", "sqlite3":
+		return sqliteSchema(ctx, db)
+	case "mysql":
+		query = `SELECT table_name, column_name, data_type, is_nullable FROM information_schema.columns
+			WHERE table_schema = DATABASE() ORDER BY table_name, ordinal_position`
+	default:
+		query = `SELECT table_schema || '.' || table_name, column_name, data_type, is_nullable FROM information_schema.columns
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema') ORDER BY table_schema, table_name, ordinal_position`
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []TableSchema
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		if err := rows.Scan(&table, &column, &dataType, &nullable); err != nil {
+			return nil, err
+		}
+		if len(tables) == 0 || tables[len(tables)-1].Name != table {
+			tables = append(tables, TableSchema{Name: table})
+		}
+		last := &tables[len(tables)-1]
+		last.Columns = append(last.Columns, ColumnSchema{Name: column, Type: dataType, Nullable: nullable == "YES"})
+	}
+	return tables, rows.Err()
+}
+
+func sqliteSchema(ctx context.Context, db *sql.DB) ([]TableSchema, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	tables := make([]TableSchema, 0, len(names))
+	for _, name := range names {
+		columns, err := db.QueryContext(ctx, `SELECT name, type, "notnull" FROM pragma_table_info(?)`, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+		}
+		table := TableSchema{Name: name}
+		for columns.Next() {
+			var column ColumnSchema
+			var notNull int
+			if err := columns.Scan(&column.Name, &column.Type, &notNull); err != nil {
+				columns.Close()
+				return nil, err
+			}
+			column.Nullable = notNull == 0
+			table.Columns = append(table.Columns, column)
+		}
+		columns.Close()
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// formatSchema renders a schema compactly for prompts
+func formatSchema(tables []TableSchema) string {
+	var b strings.Builder
+	for _, table := range tables {
+		cols := make([]string, len(table.Columns))
+		for i, c := range table.Columns {
+			cols[i] = c.Name + " " + c.Type
+			if !c.Nullable {
+				cols[i] += " NOT NULL"
+			}
+		}
+		fmt.Fprintf(&b, "%s(%s)\n", table.Name, strings.Join(cols, ", "))
+	}
+	return b.String()
+}
+
+// statementSQL returns the task's "sql", or generates it from "request"
+func (d *DatabaseAgentImpl) statementSQL(ctx context.Context, task *Task, name string, cfg DatabaseConfig) (string, error) {
+	if statement := stringField(task.Data, "sql"); statement != "" {
+		return statement, nil
+	}
+	request := stringField(task.Data, "request")
+	if request == "" {
+		return "", fmt.Errorf("sql or request not found in task data")
+	}
+
+	tables, err := d.schema(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	prompt := fmt.Sprintf(`Database dialect: %s
+Schema:
+%s
+Write a single SQL statement for this request: %s
+Respond with only the SQL.`, cfg.Driver, formatSchema(tables), request)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a database expert who writes correct, efficient SQL for the given dialect."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SQL: %w", err)
+	}
+	return strings.TrimSpace(stripCodeFence(response)), nil
+}
+
+func (d *DatabaseAgentImpl) handleQuery(ctx context.Context, task *Task, name string, cfg DatabaseConfig) (*TaskResult, error) {
+	statement, err := d.statementSQL(ctx, task, name, cfg)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		return &TaskResult{
+			Success: true,
+			Data:    Fields{"sql": statement, "read_only": IsReadOnlySQL(statement), "dry_run": true},
+		}, nil
+	}
+
+	if !IsReadOnlySQL(statement) {
+		if cfg.ReadOnly {
+			return &TaskResult{Success: false, Error: fmt.Sprintf("database %s is read-only", name)}, nil
+		}
+		return d.requestApproval(task, name, statement), nil
+	}
+
+	result, err := d.query(ctx, name, cfg, statement)
+	if err != nil && isReadOnlyViolation(err) {
+		// Looked read-only but writes, such as SELECT setval(...)
+		if cfg.ReadOnly {
+			return &TaskResult{Success: false, Error: fmt.Sprintf("database %s is read-only", name)}, nil
+		}
+		return d.requestApproval(task, name, statement), nil
+	}
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: Fields{"sql": statement}}, nil
+	}
+	return &TaskResult{Success: true, Data: Fields{"sql": statement, "result": result}}, nil
+}
+
+// query runs a read-only statement, returning at most MaxRows rows. A
+// statement can look read-only and still write, such as SELECT setval(...),
+// so it runs in a read-only transaction that is rolled back; SQLite has no
+// read-only transactions, so its connection is made query_only instead.
+func (d *DatabaseAgentImpl) query(ctx context.Context, name string, cfg DatabaseConfig, statement string) (*QueryResult, error) {
+	db, err := d.conn(name)
+	if err != nil {
+		return nil, err
+	}
+	maxRows := cfg.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if cfg.Driver == "sqlite" || cfg.Driver == "sqlite3" {
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return nil, err
+		}
+		// The connection goes back to the pool, where writes use it
+		defer conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+	}
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, statement)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &QueryResult{Columns: columns, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
+
+// isReadOnlyViolation reports whether err is the database refusing a write
+// in a read-only transaction or on a query_only connection
+func isReadOnlyViolation(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "read-only transaction") || strings.Contains(msg, "read only transaction") ||
+		strings.Contains(msg, "readonly database")
+}
+
+// execWrite runs an approved statement or script in a transaction
+func (d *DatabaseAgentImpl) execWrite(ctx context.Context, name, statement string) (*TaskResult, error) {
+	cfg, ok := d.databases[name]
+	if !ok {
+		return &TaskResult{Success: false, Error: fmt.Sprintf("unknown database %q", name)}, nil
+	}
+	if cfg.ReadOnly {
+		return &TaskResult{Success: false, Error: fmt.Sprintf("database %s is read-only", name)}, nil
+	}
+	db, err := d.conn(name)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	res, err := tx.ExecContext(ctx, statement)
+	if err != nil {
+		tx.Rollback()
+		return &TaskResult{Success: false, Error: fmt.Sprintf("statement failed and was rolled back: %v", err), Data: Fields{"sql": statement}}, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	affected, _ := res.RowsAffected()
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"sql": statement, "database": name, "rows_affected": affected},
+	}, nil
+}
+
+// requestApproval holds a modifying statement until a user approves it
+func (d *DatabaseAgentImpl) requestApproval(task *Task, name, statement string) *TaskResult {
+	risk := &RiskAssessment{Level: RiskHigh, Reasons: []string{"statement may modify the database"}}
+	approval := d.approvals.Request(&Approval{
+		TaskID:  task.ID,
+		Kind:    "sql",
+		Subject: statement,
+		Risk:    risk,
+		Data:    map[string]interface{}{"database": name},
+	})
+	d.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "sql": statement, "database": name},
+	})
+
+	return &TaskResult{
+		Success: false,
+		Error:   "statement modifies the database and requires approval",
+		Data: Fields{
+			"sql":               statement,
+			"database":          name,
+			"requires_approval": true,
+			"approval_id":       approval.ID,
+		},
+	}
+}
+
+// handleMigrate generates an up/down migration pair, writes it under
+// "migrations_dir" (default migrations/) and requests approval to apply it
+func (d *DatabaseAgentImpl) handleMigrate(ctx context.Context, task *Task, name string, cfg DatabaseConfig) (*TaskResult, error) {
+	request := stringField(task.Data, "request")
+	if request == "" {
+		return nil, fmt.Errorf("request not found in task data")
+	}
+	tables, err := d.schema(ctx, name)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	prompt := fmt.Sprintf(`Database dialect: %s
+Current schema:
+%s
+Write a migration for: %s
+Respond with only JSON: {"name": "<short_snake_case_name>", "up": "<SQL applying the change>", "down": "<SQL reverting it>"}`, cfg.Driver, formatSchema(tables), request)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a database expert who writes safe, reversible schema migrations."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate migration: %w", err)
+	}
+	var migration struct {
+		Name string `json:"name"`
+		Up   string `json:"up"`
+		Down string `json:"down"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(response)), &migration); err != nil || migration.Up == "" {
+		return nil, fmt.Errorf("failed to parse migration from LLM response: %v", err)
+	}
+
+	data := Fields{"database": name, "up": migration.Up, "down": migration.Down}
+	if workspaceDir := stringField(task.Data, "workspace_dir"); workspaceDir != "" {
+		dir := stringField(task.Data, "migrations_dir")
+		if dir == "" {
+			dir = "migrations"
+		}
+		base := filepath.Join(workspaceDir, dir, fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102150405"), migrationSlug(migration.Name)))
+		if err := fileManagerFor(ctx, d.fileManager).CreateFile(base+".up.sql", migration.Up+"\n"); err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		if err := fileManagerFor(ctx, d.fileManager).CreateFile(base+".down.sql", migration.Down+"\n"); err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		data["files"] = []string{base + ".up.sql", base + ".down.sql"}
+	}
+
+	if apply, _ := task.Data["apply"].(bool); !apply || cfg.ReadOnly {
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+	result := d.requestApproval(task, name, migration.Up)
+	for k, v := range data {
+		result.Fields()[k] = v
+	}
+	return result, nil
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// migrationSlug turns a migration name into a file-name-safe slug
+func migrationSlug(name string) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "migration"
+	}
+	return slug
+}
+
+// handleExplain runs the dialect's EXPLAIN for a statement and has the LLM
+// interpret the plan
+func (d *DatabaseAgentImpl) handleExplain(ctx context.Context, task *Task, name string, cfg DatabaseConfig) (*TaskResult, error) {
+	statement, err := d.statementSQL(ctx, task, name, cfg)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	if err := checkExplainable(statement); err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: Fields{"sql": statement}}, nil
+	}
+
+	prefix := "EXPLAIN "
+	if cfg.Driver == "sqlite" || cfg.Driver == "sqlite3" {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	// EXPLAIN without ANALYZE only plans the statement, so it is safe even
+	// for writes; it still runs in query's rolled back transaction
+	plan, err := d.query(ctx, name, cfg, prefix+statement)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: Fields{"sql": statement}}, nil
+	}
+	planJSON, _ := json.MarshalIndent(plan.Rows, "", "  ")
+
+	prompt := fmt.Sprintf(`Explain this %s query plan in plain language: how the data is accessed, the expensive steps, and concrete improvements (indexes, rewrites).
+
+Query:
+%s
+
+Plan:
+%s`, cfg.Driver, statement, planJSON)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a database performance expert."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	explanation, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain query plan: %w", err)
+	}
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"sql": statement, "plan": plan, "explanation": explanation},
+	}, nil
+}
+
+// checkExplainable rejects what would make EXPLAIN run the statement, or
+// more than one: EXPLAIN itself, ANALYZE and option lists such as
+// (ANALYZE), and statements separated by semicolons, which lib/pq runs
+// one after another
+func checkExplainable(statement string) error {
+	if strings.Contains(statement, ";") {
+		return fmt.Errorf("explain takes a single statement without semicolons")
+	}
+	cleaned := sqlBlockComment.ReplaceAllString(statement, " ")
+	cleaned = strings.TrimSpace(sqlLineComment.ReplaceAllString(cleaned, " "))
+	if strings.HasPrefix(cleaned, "(") {
+		return fmt.Errorf("pass the statement itself, without EXPLAIN options")
+	}
+	fields := strings.Fields(cleaned)
+	if len(fields) == 0 {
+		return fmt.Errorf("no statement to explain")
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "EXPLAIN", "ANALYZE", "ANALYSE", "VERBOSE":
+		return fmt.Errorf("pass the statement itself, without EXPLAIN or its options")
+	}
+	return nil
+}
+
+var (
+	sqlLineComment  = regexp.MustCompile(`--[^\n]*`)
+	sqlBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlStringLit    = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlIntoKeyword  = regexp.MustCompile(`(?i)\bINTO\b`)
+	sqlWriteKeyword = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|UPSERT|REPLACE|CREATE|ALTER|DROP|TRUNCATE|GRANT|REVOKE|ATTACH|DETACH|VACUUM|REINDEX|COPY|CALL|LOCK|SET)\b`)
+)
+
+// looksLikeSQL reports whether text starts with a common SQL keyword
+func looksLikeSQL(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "SHOW", "DESCRIBE", "VALUES", "INSERT", "UPDATE", "DELETE",
+		"CREATE", "ALTER", "DROP", "TRUNCATE", "MERGE", "REPLACE", "GRANT", "REVOKE":
+		return true
+	}
+	return false
+}
+
+// IsReadOnlySQL reports whether every statement in a script only reads data
+func IsReadOnlySQL(script string) bool {
+	cleaned := sqlBlockComment.ReplaceAllString(script, " ")
+	cleaned = sqlLineComment.ReplaceAllString(cleaned, " ")
+	cleaned = sqlStringLit.ReplaceAllString(cleaned, "''")
+
+	found := false
+	for _, statement := range strings.Split(cleaned, ";") {
+		fields := strings.Fields(statement)
+		if len(fields) == 0 {
+			continue
+		}
+		found = true
+		switch strings.ToUpper(fields[0]) {
+		case "SELECT", "WITH", "SHOW", "DESCRIBE", "DESC", "VALUES", "TABLE":
+		case "EXPLAIN":
+			if len(fields) > 1 && strings.EqualFold(fields[1], "ANALYZE") {
+				return false
+			}
+		default:
+			return false
+		}
+		// Catches data-modifying CTEs and SELECT ... INTO
+		if sqlWriteKeyword.MatchString(statement) || sqlIntoKeyword.MatchString(statement) {
+			return false
+		}
+	}
+	return found
+}
@@ -2,7 +2,10 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
@@ -12,15 +15,44 @@ import (
 type DebugAgentImpl struct {
 	llmClient   LLMClient
 	fileManager FileManager
-	logger      *zap.Logger
+	commandExec CommandExecutor
+	processes   *ProcessManager
+	events      *EventBus
+	// contextBudget is the number of characters of related files gathered
+	// around error locations
+	contextBudget int
+	// budgets cap the code put in prompts per model
+	budgets ContextBudgets
+	// diagnostics runs native checkers (go vet, tsc, ...) before analysis
+	diagnostics bool
+	// web supplies current documentation about errors; nil disables it
+	web WebRetriever
+	// memory recalls and records fixed errors; nil disables it
+	memory *MemoryStore
+	// terminal screens the commands sent with tasks, which the agent runs
+	// unattended, as it screens its own
+	terminal *TerminalAgentImpl
+	// tools runs git for the diffs of applied fixes
+	tools  *ToolRunner
+	logger *zap.Logger
 }
 
 // NewDebugAgent creates a new debug agent
-func NewDebugAgent(llmClient LLMClient, fileManager FileManager, logger *zap.Logger) *DebugAgentImpl {
+func NewDebugAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, processes *ProcessManager, events *EventBus, contextTokens int, budgets ContextBudgets, diagnostics bool, web WebRetriever, memory *MemoryStore, terminal *TerminalAgentImpl, tools *ToolRunner, logger *zap.Logger) *DebugAgentImpl {
 	return &DebugAgentImpl{
-		llmClient:   llmClient,
-		fileManager: fileManager,
-		logger:      logger,
+		llmClient:     llmClient,
+		fileManager:   fileManager,
+		commandExec:   commandExec,
+		processes:     processes,
+		events:        events,
+		contextBudget: contextTokens * charsPerToken,
+		budgets:       budgets,
+		diagnostics:   diagnostics,
+		web:           web,
+		memory:        memory,
+		terminal:      terminal,
+		tools:         tools,
+		logger:        logger,
 	}
 }
 
@@ -33,49 +65,367 @@ func (d *DebugAgentImpl) Type() AgentType {
 func (d *DebugAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
 	d.logger.Info("Debug agent executing task", zap.String("task_id", task.ID))
 
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+
+	if mode, _ := task.Data["mode"].(string); mode == "repair" {
+		return d.repair(ctx, task, workspaceDir)
+	}
+	if _, ok := task.Data["log_file"].(string); ok {
+		return d.analyzeLogs(ctx, task, workspaceDir)
+	}
+	if _, ok := task.Data["process_id"].(string); ok {
+		return d.analyzeLogs(ctx, task, workspaceDir)
+	}
+
 	errorOutput, ok := task.Data["error_output"].(string)
 	if !ok {
 		return nil, fmt.Errorf("error_output not found in task data")
 	}
 
-	workspaceDir, ok := task.Data["workspace_dir"].(string)
-	if !ok {
-		workspaceDir = "."
+	if mode, _ := task.Data["mode"].(string); mode == "explain" {
+		return d.explainError(ctx, errorOutput, workspaceDir)
 	}
 
-	// Try to identify the file with the error
-	filePath, fileContent := d.identifyErrorFile(errorOutput, workspaceDir)
+	// Go panics and race reports get stack-aware handling
+	if crash, ok := ParseGoCrash(errorOutput); ok {
+		return d.analyzeGoCrash(ctx, task, crash, errorOutput, workspaceDir)
+	}
+
+	// Native tooling gives precise locations the pasted error may lack
+	var diagnostics []Diagnostic
+	if enabled, ok := task.Data["diagnostics"].(bool); d.diagnostics && (!ok || enabled) {
+		diagnostics = RunDiagnostics(ctx, d.commandExec, workspaceDir)
+		if len(diagnostics) > 0 {
+			errorOutput += "\n\nDiagnostics from native tools:\n" + formatDiagnostics(diagnostics)
+		}
+	}
+
+	// Locate the files mentioned in the error and gather their snippets
+	locations, fileContent, injections := d.identifyErrorFile(ctx, errorOutput, workspaceDir)
+	// Errors without file locations still name symbols the SearchAgent can find
+	if len(locations) == 0 {
+		fileContent = d.searchRelatedCode(ctx, errorOutput)
+	}
+
+	// Documentation newer than the model's training data helps with
+	// recently changed APIs and tools
+	webContext := retrieveWebContext(ctx, d.web, task.Data, webQuery(errorOutput), d.logger)
+	if webContext != nil {
+		errorOutput += "\n\n" + webContext.Prompt()
+	}
+	// Similar errors fixed before point at their likely cause
+	if memory := d.memory.Prompt(workspaceDir, errorOutput); memory != "" {
+		errorOutput += "\n\n" + memory
+	}
+	filePath := ""
+	if len(locations) > 0 {
+		filePath = locations[0].File
+	}
 
 	// Analyze the error
-	analysis, err := d.llmClient.AnalyzeError(ctx, errorOutput, fileContent)
+	analysis, err := d.llmClient.AnalyzeError(ctx, fenceUntrusted("error output", errorOutput), fileContent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze error: %w", err)
 	}
 
-	// Generate fix
-	fix, err := d.generateFix(ctx, errorOutput, fileContent, analysis)
+	// Generate ranked alternative fixes, falling back to a single fix
+	alternatives := defaultFixCandidates
+	if n, ok := task.Data["alternatives"].(float64); ok && n >= 1 {
+		alternatives = int(n)
+	}
+	var fix string
+	candidates, err := d.generateCandidates(ctx, errorOutput, fileContent, analysis, alternatives)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate fix: %w", err)
+		d.logger.Warn("Falling back to a single fix", zap.Error(err))
+		fix, err = d.generateFix(ctx, errorOutput, fileContent, analysis)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate fix: %w", err)
+		}
+	} else {
+		fix = candidates[0].Code
 	}
 
-	return &TaskResult{
-		Success: true,
-		Data: map[string]interface{}{
-			"analysis": analysis,
-			"fix":      fix,
-			"file":     filePath,
+	debug := &DebugResult{
+		Analysis:          analysis,
+		Fix:               fix,
+		Candidates:        candidates,
+		File:              filePath,
+		Locations:         locations,
+		Diagnostics:       diagnostics,
+		InjectionWarnings: injections,
+	}
+	result := &TaskResult{Success: true, Data: debug}
+	if webContext != nil {
+		debug.WebSources = webContext.Sources
+	}
+	if len(injections) > 0 {
+		d.warnInjections(injections)
+	}
+
+	if apply, _ := task.Data["apply"].(bool); apply {
+		d.applyFix(ctx, task, result, candidates, errorOutput, fileContent, analysis, workspaceDir)
+	}
+
+	return result, nil
+}
+
+// applyFix applies the patches of the chosen candidate (task field
+// "candidate", default the top-ranked one) or, if it has none, asks the
+// LLM for patches. Files are backed up and the failing command (task field
+// "command") is re-run to verify the fix. Unless disabled, a regression
+// guard compares the project's build/test results before and after the fix
+// and rolls it back if new failures appear. Outcomes are recorded in result.
+// A verification command that needs approval holds the whole fix: nothing
+// is applied until the task is resubmitted with the approval_id.
+func (d *DebugAgentImpl) applyFix(ctx context.Context, task *Task, result *TaskResult, candidates []FixCandidate, errorOutput, fileContent, analysis, workspaceDir string) {
+	debug := result.Debug()
+	command, _ := task.Data["command"].(string)
+	if command != "" {
+		approval, err := d.terminal.screenCommand(ctx, task, command, workspaceDir)
+		if err != nil {
+			result.Success = false
+			result.Error = err.Error()
+			return
+		}
+		if approval != nil {
+			result.Success = false
+			result.Error = fmt.Sprintf("verification command requires approval (%s risk)", approval.Risk.Level)
+			debug.RequiresApproval, debug.ApprovalID = true, approval.ID
+			return
+		}
+	}
+
+	var patches []FilePatch
+	choice := 0
+	if n, ok := task.Data["candidate"].(float64); ok {
+		choice = int(n)
+	}
+	if choice >= 0 && choice < len(candidates) {
+		patches = candidates[choice].Patches
+	}
+	if len(patches) == 0 {
+		var err error
+		patches, err = d.generatePatches(ctx, errorOutput, fileContent, analysis)
+		if err != nil {
+			result.Success = false
+			result.Error = err.Error()
+			return
+		}
+	}
+
+	guard, err := d.newRegressionGuard(ctx, task, workspaceDir)
+	if err != nil {
+		result.Success = false
+		result.Error = err.Error()
+		return
+	}
+
+	backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID)
+	applied, err := ApplyPatches(fileManagerFor(ctx, d.fileManager), workspaceDir, backupDir, patches)
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Sprintf("failed to apply fix: %v", err)
+		return
+	}
+	debug.Patches, debug.Applied = patches, applied
+	defer func() {
+		if result.Success {
+			d.rememberFix(task, workspaceDir, errorOutput, analysis, applied.Files)
+		}
+	}()
+
+	if guard != nil {
+		report, err := guard.check(ctx, d.commandExec, d.tools, applied, workspaceDir)
+		if report != nil {
+			debug.Regression = report
+		}
+		if err != nil {
+			result.Success = false
+			result.Error = err.Error()
+			return
+		}
+		if report.RolledBack {
+			result.Success = false
+			result.Error = fmt.Sprintf("fix introduced new failures in %q and was rolled back", report.Command)
+			return
+		}
+	}
+
+	if command == "" {
+		return
+	}
+	verification, err := d.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Sprintf("failed to verify fix: %v", err)
+		return
+	}
+	debug.Verification = verification
+	debug.Verified = verification.Status == "completed"
+	result.Success = verification.Status == "completed"
+}
+
+// rememberFix records a fixed error in the workspace memory so the same
+// error is recognized later
+func (d *DebugAgentImpl) rememberFix(task *Task, workspaceDir, errorOutput, analysis string, files []string) {
+	if d.memory == nil {
+		return
+	}
+	errorLine := ""
+	for _, line := range strings.Split(errorOutput, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			errorLine = truncateString(line, 200)
+			break
+		}
+	}
+	cause := strings.TrimSpace(strings.SplitN(strings.TrimSpace(analysis), "\n", 2)[0])
+	content := fmt.Sprintf("%q was fixed by changing %s: %s", errorLine, strings.Join(files, ", "), truncateString(cause, 300))
+	if _, err := d.memory.Add(workspaceDir, MemoryEntry{Kind: MemoryFixedError, Content: content, TaskID: task.ID}); err != nil {
+		d.logger.Warn("Failed to remember fix", zap.Error(err))
+	}
+}
+
+// generatePatches asks the LLM for concrete file patches implementing the fix
+func (d *DebugAgentImpl) generatePatches(ctx context.Context, errorOutput, fileContent, analysis string) ([]FilePatch, error) {
+	prompt := fmt.Sprintf(`Error output:
+%s
+
+Relevant source (numbered lines, the error line is marked with >):
+%s
+
+Analysis:
+%s
+
+Respond with only a JSON array of patches that fix the error. Each patch is
+{"path": "<file path as shown above>", "search": "<exact existing text to replace>", "replace": "<new text>"}
+or, to create or fully rewrite a file, {"path": "...", "content": "<entire new file>"}.
+Search text must match the file exactly, without line numbers. Keep patches minimal.`, fenceUntrusted("error output", errorOutput), fileContent, analysis)
+
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "You are an expert debugger. Produce minimal, exact file patches as JSON.",
 		},
-	}, nil
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt,
+		},
+	}
+
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate patches: %w", err)
+	}
+	patches, err := parsePatches(response)
+	if err != nil {
+		return nil, err
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("LLM returned no patches")
+	}
+	return patches, nil
 }
 
-// identifyErrorFile tries to identify the file containing the error
-func (d *DebugAgentImpl) identifyErrorFile(_, _ string) (string, string) {
-	// This is a simplified implementation
-	// In a real implementation, you would parse the error output
-	// to extract file paths and line numbers
+// charsPerToken approximates prompt size in tokens from characters
+const charsPerToken = 4
+
+// maxErrorLocations caps how many locations are read and sent to the LLM
+const maxErrorLocations = 5
 
-	// For now, return empty strings
-	return "", ""
+// snippetContextLines is the number of lines shown around an error line
+const snippetContextLines = 10
+
+// identifyErrorFile parses file locations out of the error output and
+// returns the locations inside the workspace together with numbered source
+// snippets around each of them and related code, within the model's
+// context budget. Parts of the error output or snippets that appear to
+// instruct the model are returned as injections.
+func (d *DebugAgentImpl) identifyErrorFile(ctx context.Context, errorOutput, workspaceDir string) ([]ErrorLocation, string, []InjectionWarning) {
+	var locations []ErrorLocation
+	assembler := NewContextAssembler(d.budgets.For(d.llmClient.GetModel()), d.llmClient, d.logger)
+
+	for _, loc := range ParseErrorLocations(errorOutput) {
+		if len(locations) == maxErrorLocations {
+			break
+		}
+		path, ok := resolveInWorkspace(workspaceDir, loc.File)
+		if !ok {
+			continue
+		}
+		content, err := fileManagerFor(ctx, d.fileManager).ReadFile(path)
+		if err != nil {
+			d.logger.Debug("Skipping unreadable error location", zap.String("file", path), zap.Error(err))
+			continue
+		}
+
+		loc.File = path
+		locations = append(locations, loc)
+		assembler.Add(ContextPiece{
+			Label:     fmt.Sprintf("%s:%d", path, loc.Line),
+			Content:   snippetAround(content, loc.Line, snippetContextLines),
+			Priority:  PriorityCritical,
+			Required:  true,
+			Untrusted: true,
+		})
+	}
+
+	// Spend the remaining budget on callers and imports of the failing code
+	if d.contextBudget > 0 && len(locations) > 0 {
+		gatherer := newRelatedContextGatherer(fileManagerFor(ctx, d.fileManager), workspaceDir, d.contextBudget)
+		for _, loc := range locations {
+			gatherer.exclude(loc.File)
+		}
+		for _, loc := range locations {
+			gatherer.gather(loc)
+		}
+		for _, piece := range gatherer.pieces {
+			assembler.Add(piece)
+		}
+	}
+
+	injections := detectInjection("error output", errorOutput)
+	if assembler.Len() == 0 {
+		return locations, "", injections
+	}
+	snippets, report := assembler.Assemble(ctx)
+	return locations, snippets, append(injections, report.Injections...)
+}
+
+// warnInjections logs content that appears to instruct the model
+func (d *DebugAgentImpl) warnInjections(injections []InjectionWarning) {
+	for _, injection := range injections {
+		d.logger.Warn("Content appears to contain instructions to the model",
+			zap.String("source", injection.Source),
+			zap.String("excerpt", injection.Excerpt))
+	}
+}
+
+// searchRelatedCode hands the error to the SearchAgent to find the code
+// involved, returning its answer with citations, or "" if it found nothing
+func (d *DebugAgentImpl) searchRelatedCode(ctx context.Context, errorOutput string) string {
+	result, err := HandOff(ctx, SearchAgent, "Find code related to an error", map[string]interface{}{
+		"question": "Where are the functions, types or settings involved in this error defined?\n" + truncateString(errorOutput, 2000),
+	})
+	if err != nil {
+		if !errors.Is(err, ErrHandoffUnavailable) {
+			d.logger.Debug("Search handoff failed", zap.Error(err))
+		}
+		return ""
+	}
+	citations, _ := result.Fields()["citations"].([]Citation)
+	if !result.Success || len(citations) == 0 {
+		return ""
+	}
+	answer, _ := result.Fields()["answer"].(string)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Related code found by searching the workspace:\n%s\n", answer)
+	for _, citation := range citations {
+		fmt.Fprintf(&b, "- %s:%d-%d\n", citation.File, citation.StartLine, citation.EndLine)
+	}
+	return b.String()
 }
 
 // generateFix generates a fix for the error
@@ -87,7 +437,7 @@ func (d *DebugAgentImpl) generateFix(ctx context.Context, errorOutput, _, analys
 And the original error:
 %s
 
-Generate the corrected code. Provide only the fixed code, no explanations.`, analysis, errorOutput)
+Generate the corrected code. Provide only the fixed code, no explanations.`, analysis, fenceUntrusted("error output", errorOutput))
 
 	messages := []openai.ChatCompletionMessage{
 		{
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// relatedContextGatherer collects files related to an error location —
+// imported local files and callers of the failing function — as context
+// pieces, within a character budget
+type relatedContextGatherer struct {
+	fileManager  FileManager
+	workspaceDir string
+	budget       int
+	used         int
+	included     map[string]bool
+	pieces       []ContextPiece
+}
+
+// maxCallerFiles bounds the number of files scanned for callers
+const maxCallerFiles = 2000
+
+var (
+	goImportBlock  = regexp.MustCompile(`(?s)import\s*\((.*?)\)`)
+	goImportSingle = regexp.MustCompile(`(?m)^import\s+(?:\w+\s+)?"([^"]+)"`)
+	quotedString   = regexp.MustCompile(`"([^"]+)"`)
+	jsImport       = regexp.MustCompile(`(?m)(?:from\s+|require\(\s*|import\s+)['"](\.{1,2}/[^'"]+)['"]`)
+	pyImport       = regexp.MustCompile(`(?m)^\s*(?:from\s+(\.*[\w.]+)\s+import|import\s+([\w.]+))`)
+	goModule       = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+
+	funcDeclPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^func\s+(?:\([^)]*\)\s*)?(\w+)\s*[\[(]`),
+		regexp.MustCompile(`^\s*(?:async\s+)?def\s+(\w+)\s*\(`),
+		regexp.MustCompile(`^\s*(?:export\s+)?(?:async\s+)?function\s*\*?\s*(\w+)\s*\(`),
+		regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+(\w+)\s*=\s*(?:async\s*)?\(`),
+		regexp.MustCompile(`^\s*(?:pub\s+)?(?:async\s+)?fn\s+(\w+)`),
+	}
+)
+
+func newRelatedContextGatherer(fileManager FileManager, workspaceDir string, budget int) *relatedContextGatherer {
+	return &relatedContextGatherer{
+		fileManager:  fileManager,
+		workspaceDir: workspaceDir,
+		budget:       budget,
+		included:     make(map[string]bool),
+	}
+}
+
+// exclude marks files already present in the prompt
+func (g *relatedContextGatherer) exclude(path string) {
+	g.included[path] = true
+}
+
+// add appends a piece of context if it fits in the remaining budget
+func (g *relatedContextGatherer) add(header, body string, priority int) bool {
+	piece := ContextPiece{Label: header, Content: body, Priority: priority, Summarize: true, Untrusted: true}
+	if size := len(piece.text()); g.used+size <= g.budget {
+		g.pieces = append(g.pieces, piece)
+		g.used += size
+		return true
+	}
+	return false
+}
+
+// gather collects context for a location: its callers first, since they
+// show how the failing code is used, then the local files it imports
+func (g *relatedContextGatherer) gather(loc ErrorLocation) {
+	content, err := g.fileManager.ReadFile(loc.File)
+	if err != nil {
+		return
+	}
+
+	if name := enclosingFunction(content, loc.Line); name != "" {
+		g.gatherCallers(name, loc.File)
+	}
+	for _, imported := range g.localImports(loc.File, content) {
+		if g.included[imported] {
+			continue
+		}
+		body, err := g.fileManager.ReadFile(imported)
+		if err != nil {
+			continue
+		}
+		g.included[imported] = true
+		if !g.add("imported by "+filepath.Base(loc.File)+": "+imported, body, PriorityLow) {
+			// Whole file doesn't fit; fall back to its head
+			g.add("imported (truncated): "+imported, snippetAround(body, 1, 40), PriorityLow)
+		}
+	}
+}
+
+// gatherCallers adds snippets around calls to name in files of the same language
+func (g *relatedContextGatherer) gatherCallers(name, definingFile string) {
+	call := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\s*\(`)
+	ext := filepath.Ext(definingFile)
+	scanned := 0
+
+	filepath.WalkDir(g.workspaceDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ext || scanned >= maxCallerFiles || g.used >= g.budget {
+			return nil
+		}
+		scanned++
+
+		content, err := g.fileManager.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		for i, line := range strings.Split(content, "\n") {
+			if !call.MatchString(line) || declaredFunction(line) == name {
+				continue
+			}
+			abs, _ := filepath.Abs(path)
+			g.add(fmt.Sprintf("caller of %s: %s:%d", name, abs, i+1), snippetAround(content, i+1, 5), PriorityNormal)
+			break
+		}
+		return nil
+	})
+}
+
+// localImports resolves the workspace files imported by a source file
+func (g *relatedContextGatherer) localImports(file, content string) []string {
+	dir := filepath.Dir(file)
+	var imports []string
+
+	switch filepath.Ext(file) {
+	case ".go":
+		module := g.goModulePath()
+		if module == "" {
+			return nil
+		}
+		var paths []string
+		for _, block := range goImportBlock.FindAllStringSubmatch(content, -1) {
+			for _, m := range quotedString.FindAllStringSubmatch(block[1], -1) {
+				paths = append(paths, m[1])
+			}
+		}
+		for _, m := range goImportSingle.FindAllStringSubmatch(content, -1) {
+			paths = append(paths, m[1])
+		}
+		for _, p := range paths {
+			if !strings.HasPrefix(p, module+"/") {
+				continue
+			}
+			pkgDir := filepath.Join(g.workspaceDir, strings.TrimPrefix(p, module+"/"))
+			matches, _ := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+			for _, m := range matches {
+				if !strings.HasSuffix(m, "_test.go") {
+					imports = append(imports, absPath(m))
+				}
+			}
+		}
+	case ".js", ".jsx", ".ts", ".tsx", ".mjs", ".cjs":
+		for _, m := range jsImport.FindAllStringSubmatch(content, -1) {
+			base := filepath.Join(dir, m[1])
+			for _, candidate := range []string{base, base + ".ts", base + ".tsx", base + ".js", base + ".jsx", filepath.Join(base, "index.ts"), filepath.Join(base, "index.js")} {
+				if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+					imports = append(imports, absPath(candidate))
+					break
+				}
+			}
+		}
+	case ".py":
+		for _, m := range pyImport.FindAllStringSubmatch(content, -1) {
+			module := m[1]
+			if module == "" {
+				module = m[2]
+			}
+			rel := strings.ReplaceAll(strings.TrimLeft(module, "."), ".", string(filepath.Separator)) + ".py"
+			for _, root := range []string{dir, g.workspaceDir} {
+				candidate := filepath.Join(root, rel)
+				if _, err := os.Stat(candidate); err == nil {
+					imports = append(imports, absPath(candidate))
+					break
+				}
+			}
+		}
+	}
+	return imports
+}
+
+// goModulePath reads the module path from the workspace go.mod
+func (g *relatedContextGatherer) goModulePath() string {
+	data, err := os.ReadFile(filepath.Join(g.workspaceDir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	if m := goModule.FindSubmatch(data); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// enclosingFunction finds the name of the function declared closest above line
+func enclosingFunction(content string, line int) string {
+	lines := strings.Split(content, "\n")
+	if line > len(lines) {
+		line = len(lines)
+	}
+	for i := line - 1; i >= 0; i-- {
+		if name := declaredFunction(lines[i]); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// declaredFunction returns the function declared on a line, if any
+func declaredFunction(line string) string {
+	for _, pattern := range funcDeclPatterns {
+		if m := pattern.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// skipDir reports directories that never contain relevant source
+func skipDir(name string) bool {
+	switch name {
+	case ".git", "node_modules", "vendor", "dist", "build", "target", "__pycache__", ".venv", "venv", ".spilot":
+		return true
+	}
+	return false
+}
+
+// absPath returns the absolute form of path, or path itself on error
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Diagnostic is a structured finding reported by a native toolchain
+type Diagnostic struct {
+	Tool     string `json:"tool"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+	Language string `json:"language"`
+}
+
+// diagnosticTool is a native checker run before asking the LLM
+type diagnosticTool struct {
+	name    string
+	command string
+	// applies reports whether the tool is relevant to the workspace
+	applies func(workspaceDir string) bool
+}
+
+var diagnosticTools = []diagnosticTool{
+	{"go vet", "go vet ./...", func(dir string) bool { return fileExists(dir, "go.mod") && onPath("go") }},
+	{"go build", "go build -gcflags=-e -o " + os.DevNull + " ./...", func(dir string) bool { return fileExists(dir, "go.mod") && onPath("go") }},
+	{"tsc", "tsc --noEmit --pretty false", func(dir string) bool { return fileExists(dir, "tsconfig.json") && onPath("tsc") }},
+	{"pyflakes", "pyflakes .", func(dir string) bool { return hasFileWithExt(dir, ".py") && onPath("pyflakes") }},
+}
+
+// RunDiagnostics runs every applicable native checker in workspaceDir and
+// returns their findings
+func RunDiagnostics(ctx context.Context, commandExec CommandExecutor, workspaceDir string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, tool := range diagnosticTools {
+		if !tool.applies(workspaceDir) {
+			continue
+		}
+		result, err := commandExec.ExecuteCommand(ctx, tool.command, workspaceDir, CommandOptions{})
+		if err != nil || result.Status == "completed" {
+			continue
+		}
+		for _, loc := range ParseErrorLocations(result.Output + "\n" + result.Error) {
+			diagnostics = append(diagnostics, Diagnostic{
+				Tool:     tool.name,
+				File:     loc.File,
+				Line:     loc.Line,
+				Column:   loc.Column,
+				Message:  loc.Message,
+				Language: loc.Language,
+			})
+		}
+	}
+	return diagnostics
+}
+
+// formatDiagnostics renders diagnostics in compiler style for prompts, so
+// the locations parse like any other error output
+func formatDiagnostics(diagnostics []Diagnostic) string {
+	var b strings.Builder
+	for _, d := range diagnostics {
+		fmt.Fprintf(&b, "%s:%d:%d: %s (%s)\n", d.File, d.Line, d.Column, d.Message, d.Tool)
+	}
+	return b.String()
+}
+
+// fileExists reports whether name exists in dir
+func fileExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+// onPath reports whether a binary is installed
+func onPath(binary string) bool {
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
+
+// hasFileWithExt reports whether dir (not recursively) contains a file with ext
+func hasFileWithExt(dir, ext string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+ext))
+	return len(matches) > 0
+}
@@ -0,0 +1,431 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultAPIDocsPath is where API docs are written when "output" is not set
+	defaultAPIDocsPath = "docs/API.md"
+	// maxDocsContextChars bounds the source sent to the LLM for README and API docs
+	maxDocsContextChars = 24000
+)
+
+// DocsAgent generates and updates documentation. Changes are written
+// through the FileAgent and returned with diff previews.
+type DocsAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	fileAgent   Agent
+	tools       *ToolRunner
+	logger      *zap.Logger
+}
+
+// NewDocsAgent creates a new docs agent that writes through fileAgent and
+// previews changes with git run by tools
+func NewDocsAgent(llmClient LLMClient, fileManager FileManager, fileAgent Agent, tools *ToolRunner, logger *zap.Logger) *DocsAgentImpl {
+	return &DocsAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		fileAgent:   fileAgent,
+		tools:       tools,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (d *DocsAgentImpl) Type() AgentType {
+	return DocsAgent
+}
+
+// Execute executes a documentation task. Operations:
+//   - comments: add or update doc comments in "path"
+//   - readme: write the README section named "section"
+//   - api: generate API docs for the package at "path" into "output"
+//
+// With "preview" the diff is returned without writing anything.
+func (d *DocsAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	d.logger.Info("Docs agent executing task", zap.String("task_id", task.ID))
+
+	operation, ok := task.Data["operation"].(string)
+	if !ok {
+		return nil, fmt.Errorf("operation data not found in task")
+	}
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		return nil, fmt.Errorf("workspace_dir not found in task data")
+	}
+
+	switch operation {
+	case "comments":
+		return d.handleComments(ctx, task, workspaceDir)
+	case "readme":
+		return d.handleReadme(ctx, task, workspaceDir)
+	case "api":
+		return d.handleAPI(ctx, task, workspaceDir)
+	default:
+		return nil, fmt.Errorf("unknown docs operation: %s", operation)
+	}
+}
+
+func (d *DocsAgentImpl) handleComments(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	path := stringField(task.Data, "path")
+	if path == "" {
+		return nil, fmt.Errorf("path not found in task data")
+	}
+	original, err := fileManagerFor(ctx, d.fileManager).ReadFile(filepath.Join(workspaceDir, path))
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	prompt := fmt.Sprintf(`Add or improve documentation comments in %s for every exported or public declaration that lacks a useful one.
+Use the idiomatic doc-comment style of the language. Keep existing accurate comments. Do not change any code.
+Respond with only the complete updated file.
+
+%s`, path, original)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a technical writer who documents code concisely and accurately."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate doc comments: %w", err)
+	}
+	updated := stripCodeFence(response)
+
+	// Go files are verified to differ only in comments
+	if filepath.Ext(path) == ".go" && !sameGoCode(original, updated) {
+		return &TaskResult{Success: false, Error: "generated documentation changed code, not only comments"}, nil
+	}
+	return d.write(ctx, task, workspaceDir, path, &original, updated)
+}
+
+// readmeHeading matches Markdown ATX headings
+var readmeHeading = regexp.MustCompile(`(?m)^(#{1,6})\s+(.+?)\s*#*\s*$`)
+
+func (d *DocsAgentImpl) handleReadme(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	section := stringField(task.Data, "section")
+	if section == "" {
+		return nil, fmt.Errorf("section not found in task data")
+	}
+	path := stringField(task.Data, "path")
+	if path == "" {
+		path = "README.md"
+	}
+
+	var original *string
+	readme := ""
+	if content, err := fileManagerFor(ctx, d.fileManager).ReadFile(filepath.Join(workspaceDir, path)); err == nil {
+		original, readme = &content, content
+	}
+	start, end, level := findReadmeSection(readme, section)
+	current := ""
+	if start >= 0 {
+		current = readme[start:end]
+	}
+
+	prompt := fmt.Sprintf(`Write the %q section of the project README based on the code below.
+Start with a level-%d Markdown heading "%s". Be accurate; do not invent features.
+Current section (may be empty or outdated):
+%s
+
+Project files and excerpts:
+%s
+
+Respond with only the Markdown for this section.`, section, level, section, current, d.projectContext(ctx, workspaceDir))
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a technical writer producing clear, accurate README documentation."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate README section: %w", err)
+	}
+	body := strings.TrimRight(stripCodeFence(response), "\n") + "\n"
+
+	var updated string
+	switch {
+	case start >= 0:
+		updated = readme[:start] + body + readme[end:]
+	case readme == "":
+		updated = body
+	default:
+		updated = strings.TrimRight(readme, "\n") + "\n\n" + body
+	}
+	return d.write(ctx, task, workspaceDir, path, original, updated)
+}
+
+// findReadmeSection locates the section titled name (case-insensitive),
+// returning its byte range and heading level. start is -1 if it is
+// missing, in which case level is the one a new section should use.
+func findReadmeSection(readme, name string) (start, end, level int) {
+	headings := readmeHeading.FindAllStringSubmatchIndex(readme, -1)
+	for i, h := range headings {
+		title := readme[h[4]:h[5]]
+		if !strings.EqualFold(strings.TrimSpace(title), name) {
+			continue
+		}
+		level = h[3] - h[2]
+		end = len(readme)
+		for _, next := range headings[i+1:] {
+			if next[3]-next[2] <= level {
+				end = next[0]
+				break
+			}
+		}
+		return h[0], end, level
+	}
+	return -1, -1, 2
+}
+
+func (d *DocsAgentImpl) handleAPI(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	path := stringField(task.Data, "path")
+	if path == "" {
+		path = "."
+	}
+	output := stringField(task.Data, "output")
+	if output == "" {
+		output = defaultAPIDocsPath
+	}
+	dir := filepath.Join(workspaceDir, path)
+
+	var docs string
+	if goFiles, _ := filepath.Glob(filepath.Join(dir, "*.go")); len(goFiles) > 0 {
+		rendered, err := renderGoAPIDocs(dir)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		docs = rendered
+	} else {
+		prompt := fmt.Sprintf(`Write Markdown API reference documentation for the public interface of the code below:
+modules, classes, functions and endpoints with their parameters, return values and short examples.
+Respond with only the Markdown.
+
+%s`, d.projectContext(ctx, dir))
+		messages := []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "You are a technical writer producing precise API reference documentation."},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		}
+		response, err := d.llmClient.Chat(ctx, messages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate API docs: %w", err)
+		}
+		docs = stripCodeFence(response)
+	}
+
+	var original *string
+	if content, err := fileManagerFor(ctx, d.fileManager).ReadFile(filepath.Join(workspaceDir, output)); err == nil {
+		original = &content
+	}
+	return d.write(ctx, task, workspaceDir, output, original, docs)
+}
+
+// renderGoAPIDocs renders the exported API of the Go package in dir as Markdown
+func renderGoAPIDocs(dir string) (string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", dir, err)
+	}
+	if len(pkgs) == 0 {
+		return "", fmt.Errorf("no Go package in %s", dir)
+	}
+
+	var out strings.Builder
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var files []*ast.File
+		for _, file := range pkgs[name].Files {
+			files = append(files, file)
+		}
+		p, err := doc.NewFromFiles(fset, files, name)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&out, "# Package %s\n\n", p.Name)
+		if p.Doc != "" {
+			out.WriteString(p.Doc + "\n")
+		}
+		writeGoValues(&out, fset, "Constants", p.Consts)
+		writeGoValues(&out, fset, "Variables", p.Vars)
+		if len(p.Funcs) > 0 {
+			out.WriteString("\n## Functions\n")
+			for _, fn := range p.Funcs {
+				writeGoDecl(&out, fset, "### func "+fn.Name, fn.Decl, fn.Doc)
+			}
+		}
+		if len(p.Types) > 0 {
+			out.WriteString("\n## Types\n")
+			for _, t := range p.Types {
+				writeGoDecl(&out, fset, "### type "+t.Name, t.Decl, t.Doc)
+				for _, fn := range t.Funcs {
+					writeGoDecl(&out, fset, "#### func "+fn.Name, fn.Decl, fn.Doc)
+				}
+				for _, m := range t.Methods {
+					writeGoDecl(&out, fset, fmt.Sprintf("#### func (%s) %s", t.Name, m.Name), m.Decl, m.Doc)
+				}
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func writeGoValues(out *strings.Builder, fset *token.FileSet, title string, values []*doc.Value) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n## %s\n", title)
+	for _, v := range values {
+		writeGoDecl(out, fset, "", v.Decl, v.Doc)
+	}
+}
+
+// writeGoDecl writes a declaration's signature (without body) and its doc
+func writeGoDecl(out *strings.Builder, fset *token.FileSet, heading string, decl ast.Node, docText string) {
+	if fn, ok := decl.(*ast.FuncDecl); ok {
+		copied := *fn
+		copied.Body, copied.Doc = nil, nil
+		decl = &copied
+	}
+	var buf bytes.Buffer
+	(&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(&buf, fset, decl)
+
+	if heading != "" {
+		out.WriteString("\n" + heading + "\n")
+	}
+	fmt.Fprintf(out, "\n```go\n%s\n```\n", buf.String())
+	if docText != "" {
+		out.WriteString("\n" + docText)
+	}
+}
+
+// projectContext lists the files under dir with excerpts, within a budget
+func (d *DocsAgentImpl) projectContext(ctx context.Context, dir string) string {
+	files, err := fileManagerFor(ctx, d.fileManager).ListFiles(dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	var kept []string
+	for _, file := range files {
+		if parts := strings.Split(filepath.ToSlash(file), "/"); len(parts) > 1 && (skipDir(parts[0]) || strings.HasPrefix(parts[0], ".")) {
+			continue
+		}
+		kept = append(kept, file)
+	}
+	b.WriteString("Files:\n" + strings.Join(kept, "\n") + "\n\n")
+
+	for _, file := range kept {
+		if b.Len() >= maxDocsContextChars {
+			break
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".go", ".py", ".js", ".ts", ".tsx", ".rs", ".java", ".rb", ".md", ".yaml", ".yml", ".toml", ".json":
+		default:
+			continue
+		}
+		head, err := fileManagerFor(ctx, d.fileManager).ReadHead(filepath.Join(dir, file), 60)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "--- %s\n%s\n", file, head)
+	}
+	return truncateString(b.String(), maxDocsContextChars)
+}
+
+// write saves content through the FileAgent unless "preview" is set,
+// returning a diff preview either way
+func (d *DocsAgentImpl) write(ctx context.Context, task *Task, workspaceDir, path string, original *string, content string) (*TaskResult, error) {
+	diff := previewDiff(ctx, d.tools, path, original, content)
+	if original != nil && *original == content {
+		return &TaskResult{Success: true, Data: Fields{"path": path, "changed": false}}, nil
+	}
+	if preview, _ := task.Data["preview"].(bool); preview {
+		return &TaskResult{
+			Success: true,
+			Data:    Fields{"path": path, "diff": diff, "content": content, "preview": true},
+		}, nil
+	}
+
+	operation := "update"
+	if original == nil {
+		operation = "create"
+	}
+	result, err := d.fileAgent.Execute(ctx, &Task{
+		ID:          task.ID + "_write",
+		Type:        FileAgent,
+		Description: "Write documentation to " + path,
+		Data: map[string]interface{}{
+			"operation":     operation,
+			"path":          path,
+			"content":       content,
+			"workspace_dir": workspaceDir,
+		},
+	})
+	if err != nil || !result.Success {
+		return result, err
+	}
+	data := Fields{"path": path, "operation": operation, "diff": diff, "changed": true}
+	if written := result.FileOp(); written != nil {
+		data["path"], data["formatted"] = written.Path, written.Formatted
+	}
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// sameGoCode reports whether two Go sources have identical tokens once
+// comments are ignored
+func sameGoCode(a, b string) bool {
+	tokens := func(src string) []string {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var s scanner.Scanner
+		s.Init(file, []byte(src), nil, 0)
+		var out []string
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				return out
+			}
+			// Automatic semicolons depend on comment placement; skip them
+			if tok == token.SEMICOLON && lit == "\n" {
+				continue
+			}
+			out = append(out, tok.String()+lit)
+		}
+	}
+	ta, tb := tokens(a), tokens(b)
+	if len(ta) != len(tb) {
+		return false
+	}
+	for i := range ta {
+		if ta[i] != tb[i] {
+			return false
+		}
+	}
+	return true
+}
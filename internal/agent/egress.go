@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Egress modes for executed commands
+const (
+	// EgressOpen leaves network access unrestricted
+	EgressOpen = "open"
+	// EgressNone runs commands without any network access
+	EgressNone = "none"
+	// EgressProxy points commands at a proxy that only lets allowlisted
+	// hosts through. It is not enforced: commands that ignore the proxy
+	// variables, or open sockets themselves, reach any host.
+	EgressProxy = "proxy"
+)
+
+// egressDialTimeout bounds connecting to an allowed host through the proxy
+const egressDialTimeout = 30 * time.Second
+
+// EgressConfig restricts the network access of executed commands
+type EgressConfig struct {
+	Mode string
+	// AllowedHosts are glob patterns (path.Match syntax) of the hosts the
+	// proxy connects to, e.g. "proxy.golang.org" or "*.npmjs.org"
+	AllowedHosts []string
+	// ProxyListen is the proxy's listen address; ProxyURL is the address
+	// commands are given, when it differs (for example from a container)
+	ProxyListen string
+	ProxyURL    string
+}
+
+// Egress applies the configured network restrictions to commands. A nil
+// Egress leaves commands unrestricted.
+type Egress struct {
+	mode     string
+	allowed  []string
+	proxyURL string
+	server   *http.Server
+	logger   *zap.Logger
+}
+
+// NewEgress sets up network restrictions for cfg, starting the filtering
+// proxy in proxy mode, or returns nil if network access is open. Even with
+// the sandbox, git, formatters, ctags and plugins run on the host, so
+// network isolation must be available there.
+func NewEgress(cfg EgressConfig, logger *zap.Logger) (*Egress, error) {
+	switch cfg.Mode {
+	case "", EgressOpen:
+		return nil, nil
+	case EgressNone:
+		if err := checkNetworkIsolation(); err != nil {
+			return nil, err
+		}
+		return &Egress{mode: EgressNone, logger: logger}, nil
+	case EgressProxy:
+	default:
+		return nil, fmt.Errorf("unknown egress mode: %s", cfg.Mode)
+	}
+
+	listen := cfg.ProxyListen
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start egress proxy: %w", err)
+	}
+	e := &Egress{
+		mode:     EgressProxy,
+		allowed:  cfg.AllowedHosts,
+		proxyURL: cfg.ProxyURL,
+		logger:   logger,
+	}
+	if e.proxyURL == "" {
+		e.proxyURL = "http://" + listener.Addr().String()
+	}
+	e.server = &http.Server{Handler: e, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := e.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Egress proxy stopped", zap.Error(err))
+		}
+	}()
+	logger.Info("Egress proxy listening", zap.String("address", listener.Addr().String()), zap.Strings("allowed_hosts", cfg.AllowedHosts))
+	logger.Warn("Egress proxy mode is not enforced: commands that ignore http_proxy/https_proxy reach any host; use mode none to cut them off the network")
+	return e, nil
+}
+
+// Close stops the proxy
+func (e *Egress) Close() {
+	if e == nil || e.server == nil {
+		return
+	}
+	e.server.Close()
+}
+
+// isolated reports whether commands run without network access
+func (e *Egress) isolated() bool {
+	return e != nil && e.mode == EgressNone
+}
+
+// env adds the proxy variables to a command's extra environment. Both
+// spellings are set since curl only reads the lower case ones.
+func (e *Egress) env(extra map[string]string) map[string]string {
+	if e == nil || e.mode != EgressProxy {
+		return extra
+	}
+	env := make(map[string]string, len(extra)+8)
+	for k, v := range extra {
+		env[k] = v
+	}
+	for _, name := range []string{"http_proxy", "https_proxy", "all_proxy"} {
+		env[name] = e.proxyURL
+		env[strings.ToUpper(name)] = e.proxyURL
+	}
+	env["no_proxy"] = ""
+	env["NO_PROXY"] = ""
+	return env
+}
+
+// apply restricts cmd, which must already have its environment set
+func (e *Egress) apply(cmd *exec.Cmd) error {
+	if e.isolated() {
+		return isolateNetwork(cmd)
+	}
+	return nil
+}
+
+// allows reports whether the proxy may connect to host
+func (e *Egress) allows(host string) bool {
+	return matchesAny(e.allowed, strings.TrimSuffix(host, "."))
+}
+
+// ServeHTTP proxies CONNECT tunnels and plain HTTP requests to allowed hosts
+func (e *Egress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	if r.Method == http.MethodConnect {
+		host, _, _ = net.SplitHostPort(r.Host)
+	}
+	if host == "" || !e.allows(host) {
+		e.logger.Warn("Blocked command network access", zap.String("host", host), zap.String("method", r.Method))
+		http.Error(w, fmt.Sprintf("spilot: network access to %s is not allowed", host), http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodConnect {
+		if r.URL.Scheme != "http" {
+			http.Error(w, "spilot: only absolute http URLs can be proxied", http.StatusBadRequest)
+			return
+		}
+		proxy := &httputil.ReverseProxy{Director: func(*http.Request) {}, ErrorLog: zap.NewStdLog(e.logger)}
+		proxy.ServeHTTP(w, r)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, egressDialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "spilot: tunnelling is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	go tunnel(upstream, client)
+	tunnel(client, upstream)
+}
+
+// tunnel copies src to dst, then closes both
+func tunnel(dst, src net.Conn) {
+	defer dst.Close()
+	defer src.Close()
+	io.Copy(dst, src)
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultEmbeddingBatchSize is how many chunks an embedding request
+	// carries at most; OpenAI accepts up to 2048 inputs
+	defaultEmbeddingBatchSize = 128
+	// defaultEmbeddingBatchBytes bounds the text of an embedding request,
+	// well under the token limit of a request at about four bytes a token
+	defaultEmbeddingBatchBytes = 512 << 10
+	// defaultEmbeddingConcurrency is how many embedding requests are in
+	// flight at once
+	defaultEmbeddingConcurrency = 4
+)
+
+// pendingFile is a changed file whose chunks are being embedded. It is
+// written to the store once every chunk has its vector.
+type pendingFile struct {
+	rel         string
+	info        fs.FileInfo
+	hash        string
+	indexedHash string
+	chunks      []CodeChunk
+	// missing are the chunks to embed, of which left are not yet embedded
+	missing []int
+	left    int
+}
+
+// embedItem is a chunk of a pending file to embed
+type embedItem struct {
+	file  *pendingFile
+	chunk int
+	text  string
+}
+
+// embedBatcher embeds the chunks of the files of an indexing pass in
+// batches, which may span files, sent by a pool of workers at the rate the
+// index allows
+type embedBatcher struct {
+	ci     *CodeIndex
+	ctx    context.Context
+	cancel context.CancelFunc
+	work   chan []embedItem
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	// batch is being filled, with bytes of text
+	batch []embedItem
+	bytes int
+
+	// mu serializes writing files to the store and guards what follows
+	mu    sync.Mutex
+	stats *IndexStats
+	// churn counts the indexed files replaced, for ci.churn
+	churn int
+	err   error
+}
+
+// newEmbedBatcher starts the workers of an indexing pass counting in stats
+func (ci *CodeIndex) newEmbedBatcher(ctx context.Context, stats *IndexStats) *embedBatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	b := &embedBatcher{ci: ci, ctx: ctx, cancel: cancel, work: make(chan []embedItem), stats: stats}
+	for i := 0; i < ci.embedConcurrency; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+	return b
+}
+
+// add queues the chunks of file to embed, writing it at once if they all
+// kept their vectors. It returns the error that stopped the pass, if any.
+func (b *embedBatcher) add(file *pendingFile) error {
+	if err := b.failed(); err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+	file.left = len(file.missing)
+	if file.left == 0 {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.commit(file)
+	}
+	for _, i := range file.missing {
+		text := file.chunks[i].embeddingText()
+		if len(b.batch) > 0 && (len(b.batch) >= b.ci.embedBatchSize || b.bytes+len(text) > b.ci.embedBatchBytes) {
+			b.flush()
+		}
+		b.batch = append(b.batch, embedItem{file: file, chunk: i, text: text})
+		b.bytes += len(text)
+	}
+	return nil
+}
+
+// flush hands the batch being filled to a worker
+func (b *embedBatcher) flush() {
+	if len(b.batch) == 0 {
+		return
+	}
+	b.work <- b.batch
+	b.batch, b.bytes = nil, 0
+}
+
+// wait embeds what is left, waits for the workers and returns the first
+// error of the pass. Calling it again returns the same error.
+func (b *embedBatcher) wait() error {
+	b.once.Do(func() {
+		if b.failed() == nil {
+			b.flush()
+		}
+		close(b.work)
+		b.wg.Wait()
+		b.cancel()
+		b.ci.churn += b.churn
+	})
+	return b.failed()
+}
+
+func (b *embedBatcher) failed() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// fail records the first error of the pass and stops the others
+func (b *embedBatcher) fail(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	b.cancel()
+}
+
+// worker embeds batches, writing the files whose chunks are all embedded.
+// After an error it drains the batches without embedding them.
+func (b *embedBatcher) worker() {
+	defer b.wg.Done()
+	for batch := range b.work {
+		if b.ctx.Err() != nil {
+			continue
+		}
+		if err := b.embed(batch); err != nil {
+			b.fail(err)
+		}
+	}
+}
+
+func (b *embedBatcher) embed(batch []embedItem) error {
+	if err := b.ci.embedLimit.wait(b.ctx); err != nil {
+		return err
+	}
+	texts := make([]string, len(batch))
+	for i, item := range batch {
+		texts[i] = item.text
+	}
+	vectors, err := b.ci.embedder.Embed(b.ctx, texts)
+	if err != nil {
+		if len(batch) == 1 || batch[0].file == batch[len(batch)-1].file {
+			return fmt.Errorf("failed to embed %s: %w", batch[0].file.rel, err)
+		}
+		return fmt.Errorf("failed to embed %d chunks from %s on: %w", len(batch), batch[0].file.rel, err)
+	}
+	if len(vectors) != len(batch) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(batch), len(vectors))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, item := range batch {
+		item.file.chunks[item.chunk].Vector = vectors[i]
+		item.file.left--
+		if item.file.left == 0 {
+			if err := b.commit(item.file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commit writes file to the store and counts it; b.mu is held
+func (b *embedBatcher) commit(file *pendingFile) error {
+	ci := b.ci
+	// Replace rather than upsert so chunks past the new end of file go away
+	if err := ci.store.DeleteFile(b.ctx, file.rel); err != nil {
+		return fmt.Errorf("failed to update %s in index: %w", file.rel, err)
+	}
+	if err := ci.store.Upsert(b.ctx, file.chunks); err != nil {
+		return fmt.Errorf("failed to update %s in index: %w", file.rel, err)
+	}
+	ci.manifest.set(file.rel, file.info, file.hash)
+	if file.indexedHash == "" {
+		ci.updateStatus(func(s *IndexStatus) { s.Files++ })
+	} else {
+		b.churn++
+	}
+	b.stats.Indexed++
+	b.stats.Chunks += len(file.chunks)
+	b.stats.Embedded += len(file.missing)
+	return nil
+}
+
+// embedLimiter spaces embedding requests to stay under a rate; a nil
+// limiter does not
+type embedLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newEmbedLimiter limits requests to perMinute, or returns nil if it is
+// not positive
+func newEmbedLimiter(perMinute int) *embedLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &embedLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until a request may be sent, or ctx is done
+func (l *embedLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
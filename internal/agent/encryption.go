@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks data encrypted by a Sealer. The rest is the base64 of
+// the nonce followed by the AES-GCM ciphertext, so sealed data stays text
+// and fits on one line of a JSON-lines file.
+const sealedPrefix = "spilot-enc:v1:"
+
+// ErrNoEncryptionKey is returned when reading encrypted data without a key
+var ErrNoEncryptionKey = errors.New("data is encrypted but no encryption key is configured")
+
+// Sealer encrypts data stored at rest: sessions, memory, the command audit
+// log and the local code index. A nil Sealer stores data in the clear.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer creates a sealer using key, the base64 encoding of 32 random
+// bytes (e.g. from "openssl rand -base64 32"). An empty key returns nil.
+func NewSealer(key string) (*Sealer, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts data; a nil Sealer returns it unchanged
+func (s *Sealer) Seal(data []byte) ([]byte, error) {
+	if s == nil {
+		return data, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, data, nil)
+	out := make([]byte, len(sealedPrefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(out, sealedPrefix)
+	base64.StdEncoding.Encode(out[len(sealedPrefix):], sealed)
+	return out, nil
+}
+
+// Open decrypts data sealed by Seal. Data stored before encryption was
+// enabled is returned unchanged, so existing files stay readable and are
+// encrypted the next time they are written.
+func (s *Sealer) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(sealedPrefix)) {
+		return data, nil
+	}
+	if s == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(sealedPrefix):])))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt encrypted data: %w", err)
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("corrupt encrypted data: too short")
+	}
+	plain, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data; was it written with another key? %w", err)
+	}
+	return plain, nil
+}
+
+// sealString and openString seal text kept in string fields, such as
+// database columns
+func (s *Sealer) sealString(text string) (string, error) {
+	sealed, err := s.Seal([]byte(text))
+	return string(sealed), err
+}
+
+func (s *Sealer) openString(text string) (string, error) {
+	plain, err := s.Open([]byte(text))
+	return string(plain), err
+}
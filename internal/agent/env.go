@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// envFilter decides which server environment variables are inherited by
+// executed commands, so secrets like GROQ_API_KEY don't leak into them
+type envFilter struct {
+	allow []string
+	deny  []string
+}
+
+func newEnvFilter(allow, deny []string) *envFilter {
+	return &envFilter{allow: allow, deny: deny}
+}
+
+// inherits reports whether a variable is passed through. The denylist wins
+// over the allowlist; an empty allowlist allows everything not denied.
+func (f *envFilter) inherits(name string) bool {
+	if matchesAny(f.deny, name) {
+		return false
+	}
+	return len(f.allow) == 0 || matchesAny(f.allow, name)
+}
+
+// environ builds the child environment from the filtered server environment
+// plus the given extra variables
+func (f *envFilter) environ(extra map[string]string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, overridden := extra[name]; overridden {
+			continue
+		}
+		if f.inherits(name) {
+			env = append(env, kv)
+		}
+	}
+
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+extra[name])
+	}
+	return env
+}
+
+// matchesAny reports whether name matches one of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorLocation is a source position extracted from compiler or runtime output
+type ErrorLocation struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Language string `json:"language"`
+}
+
+type errorPattern struct {
+	language string
+	pattern  *regexp.Regexp
+	// group indexes of file, line, column and message; 0 means absent
+	file, line, column, message int
+}
+
+// errorPatterns recognise the location formats of common toolchains. Order
+// matters: more specific formats come first.
+var errorPatterns = []errorPattern{
+	// tsc: src/app.ts(12,5): error TS2322: ...
+	{"typescript", regexp.MustCompile(`(?m)^\s*([^\s()]+\.tsx?)\((\d+),(\d+)\):\s*(error TS\d+:.*)$`), 1, 2, 3, 4},
+	// tsc --pretty: src/app.ts:12:5 - error TS2322: ...
+	{"typescript", regexp.MustCompile(`(?m)^\s*([^\s:]+\.tsx?):(\d+):(\d+)\s+-\s+(error TS\d+:.*)$`), 1, 2, 3, 4},
+	// rustc: --> src/main.rs:4:5
+	{"rust", regexp.MustCompile(`(?m)-->\s+([^\s:]+\.rs):(\d+):(\d+)`), 1, 2, 3, 0},
+	// Python traceback: File "app.py", line 10, in main
+	{"python", regexp.MustCompile(`(?m)File "([^"]+\.py)", line (\d+)`), 1, 2, 0, 0},
+	// Node stack: at fn (/app/index.js:10:15) or at /app/index.js:10:15
+	{"javascript", regexp.MustCompile(`(?m)at (?:.*?\()?([^\s()]+\.(?:js|mjs|cjs|jsx|ts|tsx)):(\d+):(\d+)\)?`), 1, 2, 3, 0},
+	// Go compiler/vet: ./main.go:10:2: undefined: foo
+	{"go", regexp.MustCompile(`(?m)^\s*([^\s:]+\.go):(\d+):(?:(\d+):)?\s*(.*)$`), 1, 2, 3, 4},
+	// pyflakes/flake8/mypy: app.py:3:1: 'os' imported but unused
+	{"python", regexp.MustCompile(`(?m)^\s*([^\s:]+\.py):(\d+):(?:(\d+):)?\s*(.*)$`), 1, 2, 3, 4},
+	// Go panic stack: \t/app/main.go:10 +0x1d
+	{"go", regexp.MustCompile(`(?m)^\s+(/[^\s:]+\.go):(\d+)(?:\s+\+0x[0-9a-f]+)?$`), 1, 2, 0, 0},
+}
+
+// ParseErrorLocations extracts file locations from error output, in order
+// of appearance and without duplicates
+func ParseErrorLocations(output string) []ErrorLocation {
+	type found struct {
+		offset int
+		loc    ErrorLocation
+	}
+
+	var matches []found
+	seen := make(map[string]bool)
+	for _, p := range errorPatterns {
+		for _, m := range p.pattern.FindAllStringSubmatchIndex(output, -1) {
+			group := func(i int) string {
+				if i == 0 || m[2*i] < 0 {
+					return ""
+				}
+				return output[m[2*i]:m[2*i+1]]
+			}
+			line, _ := strconv.Atoi(group(p.line))
+			column, _ := strconv.Atoi(group(p.column))
+			loc := ErrorLocation{
+				File:     group(p.file),
+				Line:     line,
+				Column:   column,
+				Message:  strings.TrimSpace(group(p.message)),
+				Language: p.language,
+			}
+			key := fmt.Sprintf("%s:%d", loc.File, loc.Line)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			matches = append(matches, found{offset: m[0], loc: loc})
+		}
+	}
+
+	// Restore output order across patterns
+	for i := 1; i < len(matches); i++ {
+		for j := i; j > 0 && matches[j].offset < matches[j-1].offset; j-- {
+			matches[j], matches[j-1] = matches[j-1], matches[j]
+		}
+	}
+
+	locations := make([]ErrorLocation, len(matches))
+	for i, m := range matches {
+		locations[i] = m.loc
+	}
+	return locations
+}
+
+// resolveInWorkspace maps a reported path to a file inside workspaceDir,
+// returning false for paths outside it (standard library, node_modules, ...)
+func resolveInWorkspace(workspaceDir, file string) (string, bool) {
+	root, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return "", false
+	}
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if strings.Contains(filepath.ToSlash(rel), "node_modules/") {
+		return "", false
+	}
+	return path, true
+}
+
+// snippetAround returns the lines around line (1-based), numbered
+func snippetAround(content string, line, context int) string {
+	lines := strings.Split(content, "\n")
+	if line < 1 || line > len(lines) {
+		line = 1
+	}
+	start := line - context
+	if start < 1 {
+		start = 1
+	}
+	end := line + context
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		marker := "  "
+		if i == line {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, i, lines[i-1])
+	}
+	return b.String()
+}
@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ActionType identifies what the agent did in an event log entry
+type ActionType string
+
+const (
+	ActionTaskCreated     ActionType = "task_created"
+	ActionPlanGenerated   ActionType = "plan_generated"
+	ActionFileWritten     ActionType = "file_written"
+	ActionCommandExecuted ActionType = "command_executed"
+	ActionFixApplied      ActionType = "fix_applied"
+	ActionSecretRedacted  ActionType = "secret_redacted"
+)
+
+// ActionEvent is one action recorded in the event log
+type ActionEvent struct {
+	// Seq numbers the events in the order they were recorded
+	Seq       int64                  `json:"seq"`
+	Type      ActionType             `json:"type"`
+	TaskID    string                 `json:"task_id,omitempty"`
+	Requester string                 `json:"requester,omitempty"`
+	Workspace string                 `json:"workspace,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// EventFilter selects events. Zero fields match everything; TaskID also
+// matches the task's sub-tasks and After skips events up to that sequence
+// number.
+type EventFilter struct {
+	Type      ActionType
+	TaskID    string
+	Requester string
+	Since     time.Time
+	Until     time.Time
+	After     int64
+	Limit     int
+}
+
+func (f EventFilter) matches(event *ActionEvent) bool {
+	switch {
+	case f.Type != "" && event.Type != f.Type:
+		return false
+	case f.TaskID != "" && event.TaskID != f.TaskID && !strings.HasPrefix(event.TaskID, f.TaskID+"."):
+		return false
+	case f.Requester != "" && event.Requester != f.Requester:
+		return false
+	case !f.Since.IsZero() && event.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !event.Timestamp.Before(f.Until):
+		return false
+	case event.Seq <= f.After:
+		return false
+	}
+	return true
+}
+
+// EventLog is an append-only record of what the agent did to workspaces:
+// tasks created, plans generated, files written, commands executed, fixes
+// applied and secrets redacted. Events are stored as JSON lines, each sealed on its own.
+type EventLog struct {
+	mu     sync.Mutex
+	path   string
+	seq    int64
+	sealer *Sealer
+	logger *zap.Logger
+}
+
+// NewEventLog opens the event log at path, creating its directory
+func NewEventLog(path string, sealer *Sealer, logger *zap.Logger) (*EventLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create event log directory: %w", err)
+	}
+	log := &EventLog{path: path, sealer: sealer, logger: logger}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer file.Close()
+	// Continue the numbering of the events already recorded
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		log.seq++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+	return log, nil
+}
+
+// Record appends an event for an action taken under ctx, attributing it to
+// the task, requester and workspace ctx carries. Failures are logged;
+// they never stop the action. A nil EventLog records nothing.
+func (l *EventLog) Record(ctx context.Context, action ActionType, data map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	event := &ActionEvent{
+		Type:      action,
+		TaskID:    commandOriginFrom(ctx).TaskID,
+		Requester: RequesterFrom(ctx),
+		Workspace: workspaceFrom(ctx),
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+	if event.TaskID == "" {
+		event.TaskID, _ = ctx.Value(taskIDKey{}).(string)
+	}
+	if err := l.append(event); err != nil {
+		l.logger.Error("Failed to record event", zap.String("type", string(action)), zap.Error(err))
+	}
+}
+
+func (l *EventLog) append(event *ActionEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Seq = l.seq + 1
+	line, err := json.Marshal(event)
+	if err == nil {
+		line, err = l.sealer.Seal(line)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq = event.Seq
+	return nil
+}
+
+// Query returns matching events, newest first
+func (l *EventLog) Query(filter EventFilter) ([]*ActionEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer file.Close()
+
+	var events []*ActionEvent
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		line, err := l.sealer.Open(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read event log: %w", err)
+		}
+		var event ActionEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		if filter.matches(&event) {
+			events = append(events, &event)
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+
+	// Reverse to newest first, then apply the limit
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// contentHash returns the SHA-256 of content in hex
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// diffHash identifies a change from before to after; before is nil for a
+// new file and after for a deleted one
+func diffHash(before, after *string) string {
+	hash := sha256.New()
+	for _, content := range []*string{before, after} {
+		if content == nil {
+			hash.Write([]byte{0})
+			continue
+		}
+		hash.Write([]byte{1})
+		hash.Write([]byte(contentHash(*content)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// fileChange describes a change to path for the event log
+func fileChange(path string, before, after *string) map[string]interface{} {
+	change := map[string]interface{}{"path": path, "diff_hash": diffHash(before, after)}
+	if before != nil {
+		change["before_sha256"] = contentHash(*before)
+	}
+	if after != nil {
+		change["sha256"] = contentHash(*after)
+		change["bytes"] = len(*after)
+	}
+	return change
+}
+
+// eventedFileManager records every file the wrapped manager writes in the
+// event log, attributed to the task of its context if it has one (see
+// fileManagerFor)
+type eventedFileManager struct {
+	FileManager
+	events *EventLog
+	ctx    context.Context
+}
+
+func (f *eventedFileManager) withContext(ctx context.Context) FileManager {
+	return &eventedFileManager{FileManager: fileManagerFor(ctx, f.FileManager), events: f.events, ctx: ctx}
+}
+
+func (f *eventedFileManager) CreateFile(path, content string) error {
+	if err := f.FileManager.CreateFile(path, content); err != nil {
+		return err
+	}
+	f.record(path, "create", nil, &content)
+	return nil
+}
+
+func (f *eventedFileManager) UpdateFile(path, content string) error {
+	before := readForHash(path)
+	if err := f.FileManager.UpdateFile(path, content); err != nil {
+		return err
+	}
+	f.record(path, "update", before, &content)
+	return nil
+}
+
+func (f *eventedFileManager) DeleteFile(path string) error {
+	before := readForHash(path)
+	if err := f.FileManager.DeleteFile(path); err != nil {
+		return err
+	}
+	f.record(path, "delete", before, nil)
+	return nil
+}
+
+func (f *eventedFileManager) record(path, operation string, before, after *string) {
+	change := fileChange(absPath(path), before, after)
+	change["operation"] = operation
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	f.events.Record(ctx, ActionFileWritten, change)
+}
+
+// readForHash returns the content of path, or nil if it cannot be read
+func readForHash(path string) *string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	content := string(data)
+	return &content
+}
+
+// eventedAuditLog records executed commands in the event log as well as
+// the command audit log
+type eventedAuditLog struct {
+	CommandAuditLog
+	events *EventLog
+}
+
+func (l *eventedAuditLog) Record(entry *CommandAuditEntry) error {
+	ctx := withCommandOrigin(ContextWithRequester(context.Background(), entry.Requester), entry.TaskID, entry.Instruction)
+	l.events.Record(ctx, ActionCommandExecuted, map[string]interface{}{
+		"command_id":  entry.ID,
+		"command":     entry.Command,
+		"working_dir": entry.WorkingDir,
+		"status":      entry.Status,
+		"exit_code":   entry.ExitCode,
+	})
+	return l.CommandAuditLog.Record(entry)
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TaskEventType identifies the kind of a task event
+type TaskEventType string
+
+const (
+	EventTaskStarted   TaskEventType = "task_started"
+	EventTaskCompleted TaskEventType = "task_completed"
+	EventTaskFailed    TaskEventType = "task_failed"
+	EventCommandOutput TaskEventType = "command_output"
+
+	EventApprovalRequired TaskEventType = "approval_required"
+	EventRepairIteration  TaskEventType = "repair_iteration"
+	EventMigrationBatch   TaskEventType = "migration_batch"
+	EventHandoff          TaskEventType = "handoff"
+)
+
+// TaskEvent is a progress notification emitted while a task executes
+type TaskEvent struct {
+	TaskID    string                 `json:"task_id"`
+	Type      TaskEventType          `json:"type"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// EventBus fans task events out to subscribers
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]*subscription
+	nextID      int
+}
+
+type subscription struct {
+	taskID string
+	ch     chan TaskEvent
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[int]*subscription),
+	}
+}
+
+// Publish delivers an event to every matching subscriber. Slow subscribers
+// drop events rather than blocking task execution.
+func (b *EventBus) Publish(event TaskEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subscribers {
+		if sub.taskID != "" && sub.taskID != event.TaskID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events for taskID, or for all tasks if
+// taskID is empty, and a function that ends the subscription
+func (b *EventBus) Subscribe(taskID string) (<-chan TaskEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	sub := &subscription{taskID: taskID, ch: make(chan TaskEvent, 256)}
+	b.subscribers[id] = sub
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, id)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+type taskIDKey struct{}
+
+// ContextWithTaskID lets callers choose the ID of the task created for a
+// request, so they can subscribe to its events before it starts
+func ContextWithTaskID(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, taskID)
+}
+
+// TaskIDFrom returns the task ID chosen with ContextWithTaskID, if any
+func TaskIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	return id
+}
+
+// newTaskID returns the caller-chosen task ID from ctx, or a generated one
+func newTaskID(ctx context.Context) string {
+	if id := TaskIDFrom(ctx); id != "" {
+		return id
+	}
+	return generateTaskID()
+}
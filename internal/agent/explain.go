@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrorCategory is the broad kind of an error
+type ErrorCategory string
+
+const (
+	CategorySyntax      ErrorCategory = "syntax"
+	CategoryDependency  ErrorCategory = "dependency"
+	CategoryEnvironment ErrorCategory = "environment"
+	CategoryLogic       ErrorCategory = "logic"
+	CategoryFlaky       ErrorCategory = "flaky"
+)
+
+// Severity is how badly an error affects the project
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// ErrorExplanation is the result of the explain-only debug mode
+type ErrorExplanation struct {
+	Category    ErrorCategory `json:"category"`
+	Severity    Severity      `json:"severity"`
+	Summary     string        `json:"summary"`
+	Explanation string        `json:"explanation"`
+	LikelyCause string        `json:"likely_cause,omitempty"`
+	NextSteps   []string      `json:"next_steps,omitempty"`
+}
+
+// categoryHints are cheap pattern checks used to suggest a category to the
+// LLM and as a fallback when its answer can't be parsed. Order matters.
+var categoryHints = []struct {
+	category ErrorCategory
+	pattern  *regexp.Regexp
+}{
+	{CategorySyntax, regexp.MustCompile(`(?i)syntax ?error|unexpected (token|eof|newline)|expected ['"]?[;){}\]]|indentationerror|parse error`)},
+	{CategoryDependency, regexp.MustCompile(`(?i)cannot find (module|package)|modulenotfounderror|no module named|unresolved import|could not resolve|missing go\.sum entry|npm err! (404|peer)|version conflict`)},
+	{CategoryEnvironment, regexp.MustCompile(`(?i)command not found|permission denied|no such file or directory|address already in use|connection refused|eaddrinuse|econnrefused|out of memory|no space left|not recognized as an internal`)},
+	{CategoryFlaky, regexp.MustCompile(`(?i)timed? ?out|deadline exceeded|data race|flaky|intermittent|connection reset|econnreset`)},
+}
+
+// guessErrorCategory classifies an error by pattern alone, defaulting to logic
+func guessErrorCategory(errorOutput string) ErrorCategory {
+	for _, hint := range categoryHints {
+		if hint.pattern.MatchString(errorOutput) {
+			return hint.category
+		}
+	}
+	return CategoryLogic
+}
+
+// explainError classifies and explains an error without generating a fix.
+// It makes a single LLM call and skips native diagnostics, so it is much
+// cheaper than the full fix pipeline.
+func (d *DebugAgentImpl) explainError(ctx context.Context, errorOutput, workspaceDir string) (*TaskResult, error) {
+	locations, snippets, injections := d.identifyErrorFile(ctx, errorOutput, workspaceDir)
+	guess := guessErrorCategory(errorOutput)
+
+	prompt := fmt.Sprintf(`Error output:
+%s
+
+Relevant source:
+%s
+
+Classify and explain this error. Do not write code or a fix.
+A pattern check suggests the category %q; override it if wrong.
+Respond with only JSON:
+{"category": "syntax|dependency|environment|logic|flaky",
+ "severity": "low|medium|high|critical",
+ "summary": "<one sentence>",
+ "explanation": "<what the error means and why it happens>",
+ "likely_cause": "<the most probable root cause>",
+ "next_steps": ["<diagnostic step>", ...]}`, fenceUntrusted("error output", errorOutput), snippets, guess)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert debugger. Diagnose errors precisely without proposing code."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain error: %w", err)
+	}
+
+	explanation := &ErrorExplanation{}
+	if err := json.Unmarshal([]byte(extractJSON(response)), explanation); err != nil {
+		explanation = &ErrorExplanation{
+			Category:    guess,
+			Severity:    SeverityMedium,
+			Explanation: strings.TrimSpace(response),
+		}
+	}
+	if !validCategory(explanation.Category) {
+		explanation.Category = guess
+	}
+	switch explanation.Severity = Severity(strings.ToLower(string(explanation.Severity))); explanation.Severity {
+	case SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical:
+	default:
+		explanation.Severity = SeverityMedium
+	}
+
+	result := &TaskResult{
+		Success: true,
+		Data: Fields{
+			"explanation": explanation,
+			"category":    explanation.Category,
+			"severity":    explanation.Severity,
+			"locations":   locations,
+		},
+	}
+	if len(injections) > 0 {
+		d.warnInjections(injections)
+		result.Fields()["injection_warnings"] = injections
+	}
+	return result, nil
+}
+
+// validCategory reports whether c is one of the known error categories
+func validCategory(c ErrorCategory) bool {
+	switch c {
+	case CategorySyntax, CategoryDependency, CategoryEnvironment, CategoryLogic, CategoryFlaky:
+		return true
+	}
+	return false
+}
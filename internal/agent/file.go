@@ -11,13 +11,16 @@ import (
 // FileAgent handles file operations
 type FileAgentImpl struct {
 	fileManager FileManager
+	formatter   Formatter
 	logger      *zap.Logger
 }
 
-// NewFileAgent creates a new file agent
-func NewFileAgent(fileManager FileManager, logger *zap.Logger) *FileAgentImpl {
+// NewFileAgent creates a new file agent. formatter may be nil to disable
+// formatting of written files.
+func NewFileAgent(fileManager FileManager, formatter Formatter, logger *zap.Logger) *FileAgentImpl {
 	return &FileAgentImpl{
 		fileManager: fileManager,
+		formatter:   formatter,
 		logger:      logger,
 	}
 }
@@ -45,12 +48,14 @@ func (f *FileAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, e
 		return f.handleDeleteFile(ctx, task)
 	case "read":
 		return f.handleReadFile(ctx, task)
+	case "head", "tail":
+		return f.handleReadLines(ctx, task, operation)
 	default:
 		return nil, fmt.Errorf("unknown file operation: %s", operation)
 	}
 }
 
-func (f *FileAgentImpl) handleCreateFile(_ context.Context, task *Task) (*TaskResult, error) {
+func (f *FileAgentImpl) handleCreateFile(ctx context.Context, task *Task) (*TaskResult, error) {
 	path, ok := task.Data["path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("path not found in task data")
@@ -67,17 +72,17 @@ func (f *FileAgentImpl) handleCreateFile(_ context.Context, task *Task) (*TaskRe
 	}
 	fullPath := filepath.Join(workspaceDir, path)
 
-	if err := f.fileManager.CreateFile(fullPath, content); err != nil {
+	if err := fileManagerFor(ctx, f.fileManager).CreateFile(fullPath, content); err != nil {
 		return &TaskResult{Success: false, Error: err.Error()}, nil
 	}
 
 	return &TaskResult{
 		Success: true,
-		Data:    map[string]interface{}{"path": fullPath, "created": true},
+		Data:    &FileOpResult{Operation: "create", Path: fullPath, Created: true, Formatted: f.formatFile(ctx, fullPath)},
 	}, nil
 }
 
-func (f *FileAgentImpl) handleUpdateFile(_ context.Context, task *Task) (*TaskResult, error) {
+func (f *FileAgentImpl) handleUpdateFile(ctx context.Context, task *Task) (*TaskResult, error) {
 	path, ok := task.Data["path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("path not found in task data")
@@ -92,17 +97,17 @@ func (f *FileAgentImpl) handleUpdateFile(_ context.Context, task *Task) (*TaskRe
 	}
 	fullPath := filepath.Join(workspaceDir, path)
 
-	if err := f.fileManager.UpdateFile(fullPath, content); err != nil {
+	if err := fileManagerFor(ctx, f.fileManager).UpdateFile(fullPath, content); err != nil {
 		return &TaskResult{Success: false, Error: err.Error()}, nil
 	}
 
 	return &TaskResult{
 		Success: true,
-		Data:    map[string]interface{}{"path": fullPath, "updated": true},
+		Data:    &FileOpResult{Operation: "update", Path: fullPath, Updated: true, Formatted: f.formatFile(ctx, fullPath)},
 	}, nil
 }
 
-func (f *FileAgentImpl) handleDeleteFile(_ context.Context, task *Task) (*TaskResult, error) {
+func (f *FileAgentImpl) handleDeleteFile(ctx context.Context, task *Task) (*TaskResult, error) {
 	path, ok := task.Data["path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("path not found in task data")
@@ -113,17 +118,17 @@ func (f *FileAgentImpl) handleDeleteFile(_ context.Context, task *Task) (*TaskRe
 	}
 	fullPath := filepath.Join(workspaceDir, path)
 
-	if err := f.fileManager.DeleteFile(fullPath); err != nil {
+	if err := fileManagerFor(ctx, f.fileManager).DeleteFile(fullPath); err != nil {
 		return &TaskResult{Success: false, Error: err.Error()}, nil
 	}
 
 	return &TaskResult{
 		Success: true,
-		Data:    map[string]interface{}{"path": fullPath, "deleted": true},
+		Data:    &FileOpResult{Operation: "delete", Path: fullPath, Deleted: true},
 	}, nil
 }
 
-func (f *FileAgentImpl) handleReadFile(_ context.Context, task *Task) (*TaskResult, error) {
+func (f *FileAgentImpl) handleReadFile(ctx context.Context, task *Task) (*TaskResult, error) {
 	path, ok := task.Data["path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("path not found in task data")
@@ -134,13 +139,64 @@ func (f *FileAgentImpl) handleReadFile(_ context.Context, task *Task) (*TaskResu
 	}
 	fullPath := filepath.Join(workspaceDir, path)
 
-	content, err := f.fileManager.ReadFile(fullPath)
+	content, err := fileManagerFor(ctx, f.fileManager).ReadFile(fullPath)
 	if err != nil {
 		return &TaskResult{Success: false, Error: err.Error()}, nil
 	}
 
 	return &TaskResult{
 		Success: true,
-		Data:    map[string]interface{}{"path": fullPath, "content": content},
+		Data:    &FileOpResult{Operation: "read", Path: fullPath, Content: content},
 	}, nil
 }
+
+// defaultReadLines is the number of lines returned by head/tail reads when
+// the task does not specify one
+const defaultReadLines = 200
+
+func (f *FileAgentImpl) handleReadLines(ctx context.Context, task *Task, operation string) (*TaskResult, error) {
+	path, ok := task.Data["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("path not found in task data")
+	}
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		return nil, fmt.Errorf("workspace_dir not found in task data")
+	}
+	fullPath := filepath.Join(workspaceDir, path)
+
+	lines := defaultReadLines
+	if n, ok := task.Data["lines"].(float64); ok && n > 0 {
+		lines = int(n)
+	}
+
+	var content string
+	var err error
+	if operation == "head" {
+		content, err = fileManagerFor(ctx, f.fileManager).ReadHead(fullPath, lines)
+	} else {
+		content, err = fileManagerFor(ctx, f.fileManager).ReadTail(fullPath, lines)
+	}
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data:    &FileOpResult{Operation: operation, Path: fullPath, Content: content, Lines: lines},
+	}, nil
+}
+
+// formatFile runs the configured formatter on a written file. Formatting
+// failures are logged but never fail the write itself.
+func (f *FileAgentImpl) formatFile(ctx context.Context, path string) bool {
+	if f.formatter == nil {
+		return false
+	}
+	formatted, err := f.formatter.Format(ctx, path)
+	if err != nil {
+		f.logger.Warn("Failed to format file", zap.String("path", path), zap.Error(err))
+		return false
+	}
+	return formatted
+}
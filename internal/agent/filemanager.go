@@ -1,21 +1,56 @@
 package agent
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// ErrFileTooLarge is returned when a file exceeds the configured size limits
+var ErrFileTooLarge = errors.New("file too large")
+
+// FileTooLargeError describes a read or write rejected by the size guards
+type FileTooLargeError struct {
+	Path  string
+	Size  int64
+	Limit int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file %s is %d bytes, exceeding the %d byte limit; use a head or tail read instead", e.Path, e.Size, e.Limit)
+}
+
+// Unwrap allows errors.Is(err, ErrFileTooLarge)
+func (e *FileTooLargeError) Unwrap() error {
+	return ErrFileTooLarge
+}
+
 // FileManagerImpl implements the FileManager interface
-type FileManagerImpl struct{}
+type FileManagerImpl struct {
+	maxReadBytes  int64
+	maxWriteBytes int64
+}
 
-// NewFileManager creates a new file manager
-func NewFileManager() FileManager {
-	return &FileManagerImpl{}
+// NewFileManager creates a new file manager. A limit of zero disables the
+// corresponding size guard.
+func NewFileManager(maxReadBytes, maxWriteBytes int64) FileManager {
+	return &FileManagerImpl{
+		maxReadBytes:  maxReadBytes,
+		maxWriteBytes: maxWriteBytes,
+	}
 }
 
 // CreateFile creates a new file with the given content
 func (f *FileManagerImpl) CreateFile(path, content string) error {
+	if err := f.checkWriteSize(path, content); err != nil {
+		return err
+	}
+
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory for %s: %w", path, err)
@@ -38,6 +73,9 @@ func (f *FileManagerImpl) UpdateFile(path, content string) error {
 	if !f.FileExists(path) {
 		return fmt.Errorf("file does not exist: %s", path)
 	}
+	if err := f.checkWriteSize(path, content); err != nil {
+		return err
+	}
 	return os.WriteFile(path, []byte(content), 0644)
 }
 
@@ -48,6 +86,14 @@ func (f *FileManagerImpl) DeleteFile(path string) error {
 
 // ReadFile reads the content of a file
 func (f *FileManagerImpl) ReadFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if f.maxReadBytes > 0 && info.Size() > f.maxReadBytes {
+		return "", &FileTooLargeError{Path: path, Size: info.Size(), Limit: f.maxReadBytes}
+	}
+
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
@@ -55,6 +101,56 @@ func (f *FileManagerImpl) ReadFile(path string) (string, error) {
 	return string(content), nil
 }
 
+// ReadHead streams the first n lines of a file without loading it whole
+func (f *FileManagerImpl) ReadHead(path string, n int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := newLineScanner(file)
+	for len(lines) < n && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// ReadTail streams a file and returns its last n lines, keeping only those
+// lines in memory
+func (f *FileManagerImpl) ReadTail(path string, n int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if n <= 0 {
+		return "", nil
+	}
+
+	ring := make([]string, n)
+	count := 0
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		ring[count%n] = scanner.Text()
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if count < n {
+		return strings.Join(ring[:count], "\n"), nil
+	}
+	start := count % n
+	return strings.Join(append(ring[start:], ring[:start]...), "\n"), nil
+}
+
 // FileExists checks if a file exists
 func (f *FileManagerImpl) FileExists(path string) bool {
 	_, err := os.Stat(path)
@@ -79,3 +175,53 @@ func (f *FileManagerImpl) ListFiles(dir string) ([]string, error) {
 	})
 	return files, err
 }
+
+// checkWriteSize enforces the configured write limit
+func (f *FileManagerImpl) checkWriteSize(path, content string) error {
+	if f.maxWriteBytes > 0 && int64(len(content)) > f.maxWriteBytes {
+		return &FileTooLargeError{Path: path, Size: int64(len(content)), Limit: f.maxWriteBytes}
+	}
+	return nil
+}
+
+// contextFileManager is implemented by file managers that attribute writes
+// to the task, agent and requester of a context, e.g. to check them
+// against the policy or record them in the event log
+type contextFileManager interface {
+	withContext(ctx context.Context) FileManager
+}
+
+// fileManagerFor returns fileManager acting for ctx. Agents use it for the
+// files they read and write while executing a task.
+func fileManagerFor(ctx context.Context, fileManager FileManager) FileManager {
+	if contextual, ok := fileManager.(contextFileManager); ok {
+		return contextual.withContext(ctx)
+	}
+	return fileManager
+}
+
+// newLineScanner returns a line scanner that tolerates long lines such as
+// minified files or single-line JSON logs
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	return scanner
+}
+
+// ReadWorkspaceFile reads path, relative to workspaceDir, through the checks
+// the agents' reads go through. Paths may not leave the workspace.
+func (s *System) ReadWorkspaceFile(ctx context.Context, workspaceDir, path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("path %q is not inside the workspace", path)
+	}
+	return fileManagerFor(ctx, s.fileManager).ReadFile(filepath.Join(workspaceDir, path))
+}
+
+// ListWorkspaceFiles lists the files under dir, relative to workspaceDir,
+// as paths relative to dir
+func (s *System) ListWorkspaceFiles(ctx context.Context, workspaceDir, dir string) ([]string, error) {
+	if !filepath.IsLocal(dir) {
+		return nil, fmt.Errorf("directory %q is not inside the workspace", dir)
+	}
+	return fileManagerFor(ctx, s.fileManager).ListFiles(filepath.Join(workspaceDir, dir))
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Formatter formats files after they have been written
+type Formatter interface {
+	// Format runs the configured formatter for path. It reports whether a
+	// formatter was actually run.
+	Format(ctx context.Context, path string) (bool, error)
+}
+
+// FormatterImpl runs external formatters (gofmt, prettier, black, ...) chosen by file extension
+type FormatterImpl struct {
+	commands map[string][]string
+	tools    *ToolRunner
+	logger   *zap.Logger
+}
+
+// NewFormatter creates a formatter from a map of extension to command line,
+// running the formatters with tools
+func NewFormatter(commands map[string]string, tools *ToolRunner, logger *zap.Logger) *FormatterImpl {
+	parsed := make(map[string][]string, len(commands))
+	for ext, command := range commands {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		parsed[strings.ToLower(strings.TrimPrefix(ext, "."))] = fields
+	}
+	return &FormatterImpl{
+		commands: parsed,
+		tools:    tools,
+		logger:   logger,
+	}
+}
+
+// Format runs the formatter registered for the file's extension, if any
+func (f *FormatterImpl) Format(ctx context.Context, path string) (bool, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	command, ok := f.commands[ext]
+	if !ok {
+		return false, nil
+	}
+
+	// A missing formatter is not an error; the file is simply left as written
+	if _, err := exec.LookPath(command[0]); err != nil {
+		f.logger.Debug("Formatter not installed, skipping", zap.String("formatter", command[0]), zap.String("path", path))
+		return false, nil
+	}
+
+	args := append(append([]string{}, command[1:]...), path)
+	if _, err := f.tools.run(ctx, filepath.Dir(path), command[0], args...); err != nil {
+		return false, fmt.Errorf("%s failed on %s: %w", command[0], path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,322 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultGitLogEntries is how many commits the log operation returns
+	defaultGitLogEntries = 20
+	// maxCommitDiffChars bounds the staged diff sent to the LLM for a commit message
+	maxCommitDiffChars = 12000
+)
+
+// GitFileStatus is one entry of `git status`
+type GitFileStatus struct {
+	Path     string `json:"path"`
+	Index    string `json:"index"`
+	Worktree string `json:"worktree"`
+}
+
+// GitLogEntry is one commit of `git log`
+type GitLogEntry struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+}
+
+// GitAgent handles repository operations
+type GitAgentImpl struct {
+	llmClient LLMClient
+	tools     *ToolRunner
+	logger    *zap.Logger
+}
+
+// NewGitAgent creates a new git agent running git through tools
+func NewGitAgent(llmClient LLMClient, tools *ToolRunner, logger *zap.Logger) *GitAgentImpl {
+	return &GitAgentImpl{
+		llmClient: llmClient,
+		tools:     tools,
+		logger:    logger,
+	}
+}
+
+// Type returns the agent type
+func (g *GitAgentImpl) Type() AgentType {
+	return GitAgent
+}
+
+// Execute executes a git operation task
+func (g *GitAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	g.logger.Info("Git agent executing task", zap.String("task_id", task.ID))
+
+	operation, ok := task.Data["operation"].(string)
+	if !ok {
+		return nil, fmt.Errorf("operation data not found in task")
+	}
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+
+	switch operation {
+	case "status":
+		return g.handleStatus(ctx, workspaceDir)
+	case "diff":
+		return g.handleDiff(ctx, task, workspaceDir)
+	case "branch":
+		return g.handleBranch(ctx, task, workspaceDir)
+	case "commit":
+		return g.handleCommit(ctx, task, workspaceDir)
+	case "stash":
+		return g.handleStash(ctx, task, workspaceDir)
+	case "log":
+		return g.handleLog(ctx, task, workspaceDir)
+	default:
+		return nil, fmt.Errorf("unknown git operation: %s", operation)
+	}
+}
+
+func (g *GitAgentImpl) handleStatus(ctx context.Context, workspaceDir string) (*TaskResult, error) {
+	out, err := g.tools.git(ctx, workspaceDir, "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	branch := ""
+	files := []GitFileStatus{}
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			branch = strings.TrimPrefix(line, "## ")
+		case len(line) > 3:
+			files = append(files, GitFileStatus{
+				Path:     line[3:],
+				Index:    strings.TrimSpace(line[:1]),
+				Worktree: strings.TrimSpace(line[1:2]),
+			})
+		}
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"branch": branch, "files": files, "clean": len(files) == 0},
+	}, nil
+}
+
+func (g *GitAgentImpl) handleDiff(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	args := []string{"diff", "--no-color"}
+	if staged, _ := task.Data["staged"].(bool); staged {
+		args = append(args, "--cached")
+	}
+	if ref, ok := task.Data["ref"].(string); ok && ref != "" {
+		if err := checkGitRef(ref); err != nil {
+			return nil, err
+		}
+		args = append(args, ref)
+	}
+	if path, ok := task.Data["path"].(string); ok && path != "" {
+		args = append(args, "--", path)
+	}
+
+	diff, err := g.tools.git(ctx, workspaceDir, args...)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	stat, _ := g.tools.git(ctx, workspaceDir, append([]string{args[0], "--stat"}, args[2:]...)...)
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"diff": diff, "stat": stat},
+	}, nil
+}
+
+func (g *GitAgentImpl) handleBranch(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	action, _ := task.Data["action"].(string)
+	name, _ := task.Data["name"].(string)
+	if action != "" && action != "list" && name == "" {
+		return nil, fmt.Errorf("name not found in task data")
+	}
+	if err := checkGitRef(name); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch action {
+	case "", "list":
+	case "create":
+		_, err = g.tools.gitChange(ctx, workspaceDir, "switch", "-c", name)
+	case "switch":
+		_, err = g.tools.gitChange(ctx, workspaceDir, "switch", name)
+	case "delete":
+		_, err = g.tools.gitChange(ctx, workspaceDir, "branch", "-d", name)
+	default:
+		return nil, fmt.Errorf("unknown branch action: %s", action)
+	}
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	out, err := g.tools.git(ctx, workspaceDir, "branch", "--format=%(HEAD) %(refname:short)")
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	current := ""
+	branches := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		branch := line[2:]
+		if line[0] == '*' {
+			current = branch
+		}
+		branches = append(branches, branch)
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"current": current, "branches": branches},
+	}, nil
+}
+
+// handleCommit commits staged changes ("all" stages everything first). The
+// commit message is generated from the staged diff unless "message" is set.
+func (g *GitAgentImpl) handleCommit(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	if all, _ := task.Data["all"].(bool); all {
+		if _, err := g.tools.gitChange(ctx, workspaceDir, "add", "-A"); err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+	}
+
+	diff, err := g.tools.git(ctx, workspaceDir, "diff", "--cached", "--no-color")
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	if strings.TrimSpace(diff) == "" {
+		return &TaskResult{Success: false, Error: "nothing staged to commit"}, nil
+	}
+
+	message, _ := task.Data["message"].(string)
+	generated := false
+	if message == "" {
+		message, err = g.generateCommitMessage(ctx, diff)
+		if err != nil {
+			return nil, err
+		}
+		generated = true
+	}
+
+	if _, err := g.tools.gitChange(ctx, workspaceDir, "commit", "-m", message); err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	hash, _ := g.tools.git(ctx, workspaceDir, "rev-parse", "HEAD")
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"hash": hash, "message": message, "generated_message": generated},
+	}, nil
+}
+
+// generateCommitMessage asks the LLM to describe a staged diff
+func (g *GitAgentImpl) generateCommitMessage(ctx context.Context, diff string) (string, error) {
+	prompt := fmt.Sprintf(`Write a git commit message for this staged diff.
+Use a short imperative subject line (at most 72 characters), optionally followed by a blank line and a brief body.
+Respond with only the commit message.
+
+%s`, truncateString(diff, maxCommitDiffChars))
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an experienced developer writing clear, conventional git commit messages."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	message, err := g.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate commit message: %w", err)
+	}
+	message = strings.Trim(strings.TrimSpace(message), "`")
+	if message == "" {
+		return "", fmt.Errorf("LLM returned an empty commit message")
+	}
+	return strings.TrimSpace(message), nil
+}
+
+func (g *GitAgentImpl) handleStash(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	action, _ := task.Data["action"].(string)
+
+	var args []string
+	switch action {
+	case "", "push":
+		args = []string{"stash", "push", "--include-untracked"}
+		if message, ok := task.Data["message"].(string); ok && message != "" {
+			args = append(args, "-m", message)
+		}
+	case "pop", "apply", "drop":
+		args = []string{"stash", action}
+		if ref, ok := task.Data["ref"].(string); ok && ref != "" {
+			if err := checkGitRef(ref); err != nil {
+				return nil, err
+			}
+			args = append(args, ref)
+		}
+	case "list":
+		args = []string{"stash", "list"}
+	default:
+		return nil, fmt.Errorf("unknown stash action: %s", action)
+	}
+
+	run := g.tools.gitChange
+	if action == "list" {
+		run = g.tools.git
+	}
+	out, err := run(ctx, workspaceDir, args...)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"action": args[1], "output": out},
+	}, nil
+}
+
+func (g *GitAgentImpl) handleLog(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	limit := defaultGitLogEntries
+	if n, ok := task.Data["limit"].(float64); ok && n > 0 {
+		limit = int(n)
+	}
+	args := []string{"log", fmt.Sprintf("-n%d", limit), "--pretty=format:%H%x1f%an%x1f%aI%x1f%s"}
+	if ref, ok := task.Data["ref"].(string); ok && ref != "" {
+		if err := checkGitRef(ref); err != nil {
+			return nil, err
+		}
+		args = append(args, ref)
+	}
+	if path, ok := task.Data["path"].(string); ok && path != "" {
+		args = append(args, "--", path)
+	}
+
+	out, err := g.tools.git(ctx, workspaceDir, args...)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	entries := []GitLogEntry{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		entries = append(entries, GitLogEntry{Hash: fields[0], Author: fields[1], Date: fields[2], Subject: fields[3]})
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"commits": entries},
+	}, nil
+}
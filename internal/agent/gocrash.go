@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// GoFrame is one frame of a Go stack trace
+type GoFrame struct {
+	Function    string `json:"function"`
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	InWorkspace bool   `json:"in_workspace"`
+}
+
+// GoroutineStack is the stack of one goroutine in a panic dump
+type GoroutineStack struct {
+	ID     int       `json:"id"`
+	State  string    `json:"state"`
+	Frames []GoFrame `json:"frames"`
+}
+
+// RaceAccess is one side of a data race reported by the race detector
+type RaceAccess struct {
+	Operation string    `json:"operation"`
+	Goroutine int       `json:"goroutine"`
+	Frames    []GoFrame `json:"frames"`
+}
+
+// GoCrash is a parsed Go panic, fatal error or data race report
+type GoCrash struct {
+	Kind       string           `json:"kind"` // panic, fatal, race
+	Message    string           `json:"message"`
+	Goroutines []GoroutineStack `json:"goroutines,omitempty"`
+	Race       []RaceAccess     `json:"race,omitempty"`
+}
+
+var (
+	goPanicLine     = regexp.MustCompile(`(?m)^(panic|fatal error): (.*)$`)
+	goGoroutineHead = regexp.MustCompile(`^goroutine (\d+) \[([^\]]+)\]:`)
+	goFrameFile     = regexp.MustCompile(`^\s+(\S+\.go):(\d+)`)
+	goRaceHeader    = regexp.MustCompile(`^(Previous )?(Read|Write|read|write) at 0x[0-9a-f]+ by (goroutine (\d+)|main goroutine):`)
+)
+
+// ParseGoCrash parses a Go panic or race detector report. It returns false
+// if the output contains neither.
+func ParseGoCrash(output string) (*GoCrash, bool) {
+	lines := strings.Split(output, "\n")
+
+	if strings.Contains(output, "WARNING: DATA RACE") {
+		crash := &GoCrash{Kind: "race", Message: "data race"}
+		for i := 0; i < len(lines); i++ {
+			m := goRaceHeader.FindStringSubmatch(lines[i])
+			if m == nil {
+				continue
+			}
+			access := RaceAccess{Operation: strings.ToLower(strings.TrimSpace(m[1] + m[2]))}
+			access.Goroutine, _ = strconv.Atoi(m[4])
+			access.Frames, i = parseGoFrames(lines, i+1)
+			crash.Race = append(crash.Race, access)
+		}
+		return crash, len(crash.Race) > 0
+	}
+
+	m := goPanicLine.FindStringSubmatch(output)
+	if m == nil || !strings.Contains(output, "goroutine ") {
+		return nil, false
+	}
+	crash := &GoCrash{Kind: "panic", Message: m[2]}
+	if m[1] == "fatal error" {
+		crash.Kind = "fatal"
+	}
+	for i := 0; i < len(lines); i++ {
+		head := goGoroutineHead.FindStringSubmatch(lines[i])
+		if head == nil {
+			continue
+		}
+		stack := GoroutineStack{State: head[2]}
+		stack.ID, _ = strconv.Atoi(head[1])
+		stack.Frames, i = parseGoFrames(lines, i+1)
+		crash.Goroutines = append(crash.Goroutines, stack)
+	}
+	return crash, true
+}
+
+// parseGoFrames reads function/file line pairs starting at lines[start]
+// and returns the frames and the index of the last consumed line
+func parseGoFrames(lines []string, start int) ([]GoFrame, int) {
+	var frames []GoFrame
+	i := start
+	for ; i+1 < len(lines); i += 2 {
+		function := strings.TrimSpace(lines[i])
+		file := goFrameFile.FindStringSubmatch(lines[i+1])
+		if function == "" || file == nil {
+			break
+		}
+		line, _ := strconv.Atoi(file[2])
+		frames = append(frames, GoFrame{Function: function, File: file[1], Line: line})
+	}
+	return frames, i
+}
+
+// mapGoFrames resolves frame files to workspace paths. Binaries built in
+// another directory (CI, containers) are matched by their path suffix.
+func mapGoFrames(frames []GoFrame, workspaceDir string) {
+	for i := range frames {
+		if path, ok := resolveInWorkspace(workspaceDir, frames[i].File); ok && fileExistsAt(path) {
+			frames[i].File, frames[i].InWorkspace = path, true
+			continue
+		}
+		if path := findBySuffix(workspaceDir, frames[i].File); path != "" {
+			frames[i].File, frames[i].InWorkspace = path, true
+		}
+	}
+}
+
+// findBySuffix looks for a workspace file ending in the last two path
+// elements of file (package directory and file name)
+func findBySuffix(workspaceDir, file string) string {
+	file = filepath.ToSlash(file)
+	if strings.Contains(file, "/go/pkg/mod/") || strings.Contains(file, "/src/runtime/") {
+		return ""
+	}
+	parts := strings.Split(file, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	suffix := string(filepath.Separator) + filepath.Join(parts[len(parts)-2], parts[len(parts)-1])
+	root := absPath(workspaceDir)
+	if fileExistsAt(filepath.Join(root, parts[len(parts)-1])) && filepath.Base(root) == parts[len(parts)-2] {
+		return filepath.Join(root, parts[len(parts)-1])
+	}
+
+	var found string
+	filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || found != "" {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() {
+			if path != root && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, suffix) {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// fileExistsAt reports whether path is an existing regular file
+func fileExistsAt(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// goCrashAnalysis is the LLM's structured answer for a crash
+type goCrashAnalysis struct {
+	Explanation string      `json:"explanation"`
+	Patches     []FilePatch `json:"patches"`
+}
+
+// maxCrashFrames bounds the workspace frames whose source goes into the prompt
+const maxCrashFrames = 4
+
+// analyzeGoCrash explains a Go panic or data race and proposes a targeted patch
+func (d *DebugAgentImpl) analyzeGoCrash(ctx context.Context, task *Task, crash *GoCrash, errorOutput, workspaceDir string) (*TaskResult, error) {
+	var stacks [][]GoFrame
+	for i := range crash.Goroutines {
+		mapGoFrames(crash.Goroutines[i].Frames, workspaceDir)
+		stacks = append(stacks, crash.Goroutines[i].Frames)
+	}
+	for i := range crash.Race {
+		mapGoFrames(crash.Race[i].Frames, workspaceDir)
+		stacks = append(stacks, crash.Race[i].Frames)
+	}
+
+	var source strings.Builder
+	injections := detectInjection("crash output", errorOutput)
+	shown := make(map[string]bool)
+	for _, frames := range stacks {
+		for _, frame := range frames {
+			key := fmt.Sprintf("%s:%d", frame.File, frame.Line)
+			if !frame.InWorkspace || shown[key] || len(shown) >= maxCrashFrames {
+				continue
+			}
+			content, err := fileManagerFor(ctx, d.fileManager).ReadFile(frame.File)
+			if err != nil {
+				continue
+			}
+			shown[key] = true
+			snippet := snippetAround(content, frame.Line, snippetContextLines)
+			injections = append(injections, detectInjection(key, snippet)...)
+			fmt.Fprintf(&source, "// %s (%s)\n%s\n", key, frame.Function, fenceUntrusted(key, snippet))
+		}
+	}
+
+	crashJSON, _ := json.MarshalIndent(crash, "", "  ")
+	prompt := fmt.Sprintf(`A Go program crashed with a %s.
+
+Raw output:
+%s
+
+Parsed report (frames marked in_workspace belong to the project):
+%s
+
+Project source at the crashing frames (error line marked with >):
+%s
+
+Respond with only JSON: {"explanation": "<root cause and why it happens>", "patches": [{"path": "...", "search": "<exact existing text>", "replace": "<new text>"}]}.
+For data races, fix the synchronization rather than removing the concurrency.`, crash.Kind, fenceUntrusted("crash output", errorOutput), crashJSON, source.String())
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert Go engineer who diagnoses panics and data races from stack traces."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	response, err := d.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze crash: %w", err)
+	}
+
+	var analysis goCrashAnalysis
+	if err := json.Unmarshal([]byte(extractJSON(response)), &analysis); err != nil {
+		// Fall back to the raw answer as the explanation
+		analysis.Explanation = response
+	}
+
+	debug := &DebugResult{
+		Analysis:          analysis.Explanation,
+		Crash:             crash,
+		Patches:           analysis.Patches,
+		InjectionWarnings: injections,
+	}
+	result := &TaskResult{Success: true, Data: debug}
+	if len(injections) > 0 {
+		d.warnInjections(injections)
+	}
+
+	if apply, _ := task.Data["apply"].(bool); apply && len(analysis.Patches) > 0 {
+		backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID)
+		applied, err := ApplyPatches(fileManagerFor(ctx, d.fileManager), workspaceDir, backupDir, analysis.Patches)
+		if err != nil {
+			result.Success = false
+			result.Error = fmt.Sprintf("failed to apply fix: %v", err)
+			return result, nil
+		}
+		debug.Applied = applied
+	}
+	return result, nil
+}
@@ -0,0 +1,647 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goPackage is a parsed and type-checked Go package
+type goPackage struct {
+	dir   string
+	pkg   *types.Package
+	files []*ast.File
+	info  *types.Info
+}
+
+// goLoader parses and type-checks packages of a module, sharing one file set
+// and source importer so dependencies are only checked once
+type goLoader struct {
+	fset     *token.FileSet
+	importer *sourceImporter
+}
+
+// newGoLoader creates a loader for the module containing root
+func newGoLoader(root string) *goLoader {
+	fset := token.NewFileSet()
+	ctxt := build.Default
+	// go/build resolves module imports by running `go list` in ctxt.Dir,
+	// not in the importing package's directory
+	ctxt.Dir = absPath(root)
+	return &goLoader{
+		fset: fset,
+		importer: &sourceImporter{
+			ctxt:     ctxt,
+			fset:     fset,
+			packages: make(map[string]*types.Package),
+		},
+	}
+}
+
+// sourceImporter type-checks imported packages from source, skipping
+// function bodies since only their exported API is needed
+type sourceImporter struct {
+	ctxt     build.Context
+	fset     *token.FileSet
+	packages map[string]*types.Package
+}
+
+func (s *sourceImporter) Import(path string) (*types.Package, error) {
+	return s.ImportFrom(path, s.ctxt.Dir, 0)
+}
+
+func (s *sourceImporter) ImportFrom(path, dir string, _ types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	bp, err := s.ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := s.packages[bp.ImportPath]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	s.packages[bp.ImportPath] = nil
+
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		file, err := parser.ParseFile(s.fset, filepath.Join(bp.Dir, name), nil, 0)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	config := types.Config{
+		Importer:         s,
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(error) {},
+	}
+	pkg, _ := config.Check(bp.ImportPath, s.fset, files, nil)
+	s.packages[bp.ImportPath] = pkg
+	return pkg, nil
+}
+
+// load type-checks the packages in dir, including test packages. Type
+// errors are tolerated so partially broken code can still be refactored.
+func (l *goLoader) load(dir string) ([]*goPackage, error) {
+	dir = absPath(dir)
+	parsed, err := parser.ParseDir(l.fset, dir, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", dir, err)
+	}
+
+	names := make([]string, 0, len(parsed))
+	for name := range parsed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pkgs []*goPackage
+	for _, name := range names {
+		var files []*ast.File
+		for _, file := range parsed[name].Files {
+			files = append(files, file)
+		}
+		info := &types.Info{
+			Defs: make(map[*ast.Ident]types.Object),
+			Uses: make(map[*ast.Ident]types.Object),
+		}
+		config := types.Config{Importer: l.importer, Error: func(error) {}}
+		pkg, _ := config.Check(name, l.fset, files, info)
+		pkgs = append(pkgs, &goPackage{dir: dir, pkg: pkg, files: files, info: info})
+	}
+	return pkgs, nil
+}
+
+// declKey identifies an object by its declaration position, which is stable
+// across separate type-checks of the same source
+func (l *goLoader) declKey(obj types.Object) string {
+	pos := l.fset.Position(obj.Pos())
+	return fmt.Sprintf("%s:%d", pos.Filename, pos.Offset)
+}
+
+// goPackageDirs lists the directories under root that contain Go files
+func goPackageDirs(root string) ([]string, error) {
+	seen := make(map[string]bool)
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (skipDir(d.Name()) || strings.HasPrefix(d.Name(), ".") || d.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if dir := filepath.Dir(path); strings.HasSuffix(path, ".go") && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// RenameGoSymbol renames the identifier name found in file (at line, if
+// non-zero) and every reference to it in the workspace. It returns content
+// patches for the changed files and the number of occurrences renamed.
+// Methods implementing an interface are renamed on their own; the
+// interface and other implementations are left untouched.
+func RenameGoSymbol(workspaceDir, file string, line int, name, newName string) ([]FilePatch, int, error) {
+	if !token.IsIdentifier(newName) {
+		return nil, 0, fmt.Errorf("%q is not a valid Go identifier", newName)
+	}
+	loader := newGoLoader(workspaceDir)
+	file = absPath(file)
+
+	pkgs, err := loader.load(filepath.Dir(file))
+	if err != nil {
+		return nil, 0, err
+	}
+	obj := findGoObject(loader.fset, pkgs, file, line, name)
+	if obj == nil {
+		return nil, 0, fmt.Errorf("symbol %s not found in %s", name, file)
+	}
+	if obj.Pkg() == nil {
+		return nil, 0, fmt.Errorf("cannot rename predeclared identifier %s", name)
+	}
+	if parent := obj.Parent(); parent != nil && parent.Lookup(newName) != nil {
+		return nil, 0, fmt.Errorf("%s is already declared in the scope of %s", newName, name)
+	}
+	target := loader.declKey(obj)
+
+	// Exported names, fields and methods can be referenced from any package
+	dirs := []string{filepath.Dir(file)}
+	if obj.Exported() || obj.Parent() == nil {
+		if dirs, err = goPackageDirs(absPath(workspaceDir)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	edits := make(map[string]map[int]bool)
+	for _, dir := range dirs {
+		if dir != filepath.Dir(file) && !dirMentions(dir, name) {
+			continue
+		}
+		dirPkgs := pkgs
+		if dir != filepath.Dir(file) {
+			if dirPkgs, err = loader.load(dir); err != nil {
+				return nil, 0, err
+			}
+		}
+		for _, p := range dirPkgs {
+			for _, idents := range []map[*ast.Ident]types.Object{p.info.Defs, p.info.Uses} {
+				for ident, o := range idents {
+					if o == nil || ident.Name != name || loader.declKey(o) != target {
+						continue
+					}
+					pos := loader.fset.Position(ident.Pos())
+					if edits[pos.Filename] == nil {
+						edits[pos.Filename] = make(map[int]bool)
+					}
+					edits[pos.Filename][pos.Offset] = true
+				}
+			}
+		}
+	}
+
+	var patches []FilePatch
+	count := 0
+	for path, offsets := range edits {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		sorted := make([]int, 0, len(offsets))
+		for offset := range offsets {
+			sorted = append(sorted, offset)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+		for _, offset := range sorted {
+			src = append(src[:offset], append([]byte(newName), src[offset+len(name):]...)...)
+		}
+		count += len(sorted)
+		patches = append(patches, FilePatch{Path: path, Content: string(src)})
+	}
+	sort.Slice(patches, func(i, j int) bool { return patches[i].Path < patches[j].Path })
+	return patches, count, nil
+}
+
+// findGoObject resolves the identifier name in file, preferring the one on line
+func findGoObject(fset *token.FileSet, pkgs []*goPackage, file string, line int, name string) types.Object {
+	var fallback types.Object
+	for _, p := range pkgs {
+		for _, idents := range []map[*ast.Ident]types.Object{p.info.Defs, p.info.Uses} {
+			for ident, obj := range idents {
+				if obj == nil || ident.Name != name {
+					continue
+				}
+				pos := fset.Position(ident.Pos())
+				if pos.Filename != file {
+					continue
+				}
+				if line == 0 || pos.Line == line {
+					return obj
+				}
+				if fallback == nil {
+					fallback = obj
+				}
+			}
+		}
+	}
+	if line != 0 {
+		return nil
+	}
+	return fallback
+}
+
+// dirMentions reports whether any Go file in dir contains word
+func dirMentions(dir, word string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, match := range matches {
+		if data, err := os.ReadFile(match); err == nil && bytes.Contains(data, []byte(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractGoFunction moves the statements between startLine and endLine of
+// a function in file into a new function called name. Variables read by
+// the statements become parameters; variables they declare that are used
+// later, or outer variables they assign, become results.
+func ExtractGoFunction(file string, startLine, endLine int, name string) (*FilePatch, error) {
+	if !token.IsIdentifier(name) {
+		return nil, fmt.Errorf("%q is not a valid Go identifier", name)
+	}
+	loader := newGoLoader(filepath.Dir(file))
+	file = absPath(file)
+	pkgs, err := loader.load(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg *goPackage
+	var astFile *ast.File
+	for _, p := range pkgs {
+		for _, f := range p.files {
+			if loader.fset.Position(f.Pos()).Filename == file {
+				pkg, astFile = p, f
+			}
+		}
+	}
+	if astFile == nil {
+		return nil, fmt.Errorf("%s is not part of a Go package", file)
+	}
+	if pkg.pkg.Scope().Lookup(name) != nil {
+		return nil, fmt.Errorf("%s is already declared in package %s", name, pkg.pkg.Name())
+	}
+
+	fset := loader.fset
+	lineOf := func(pos token.Pos) int { return fset.Position(pos).Line }
+
+	var fn *ast.FuncDecl
+	for _, decl := range astFile.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d.Body != nil && lineOf(d.Body.Lbrace) <= startLine && lineOf(d.Body.Rbrace) >= endLine {
+			fn = d
+		}
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("lines %d-%d are not inside a function body", startLine, endLine)
+	}
+	if fn.Type.TypeParams != nil {
+		return nil, fmt.Errorf("extracting from generic functions is not supported")
+	}
+
+	stmts := selectStatements(fset, fn.Body, startLine, endLine)
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("lines %d-%d do not cover whole statements of one block", startLine, endLine)
+	}
+	start, end := stmts[0].Pos(), stmts[len(stmts)-1].End()
+	if err := checkExtractable(stmts); err != nil {
+		return nil, err
+	}
+
+	within := func(pos token.Pos) bool { return pos >= start && pos < end }
+	inFunc := func(pos token.Pos) bool { return pos >= fn.Pos() && pos < fn.End() }
+
+	// Parameters: function-local variables declared outside the selection
+	var params []*types.Var
+	seen := make(map[*types.Var]bool)
+	assigned := make(map[*types.Var]bool)
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.Ident:
+				v, ok := pkg.info.Uses[n].(*types.Var)
+				if ok && !v.IsField() && inFunc(v.Pos()) && !within(v.Pos()) && !seen[v] {
+					seen[v] = true
+					params = append(params, v)
+				}
+			case *ast.AssignStmt:
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok {
+						if v, ok := pkg.info.Uses[id].(*types.Var); ok {
+							assigned[v] = true
+						}
+					}
+				}
+			case *ast.IncDecStmt:
+				if id, ok := n.X.(*ast.Ident); ok {
+					if v, ok := pkg.info.Uses[id].(*types.Var); ok {
+						assigned[v] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	// Results: outer variables assigned in the selection, then variables
+	// declared in it and used after it
+	var outerResults, newResults []*types.Var
+	for _, v := range params {
+		if assigned[v] {
+			outerResults = append(outerResults, v)
+		}
+	}
+	usedAfter := make(map[types.Object]bool)
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Pos() >= end {
+			usedAfter[pkg.info.Uses[id]] = true
+		}
+		return true
+	})
+	for ident, obj := range pkg.info.Defs {
+		v, ok := obj.(*types.Var)
+		if ok && within(ident.Pos()) && usedAfter[v] && declaredAtTopLevel(v, stmts) {
+			newResults = append(newResults, v)
+		}
+	}
+	sort.Slice(newResults, func(i, j int) bool { return newResults[i].Pos() < newResults[j].Pos() })
+
+	qualifier := types.RelativeTo(pkg.pkg)
+	var paramList, resultTypes, resultNames []string
+	var callArgs []string
+	for _, v := range params {
+		paramList = append(paramList, v.Name()+" "+types.TypeString(v.Type(), qualifier))
+		callArgs = append(callArgs, v.Name())
+	}
+	results := append(append([]*types.Var{}, outerResults...), newResults...)
+	for _, v := range results {
+		resultTypes = append(resultTypes, types.TypeString(v.Type(), qualifier))
+		resultNames = append(resultNames, v.Name())
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	body := string(src[offset(start):offset(end)])
+
+	var newFunc strings.Builder
+	fmt.Fprintf(&newFunc, "func %s(%s)", name, strings.Join(paramList, ", "))
+	switch len(resultTypes) {
+	case 0:
+	case 1:
+		newFunc.WriteString(" " + resultTypes[0])
+	default:
+		newFunc.WriteString(" (" + strings.Join(resultTypes, ", ") + ")")
+	}
+	newFunc.WriteString(" {\n" + body + "\n")
+	if len(results) > 0 {
+		newFunc.WriteString("return " + strings.Join(resultNames, ", ") + "\n")
+	}
+	newFunc.WriteString("}\n")
+
+	call := fmt.Sprintf("%s(%s)", name, strings.Join(callArgs, ", "))
+	switch {
+	case len(results) == 0:
+	case len(outerResults) == 0:
+		call = strings.Join(resultNames, ", ") + " := " + call
+	case len(newResults) == 0:
+		call = strings.Join(resultNames, ", ") + " = " + call
+	default:
+		var decls strings.Builder
+		for _, v := range newResults {
+			fmt.Fprintf(&decls, "var %s %s\n", v.Name(), types.TypeString(v.Type(), qualifier))
+		}
+		call = decls.String() + strings.Join(resultNames, ", ") + " = " + call
+	}
+
+	var out bytes.Buffer
+	out.Write(src[:offset(start)])
+	out.WriteString(call)
+	out.Write(src[offset(end):offset(fn.End())])
+	out.WriteString("\n\n" + newFunc.String())
+	out.Write(src[offset(fn.End()):])
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("extracted code does not parse: %w", err)
+	}
+	return &FilePatch{Path: file, Content: string(formatted)}, nil
+}
+
+// selectStatements returns the statements of the innermost block that lie
+// entirely within the line range, or nil if the range cuts a statement
+func selectStatements(fset *token.FileSet, body *ast.BlockStmt, startLine, endLine int) []ast.Stmt {
+	var selected []ast.Stmt
+	ast.Inspect(body, func(n ast.Node) bool {
+		block, ok := n.(*ast.BlockStmt)
+		if !ok {
+			return true
+		}
+		// Blocks nested in the selected statements are moved with them
+		if fset.Position(block.Lbrace).Line >= startLine && fset.Position(block.Rbrace).Line <= endLine {
+			return false
+		}
+		var inRange []ast.Stmt
+		for _, stmt := range block.List {
+			first, last := fset.Position(stmt.Pos()).Line, fset.Position(stmt.End()).Line
+			switch {
+			case first >= startLine && last <= endLine:
+				inRange = append(inRange, stmt)
+			case last >= startLine && first <= endLine:
+				// Partially covered; an inner block may still match
+				return true
+			}
+		}
+		if len(inRange) > 0 {
+			selected = inRange
+		}
+		return true
+	})
+	return selected
+}
+
+// checkExtractable rejects statements whose control flow would change when
+// moved into another function
+func checkExtractable(stmts []ast.Stmt) error {
+	var err error
+	var walk func(n ast.Node, loops, breakables int) bool
+	walk = func(n ast.Node, loops, breakables int) bool {
+		ast.Inspect(n, func(c ast.Node) bool {
+			if err != nil || c == nil {
+				return false
+			}
+			if c == n {
+				return true
+			}
+			switch c := c.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				err = fmt.Errorf("the selection contains a return statement")
+			case *ast.DeferStmt:
+				err = fmt.Errorf("the selection contains a defer statement")
+			case *ast.BranchStmt:
+				switch {
+				case c.Label != nil || c.Tok == token.GOTO || c.Tok == token.FALLTHROUGH:
+					err = fmt.Errorf("the selection contains a %s statement", c.Tok)
+				case c.Tok == token.CONTINUE && loops == 0, c.Tok == token.BREAK && breakables == 0:
+					err = fmt.Errorf("the selection contains a %s out of the selected code", c.Tok)
+				}
+			case *ast.ForStmt, *ast.RangeStmt:
+				walk(c, loops+1, breakables+1)
+				return false
+			case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				walk(c, loops, breakables+1)
+				return false
+			}
+			return true
+		})
+		return err == nil
+	}
+	for _, stmt := range stmts {
+		// Wrap so the statement itself is visited as a child
+		if !walk(&ast.BlockStmt{List: []ast.Stmt{stmt}}, 0, 0) {
+			return err
+		}
+	}
+	return nil
+}
+
+// declaredAtTopLevel reports whether v is declared by one of stmts rather
+// than inside a nested block, where it wouldn't be visible after them
+func declaredAtTopLevel(v *types.Var, stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range s.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Pos() == v.Pos() {
+					return true
+				}
+			}
+		case *ast.DeclStmt:
+			if s.Pos() <= v.Pos() && v.Pos() < s.End() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MoveGoPackage plans moving the package in oldDir to newDir (both relative
+// to the module root workspaceDir), rewriting imports of it and its
+// subpackages across the module. It returns the import patches; the caller
+// renames the directory after applying them.
+func MoveGoPackage(workspaceDir, oldDir, newDir string) ([]FilePatch, string, string, error) {
+	root := absPath(workspaceDir)
+	modulePath, err := goModulePath(root)
+	if err != nil {
+		return nil, "", "", err
+	}
+	from, ok := resolveInWorkspace(root, oldDir)
+	if !ok {
+		return nil, "", "", fmt.Errorf("%s is outside the workspace", oldDir)
+	}
+	to, ok := resolveInWorkspace(root, newDir)
+	if !ok {
+		return nil, "", "", fmt.Errorf("%s is outside the workspace", newDir)
+	}
+	if info, err := os.Stat(from); err != nil || !info.IsDir() {
+		return nil, "", "", fmt.Errorf("package directory %s does not exist", oldDir)
+	}
+	if _, err := os.Stat(to); err == nil {
+		return nil, "", "", fmt.Errorf("destination %s already exists", newDir)
+	}
+
+	relFrom, _ := filepath.Rel(root, from)
+	relTo, _ := filepath.Rel(root, to)
+	oldImport := modulePath + "/" + filepath.ToSlash(relFrom)
+	newImport := modulePath + "/" + filepath.ToSlash(relTo)
+
+	dirs, err := goPackageDirs(root)
+	if err != nil {
+		return nil, "", "", err
+	}
+	fset := token.NewFileSet()
+	var patches []FilePatch
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		for _, path := range matches {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return nil, "", "", err
+			}
+			if !bytes.Contains(src, []byte(oldImport)) {
+				continue
+			}
+			f, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+
+			updated := src
+			// Rewrite from the end so earlier offsets stay valid
+			for i := len(f.Imports) - 1; i >= 0; i-- {
+				spec := f.Imports[i]
+				importPath := strings.Trim(spec.Path.Value, "\"`")
+				if importPath != oldImport && !strings.HasPrefix(importPath, oldImport+"/") {
+					continue
+				}
+				rewritten := fmt.Sprintf("%q", newImport+strings.TrimPrefix(importPath, oldImport))
+				start, end := fset.Position(spec.Path.Pos()).Offset, fset.Position(spec.Path.End()).Offset
+				updated = append(updated[:start:start], append([]byte(rewritten), updated[end:]...)...)
+			}
+			if !bytes.Equal(updated, src) {
+				if formatted, err := format.Source(updated); err == nil {
+					updated = formatted
+				}
+				patches = append(patches, FilePatch{Path: path, Content: string(updated)})
+			}
+		}
+	}
+	return patches, from, to, nil
+}
+
+// goModulePath reads the module path from root/go.mod
+func goModulePath(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("no go.mod in %s: %w", root, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\""), nil
+		}
+	}
+	return "", fmt.Errorf("go.mod in %s has no module directive", root)
+}
@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// RegressionReport describes the build/test run made after applying a fix
+type RegressionReport struct {
+	Command        string   `json:"command"`
+	BaselinePassed bool     `json:"baseline_passed"`
+	Passed         bool     `json:"passed"`
+	NewFailures    []string `json:"new_failures,omitempty"`
+	Diff           string   `json:"diff,omitempty"`
+	RolledBack     bool     `json:"rolled_back"`
+	Output         string   `json:"output,omitempty"`
+}
+
+// Regressed reports whether the fix made the build or tests worse
+func (r *RegressionReport) Regressed() bool {
+	return !r.Passed && (r.BaselinePassed || len(r.NewFailures) > 0)
+}
+
+// testFailureLine matches per-test failure lines of common test runners
+var testFailureLine = regexp.MustCompile(`(?m)^\s*(--- FAIL: \S+|FAIL\s+\S+|FAILED \S+|test \S+ \.\.\. FAILED|✕ .+|● .+)`)
+
+// regressionGuard holds the pre-fix build/test state a fix is compared against
+type regressionGuard struct {
+	command  string
+	baseline *Command
+	failures map[string]bool
+}
+
+// newRegressionGuard runs the project's build/test command (task field
+// "guard_command", else the detected test command) before a fix is
+// applied. It returns nil when the guard is disabled with
+// "regression_guard": false or no command is known, and an error when the
+// guard command would need approval: the task can only wait for that of
+// its verification command.
+func (d *DebugAgentImpl) newRegressionGuard(ctx context.Context, task *Task, workspaceDir string) (*regressionGuard, error) {
+	if enabled, ok := task.Data["regression_guard"].(bool); ok && !enabled {
+		return nil, nil
+	}
+	command, _ := task.Data["guard_command"].(string)
+	if command != "" {
+		if risk := d.terminal.safety.Check(ctx, command); d.terminal.needsApproval(risk.Level) {
+			return nil, fmt.Errorf("guard_command requires approval (%s risk) and cannot guard a fix", risk.Level)
+		}
+	} else {
+		detected, err := detectTestCommand(workspaceDir)
+		if err != nil {
+			return nil, nil
+		}
+		command = detected
+	}
+
+	baseline, err := d.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil {
+		d.logger.Warn("Skipping regression guard, baseline run failed", zap.String("command", command), zap.Error(err))
+		return nil, nil
+	}
+	return &regressionGuard{
+		command:  command,
+		baseline: baseline,
+		failures: failureSignatures(baseline.Output + "\n" + baseline.Error),
+	}, nil
+}
+
+// check re-runs the guard command after a fix and rolls the fix back if
+// new failures appeared
+func (g *regressionGuard) check(ctx context.Context, commandExec CommandExecutor, tools *ToolRunner, applied *AppliedPatchSet, workspaceDir string) (*RegressionReport, error) {
+	report := &RegressionReport{
+		Command:        g.command,
+		BaselinePassed: g.baseline.Status == "completed",
+		Diff:           applied.Diff(ctx, tools),
+	}
+
+	run, err := commandExec.ExecuteCommand(ctx, g.command, workspaceDir, CommandOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run regression guard: %w", err)
+	}
+	report.Passed = run.Status == "completed"
+	if report.Passed {
+		return report, nil
+	}
+
+	for signature := range failureSignatures(run.Output + "\n" + run.Error) {
+		if !g.failures[signature] {
+			report.NewFailures = append(report.NewFailures, signature)
+		}
+	}
+	sort.Strings(report.NewFailures)
+
+	if report.Regressed() {
+		report.Output = truncateString(run.Output+"\n"+run.Error, 4096)
+		if err := applied.Rollback(); err != nil {
+			return report, err
+		}
+		report.RolledBack = true
+	}
+	return report, nil
+}
+
+// failureSignatures extracts comparable failure identifiers from build or
+// test output. Line numbers are left out since a fix shifts them.
+func failureSignatures(output string) map[string]bool {
+	signatures := make(map[string]bool)
+	for _, loc := range ParseErrorLocations(output) {
+		signatures[fmt.Sprintf("%s: %s", filepath.Base(loc.File), loc.Message)] = true
+	}
+	for _, match := range testFailureLine.FindAllStringSubmatch(output, -1) {
+		signatures[strings.TrimSpace(match[1])] = true
+	}
+	return signatures
+}
+
+// Diff returns a unified diff of the patched files against their pre-fix
+// backups, made by git run with tools, or an empty string if git is
+// unavailable
+func (s *AppliedPatchSet) Diff(ctx context.Context, tools *ToolRunner) string {
+	if !onPath("git") {
+		return ""
+	}
+
+	var diff strings.Builder
+	for _, path := range s.Files {
+		before := os.DevNull
+		if original := s.originals[path]; original != nil {
+			tmp, err := os.CreateTemp("", "spilot-prefix-*")
+			if err != nil {
+				continue
+			}
+			tmp.WriteString(*original)
+			tmp.Close()
+			defer os.Remove(tmp.Name())
+			before = tmp.Name()
+		}
+
+		// git diff --no-index exits 1 when the files differ
+		out, _ := tools.run(ctx, "", "git", "diff", "--no-index", "--no-color",
+			"--src-prefix=a/", "--dst-prefix=b/", "--", before, path)
+		if before != os.DevNull {
+			out = []byte(strings.ReplaceAll(string(out), before, path))
+		}
+		diff.Write(out)
+	}
+	return diff.String()
+}
+
+// previewDiff returns a unified diff of a proposed change to path without
+// touching the file, made by git run with tools. before is nil for a new
+// file.
+func previewDiff(ctx context.Context, tools *ToolRunner, path string, before *string, after string) string {
+	if !onPath("git") {
+		return ""
+	}
+
+	write := func(content string) (string, error) {
+		tmp, err := os.CreateTemp("", "spilot-preview-*")
+		if err != nil {
+			return "", err
+		}
+		defer tmp.Close()
+		_, err = tmp.WriteString(content)
+		return tmp.Name(), err
+	}
+
+	from := os.DevNull
+	if before != nil {
+		name, err := write(*before)
+		if err != nil {
+			return ""
+		}
+		defer os.Remove(name)
+		from = name
+	}
+	to, err := write(after)
+	if err != nil {
+		return ""
+	}
+	defer os.Remove(to)
+
+	out, _ := tools.run(ctx, "", "git", "diff", "--no-index", "--no-color",
+		"--src-prefix=a/", "--dst-prefix=b/", "--", from, to)
+	// git prints the temporary paths without their leading slash
+	diff := strings.ReplaceAll(string(out), strings.TrimPrefix(to, "/"), path)
+	if from != os.DevNull {
+		diff = strings.ReplaceAll(diff, strings.TrimPrefix(from, "/"), path)
+	}
+	return diff
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrHandoffUnavailable is returned when an agent runs outside a System,
+	// so there is no one to hand work to
+	ErrHandoffUnavailable = errors.New("handoff is not available outside a running system")
+	// ErrHandoffCycle is returned when a handoff would re-enter an agent
+	// type already working on the chain
+	ErrHandoffCycle = errors.New("handoff cycle")
+	// ErrHandoffDepth is returned when the handoff chain is too deep
+	ErrHandoffDepth = errors.New("handoff depth budget exhausted")
+)
+
+type handoffKey struct{}
+
+// handoffChain describes the task an agent is executing and the tasks that
+// handed work down to it
+type handoffChain struct {
+	system *System
+	// agents are the agent types on the chain, the executing one last
+	agents       []AgentType
+	taskID       string
+	workspaceDir string
+	// subtasks numbers the sub-tasks of taskID
+	subtasks *atomic.Int64
+}
+
+// withHandoff records the task being executed so its agent can hand off
+// sub-tasks from within Execute
+func (s *System) withHandoff(ctx context.Context, task *Task) context.Context {
+	chain := &handoffChain{
+		system:       s,
+		taskID:       task.ID,
+		workspaceDir: stringField(task.Data, "workspace_dir"),
+		subtasks:     new(atomic.Int64),
+	}
+	if parent, ok := ctx.Value(handoffKey{}).(*handoffChain); ok {
+		chain.agents = append(chain.agents, parent.agents...)
+	}
+	chain.agents = append(chain.agents, task.Type)
+	return context.WithValue(ctx, handoffKey{}, chain)
+}
+
+// HandOff runs a sub-task on another agent while the calling agent is
+// executing, e.g. the DebugAgent asking the SearchAgent where a symbol is
+// defined. The sub-task inherits the workspace and goes through the
+// system's hooks and events like any task. Handoffs that would re-enter an
+// agent type already on the chain, or exceed the depth budget, are refused.
+func HandOff(ctx context.Context, agentType AgentType, description string, data map[string]interface{}) (*TaskResult, error) {
+	chain, ok := ctx.Value(handoffKey{}).(*handoffChain)
+	if !ok {
+		return nil, ErrHandoffUnavailable
+	}
+	for _, active := range chain.agents {
+		if active == agentType {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrHandoffCycle, handoffPath(chain.agents), agentType)
+		}
+	}
+	if len(chain.agents) > chain.system.handoffDepth {
+		return nil, fmt.Errorf("%w after %s", ErrHandoffDepth, handoffPath(chain.agents))
+	}
+
+	task := &Task{
+		ID:          fmt.Sprintf("%s.%d", chain.taskID, chain.subtasks.Add(1)),
+		Type:        agentType,
+		Description: description,
+		Data:        withOptions(map[string]interface{}{"workspace_dir": chain.workspaceDir}, data),
+		Status:      TaskPending,
+		CreatedAt:   time.Now(),
+	}
+	chain.system.events.Publish(TaskEvent{
+		TaskID: chain.taskID,
+		Type:   EventHandoff,
+		Data:   map[string]interface{}{"subtask_id": task.ID, "agent": string(agentType), "description": description},
+	})
+	return chain.system.ExecuteTask(ctx, task)
+}
+
+func handoffPath(agents []AgentType) string {
+	names := make([]string, len(agents))
+	for i, agent := range agents {
+		names[i] = string(agent)
+	}
+	return strings.Join(names, " -> ")
+}
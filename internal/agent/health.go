@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// llmCheckInterval is how long the result of a provider check is reused,
+// so frequent readiness probes do not use up the provider's rate limit
+const llmCheckInterval = 30 * time.Second
+
+// ModelChecker is implemented by LLM clients that can verify the provider
+// serves a model
+type ModelChecker interface {
+	CheckModel(ctx context.Context, model string) error
+}
+
+// HealthCheck is the outcome of checking one dependency
+type HealthCheck struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	// Cached is set when the result of an earlier check was reused
+	Cached bool `json:"cached,omitempty"`
+}
+
+type healthCache struct {
+	mu      sync.Mutex
+	llm     HealthCheck
+	checked time.Time
+}
+
+// CheckReadiness checks the dependencies needed to serve requests: the LLM
+// provider, the data directory, the workspace and, when enabled, the
+// codebase index store. The system is ready if every check is OK.
+func (s *System) CheckReadiness(ctx context.Context) ([]HealthCheck, bool) {
+	checks := []HealthCheck{s.checkLLM(ctx)}
+	if s.dataDir != "" {
+		checks = append(checks, runCheck("data_dir", func() error { return checkWritable(s.dataDir) }))
+	}
+	workspaceDir := s.workspaceDir
+	if workspaceDir == "" {
+		workspaceDir = "."
+	}
+	checks = append(checks, runCheck("workspace", func() error { return checkWritable(workspaceDir) }))
+	if s.index != nil {
+		checks = append(checks, runCheck("index", func() error { return s.index.Ping(ctx) }))
+	}
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+	return checks, ready
+}
+
+// checkLLM checks that the provider is reachable and serves the current
+// model, reusing a recent result
+func (s *System) checkLLM(ctx context.Context) HealthCheck {
+	checker, ok := s.llmClient.(ModelChecker)
+	if !ok {
+		return HealthCheck{Name: "llm", OK: true}
+	}
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if !s.health.checked.IsZero() && time.Since(s.health.checked) < llmCheckInterval {
+		check := s.health.llm
+		check.Cached = true
+		return check
+	}
+	check := runCheck("llm", func() error { return checker.CheckModel(ctx, s.llmClient.GetModel()) })
+	// A cancelled probe says nothing about the provider
+	if ctx.Err() == nil {
+		s.health.llm, s.health.checked = check, time.Now()
+	}
+	return check
+}
+
+func runCheck(name string, check func() error) HealthCheck {
+	start := time.Now()
+	err := check()
+	result := HealthCheck{Name: name, OK: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkWritable checks that files can be created in dir
+func checkWritable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	file, err := os.CreateTemp(dir, ".spilot-ready-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
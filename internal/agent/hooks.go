@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// AllAgents registers a hook for every agent type
+const AllAgents AgentType = "*"
+
+// PreTaskHook runs before an agent executes a task. It may adjust the task
+// data; returning an error rejects the task without running it.
+type PreTaskHook func(ctx context.Context, task *Task) error
+
+// PostTaskHook runs after an agent executed a task, with the agent's result
+// (nil if it returned an error) and error. It may modify the result.
+type PostTaskHook func(ctx context.Context, task *Task, result *TaskResult, err error)
+
+// TaskRejectedError reports that a pre-task hook refused a task
+type TaskRejectedError struct {
+	Reason error
+}
+
+func (e *TaskRejectedError) Error() string {
+	return fmt.Sprintf("task rejected: %v", e.Reason)
+}
+
+func (e *TaskRejectedError) Unwrap() error {
+	return e.Reason
+}
+
+// hookRegistry holds task hooks by agent type. Hooks registered for
+// AllAgents run before type-specific ones, each in registration order.
+type hookRegistry struct {
+	mu     sync.RWMutex
+	pre    map[AgentType][]PreTaskHook
+	post   map[AgentType][]PostTaskHook
+	logger *zap.Logger
+}
+
+func newHookRegistry(logger *zap.Logger) *hookRegistry {
+	return &hookRegistry{
+		pre:    make(map[AgentType][]PreTaskHook),
+		post:   make(map[AgentType][]PostTaskHook),
+		logger: logger,
+	}
+}
+
+// AddPreHook registers a hook run before tasks of agentType (or AllAgents)
+func (s *System) AddPreHook(agentType AgentType, hook PreTaskHook) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.pre[agentType] = append(s.hooks.pre[agentType], hook)
+}
+
+// AddPostHook registers a hook run after tasks of agentType (or AllAgents)
+func (s *System) AddPostHook(agentType AgentType, hook PostTaskHook) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.post[agentType] = append(s.hooks.post[agentType], hook)
+}
+
+// runPre runs the pre-task hooks for a task, stopping at the first rejection
+func (h *hookRegistry) runPre(ctx context.Context, task *Task) error {
+	h.mu.RLock()
+	hooks := append(append([]PreTaskHook(nil), h.pre[AllAgents]...), h.pre[task.Type]...)
+	h.mu.RUnlock()
+
+	for _, hook := range hooks {
+		if err := h.callPre(ctx, hook, task); err != nil {
+			return &TaskRejectedError{Reason: err}
+		}
+	}
+	return nil
+}
+
+// runPost runs the post-task hooks for a task
+func (h *hookRegistry) runPost(ctx context.Context, task *Task, result *TaskResult, err error) {
+	h.mu.RLock()
+	hooks := append(append([]PostTaskHook(nil), h.post[AllAgents]...), h.post[task.Type]...)
+	h.mu.RUnlock()
+
+	for _, hook := range hooks {
+		h.callPost(ctx, hook, task, result, err)
+	}
+}
+
+// callPre runs one hook, turning a panic into a rejection so a faulty hook
+// can't take down the server
+func (h *hookRegistry) callPre(ctx context.Context, hook PreTaskHook, task *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error("Pre-task hook panicked", zap.String("task_id", task.ID), zap.Any("panic", r))
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	return hook(ctx, task)
+}
+
+// callPost runs one hook, logging a panic instead of propagating it
+func (h *hookRegistry) callPost(ctx context.Context, hook PostTaskHook, task *Task, result *TaskResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error("Post-task hook panicked", zap.String("task_id", task.ID), zap.Any("panic", r))
+		}
+	}()
+	hook(ctx, task, result, err)
+}
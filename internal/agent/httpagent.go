@@ -0,0 +1,369 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxHTTPBodyBytes caps the response body captured from one request
+	maxHTTPBodyBytes = 256 * 1024
+	// defaultHTTPTimeout bounds a request unless "timeout_seconds" is set
+	defaultHTTPTimeout = 30 * time.Second
+)
+
+// sensitiveHeaders are masked before exchanges are shown to the LLM
+var sensitiveHeaders = map[string]bool{
+	"authorization": true, "proxy-authorization": true, "cookie": true, "set-cookie": true,
+	"x-api-key": true, "x-auth-token": true,
+}
+
+// HTTPRequestSpec describes a request to send
+type HTTPRequestSpec struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// HTTPExchange is a request together with the response it produced
+type HTTPExchange struct {
+	Request    HTTPRequestSpec   `json:"request"`
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	Truncated  bool              `json:"truncated,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+}
+
+// HTTPRequestAgent calls endpoints on allowlisted hosts and turns the
+// captured exchanges into client code or tests
+type HTTPRequestAgentImpl struct {
+	llmClient    LLMClient
+	fileManager  FileManager
+	allowedHosts []string
+	logger       *zap.Logger
+}
+
+// NewHTTPRequestAgent creates a new HTTP request agent. allowedHosts are
+// host names or "*.domain" patterns; "*" allows any host.
+func NewHTTPRequestAgent(llmClient LLMClient, fileManager FileManager, allowedHosts []string, logger *zap.Logger) *HTTPRequestAgentImpl {
+	return &HTTPRequestAgentImpl{
+		llmClient:    llmClient,
+		fileManager:  fileManager,
+		allowedHosts: allowedHosts,
+		logger:       logger,
+	}
+}
+
+// Type returns the agent type
+func (h *HTTPRequestAgentImpl) Type() AgentType {
+	return HTTPRequestAgent
+}
+
+// Execute executes an HTTP task. Operations:
+//   - request (default): send "method", "url", "headers", "body", or a
+//     request described in natural language by "request"
+//   - generate: send "requests" (or a single request as above) and write a
+//     "client" or "test" (per "kind") in "language" to "path"
+func (h *HTTPRequestAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	h.logger.Info("HTTP request agent executing task", zap.String("task_id", task.ID))
+
+	operation := stringField(task.Data, "operation")
+	switch operation {
+	case "", "request":
+		spec, err := h.requestSpec(ctx, task.Data)
+		if err != nil {
+			return nil, err
+		}
+		exchange, err := h.send(ctx, spec, httpTimeout(task.Data))
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error(), Data: Fields{"request": spec}}, nil
+		}
+		return &TaskResult{Success: true, Data: Fields{"exchange": exchange}}, nil
+	case "generate":
+		return h.handleGenerate(ctx, task)
+	default:
+		return nil, fmt.Errorf("unknown http operation: %s", operation)
+	}
+}
+
+// requestSpec builds a request from explicit fields, or asks the LLM to
+// construct one from a natural language "request"
+func (h *HTTPRequestAgentImpl) requestSpec(ctx context.Context, data map[string]interface{}) (*HTTPRequestSpec, error) {
+	if stringField(data, "url") != "" {
+		return specFromData(data)
+	}
+	request := stringField(data, "request")
+	if request == "" {
+		return nil, fmt.Errorf("url or request not found in task data")
+	}
+
+	prompt := fmt.Sprintf(`Construct the HTTP request described here: %s
+Respond with only JSON: {"method": "GET", "url": "<absolute URL>", "headers": {"<name>": "<value>"}, "body": <JSON body or null>}`, request)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You translate descriptions of API calls into exact HTTP requests."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := h.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request: %w", err)
+	}
+	var spec HTTPRequestSpec
+	if err := json.Unmarshal([]byte(extractJSON(response)), &spec); err != nil || spec.URL == "" {
+		return nil, fmt.Errorf("failed to parse request from LLM response: %v", err)
+	}
+	if string(spec.Body) == "null" {
+		spec.Body = nil
+	}
+	return &spec, nil
+}
+
+// specFromData reads a request from task fields. A string body is sent as
+// is; any other body is encoded as JSON.
+func specFromData(data map[string]interface{}) (*HTTPRequestSpec, error) {
+	spec := &HTTPRequestSpec{Method: stringField(data, "method"), URL: stringField(data, "url")}
+	if headers, ok := data["headers"].(map[string]interface{}); ok {
+		spec.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			spec.Headers[name] = fmt.Sprint(value)
+		}
+	}
+	switch body := data["body"].(type) {
+	case nil:
+	case string:
+		encoded, _ := json.Marshal(body)
+		spec.Body = encoded
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request body: %w", err)
+		}
+		spec.Body = encoded
+	}
+	return spec, nil
+}
+
+// bodyBytes returns the bytes sent for the spec's body and whether it is JSON
+func (s *HTTPRequestSpec) bodyBytes() ([]byte, bool) {
+	if len(s.Body) == 0 {
+		return nil, false
+	}
+	var text string
+	if err := json.Unmarshal(s.Body, &text); err == nil {
+		return []byte(text), false
+	}
+	return s.Body, true
+}
+
+// checkURL rejects URLs that aren't http(s) or whose host isn't allowlisted
+func (h *HTTPRequestAgentImpl) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if !hostMatches(u.Hostname(), h.allowedHosts) {
+		return fmt.Errorf("host %s is not in http_allowed_hosts", u.Hostname())
+	}
+	return nil
+}
+
+// send performs a request and captures the response. Redirects are followed
+// only to allowlisted hosts.
+func (h *HTTPRequestAgentImpl) send(ctx context.Context, spec *HTTPRequestSpec, timeout time.Duration) (*HTTPExchange, error) {
+	u, err := url.Parse(spec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if err := h.checkURL(u); err != nil {
+		return nil, err
+	}
+	if spec.Method == "" {
+		spec.Method = http.MethodGet
+	}
+	spec.Method = strings.ToUpper(spec.Method)
+
+	body, isJSON := spec.bodyBytes()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, spec.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	for name, value := range spec.Headers {
+		req.Header.Set(name, value)
+	}
+	if isJSON && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return h.checkURL(req.URL)
+		},
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBodyBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	exchange := &HTTPExchange{
+		Request:    *spec,
+		Status:     resp.StatusCode,
+		Headers:    make(map[string]string, len(resp.Header)),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if len(content) > maxHTTPBodyBytes {
+		content, exchange.Truncated = content[:maxHTTPBodyBytes], true
+	}
+	exchange.Body = string(content)
+	for name := range resp.Header {
+		exchange.Headers[name] = resp.Header.Get(name)
+	}
+	return exchange, nil
+}
+
+// httpTimeout returns the task's "timeout_seconds" or the default
+func httpTimeout(data map[string]interface{}) time.Duration {
+	if seconds, ok := data["timeout_seconds"].(float64); ok && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return defaultHTTPTimeout
+}
+
+func (h *HTTPRequestAgentImpl) handleGenerate(ctx context.Context, task *Task) (*TaskResult, error) {
+	kind := stringField(task.Data, "kind")
+	if kind == "" {
+		kind = "client"
+	}
+	if kind != "client" && kind != "test" {
+		return nil, fmt.Errorf("unknown generate kind: %s", kind)
+	}
+	language := stringField(task.Data, "language")
+	if language == "" {
+		language = "go"
+	}
+
+	var specs []*HTTPRequestSpec
+	if requests, ok := task.Data["requests"].([]interface{}); ok {
+		for _, r := range requests {
+			data, ok := r.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("requests must be objects")
+			}
+			spec, err := specFromData(data)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, spec)
+		}
+	} else {
+		spec, err := h.requestSpec(ctx, task.Data)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+
+	var exchanges []*HTTPExchange
+	for _, spec := range specs {
+		exchange, err := h.send(ctx, spec, httpTimeout(task.Data))
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error(), Data: Fields{"request": spec}}, nil
+		}
+		exchanges = append(exchanges, exchange)
+	}
+
+	instruction := "Write a small, idiomatic API client with one function per endpoint, typed request/response models inferred from the bodies, and error handling for non-2xx responses."
+	if kind == "test" {
+		instruction = "Write API tests that repeat these requests and assert on the status codes, important headers and the shape of the response bodies (not volatile values like IDs or timestamps)."
+	}
+	prompt := fmt.Sprintf(`%s
+Language: %s
+Read credentials from environment variables instead of hard-coding them.
+Respond with only the complete source file.
+
+Captured exchanges:
+%s`, instruction, language, describeExchanges(exchanges))
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert at writing API clients and integration tests from real HTTP traffic."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := h.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s: %w", kind, err)
+	}
+	content := stripCodeFence(response)
+
+	data := Fields{"kind": kind, "language": language, "exchanges": exchanges, "content": content}
+	if target := stringField(task.Data, "path"); target != "" {
+		if workspaceDir := stringField(task.Data, "workspace_dir"); workspaceDir != "" && !filepath.IsAbs(target) {
+			target = filepath.Join(workspaceDir, target)
+		}
+		if fileManagerFor(ctx, h.fileManager).FileExists(target) {
+			err = fileManagerFor(ctx, h.fileManager).UpdateFile(target, content)
+		} else {
+			err = fileManagerFor(ctx, h.fileManager).CreateFile(target, content)
+		}
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		data["path"] = target
+	}
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// describeExchanges renders exchanges for a prompt with credentials masked
+// and bodies shortened
+func describeExchanges(exchanges []*HTTPExchange) string {
+	var b strings.Builder
+	for i, exchange := range exchanges {
+		body, _ := exchange.Request.bodyBytes()
+		fmt.Fprintf(&b, "--- Exchange %d ---\n%s %s\n%s", i+1, exchange.Request.Method, exchange.Request.URL, formatHeaders(exchange.Request.Headers))
+		if len(body) > 0 {
+			fmt.Fprintf(&b, "\n%s\n", truncateString(string(body), 2000))
+		}
+		fmt.Fprintf(&b, "\nHTTP %d\n%s\n%s\n\n", exchange.Status, formatHeaders(exchange.Headers), truncateString(exchange.Body, 4000))
+	}
+	return b.String()
+}
+
+// formatHeaders renders headers one per line, sorted, with sensitive values masked
+func formatHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := headers[name]
+		if sensitiveHeaders[strings.ToLower(name)] {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", name, value)
+	}
+	return b.String()
+}
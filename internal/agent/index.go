@@ -0,0 +1,503 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// IndexConfig configures the codebase embeddings index
+type IndexConfig struct {
+	Enabled bool
+	// Store is sqlite, qdrant or pgvector
+	Store string
+	// DSN is the SQLite file or PostgreSQL connection string
+	DSN string
+	// URL and APIKey address a Qdrant server
+	URL    string
+	APIKey string
+	// Collection names the Qdrant collection or pgvector table
+	Collection      string
+	EmbeddingURL    string
+	EmbeddingAPIKey string
+	EmbeddingModel  string
+	// Watch re-indexes files as they change, compacting the store in the
+	// background as changes accumulate
+	Watch bool
+	// ChunkLines is the number of lines per chunk
+	ChunkLines int
+	// Chunks are embedded in requests of at most EmbeddingBatchSize chunks
+	// and EmbeddingBatchBytes of text, EmbeddingConcurrency at once and
+	// EmbeddingRateLimit a minute; zero takes the defaults, and no rate
+	// limit
+	EmbeddingBatchSize   int
+	EmbeddingBatchBytes  int
+	EmbeddingConcurrency int
+	EmbeddingRateLimit   int
+}
+
+const (
+	defaultChunkLines = 60
+	chunkOverlap      = 10
+	// maxIndexedFileBytes skips generated and vendored blobs
+	maxIndexedFileBytes = 512 << 10
+	// indexDebounce batches bursts of file events, e.g. from a checkout
+	indexDebounce = 2 * time.Second
+)
+
+// IndexStatus reports the state of the codebase index
+type IndexStatus struct {
+	Root        string    `json:"root"`
+	Store       string    `json:"store"`
+	Files       int       `json:"files"`
+	Indexing    bool      `json:"indexing"`
+	Watching    bool      `json:"watching"`
+	LastIndexed time.Time `json:"last_indexed,omitempty"`
+	// LastCompacted is when the store last reclaimed the space of replaced
+	// and removed chunks
+	LastCompacted time.Time `json:"last_compacted,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// IndexStats summarizes one indexing pass
+type IndexStats struct {
+	Indexed   int `json:"indexed"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+	// Chunks were written for the indexed files, of which Embedded were
+	// embedded; the others were unchanged and kept their vectors
+	Chunks   int `json:"chunks"`
+	Embedded int `json:"embedded"`
+}
+
+// CodeIndex chunks workspace files, embeds the chunks and keeps them in a
+// vector store so agents can retrieve code relevant to a request. Files are
+// read again only when their size or modification time changes, and
+// re-indexed only when their content hash changes; then only the chunks
+// whose text changed are embedded again.
+type CodeIndex struct {
+	root       string
+	storeName  string
+	store      VectorStore
+	embedder   Embedder
+	chunkLines int
+	manifest   *indexManifest
+	// embedBatchSize, embedBatchBytes and embedConcurrency shape the
+	// embedding requests of an indexing pass, and embedLimit paces them
+	embedBatchSize   int
+	embedBatchBytes  int
+	embedConcurrency int
+	embedLimit       *embedLimiter
+	// indexMu serializes indexing passes and compaction
+	indexMu sync.Mutex
+	// churn counts the indexed files replaced or removed since the store
+	// was last compacted
+	churn int
+
+	statusMu sync.Mutex
+	status   IndexStatus
+	logger   *zap.Logger
+}
+
+// NewCodeIndex creates an index of the workspace at root, remembering the
+// files it indexed under dataDir; an empty dataDir remembers them in memory
+// only
+func NewCodeIndex(root string, store VectorStore, embedder Embedder, cfg IndexConfig, dataDir string, logger *zap.Logger) *CodeIndex {
+	chunkLines := cfg.ChunkLines
+	if chunkLines <= chunkOverlap {
+		chunkLines = defaultChunkLines
+	}
+	storeName := cfg.Store
+	if storeName == "" {
+		storeName = "sqlite"
+	}
+	ci := &CodeIndex{
+		root:             absPath(root),
+		storeName:        storeName,
+		store:            store,
+		embedder:         embedder,
+		chunkLines:       chunkLines,
+		manifest:         loadIndexManifest(dataDir, logger),
+		embedBatchSize:   cfg.EmbeddingBatchSize,
+		embedBatchBytes:  cfg.EmbeddingBatchBytes,
+		embedConcurrency: cfg.EmbeddingConcurrency,
+		embedLimit:       newEmbedLimiter(cfg.EmbeddingRateLimit),
+		logger:           logger,
+	}
+	if ci.embedBatchSize <= 0 {
+		ci.embedBatchSize = defaultEmbeddingBatchSize
+	}
+	if ci.embedBatchBytes <= 0 {
+		ci.embedBatchBytes = defaultEmbeddingBatchBytes
+	}
+	if ci.embedConcurrency <= 0 {
+		ci.embedConcurrency = defaultEmbeddingConcurrency
+	}
+	return ci
+}
+
+// Root returns the indexed workspace directory
+func (ci *CodeIndex) Root() string {
+	return ci.root
+}
+
+// Status returns the current index status
+func (ci *CodeIndex) Status() IndexStatus {
+	ci.statusMu.Lock()
+	defer ci.statusMu.Unlock()
+	status := ci.status
+	status.Root = ci.root
+	status.Store = ci.storeName
+	return status
+}
+
+func (ci *CodeIndex) updateStatus(update func(*IndexStatus)) {
+	ci.statusMu.Lock()
+	update(&ci.status)
+	ci.statusMu.Unlock()
+}
+
+// Index brings the whole workspace up to date, embedding new and changed
+// files and dropping deleted ones
+func (ci *CodeIndex) Index(ctx context.Context) (*IndexStats, error) {
+	ci.indexMu.Lock()
+	defer ci.indexMu.Unlock()
+	ci.updateStatus(func(s *IndexStatus) { s.Indexing = true })
+
+	stats, err := ci.index(ctx)
+	ci.saveManifest()
+
+	ci.updateStatus(func(s *IndexStatus) {
+		s.Indexing = false
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+			return
+		}
+		s.LastIndexed = time.Now()
+	})
+	return stats, err
+}
+
+func (ci *CodeIndex) index(ctx context.Context) (*IndexStats, error) {
+	indexed, err := ci.store.FileHashes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	stats := &IndexStats{}
+	seen := make(map[string]bool)
+	batcher := ci.newEmbedBatcher(ctx, stats)
+	err = filepath.WalkDir(ci.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if path != ci.root && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, info, ok := ci.indexable(path, d)
+		if !ok {
+			return nil
+		}
+		seen[rel] = true
+		return batcher.add(ci.prepareFile(ctx, path, rel, info, indexed[rel], stats))
+	})
+	if embedErr := batcher.wait(); err == nil {
+		err = embedErr
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	for rel := range indexed {
+		if seen[rel] {
+			continue
+		}
+		if err := ci.store.DeleteFile(ctx, rel); err != nil {
+			return stats, fmt.Errorf("failed to remove %s from index: %w", rel, err)
+		}
+		stats.Removed++
+		ci.churn++
+	}
+	ci.manifest.retain(seen)
+	ci.updateStatus(func(s *IndexStatus) { s.Files = len(seen) })
+	return stats, nil
+}
+
+// IndexFiles re-indexes the given paths, which may be files or directories,
+// removing ones that no longer exist
+func (ci *CodeIndex) IndexFiles(ctx context.Context, paths []string) (*IndexStats, error) {
+	ci.indexMu.Lock()
+	defer ci.indexMu.Unlock()
+
+	indexed, err := ci.store.FileHashes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	stats := &IndexStats{}
+	defer ci.saveManifest()
+	batcher := ci.newEmbedBatcher(ctx, stats)
+	defer batcher.wait()
+	refresh := func(path string, d fs.DirEntry) error {
+		rel, info, ok := ci.indexable(path, d)
+		if !ok {
+			return nil
+		}
+		return batcher.add(ci.prepareFile(ctx, path, rel, info, indexed[rel], stats))
+	}
+
+	for _, path := range paths {
+		path = absPath(path)
+		rel, err := filepath.Rel(ci.root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// Gone: drop the file, or every file under the directory
+			for indexedPath := range indexed {
+				if indexedPath == rel || strings.HasPrefix(indexedPath, rel+"/") {
+					if err := ci.store.DeleteFile(ctx, indexedPath); err != nil {
+						return stats, err
+					}
+					delete(indexed, indexedPath)
+					ci.manifest.remove(indexedPath)
+					stats.Removed++
+					ci.churn++
+				}
+			}
+			continue
+		}
+		if !info.IsDir() {
+			if err := refresh(path, fs.FileInfoToDirEntry(info)); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if p != path && skipDir(d.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return refresh(p, d)
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	if err := batcher.wait(); err != nil {
+		return stats, err
+	}
+	if stats.Indexed > 0 || stats.Removed > 0 {
+		ci.updateStatus(func(s *IndexStatus) {
+			s.Files -= stats.Removed
+			s.LastIndexed = time.Now()
+		})
+	}
+	return stats, nil
+}
+
+// indexable reports whether a file should be indexed, with its path
+// relative to the root and its info
+func (ci *CodeIndex) indexable(path string, d fs.DirEntry) (string, fs.FileInfo, bool) {
+	if !d.Type().IsRegular() || !searchableExtensions[strings.ToLower(filepath.Ext(path))] {
+		return "", nil, false
+	}
+	info, err := d.Info()
+	if err != nil || info.Size() > maxIndexedFileBytes {
+		return "", nil, false
+	}
+	rel, err := filepath.Rel(ci.root, path)
+	if err != nil {
+		return "", nil, false
+	}
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if skipDir(part) {
+			return "", nil, false
+		}
+	}
+	return filepath.ToSlash(rel), info, true
+}
+
+// prepareFile returns a file to index, with the chunks it is split into
+// and those of them to embed, unless its size and modification time, or
+// else its hash, show it unchanged since it was indexed at indexedHash,
+// which it then counts in stats. Chunks whose text did not change keep
+// their vectors.
+func (ci *CodeIndex) prepareFile(ctx context.Context, path, rel string, info fs.FileInfo, indexedHash string, stats *IndexStats) *pendingFile {
+	if ci.manifest.unchanged(rel, info, indexedHash) {
+		stats.Unchanged++
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		stats.Unchanged++
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if hash == indexedHash {
+		ci.manifest.set(rel, info, hash)
+		stats.Unchanged++
+		return nil
+	}
+
+	file := &pendingFile{rel: rel, info: info, hash: hash, indexedHash: indexedHash}
+	file.chunks = chunkFile(rel, string(content), ci.chunkLines, chunkOverlap)
+	var reuse map[string][]float32
+	if indexedHash != "" {
+		reuse = ci.reuseVectors(ctx, rel)
+	}
+	for i := range file.chunks {
+		file.chunks[i].FileHash = hash
+		if vector, ok := reuse[file.chunks[i].embeddingText()]; ok {
+			file.chunks[i].Vector = vector
+			continue
+		}
+		file.missing = append(file.missing, i)
+	}
+	return file
+}
+
+// chunkFile splits content into overlapping line windows, skipping blank ones
+func chunkFile(path, content string, size, overlap int) []CodeChunk {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	var chunks []CodeChunk
+	for start := 0; start < len(lines); start += size - overlap {
+		end := start + size
+		if end > len(lines) {
+			end = len(lines)
+		}
+		text := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(text) != "" {
+			chunks = append(chunks, CodeChunk{Path: path, StartLine: start + 1, EndLine: end, Content: text})
+		}
+		if end == len(lines) {
+			break
+		}
+	}
+	return chunks
+}
+
+// Search returns the k chunks most relevant to the query
+func (ci *CodeIndex) Search(ctx context.Context, query string, k int) ([]ScoredChunk, error) {
+	if k <= 0 {
+		k = 10
+	}
+	vectors, err := ci.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return ci.store.Search(ctx, vectors[0], k)
+}
+
+// Watch re-indexes files as they change until ctx is done
+func (ci *CodeIndex) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch workspace: %w", err)
+	}
+	defer watcher.Close()
+	if err := ci.watchTree(watcher, ci.root); err != nil {
+		return err
+	}
+	ci.updateStatus(func(s *IndexStatus) { s.Watching = true })
+	defer ci.updateStatus(func(s *IndexStatus) { s.Watching = false })
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(indexDebounce)
+	timer.Stop()
+	compaction := time.NewTicker(indexCompactInterval)
+	defer compaction.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-compaction.C:
+			if err := ci.compact(ctx); err != nil {
+				ci.logger.Warn("Failed to compact index", zap.Error(err))
+			}
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !skipDir(info.Name()) {
+					ci.watchTree(watcher, event.Name)
+				}
+			}
+			pending[event.Name] = true
+			timer.Reset(indexDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			ci.logger.Warn("Workspace watcher error", zap.Error(err))
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			pending = make(map[string]bool)
+			stats, err := ci.IndexFiles(ctx, paths)
+			if err != nil {
+				ci.logger.Warn("Failed to re-index changed files", zap.Error(err))
+				ci.updateStatus(func(s *IndexStatus) { s.LastError = err.Error() })
+				continue
+			}
+			if stats.Indexed > 0 || stats.Removed > 0 {
+				ci.logger.Debug("Re-indexed changed files", zap.Int("indexed", stats.Indexed), zap.Int("removed", stats.Removed))
+			}
+		}
+	}
+}
+
+// watchTree adds dir and its subdirectories to the watcher
+func (ci *CodeIndex) watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && skipDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// Ping checks that the vector store can be reached
+func (ci *CodeIndex) Ping(ctx context.Context) error {
+	return ci.store.Ping(ctx)
+}
+
+// Close releases the vector store
+func (ci *CodeIndex) Close() error {
+	return ci.store.Close()
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// indexRacyWindow is how recently a file may have been modified for its
+	// modification time not to be trusted: a write within the same clock
+	// tick could leave it unchanged
+	indexRacyWindow = 2 * time.Second
+	// indexCompactInterval is how often the watcher checks whether the
+	// store needs compacting
+	indexCompactInterval = 30 * time.Minute
+	// indexCompactChurn is how many indexed files must have been replaced
+	// or removed since the last compaction for another to be worthwhile
+	indexCompactChurn = 1000
+)
+
+// compactor is implemented by vector stores that keep the space of
+// removed chunks until they are compacted. Qdrant and PostgreSQL reclaim
+// it themselves.
+type compactor interface {
+	Compact(ctx context.Context) error
+}
+
+// fileStamp is the size and modification time a file was indexed at, and
+// the hash of its content then
+type fileStamp struct {
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+// indexManifest remembers the stamps of indexed files, in
+// DataDir/index/files.json, so files whose size and modification time are
+// unchanged need not be read and hashed again
+type indexManifest struct {
+	mu    sync.Mutex
+	path  string
+	files map[string]fileStamp
+	dirty bool
+}
+
+// loadIndexManifest loads the manifest kept under dataDir; an empty
+// dataDir keeps it in memory only. A manifest that cannot be read is
+// started afresh, which only costs hashing every file once.
+func loadIndexManifest(dataDir string, logger *zap.Logger) *indexManifest {
+	m := &indexManifest{files: make(map[string]fileStamp)}
+	if dataDir == "" {
+		return m
+	}
+	m.path = filepath.Join(dataDir, "index", "files.json")
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read index manifest", zap.Error(err))
+		}
+		return m
+	}
+	if err := json.Unmarshal(data, &m.files); err != nil {
+		logger.Warn("Failed to read index manifest", zap.Error(err))
+		m.files = make(map[string]fileStamp)
+	}
+	return m
+}
+
+// unchanged reports whether the file at rel still has the size and
+// modification time it was indexed at, as the content with indexedHash
+func (m *indexManifest) unchanged(rel string, info fs.FileInfo, indexedHash string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stamp, ok := m.files[rel]
+	return ok && indexedHash != "" && stamp.Hash == indexedHash && stamp.ModTime != 0 &&
+		stamp.Size == info.Size() && stamp.ModTime == info.ModTime().UnixNano()
+}
+
+// set records that the file at rel was indexed with hash
+func (m *indexManifest) set(rel string, info fs.FileInfo, hash string) {
+	stamp := fileStamp{Hash: hash, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if time.Since(info.ModTime()) < indexRacyWindow {
+		// Hash it again next time rather than risk missing a change
+		stamp.ModTime = 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[rel] != stamp {
+		m.files[rel] = stamp
+		m.dirty = true
+	}
+}
+
+// remove forgets the file at rel
+func (m *indexManifest) remove(rel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[rel]; ok {
+		delete(m.files, rel)
+		m.dirty = true
+	}
+}
+
+// retain forgets every file not in keep
+func (m *indexManifest) retain(keep map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for rel := range m.files {
+		if !keep[rel] {
+			delete(m.files, rel)
+			m.dirty = true
+		}
+	}
+}
+
+// save writes the manifest if it changed
+func (m *indexManifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" || !m.dirty {
+		return nil
+	}
+	data, err := json.Marshal(m.files)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// saveManifest persists the manifest, logging rather than failing: a
+// stale manifest only costs rehashing
+func (ci *CodeIndex) saveManifest() {
+	if err := ci.manifest.save(); err != nil {
+		ci.logger.Warn("Failed to save index manifest", zap.Error(err))
+	}
+}
+
+// compact reclaims the space of replaced and removed chunks once enough
+// have accumulated, if the store needs compacting
+func (ci *CodeIndex) compact(ctx context.Context) error {
+	c, ok := ci.store.(compactor)
+	if !ok {
+		return nil
+	}
+	ci.indexMu.Lock()
+	defer ci.indexMu.Unlock()
+	if ci.churn < indexCompactChurn {
+		return nil
+	}
+	start := time.Now()
+	if err := c.Compact(ctx); err != nil {
+		return fmt.Errorf("failed to compact index: %w", err)
+	}
+	ci.logger.Debug("Compacted index", zap.Int("files_changed", ci.churn), zap.Duration("duration", time.Since(start)))
+	ci.churn = 0
+	ci.updateStatus(func(s *IndexStatus) { s.LastCompacted = time.Now() })
+	return nil
+}
+
+// reuseVectors returns the vectors of the chunks of the file at rel as
+// indexed, by the text they were embedded from, so unchanged chunks of a
+// changed file need not be embedded again
+func (ci *CodeIndex) reuseVectors(ctx context.Context, rel string) map[string][]float32 {
+	old, err := ci.store.FileChunks(ctx, rel)
+	if err != nil {
+		ci.logger.Debug("Failed to read indexed chunks; embedding the whole file", zap.String("path", rel), zap.Error(err))
+		return nil
+	}
+	vectors := make(map[string][]float32, len(old))
+	for _, c := range old {
+		if len(c.Vector) > 0 {
+			vectors[c.embeddingText()] = c.Vector
+		}
+	}
+	return vectors
+}
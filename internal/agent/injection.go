@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxInjectionExcerpt caps the text quoted in an injection warning
+const maxInjectionExcerpt = 160
+
+// InjectionWarning flags file or command content that appears to address
+// the model, such as a comment telling it to ignore its instructions
+type InjectionWarning struct {
+	Source  string `json:"source"`
+	Excerpt string `json:"excerpt"`
+}
+
+// injectionPatterns match text written to steer a model rather than to be
+// read as code, output or documentation
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+)?(previous|prior|above|earlier|system|your)\s+(instructions|prompts?|rules|directions)`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(a|an|in)\b`),
+	regexp.MustCompile(`(?i)\b(new|updated|real)\s+(system\s+)?instructions\s*:`),
+	regexp.MustCompile(`(?i)\b(AI|LLM|language\s+model|assistant|agent)s?\s*[,:]?\s*(must|should|please)\s+(run|execute|call|delete|send|upload|curl|ignore)\b`),
+	regexp.MustCompile(`(?i)\bdo\s+not\s+(tell|inform|mention\s+(this\s+)?to)\s+the\s+user\b`),
+	regexp.MustCompile(`(?i)<\|?(im_start|im_end|system|endoftext)\|?>|\[/?INST\]|<</?SYS>>`),
+	regexp.MustCompile(`(?im)^\s*(#+\s*)?(system|assistant)\s*:\s*(you|ignore|from\s+now)\b`),
+}
+
+// untrustedTag delimits file and command content in prompts. The model is
+// told never to follow instructions found inside it (see llm.UntrustedContentRule).
+const untrustedTag = "untrusted"
+
+// fenceUntrusted wraps content read from files or produced by commands in
+// delimiters marking it as data. Closing tags inside content are broken up
+// so it cannot end the block early.
+func fenceUntrusted(source, content string) string {
+	if strings.TrimSpace(content) == "" {
+		return content
+	}
+	content = strings.ReplaceAll(content, "</"+untrustedTag, "<\\/"+untrustedTag)
+	source = strings.NewReplacer(`"`, "'", "\n", " ").Replace(source)
+	return fmt.Sprintf("<%s source=%q>\n%s\n</%s>", untrustedTag, source, strings.TrimRight(content, "\n"), untrustedTag)
+}
+
+// detectInjection returns a warning for each line of content that looks
+// like an instruction to the model
+func detectInjection(source, content string) []InjectionWarning {
+	var warnings []InjectionWarning
+	for _, pattern := range injectionPatterns {
+		for _, loc := range pattern.FindAllStringIndex(content, -1) {
+			warnings = append(warnings, InjectionWarning{Source: source, Excerpt: excerptAround(content, loc[0], loc[1])})
+		}
+	}
+	return warnings
+}
+
+// excerptAround returns the line holding content[start:end], shortened to
+// maxInjectionExcerpt
+func excerptAround(content string, start, end int) string {
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	lineEnd := len(content)
+	if i := strings.IndexByte(content[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+	return truncateString(strings.TrimSpace(content[lineStart:lineEnd]), maxInjectionExcerpt)
+}
@@ -0,0 +1,489 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxDiagnosedPods bounds the failing pods sent to the DebugAgent
+	maxDiagnosedPods = 5
+	// podLogLines is the number of log lines gathered per failing pod
+	podLogLines = 200
+	// maxWorkspaceListing bounds the file listing shown when generating manifests
+	maxWorkspaceListing = 200
+)
+
+var plainShellArg = regexp.MustCompile(`^[A-Za-z0-9_./=:,@-]+$`)
+
+// manifestHints are the files that tell the LLM how a workspace is built and run
+var manifestHints = []string{"Dockerfile", "docker-compose.yml", "compose.yaml", "go.mod", "package.json", "requirements.txt", "pyproject.toml", "Procfile"}
+
+// KubernetesConfig selects the cluster the KubernetesAgent works against
+type KubernetesConfig struct {
+	Context   string
+	Namespace string
+}
+
+// PodDiagnosis is a failing pod with its events, logs and the DebugAgent's
+// analysis of them
+type PodDiagnosis struct {
+	Pod      string   `json:"pod"`
+	Phase    string   `json:"phase"`
+	Reasons  []string `json:"reasons"`
+	Restarts int      `json:"restarts"`
+	Events   string   `json:"events,omitempty"`
+	Logs     string   `json:"logs,omitempty"`
+	Analysis string   `json:"analysis,omitempty"`
+	Fix      string   `json:"fix,omitempty"`
+	File     string   `json:"file,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// KubernetesAgent generates deployment manifests and Helm charts, applies
+// them to the configured cluster context and diagnoses failing pods
+type KubernetesAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	approvals   *ApprovalStore
+	events      *EventBus
+	// debugAgent analyzes the logs of failing pods
+	debugAgent Agent
+	config     KubernetesConfig
+	logger     *zap.Logger
+}
+
+// NewKubernetesAgent creates a new Kubernetes agent
+func NewKubernetesAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, approvals *ApprovalStore, events *EventBus, debugAgent Agent, cfg KubernetesConfig, logger *zap.Logger) *KubernetesAgentImpl {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "default"
+	}
+	return &KubernetesAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		approvals:   approvals,
+		events:      events,
+		debugAgent:  debugAgent,
+		config:      cfg,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (k *KubernetesAgentImpl) Type() AgentType {
+	return KubernetesAgent
+}
+
+// Execute executes a Kubernetes task. Operations:
+//   - generate: write manifests ("kind": manifests) or a Helm chart
+//     ("kind": helm) for the workspace to "path"
+//   - apply: apply "path" to the cluster after approval; "dry_run" only
+//     validates it against the cluster
+//   - diagnose: analyze failing pods ("pod" or "selector" narrows them)
+func (k *KubernetesAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	k.logger.Info("Kubernetes agent executing task", zap.String("task_id", task.ID))
+
+	// A previously approved deployment runs exactly as it was approved
+	if approvalID, ok := task.Data["approval_id"].(string); ok && approvalID != "" {
+		approval, err := k.approvals.Consume(approvalID, "kubernetes")
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		return k.runApply(ctx, approval.Subject, approval.WorkingDir)
+	}
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	operation, ok := task.Data["operation"].(string)
+	if !ok {
+		return nil, fmt.Errorf("operation data not found in task")
+	}
+
+	switch operation {
+	case "generate":
+		return k.generate(ctx, task, workspaceDir)
+	case "apply":
+		return k.apply(ctx, task, workspaceDir)
+	case "diagnose":
+		return k.diagnose(ctx, task, workspaceDir)
+	default:
+		return nil, fmt.Errorf("unsupported kubernetes operation: %s", operation)
+	}
+}
+
+// generate asks the LLM for manifests or a chart based on how the
+// workspace is built, and writes them below the output directory
+func (k *KubernetesAgentImpl) generate(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	kind := stringField(task.Data, "kind")
+	if kind == "" {
+		kind = "manifests"
+	}
+	outDir := stringField(task.Data, "path")
+	var format string
+	switch kind {
+	case "manifests":
+		if outDir == "" {
+			outDir = "k8s"
+		}
+		format = "Kubernetes YAML manifests (Deployment, Service and, if the app needs configuration, a ConfigMap), one resource kind per file"
+	case "helm":
+		if outDir == "" {
+			outDir = "chart"
+		}
+		format = "a Helm chart: Chart.yaml, values.yaml and templates/ using values for the image, replicas, ports and resources"
+	default:
+		return nil, fmt.Errorf("unsupported kind: %s (use manifests or helm)", kind)
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Write %s to deploy this project.\n", format)
+	if request := stringField(task.Data, "request"); request != "" {
+		fmt.Fprintf(&prompt, "Requirements: %s\n", request)
+	}
+	if image := stringField(task.Data, "image"); image != "" {
+		fmt.Fprintf(&prompt, "Container image: %s\n", image)
+	}
+	fmt.Fprintf(&prompt, "\nProject files:\n%s\n", strings.Join(workspaceListing(workspaceDir), "\n"))
+	for _, name := range manifestHints {
+		content, err := fileManagerFor(ctx, k.fileManager).ReadHead(filepath.Join(workspaceDir, name), 60)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&prompt, "\n%s:\n%s\n", name, content)
+	}
+	prompt.WriteString(`
+Set resource requests and limits and liveness/readiness probes where the app exposes a port.
+Respond with only a JSON array: [{"path": "<path relative to the output directory>", "content": "<file content>"}]`)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a Kubernetes expert who writes production-ready, minimal deployment configuration."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt.String()},
+	}
+	response, err := k.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s: %w", kind, err)
+	}
+	var files []ProjectFile
+	if err := json.Unmarshal([]byte(extractJSON(response)), &files); err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("failed to parse %s from LLM response: %v", kind, err)
+	}
+
+	written := make([]string, 0, len(files))
+	for _, file := range files {
+		rel := filepath.Clean(filepath.FromSlash(file.Path))
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return &TaskResult{Success: false, Error: fmt.Sprintf("refusing to write outside %s: %s", outDir, file.Path)}, nil
+		}
+		path := filepath.Join(outDir, rel)
+		if err := fileManagerFor(ctx, k.fileManager).CreateFile(filepath.Join(workspaceDir, path), file.Content); err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		written = append(written, filepath.ToSlash(path))
+	}
+
+	data := Fields{"kind": kind, "path": outDir, "files": written}
+	// Charts can be checked without a cluster
+	if kind == "helm" && onPath("helm") {
+		lint, err := k.commandExec.ExecuteCommand(ctx, "helm lint "+k.quote(outDir), workspaceDir, CommandOptions{})
+		if err == nil {
+			data["lint"] = strings.TrimSpace(lint.Output + lint.Error)
+			data["lint_passed"] = lint.Status == "completed"
+		}
+	}
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// apply validates manifests or a chart with a server-side dry run and
+// requests approval to deploy them
+func (k *KubernetesAgentImpl) apply(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	if k.config.Context == "" {
+		return &TaskResult{Success: false, Error: "no cluster context configured (kubernetes.context)"}, nil
+	}
+	path := stringField(task.Data, "path")
+	if path == "" {
+		path = "k8s"
+		if fileExists(workspaceDir, filepath.Join("chart", "Chart.yaml")) {
+			path = "chart"
+		}
+	}
+	if !fileManagerFor(ctx, k.fileManager).FileExists(filepath.Join(workspaceDir, path)) {
+		return &TaskResult{Success: false, Error: fmt.Sprintf("%s not found; generate manifests first", path)}, nil
+	}
+
+	var command, dryRun string
+	if fileExists(workspaceDir, filepath.Join(path, "Chart.yaml")) {
+		release := stringField(task.Data, "release")
+		if release == "" {
+			release = migrationSlug(filepath.Base(absPath(workspaceDir)))
+		}
+		command = fmt.Sprintf("helm upgrade --install %s %s --kube-context %s --namespace %s",
+			k.quote(release), k.quote(path), k.quote(k.config.Context), k.quote(k.config.Namespace))
+		dryRun = command + " --dry-run"
+	} else {
+		command = k.kubectl("apply", "--recursive", "-f", path)
+		dryRun = command + " --dry-run=server"
+	}
+
+	validation, err := k.commandExec.ExecuteCommand(ctx, dryRun, workspaceDir, CommandOptions{})
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	data := Fields{
+		"command": command,
+		"context": k.config.Context,
+		"dry_run": truncateString(strings.TrimSpace(validation.Output), 10000),
+	}
+	if validation.Status != "completed" {
+		return &TaskResult{Success: false, Error: "dry run failed: " + strings.TrimSpace(validation.Error), Data: data}, nil
+	}
+	if dry, _ := task.Data["dry_run"].(bool); dry {
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+
+	risk := &RiskAssessment{Level: RiskHigh, Reasons: []string{"changes resources in cluster context " + k.config.Context}}
+	approval := k.approvals.Request(&Approval{
+		TaskID:     task.ID,
+		Kind:       "kubernetes",
+		Subject:    command,
+		Risk:       risk,
+		Data:       map[string]interface{}{"context": k.config.Context, "namespace": k.config.Namespace},
+		WorkingDir: workspaceDir,
+	})
+	k.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "command": command, "risk": risk},
+	})
+	data["requires_approval"] = true
+	data["approval_id"] = approval.ID
+	return &TaskResult{
+		Success: false,
+		Error:   "deployment changes the cluster and requires approval",
+		Data:    data,
+	}, nil
+}
+
+// runApply runs an approved kubectl or helm command
+func (k *KubernetesAgentImpl) runApply(ctx context.Context, command, workspaceDir string) (*TaskResult, error) {
+	result, err := k.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	return &TaskResult{
+		Success: result.Status == "completed",
+		Error:   strings.TrimSpace(result.Error),
+		Data:    Fields{"command": command, "output": result.Output, "context": k.config.Context},
+	}, nil
+}
+
+// diagnose finds failing pods and hands their events and logs to the
+// DebugAgent, which maps stack traces back to workspace files
+func (k *KubernetesAgentImpl) diagnose(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	if k.config.Context == "" {
+		return &TaskResult{Success: false, Error: "no cluster context configured (kubernetes.context)"}, nil
+	}
+
+	args := []string{"get", "pods", "-o", "json"}
+	if selector := stringField(task.Data, "selector"); selector != "" {
+		args = append(args, "-l", selector)
+	}
+	listing, err := k.commandExec.ExecuteCommand(ctx, k.kubectl(args...), workspaceDir, CommandOptions{})
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	if listing.Status != "completed" {
+		return &TaskResult{Success: false, Error: "failed to list pods: " + strings.TrimSpace(listing.Error)}, nil
+	}
+	failing, err := failingPods(listing.Output, stringField(task.Data, "pod"))
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	if len(failing) > maxDiagnosedPods {
+		failing = failing[:maxDiagnosedPods]
+	}
+
+	for i := range failing {
+		k.diagnosePod(ctx, task, &failing[i], workspaceDir)
+	}
+	return &TaskResult{
+		Success: true,
+		Data: Fields{
+			"context":   k.config.Context,
+			"namespace": k.config.Namespace,
+			"pods":      failing,
+		},
+	}, nil
+}
+
+// diagnosePod gathers a pod's events and logs and runs the DebugAgent on them
+func (k *KubernetesAgentImpl) diagnosePod(ctx context.Context, task *Task, pod *PodDiagnosis, workspaceDir string) {
+	if events, err := k.commandExec.ExecuteCommand(ctx, k.kubectl("get", "events", "--field-selector", "involvedObject.name="+pod.Pod, "--sort-by=.lastTimestamp"), workspaceDir, CommandOptions{}); err == nil {
+		pod.Events = strings.TrimSpace(events.Output)
+	}
+	logArgs := []string{"logs", pod.Pod, "--all-containers", fmt.Sprintf("--tail=%d", podLogLines)}
+	// A crash-looping container's useful output is in its previous run
+	if pod.Restarts > 0 {
+		logArgs = append(logArgs, "--previous")
+	}
+	if logs, err := k.commandExec.ExecuteCommand(ctx, k.kubectl(logArgs...), workspaceDir, CommandOptions{}); err == nil {
+		pod.Logs = strings.TrimSpace(logs.Output)
+	}
+
+	errorOutput := fmt.Sprintf("Kubernetes pod %s is failing (phase %s): %s\n\nEvents:\n%s\n\nLogs:\n%s",
+		pod.Pod, pod.Phase, strings.Join(pod.Reasons, "; "), pod.Events, pod.Logs)
+	result, err := k.debugAgent.Execute(ctx, &Task{
+		ID:          task.ID + "_" + pod.Pod,
+		Type:        DebugAgent,
+		Description: "Diagnose failing pod " + pod.Pod,
+		Data: map[string]interface{}{
+			"error_output":  errorOutput,
+			"workspace_dir": workspaceDir,
+			// Local checkers say nothing about a failure in the cluster
+			"diagnostics": false,
+		},
+	})
+	if err != nil {
+		pod.Error = err.Error()
+		return
+	}
+	if !result.Success {
+		pod.Error = result.Error
+		return
+	}
+	if debug := result.Debug(); debug != nil {
+		pod.Analysis, pod.Fix, pod.File = debug.Analysis, debug.Fix, debug.File
+	}
+}
+
+// kubectl builds a kubectl command against the configured context and namespace
+func (k *KubernetesAgentImpl) kubectl(args ...string) string {
+	parts := []string{"kubectl", "--context", k.quote(k.config.Context), "--namespace", k.quote(k.config.Namespace)}
+	for _, arg := range args {
+		parts = append(parts, k.quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quote quotes arg for the shell unless it is plainly safe, keeping the
+// commands shown for approval readable
+func (k *KubernetesAgentImpl) quote(arg string) string {
+	if arg != "" && plainShellArg.MatchString(arg) {
+		return arg
+	}
+	return k.commandExec.DefaultShell().Quote(arg)
+}
+
+// failingPods parses "kubectl get pods -o json" and returns the pods that
+// aren't healthy, optionally only the one named only
+func failingPods(output, only string) ([]PodDiagnosis, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase             string `json:"phase"`
+				Reason            string `json:"reason"`
+				Message           string `json:"message"`
+				ContainerStatuses []struct {
+					Name         string `json:"name"`
+					Ready        bool   `json:"ready"`
+					RestartCount int    `json:"restartCount"`
+					State        struct {
+						Waiting *struct {
+							Reason  string `json:"reason"`
+							Message string `json:"message"`
+						} `json:"waiting"`
+						Terminated *struct {
+							Reason   string `json:"reason"`
+							ExitCode int    `json:"exitCode"`
+						} `json:"terminated"`
+					} `json:"state"`
+					LastState struct {
+						Terminated *struct {
+							Reason   string `json:"reason"`
+							ExitCode int    `json:"exitCode"`
+						} `json:"terminated"`
+					} `json:"lastState"`
+				} `json:"containerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse pod list: %w", err)
+	}
+
+	var failing []PodDiagnosis
+	for _, item := range list.Items {
+		if only != "" && item.Metadata.Name != only {
+			continue
+		}
+		pod := PodDiagnosis{Pod: item.Metadata.Name, Phase: item.Status.Phase}
+		if item.Status.Phase == "Failed" || item.Status.Phase == "Unknown" {
+			pod.Reasons = append(pod.Reasons, strings.TrimSpace(item.Status.Reason+" "+item.Status.Message))
+		}
+		for _, container := range item.Status.ContainerStatuses {
+			pod.Restarts += container.RestartCount
+			if container.Ready || item.Status.Phase == "Succeeded" {
+				continue
+			}
+			switch {
+			case container.State.Waiting != nil && container.State.Waiting.Reason != "":
+				pod.Reasons = append(pod.Reasons, fmt.Sprintf("%s: %s %s", container.Name, container.State.Waiting.Reason, container.State.Waiting.Message))
+			case container.State.Terminated != nil:
+				pod.Reasons = append(pod.Reasons, fmt.Sprintf("%s: %s (exit code %d)", container.Name, container.State.Terminated.Reason, container.State.Terminated.ExitCode))
+			case container.LastState.Terminated != nil:
+				pod.Reasons = append(pod.Reasons, fmt.Sprintf("%s: not ready, last run %s (exit code %d)", container.Name, container.LastState.Terminated.Reason, container.LastState.Terminated.ExitCode))
+			case item.Status.Phase == "Running":
+				pod.Reasons = append(pod.Reasons, container.Name+": not ready")
+			}
+		}
+		if len(pod.Reasons) == 0 && item.Status.Phase == "Pending" {
+			pod.Reasons = []string{"Pending " + item.Status.Message}
+		}
+		if len(pod.Reasons) == 0 {
+			continue
+		}
+		for i, reason := range pod.Reasons {
+			pod.Reasons[i] = strings.TrimSpace(reason)
+		}
+		failing = append(failing, pod)
+	}
+	sort.SliceStable(failing, func(i, j int) bool { return failing[i].Restarts > failing[j].Restarts })
+	return failing, nil
+}
+
+// workspaceListing lists the workspace's files, skipping dependency and
+// build directories
+func workspaceListing(workspaceDir string) []string {
+	var files []string
+	filepath.WalkDir(workspaceDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || len(files) >= maxWorkspaceListing {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() {
+			if path != workspaceDir && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel, err := filepath.Rel(workspaceDir, path); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResourceLimits bounds the resources a single command may use. Zero
+// values disable the corresponding limit.
+type ResourceLimits struct {
+	CPUSeconds     int
+	MemoryMB       int
+	MaxProcesses   int
+	MaxOutputBytes int64
+}
+
+// shellPrefix returns ulimit statements enforcing the limits for POSIX
+// shells. Other shells have no equivalent and run unrestricted apart from
+// the output cap. Unsupported limits are ignored rather than failing the
+// command.
+func (l ResourceLimits) shellPrefix(shell Shell) string {
+	if shell != ShellSh && shell != ShellBash {
+		return ""
+	}
+
+	var parts []string
+	if l.CPUSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("ulimit -t %d 2>/dev/null", l.CPUSeconds))
+	}
+	if l.MemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("ulimit -v %d 2>/dev/null", l.MemoryMB*1024))
+	}
+	if l.MaxProcesses > 0 {
+		// bash spells the process limit -u, dash spells it -p
+		parts = append(parts, fmt.Sprintf("{ ulimit -u %d || ulimit -p %d; } 2>/dev/null", l.MaxProcesses, l.MaxProcesses))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "; ") + "; "
+}
+
+// containerArgs returns the equivalent container run flags
+func (l ResourceLimits) containerArgs() []string {
+	var args []string
+	if l.CPUSeconds > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("cpu=%d", l.CPUSeconds))
+	}
+	if l.MaxProcesses > 0 {
+		args = append(args, "--pids-limit", fmt.Sprint(l.MaxProcesses))
+	}
+	return args
+}
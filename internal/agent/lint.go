@@ -0,0 +1,436 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// maxLintFixFiles bounds the files sent to the LLM in one fix run
+const maxLintFixFiles = 10
+
+// LintViolation is one linter finding
+type LintViolation struct {
+	Linter   string `json:"linter"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+	// Fixable is set when the linter reported an automatic fix
+	Fixable bool `json:"fixable,omitempty"`
+}
+
+// key identifies a violation across runs; lines shift as code is fixed
+func (v LintViolation) key() string {
+	return v.Linter + "\x00" + v.Rule + "\x00" + v.File + "\x00" + v.Message
+}
+
+// LintGroup summarizes the violations of one rule
+type LintGroup struct {
+	Linter string   `json:"linter"`
+	Rule   string   `json:"rule"`
+	Count  int      `json:"count"`
+	Files  []string `json:"files"`
+}
+
+// linter is a project linter with its JSON report and fix commands
+type linter struct {
+	name       string
+	command    string
+	fixCommand string
+	// applies reports whether the linter is configured for the workspace
+	applies func(workspaceDir string) bool
+	parse   func(output, workspaceDir string) ([]LintViolation, error)
+}
+
+var linters = []linter{
+	{"golangci-lint", "golangci-lint run --output.json.path=stdout --show-stats=false ./...", "golangci-lint run --fix ./...",
+		func(dir string) bool { return fileExists(dir, "go.mod") && onPath("golangci-lint") }, parseGolangciLint},
+	{"eslint", "npx --no-install eslint -f json .", "npx --no-install eslint --fix .", eslintConfigured, parseESLint},
+	{"ruff", "ruff check --output-format json .", "ruff check --fix .",
+		func(dir string) bool {
+			return (hasFileWithExt(dir, ".py") || fileExists(dir, "pyproject.toml")) && onPath("ruff")
+		}, parseRuff},
+}
+
+// eslintConfigured reports whether the workspace has an ESLint config and binary
+func eslintConfigured(dir string) bool {
+	if !fileExists(dir, filepath.Join("node_modules", ".bin", "eslint")) {
+		return false
+	}
+	for _, name := range []string{"eslint.config.js", "eslint.config.mjs", "eslint.config.cjs", "eslint.config.ts", ".eslintrc", ".eslintrc.js", ".eslintrc.cjs", ".eslintrc.json", ".eslintrc.yml", ".eslintrc.yaml"} {
+		if fileExists(dir, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LintAgent runs project linters and fixes what they report
+type LintAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	logger      *zap.Logger
+}
+
+// NewLintAgent creates a new lint agent
+func NewLintAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, logger *zap.Logger) *LintAgentImpl {
+	return &LintAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (l *LintAgentImpl) Type() AgentType {
+	return LintAgent
+}
+
+// Execute lints the workspace ("linters" narrows the set). With "fix" the
+// violations are fixed according to "fix_mode": "native" runs the linters'
+// own --fix, "llm" asks the LLM for patches, and "auto" (default) does
+// both in that order. Every fix is verified by linting again; LLM patches
+// that make a file worse are rolled back.
+func (l *LintAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	l.logger.Info("Lint agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	var selected []linter
+	requested := stringList(task.Data, "linters")
+	for _, lt := range linters {
+		if len(requested) > 0 && !slices.Contains(requested, lt.name) {
+			continue
+		}
+		if lt.applies(workspaceDir) {
+			selected = append(selected, lt)
+		}
+	}
+	if len(selected) == 0 {
+		return &TaskResult{Success: false, Error: "no configured linters found in the workspace"}, nil
+	}
+
+	violations, lintErrors := l.lint(ctx, selected, workspaceDir)
+	data := Fields{
+		"linters": linterNames(selected),
+		"errors":  lintErrors,
+		"before":  len(violations),
+	}
+
+	if fix, _ := task.Data["fix"].(bool); fix && len(violations) > 0 {
+		mode := stringField(task.Data, "fix_mode")
+		if mode == "" {
+			mode = "auto"
+		}
+		if mode != "auto" && mode != "native" && mode != "llm" {
+			return nil, fmt.Errorf("unknown fix_mode: %s", mode)
+		}
+
+		if mode != "llm" {
+			for _, lt := range selected {
+				if _, err := l.commandExec.ExecuteCommand(ctx, lt.fixCommand, workspaceDir, CommandOptions{}); err != nil {
+					lintErrors[lt.name] = err.Error()
+				}
+			}
+			violations, lintErrors = l.lint(ctx, selected, workspaceDir)
+			data["after_native"] = len(violations)
+		}
+		if mode != "native" && len(violations) > 0 {
+			var applied []*AppliedPatchSet
+			violations, applied = l.llmFix(ctx, task, selected, violations, workspaceDir)
+			if len(applied) > 0 {
+				data["applied"] = applied
+			}
+		}
+		data["fixed"] = data["before"].(int) - len(violations)
+	}
+
+	data["violations"] = violations
+	data["groups"] = groupViolations(violations)
+	data["after"] = len(violations)
+	data["errors"] = lintErrors
+
+	result := &TaskResult{Success: len(violations) == 0, Data: data}
+	if !result.Success {
+		result.Error = fmt.Sprintf("%d lint violations remain", len(violations))
+	}
+	return result, nil
+}
+
+// lint runs linters and collects their violations. Linters exit non-zero
+// when they report anything, so only unparsable output counts as an error.
+func (l *LintAgentImpl) lint(ctx context.Context, selected []linter, workspaceDir string) ([]LintViolation, map[string]string) {
+	violations := []LintViolation{}
+	lintErrors := make(map[string]string)
+	for _, lt := range selected {
+		result, err := l.commandExec.ExecuteCommand(ctx, lt.command, workspaceDir, CommandOptions{})
+		if err != nil {
+			lintErrors[lt.name] = err.Error()
+			continue
+		}
+		found, err := lt.parse(result.Output, workspaceDir)
+		if err != nil {
+			lintErrors[lt.name] = fmt.Sprintf("%v: %s", err, truncateString(result.Error, 500))
+			continue
+		}
+		violations = append(violations, found...)
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].File != violations[j].File {
+			return violations[i].File < violations[j].File
+		}
+		return violations[i].Line < violations[j].Line
+	})
+	return violations, lintErrors
+}
+
+// llmFix asks the LLM to fix the violations of each file, lints again and
+// rolls back the files that didn't improve. It returns the remaining
+// violations and the kept patch sets.
+func (l *LintAgentImpl) llmFix(ctx context.Context, task *Task, selected []linter, violations []LintViolation, workspaceDir string) ([]LintViolation, []*AppliedPatchSet) {
+	byFile := make(map[string][]LintViolation)
+	var files []string
+	for _, v := range violations {
+		if _, ok := byFile[v.File]; !ok {
+			files = append(files, v.File)
+		}
+		byFile[v.File] = append(byFile[v.File], v)
+	}
+	if len(files) > maxLintFixFiles {
+		files = files[:maxLintFixFiles]
+	}
+
+	applied := make(map[string]*AppliedPatchSet)
+	for i, file := range files {
+		patches, err := l.generateLintPatches(ctx, file, byFile[file], workspaceDir)
+		if err != nil {
+			l.logger.Warn("Failed to generate lint fixes", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		// Keep each file's change separately revertible
+		backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID, fmt.Sprintf("lint-%d", i))
+		set, err := ApplyPatches(fileManagerFor(ctx, l.fileManager), workspaceDir, backupDir, patches)
+		if err != nil {
+			l.logger.Warn("Failed to apply lint fixes", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		applied[file] = set
+	}
+	if len(applied) == 0 {
+		return violations, nil
+	}
+
+	after, _ := l.lint(ctx, selected, workspaceDir)
+	before := make(map[string]bool, len(violations))
+	for _, v := range violations {
+		before[v.key()] = true
+	}
+	worse := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, v := range after {
+		counts[v.File]++
+		if !before[v.key()] {
+			worse[v.File] = true
+		}
+	}
+
+	var kept []*AppliedPatchSet
+	rolledBack := false
+	for file, set := range applied {
+		if worse[file] || counts[file] >= len(byFile[file]) {
+			if err := set.Rollback(); err != nil {
+				l.logger.Error("Failed to roll back lint fix", zap.String("file", file), zap.Error(err))
+			}
+			rolledBack = true
+			continue
+		}
+		kept = append(kept, set)
+	}
+	if rolledBack {
+		after, _ = l.lint(ctx, selected, workspaceDir)
+	}
+	return after, kept
+}
+
+// generateLintPatches asks the LLM for patches fixing a file's violations
+func (l *LintAgentImpl) generateLintPatches(ctx context.Context, file string, violations []LintViolation, workspaceDir string) ([]FilePatch, error) {
+	content, err := fileManagerFor(ctx, l.fileManager).ReadFile(filepath.Join(workspaceDir, file))
+	if err != nil {
+		return nil, err
+	}
+	var report strings.Builder
+	for _, v := range violations {
+		fmt.Fprintf(&report, "line %d: [%s %s] %s\n", v.Line, v.Linter, v.Rule, v.Message)
+	}
+
+	prompt := fmt.Sprintf(`Fix these lint violations in %s without changing behavior:
+%s
+File (numbered lines):
+%s
+
+Respond with only a JSON array of patches, each {"path": %q, "search": "<exact existing text>", "replace": "<new text>"}.
+Search text must match the file exactly, without line numbers. Don't silence rules with disable comments unless there is no real fix.`, file, report.String(), numberLines(content), file)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You fix lint violations with minimal, exact file patches as JSON."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := l.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate lint fixes: %w", err)
+	}
+	patches, err := parsePatches(response)
+	if err != nil {
+		return nil, err
+	}
+	// The LLM may only touch the file it was shown
+	for i := range patches {
+		patches[i].Path = file
+	}
+	return patches, nil
+}
+
+// groupViolations groups violations by rule, most frequent first
+func groupViolations(violations []LintViolation) []LintGroup {
+	index := make(map[string]int)
+	var groups []LintGroup
+	for _, v := range violations {
+		key := v.Linter + "\x00" + v.Rule
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, LintGroup{Linter: v.Linter, Rule: v.Rule})
+		}
+		groups[i].Count++
+		if n := len(groups[i].Files); n == 0 || groups[i].Files[n-1] != v.File {
+			groups[i].Files = append(groups[i].Files, v.File)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+	return groups
+}
+
+func linterNames(selected []linter) []string {
+	names := make([]string, len(selected))
+	for i, lt := range selected {
+		names[i] = lt.name
+	}
+	return names
+}
+
+func parseGolangciLint(output, workspaceDir string) ([]LintViolation, error) {
+	var report struct {
+		Issues []struct {
+			FromLinter  string `json:"FromLinter"`
+			Text        string `json:"Text"`
+			Severity    string `json:"Severity"`
+			Replacement *struct {
+				NewLines []string `json:"NewLines"`
+			} `json:"Replacement"`
+			Pos struct {
+				Filename string `json:"Filename"`
+				Line     int    `json:"Line"`
+				Column   int    `json:"Column"`
+			} `json:"Pos"`
+		} `json:"Issues"`
+	}
+	// golangci-lint appends a text summary after the JSON line
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	if err := json.Unmarshal([]byte(line), &report); err != nil {
+		return nil, fmt.Errorf("failed to parse golangci-lint output: %w", err)
+	}
+	violations := make([]LintViolation, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		violations = append(violations, LintViolation{
+			Linter:   "golangci-lint",
+			Rule:     issue.FromLinter,
+			Severity: issue.Severity,
+			File:     relativeTo(workspaceDir, issue.Pos.Filename),
+			Line:     issue.Pos.Line,
+			Column:   issue.Pos.Column,
+			Message:  issue.Text,
+			Fixable:  issue.Replacement != nil,
+		})
+	}
+	return violations, nil
+}
+
+func parseESLint(output, workspaceDir string) ([]LintViolation, error) {
+	var report []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   string          `json:"ruleId"`
+			Severity int             `json:"severity"`
+			Message  string          `json:"message"`
+			Line     int             `json:"line"`
+			Column   int             `json:"column"`
+			Fix      json.RawMessage `json:"fix"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &report); err != nil {
+		return nil, fmt.Errorf("failed to parse eslint output: %w", err)
+	}
+	var violations []LintViolation
+	for _, file := range report {
+		for _, m := range file.Messages {
+			severity := "warning"
+			if m.Severity == 2 {
+				severity = "error"
+			}
+			violations = append(violations, LintViolation{
+				Linter:   "eslint",
+				Rule:     m.RuleID,
+				Severity: severity,
+				File:     relativeTo(workspaceDir, file.FilePath),
+				Line:     m.Line,
+				Column:   m.Column,
+				Message:  m.Message,
+				Fixable:  len(m.Fix) > 0,
+			})
+		}
+	}
+	return violations, nil
+}
+
+func parseRuff(output, workspaceDir string) ([]LintViolation, error) {
+	var report []struct {
+		Code     string          `json:"code"`
+		Message  string          `json:"message"`
+		Filename string          `json:"filename"`
+		Fix      json.RawMessage `json:"fix"`
+		Location struct {
+			Row    int `json:"row"`
+			Column int `json:"column"`
+		} `json:"location"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &report); err != nil {
+		return nil, fmt.Errorf("failed to parse ruff output: %w", err)
+	}
+	violations := make([]LintViolation, 0, len(report))
+	for _, r := range report {
+		violations = append(violations, LintViolation{
+			Linter:  "ruff",
+			Rule:    r.Code,
+			File:    relativeTo(workspaceDir, r.Filename),
+			Line:    r.Location.Row,
+			Column:  r.Location.Column,
+			Message: r.Message,
+			Fixable: len(r.Fix) > 0 && string(r.Fix) != "null",
+		})
+	}
+	return violations, nil
+}
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultLogLines is how many trailing log lines are ingested
+	defaultLogLines = 2000
+	// maxLogClusters bounds how many distinct failures get a fix proposal
+	maxLogClusters = 5
+	// maxClusterExampleLines bounds the context lines kept after an error line
+	maxClusterExampleLines = 15
+)
+
+// ErrorCluster groups repeated occurrences of the same failure in a log
+type ErrorCluster struct {
+	Signature string          `json:"signature"`
+	Count     int             `json:"count"`
+	FirstLine int             `json:"first_line"`
+	Example   string          `json:"example"`
+	Locations []ErrorLocation `json:"locations,omitempty"`
+	Analysis  string          `json:"analysis,omitempty"`
+	Fix       string          `json:"fix,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	// Injections flag log lines or code that appear to instruct the model
+	Injections []InjectionWarning `json:"injections,omitempty"`
+}
+
+var (
+	logErrorLine = regexp.MustCompile(`(?i)\b(error|exception|panic|fatal|traceback|failed|unhandled)\b`)
+	logTimestamp = regexp.MustCompile(`^\S*\d{4}[-/]\d{2}[-/]\d{2}[T ]?[\d:.,]*(Z|[+-]\d{2}:?\d{2})?\]?\s*`)
+	logHex       = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	logUUID      = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	logQuoted    = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+	logNumber    = regexp.MustCompile(`\d+`)
+	// logContinuance matches stack frames and other lines that belong to
+	// the preceding error (indented lines, Go goroutine headers and frames)
+	logContinuance = regexp.MustCompile(`^(\s*$|\s+|at\s|File\s|Caused by|goroutine\s|\.\.\.|[\w./*()\[\]-]+\(.*\)$)`)
+)
+
+// errorSignature normalizes an error line so repeated occurrences that
+// differ only in timestamps, IDs, addresses or values cluster together
+func errorSignature(line string) string {
+	sig := logTimestamp.ReplaceAllString(strings.TrimSpace(line), "")
+	sig = logUUID.ReplaceAllString(sig, "<uuid>")
+	sig = logHex.ReplaceAllString(sig, "<hex>")
+	sig = logQuoted.ReplaceAllString(sig, "<str>")
+	sig = logNumber.ReplaceAllString(sig, "<n>")
+	return strings.ToLower(sig)
+}
+
+// ClusterLogErrors groups the error lines of a log by signature, most
+// frequent first. Each cluster keeps its first occurrence with the
+// stack/continuation lines that follow it.
+func ClusterLogErrors(lines []string) []*ErrorCluster {
+	clusters := make(map[string]*ErrorCluster)
+	for i := 0; i < len(lines); i++ {
+		if !logErrorLine.MatchString(lines[i]) {
+			continue
+		}
+		sig := errorSignature(lines[i])
+		if cluster, ok := clusters[sig]; ok {
+			cluster.Count++
+			continue
+		}
+
+		end := i + 1
+		for end < len(lines) && end-i <= maxClusterExampleLines && logContinuance.MatchString(lines[end]) {
+			end++
+		}
+		clusters[sig] = &ErrorCluster{
+			Signature: sig,
+			Count:     1,
+			FirstLine: i + 1,
+			Example:   strings.Join(lines[i:end], "\n"),
+		}
+	}
+
+	list := make([]*ErrorCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		list = append(list, cluster)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].FirstLine < list[j].FirstLine
+	})
+	return list
+}
+
+// analyzeLogs ingests a log file (task field "log_file") or a background
+// process's logs ("process_id"), clusters repeated errors, and proposes a
+// fix for each distinct failure
+func (d *DebugAgentImpl) analyzeLogs(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	lineCount := defaultLogLines
+	if n, ok := task.Data["lines"].(float64); ok && n > 0 {
+		lineCount = int(n)
+	}
+
+	var lines []string
+	var source string
+	if processID, ok := task.Data["process_id"].(string); ok && processID != "" {
+		logs, err := d.processes.Logs(processID, lineCount)
+		if err != nil {
+			return nil, err
+		}
+		lines, source = logs, "process "+processID
+	} else {
+		logFile, _ := task.Data["log_file"].(string)
+		if !filepath.IsAbs(logFile) {
+			logFile = filepath.Join(workspaceDir, logFile)
+		}
+		content, err := fileManagerFor(ctx, d.fileManager).ReadTail(logFile, lineCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log file: %w", err)
+		}
+		lines, source = strings.Split(content, "\n"), logFile
+	}
+
+	clusters := ClusterLogErrors(lines)
+	analyzed := clusters
+	if len(analyzed) > maxLogClusters {
+		analyzed = analyzed[:maxLogClusters]
+	}
+
+	for _, cluster := range analyzed {
+		locations, fileContent, injections := d.identifyErrorFile(ctx, cluster.Example, workspaceDir)
+		cluster.Locations = locations
+		cluster.Injections = injections
+		d.warnInjections(injections)
+
+		analysis, err := d.llmClient.AnalyzeError(ctx, fenceUntrusted(source, cluster.Example), fileContent)
+		if err != nil {
+			cluster.Error = fmt.Sprintf("failed to analyze error: %v", err)
+			continue
+		}
+		cluster.Analysis = analysis
+
+		fix, err := d.generateFix(ctx, cluster.Example, fileContent, analysis)
+		if err != nil {
+			cluster.Error = fmt.Sprintf("failed to generate fix: %v", err)
+			continue
+		}
+		cluster.Fix = fix
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data: Fields{
+			"source":         source,
+			"lines_scanned":  len(lines),
+			"clusters":       analyzed,
+			"total_clusters": len(clusters),
+		},
+	}, nil
+}
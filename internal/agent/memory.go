@@ -0,0 +1,294 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// Kinds of workspace memory
+const (
+	MemoryDecision   = "decision"
+	MemoryConvention = "convention"
+	MemoryFixedError = "fixed_error"
+)
+
+const (
+	// maxMemoryEntries caps the entries kept per workspace; the oldest are
+	// forgotten first
+	maxMemoryEntries = 300
+	// memoryPromptEntries is how many entries are offered to a prompt
+	memoryPromptEntries = 12
+	// learnTimeout bounds extracting memories from a finished request
+	learnTimeout = 2 * time.Minute
+)
+
+// ErrMemoryNotFound is returned for unknown memory entry IDs
+var ErrMemoryNotFound = errors.New("memory entry not found")
+
+// MemoryEntry is a fact about a workspace worth keeping across requests
+type MemoryEntry struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	// TaskID is the task the entry was learned from, if any
+	TaskID    string    `json:"task_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceMemory is what has been learned about one workspace
+type WorkspaceMemory struct {
+	Root    string        `json:"root"`
+	Entries []MemoryEntry `json:"entries"`
+}
+
+// MemoryStore keeps a memory per workspace of decisions made, conventions
+// discovered and errors fixed, so agents need not re-discover them on
+// every request
+type MemoryStore struct {
+	mu       sync.Mutex
+	dir      string
+	memories map[string]*WorkspaceMemory
+	// llmClient extracts memories from finished requests; nil disables it
+	llmClient LLMClient
+	// sealer encrypts memory files; nil stores them in the clear
+	sealer *Sealer
+	logger *zap.Logger
+}
+
+// NewMemoryStore creates a store persisting memories under dataDir; an
+// empty dataDir keeps them in memory only
+func NewMemoryStore(dataDir string, llmClient LLMClient, sealer *Sealer, logger *zap.Logger) *MemoryStore {
+	dir := ""
+	if dataDir != "" {
+		dir = filepath.Join(dataDir, "memory")
+	}
+	return &MemoryStore{dir: dir, memories: make(map[string]*WorkspaceMemory), llmClient: llmClient, sealer: sealer, logger: logger}
+}
+
+// List returns the entries of a workspace, oldest first
+func (s *MemoryStore) List(workspaceDir string) []MemoryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MemoryEntry(nil), s.get(absPath(workspaceDir)).Entries...)
+}
+
+// Add records an entry for a workspace. Entries repeating an existing one
+// are ignored and the existing entry is returned.
+func (s *MemoryStore) Add(workspaceDir string, entry MemoryEntry) (MemoryEntry, error) {
+	switch entry.Kind {
+	case MemoryDecision, MemoryConvention, MemoryFixedError:
+	default:
+		return MemoryEntry{}, fmt.Errorf("unknown memory kind %q", entry.Kind)
+	}
+	entry.Content = strings.TrimSpace(entry.Content)
+	if entry.Content == "" {
+		return MemoryEntry{}, fmt.Errorf("memory content is empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memory := s.get(absPath(workspaceDir))
+	for _, existing := range memory.Entries {
+		if existing.Kind == entry.Kind && strings.EqualFold(existing.Content, entry.Content) {
+			return existing, nil
+		}
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.ID = fmt.Sprintf("mem_%d", entry.CreatedAt.UnixNano())
+	memory.Entries = append(memory.Entries, entry)
+	if len(memory.Entries) > maxMemoryEntries {
+		memory.Entries = memory.Entries[len(memory.Entries)-maxMemoryEntries:]
+	}
+	return entry, s.save(memory)
+}
+
+// Delete forgets an entry
+func (s *MemoryStore) Delete(workspaceDir, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memory := s.get(absPath(workspaceDir))
+	for i, entry := range memory.Entries {
+		if entry.ID == id {
+			memory.Entries = append(memory.Entries[:i], memory.Entries[i+1:]...)
+			return s.save(memory)
+		}
+	}
+	return ErrMemoryNotFound
+}
+
+// Relevant returns up to limit entries of a workspace ranked by how many
+// words they share with query. Conventions apply to any request and rank
+// ahead of unrelated entries; ties go to the newest.
+func (s *MemoryStore) Relevant(workspaceDir, query string, limit int) []MemoryEntry {
+	entries := s.List(workspaceDir)
+	words := memoryWords(query)
+	scores := make(map[string]int, len(entries))
+	var matched []MemoryEntry
+	for _, entry := range entries {
+		score := 0
+		for word := range memoryWords(entry.Content) {
+			if words[word] {
+				score += 2
+			}
+		}
+		if entry.Kind == MemoryConvention {
+			score++
+		}
+		if score > 0 {
+			scores[entry.ID] = score
+			matched = append(matched, entry)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if scores[matched[i].ID] != scores[matched[j].ID] {
+			return scores[matched[i].ID] > scores[matched[j].ID]
+		}
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched
+}
+
+// Prompt returns the entries relevant to query for a prompt, or "" if
+// there are none
+func (s *MemoryStore) Prompt(workspaceDir, query string) string {
+	if s == nil {
+		return ""
+	}
+	entries := s.Relevant(workspaceDir, query, memoryPromptEntries)
+	if len(entries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Known from earlier work in this workspace (rely on it rather than re-discovering it):\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "- [%s] %s\n", entry.Kind, entry.Content)
+	}
+	return b.String()
+}
+
+// Learn asks the LLM which durable facts a finished request revealed and
+// records them. It is meant to run in the background after the request.
+func (s *MemoryStore) Learn(ctx context.Context, workspaceDir, request, outcome, taskID string) {
+	if s == nil || s.llmClient == nil || strings.TrimSpace(outcome) == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, learnTimeout)
+	defer cancel()
+
+	prompt := fmt.Sprintf(`A coding agent just handled this request in a project.
+
+Request: %s
+
+Outcome:
+%s
+
+List the facts worth remembering for future requests in this project: decisions made (libraries, designs, names chosen), conventions of the codebase (layout, naming, error handling, test style) and errors fixed with their cause. Only include facts that will still hold later; skip anything specific to this one request.
+Respond with only a JSON array of {"kind": "decision" | "convention" | "fixed_error", "content": "<one sentence>"}, at most 3 items, or [] if there is nothing worth keeping.`,
+		request, truncateString(outcome, 6000))
+	response, err := s.llmClient.Chat(ctx, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You keep concise, accurate notes about software projects."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	})
+	if err != nil {
+		s.logger.Debug("Failed to learn from request", zap.Error(err))
+		return
+	}
+	var entries []MemoryEntry
+	if err := json.Unmarshal([]byte(extractJSON(response)), &entries); err != nil {
+		s.logger.Debug("Failed to parse learned memories", zap.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		entry.TaskID = taskID
+		if _, err := s.Add(workspaceDir, entry); err != nil {
+			s.logger.Debug("Skipping learned memory", zap.Error(err))
+		}
+	}
+}
+
+// get returns the memory of root, loading it on first use; callers hold mu
+func (s *MemoryStore) get(root string) *WorkspaceMemory {
+	if memory, ok := s.memories[root]; ok {
+		return memory
+	}
+	memory := s.load(root)
+	if memory == nil {
+		memory = &WorkspaceMemory{Root: root, Entries: []MemoryEntry{}}
+	}
+	s.memories[root] = memory
+	return memory
+}
+
+func (s *MemoryStore) path(root string) string {
+	sum := sha1.Sum([]byte(root))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+func (s *MemoryStore) load(root string) *WorkspaceMemory {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path(root))
+	if err == nil {
+		data, err = s.sealer.Open(data)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Warn("Failed to read workspace memory", zap.String("root", root), zap.Error(err))
+		}
+		return nil
+	}
+	var memory WorkspaceMemory
+	if json.Unmarshal(data, &memory) != nil || memory.Root != root {
+		return nil
+	}
+	return &memory
+}
+
+func (s *MemoryStore) save(memory *WorkspaceMemory) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(memory, "", "  ")
+	if err == nil {
+		data, err = s.sealer.Seal(data)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(memory.Root), data, 0644)
+}
+
+// memoryWords returns the distinct lowercase words of text worth matching on
+func memoryWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	}) {
+		if len(word) > 3 {
+			words[word] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,366 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultMigrationBatchSize is the number of files edited before verifying
+	defaultMigrationBatchSize = 5
+	// maxMigrationFiles bounds the files a single migration may edit
+	maxMigrationFiles = 100
+	// maxMigrationFileBytes skips files too large to send to the LLM whole
+	maxMigrationFileBytes = 64 << 10
+)
+
+// MigrationPlan is the change set of a mechanical migration
+type MigrationPlan struct {
+	Summary string `json:"summary"`
+	// Pattern is a regular expression matching code the migration changes
+	Pattern string `json:"pattern,omitempty"`
+	// Instructions are the edit rules applied to every file
+	Instructions string   `json:"instructions"`
+	Files        []string `json:"files"`
+}
+
+// MigrationBatch is the outcome of editing and verifying one batch of files
+type MigrationBatch struct {
+	Files []string `json:"files"`
+	// Changed are the files the LLM actually edited
+	Changed []string `json:"changed,omitempty"`
+	// Status is applied, unchanged, rolled_back or failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// MigrationAgent performs mechanical migrations such as language version
+// bumps and library API changes across many files, in verified batches
+type MigrationAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	events      *EventBus
+	logger      *zap.Logger
+	// terminal screens the verify_command sent with tasks, which runs
+	// unattended after every batch
+	terminal *TerminalAgentImpl
+}
+
+// NewMigrationAgent creates a new migration agent
+func NewMigrationAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, events *EventBus, terminal *TerminalAgentImpl, logger *zap.Logger) *MigrationAgentImpl {
+	return &MigrationAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		events:      events,
+		logger:      logger,
+		terminal:    terminal,
+	}
+}
+
+// Type returns the agent type
+func (m *MigrationAgentImpl) Type() AgentType {
+	return MigrationAgent
+}
+
+// Execute migrates the workspace as described by "request". The LLM plans
+// the change set ("files" overrides it), then files are edited
+// "batch_size" at a time and the project is built and tested after each
+// batch. A batch that breaks the build is rolled back and the migration
+// stops there unless "continue_on_failure" is set. "dry_run" returns the
+// plan only; "verify_command" replaces the default build and test commands,
+// and when it needs approval the migration waits for the task to be sent
+// again with the approval's "approval_id".
+func (m *MigrationAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	m.logger.Info("Migration agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	request := stringField(task.Data, "request")
+	if request == "" {
+		return nil, fmt.Errorf("request not found in task data")
+	}
+
+	plan, err := m.plan(ctx, request, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if files := stringList(task.Data, "files"); len(files) > 0 {
+		plan.Files = files
+	} else {
+		plan.Files = m.candidateFiles(ctx, plan, workspaceDir)
+	}
+	if len(plan.Files) > maxMigrationFiles {
+		plan.Files = plan.Files[:maxMigrationFiles]
+	}
+	data := Fields{"plan": plan}
+	if len(plan.Files) == 0 {
+		return &TaskResult{Success: false, Error: "no files need migrating", Data: data}, nil
+	}
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+
+	if command := stringField(task.Data, "verify_command"); command != "" {
+		approval, err := m.terminal.screenCommand(ctx, task, command, workspaceDir)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error(), Data: data}, nil
+		}
+		if approval != nil {
+			data["verify_command"] = command
+			data["requires_approval"] = true
+			data["approval_id"] = approval.ID
+			return &TaskResult{Success: false, Error: fmt.Sprintf("verify command requires approval (%s risk)", approval.Risk.Level), Data: data}, nil
+		}
+	}
+	verify := m.verifyCommands(task, workspaceDir)
+	data["verify_commands"] = verify
+	// Batches can only be judged by the build if it works to begin with
+	if output, ok := m.verify(ctx, verify, workspaceDir); !ok {
+		data["verify_output"] = output
+		return &TaskResult{Success: false, Error: "the project doesn't build or pass its tests before the migration", Data: data}, nil
+	}
+
+	batchSize := defaultMigrationBatchSize
+	if n, ok := task.Data["batch_size"].(float64); ok && n >= 1 {
+		batchSize = int(n)
+	}
+	continueOnFailure, _ := task.Data["continue_on_failure"].(bool)
+
+	var batches []MigrationBatch
+	var changed []string
+	failed := 0
+	for start := 0; start < len(plan.Files); start += batchSize {
+		files := plan.Files[start:min(start+batchSize, len(plan.Files))]
+		batch := m.runBatch(ctx, task, plan, files, len(batches), verify, workspaceDir)
+		batches = append(batches, batch)
+		changed = append(changed, batch.Changed...)
+		m.events.Publish(TaskEvent{
+			TaskID: task.ID,
+			Type:   EventMigrationBatch,
+			Data:   map[string]interface{}{"batch": len(batches), "of": (len(plan.Files) + batchSize - 1) / batchSize, "result": batch},
+		})
+		if batch.Status == "rolled_back" || batch.Status == "failed" {
+			failed++
+			if !continueOnFailure {
+				break
+			}
+		}
+	}
+	data["batches"] = batches
+	data["changed_files"] = changed
+
+	result := &TaskResult{Success: failed == 0, Data: data}
+	if failed > 0 {
+		result.Error = fmt.Sprintf("%d batch(es) failed and were left unchanged", failed)
+	}
+	return result, nil
+}
+
+// plan asks the LLM how to carry out the migration
+func (m *MigrationAgentImpl) plan(ctx context.Context, request, workspaceDir string) (*MigrationPlan, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Plan this migration: %s\n\nProject files:\n%s\n", request, strings.Join(workspaceListing(workspaceDir), "\n"))
+	for _, name := range manifestHints {
+		if content, err := fileManagerFor(ctx, m.fileManager).ReadHead(filepath.Join(workspaceDir, name), 40); err == nil {
+			fmt.Fprintf(&prompt, "\n%s:\n%s\n", name, content)
+		}
+	}
+	prompt.WriteString(`
+Respond with only JSON: {"summary": "<one sentence>", "pattern": "<RE2 regular expression matching code that must change, or empty>", "instructions": "<precise rules for editing any affected file>", "files": ["<files known to need changes, e.g. go.mod>"]}`)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert at mechanical codebase migrations: language version upgrades and library API changes."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt.String()},
+	}
+	response, err := m.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan migration: %w", err)
+	}
+	var plan MigrationPlan
+	if err := json.Unmarshal([]byte(extractJSON(response)), &plan); err != nil || plan.Instructions == "" {
+		return nil, fmt.Errorf("failed to parse migration plan from LLM response: %v", err)
+	}
+	return &plan, nil
+}
+
+// candidateFiles combines the files the plan names with the source files
+// matching its pattern, in a stable order
+func (m *MigrationAgentImpl) candidateFiles(ctx context.Context, plan *MigrationPlan, workspaceDir string) []string {
+	var files []string
+	for _, file := range plan.Files {
+		file = filepath.ToSlash(filepath.Clean(file))
+		if fileManagerFor(ctx, m.fileManager).FileExists(filepath.Join(workspaceDir, file)) && !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	pattern, err := regexp.Compile(plan.Pattern)
+	if plan.Pattern == "" || err != nil {
+		if err != nil {
+			m.logger.Warn("Ignoring invalid migration pattern", zap.String("pattern", plan.Pattern), zap.Error(err))
+		}
+		return files
+	}
+
+	root := absPath(workspaceDir)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (skipDir(d.Name()) || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !searchableExtensions[strings.ToLower(filepath.Ext(path))] && !slices.Contains(manifestHints, d.Name()) {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxMigrationFileBytes {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if slices.Contains(files, rel) {
+			return nil
+		}
+		if content, err := fileManagerFor(ctx, m.fileManager).ReadFile(path); err == nil && pattern.MatchString(content) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+// runBatch edits a batch of files and verifies the result, rolling the
+// batch back if verification fails
+func (m *MigrationAgentImpl) runBatch(ctx context.Context, task *Task, plan *MigrationPlan, files []string, index int, verify []string, workspaceDir string) MigrationBatch {
+	batch := MigrationBatch{Files: files}
+
+	var patches []FilePatch
+	var errs []string
+	for _, file := range files {
+		filePatches, err := m.generatePatches(ctx, plan, file, workspaceDir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		patches = append(patches, filePatches...)
+	}
+	if len(patches) == 0 {
+		batch.Status = "unchanged"
+		if len(errs) > 0 {
+			batch.Status = "failed"
+			batch.Error = strings.Join(errs, "; ")
+		}
+		return batch
+	}
+
+	backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID, fmt.Sprintf("migration-%d", index))
+	applied, err := ApplyPatches(fileManagerFor(ctx, m.fileManager), workspaceDir, backupDir, patches)
+	if err != nil {
+		batch.Status = "failed"
+		batch.Error = err.Error()
+		return batch
+	}
+
+	if output, ok := m.verify(ctx, verify, workspaceDir); !ok {
+		if err := applied.Rollback(); err != nil {
+			m.logger.Error("Failed to roll back migration batch", zap.Int("batch", index), zap.Error(err))
+		}
+		batch.Status = "rolled_back"
+		batch.Error = truncateString(output, 4000)
+		return batch
+	}
+	batch.Status = "applied"
+	batch.Changed = patchPaths(patches)
+	if len(errs) > 0 {
+		batch.Error = strings.Join(errs, "; ")
+	}
+	return batch
+}
+
+// generatePatches asks the LLM to apply the plan's instructions to one file.
+// Files that need no change yield no patches.
+func (m *MigrationAgentImpl) generatePatches(ctx context.Context, plan *MigrationPlan, file, workspaceDir string) ([]FilePatch, error) {
+	content, err := fileManagerFor(ctx, m.fileManager).ReadFile(filepath.Join(workspaceDir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := fmt.Sprintf(`Migration: %s
+Rules:
+%s
+
+Apply the rules to %s (numbered lines):
+%s
+
+Respond with only a JSON array of patches, each {"path": %q, "search": "<exact existing text>", "replace": "<new text>"}, or [] if the file needs no change.
+Search text must match the file exactly, without line numbers. Change nothing the rules don't call for.`, plan.Summary, plan.Instructions, file, numberLines(content), file)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You apply mechanical migrations with minimal, exact file patches as JSON."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := m.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate migration edits: %w", err)
+	}
+	patches, err := parsePatches(response)
+	if err != nil {
+		return nil, err
+	}
+	for i := range patches {
+		patches[i].Path = file
+	}
+	return patches, nil
+}
+
+// verifyCommands returns the commands that must pass after every batch:
+// "verify_command" if given, else a build and the test suite
+func (m *MigrationAgentImpl) verifyCommands(task *Task, workspaceDir string) []string {
+	if command := stringField(task.Data, "verify_command"); command != "" {
+		return []string{command}
+	}
+	framework, err := DetectTestFramework(workspaceDir)
+	if err != nil {
+		return nil
+	}
+	var commands []string
+	switch framework {
+	case FrameworkGo:
+		commands = append(commands, "go build ./...", "go vet ./...")
+	case FrameworkCargo:
+		commands = append(commands, "cargo build")
+	}
+	if command, err := testCommand(m.commandExec.DefaultShell(), framework, "", ""); err == nil {
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// verify runs the verification commands, returning the failing command's
+// output
+func (m *MigrationAgentImpl) verify(ctx context.Context, commands []string, workspaceDir string) (string, bool) {
+	for _, command := range commands {
+		run, err := m.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+		if err != nil {
+			return err.Error(), false
+		}
+		if run.Status != "completed" {
+			return fmt.Sprintf("%s: %s\n%s", command, run.Status, strings.TrimSpace(run.Output+"\n"+run.Error)), false
+		}
+	}
+	return "", true
+}
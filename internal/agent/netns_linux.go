@@ -0,0 +1,42 @@
+//go:build linux
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateNetwork starts the command in a network namespace of its own, with
+// only a loopback interface. A user namespace mapping the current user to
+// itself lets unprivileged servers do so.
+func isolateNetwork(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	if uid := os.Getuid(); uid != 0 {
+		gid := os.Getgid()
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	}
+	return nil
+}
+
+// checkNetworkIsolation reports whether commands can be isolated from the
+// network, by running true that way
+func checkNetworkIsolation() error {
+	path, err := exec.LookPath("true")
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command(path)
+	isolateNetwork(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot create network namespaces (are unprivileged user namespaces disabled?): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errNoNetworkIsolation is returned where commands and tools cannot be run
+// without network access outside a container
+var errNoNetworkIsolation = errors.New("running commands and tools without network access needs Linux")
+
+// isolateNetwork is only supported on Linux
+func isolateNetwork(cmd *exec.Cmd) error {
+	return errNoNetworkIsolation
+}
+
+// checkNetworkIsolation reports that commands cannot be isolated
+func checkNetworkIsolation() error {
+	return errNoNetworkIsolation
+}
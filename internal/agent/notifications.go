@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"spilot-agent/internal/notify"
+
+	"go.uber.org/zap"
+)
+
+// Events notification channels can be subscribed to
+const (
+	NotifyTaskCompleted = "task_completed"
+	NotifyTaskFailed    = "task_failed"
+	NotifyBudgetAlert   = "budget_alert"
+)
+
+// NotifyEvents are every event notification channels can be subscribed to
+var NotifyEvents = []string{NotifyTaskCompleted, NotifyTaskFailed, NotifyBudgetAlert}
+
+// notifyTimeout bounds delivering one notification
+const notifyTimeout = time.Minute
+
+// Limits of the texts of notifications, and of the titles of issues
+const (
+	maxNotificationText = 1500
+	maxSubject          = 120
+)
+
+// NotificationsConfig holds the notification channels. Tasks and plans
+// that take at least MinDuration notify as they finish.
+type NotificationsConfig struct {
+	MinDuration time.Duration
+	Channels    []NotificationChannelConfig
+}
+
+// NotificationChannelConfig is one notification channel. Events are the
+// events it receives, all NotifyEvents when empty; Workspaces restrict it
+// to work in those workspaces and their subdirectories. The remaining
+// fields configure the channel (see notify.Config).
+type NotificationChannelConfig struct {
+	Name       string
+	Type       string
+	Events     []string
+	Workspaces []string
+	WebhookURL string
+	SMTPHost   string
+	SMTPPort   int
+	Username   string
+	Password   string
+	From       string
+	To         []string
+}
+
+// notificationChannel is a channel and what it receives
+type notificationChannel struct {
+	name       string
+	events     map[string]bool
+	workspaces []string
+	channel    notify.Channel
+}
+
+// wants reports whether the channel receives event for work in workspace
+func (c *notificationChannel) wants(event, workspace string) bool {
+	if len(c.events) > 0 && !c.events[event] {
+		return false
+	}
+	if len(c.workspaces) == 0 {
+		return true
+	}
+	for _, dir := range c.workspaces {
+		if workspace != "" && within(dir, workspace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifications tells people over email or Discord when long-running work
+// finishes and when spend budgets reach an alert threshold. A nil
+// Notifications sends nothing.
+type Notifications struct {
+	minDuration time.Duration
+	channels    []*notificationChannel
+	logger      *zap.Logger
+}
+
+// NewNotifications creates the channels in cfg, or returns nil if there
+// are none
+func NewNotifications(cfg NotificationsConfig, logger *zap.Logger) (*Notifications, error) {
+	if len(cfg.Channels) == 0 {
+		return nil, nil
+	}
+	n := &Notifications{minDuration: cfg.MinDuration, logger: logger}
+	for i, c := range cfg.Channels {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("channel %d", i+1)
+		}
+		channel, err := notify.New(notify.Config{
+			Type:       c.Type,
+			WebhookURL: c.WebhookURL,
+			SMTPHost:   c.SMTPHost,
+			SMTPPort:   c.SMTPPort,
+			Username:   c.Username,
+			Password:   c.Password,
+			From:       c.From,
+			To:         c.To,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("notification channel %s: %w", c.Name, err)
+		}
+		nc := &notificationChannel{name: c.Name, channel: channel}
+		if len(c.Events) > 0 {
+			nc.events = make(map[string]bool, len(c.Events))
+			for _, event := range c.Events {
+				nc.events[event] = true
+			}
+		}
+		for _, dir := range c.Workspaces {
+			nc.workspaces = append(nc.workspaces, absPath(dir))
+		}
+		n.channels = append(n.channels, nc)
+	}
+	return n, nil
+}
+
+// taskFinished notifies that a task which took elapsed finished. Only
+// tasks run on their own notify; plan steps and handoffs are part of one.
+func (n *Notifications) taskFinished(ctx context.Context, task *Task, result *TaskResult, err error, elapsed time.Duration) {
+	if n == nil || elapsed < n.minDuration || strings.Contains(task.ID, ".") {
+		return
+	}
+	event, outcome := NotifyTaskCompleted, "completed"
+	if err != nil || result == nil || !result.Success {
+		event, outcome = NotifyTaskFailed, "failed"
+	}
+	workspace := workspaceFrom(ctx)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Task %s (%s agent) %s after %s.\n", task.ID, task.Type, outcome, elapsed.Round(time.Second))
+	if workspace != "" {
+		fmt.Fprintf(&b, "Workspace: %s\n", workspace)
+	}
+	text := resultMessage(result)
+	if err != nil {
+		text = "Failed: " + err.Error()
+	}
+	if text != "" {
+		b.WriteString("\n" + truncateString(text, maxNotificationText) + "\n")
+	}
+	n.send(event, workspace, notify.Message{
+		Subject: fmt.Sprintf("Spilot task %s: %s", outcome, truncateSubject(task.Description)),
+		Text:    b.String(),
+	})
+}
+
+// planFinished notifies that the execution of a plan's tasks, as parentID,
+// which took elapsed finished
+func (n *Notifications) planFinished(parentID, workspace string, tasks []*Task, results []*TaskResult, err error, elapsed time.Duration) {
+	if n == nil || elapsed < n.minDuration {
+		return
+	}
+	if workspace != "" {
+		workspace = absPath(workspace)
+	}
+	event, outcome := NotifyTaskCompleted, "completed"
+	if !planSucceeded(tasks, results, err) {
+		event, outcome = NotifyTaskFailed, "failed"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plan %s %s after %s.\n", parentID, outcome, elapsed.Round(time.Second))
+	if workspace != "" {
+		fmt.Fprintf(&b, "Workspace: %s\n", workspace)
+	}
+	b.WriteString("\n" + planOutcome(tasks, results, err))
+	n.send(event, workspace, notify.Message{
+		Subject: fmt.Sprintf("Spilot plan %s: %d tasks", outcome, len(tasks)),
+		Text:    b.String(),
+	})
+}
+
+// budgetAlert notifies that a budget reached threshold. Budgets of a
+// workspace alert the channels of that workspace; other budgets alert
+// channels not restricted to workspaces.
+func (n *Notifications) budgetAlert(status *BudgetStatus, threshold float64) {
+	if n == nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Spend budget %q used %s per %s, %.0f%% of it.\n", status.Budget, status.describe(), status.Period, status.Used*100)
+	if status.Requester != "" {
+		fmt.Fprintf(&b, "Requester: %s\n", status.Requester)
+	}
+	if status.Workspace != "" {
+		fmt.Fprintf(&b, "Workspace: %s\n", status.Workspace)
+	}
+	fmt.Fprintf(&b, "It resets at %s.\n", status.Resets.Format(time.RFC1123))
+	n.send(NotifyBudgetAlert, status.Workspace, notify.Message{
+		Subject: fmt.Sprintf("Spilot budget %q reached %.0f%%", status.Budget, threshold*100),
+		Text:    b.String(),
+	})
+}
+
+// send delivers msg to the channels that want event for workspace, in the
+// background
+func (n *Notifications) send(event, workspace string, msg notify.Message) {
+	for _, c := range n.channels {
+		if !c.wants(event, workspace) {
+			continue
+		}
+		go func(c *notificationChannel) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := c.channel.Send(ctx, msg); err != nil {
+				n.logger.Warn("Failed to send notification",
+					zap.String("channel", c.name), zap.String("event", event), zap.Error(err))
+			}
+		}(c)
+	}
+}
+
+// truncateSubject shortens text to a one-line subject
+func truncateSubject(text string) string {
+	subject := []rune(strings.Join(strings.Fields(text), " "))
+	if len(subject) > maxSubject {
+		subject = append(subject[:maxSubject], '…')
+	}
+	return string(subject)
+}
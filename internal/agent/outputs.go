@@ -0,0 +1,365 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// ErrOutputNotFound is returned for outputs that do not exist, were written
+// by an earlier run, or belong to another requester's task
+var ErrOutputNotFound = errors.New("output not found")
+
+const (
+	// outputPreviewBytes is how much of the start, and of the end, of a
+	// spilled output its reference keeps
+	outputPreviewBytes = 2048
+	// outputRetention is how long the outputs of a run that did not shut
+	// down cleanly are kept
+	outputRetention = 24 * time.Hour
+)
+
+// OutputRef stands in a task result for an output too large to keep in
+// memory, such as a long LLM answer or command log. Preview is its start
+// and end; ReadOutput returns all of it.
+type OutputRef struct {
+	OutputID string `json:"output_id"`
+	Bytes    int    `json:"bytes"`
+	Preview  string `json:"preview"`
+}
+
+// OutputStore writes the outputs of task results larger than a threshold
+// to temporary files in DataDir/outputs, replacing them in the results
+// with OutputRefs. Results only live in memory, so each run has its own
+// directory, removed when it shuts down. A nil OutputStore keeps every
+// output in memory.
+type OutputStore struct {
+	dir       string
+	threshold int
+	sealer    *Sealer
+
+	mu sync.Mutex
+	// outputs maps output IDs to the tasks they belong to
+	outputs map[string]string
+	logger  *zap.Logger
+}
+
+// NewOutputStore creates a store spilling outputs of at least threshold
+// bytes under dataDir, or the system's temporary directory if dataDir is
+// empty. It returns nil if threshold is not positive.
+func NewOutputStore(dataDir string, threshold int64, sealer *Sealer, logger *zap.Logger) (*OutputStore, error) {
+	if threshold <= 0 {
+		return nil, nil
+	}
+	root := os.TempDir()
+	if dataDir != "" {
+		root = filepath.Join(dataDir, "outputs")
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outputs directory: %w", err)
+	}
+	pruneOutputs(root, logger)
+	dir, err := os.MkdirTemp(root, "spilot-outputs-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outputs directory: %w", err)
+	}
+	return &OutputStore{
+		dir:       dir,
+		threshold: int(threshold),
+		sealer:    sealer,
+		outputs:   make(map[string]string),
+		logger:    logger,
+	}, nil
+}
+
+// pruneOutputs removes the output directories of earlier runs that did not
+// shut down cleanly, once they are outputRetention old
+func pruneOutputs(root string, logger *zap.Logger) {
+	entries, err := filepath.Glob(filepath.Join(root, "spilot-outputs-*"))
+	if err != nil {
+		return
+	}
+	for _, dir := range entries {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || time.Since(info.ModTime()) < outputRetention {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warn("Failed to remove old outputs", zap.String("dir", dir), zap.Error(err))
+		}
+	}
+}
+
+// spill returns result with its outputs of at least the threshold written
+// to files: the strings of Fields become OutputRefs, and the content of
+// file reads and the output and error of commands become previews, with
+// their ContentID, OutputID or ErrorID set. result itself is not modified.
+// Outputs that cannot be written stay in the result.
+func (o *OutputStore) spill(taskID string, result *TaskResult) *TaskResult {
+	if o == nil || result == nil || result.Data == nil {
+		return result
+	}
+	data, changed := o.spillData(taskID, result.Data)
+	if !changed {
+		return result
+	}
+	spilled := *result
+	spilled.Data = data
+	return &spilled
+}
+
+func (o *OutputStore) spillData(taskID string, data ResultData) (ResultData, bool) {
+	switch d := data.(type) {
+	case Fields:
+		fields, changed := o.spillMap(taskID, d)
+		return Fields(fields), changed
+	case *FileOpResult:
+		content := o.write(taskID, d.Content)
+		if content == nil {
+			return data, false
+		}
+		spilled := *d
+		spilled.Content, spilled.ContentID = content.Preview, content.OutputID
+		return &spilled, true
+	case *CommandResult:
+		output, errOutput := o.write(taskID, d.Output), o.write(taskID, d.Error)
+		if output == nil && errOutput == nil {
+			return data, false
+		}
+		spilled := *d
+		if output != nil {
+			spilled.Output, spilled.OutputID = output.Preview, output.OutputID
+		}
+		if errOutput != nil {
+			spilled.Error, spilled.ErrorID = errOutput.Preview, errOutput.OutputID
+		}
+		return &spilled, true
+	case *DebugResult:
+		verification := o.spillCommand(taskID, d.Verification)
+		if verification == d.Verification {
+			return data, false
+		}
+		spilled := *d
+		spilled.Verification = verification
+		return &spilled, true
+	}
+	return data, false
+}
+
+func (o *OutputStore) spillMap(taskID string, data map[string]interface{}) (map[string]interface{}, bool) {
+	var out map[string]interface{}
+	for key, value := range data {
+		spilled, changed := o.spillValue(taskID, value)
+		if !changed {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]interface{}, len(data))
+			for k, v := range data {
+				out[k] = v
+			}
+		}
+		out[key] = spilled
+	}
+	if out == nil {
+		return data, false
+	}
+	return out, true
+}
+
+func (o *OutputStore) spillValue(taskID string, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if ref := o.write(taskID, v); ref != nil {
+			return *ref, true
+		}
+	case map[string]interface{}:
+		return o.spillMap(taskID, v)
+	case []interface{}:
+		var out []interface{}
+		for i, item := range v {
+			spilled, changed := o.spillValue(taskID, item)
+			if !changed {
+				continue
+			}
+			if out == nil {
+				out = append([]interface{}(nil), v...)
+			}
+			out[i] = spilled
+		}
+		if out != nil {
+			return out, true
+		}
+	case *Command:
+		if spilled := o.spillCommand(taskID, v); spilled != v {
+			return spilled, true
+		}
+	case []*Command:
+		var out []*Command
+		for i, command := range v {
+			spilled := o.spillCommand(taskID, command)
+			if spilled == command {
+				continue
+			}
+			if out == nil {
+				out = append([]*Command(nil), v...)
+			}
+			out[i] = spilled
+		}
+		if out != nil {
+			return out, true
+		}
+	}
+	return value, false
+}
+
+// spillCommand returns command with a large output or error spilled, or
+// command itself if neither is
+func (o *OutputStore) spillCommand(taskID string, command *Command) *Command {
+	if command == nil {
+		return nil
+	}
+	output, errOutput := o.write(taskID, command.Output), o.write(taskID, command.Error)
+	if output == nil && errOutput == nil {
+		return command
+	}
+	spilled := *command
+	if output != nil {
+		spilled.Output, spilled.OutputID = output.Preview, output.OutputID
+	}
+	if errOutput != nil {
+		spilled.Error, spilled.ErrorID = errOutput.Preview, errOutput.OutputID
+	}
+	return &spilled
+}
+
+// write stores text if it reaches the threshold, returning its reference,
+// or nil if it is kept in memory
+func (o *OutputStore) write(taskID, text string) *OutputRef {
+	if len(text) < o.threshold {
+		return nil
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil
+	}
+	id := "out_" + hex.EncodeToString(random)
+	data, err := o.sealer.Seal([]byte(text))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(o.dir, id), data, 0600)
+	}
+	if err != nil {
+		o.logger.Warn("Failed to write output to disk; keeping it in memory", zap.String("task_id", taskID), zap.Error(err))
+		return nil
+	}
+	o.mu.Lock()
+	o.outputs[id] = taskID
+	o.mu.Unlock()
+	return &OutputRef{OutputID: id, Bytes: len(text), Preview: outputPreview(text)}
+}
+
+// open returns the output with id and the task it belongs to
+func (o *OutputStore) open(id string) (io.ReadCloser, string, error) {
+	if o == nil {
+		return nil, "", ErrOutputNotFound
+	}
+	o.mu.Lock()
+	taskID, ok := o.outputs[id]
+	o.mu.Unlock()
+	if !ok {
+		return nil, "", ErrOutputNotFound
+	}
+	path := filepath.Join(o.dir, id)
+	if o.sealer == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return file, taskID, nil
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		data, err = o.sealer.Open(data)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return io.NopCloser(bytes.NewReader(data)), taskID, nil
+}
+
+// text returns the whole output a value refers to, or the value itself if
+// it is a string, or "" otherwise
+func (o *OutputStore) text(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case OutputRef:
+		reader, _, err := o.open(v.OutputID)
+		if err != nil {
+			return v.Preview
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return v.Preview
+		}
+		return string(data)
+	}
+	return ""
+}
+
+// Close removes the outputs of this run
+func (o *OutputStore) Close() {
+	if o == nil {
+		return
+	}
+	if err := os.RemoveAll(o.dir); err != nil {
+		o.logger.Warn("Failed to remove outputs", zap.Error(err))
+	}
+}
+
+// ReadOutput returns a task output that was written to disk, by the ID of
+// its OutputRef, the OutputID or ErrorID of a command or the ContentID of a
+// file read. The caller closes it.
+func (s *System) ReadOutput(ctx context.Context, id string) (io.ReadCloser, error) {
+	reader, taskID, err := s.outputs.open(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrOutputNotFound
+		}
+		return nil, err
+	}
+	if !s.OwnsTask(ctx, taskID) {
+		reader.Close()
+		return nil, ErrOutputNotFound
+	}
+	return reader, nil
+}
+
+// outputPreview returns the start and end of text
+func outputPreview(text string) string {
+	if len(text) <= 2*outputPreviewBytes {
+		return text
+	}
+	// Cut on rune boundaries
+	end, start := outputPreviewBytes, len(text)-outputPreviewBytes
+	for i := 0; i < utf8.UTFMax && !utf8.RuneStart(text[end]); i++ {
+		end--
+	}
+	for i := 0; i < utf8.UTFMax && !utf8.RuneStart(text[start]); i++ {
+		start++
+	}
+	head, tail := text[:end], text[start:]
+	return fmt.Sprintf("%s\n...[%d bytes omitted]...\n%s", head, len(text)-len(head)-len(tail), tail)
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilePatch is a concrete change to one file. If Search is set, its first
+// occurrence is replaced by Replace; otherwise the file is overwritten
+// with Content.
+type FilePatch struct {
+	Path    string `json:"path"`
+	Search  string `json:"search,omitempty"`
+	Replace string `json:"replace,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// AppliedPatchSet records the files changed by a set of patches so the
+// change can be rolled back
+type AppliedPatchSet struct {
+	Files     []string `json:"files"`
+	BackupDir string   `json:"backup_dir"`
+
+	fileManager FileManager
+	// originals maps a changed path to its previous content; nil content
+	// means the file did not exist
+	originals map[string]*string
+}
+
+// ApplyPatches applies patches to files inside workspaceDir, backing up
+// every touched file under backupDir first. If any patch fails, the
+// already applied ones are rolled back.
+func ApplyPatches(fileManager FileManager, workspaceDir, backupDir string, patches []FilePatch) (*AppliedPatchSet, error) {
+	set := &AppliedPatchSet{
+		BackupDir:   backupDir,
+		fileManager: fileManager,
+		originals:   make(map[string]*string),
+	}
+
+	for _, patch := range patches {
+		if err := set.apply(workspaceDir, patch); err != nil {
+			if rbErr := set.Rollback(); rbErr != nil {
+				return nil, fmt.Errorf("%w (rollback also failed: %v)", err, rbErr)
+			}
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func (s *AppliedPatchSet) apply(workspaceDir string, patch FilePatch) error {
+	path, ok := resolveInWorkspace(workspaceDir, patch.Path)
+	if !ok {
+		return fmt.Errorf("patch path %s is outside the workspace", patch.Path)
+	}
+
+	var original *string
+	if s.fileManager.FileExists(path) {
+		content, err := s.fileManager.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		original = &content
+	}
+
+	var updated string
+	switch {
+	case patch.Search != "":
+		if original == nil {
+			return fmt.Errorf("cannot apply search/replace patch to missing file %s", patch.Path)
+		}
+		if !strings.Contains(*original, patch.Search) {
+			return fmt.Errorf("search text not found in %s", patch.Path)
+		}
+		updated = strings.Replace(*original, patch.Search, patch.Replace, 1)
+	default:
+		updated = patch.Content
+	}
+
+	if _, seen := s.originals[path]; !seen {
+		if original != nil {
+			if err := s.backup(workspaceDir, path, *original); err != nil {
+				return err
+			}
+		}
+		s.originals[path] = original
+		s.Files = append(s.Files, path)
+	}
+
+	if original == nil {
+		return s.fileManager.CreateFile(path, updated)
+	}
+	return s.fileManager.UpdateFile(path, updated)
+}
+
+// backup copies the original content of path into the backup directory
+func (s *AppliedPatchSet) backup(workspaceDir, path, content string) error {
+	root, _ := filepath.Abs(workspaceDir)
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	backupPath := filepath.Join(s.BackupDir, rel)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.WriteFile(backupPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return nil
+}
+
+// Rollback restores every changed file to its original content, deleting
+// files the patches created
+func (s *AppliedPatchSet) Rollback() error {
+	var errs []string
+	for path, original := range s.originals {
+		var err error
+		if original == nil {
+			err = s.fileManager.DeleteFile(path)
+		} else {
+			err = s.fileManager.UpdateFile(path, *original)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to roll back: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parsePatches decodes an LLM response holding a JSON array of patches
+func parsePatches(response string) ([]FilePatch, error) {
+	var patches []FilePatch
+	if err := json.Unmarshal([]byte(extractJSON(response)), &patches); err != nil {
+		return nil, fmt.Errorf("failed to parse patches from LLM response: %w", err)
+	}
+	return patches, nil
+}
+
+// extractJSON strips Markdown code fences and surrounding prose from an
+// LLM response, returning the outermost JSON array or object
+func extractJSON(response string) string {
+	text := strings.TrimSpace(response)
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		if nl := strings.Index(body, "\n"); nl >= 0 {
+			body = body[nl+1:]
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			text = strings.TrimSpace(body[:end])
+		}
+	}
+
+	start := strings.IndexAny(text, "[{")
+	if start < 0 {
+		return text
+	}
+	closer := byte('}')
+	if text[start] == '[' {
+		closer = ']'
+	}
+	end := strings.LastIndexByte(text, closer)
+	if end < start {
+		return text[start:]
+	}
+	return text[start : end+1]
+}
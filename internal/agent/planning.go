@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
@@ -13,13 +15,26 @@ import (
 // PlanningAgent handles high-level planning and task breakdown
 type PlanningAgentImpl struct {
 	llmClient LLMClient
-	logger    *zap.Logger
+	// web supplies current documentation for plans; nil disables it
+	web WebRetriever
+	// profiles describe the workspace being planned for
+	profiles *ProfileStore
+	// memory holds what earlier requests learned; nil disables it
+	memory *MemoryStore
+	// extraAgents lists custom and plugin agents available to plans
+	extraAgents func() []AgentCapabilities
+	// disabledAgents are agent types plans must not use
+	disabledAgents []AgentType
+	logger         *zap.Logger
 }
 
 // NewPlanningAgent creates a new planning agent
-func NewPlanningAgent(llmClient LLMClient, logger *zap.Logger) *PlanningAgentImpl {
+func NewPlanningAgent(llmClient LLMClient, web WebRetriever, profiles *ProfileStore, memory *MemoryStore, logger *zap.Logger) *PlanningAgentImpl {
 	return &PlanningAgentImpl{
 		llmClient: llmClient,
+		web:       web,
+		profiles:  profiles,
+		memory:    memory,
 		logger:    logger,
 	}
 }
@@ -45,7 +60,7 @@ func (p *PlanningAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResul
 		if err != nil {
 			return nil, err
 		}
-		return &TaskResult{Success: true, Data: map[string]interface{}{"plan": plan}}, nil
+		return &TaskResult{Success: true, Data: &PlanResult{Project: plan}}, nil
 	}
 
 	if strings.HasPrefix(request, "/explain") {
@@ -54,28 +69,82 @@ func (p *PlanningAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResul
 		if err != nil {
 			return nil, err
 		}
-		return &TaskResult{Success: true, Data: map[string]interface{}{"explanation": explanation}}, nil
+		return &TaskResult{Success: true, Data: Fields{"explanation": explanation}}, nil
 	}
 
 	// Generic planning for other natural language requests
-	plan, err := p.createGenericPlan(ctx, request)
+	webContext := retrieveWebContext(ctx, p.web, task.Data, request, p.logger)
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	project := p.profiles.Prompt(workspaceDir)
+	if memory := p.memory.Prompt(workspaceDir, request); memory != "" {
+		project += "\n" + memory
+	}
+	if ticket := stringField(task.Data, "ticket_context"); ticket != "" {
+		project += "\nThe request is for this issue; plan the work it describes:\n" + ticket + "\n"
+	}
+	plan, err := p.createGenericPlan(ctx, request, project, stringField(task.Data, "history"), webContext)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create plan: %w", err)
 	}
 
-	return &TaskResult{
-		Success: true,
-		Data:    map[string]interface{}{"plan": plan},
-	}, nil
+	result := &PlanResult{Plan: plan}
+	if webContext != nil {
+		result.WebSources = webContext.Sources
+	}
+	return &TaskResult{Success: true, Data: result}, nil
 }
 
 // createGenericPlan creates a generic plan from a natural language request
-func (p *PlanningAgentImpl) createGenericPlan(ctx context.Context, request string) (string, error) {
+// for the project described by project, following on from the conversation
+// history and grounded in webContext when it is available
+func (p *PlanningAgentImpl) createGenericPlan(ctx context.Context, request, project, history string, webContext *WebContext) (string, error) {
+	reference := ""
+	if project != "" {
+		reference = "\n" + project + "\n"
+	}
+	if history != "" {
+		reference += "\nConversation so far; resolve references such as \"that function\" against it:\n" + history
+	}
+	if webContext != nil {
+		reference += "\n" + webContext.Prompt()
+	}
+	if p.extraAgents != nil {
+		for _, agent := range p.extraAgents() {
+			reference += fmt.Sprintf("\nAgent %q is also available: %s.", agent.Type, agent.Description)
+			if len(agent.Operations) > 0 {
+				reference += fmt.Sprintf(" Its data takes an \"operation\" (%s).", strings.Join(agent.Operations, ", "))
+			}
+		}
+	}
+	if len(p.disabledAgents) > 0 {
+		types := make([]string, len(p.disabledAgents))
+		for i, agentType := range p.disabledAgents {
+			types[i] = fmt.Sprintf("%q", agentType)
+		}
+		reference += fmt.Sprintf("\nThese agents are disabled; never plan tasks for them: %s.", strings.Join(types, ", "))
+	}
 	prompt := fmt.Sprintf(`%s
-User request: "%s"
-Generate a JSON array of tasks. Each task must have a "type" (e.g., "file", "terminal"), a "description", and a "data" object with necessary parameters.
+User request: "%s"%s
+Generate a JSON array of tasks. Each task must have a "type" (e.g., "file", "terminal", "git", "test", "review", "refactor", "docs", "search", "database", "http", "security", "lint", "migration", "release", "benchmark", "kubernetes"), a "description", and a "data" object with necessary parameters.
 For file tasks, data should include "operation", "path", and "content".
 For terminal tasks, data should include "instruction".
+For test tasks, data may include "operation" (run or generate), "target", "pattern" and, for generate, "path".
+For review tasks, data may include "base" and "fail_on" (a severity that fails the task); add one at the end of a plan that changes code.
+For refactor tasks, data should include "operation" (rename, extract_function or move_package) and its parameters: "path", "symbol", "new_name" for rename; "path", "start_line", "end_line", "name" for extract_function; "from", "to" for move_package.
+For docs tasks, data should include "operation" (comments, readme or api) and "path"; readme also takes "section".
+For search tasks, data should include "question", or "symbol" (optionally qualified, e.g. "Server.Start") with "references": true to list its uses.
+For database tasks, data should include "operation" (schema, query, migrate, explain) and "sql" or a natural language "request".
+For http tasks, data should include "method", "url" and optional "headers" and "body"; to write a client or tests from the responses, add "operation": "generate", "kind" (client, test), "language" and "path".
+For security tasks, data may include "scanners" (gosec, npm-audit, pip-audit, gitleaks), "remediate" (true to propose fixes) and "fail_on" (low, medium, high, critical).
+For lint tasks, data may include "linters" (golangci-lint, eslint, ruff), "fix" (true to fix violations) and "fix_mode" (auto, native, llm).
+For migration tasks (version upgrades, library API changes across many files), data should include "request" and may include "files", "batch_size" and "dry_run".
+For release tasks, data may include "bump" (major, minor, patch), "version", "push" and "dry_run"; releases wait for approval.
+For benchmark tasks, data may include "operation" (run or baseline), "pattern", "target", "count" and "threshold" (regression percent).
+For kubernetes tasks, data should include "operation": generate (with "kind" manifests or helm, optional "image" and "request"), apply (optional "path") or diagnose (optional "pod" or "selector").
+For git tasks, data should include "operation" (status, diff, branch, commit, stash or log) and its parameters, e.g. "action" and "name" for branch, "message" or "all" for commit. Prefer git tasks over terminal tasks for git.
 
 Example Request: "create a new directory called 'server' and inside it, create a file named 'main.go' with a basic hello world program"
 Example Response:
@@ -89,7 +158,7 @@ Example Response:
       "content": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello world\")\n}"
     }
   }
-]`, SystemPrompt, request)
+]`, SystemPrompt, request, reference)
 
 	messages := []openai.ChatCompletionMessage{
 		{Role: openai.ChatMessageRoleSystem, Content: SystemPrompt},
@@ -124,6 +193,83 @@ func (p *PlanningAgentImpl) handleProjectCreation(ctx context.Context, descripti
 	if err := json.Unmarshal([]byte(planJSON), &plan); err != nil {
 		return nil, fmt.Errorf("failed to parse project plan JSON from LLM: %w. Raw response: %s", err, planJSON)
 	}
+	if err := p.generateProjectFiles(ctx, &plan); err != nil {
+		return nil, err
+	}
 
 	return &plan, nil
 }
+
+// projectFileConcurrency bounds how many files of a new project are
+// generated at once
+const projectFileConcurrency = 4
+
+// generateProjectFiles generates the files in the structure of plan that it
+// does not already include, projectFileConcurrency at a time, and adds them
+// to plan.Files in the order of the structure. The first failure cancels the
+// files still being generated.
+func (p *PlanningAgentImpl) generateProjectFiles(ctx context.Context, plan *ProjectPlan) error {
+	var paths []string
+	seen := make(map[string]bool, len(plan.Files))
+	for _, file := range plan.Files {
+		seen[file.Path] = true
+	}
+	for _, path := range plan.Structure.Files {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	outline := *plan
+	outline.Files = nil
+	outlineJSON, err := json.Marshal(outline)
+	if err != nil {
+		return fmt.Errorf("failed to encode project plan: %w", err)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		failed error
+	)
+	files := make([]ProjectFile, len(paths))
+	slots := make(chan struct{}, projectFileConcurrency)
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			requirements := fmt.Sprintf("Write the complete contents of the file %s of the project %q (%s), consistent with the other files of the project plan.", path, plan.Name, plan.Description)
+			code, err := p.llmClient.GenerateCode(ctx, requirements, "Project plan: "+string(outlineJSON))
+			if err != nil {
+				once.Do(func() {
+					failed = fmt.Errorf("failed to generate %s: %w", path, err)
+					cancel()
+				})
+				return
+			}
+			files[i] = ProjectFile{Path: path, Content: stripCodeFence(code)}
+		}(i, path)
+	}
+	wg.Wait()
+	if failed != nil {
+		return failed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	plan.Files = append(plan.Files, files...)
+	p.logger.Info("Generated project files", zap.Int("files", len(files)), zap.Duration("duration", time.Since(start)))
+	return nil
+}
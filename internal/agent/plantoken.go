@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPlanToken is returned for plan executions without a valid token for
+// the exact plan
+var ErrPlanToken = errors.New("invalid plan execution token")
+
+// ApprovalPlan is the kind of approvals for executing generated plans
+const ApprovalPlan = "plan"
+
+// defaultPlanTokenTTL is how long an execution token stays valid
+const defaultPlanTokenTTL = 15 * time.Minute
+
+// planTokenClaims are the signed contents of an execution token
+type planTokenClaims struct {
+	ApprovalID string `json:"approval_id"`
+	PlanSHA256 string `json:"plan_sha256"`
+	Expires    int64  `json:"exp"`
+}
+
+// PlanTokens signs and verifies plan execution tokens. A token binds the
+// approval of a plan to the SHA-256 of its exact text and expires after a
+// while, so a changed plan cannot run under an earlier approval.
+type PlanTokens struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewPlanTokens creates a signer using key, or a random key if it is
+// empty, in which case tokens do not survive a restart. A ttl of 0 uses the
+// default of 15 minutes.
+func NewPlanTokens(key string, ttl time.Duration) (*PlanTokens, error) {
+	secret := []byte(key)
+	if key == "" {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate plan signing key: %w", err)
+		}
+	}
+	if ttl <= 0 {
+		ttl = defaultPlanTokenTTL
+	}
+	return &PlanTokens{key: secret, ttl: ttl}, nil
+}
+
+// Issue returns a token allowing the plan with planHash to execute once
+// under the approval approvalID, and when it expires
+func (t *PlanTokens) Issue(approvalID, planHash string) (string, time.Time) {
+	expires := time.Now().Add(t.ttl)
+	payload, _ := json.Marshal(planTokenClaims{ApprovalID: approvalID, PlanSHA256: planHash, Expires: expires.Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + t.sign(encoded), expires
+}
+
+// Verify checks that token is signed, unexpired and issued for the plan
+// with planHash, and returns the approval it was issued under
+func (t *PlanTokens) Verify(token, planHash string) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return "", fmt.Errorf("%w: bad signature", ErrPlanToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed", ErrPlanToken)
+	}
+	var claims planTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("%w: malformed", ErrPlanToken)
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return "", fmt.Errorf("%w: expired", ErrPlanToken)
+	}
+	if !hmac.Equal([]byte(claims.PlanSHA256), []byte(planHash)) {
+		return "", fmt.Errorf("%w: the plan changed since it was approved", ErrPlanToken)
+	}
+	return claims.ApprovalID, nil
+}
+
+func (t *PlanTokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// plannedTask is one task of a generated plan
+type plannedTask struct {
+	Type        AgentType              `json:"type"`
+	Description string                 `json:"description"`
+	Data        map[string]interface{} `json:"data"`
+}
+
+// ParsePlan reads the tasks of a generated plan: a JSON array, possibly
+// surrounded by text or a code fence, of tasks with a type, description
+// and data. The tasks have no ID or workspace yet.
+func ParsePlan(plan string) ([]*Task, error) {
+	var planned []plannedTask
+	if err := json.Unmarshal([]byte(extractJSON(plan)), &planned); err != nil {
+		return nil, fmt.Errorf("invalid plan: %w", err)
+	}
+	now := time.Now()
+	tasks := make([]*Task, len(planned))
+	for i, p := range planned {
+		tasks[i] = &Task{Type: p.Type, Description: p.Description, Data: p.Data, Status: TaskPending, CreatedAt: now}
+	}
+	return tasks, nil
+}
+
+// requestPlanApproval asks for approval to execute a generated plan and
+// notes the approval in the planning result, along with the issue the plan
+// is linked to
+func (s *System) requestPlanApproval(ctx context.Context, task *Task, result *TaskResult) {
+	plan := result.Plan()
+	if plan == nil || plan.Plan == "" {
+		return
+	}
+	hash := contentHash(plan.Plan)
+	data := map[string]interface{}{"plan_sha256": hash}
+	if ticket := s.linkPlanTicket(ctx, task, plan); ticket != "" {
+		data["ticket"] = ticket
+	}
+	approval := s.approvals.Request(&Approval{
+		TaskID:     task.ID,
+		Kind:       ApprovalPlan,
+		Subject:    "Execute plan: " + truncateString(taskInstruction(task), 200),
+		Data:       data,
+		WorkingDir: stringField(task.Data, "workspace_dir"),
+	})
+	s.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "plan_sha256": hash},
+	})
+	plan.PlanSHA256 = hash
+	plan.ApprovalID = approval.ID
+}
+
+// DecideApproval approves or rejects a pending approval. Approving a plan,
+// or its continuation past a blast-radius limit, also returns the token its
+// execution needs, with its expiry.
+func (s *System) DecideApproval(id string, approve bool) (*Approval, string, time.Time, error) {
+	approval, err := s.approvals.Decide(id, approve)
+	if err != nil || (approval.Kind != ApprovalPlan && approval.Kind != ApprovalBlastRadius) || approval.Status != ApprovalApproved {
+		return approval, "", time.Time{}, err
+	}
+	hash, _ := approval.Data["plan_sha256"].(string)
+	token, expires := s.planTokens.Issue(approval.ID, hash)
+	return approval, token, expires, nil
+}
+
+// ExecutePlan executes the tasks of an approved plan in the workspace it
+// was approved for, one after another until one fails. token must have
+// been issued for the approval of this exact plan, and is used up. A
+// non-empty workspaceDir must be that workspace.
+//
+// The execution of a plan linked to an issue posts a summary on it.
+//
+// An execution reaching one of the blast-radius limits stops and returns a
+// *BlastRadiusError. The token issued for approving its continuation
+// executes the rest of the plan, from the task that reached the limit,
+// without limits.
+func (s *System) ExecutePlan(ctx context.Context, plan, token, workspaceDir string) ([]*TaskResult, error) {
+	hash := contentHash(plan)
+	approvalID, err := s.planTokens.Verify(token, hash)
+	if err != nil {
+		return nil, err
+	}
+	kind := ApprovalPlan
+	if pending, ok := s.approvals.Get(approvalID); ok {
+		if !s.OwnsTask(ctx, pending.TaskID) {
+			return nil, fmt.Errorf("%w: issued to another user", ErrPlanToken)
+		}
+		// The plan was approved for its workspace and runs nowhere else
+		if workspaceDir != "" && absPath(workspaceDir) != absPath(pending.WorkingDir) {
+			return nil, fmt.Errorf("%w: issued for workspace %s", ErrPlanToken, pending.WorkingDir)
+		}
+		if pending.Kind == ApprovalBlastRadius {
+			kind = ApprovalBlastRadius
+		}
+	}
+	approval, err := s.approvals.Consume(approvalID, kind)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPlanToken, err)
+	}
+	workspaceDir = approval.WorkingDir
+	limits, resumeFrom := s.blastRadius, 0
+	if kind == ApprovalBlastRadius {
+		limits = BlastRadiusLimits{}
+		resumeFrom, _ = approval.Data["resume_from"].(int)
+	}
+
+	tasks, err := ParsePlan(plan)
+	if err != nil {
+		return nil, err
+	}
+	// The plan's tasks are numbered after the request executing it
+	parentID := newTaskID(ctx)
+	for i, task := range tasks {
+		task.ID = fmt.Sprintf("%s.%d", parentID, i+1)
+		task.Data = withOptions(task.Data, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		})
+	}
+	if resumeFrom < 0 || resumeFrom >= len(tasks) {
+		return nil, fmt.Errorf("invalid plan: no task %d to resume from", resumeFrom+1)
+	}
+	tasks = tasks[resumeFrom:]
+
+	ticket, _ := approval.Data["ticket"].(string)
+	counter := newBlastRadius(limits)
+	started := time.Now()
+	results, err := s.ExecuteTaskChain(withBlastRadius(ctx, counter), tasks)
+	if reason, _ := counter.paused(); reason != "" {
+		return results, s.pauseAtBlastRadius(counter, tasks, hash, workspaceDir, ticket)
+	}
+	s.notifications.planFinished(parentID, workspaceDir, tasks, results, err, time.Since(started))
+	if ticket != "" && s.tickets != nil {
+		go s.postPlanSummary(context.WithoutCancel(ctx), ticket, parentID, tasks, results, err)
+	}
+	return results, err
+}
+
+// planSucceeded reports whether every task of a plan ran and succeeded,
+// given the results and error of their execution
+func planSucceeded(tasks []*Task, results []*TaskResult, err error) bool {
+	if err != nil || len(results) < len(tasks) {
+		return false
+	}
+	for _, result := range results {
+		if result == nil || !result.Success {
+			return false
+		}
+	}
+	return true
+}
+
+// planOutcome lists what happened to each task of a plan, given the
+// results and error of their execution
+func planOutcome(tasks []*Task, results []*TaskResult, err error) string {
+	var b strings.Builder
+	for i, task := range tasks {
+		switch {
+		case i == len(results) && err != nil:
+			fmt.Fprintf(&b, "- failed: %s (%s)\n", task.Description, truncateString(err.Error(), 500))
+		case i >= len(results) || results[i] == nil:
+			fmt.Fprintf(&b, "- not run: %s\n", task.Description)
+		case results[i].Success:
+			fmt.Fprintf(&b, "- done: %s\n", task.Description)
+		default:
+			fmt.Fprintf(&b, "- failed: %s", task.Description)
+			if results[i].Error != "" {
+				b.WriteString(" (" + truncateString(results[i].Error, 500) + ")")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,290 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxPluginMessageBytes bounds a single JSON-RPC message from a plugin
+const maxPluginMessageBytes = 16 << 20
+
+// AgentCapabilities describes an agent for discovery
+type AgentCapabilities struct {
+	Type        AgentType `json:"type"`
+	Description string    `json:"description"`
+	Operations  []string  `json:"operations,omitempty"`
+	// Plugin is the executable serving a plugin agent
+	Plugin string `json:"plugin,omitempty"`
+	// Enabled is false for agents disabled in the configuration
+	Enabled bool `json:"enabled"`
+}
+
+// DescribedAgent is implemented by agents that report their own capabilities
+type DescribedAgent interface {
+	Agent
+	Capabilities() AgentCapabilities
+}
+
+// builtinCapabilities describes the agents that ship with the system
+var builtinCapabilities = map[AgentType]AgentCapabilities{
+	PlanningAgent:     {Description: "Breaks natural language requests into tasks for other agents"},
+	FileAgent:         {Description: "Creates, reads, updates and deletes files", Operations: []string{"create", "read", "update", "delete", "head", "tail"}},
+	TerminalAgent:     {Description: "Runs shell commands, with risk checks and approvals"},
+	DebugAgent:        {Description: "Analyzes and explains errors and logs and proposes or applies fixes"},
+	GitAgent:          {Description: "Runs git operations", Operations: []string{"status", "diff", "branch", "commit", "stash", "log"}},
+	TestAgent:         {Description: "Detects, runs and generates tests", Operations: []string{"detect", "run", "generate"}},
+	CodeReviewAgent:   {Description: "Reviews diffs and returns structured comments"},
+	RefactorAgent:     {Description: "Performs structural refactorings", Operations: []string{"rename", "extract_function", "move_package"}},
+	DocsAgent:         {Description: "Writes doc comments, README sections and API docs", Operations: []string{"comments", "readme", "api"}},
+	SearchAgent:       {Description: "Answers questions about the codebase with citations and resolves symbol definitions and references"},
+	DatabaseAgent:     {Description: "Introspects databases, runs SQL and writes migrations", Operations: []string{"schema", "query", "migrate", "explain"}},
+	HTTPRequestAgent:  {Description: "Calls HTTP APIs and generates clients or tests from the responses", Operations: []string{"request", "generate"}},
+	SecurityScanAgent: {Description: "Runs security scanners and proposes remediations"},
+	LintAgent:         {Description: "Runs linters and fixes violations"},
+	KubernetesAgent:   {Description: "Generates manifests and Helm charts, deploys them and diagnoses failing pods", Operations: []string{"generate", "apply", "diagnose"}},
+	MigrationAgent:    {Description: "Migrates language versions and library APIs across many files in verified batches"},
+	ReleaseAgent:      {Description: "Derives changelogs and versions from git history and tags releases after approval"},
+	BenchmarkAgent:    {Description: "Runs Go benchmarks, compares them with a baseline and suggests optimizations", Operations: []string{"run", "baseline"}},
+}
+
+// PluginConfig describes an external agent executable
+type PluginConfig struct {
+	Command string
+	Args    []string
+	Env     map[string]string
+}
+
+// PluginAgent is an agent served by an external process. Plugins speak
+// newline-delimited JSON-RPC 2.0 on stdin/stdout:
+//
+//   - "describe" returns the plugin's AgentCapabilities
+//   - "execute" takes {"task": Task} and returns a TaskResult
+//   - the "cancel" notification {"id": <request id>} aborts an execute call
+//
+// Anything the plugin writes to stderr is logged.
+type PluginAgent struct {
+	config       PluginConfig
+	capabilities AgentCapabilities
+	logger       *zap.Logger
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	// exitErr is set once the plugin's stdout closes
+	exitErr error
+	done    chan struct{}
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     *int64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// LoadPlugin starts a plugin executable and asks it to describe itself.
+// The plugin gets the environment filtering and network restrictions of
+// executed commands in execConfig, since it acts on tasks just as they do;
+// variables it needs from the filtered environment go in cfg.Env.
+func LoadPlugin(ctx context.Context, cfg PluginConfig, execConfig ExecutorConfig, logger *zap.Logger) (*PluginAgent, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = newEnvFilter(execConfig.EnvAllowlist, execConfig.EnvDenylist).environ(execConfig.Egress.env(cfg.Env))
+	if err := execConfig.Egress.apply(cmd); err != nil {
+		return nil, err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", cfg.Command, err)
+	}
+
+	p := &PluginAgent{
+		config:  cfg,
+		logger:  logger.With(zap.String("plugin", cfg.Command)),
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan rpcResponse),
+		done:    make(chan struct{}),
+	}
+	go p.readResponses(stdout)
+	go p.logStderr(stderr)
+
+	describeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := p.call(describeCtx, "describe", nil, &p.capabilities); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s failed to describe itself: %w", cfg.Command, err)
+	}
+	if p.capabilities.Type == "" {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s did not report an agent type", cfg.Command)
+	}
+	p.capabilities.Plugin = cfg.Command
+	return p, nil
+}
+
+// Type returns the agent type reported by the plugin
+func (p *PluginAgent) Type() AgentType {
+	return p.capabilities.Type
+}
+
+// Capabilities returns what the plugin reported about itself
+func (p *PluginAgent) Capabilities() AgentCapabilities {
+	return p.capabilities
+}
+
+// Execute forwards a task to the plugin
+func (p *PluginAgent) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	p.logger.Info("Plugin agent executing task", zap.String("task_id", task.ID))
+
+	var result TaskResult
+	if err := p.call(ctx, "execute", map[string]interface{}{"task": task}, &result); err != nil {
+		return nil, err
+	}
+	if result.Data == nil {
+		result.Data = Fields{}
+	}
+	return &result, nil
+}
+
+// Close stops the plugin process, killing it if it doesn't exit promptly
+func (p *PluginAgent) Close() {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	p.cmd.Wait()
+}
+
+// call sends a request and waits for its response
+func (p *PluginAgent) call(ctx context.Context, method string, params, result interface{}) error {
+	p.mu.Lock()
+	if p.exitErr != nil {
+		p.mu.Unlock()
+		return p.exitErr
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan rpcResponse, 1)
+	p.pending[id] = ch
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	if err := p.send(rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		p.send(rpcRequest{JSONRPC: "2.0", Method: "cancel", Params: map[string]int64{"id": id}})
+		return ctx.Err()
+	case resp, ok := <-ch:
+		if !ok {
+			return p.exitErr
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("plugin error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("invalid %s result from plugin: %w", method, err)
+		}
+		return nil
+	}
+}
+
+// send writes one request line
+func (p *PluginAgent) send(req rpcRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to plugin: %w", err)
+	}
+	return nil
+}
+
+// readResponses delivers responses to waiting calls until stdout closes,
+// then fails every call still pending
+func (p *PluginAgent) readResponses(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxPluginMessageBytes)
+	for scanner.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || resp.ID == nil {
+			p.logger.Warn("Ignoring malformed plugin message", zap.ByteString("line", scanner.Bytes()))
+			continue
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[*resp.ID]
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	p.mu.Lock()
+	p.exitErr = fmt.Errorf("plugin %s exited", p.config.Command)
+	if err := scanner.Err(); err != nil {
+		p.exitErr = fmt.Errorf("plugin %s: %w", p.config.Command, err)
+	}
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+	close(p.done)
+}
+
+func (p *PluginAgent) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		p.logger.Info("Plugin output", zap.String("line", scanner.Text()))
+	}
+}
@@ -0,0 +1,323 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrPolicyDenied is returned for actions the policy forbids
+var ErrPolicyDenied = errors.New("denied by policy")
+
+// policyTimeout bounds one policy evaluation
+const policyTimeout = 10 * time.Second
+
+// defaultPolicyQuery is the rule listing the reasons to deny an action
+const defaultPolicyQuery = "data.spilot.deny"
+
+// Policy actions
+const (
+	PolicyFileWrite = "file_write"
+	PolicyCommand   = "command"
+)
+
+// PolicyInput describes an action for the policy to decide on
+type PolicyInput struct {
+	// Action is PolicyFileWrite or PolicyCommand
+	Action string `json:"action"`
+	// Operation is create, update or delete for file writes
+	Operation  string `json:"operation,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Command    string `json:"command,omitempty"`
+	WorkingDir string `json:"working_dir,omitempty"`
+	Agent      string `json:"agent,omitempty"`
+	User       string `json:"user,omitempty"`
+	Workspace  string `json:"workspace,omitempty"`
+	TaskID     string `json:"task_id,omitempty"`
+}
+
+// PolicyConfig selects the policy engine: an OPA server at URL, or the
+// Rego files in Dir evaluated with the opa binary. Query is the rule
+// listing reasons to deny, default data.spilot.deny. Unless FailOpen is
+// set, actions are denied when the policy cannot be evaluated.
+type PolicyConfig struct {
+	URL      string
+	Dir      string
+	Query    string
+	FailOpen bool
+}
+
+// policyEngine evaluates the deny rule for an input
+type policyEngine interface {
+	deny(ctx context.Context, input PolicyInput) ([]string, error)
+}
+
+// PolicyGuard checks actions against the organization's policy before the
+// agent takes them. A nil PolicyGuard allows everything.
+type PolicyGuard struct {
+	engine   policyEngine
+	failOpen bool
+	logger   *zap.Logger
+}
+
+// NewPolicyGuard creates a guard for cfg, or returns nil if no policy is
+// configured
+func NewPolicyGuard(cfg PolicyConfig, logger *zap.Logger) (*PolicyGuard, error) {
+	query := cfg.Query
+	if query == "" {
+		query = defaultPolicyQuery
+	}
+	var engine policyEngine
+	switch {
+	case cfg.URL != "":
+		path := strings.ReplaceAll(strings.TrimPrefix(query, "data."), ".", "/")
+		engine = &opaServer{url: strings.TrimSuffix(cfg.URL, "/") + "/v1/data/" + path, client: &http.Client{Timeout: policyTimeout}}
+	case cfg.Dir != "":
+		if !onPath("opa") {
+			return nil, fmt.Errorf("policy.dir needs the opa binary on PATH")
+		}
+		engine = &opaEval{dir: cfg.Dir, query: query}
+	default:
+		return nil, nil
+	}
+	return &PolicyGuard{engine: engine, failOpen: cfg.FailOpen, logger: logger}, nil
+}
+
+// Check returns an error wrapping ErrPolicyDenied if the policy forbids
+// the action. The agent, user, workspace and task come from ctx.
+func (g *PolicyGuard) Check(ctx context.Context, input PolicyInput) error {
+	if g == nil {
+		return nil
+	}
+	input.Agent = string(agentTypeFrom(ctx))
+	input.User = RequesterFrom(ctx)
+	input.Workspace = workspaceFrom(ctx)
+	input.TaskID = commandOriginFrom(ctx).TaskID
+
+	evalCtx, cancel := context.WithTimeout(ctx, policyTimeout)
+	defer cancel()
+	reasons, err := g.engine.deny(evalCtx, input)
+	if err != nil {
+		if g.failOpen {
+			g.logger.Warn("Policy evaluation failed; allowing the action", zap.String("action", input.Action), zap.Error(err))
+			return nil
+		}
+		return fmt.Errorf("%w: policy evaluation failed: %v", ErrPolicyDenied, err)
+	}
+	if len(reasons) > 0 {
+		g.logger.Info("Action denied by policy",
+			zap.String("action", input.Action),
+			zap.String("path", input.Path),
+			zap.String("command", input.Command),
+			zap.Strings("reasons", reasons))
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// opaServer asks an OPA server through its data API
+type opaServer struct {
+	url    string
+	client *http.Client
+}
+
+func (o *opaServer) deny(ctx context.Context, input PolicyInput) ([]string, error) {
+	payload, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OPA request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("OPA returned %s: %s", resp.Status, truncateString(string(data), 500))
+	}
+	var result struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid OPA response: %w", err)
+	}
+	return denyReasons(result.Result)
+}
+
+// opaEval evaluates local Rego files with the opa binary
+type opaEval struct {
+	dir   string
+	query string
+}
+
+func (o *opaEval) deny(ctx context.Context, input PolicyInput) ([]string, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	// opa is part of the server's own checks, not a tool of the agents, so
+	// it runs without the executor's restrictions
+	cmd := exec.CommandContext(ctx, "opa", "eval", "--format", "json", "--stdin-input", "--data", o.dir, o.query)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("opa eval failed: %v: %s", err, truncateString(strings.TrimSpace(stderr.String()), 500))
+	}
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("invalid opa eval output: %w", err)
+	}
+	// An undefined rule has no result, which denies nothing
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return denyReasons(result.Result[0].Expressions[0].Value)
+}
+
+// denyReasons reads the value of the deny rule: a set of messages, or a
+// boolean for policies written as a single deny rule
+func denyReasons(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if v {
+			return []string{"denied"}, nil
+		}
+		return nil, nil
+	case []interface{}:
+		reasons := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				reasons = append(reasons, s)
+			} else {
+				data, _ := json.Marshal(item)
+				reasons = append(reasons, string(data))
+			}
+		}
+		return reasons, nil
+	}
+	return nil, fmt.Errorf("deny rule must be a set of messages or a boolean, got %T", value)
+}
+
+type agentTypeKey struct{}
+
+// withAgentType records the agent acting under ctx
+func withAgentType(ctx context.Context, agentType AgentType) context.Context {
+	return context.WithValue(ctx, agentTypeKey{}, agentType)
+}
+
+func agentTypeFrom(ctx context.Context) AgentType {
+	agentType, _ := ctx.Value(agentTypeKey{}).(AgentType)
+	return agentType
+}
+
+// PolicyExecutor checks every command against the policy before the
+// wrapped executor runs it
+type PolicyExecutor struct {
+	CommandExecutor
+	policy *PolicyGuard
+}
+
+// NewPolicyExecutor wraps an executor with policy checks
+func NewPolicyExecutor(exec CommandExecutor, policy *PolicyGuard) *PolicyExecutor {
+	return &PolicyExecutor{CommandExecutor: exec, policy: policy}
+}
+
+// ExecuteCommand checks and executes a single command
+func (p *PolicyExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return p.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream checks and executes a single command, streaming its output
+func (p *PolicyExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	if err := p.policy.Check(ctx, PolicyInput{Action: PolicyCommand, Command: command, WorkingDir: absPath(workingDir)}); err != nil {
+		return nil, err
+	}
+	return p.CommandExecutor.ExecuteCommandStream(ctx, command, workingDir, opts, onStdout, onStderr)
+}
+
+// ExecuteCommands checks and executes multiple commands
+func (p *PolicyExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	var results []*Command
+
+	for _, command := range commands {
+		result, err := p.ExecuteCommand(ctx, command, workingDir, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if result.Status != "completed" {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// policyFileManager checks every file write against the policy before the
+// wrapped manager makes it
+type policyFileManager struct {
+	FileManager
+	policy *PolicyGuard
+	ctx    context.Context
+}
+
+func (f *policyFileManager) withContext(ctx context.Context) FileManager {
+	return &policyFileManager{FileManager: fileManagerFor(ctx, f.FileManager), policy: f.policy, ctx: ctx}
+}
+
+func (f *policyFileManager) check(path, operation string) error {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return f.policy.Check(ctx, PolicyInput{Action: PolicyFileWrite, Operation: operation, Path: absPath(path)})
+}
+
+func (f *policyFileManager) CreateFile(path, content string) error {
+	if err := f.check(path, "create"); err != nil {
+		return err
+	}
+	return f.FileManager.CreateFile(path, content)
+}
+
+func (f *policyFileManager) UpdateFile(path, content string) error {
+	if err := f.check(path, "update"); err != nil {
+		return err
+	}
+	return f.FileManager.UpdateFile(path, content)
+}
+
+func (f *policyFileManager) DeleteFile(path string) error {
+	if err := f.check(path, "delete"); err != nil {
+		return err
+	}
+	return f.FileManager.DeleteFile(path)
+}
@@ -0,0 +1,372 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ProcessStatus is the state of a managed background process
+type ProcessStatus string
+
+const (
+	ProcessRunning ProcessStatus = "running"
+	ProcessExited  ProcessStatus = "exited"
+	ProcessStopped ProcessStatus = "stopped"
+)
+
+// defaultProcessLogLines is the number of log lines kept per process
+const defaultProcessLogLines = 1000
+
+// longRunningPatterns match commands that start servers or watchers and
+// never exit on their own
+var longRunningPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(npm|pnpm|yarn|bun)\s+(run\s+)?(dev|start|serve|watch)\b`),
+	// Only servers: generators and scripts run with go run exit on their own
+	regexp.MustCompile(`\bgo\s+run\b.*\bserver?\b`),
+	regexp.MustCompile(`\b(flask\s+run|uvicorn|gunicorn|manage\.py\s+runserver|python3?\s+-m\s+http\.server)\b`),
+	regexp.MustCompile(`\b(rails\s+s(erver)?|next\s+dev|nodemon)\b`),
+	// vite and air when run as commands with nothing but flags, unlike
+	// vite build or an argument that happens to be "air"
+	regexp.MustCompile(`(^|[;&|]\s*)((npx\s+)?vite(\s+(dev|serve|preview))?|air)(\s+-[^;&|]*)?\s*($|[;&|])`),
+}
+
+// isLongRunning reports whether a command looks like it never exits on its own
+func isLongRunning(command string) bool {
+	for _, pattern := range longRunningPatterns {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagedProcess is a background process started by the agent
+type ManagedProcess struct {
+	ID         string        `json:"id"`
+	Command    string        `json:"command"`
+	WorkingDir string        `json:"working_dir"`
+	Requester  string        `json:"requester,omitempty"`
+	PID        int           `json:"pid"`
+	Status     ProcessStatus `json:"status"`
+	ExitCode   int           `json:"exit_code"`
+	StartedAt  time.Time     `json:"started_at"`
+	ExitedAt   time.Time     `json:"exited_at,omitempty"`
+
+	cmd  *exec.Cmd
+	logs *rollingLog
+	done chan struct{}
+	// container names the sandbox container the process runs in, if any
+	container string
+}
+
+// ProcessManager starts, tracks and stops background processes
+type ProcessManager struct {
+	mu        sync.Mutex
+	processes map[string]*ManagedProcess
+	envFilter *envFilter
+	limits    ResourceLimits
+	egress    *Egress
+	logLines  int
+	audit     CommandAuditLog
+	policy    *PolicyGuard
+	// sandbox runs the processes in containers when commands are sandboxed
+	sandbox *SandboxExecutor
+	// confined refuses to start processes, which can reach any workspace,
+	// while users are isolated from each other
+	confined bool
+	logger   *zap.Logger
+}
+
+// NewProcessManager creates a process manager using the executor's
+// environment filtering and resource limits. With a sandbox, processes run
+// in its containers rather than on the host. Processes are recorded in
+// audit when they exit and checked against policy before they start;
+// sandbox, audit and policy may be nil. No processes start under a tenancy.
+func NewProcessManager(cfg ExecutorConfig, sandbox *SandboxExecutor, audit CommandAuditLog, policy *PolicyGuard, tenancy *Tenancy, logger *zap.Logger) *ProcessManager {
+	return &ProcessManager{
+		processes: make(map[string]*ManagedProcess),
+		envFilter: newEnvFilter(cfg.EnvAllowlist, cfg.EnvDenylist),
+		limits:    cfg.Limits,
+		egress:    cfg.Egress,
+		logLines:  defaultProcessLogLines,
+		audit:     audit,
+		policy:    policy,
+		sandbox:   sandbox,
+		confined:  tenancy != nil,
+		logger:    logger,
+	}
+}
+
+// Start launches command in the background and returns immediately
+func (m *ProcessManager) Start(ctx context.Context, command, workingDir string, shell Shell, env map[string]string) (*ManagedProcess, error) {
+	if m.confined {
+		return nil, fmt.Errorf("%w: background processes are unavailable while users are isolated", ErrOutsideTenant)
+	}
+	if err := RequireScope(ctx, ScopeTerminal); err != nil {
+		return nil, err
+	}
+	if err := blastRadiusFrom(ctx).command(ctx, 1); err != nil {
+		return nil, err
+	}
+	if err := m.policy.Check(ctx, PolicyInput{Action: PolicyCommand, Command: command, WorkingDir: absPath(workingDir)}); err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("proc_%d", time.Now().UnixNano())
+	var cmd *exec.Cmd
+	var container string
+	if m.sandbox != nil {
+		container = "spilot-" + id
+		var err error
+		if cmd, err = m.sandbox.containerCommand(container, workingDir, env, command, false); err != nil {
+			return nil, err
+		}
+	} else {
+		// CPU time limits make no sense for servers, so only the process
+		// and memory limits apply
+		limits := m.limits
+		limits.CPUSeconds = 0
+
+		cmd = shell.Command(context.Background(), limits.shellPrefix(shell)+command)
+		cmd.Dir = workingDir
+		cmd.Env = m.envFilter.environ(m.egress.env(env))
+		if err := m.egress.apply(cmd); err != nil {
+			return nil, err
+		}
+	}
+	setProcessGroup(cmd)
+
+	logs := newRollingLog(m.logLines)
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+
+	proc := &ManagedProcess{
+		ID:         id,
+		Command:    command,
+		WorkingDir: workingDir,
+		Requester:  RequesterFrom(ctx),
+		PID:        cmd.Process.Pid,
+		Status:     ProcessRunning,
+		StartedAt:  time.Now(),
+		cmd:        cmd,
+		container:  container,
+		logs:       logs,
+		done:       make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.processes[proc.ID] = proc
+	m.mu.Unlock()
+
+	origin := commandOriginFrom(ctx)
+	requester := RequesterFrom(ctx)
+	go func() {
+		m.wait(proc)
+		m.record(proc, origin, requester)
+	}()
+
+	m.logger.Info("Started background process", zap.String("process_id", proc.ID), zap.String("command", command), zap.Int("pid", proc.PID))
+	return proc.snapshot(), nil
+}
+
+// wait records the exit of a process
+func (m *ProcessManager) wait(proc *ManagedProcess) {
+	err := proc.cmd.Wait()
+
+	m.mu.Lock()
+	if proc.Status == ProcessRunning {
+		proc.Status = ProcessExited
+	}
+	proc.ExitCode = exitCode(proc.cmd, err)
+	proc.ExitedAt = time.Now()
+	m.mu.Unlock()
+
+	close(proc.done)
+}
+
+// record writes the exited process to the audit log
+func (m *ProcessManager) record(proc *ManagedProcess, origin commandOrigin, requester string) {
+	if m.audit == nil {
+		return
+	}
+
+	m.mu.Lock()
+	snapshot := proc.snapshot()
+	m.mu.Unlock()
+
+	entry := &CommandAuditEntry{
+		ID:          snapshot.ID,
+		TaskID:      origin.TaskID,
+		Requester:   requester,
+		Instruction: origin.Instruction,
+		Command:     snapshot.Command,
+		WorkingDir:  snapshot.WorkingDir,
+		Status:      string(snapshot.Status),
+		ExitCode:    snapshot.ExitCode,
+		Duration:    snapshot.ExitedAt.Sub(snapshot.StartedAt),
+		Output:      truncateString(strings.Join(proc.logs.Tail(50), "\n"), auditOutputBytes),
+		Timestamp:   snapshot.StartedAt,
+	}
+	if err := m.audit.Record(entry); err != nil {
+		m.logger.Error("Failed to record background process in audit log", zap.Error(err))
+	}
+}
+
+// List returns all managed processes, newest first
+func (m *ProcessManager) List() []*ManagedProcess {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*ManagedProcess, 0, len(m.processes))
+	for _, proc := range m.processes {
+		list = append(list, proc.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list
+}
+
+// Get returns a managed process by ID
+func (m *ProcessManager) Get(id string) (*ManagedProcess, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	proc, ok := m.processes[id]
+	if !ok {
+		return nil, false
+	}
+	return proc.snapshot(), true
+}
+
+// Logs returns the last n captured log lines of a process (all if n <= 0)
+func (m *ProcessManager) Logs(id string, n int) ([]string, error) {
+	m.mu.Lock()
+	proc, ok := m.processes[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("process %s not found", id)
+	}
+	return proc.logs.Tail(n), nil
+}
+
+// Stop kills a process and its children
+func (m *ProcessManager) Stop(id string) error {
+	m.mu.Lock()
+	proc, ok := m.processes[id]
+	if ok && proc.Status == ProcessRunning {
+		proc.Status = ProcessStopped
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("process %s not found", id)
+	}
+
+	select {
+	case <-proc.done:
+		return nil
+	default:
+	}
+
+	if err := killProcessGroup(proc.cmd); err != nil {
+		return fmt.Errorf("failed to stop process %s: %w", id, err)
+	}
+	if proc.container != "" {
+		m.sandbox.removeContainer(proc.container)
+	}
+
+	select {
+	case <-proc.done:
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("process %s did not exit after kill", id)
+	}
+	return nil
+}
+
+// StopAll stops every running process, used on shutdown
+func (m *ProcessManager) StopAll() {
+	for _, proc := range m.List() {
+		if proc.Status != ProcessRunning {
+			continue
+		}
+		if err := m.Stop(proc.ID); err != nil {
+			m.logger.Warn("Failed to stop background process", zap.String("process_id", proc.ID), zap.Error(err))
+		}
+	}
+}
+
+// snapshot copies the exported fields; callers must hold the manager lock
+func (p *ManagedProcess) snapshot() *ManagedProcess {
+	return &ManagedProcess{
+		ID:         p.ID,
+		Command:    p.Command,
+		WorkingDir: p.WorkingDir,
+		PID:        p.PID,
+		Status:     p.Status,
+		ExitCode:   p.ExitCode,
+		StartedAt:  p.StartedAt,
+		ExitedAt:   p.ExitedAt,
+		Requester:  p.Requester,
+	}
+}
+
+// rollingLog keeps the last max lines written to it
+type rollingLog struct {
+	mu      sync.Mutex
+	lines   []string
+	max     int
+	partial strings.Builder
+}
+
+func newRollingLog(max int) *rollingLog {
+	return &rollingLog{max: max}
+}
+
+func (l *rollingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial.Write(p)
+	text := l.partial.String()
+	l.partial.Reset()
+
+	for {
+		line, rest, found := strings.Cut(text, "\n")
+		if !found {
+			l.partial.WriteString(line)
+			break
+		}
+		l.lines = append(l.lines, line)
+		text = rest
+	}
+	if over := len(l.lines) - l.max; over > 0 {
+		l.lines = append([]string(nil), l.lines[over:]...)
+	}
+	return len(p), nil
+}
+
+// Tail returns the last n lines, including an unterminated final line
+func (l *rollingLog) Tail(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := l.lines
+	if l.partial.Len() > 0 {
+		lines = append(append([]string(nil), lines...), l.partial.String())
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]string(nil), lines...)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package agent
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op on Windows; child processes are killed with taskkill /T
+func setProcessGroup(_ *exec.Cmd) {}
+
+// killProcessGroup kills the command and its child process tree
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
@@ -0,0 +1,389 @@
+package agent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ProjectProfile describes how a workspace is built and tested, so prompts
+// suggest commands and code that fit the project
+type ProjectProfile struct {
+	Root string `json:"root"`
+	// Languages are ordered by number of source files
+	Languages    []string  `json:"languages"`
+	BuildSystem  string    `json:"build_system,omitempty"`
+	BuildCommand string    `json:"build_command,omitempty"`
+	TestCommand  string    `json:"test_command,omitempty"`
+	EntryPoints  []string  `json:"entry_points,omitempty"`
+	Frameworks   []string  `json:"frameworks,omitempty"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// profileManifests are the files whose changes invalidate a profile
+var profileManifests = []string{
+	"go.mod", "package.json", "Cargo.toml", "pyproject.toml", "requirements.txt", "setup.py",
+	"pom.xml", "build.gradle", "build.gradle.kts", "Makefile",
+}
+
+var extensionLanguages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".jsx": "JavaScript", ".mjs": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".rs": "Rust", ".java": "Java", ".kt": "Kotlin",
+	".rb": "Ruby", ".php": "PHP", ".cs": "C#", ".c": "C", ".h": "C", ".cpp": "C++", ".hpp": "C++",
+	".swift": "Swift", ".scala": "Scala",
+}
+
+// frameworkMarkers maps dependency names found in manifests to frameworks
+var frameworkMarkers = map[string][]struct{ marker, name string }{
+	"go.mod": {
+		{"github.com/gin-gonic/gin", "Gin"}, {"github.com/labstack/echo", "Echo"},
+		{"github.com/gorilla/mux", "gorilla/mux"}, {"github.com/go-chi/chi", "chi"},
+		{"github.com/gofiber/fiber", "Fiber"}, {"google.golang.org/grpc", "gRPC"},
+		{"github.com/spf13/cobra", "Cobra"},
+	},
+	"package.json": {
+		{`"next"`, "Next.js"}, {`"react"`, "React"}, {`"vue"`, "Vue"}, {`"svelte"`, "Svelte"},
+		{`"@angular/core"`, "Angular"}, {`"express"`, "Express"}, {`"@nestjs/core"`, "NestJS"},
+		{`"fastify"`, "Fastify"},
+	},
+	"requirements.txt": {{"django", "Django"}, {"flask", "Flask"}, {"fastapi", "FastAPI"}},
+	"pyproject.toml":   {{"django", "Django"}, {"flask", "Flask"}, {"fastapi", "FastAPI"}},
+	"Cargo.toml":       {{"actix-web", "Actix Web"}, {"axum", "Axum"}, {"rocket", "Rocket"}, {"tokio", "Tokio"}},
+	"pom.xml":          {{"spring-boot", "Spring Boot"}},
+	"build.gradle":     {{"spring-boot", "Spring Boot"}},
+}
+
+// DetectProjectProfile inspects the manifests and sources of a workspace
+func DetectProjectProfile(dir string) (*ProjectProfile, error) {
+	root := absPath(dir)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	profile := &ProjectProfile{Root: root, DetectedAt: time.Now()}
+	profile.Languages, profile.EntryPoints = scanSources(root)
+	detectBuildSystem(root, profile)
+
+	seen := make(map[string]bool)
+	for manifest, markers := range frameworkMarkers {
+		data, err := os.ReadFile(filepath.Join(root, manifest))
+		if err != nil {
+			continue
+		}
+		content := strings.ToLower(string(data))
+		for _, m := range markers {
+			if strings.Contains(content, strings.ToLower(m.marker)) && !seen[m.name] {
+				seen[m.name] = true
+				profile.Frameworks = append(profile.Frameworks, m.name)
+			}
+		}
+	}
+	sort.Strings(profile.Frameworks)
+	return profile, nil
+}
+
+// scanSources counts source files per language and finds entry points
+func scanSources(root string) ([]string, []string) {
+	counts := make(map[string]int)
+	var entryPoints []string
+	files := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (skipDir(d.Name()) || strings.HasPrefix(d.Name(), ".") || d.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Large trees are sampled; the first files are enough to rank languages
+		if files++; files > 20000 {
+			return filepath.SkipAll
+		}
+		if language, ok := extensionLanguages[strings.ToLower(filepath.Ext(path))]; ok {
+			counts[language]++
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if isEntryPoint(path, rel) {
+			entryPoints = append(entryPoints, rel)
+		}
+		return nil
+	})
+
+	languages := make([]string, 0, len(counts))
+	for language := range counts {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if counts[languages[i]] != counts[languages[j]] {
+			return counts[languages[i]] > counts[languages[j]]
+		}
+		return languages[i] < languages[j]
+	})
+	sort.Strings(entryPoints)
+	if len(entryPoints) > 20 {
+		entryPoints = entryPoints[:20]
+	}
+	return languages, entryPoints
+}
+
+// isEntryPoint reports files that start a program
+func isEntryPoint(path, rel string) bool {
+	name := filepath.Base(rel)
+	switch {
+	case strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go"):
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Contains(string(data), "package main\n") && strings.Contains(string(data), "\nfunc main()")
+	case rel == "src/main.rs" || strings.HasPrefix(rel, "src/bin/") && strings.HasSuffix(name, ".rs"):
+		return true
+	case name == "__main__.py" || rel == "manage.py" || rel == "main.py" || rel == "app.py":
+		return true
+	}
+	return false
+}
+
+// detectBuildSystem fills the build system and the build and test commands
+// from the workspace manifests
+func detectBuildSystem(root string, profile *ProjectProfile) {
+	switch {
+	case fileExists(root, "go.mod"):
+		profile.BuildSystem = "Go modules"
+		profile.BuildCommand = "go build ./..."
+		profile.TestCommand = "go test ./..."
+	case fileExists(root, "Cargo.toml"):
+		profile.BuildSystem = "Cargo"
+		profile.BuildCommand = "cargo build"
+		profile.TestCommand = "cargo test"
+	case fileExists(root, "package.json"):
+		manager := "npm"
+		switch {
+		case fileExists(root, "pnpm-lock.yaml"):
+			manager = "pnpm"
+		case fileExists(root, "yarn.lock"):
+			manager = "yarn"
+		case fileExists(root, "bun.lockb"):
+			manager = "bun"
+		}
+		profile.BuildSystem = manager
+		var pkg struct {
+			Main    string            `json:"main"`
+			Scripts map[string]string `json:"scripts"`
+		}
+		if data, err := os.ReadFile(filepath.Join(root, "package.json")); err == nil {
+			json.Unmarshal(data, &pkg)
+		}
+		if pkg.Scripts["build"] != "" {
+			profile.BuildCommand = manager + " run build"
+		}
+		if pkg.Scripts["test"] != "" {
+			profile.TestCommand = manager + " test"
+		}
+		if pkg.Main != "" {
+			profile.EntryPoints = append(profile.EntryPoints, filepath.ToSlash(filepath.Clean(pkg.Main)))
+		}
+	case fileExists(root, "pyproject.toml"), fileExists(root, "requirements.txt"), fileExists(root, "setup.py"):
+		profile.BuildSystem = "pip"
+		if data, err := os.ReadFile(filepath.Join(root, "pyproject.toml")); err == nil {
+			switch content := string(data); {
+			case strings.Contains(content, "[tool.poetry]"):
+				profile.BuildSystem = "Poetry"
+			case strings.Contains(content, "[tool.uv]"):
+				profile.BuildSystem = "uv"
+			}
+		}
+		profile.TestCommand = "pytest"
+	case fileExists(root, "pom.xml"):
+		profile.BuildSystem = "Maven"
+		profile.BuildCommand = "mvn package"
+		profile.TestCommand = "mvn test"
+	case fileExists(root, "build.gradle"), fileExists(root, "build.gradle.kts"):
+		profile.BuildSystem = "Gradle"
+		gradle := "gradle"
+		if fileExists(root, "gradlew") {
+			gradle = "./gradlew"
+		}
+		profile.BuildCommand = gradle + " build"
+		profile.TestCommand = gradle + " test"
+	}
+
+	// A Makefile's targets take precedence: projects with one usually
+	// expect them to be used
+	if data, err := os.ReadFile(filepath.Join(root, "Makefile")); err == nil {
+		if profile.BuildSystem == "" {
+			profile.BuildSystem = "Make"
+		} else {
+			profile.BuildSystem += " with Make"
+		}
+		content := "\n" + string(data)
+		if strings.Contains(content, "\nbuild:") {
+			profile.BuildCommand = "make build"
+		}
+		if strings.Contains(content, "\ntest:") {
+			profile.TestCommand = "make test"
+		}
+	}
+}
+
+// Prompt describes the profile for inclusion in LLM prompts
+func (p *ProjectProfile) Prompt() string {
+	if p == nil {
+		return ""
+	}
+	var lines []string
+	if len(p.Languages) > 0 {
+		lines = append(lines, "Languages: "+strings.Join(p.Languages, ", "))
+	}
+	if p.BuildSystem != "" {
+		lines = append(lines, "Build system: "+p.BuildSystem)
+	}
+	if p.BuildCommand != "" {
+		lines = append(lines, "Build command: "+p.BuildCommand)
+	}
+	if p.TestCommand != "" {
+		lines = append(lines, "Test command: "+p.TestCommand)
+	}
+	if len(p.Frameworks) > 0 {
+		lines = append(lines, "Frameworks: "+strings.Join(p.Frameworks, ", "))
+	}
+	if len(p.EntryPoints) > 0 {
+		lines = append(lines, "Entry points: "+strings.Join(p.EntryPoints, ", "))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Project profile (use these tools and commands rather than ones for other ecosystems):\n" + strings.Join(lines, "\n")
+}
+
+// ProfileStore detects project profiles when a workspace is first used and
+// keeps them in DataDir/profiles, re-detecting when a manifest changes
+type ProfileStore struct {
+	mu       sync.Mutex
+	dir      string
+	profiles map[string]*ProjectProfile
+	logger   *zap.Logger
+}
+
+// NewProfileStore creates a store persisting profiles under dataDir; an
+// empty dataDir keeps them in memory only
+func NewProfileStore(dataDir string, logger *zap.Logger) *ProfileStore {
+	dir := ""
+	if dataDir != "" {
+		dir = filepath.Join(dataDir, "profiles")
+	}
+	return &ProfileStore{dir: dir, profiles: make(map[string]*ProjectProfile), logger: logger}
+}
+
+// Get returns the profile of a workspace, detecting it if it is unknown or
+// stale
+func (s *ProfileStore) Get(workspaceDir string) (*ProjectProfile, error) {
+	root := absPath(workspaceDir)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile, ok := s.profiles[root]
+	if !ok {
+		profile = s.load(root)
+	}
+	if profile != nil && !manifestsChangedSince(root, profile.DetectedAt) {
+		s.profiles[root] = profile
+		return profile, nil
+	}
+	return s.detect(root)
+}
+
+// Refresh re-detects the profile of a workspace
+func (s *ProfileStore) Refresh(workspaceDir string) (*ProjectProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.detect(absPath(workspaceDir))
+}
+
+// Prompt returns the profile prompt for a workspace, or "" if detection fails
+func (s *ProfileStore) Prompt(workspaceDir string) string {
+	if s == nil {
+		return ""
+	}
+	profile, err := s.Get(workspaceDir)
+	if err != nil {
+		s.logger.Debug("Failed to detect project profile", zap.String("workspace_dir", workspaceDir), zap.Error(err))
+		return ""
+	}
+	return profile.Prompt()
+}
+
+func (s *ProfileStore) detect(root string) (*ProjectProfile, error) {
+	profile, err := DetectProjectProfile(root)
+	if err != nil {
+		return nil, err
+	}
+	s.profiles[root] = profile
+	if err := s.save(profile); err != nil {
+		s.logger.Warn("Failed to store project profile", zap.String("root", root), zap.Error(err))
+	}
+	s.logger.Info("Detected project profile", zap.String("root", root), zap.Strings("languages", profile.Languages), zap.String("build_system", profile.BuildSystem))
+	return profile, nil
+}
+
+func (s *ProfileStore) path(root string) string {
+	sum := sha1.Sum([]byte(root))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+func (s *ProfileStore) load(root string) *ProjectProfile {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path(root))
+	if err != nil {
+		return nil
+	}
+	var profile ProjectProfile
+	if json.Unmarshal(data, &profile) != nil || profile.Root != root {
+		return nil
+	}
+	return &profile
+}
+
+func (s *ProfileStore) save(profile *ProjectProfile) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(profile.Root), data, 0644)
+}
+
+// manifestsChangedSince reports whether a manifest was created, changed or
+// removed after t
+func manifestsChangedSince(root string, t time.Time) bool {
+	if info, err := os.Stat(root); err == nil && info.ModTime().After(t) {
+		return true
+	}
+	for _, name := range profileManifests {
+		if info, err := os.Stat(filepath.Join(root, name)); err == nil && info.ModTime().After(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"go.uber.org/zap"
+)
+
+// PTYSession is an interactive terminal session backed by a pseudo-terminal
+type PTYSession struct {
+	ID         string    `json:"id"`
+	Shell      Shell     `json:"shell"`
+	WorkingDir string    `json:"working_dir"`
+	PID        int       `json:"pid"`
+	StartedAt  time.Time `json:"started_at"`
+
+	cmd *exec.Cmd
+	tty *os.File
+	// container names the sandbox container the shell runs in, if any
+	container string
+	done      chan struct{}
+	code      int
+}
+
+// Read reads terminal output
+func (p *PTYSession) Read(b []byte) (int, error) {
+	return p.tty.Read(b)
+}
+
+// Write sends input to the terminal
+func (p *PTYSession) Write(b []byte) (int, error) {
+	return p.tty.Write(b)
+}
+
+// Resize changes the terminal window size
+func (p *PTYSession) Resize(rows, cols uint16) error {
+	return pty.Setsize(p.tty, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Done is closed when the shell exits
+func (p *PTYSession) Done() <-chan struct{} {
+	return p.done
+}
+
+// ExitCode returns the shell's exit code once Done is closed
+func (p *PTYSession) ExitCode() int {
+	<-p.done
+	return p.code
+}
+
+// PTYManager tracks interactive terminal sessions
+type PTYManager struct {
+	mu        sync.Mutex
+	sessions  map[string]*PTYSession
+	envFilter *envFilter
+	egress    *Egress
+	// sandbox runs the shells in containers when commands are sandboxed
+	sandbox *SandboxExecutor
+	audit   CommandAuditLog
+	policy  *PolicyGuard
+	logger  *zap.Logger
+}
+
+// NewPTYManager creates a PTY manager using the executor's environment
+// filtering and network restrictions. With a sandbox, shells run in its
+// containers rather than on the host. Sessions are checked against policy
+// before they start and recorded in audit when they end; sandbox, audit
+// and policy may be nil.
+func NewPTYManager(cfg ExecutorConfig, sandbox *SandboxExecutor, audit CommandAuditLog, policy *PolicyGuard, logger *zap.Logger) *PTYManager {
+	return &PTYManager{
+		sessions:  make(map[string]*PTYSession),
+		envFilter: newEnvFilter(cfg.EnvAllowlist, cfg.EnvDenylist),
+		egress:    cfg.Egress,
+		sandbox:   sandbox,
+		audit:     audit,
+		policy:    policy,
+		logger:    logger,
+	}
+}
+
+// Start opens an interactive shell in workingDir. If command is non-empty
+// it is run instead of an interactive shell (e.g. a REPL or git rebase -i).
+// ctx carries the requester checked by the policy; it does not bound the
+// session.
+func (m *PTYManager) Start(ctx context.Context, shell Shell, workingDir, command string, rows, cols uint16) (*PTYSession, error) {
+	id := fmt.Sprintf("pty_%d", time.Now().UnixNano())
+	if m.sandbox != nil {
+		shell = m.sandbox.DefaultShell()
+	}
+	// The policy sees the shell itself when the session is interactive
+	audited := command
+	if audited == "" {
+		audited = string(shell)
+	}
+	if err := m.policy.Check(ctx, PolicyInput{Action: PolicyCommand, Command: audited, WorkingDir: absPath(workingDir)}); err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	var container string
+	switch {
+	case m.sandbox != nil:
+		container = "spilot-" + id
+		var err error
+		cmd, err = m.sandbox.containerCommand(container, workingDir, map[string]string{"TERM": "xterm-256color"}, command, true)
+		if err != nil {
+			return nil, err
+		}
+	case command != "":
+		cmd = shell.Command(context.Background(), command)
+	default:
+		cmd = exec.Command(string(shell))
+	}
+	if m.sandbox == nil {
+		cmd.Dir = workingDir
+		cmd.Env = append(m.envFilter.environ(m.egress.env(nil)), "TERM=xterm-256color")
+		if err := m.egress.apply(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	tty, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pty: %w", err)
+	}
+
+	session := &PTYSession{
+		ID:         id,
+		Shell:      shell,
+		WorkingDir: workingDir,
+		PID:        cmd.Process.Pid,
+		StartedAt:  time.Now(),
+		cmd:        cmd,
+		tty:        tty,
+		container:  container,
+		done:       make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+
+	origin := commandOriginFrom(ctx)
+	requester := RequesterFrom(ctx)
+	go func() {
+		err := cmd.Wait()
+		session.code = exitCode(cmd, err)
+		close(session.done)
+		m.record(session, audited, origin, requester)
+	}()
+
+	m.logger.Info("Started PTY session", zap.String("session_id", session.ID), zap.String("shell", string(shell)), zap.Bool("sandboxed", container != ""))
+	return session, nil
+}
+
+// record writes the ended session to the audit log. What was typed into
+// it is not recorded.
+func (m *PTYManager) record(session *PTYSession, command string, origin commandOrigin, requester string) {
+	if m.audit == nil {
+		return
+	}
+	entry := &CommandAuditEntry{
+		ID:          session.ID,
+		TaskID:      origin.TaskID,
+		Requester:   requester,
+		Instruction: origin.Instruction,
+		Command:     command,
+		WorkingDir:  session.WorkingDir,
+		Status:      "exited",
+		ExitCode:    session.code,
+		Duration:    time.Since(session.StartedAt),
+		Timestamp:   session.StartedAt,
+	}
+	if err := m.audit.Record(entry); err != nil {
+		m.logger.Error("Failed to record PTY session in audit log", zap.Error(err))
+	}
+}
+
+// List returns the open sessions, oldest first
+func (m *PTYManager) List() []*PTYSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*PTYSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		list = append(list, session)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
+
+// Close kills a session's shell and releases its terminal
+func (m *PTYManager) Close(id string) error {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("pty session %s not found", id)
+	}
+
+	select {
+	case <-session.done:
+	default:
+		if session.cmd.Process != nil {
+			session.cmd.Process.Kill()
+		}
+		if session.container != "" {
+			m.sandbox.removeContainer(session.container)
+		}
+	}
+	if err := session.tty.Close(); err != nil && err != io.ErrClosedPipe {
+		return err
+	}
+	return nil
+}
+
+// CloseAll closes every session, used on shutdown
+func (m *PTYManager) CloseAll() {
+	for _, session := range m.List() {
+		if err := m.Close(session.ID); err != nil {
+			m.logger.Warn("Failed to close PTY session", zap.String("session_id", session.ID), zap.Error(err))
+		}
+	}
+}
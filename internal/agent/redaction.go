@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+
+	"spilot-agent/internal/redact"
+)
+
+// RedactingExecutor replaces secrets in the output of commands run by the
+// wrapped executor, so they reach neither task results, the audit log nor
+// prompts built from the output. Secrets split across two streamed chunks
+// are only redacted in the final output.
+type RedactingExecutor struct {
+	CommandExecutor
+	redactor *redact.Redactor
+	// onRedact is told how many secrets were removed from a command's output
+	onRedact func(ctx context.Context, count int)
+}
+
+// NewRedactingExecutor wraps an executor with secret redaction
+func NewRedactingExecutor(exec CommandExecutor, redactor *redact.Redactor, onRedact func(ctx context.Context, count int)) *RedactingExecutor {
+	return &RedactingExecutor{CommandExecutor: exec, redactor: redactor, onRedact: onRedact}
+}
+
+// ExecuteCommand executes a single command and redacts its output
+func (r *RedactingExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return r.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream executes a single command, streaming and returning
+// its output with secrets redacted
+func (r *RedactingExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	result, err := r.CommandExecutor.ExecuteCommandStream(ctx, command, workingDir, opts, r.stream(onStdout), r.stream(onStderr))
+	if result != nil {
+		var outputCount, errorCount int
+		result.Output, outputCount = r.redactor.Redact(result.Output)
+		result.Error, errorCount = r.redactor.Redact(result.Error)
+		if count := outputCount + errorCount; count > 0 && r.onRedact != nil {
+			r.onRedact(ctx, count)
+		}
+	}
+	return result, err
+}
+
+// ExecuteCommands executes multiple commands and redacts their output
+func (r *RedactingExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	var results []*Command
+
+	for _, command := range commands {
+		result, err := r.ExecuteCommand(ctx, command, workingDir, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if result.Status != "completed" {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+func (r *RedactingExecutor) stream(fn func(chunk string)) func(chunk string) {
+	if fn == nil {
+		return nil
+	}
+	return func(chunk string) {
+		fn(r.redactor.String(chunk))
+	}
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// RefactorAgent performs structural code changes. Go code is transformed
+// with go/ast and go/types; other languages fall back to LLM-generated patches.
+type RefactorAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	tools       *ToolRunner
+	logger      *zap.Logger
+}
+
+// NewRefactorAgent creates a new refactor agent, diffing its changes with
+// git run by tools
+func NewRefactorAgent(llmClient LLMClient, fileManager FileManager, tools *ToolRunner, logger *zap.Logger) *RefactorAgentImpl {
+	return &RefactorAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		tools:       tools,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (r *RefactorAgentImpl) Type() AgentType {
+	return RefactorAgent
+}
+
+// Execute executes a refactoring task. Operations:
+//   - rename: "path", "symbol", optional "line", "new_name"
+//   - extract_function: "path", "start_line", "end_line", "name"
+//   - move_package: "from", "to" (Go only)
+//
+// With "dry_run" the patches are returned without being applied.
+func (r *RefactorAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	r.logger.Info("Refactor agent executing task", zap.String("task_id", task.ID))
+
+	operation, ok := task.Data["operation"].(string)
+	if !ok {
+		return nil, fmt.Errorf("operation data not found in task")
+	}
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		return nil, fmt.Errorf("workspace_dir not found in task data")
+	}
+
+	switch operation {
+	case "rename":
+		return r.handleRename(ctx, task, workspaceDir)
+	case "extract_function":
+		return r.handleExtractFunction(ctx, task, workspaceDir)
+	case "move_package":
+		return r.handleMovePackage(ctx, task, workspaceDir)
+	default:
+		return nil, fmt.Errorf("unknown refactor operation: %s", operation)
+	}
+}
+
+func (r *RefactorAgentImpl) handleRename(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	path, symbol, newName := stringField(task.Data, "path"), stringField(task.Data, "symbol"), stringField(task.Data, "new_name")
+	if path == "" || symbol == "" || newName == "" {
+		return nil, fmt.Errorf("rename requires path, symbol and new_name")
+	}
+	line := 0
+	if n, ok := task.Data["line"].(float64); ok {
+		line = int(n)
+	}
+
+	if filepath.Ext(path) != ".go" {
+		instruction := fmt.Sprintf("Rename %s to %s everywhere it is declared or referenced in this file.", symbol, newName)
+		return r.llmRefactor(ctx, task, workspaceDir, path, instruction)
+	}
+
+	patches, count, err := RenameGoSymbol(workspaceDir, filepath.Join(workspaceDir, path), line, symbol, newName)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	result, err := r.apply(ctx, task, workspaceDir, patches)
+	if fields := result.Fields(); fields != nil {
+		fields["occurrences"] = count
+	}
+	return result, err
+}
+
+func (r *RefactorAgentImpl) handleExtractFunction(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	path, name := stringField(task.Data, "path"), stringField(task.Data, "name")
+	start, _ := task.Data["start_line"].(float64)
+	end, _ := task.Data["end_line"].(float64)
+	if path == "" || name == "" || start <= 0 || end < start {
+		return nil, fmt.Errorf("extract_function requires path, name, start_line and end_line")
+	}
+
+	if filepath.Ext(path) != ".go" {
+		instruction := fmt.Sprintf("Extract lines %d-%d into a new function named %s, passing the values it needs as parameters and returning the values used afterwards. Replace the lines with a call to it.", int(start), int(end), name)
+		return r.llmRefactor(ctx, task, workspaceDir, path, instruction)
+	}
+
+	patch, err := ExtractGoFunction(filepath.Join(workspaceDir, path), int(start), int(end), name)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	return r.apply(ctx, task, workspaceDir, []FilePatch{*patch})
+}
+
+func (r *RefactorAgentImpl) handleMovePackage(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	from, to := stringField(task.Data, "from"), stringField(task.Data, "to")
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("move_package requires from and to")
+	}
+
+	patches, fromDir, toDir, err := MoveGoPackage(workspaceDir, from, to)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	data := Fields{"from": fromDir, "to": toDir, "files": patchPaths(patches)}
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		data["patches"] = patches
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+
+	backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID)
+	applied, err := ApplyPatches(fileManagerFor(ctx, r.fileManager), workspaceDir, backupDir, patches)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	data["diff"] = applied.Diff(ctx, r.tools)
+
+	err = os.MkdirAll(filepath.Dir(toDir), 0755)
+	if err == nil {
+		err = os.Rename(fromDir, toDir)
+	}
+	if err != nil {
+		if rbErr := applied.Rollback(); rbErr != nil {
+			err = fmt.Errorf("%w (rollback also failed: %v)", err, rbErr)
+		}
+		return &TaskResult{Success: false, Error: fmt.Sprintf("failed to move package: %v", err)}, nil
+	}
+	data["applied"] = applied
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// apply writes patches with backups, or returns them unapplied for a dry run
+func (r *RefactorAgentImpl) apply(ctx context.Context, task *Task, workspaceDir string, patches []FilePatch) (*TaskResult, error) {
+	if len(patches) == 0 {
+		return &TaskResult{Success: false, Error: "refactoring produced no changes"}, nil
+	}
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		return &TaskResult{
+			Success: true,
+			Data:    Fields{"files": patchPaths(patches), "patches": patches, "dry_run": true},
+		}, nil
+	}
+
+	backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID)
+	applied, err := ApplyPatches(fileManagerFor(ctx, r.fileManager), workspaceDir, backupDir, patches)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"files": applied.Files, "applied": applied, "diff": applied.Diff(ctx, r.tools)},
+	}, nil
+}
+
+// llmRefactor asks the LLM for search/replace patches implementing
+// instruction on a single file
+func (r *RefactorAgentImpl) llmRefactor(ctx context.Context, task *Task, workspaceDir, path, instruction string) (*TaskResult, error) {
+	content, err := fileManagerFor(ctx, r.fileManager).ReadFile(filepath.Join(workspaceDir, path))
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	prompt := fmt.Sprintf(`%s
+
+File %s:
+%s
+
+Respond with only a JSON array of patches, each {"path": %q, "search": "<exact existing text>", "replace": "<new text>"}.
+Search text must match the file exactly, without line numbers. Preserve behavior and keep unrelated code untouched.`, instruction, path, numberLines(content), path)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert at behavior-preserving refactoring. Produce minimal, exact file patches as JSON."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := r.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refactoring: %w", err)
+	}
+	patches, err := parsePatches(response)
+	if err != nil {
+		return nil, err
+	}
+	return r.apply(ctx, task, workspaceDir, patches)
+}
+
+// numberLines prefixes each line of content with its line number
+func numberLines(content string) string {
+	lines := strings.Split(content, "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "%4d | %s\n", i+1, line)
+	}
+	return b.String()
+}
+
+// patchPaths lists the files touched by patches
+func patchPaths(patches []FilePatch) []string {
+	paths := make([]string, 0, len(patches))
+	for _, patch := range patches {
+		if !slices.Contains(paths, patch.Path) {
+			paths = append(paths, patch.Path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,404 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// changelogFile is the changelog maintained in the workspace root
+const changelogFile = "CHANGELOG.md"
+
+// SemVer is a MAJOR.MINOR.PATCH version
+type SemVer struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v SemVer) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+var semverTag = regexp.MustCompile(`^(.*?)(\d+)\.(\d+)\.(\d+)$`)
+
+// parseVersionTag splits a tag like v1.2.3 into its prefix and version
+func parseVersionTag(tag string) (string, SemVer, bool) {
+	m := semverTag.FindStringSubmatch(tag)
+	if m == nil {
+		return "", SemVer{}, false
+	}
+	major, _ := strconv.Atoi(m[2])
+	minor, _ := strconv.Atoi(m[3])
+	patch, _ := strconv.Atoi(m[4])
+	return m[1], SemVer{major, minor, patch}, true
+}
+
+// Bump returns the next version for a major, minor or patch release.
+// Before 1.0.0 breaking changes bump the minor version.
+func (v SemVer) Bump(kind string) SemVer {
+	switch kind {
+	case "major":
+		if v.Major == 0 {
+			return SemVer{0, v.Minor + 1, 0}
+		}
+		return SemVer{v.Major + 1, 0, 0}
+	case "minor":
+		return SemVer{v.Major, v.Minor + 1, 0}
+	default:
+		return SemVer{v.Major, v.Minor, v.Patch + 1}
+	}
+}
+
+// ReleaseCommit is a commit included in a release
+type ReleaseCommit struct {
+	Hash     string `json:"hash"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Subject  string `json:"subject"`
+	Breaking bool   `json:"breaking,omitempty"`
+}
+
+// conventionalCommit matches "type(scope)!: subject"
+var conventionalCommit = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// parseReleaseCommit classifies a commit by its Conventional Commits prefix
+func parseReleaseCommit(hash, subject, body string) ReleaseCommit {
+	commit := ReleaseCommit{Hash: hash, Type: "other", Subject: subject}
+	if m := conventionalCommit.FindStringSubmatch(subject); m != nil {
+		commit.Type, commit.Scope, commit.Subject = strings.ToLower(m[1]), m[2], m[4]
+		commit.Breaking = m[3] == "!"
+	}
+	if strings.Contains(body, "BREAKING CHANGE:") || strings.Contains(body, "BREAKING-CHANGE:") {
+		commit.Breaking = true
+	}
+	return commit
+}
+
+// changelogSections orders the changelog groups and names them
+var changelogSections = []struct{ name, title string }{
+	{"breaking", "Breaking Changes"},
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance"},
+	{"other", "Other Changes"},
+}
+
+// ReleasePlan is a prepared release awaiting approval
+type ReleasePlan struct {
+	Previous  string          `json:"previous,omitempty"`
+	Version   string          `json:"version"`
+	Tag       string          `json:"tag"`
+	Bump      string          `json:"bump"`
+	Head      string          `json:"head"`
+	Commits   []ReleaseCommit `json:"commits"`
+	Changelog string          `json:"changelog"`
+	Notes     string          `json:"notes"`
+}
+
+// ReleaseAgent derives changelogs and versions from git history and tags
+// releases. Every change to the repository waits for approval.
+type ReleaseAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	approvals   *ApprovalStore
+	events      *EventBus
+	tools       *ToolRunner
+	logger      *zap.Logger
+}
+
+// NewReleaseAgent creates a new release agent
+func NewReleaseAgent(llmClient LLMClient, fileManager FileManager, approvals *ApprovalStore, events *EventBus, tools *ToolRunner, logger *zap.Logger) *ReleaseAgentImpl {
+	return &ReleaseAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		approvals:   approvals,
+		events:      events,
+		tools:       tools,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (r *ReleaseAgentImpl) Type() AgentType {
+	return ReleaseAgent
+}
+
+// Execute prepares a release from the commits since the last version tag:
+// the next version ("bump" major, minor or patch; derived from the commits
+// by default), a changelog section and LLM-drafted release notes. Unless
+// "dry_run" is set it requests approval to update CHANGELOG.md, commit it
+// and tag the release ("push" also pushes to "remote"). Resubmitting with
+// the approval_id performs the release.
+func (r *ReleaseAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	r.logger.Info("Release agent executing task", zap.String("task_id", task.ID))
+
+	if approvalID, ok := task.Data["approval_id"].(string); ok && approvalID != "" {
+		approval, err := r.approvals.Consume(approvalID, "release")
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		plan, _ := approval.Data["plan"].(*ReleasePlan)
+		if plan == nil {
+			return &TaskResult{Success: false, Error: "approval does not hold a release"}, nil
+		}
+		push, _ := approval.Data["push"].(bool)
+		remote, _ := approval.Data["remote"].(string)
+		return r.release(ctx, plan, approval.WorkingDir, push, remote)
+	}
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	plan, err := r.prepare(ctx, task, workspaceDir)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	data := Fields{"release": plan}
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		return &TaskResult{Success: true, Data: data}, nil
+	}
+
+	push, _ := task.Data["push"].(bool)
+	remote := stringField(task.Data, "remote")
+	if remote == "" {
+		remote = "origin"
+	}
+	if err := checkGitRef(remote); err != nil {
+		return nil, err
+	}
+	reasons := []string{"commits " + changelogFile + " and creates tag " + plan.Tag}
+	if push {
+		reasons = append(reasons, "pushes the release to "+remote)
+	}
+	risk := &RiskAssessment{Level: RiskHigh, Reasons: reasons}
+	approval := r.approvals.Request(&Approval{
+		TaskID:     task.ID,
+		Kind:       "release",
+		Subject:    "Release " + plan.Tag,
+		Risk:       risk,
+		Data:       map[string]interface{}{"plan": plan, "push": push, "remote": remote},
+		WorkingDir: workspaceDir,
+	})
+	r.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "release": plan.Tag, "risk": risk},
+	})
+	data["requires_approval"] = true
+	data["approval_id"] = approval.ID
+	return &TaskResult{
+		Success: false,
+		Error:   "release changes the repository and requires approval",
+		Data:    data,
+	}, nil
+}
+
+// prepare collects the commits since the last version tag and drafts the
+// release without changing anything
+func (r *ReleaseAgentImpl) prepare(ctx context.Context, task *Task, workspaceDir string) (*ReleasePlan, error) {
+	head, err := r.tools.git(ctx, workspaceDir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	plan := &ReleasePlan{Head: head}
+
+	prefix, current := "v", SemVer{}
+	logRange := "HEAD"
+	if tag, err := r.tools.git(ctx, workspaceDir, "describe", "--tags", "--abbrev=0"); err == nil {
+		if p, v, ok := parseVersionTag(tag); ok {
+			prefix, current = p, v
+			plan.Previous = tag
+			logRange = tag + "..HEAD"
+		}
+	}
+
+	out, err := r.tools.git(ctx, workspaceDir, "log", "--no-merges", "--format=%H%x1f%s%x1f%b%x1e", logRange)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 3 {
+			continue
+		}
+		plan.Commits = append(plan.Commits, parseReleaseCommit(fields[0], fields[1], fields[2]))
+	}
+	if len(plan.Commits) == 0 {
+		return nil, fmt.Errorf("no commits since %s", plan.Previous)
+	}
+
+	plan.Bump = stringField(task.Data, "bump")
+	switch plan.Bump {
+	case "major", "minor", "patch":
+	case "", "auto":
+		plan.Bump = releaseBump(plan.Commits)
+	default:
+		return nil, fmt.Errorf("unknown bump: %s (use major, minor or patch)", plan.Bump)
+	}
+	next := current.Bump(plan.Bump)
+	if plan.Previous == "" {
+		// The first release is whatever the history says it is
+		next = SemVer{0, 1, 0}
+		if plan.Bump == "major" {
+			next = SemVer{1, 0, 0}
+		}
+	}
+	if version := stringField(task.Data, "version"); version != "" {
+		_, explicit, ok := parseVersionTag(version)
+		if !ok {
+			return nil, fmt.Errorf("invalid version: %s", version)
+		}
+		next = explicit
+	}
+	plan.Version = next.String()
+	plan.Tag = prefix + plan.Version
+	if _, err := r.tools.git(ctx, workspaceDir, "rev-parse", "--verify", "--quiet", "refs/tags/"+plan.Tag); err == nil {
+		return nil, fmt.Errorf("tag %s already exists", plan.Tag)
+	}
+
+	plan.Changelog = changelogSection(plan.Version, time.Now(), plan.Commits)
+	plan.Notes = plan.Changelog
+	if notes, ok := task.Data["notes"].(bool); !ok || notes {
+		drafted, err := r.draftNotes(ctx, plan)
+		if err != nil {
+			r.logger.Warn("Using the changelog as release notes", zap.Error(err))
+		} else {
+			plan.Notes = drafted
+		}
+	}
+	return plan, nil
+}
+
+// releaseBump derives the version bump from the commits' types
+func releaseBump(commits []ReleaseCommit) string {
+	bump := "patch"
+	for _, commit := range commits {
+		if commit.Breaking {
+			return "major"
+		}
+		if commit.Type == "feat" {
+			bump = "minor"
+		}
+	}
+	return bump
+}
+
+// changelogSection renders a Markdown changelog section for a version
+func changelogSection(version string, date time.Time, commits []ReleaseCommit) string {
+	groups := make(map[string][]string)
+	for _, commit := range commits {
+		group := commit.Type
+		switch {
+		case commit.Breaking:
+			group = "breaking"
+		case group != "feat" && group != "fix" && group != "perf":
+			group = "other"
+		}
+		// Housekeeping doesn't belong in a changelog
+		if group == "other" && (commit.Type == "chore" || commit.Type == "ci" || commit.Type == "test" || commit.Type == "style") {
+			continue
+		}
+		entry := commit.Subject
+		if commit.Scope != "" {
+			entry = "**" + commit.Scope + ":** " + entry
+		}
+		short := commit.Hash
+		if len(short) > 7 {
+			short = short[:7]
+		}
+		groups[group] = append(groups[group], fmt.Sprintf("- %s (%s)", entry, short))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s - %s\n", version, date.Format("2006-01-02"))
+	for _, section := range changelogSections {
+		if entries := groups[section.name]; len(entries) > 0 {
+			fmt.Fprintf(&b, "\n### %s\n\n%s\n", section.title, strings.Join(entries, "\n"))
+		}
+	}
+	return b.String()
+}
+
+// draftNotes asks the LLM to turn the changelog into release notes
+func (r *ReleaseAgentImpl) draftNotes(ctx context.Context, plan *ReleasePlan) (string, error) {
+	prompt := fmt.Sprintf(`Write release notes for version %s from this changelog:
+
+%s
+Start with a short summary of the highlights, then call out breaking changes with upgrade steps, then list the remaining changes. Use Markdown and don't invent changes.`, plan.Version, plan.Changelog)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You write clear, accurate release notes for software users."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	notes, err := r.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("failed to draft release notes: %w", err)
+	}
+	return strings.TrimSpace(notes) + "\n", nil
+}
+
+// release performs an approved release: it updates the changelog, commits
+// it, tags the commit and optionally pushes
+func (r *ReleaseAgentImpl) release(ctx context.Context, plan *ReleasePlan, workspaceDir string, push bool, remote string) (*TaskResult, error) {
+	// The approval covered the history as it was when the release was prepared
+	if head, err := r.tools.git(ctx, workspaceDir, "rev-parse", "HEAD"); err != nil || head != plan.Head {
+		return &TaskResult{Success: false, Error: "HEAD moved since the release was prepared; prepare it again"}, nil
+	}
+
+	path := filepath.Join(workspaceDir, changelogFile)
+	var err error
+	if fileManagerFor(ctx, r.fileManager).FileExists(path) {
+		var existing string
+		existing, err = fileManagerFor(ctx, r.fileManager).ReadFile(path)
+		if err == nil {
+			err = fileManagerFor(ctx, r.fileManager).UpdateFile(path, insertChangelogSection(existing, plan.Changelog))
+		}
+	} else {
+		err = fileManagerFor(ctx, r.fileManager).CreateFile(path, "# Changelog\n\n"+plan.Changelog)
+	}
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	data := Fields{"release": plan, "changelog": changelogFile}
+	// Only the changelog is committed, whatever else is staged
+	if _, err := r.tools.gitChange(ctx, workspaceDir, "add", "--", changelogFile); err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: data}, nil
+	}
+	if _, err := r.tools.gitChange(ctx, workspaceDir, "commit", "-m", "chore(release): "+plan.Tag, "--", changelogFile); err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: data}, nil
+	}
+	data["commit"], _ = r.tools.git(ctx, workspaceDir, "rev-parse", "HEAD")
+	if _, err := r.tools.gitChange(ctx, workspaceDir, "tag", "-a", plan.Tag, "-m", "Release "+plan.Tag+"\n\n"+plan.Notes); err != nil {
+		return &TaskResult{Success: false, Error: err.Error(), Data: data}, nil
+	}
+	data["tag"] = plan.Tag
+
+	if push {
+		if _, err := r.tools.gitChange(ctx, workspaceDir, "push", remote, "HEAD", "refs/tags/"+plan.Tag); err != nil {
+			return &TaskResult{Success: false, Error: err.Error(), Data: data}, nil
+		}
+		data["pushed"] = remote
+	}
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// insertChangelogSection adds a section above the newest release in a
+// changelog, keeping any title and introduction at the top
+func insertChangelogSection(changelog, section string) string {
+	if i := strings.Index(changelog, "\n## "); i >= 0 {
+		return changelog[:i+1] + section + "\n" + changelog[i+1:]
+	}
+	if strings.HasPrefix(changelog, "## ") {
+		return section + "\n" + changelog
+	}
+	return strings.TrimRight(changelog, "\n") + "\n\n" + section
+}
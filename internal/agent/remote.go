@@ -0,0 +1,357 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/queue"
+
+	"go.uber.org/zap"
+)
+
+// DefaultPool takes the tasks no pool is configured for
+const DefaultPool = "default"
+
+// ErrWorkerTimeout is returned when no worker reports a task's result in
+// time
+var ErrWorkerTimeout = errors.New("no worker reported the task's result in time")
+
+// remoteErrors are the errors whose identity survives a task's execution on
+// a worker, so the server still answers them with the right status
+var remoteErrors = []error{
+	ErrBudgetExceeded, ErrOutsideTenant, ErrScopeDenied, ErrAgentDisabled,
+	ErrPlanToken, ErrSessionNotFound,
+}
+
+// QueueConfig distributes tasks to workers over a message broker. The
+// server and its workers must see workspaces at the same paths.
+type QueueConfig struct {
+	// Driver is "nats" or "redis"; empty runs every task in-process
+	Driver string
+	URL    string
+	// Prefix starts the names of the queues and topics, so several
+	// deployments can share a broker
+	Prefix string
+	// Pools map the primary language of a task's workspace, such as "go",
+	// in any case, to the pool of workers with its toolchain. Tasks of
+	// other workspaces go to DefaultPool.
+	Pools map[string]string
+	// WorkerPools are the pools a worker takes tasks from
+	WorkerPools []string
+	// WorkerConcurrency is how many tasks a worker runs at once per pool
+	WorkerConcurrency int
+	// TaskTimeout bounds the wait for a worker's result
+	TaskTimeout time.Duration
+}
+
+// queuedTask is a task sent to the workers, with what the server knew
+// about its requester
+type queuedTask struct {
+	Task      *Task   `json:"task"`
+	Requester string  `json:"requester,omitempty"`
+	SessionID string  `json:"session_id,omitempty"`
+	APIKey    *APIKey `json:"api_key,omitempty"`
+}
+
+// queuedResult is the outcome of a task a worker executed
+type queuedResult struct {
+	TaskID string      `json:"task_id"`
+	Result *TaskResult `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Worker string      `json:"worker"`
+}
+
+// remoteError is an error a worker reported
+type remoteError struct {
+	message string
+	cause   error
+}
+
+func (e *remoteError) Error() string { return e.message }
+func (e *remoteError) Unwrap() error { return e.cause }
+
+func newRemoteError(message string) error {
+	for _, err := range remoteErrors {
+		if strings.Contains(message, err.Error()) {
+			return &remoteError{message: message, cause: err}
+		}
+	}
+	return errors.New(message)
+}
+
+type dequeuedKey struct{}
+
+// RemoteTasks sends tasks to the workers of their pool and waits for their
+// results. Workers publish the events of the tasks they run, which the
+// server republishes, so clients follow remote tasks as local ones.
+type RemoteTasks struct {
+	broker   queue.Broker
+	cfg      QueueConfig
+	events   *EventBus
+	profiles *ProfileStore
+
+	mu         sync.Mutex
+	subscribed bool
+	pending    map[string]chan queuedResult
+	stop       []func()
+	logger     *zap.Logger
+}
+
+// NewRemoteTasks connects to the broker of cfg. It returns nil when no
+// driver is configured.
+func NewRemoteTasks(cfg QueueConfig, events *EventBus, profiles *ProfileStore, logger *zap.Logger) (*RemoteTasks, error) {
+	if cfg.Driver == "" {
+		return nil, nil
+	}
+	broker, err := queue.New(queue.Config{Driver: cfg.Driver, URL: cfg.URL})
+	if err != nil {
+		return nil, fmt.Errorf("queue: %w", err)
+	}
+	pools := make(map[string]string, len(cfg.Pools))
+	for language, pool := range cfg.Pools {
+		pools[strings.ToLower(language)] = pool
+	}
+	cfg.Pools = pools
+	return &RemoteTasks{
+		broker:   broker,
+		cfg:      cfg,
+		events:   events,
+		profiles: profiles,
+		pending:  make(map[string]chan queuedResult),
+		logger:   logger,
+	}, nil
+}
+
+func (r *RemoteTasks) tasksQueue(pool string) string { return r.cfg.Prefix + ".tasks." + pool }
+func (r *RemoteTasks) resultsTopic() string          { return r.cfg.Prefix + ".results" }
+func (r *RemoteTasks) eventsTopic() string           { return r.cfg.Prefix + ".events" }
+
+// dispatches reports whether a task executed with ctx goes to a worker.
+// Tasks a worker took run where they are, as do the tasks that hold state
+// only this process has: a plan's blast-radius accounting, writes awaiting
+// the user's confirmation and handoffs between agents.
+func (r *RemoteTasks) dispatches(ctx context.Context) bool {
+	if r == nil {
+		return false
+	}
+	if dequeued, _ := ctx.Value(dequeuedKey{}).(bool); dequeued {
+		return false
+	}
+	_, handoff := ctx.Value(handoffKey{}).(*handoffChain)
+	return !handoff && blastRadiusFrom(ctx) == nil && !confirmsWrites(ctx)
+}
+
+// pool returns the pool whose workers run the tasks of workspaceDir
+func (r *RemoteTasks) pool(workspaceDir string) string {
+	if len(r.cfg.Pools) == 0 || workspaceDir == "" {
+		return DefaultPool
+	}
+	profile, err := r.profiles.Get(workspaceDir)
+	if err != nil || len(profile.Languages) == 0 {
+		return DefaultPool
+	}
+	if pool, ok := r.cfg.Pools[strings.ToLower(profile.Languages[0])]; ok {
+		return pool
+	}
+	return DefaultPool
+}
+
+// Submit sends task to a worker and returns its result
+func (r *RemoteTasks) Submit(ctx context.Context, task *Task) (*TaskResult, error) {
+	if err := r.subscribe(); err != nil {
+		return nil, err
+	}
+	msg := queuedTask{Task: task, Requester: RequesterFrom(ctx), SessionID: sessionFrom(ctx)}
+	if key := APIKeyFrom(ctx); key != nil {
+		c := *key
+		c.Hash = ""
+		msg.APIKey = &c
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan queuedResult, 1)
+	r.mu.Lock()
+	r.pending[task.ID] = done
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, task.ID)
+		r.mu.Unlock()
+	}()
+
+	pool := r.pool(stringField(task.Data, "workspace_dir"))
+	if err := r.broker.Enqueue(ctx, r.tasksQueue(pool), data); err != nil {
+		return nil, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	r.logger.Debug("Task sent to workers", zap.String("task_id", task.ID), zap.String("pool", pool))
+
+	timeout := time.NewTimer(r.cfg.TaskTimeout)
+	defer timeout.Stop()
+	select {
+	case result := <-done:
+		if result.Error != "" {
+			return result.Result, newRemoteError(result.Error)
+		}
+		return result.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout.C:
+		return nil, fmt.Errorf("task %s in pool %s: %w", task.ID, pool, ErrWorkerTimeout)
+	}
+}
+
+// subscribe starts receiving the results and events workers publish, the
+// first time a task is submitted
+func (r *RemoteTasks) subscribe() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subscribed {
+		return nil
+	}
+	stopResults, err := r.broker.Subscribe(r.resultsTopic(), func(data []byte) {
+		var result queuedResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			r.logger.Warn("Invalid task result from a worker", zap.Error(err))
+			return
+		}
+		r.mu.Lock()
+		done := r.pending[result.TaskID]
+		r.mu.Unlock()
+		// Results of tasks another server submitted are not ours
+		if done != nil {
+			select {
+			case done <- result:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
+	stopEvents, err := r.broker.Subscribe(r.eventsTopic(), func(data []byte) {
+		var event TaskEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			r.logger.Warn("Invalid task event from a worker", zap.Error(err))
+			return
+		}
+		r.events.Publish(event)
+	})
+	if err != nil {
+		stopResults()
+		return fmt.Errorf("queue: %w", err)
+	}
+	r.stop = append(r.stop, stopResults, stopEvents)
+	r.subscribed = true
+	return nil
+}
+
+// Close stops receiving results and disconnects from the broker
+func (r *RemoteTasks) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	for _, stop := range r.stop {
+		stop()
+	}
+	r.stop = nil
+	r.mu.Unlock()
+	r.broker.Close()
+}
+
+// Work runs the tasks of the configured worker pools until ctx is done,
+// publishing their events and results for the server that submitted them
+func (s *System) Work(ctx context.Context) error {
+	r := s.remote
+	if r == nil {
+		return errors.New("queue.driver must be set to run as a worker")
+	}
+	worker, _ := os.Hostname()
+	worker = fmt.Sprintf("%s-%d", worker, os.Getpid())
+
+	events, unsubscribe := s.events.Subscribe("")
+	defer unsubscribe()
+	go func() {
+		for event := range events {
+			data, err := json.Marshal(event)
+			if err == nil {
+				err = r.broker.Publish(context.Background(), r.eventsTopic(), data)
+			}
+			if err != nil {
+				s.logger.Warn("Failed to publish task event", zap.String("task_id", event.TaskID), zap.Error(err))
+			}
+		}
+	}()
+
+	handle := func(data []byte) {
+		var msg queuedTask
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Task == nil {
+			s.logger.Warn("Invalid task from the queue", zap.Error(err))
+			return
+		}
+		taskCtx := context.WithValue(ctx, dequeuedKey{}, true)
+		taskCtx = ContextWithTaskID(taskCtx, msg.Task.ID)
+		taskCtx = ContextWithRequester(taskCtx, msg.Requester)
+		if msg.SessionID != "" {
+			taskCtx = ContextWithSession(taskCtx, msg.SessionID)
+		}
+		if msg.APIKey != nil {
+			taskCtx = ContextWithAPIKey(taskCtx, msg.APIKey)
+		}
+		s.logger.Info("Running queued task", zap.String("task_id", msg.Task.ID), zap.String("agent", string(msg.Task.Type)))
+		result := queuedResult{TaskID: msg.Task.ID, Worker: worker}
+		var err error
+		result.Result, err = s.ExecuteTask(taskCtx, msg.Task)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		reply, err := json.Marshal(result)
+		if err == nil {
+			err = r.broker.Publish(context.Background(), r.resultsTopic(), reply)
+		}
+		if err != nil {
+			s.logger.Error("Failed to publish task result", zap.String("task_id", msg.Task.ID), zap.Error(err))
+		}
+	}
+
+	pools := r.cfg.WorkerPools
+	if len(pools) == 0 {
+		pools = []string{DefaultPool}
+	}
+	// A consumer that fails stops the others, so the worker exits and can
+	// be restarted rather than serve some of its pools
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, pool := range pools {
+		for i := 0; i < r.cfg.WorkerConcurrency; i++ {
+			wg.Add(1)
+			go func(queue string) {
+				defer wg.Done()
+				if err := r.broker.Consume(ctx, queue, handle); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", queue, err))
+					mu.Unlock()
+					cancel()
+				}
+			}(r.tasksQueue(pool))
+		}
+	}
+	s.logger.Info("Taking tasks from the queue", zap.String("driver", r.cfg.Driver),
+		zap.Strings("pools", pools), zap.String("worker", worker))
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultRepairIterations bounds the test-driven repair loop when the
+	// task does not set max_iterations
+	defaultRepairIterations = 5
+	// maxRepairIterations caps max_iterations
+	maxRepairIterations = 20
+)
+
+// RepairIteration reports one round of the test-driven repair loop
+type RepairIteration struct {
+	Iteration int              `json:"iteration"`
+	Passed    bool             `json:"passed"`
+	ExitCode  int              `json:"exit_code"`
+	Locations []ErrorLocation  `json:"locations,omitempty"`
+	Analysis  string           `json:"analysis,omitempty"`
+	Patches   []FilePatch      `json:"patches,omitempty"`
+	Applied   *AppliedPatchSet `json:"applied,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	// Injections flag test output or code that appear to instruct the model
+	Injections []InjectionWarning `json:"injections,omitempty"`
+}
+
+// testCommandMarkers maps a project marker file to its usual test command
+var testCommandMarkers = []struct {
+	file    string
+	command string
+}{
+	{"go.mod", "go test ./..."},
+	{"Cargo.toml", "cargo test"},
+	{"package.json", "npm test"},
+	{"pyproject.toml", "pytest"},
+	{"pytest.ini", "pytest"},
+	{"setup.py", "pytest"},
+	{"pom.xml", "mvn -q test"},
+	{"build.gradle", "gradle test"},
+}
+
+// detectTestCommand guesses a project's test command from its marker files
+func detectTestCommand(workspaceDir string) (string, error) {
+	for _, marker := range testCommandMarkers {
+		if _, err := os.Stat(filepath.Join(workspaceDir, marker.file)); err == nil {
+			return marker.command, nil
+		}
+	}
+	return "", fmt.Errorf("could not detect a test command; set test_command")
+}
+
+// repair runs the tests, fixes failures and repeats until the tests pass
+// or the iteration budget is exhausted. Each iteration is published as a
+// task event. A test command sent with the task is screened once, before
+// the loop; if it needs approval, the task is resubmitted with the
+// approval_id once approved.
+func (d *DebugAgentImpl) repair(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	testCommand, _ := task.Data["test_command"].(string)
+	if testCommand != "" {
+		approval, err := d.terminal.screenCommand(ctx, task, testCommand, workspaceDir)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		if approval != nil {
+			return &TaskResult{
+				Success: false,
+				Error:   fmt.Sprintf("test command requires approval (%s risk)", approval.Risk.Level),
+				Data: Fields{
+					"test_command":      testCommand,
+					"requires_approval": true,
+					"approval_id":       approval.ID,
+				},
+			}, nil
+		}
+	} else {
+		detected, err := detectTestCommand(workspaceDir)
+		if err != nil {
+			return nil, err
+		}
+		testCommand = detected
+	}
+
+	maxIterations := defaultRepairIterations
+	if n, ok := task.Data["max_iterations"].(float64); ok && n > 0 {
+		maxIterations = min(int(n), maxRepairIterations)
+	}
+
+	var iterations []RepairIteration
+	for i := 1; i <= maxIterations; i++ {
+		iteration := d.repairIteration(ctx, task, testCommand, workspaceDir, i)
+		iterations = append(iterations, iteration)
+		d.events.Publish(TaskEvent{
+			TaskID: task.ID,
+			Type:   EventRepairIteration,
+			Data:   map[string]interface{}{"iteration": iteration},
+		})
+
+		if iteration.Passed || iteration.Error != "" || ctx.Err() != nil {
+			break
+		}
+	}
+
+	last := iterations[len(iterations)-1]
+	result := &TaskResult{
+		Success: last.Passed,
+		Data: Fields{
+			"test_command": testCommand,
+			"iterations":   iterations,
+			"passed":       last.Passed,
+		},
+	}
+	if !last.Passed {
+		result.Error = fmt.Sprintf("tests still failing after %d iteration(s)", len(iterations))
+		if last.Error != "" {
+			result.Error = last.Error
+		}
+	}
+	return result, nil
+}
+
+// repairIteration runs the tests once and, if they fail, applies a fix
+func (d *DebugAgentImpl) repairIteration(ctx context.Context, task *Task, testCommand, workspaceDir string, n int) RepairIteration {
+	iteration := RepairIteration{Iteration: n}
+
+	run, err := d.commandExec.ExecuteCommand(ctx, testCommand, workspaceDir, CommandOptions{})
+	if err != nil {
+		iteration.Error = fmt.Sprintf("failed to run tests: %v", err)
+		return iteration
+	}
+	iteration.ExitCode = run.ExitCode
+	if run.Status == "completed" {
+		iteration.Passed = true
+		return iteration
+	}
+	if run.Status == "timeout" || run.Status == "cancelled" {
+		iteration.Error = fmt.Sprintf("test command %s", run.Status)
+		return iteration
+	}
+
+	failure := run.Output + "\n" + run.Error
+	locations, snippets, injections := d.identifyErrorFile(ctx, failure, workspaceDir)
+	iteration.Locations = locations
+	iteration.Injections = injections
+	d.warnInjections(injections)
+
+	analysis, err := d.llmClient.AnalyzeError(ctx, fenceUntrusted("test output", failure), snippets)
+	if err != nil {
+		iteration.Error = fmt.Sprintf("failed to analyze test failures: %v", err)
+		return iteration
+	}
+	iteration.Analysis = analysis
+
+	patches, err := d.generatePatches(ctx, failure, snippets, analysis)
+	if err != nil {
+		iteration.Error = err.Error()
+		return iteration
+	}
+	iteration.Patches = patches
+
+	backupDir := filepath.Join(workspaceDir, ".spilot", "backups", task.ID, fmt.Sprintf("iteration-%d-%d", n, time.Now().Unix()))
+	applied, err := ApplyPatches(fileManagerFor(ctx, d.fileManager), workspaceDir, backupDir, patches)
+	if err != nil {
+		// A bad patch is not fatal; the next iteration sees the same failure
+		d.logger.Warn("Failed to apply repair patches", zap.Int("iteration", n), zap.Error(err))
+		iteration.Patches = nil
+		return iteration
+	}
+	iteration.Applied = applied
+	return iteration
+}
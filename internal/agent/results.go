@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ResultKind says which payload a TaskResult carries. It is written next
+// to the data of results in JSON, so clients decode them by kind rather
+// than by probing keys.
+type ResultKind string
+
+const (
+	ResultPlan    ResultKind = "plan"
+	ResultFileOp  ResultKind = "file"
+	ResultCommand ResultKind = "command"
+	ResultDebug   ResultKind = "debug"
+	// ResultFields is the kind of results of agents without a typed result
+	ResultFields ResultKind = "fields"
+)
+
+// ResultData is the payload of a TaskResult: a PlanResult, FileOpResult,
+// CommandResult or DebugResult, or Fields for the results of other agents
+type ResultData interface {
+	Kind() ResultKind
+}
+
+// Fields is the payload of agents without a typed result, by
+// agent-specific keys
+type Fields map[string]interface{}
+
+// PlanResult is a plan generated by the planning agent: the steps of a
+// request, or the project of /create-project. The system adds the approval
+// the plan waits for and the ticket it is linked to.
+type PlanResult struct {
+	Plan    string       `json:"plan,omitempty"`
+	Project *ProjectPlan `json:"project,omitempty"`
+	// PlanSHA256 and ApprovalID are set when the plan awaits approval
+	PlanSHA256 string `json:"plan_sha256,omitempty"`
+	ApprovalID string `json:"approval_id,omitempty"`
+	// Ticket, TicketURL and TicketError are set when the plan is linked to
+	// an issue
+	Ticket      string      `json:"ticket,omitempty"`
+	TicketURL   string      `json:"ticket_url,omitempty"`
+	TicketError string      `json:"ticket_error,omitempty"`
+	WebSources  []WebResult `json:"web_sources,omitempty"`
+}
+
+// FileOpResult is the outcome of a file agent operation on Path. Reads set
+// Content, which is a preview with ContentID set when the content was
+// written to disk (see OutputRef); head and tail reads also set Lines.
+type FileOpResult struct {
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Created   bool   `json:"created,omitempty"`
+	Updated   bool   `json:"updated,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	Formatted bool   `json:"formatted,omitempty"`
+	Content   string `json:"content,omitempty"`
+	ContentID string `json:"content_id,omitempty"`
+	Lines     int    `json:"lines,omitempty"`
+}
+
+// CommandResult is a command of the terminal agent: run, started in the
+// background, described by a dry run, or held for approval. As in Command,
+// OutputID and ErrorID are set when a large Output or Error was written to
+// disk, leaving a preview.
+type CommandResult struct {
+	Command   string          `json:"command"`
+	Shell     string          `json:"shell,omitempty"`
+	Risk      *RiskAssessment `json:"risk,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	Output    string          `json:"output,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ExitCode  int             `json:"exit_code"`
+	Truncated bool            `json:"truncated,omitempty"`
+	OutputID  string          `json:"output_id,omitempty"`
+	ErrorID   string          `json:"error_id,omitempty"`
+	// DryRun results describe the Effects of the command instead of
+	// running it
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Effects string `json:"effects,omitempty"`
+	// Background commands run on as Process
+	Background bool            `json:"background,omitempty"`
+	Process    *ManagedProcess `json:"process,omitempty"`
+	// RequiresApproval is set when the command waits for ApprovalID to be
+	// approved, or would in a dry run
+	RequiresApproval bool   `json:"requires_approval,omitempty"`
+	ApprovalID       string `json:"approval_id,omitempty"`
+}
+
+// DebugResult is the debug agent's analysis of an error or Go crash and
+// its fix. Applying the fix sets Patches and Applied, and Regression and
+// Verification when they were checked. RequiresApproval is set instead
+// when the fix waits for its verification command to be approved as
+// ApprovalID.
+type DebugResult struct {
+	Analysis          string             `json:"analysis"`
+	Fix               string             `json:"fix,omitempty"`
+	Candidates        []FixCandidate     `json:"candidates,omitempty"`
+	File              string             `json:"file,omitempty"`
+	Locations         []ErrorLocation    `json:"locations,omitempty"`
+	Diagnostics       []Diagnostic       `json:"diagnostics,omitempty"`
+	Crash             *GoCrash           `json:"crash,omitempty"`
+	WebSources        []WebResult        `json:"web_sources,omitempty"`
+	InjectionWarnings []InjectionWarning `json:"injection_warnings,omitempty"`
+	Patches           []FilePatch        `json:"patches,omitempty"`
+	Applied           *AppliedPatchSet   `json:"applied,omitempty"`
+	Regression        *RegressionReport  `json:"regression,omitempty"`
+	Verification      *Command           `json:"verification,omitempty"`
+	Verified          bool               `json:"verified,omitempty"`
+	RequiresApproval  bool               `json:"requires_approval,omitempty"`
+	ApprovalID        string             `json:"approval_id,omitempty"`
+}
+
+func (Fields) Kind() ResultKind         { return ResultFields }
+func (*PlanResult) Kind() ResultKind    { return ResultPlan }
+func (*FileOpResult) Kind() ResultKind  { return ResultFileOp }
+func (*CommandResult) Kind() ResultKind { return ResultCommand }
+func (*DebugResult) Kind() ResultKind   { return ResultDebug }
+
+// Fields returns the payload of a result without a typed one, or nil
+func (r *TaskResult) Fields() Fields {
+	if r == nil {
+		return nil
+	}
+	fields, _ := r.Data.(Fields)
+	return fields
+}
+
+// Plan returns the payload of a plan result, or nil
+func (r *TaskResult) Plan() *PlanResult {
+	if r == nil {
+		return nil
+	}
+	plan, _ := r.Data.(*PlanResult)
+	return plan
+}
+
+// FileOp returns the payload of a file operation result, or nil
+func (r *TaskResult) FileOp() *FileOpResult {
+	if r == nil {
+		return nil
+	}
+	op, _ := r.Data.(*FileOpResult)
+	return op
+}
+
+// Command returns the payload of a command result, or nil
+func (r *TaskResult) Command() *CommandResult {
+	if r == nil {
+		return nil
+	}
+	command, _ := r.Data.(*CommandResult)
+	return command
+}
+
+// Debug returns the payload of a debug result, or nil
+func (r *TaskResult) Debug() *DebugResult {
+	if r == nil {
+		return nil
+	}
+	debug, _ := r.Data.(*DebugResult)
+	return debug
+}
+
+// taskResultJSON is a TaskResult as written in JSON, with the kind of its
+// data
+type taskResultJSON struct {
+	Success bool            `json:"success"`
+	Kind    ResultKind      `json:"kind,omitempty"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error,omitempty"`
+	Usage   *Usage          `json:"usage,omitempty"`
+}
+
+// MarshalJSON writes the kind of the result's data next to it
+func (r TaskResult) MarshalJSON() ([]byte, error) {
+	out := taskResultJSON{Success: r.Success, Error: r.Error, Usage: r.Usage, Data: json.RawMessage("null")}
+	if r.Data != nil {
+		data, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		out.Kind, out.Data = r.Data.Kind(), data
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the data of a result by its kind, so results from
+// workers and clients keep their types
+func (r *TaskResult) UnmarshalJSON(data []byte) error {
+	var in taskResultJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = TaskResult{Success: in.Success, Error: in.Error, Usage: in.Usage}
+	if len(in.Data) == 0 || string(in.Data) == "null" {
+		return nil
+	}
+	var payload ResultData
+	switch in.Kind {
+	case ResultPlan:
+		payload = &PlanResult{}
+	case ResultFileOp:
+		payload = &FileOpResult{}
+	case ResultCommand:
+		payload = &CommandResult{}
+	case ResultDebug:
+		payload = &DebugResult{}
+	case ResultFields, "":
+		var fields Fields
+		if err := json.Unmarshal(in.Data, &fields); err != nil {
+			return fmt.Errorf("failed to decode result data: %w", err)
+		}
+		r.Data = fields
+		return nil
+	default:
+		return fmt.Errorf("unknown result kind %q", in.Kind)
+	}
+	if err := json.Unmarshal(in.Data, payload); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", in.Kind, err)
+	}
+	r.Data = payload
+	return nil
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// retrievedChunks is how many index chunks code generation prompts include
+const retrievedChunks = 8
+
+// Covers reports whether dir lies within the indexed workspace; an empty
+// dir means the default workspace
+func (ci *CodeIndex) Covers(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	rel, err := filepath.Rel(ci.root, absPath(dir))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// generateCode answers a code-generation request with the most relevant
+// indexed code in the prompt, so the result follows the project's existing
+// patterns and naming rather than generic ones
+func (s *System) generateCode(ctx context.Context, request, workspaceDir, history string) (*TaskResult, error) {
+	start := time.Now()
+	assembler := NewContextAssembler(s.budgets.For(s.llmClient.GetModel()), s.llmClient, s.logger)
+	assembler.Add(ContextPiece{Content: s.profiles.Prompt(workspaceDir), Priority: PriorityCritical, Required: true})
+	assembler.Add(ContextPiece{Label: "Conversation so far", Content: history, Priority: PriorityHigh + 1})
+
+	citations := make(map[string]Citation)
+	if s.index.Covers(workspaceDir) {
+		chunks, err := s.index.Search(ctx, request, retrievedChunks)
+		if err != nil {
+			// Generating without project context beats failing the request
+			s.logger.Warn("Failed to retrieve code context", zap.Error(err))
+		} else if len(chunks) > 0 {
+			assembler.Add(ContextPiece{
+				Content:  "Existing code from this project, most relevant first. Match its conventions, naming, error handling and package layout, and reuse its helpers where they fit:",
+				Priority: PriorityHigh,
+			})
+			for i, chunk := range chunks {
+				// Better matches outrank weaker ones when the budget is tight
+				label := fmt.Sprintf("%s (lines %d-%d)", chunk.Path, chunk.StartLine, chunk.EndLine)
+				assembler.Add(ContextPiece{
+					Label:     label,
+					Content:   chunk.Content,
+					Priority:  PriorityHigh - 1 - i,
+					Untrusted: true,
+				})
+				citations[label] = Citation{File: chunk.Path, StartLine: chunk.StartLine, EndLine: chunk.EndLine}
+			}
+		}
+	}
+
+	codeContext, report := assembler.Assemble(ctx)
+	// Cite only the chunks that made it into the prompt
+	sources := []Citation{}
+	for _, label := range append(report.Included, report.Truncated...) {
+		if citation, ok := citations[label]; ok {
+			sources = append(sources, citation)
+		}
+	}
+	code, err := s.llmClient.GenerateCode(ctx, request, codeContext)
+	if err != nil {
+		return &TaskResult{
+			Success: false,
+			Error:   err.Error(),
+		}, nil
+	}
+	data := Fields{
+		"code":    code,
+		"sources": sources,
+		"context": report,
+	}
+	if len(report.Injections) > 0 {
+		s.logger.Warn("Retrieved code appears to contain instructions to the model", zap.Int("count", len(report.Injections)))
+		data["injection_warnings"] = report.Injections
+	}
+	s.logger.Debug("Generated code", zap.Int("context_chunks", len(sources)), zap.Int("context_tokens", report.UsedTokens), zap.Duration("duration", time.Since(start)))
+	return &TaskResult{Success: true, Data: data}, nil
+}
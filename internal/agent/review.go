@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// ReviewSeverity ranks review comments
+type ReviewSeverity string
+
+const (
+	ReviewInfo     ReviewSeverity = "info"
+	ReviewMinor    ReviewSeverity = "minor"
+	ReviewMajor    ReviewSeverity = "major"
+	ReviewCritical ReviewSeverity = "critical"
+)
+
+// rank orders review severities for comparison
+func (s ReviewSeverity) rank() int {
+	switch s {
+	case ReviewCritical:
+		return 3
+	case ReviewMajor:
+		return 2
+	case ReviewMinor:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ReviewComment is one finding of a code review
+type ReviewComment struct {
+	File       string         `json:"file"`
+	Line       int            `json:"line,omitempty"`
+	Severity   ReviewSeverity `json:"severity"`
+	Message    string         `json:"message"`
+	Suggestion string         `json:"suggestion,omitempty"`
+}
+
+// CodeReview is the structured result of reviewing a diff
+type CodeReview struct {
+	Summary  string          `json:"summary"`
+	Comments []ReviewComment `json:"comments"`
+}
+
+// maxReviewChunkChars bounds the diff text sent to the LLM in one request
+const maxReviewChunkChars = 16000
+
+// CodeReviewAgent reviews diffs and returns structured comments
+type CodeReviewAgentImpl struct {
+	llmClient LLMClient
+	tools     *ToolRunner
+	logger    *zap.Logger
+}
+
+// NewCodeReviewAgent creates a new code review agent diffing with tools
+func NewCodeReviewAgent(llmClient LLMClient, tools *ToolRunner, logger *zap.Logger) *CodeReviewAgentImpl {
+	return &CodeReviewAgentImpl{
+		llmClient: llmClient,
+		tools:     tools,
+		logger:    logger,
+	}
+}
+
+// Type returns the agent type
+func (c *CodeReviewAgentImpl) Type() AgentType {
+	return CodeReviewAgent
+}
+
+// Execute reviews the diff in "diff", or the git range "base".."head"
+// (head defaults to the working tree), or, with neither, the uncommitted
+// changes against HEAD. With "fail_on" set to a severity, the task fails
+// if any comment reaches it, so the review can gate other work.
+func (c *CodeReviewAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	c.logger.Info("Code review agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+
+	diff, err := c.reviewDiff(ctx, task, workspaceDir)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	if strings.TrimSpace(diff) == "" {
+		return &TaskResult{
+			Success: true,
+			Data:    Fields{"review": &CodeReview{Summary: "No changes to review.", Comments: []ReviewComment{}}},
+		}, nil
+	}
+
+	review := &CodeReview{Comments: []ReviewComment{}}
+	var summaries []string
+	for _, chunk := range splitDiff(diff, maxReviewChunkChars) {
+		part, err := c.reviewChunk(ctx, chunk, stringField(task.Data, "instructions"))
+		if err != nil {
+			return nil, err
+		}
+		if part.Summary != "" {
+			summaries = append(summaries, part.Summary)
+		}
+		review.Comments = append(review.Comments, part.Comments...)
+	}
+	review.Summary = strings.Join(summaries, "\n\n")
+	sort.SliceStable(review.Comments, func(i, j int) bool {
+		return review.Comments[i].Severity.rank() > review.Comments[j].Severity.rank()
+	})
+
+	result := &TaskResult{
+		Success: true,
+		Data:    Fields{"review": review},
+	}
+	if failOn := ReviewSeverity(stringField(task.Data, "fail_on")); failOn != "" {
+		for _, comment := range review.Comments {
+			if comment.Severity.rank() >= failOn.rank() {
+				result.Success = false
+				result.Error = fmt.Sprintf("review found %s issues", comment.Severity)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// reviewDiff returns the diff a review task refers to
+func (c *CodeReviewAgentImpl) reviewDiff(ctx context.Context, task *Task, workspaceDir string) (string, error) {
+	if diff, ok := task.Data["diff"].(string); ok && diff != "" {
+		return diff, nil
+	}
+	args := []string{"diff", "--no-color"}
+	if base := stringField(task.Data, "base"); base != "" {
+		if err := checkGitRef(base); err != nil {
+			return "", err
+		}
+		if head := stringField(task.Data, "head"); head != "" {
+			args = append(args, base+"..."+head)
+		} else {
+			args = append(args, base)
+		}
+	} else {
+		args = append(args, "HEAD")
+	}
+	return c.tools.git(ctx, workspaceDir, args...)
+}
+
+// splitDiff splits a unified diff at file boundaries into chunks of at most
+// limit characters. A single file larger than limit is truncated.
+func splitDiff(diff string, limit int) []string {
+	var files []string
+	for _, part := range strings.Split(diff, "\ndiff --git ") {
+		if len(files) > 0 {
+			part = "diff --git " + part
+		}
+		files = append(files, truncateString(part, limit))
+	}
+
+	var chunks []string
+	var current strings.Builder
+	for _, file := range files {
+		if current.Len() > 0 && current.Len()+len(file) > limit {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(file)
+		current.WriteString("\n")
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// reviewChunk asks the LLM to review one part of a diff
+func (c *CodeReviewAgentImpl) reviewChunk(ctx context.Context, diff, instructions string) (*CodeReview, error) {
+	extra := ""
+	if instructions != "" {
+		extra = "\nReviewer instructions: " + instructions + "\n"
+	}
+	prompt := fmt.Sprintf(`Review this diff for bugs, security issues, error handling, performance and readability.
+Only comment on changed lines; reference line numbers in the new version of the file.
+%s
+Respond with only JSON:
+{"summary": "<overall assessment>",
+ "comments": [{"file": "<path>", "line": <number>, "severity": "info|minor|major|critical", "message": "<the issue>", "suggestion": "<how to fix it>"}]}
+
+%s`, extra, diff)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a meticulous senior engineer doing code review. Be specific and avoid nitpicks that don't matter."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	response, err := c.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review diff: %w", err)
+	}
+
+	var review CodeReview
+	if err := json.Unmarshal([]byte(extractJSON(response)), &review); err != nil {
+		return nil, fmt.Errorf("failed to parse review from LLM response: %w", err)
+	}
+	for i := range review.Comments {
+		severity := ReviewSeverity(strings.ToLower(string(review.Comments[i].Severity)))
+		switch severity {
+		case ReviewInfo, ReviewMinor, ReviewMajor, ReviewCritical:
+		default:
+			severity = ReviewInfo
+		}
+		review.Comments[i].Severity = severity
+	}
+	return &review, nil
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/llm"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// RulesFile holds a workspace's rules, relative to its root
+	RulesFile = ".spilot/rules.md"
+	// maxRulesBytes caps the rules injected into each prompt
+	maxRulesBytes = 16 << 10
+)
+
+// WorkspaceRules are the instructions that apply to every prompt about a
+// workspace: coding style, forbidden libraries, deployment constraints
+type WorkspaceRules struct {
+	// Instructions come from the configuration and apply to every workspace
+	Instructions string `json:"instructions,omitempty"`
+	// File is the workspace's rules file, if it has one
+	File    string `json:"file,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Prompt returns the rules as system prompt text, or "" if there are none
+func (r WorkspaceRules) Prompt() string {
+	parts := make([]string, 0, 2)
+	if r.Instructions != "" {
+		parts = append(parts, r.Instructions)
+	}
+	if r.Content != "" {
+		parts = append(parts, r.Content)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Rules for this project. Always follow them; they take precedence over general conventions:\n" +
+		truncateString(strings.Join(parts, "\n\n"), maxRulesBytes)
+}
+
+type cachedRules struct {
+	modTime time.Time
+	content string
+}
+
+// RulesStore reads workspace rules files, re-reading them when they change
+type RulesStore struct {
+	mu           sync.Mutex
+	instructions string
+	files        map[string]cachedRules
+	logger       *zap.Logger
+}
+
+// NewRulesStore creates a store adding instructions to every workspace's
+// rules
+func NewRulesStore(instructions string, logger *zap.Logger) *RulesStore {
+	return &RulesStore{
+		instructions: strings.TrimSpace(instructions),
+		files:        make(map[string]cachedRules),
+		logger:       logger,
+	}
+}
+
+// SetInstructions replaces the instructions added to every workspace's rules
+func (s *RulesStore) SetInstructions(instructions string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instructions = strings.TrimSpace(instructions)
+}
+
+// Get returns the rules of a workspace
+func (s *RulesStore) Get(workspaceDir string) WorkspaceRules {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := WorkspaceRules{Instructions: s.instructions}
+	path := filepath.Join(absPath(workspaceDir), filepath.FromSlash(RulesFile))
+	info, err := os.Stat(path)
+	if err != nil {
+		return rules
+	}
+
+	cached, ok := s.files[path]
+	if !ok || !cached.modTime.Equal(info.ModTime()) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			s.logger.Warn("Failed to read rules file", zap.String("path", path), zap.Error(err))
+			return rules
+		}
+		cached = cachedRules{modTime: info.ModTime(), content: strings.TrimSpace(string(data))}
+		s.files[path] = cached
+	}
+	rules.File = path
+	rules.Content = cached.content
+	return rules
+}
+
+// withRules makes the LLM calls made with ctx follow the rules of
+// workspaceDir. Rules already in ctx, e.g. those of the request a task
+// belongs to, are kept.
+func (s *System) withRules(ctx context.Context, workspaceDir string) context.Context {
+	if s.rules == nil || llm.Instructions(ctx) != "" {
+		return ctx
+	}
+	if workspaceDir == "" {
+		workspaceDir = "."
+	}
+	if prompt := s.rules.Get(workspaceDir).Prompt(); prompt != "" {
+		return llm.WithInstructions(ctx, prompt)
+	}
+	return ctx
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// RiskLevel classifies how dangerous a command is
+type RiskLevel string
+
+const (
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+// rank orders risk levels for comparison
+func (r RiskLevel) rank() int {
+	switch r {
+	case RiskHigh:
+		return 2
+	case RiskMedium:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ParseRiskLevel validates a risk level name
+func ParseRiskLevel(name string) (RiskLevel, error) {
+	switch level := RiskLevel(strings.ToLower(name)); level {
+	case RiskLow, RiskMedium, RiskHigh:
+		return level, nil
+	default:
+		return "", fmt.Errorf("invalid risk level: %s", name)
+	}
+}
+
+// RiskAssessment is the result of a safety check
+type RiskAssessment struct {
+	Level   RiskLevel `json:"level"`
+	Reasons []string  `json:"reasons,omitempty"`
+}
+
+// raise records a finding, keeping the highest level seen
+func (a *RiskAssessment) raise(level RiskLevel, reason string) {
+	if level.rank() > a.Level.rank() {
+		a.Level = level
+	}
+	a.Reasons = append(a.Reasons, reason)
+}
+
+type safetyRule struct {
+	pattern *regexp.Regexp
+	level   RiskLevel
+	reason  string
+}
+
+// safetyRules are the built-in patterns for destructive commands
+var safetyRules = []safetyRule{
+	{regexp.MustCompile(`\brm\s+(-\w+\s+)*-\w*(rf|fr)\w*\s+(--no-preserve-root\s+)?(/|~|\$HOME|/\*|\*)(\s|$|;|&)`), RiskHigh, "recursive delete of root, home or everything in the directory"},
+	{regexp.MustCompile(`\brm\s+(-\w+\s+)*-\w*(rf|fr)\w*`), RiskMedium, "recursive forced delete"},
+	{regexp.MustCompile(`\bdd\b.*\bof=/dev/`), RiskHigh, "dd writing to a device"},
+	{regexp.MustCompile(`\bmkfs(\.\w+)?\b`), RiskHigh, "filesystem creation"},
+	{regexp.MustCompile(`>\s*/dev/(sd|hd|nvme|disk)`), RiskHigh, "redirect onto a block device"},
+	{regexp.MustCompile(`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`), RiskHigh, "fork bomb"},
+	{regexp.MustCompile(`\b(curl|wget|iwr|Invoke-WebRequest)\b[^|]*\|\s*(sudo\s+)?(sh|bash|zsh|python\d?|iex|Invoke-Expression)\b`), RiskHigh, "downloaded script piped into an interpreter"},
+	{regexp.MustCompile(`\b(shutdown|reboot|halt|poweroff)\b`), RiskHigh, "system shutdown or reboot"},
+	{regexp.MustCompile(`(?i)\bformat\s+[a-z]:`), RiskHigh, "drive format"},
+	{regexp.MustCompile(`(?i)\bRemove-Item\b.*-Recurse.*\s[a-z]:\\?(\s|$)`), RiskHigh, "recursive delete of a drive root"},
+	{regexp.MustCompile(`\bchmod\s+(-\w+\s+)*-R\w*\s+0?777\s+/`), RiskHigh, "recursive chmod 777 from root"},
+	{regexp.MustCompile(`\bchown\s+(-\w+\s+)*-R\w*\s+\S+\s+/(\s|$)`), RiskHigh, "recursive chown of root"},
+	{regexp.MustCompile(`\bsudo\b`), RiskMedium, "runs with elevated privileges"},
+	{regexp.MustCompile(`\bgit\s+push\b.*(--force|-f\b)`), RiskMedium, "force push"},
+	{regexp.MustCompile(`\bgit\s+(reset\s+--hard|clean\s+-\w*f)`), RiskMedium, "discards uncommitted work"},
+}
+
+// SafetyChecker assesses commands before they are executed
+type SafetyChecker struct {
+	llmClient LLMClient
+	useLLM    atomic.Bool
+	logger    *zap.Logger
+}
+
+// NewSafetyChecker creates a safety checker. When useLLM is set, commands
+// the pattern rules consider low risk are additionally classified by the LLM.
+func NewSafetyChecker(llmClient LLMClient, useLLM bool, logger *zap.Logger) *SafetyChecker {
+	checker := &SafetyChecker{
+		llmClient: llmClient,
+		logger:    logger,
+	}
+	checker.useLLM.Store(useLLM)
+	return checker
+}
+
+// SetUseLLM turns the LLM classification of low-risk commands on or off
+func (c *SafetyChecker) SetUseLLM(useLLM bool) {
+	c.useLLM.Store(useLLM)
+}
+
+// Check assesses the risk of a command
+func (c *SafetyChecker) Check(ctx context.Context, command string) *RiskAssessment {
+	assessment := &RiskAssessment{Level: RiskLow}
+	for _, rule := range safetyRules {
+		if rule.pattern.MatchString(command) {
+			assessment.raise(rule.level, rule.reason)
+		}
+	}
+
+	if c.useLLM.Load() && assessment.Level == RiskLow {
+		level, reason, err := c.classifyWithLLM(ctx, command)
+		if err != nil {
+			c.logger.Warn("LLM risk classification failed", zap.Error(err))
+		} else if level != RiskLow {
+			assessment.raise(level, reason)
+		}
+	}
+
+	return assessment
+}
+
+// classifyWithLLM asks the model for a risk level and a short reason
+func (c *SafetyChecker) classifyWithLLM(ctx context.Context, command string) (RiskLevel, string, error) {
+	prompt := fmt.Sprintf(`Classify the risk of running this shell command on a developer machine:
+
+%s
+
+Respond with one line: LOW, MEDIUM or HIGH, then a colon and a short reason.`, command)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a security reviewer for shell commands. Commands that destroy data, change system configuration, or download and run code are HIGH risk."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	response, err := c.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return "", "", err
+	}
+
+	label, reason, _ := strings.Cut(strings.TrimSpace(response), ":")
+	level, err := ParseRiskLevel(strings.TrimSpace(label))
+	if err != nil {
+		return "", "", fmt.Errorf("unexpected risk classification %q", response)
+	}
+	return level, "LLM: " + strings.TrimSpace(reason), nil
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SandboxConfig configures the container-based executor
+type SandboxConfig struct {
+	// Runtime is the container CLI, e.g. docker or podman
+	Runtime string
+	Image   string
+	CPUs    string
+	Memory  string
+	// Network is the container network mode; "none" disables networking
+	Network string
+}
+
+// SandboxExecutor runs commands inside a throwaway container with the
+// working directory bind-mounted at /workspace
+type SandboxExecutor struct {
+	local  *CommandExecutorImpl
+	config SandboxConfig
+	logger *zap.Logger
+}
+
+// NewSandboxExecutor creates a container-backed executor. Timeouts and
+// environment filtering follow the given executor config; commands always
+// run with sh inside the container.
+func NewSandboxExecutor(cfg ExecutorConfig, sandbox SandboxConfig, logger *zap.Logger) CommandExecutor {
+	return &SandboxExecutor{
+		local:  NewCommandExecutor(cfg).(*CommandExecutorImpl),
+		config: sandbox,
+		logger: logger,
+	}
+}
+
+// DefaultShell returns the shell used inside the container
+func (s *SandboxExecutor) DefaultShell() Shell {
+	return ShellSh
+}
+
+// ExecuteCommand executes a single command in a container
+func (s *SandboxExecutor) ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error) {
+	return s.ExecuteCommandStream(ctx, command, workingDir, opts, nil, nil)
+}
+
+// ExecuteCommandStream executes a single command in a container, streaming its output
+func (s *SandboxExecutor) ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error) {
+	absDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+
+	name := fmt.Sprintf("spilot-%d", time.Now().UnixNano())
+	args := s.runArgs(name, absDir, s.local.limits, s.local.egress.env(opts.Env), command, false)
+
+	result, err := s.local.run(ctx, command, workingDir, opts, func(ctx context.Context) (*exec.Cmd, error) {
+		cmd := exec.CommandContext(ctx, s.config.Runtime, args...)
+		cmd.Env = s.local.envFilter.environ(nil)
+		return cmd, nil
+	}, onStdout, onStderr)
+
+	// Killing the CLI does not stop the container, so remove it explicitly
+	if result != nil && (result.Status == "timeout" || result.Status == "cancelled") {
+		s.removeContainer(name)
+	}
+	return result, err
+}
+
+// containerCommand returns the container CLI command running command in
+// a container called name, for background processes and terminals that
+// outlive a single execution. CPU time limits make no sense for those, so
+// only the other limits apply. With tty the container gets a terminal and
+// an empty command starts an interactive shell.
+func (s *SandboxExecutor) containerCommand(name, workingDir string, env map[string]string, command string, tty bool) (*exec.Cmd, error) {
+	absDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	limits := s.local.limits
+	limits.CPUSeconds = 0
+
+	cmd := exec.Command(s.config.Runtime, s.runArgs(name, absDir, limits, s.local.egress.env(env), command, tty)...)
+	cmd.Env = s.local.envFilter.environ(nil)
+	return cmd, nil
+}
+
+// removeContainer removes a container whose CLI was killed, which leaves
+// the container running
+func (s *SandboxExecutor) removeContainer(name string) {
+	if err := exec.Command(s.config.Runtime, "rm", "-f", name).Run(); err != nil {
+		s.logger.Warn("Failed to remove sandbox container", zap.String("container", name), zap.Error(err))
+	}
+}
+
+// ExecuteCommands executes multiple commands, each in its own container
+func (s *SandboxExecutor) ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error) {
+	var results []*Command
+
+	for _, command := range commands {
+		result, err := s.ExecuteCommand(ctx, command, workingDir, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if result.Status != "completed" {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// runArgs builds the container run arguments
+func (s *SandboxExecutor) runArgs(name, workspace string, limits ResourceLimits, env map[string]string, command string, tty bool) []string {
+	network := s.config.Network
+	if s.local.egress.isolated() {
+		network = "none"
+	}
+	args := []string{"run", "--rm", "--name", name,
+		"-v", workspace + ":/workspace", "-w", "/workspace",
+		"--network", network,
+	}
+	if tty {
+		args = append(args, "-it")
+	}
+	if s.config.CPUs != "" {
+		args = append(args, "--cpus", s.config.CPUs)
+	}
+	if s.config.Memory != "" {
+		args = append(args, "--memory", s.config.Memory)
+	}
+	args = append(args, limits.containerArgs()...)
+
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		args = append(args, "-e", k+"="+env[k])
+	}
+
+	if command == "" {
+		return append(args, s.config.Image, "sh")
+	}
+	return append(args, s.config.Image, "sh", "-c", command)
+}
@@ -0,0 +1,445 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxSearchSnippets bounds the snippets retrieved for one question
+	maxSearchSnippets = 8
+	// searchSnippetRadius is the number of lines kept around a hit
+	searchSnippetRadius = 6
+	// maxSnippetLines caps how far nearby hits are merged into one snippet
+	maxSnippetLines = 40
+	// maxSearchFileBytes skips large generated or minified files
+	maxSearchFileBytes = 512 * 1024
+)
+
+// Citation points an answer at the code that supports it
+type Citation struct {
+	File      string `json:"file"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Symbol    string `json:"symbol,omitempty"`
+}
+
+// searchSnippet is a scored region of a file
+type searchSnippet struct {
+	Citation
+	score int
+	text  string
+}
+
+// searchableExtensions are the source and config files searched for answers
+var searchableExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".mjs": true,
+	".rs": true, ".java": true, ".kt": true, ".rb": true, ".php": true, ".cs": true, ".c": true,
+	".h": true, ".cpp": true, ".hpp": true, ".swift": true, ".scala": true, ".sh": true,
+	".yaml": true, ".yml": true, ".toml": true, ".json": true, ".md": true, ".sql": true,
+}
+
+// typeDeclPattern matches type, class, struct and interface declarations
+var typeDeclPattern = regexp.MustCompile(`^\s*(?:export\s+)?(?:pub\s+)?(?:type|class|struct|interface|enum|trait)\s+(\w+)`)
+
+// citationRef matches [n] references in an answer
+var citationRef = regexp.MustCompile(`\[(\d+)\]`)
+
+// searchStopwords are dropped from questions before matching
+var searchStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "where": true, "what": true, "how": true, "does": true,
+	"is": true, "are": true, "this": true, "that": true, "with": true, "which": true, "implemented": true,
+	"code": true, "logic": true, "file": true, "function": true, "defined": true, "used": true, "can": true,
+	"find": true, "show": true, "there": true, "when": true, "who": true, "why": true, "into": true,
+}
+
+// symbolQuestion matches questions naming a symbol to locate, such as
+// "where is Server.Start defined?" or "where is parseConfig used"
+var symbolQuestion = regexp.MustCompile(`(?i)^\s*where\s+(?:is|are)\s+(?:the\s+)?(?:func(?:tion)?|method|type|struct|class|interface|const(?:ant)?|var(?:iable)?|field)?\s*` + "`?" + `([A-Za-z_][\w.]*?)` + "`?" + `(?:\(\))?\s+(defined|declared|used|called|referenced)\b`)
+
+// SearchAgent answers questions about the codebase with file/line citations
+type SearchAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	symbols     *SymbolCache
+	logger      *zap.Logger
+}
+
+// NewSearchAgent creates a new search agent
+func NewSearchAgent(llmClient LLMClient, fileManager FileManager, symbols *SymbolCache, logger *zap.Logger) *SearchAgentImpl {
+	return &SearchAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		symbols:     symbols,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (s *SearchAgentImpl) Type() AgentType {
+	return SearchAgent
+}
+
+// Execute answers the question in "question", or looks up the definitions
+// (and, with "references", the uses) of the symbol in "symbol"
+func (s *SearchAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	s.logger.Info("Search agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	if symbol := stringField(task.Data, "symbol"); symbol != "" {
+		references, _ := task.Data["references"].(bool)
+		return s.lookupSymbol(ctx, workspaceDir, symbol, references)
+	}
+
+	question, ok := task.Data["question"].(string)
+	if !ok || strings.TrimSpace(question) == "" {
+		return nil, fmt.Errorf("question not found in task data")
+	}
+	// Questions about where a known symbol lives are answered from the
+	// symbol index instead of by the LLM
+	if m := symbolQuestion.FindStringSubmatch(question); m != nil {
+		if result := s.answerSymbolQuestion(ctx, workspaceDir, m[1], strings.ToLower(m[2])); result != nil {
+			return result, nil
+		}
+	}
+
+	terms := s.searchTerms(ctx, question)
+	snippets, err := s.retrieve(ctx, workspaceDir, terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search workspace: %w", err)
+	}
+	if len(snippets) == 0 {
+		return &TaskResult{
+			Success: true,
+			Data:    Fields{"answer": "No matching code was found in the workspace.", "citations": []Citation{}, "terms": terms},
+		}, nil
+	}
+
+	answer, citations, err := s.summarize(ctx, question, snippets)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"answer": answer, "citations": citations, "terms": terms},
+	}, nil
+}
+
+// searchTerms derives identifiers and keywords to look for. The LLM expands
+// the question into likely code terms; plain keywords are the fallback.
+func (s *SearchAgentImpl) searchTerms(ctx context.Context, question string) []string {
+	terms := questionKeywords(question)
+
+	prompt := fmt.Sprintf(`List identifiers and keywords likely to appear in source code that answers this question: %q
+Include synonyms (e.g. "retry" -> "backoff", "attempt"). Respond with only a JSON array of at most 10 lowercase strings.`, question)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You translate questions about a codebase into code search terms."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := s.llmClient.Chat(ctx, messages)
+	if err != nil {
+		s.logger.Debug("Search term expansion failed", zap.Error(err))
+		return terms
+	}
+	var expanded []string
+	if err := json.Unmarshal([]byte(extractJSON(response)), &expanded); err != nil {
+		return terms
+	}
+
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		seen[term] = true
+	}
+	for _, term := range expanded {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if len(term) >= 3 && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// questionKeywords splits a question into lowercase keywords, splitting
+// camelCase and snake_case identifiers
+func questionKeywords(question string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(word string) {
+		word = strings.ToLower(word)
+		if len(word) >= 3 && !searchStopwords[word] && !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+
+	words := strings.FieldsFunc(question, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range words {
+		add(word)
+		for _, part := range splitIdentifier(word) {
+			add(part)
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits camelCase and snake_case names into words
+func splitIdentifier(name string) []string {
+	var parts []string
+	var current []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == '_' {
+			parts, current = append(parts, string(current)), nil
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			parts, current = append(parts, string(current)), nil
+		}
+		current = append(current, r)
+	}
+	return append(parts, string(current))
+}
+
+// retrieve scans the workspace for lines matching terms and returns the
+// best scoring snippets. Declarations whose names contain a term score
+// highest, so symbol definitions outrank incidental mentions.
+func (s *SearchAgentImpl) retrieve(ctx context.Context, workspaceDir string, terms []string) ([]*searchSnippet, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	root := absPath(workspaceDir)
+
+	var snippets []*searchSnippet
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (skipDir(d.Name()) || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !searchableExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxSearchFileBytes {
+			return nil
+		}
+		content, err := fileManagerFor(ctx, s.fileManager).ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		snippets = append(snippets, scoreFile(filepath.ToSlash(rel), content, terms)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(snippets, func(i, j int) bool { return snippets[i].score > snippets[j].score })
+	if len(snippets) > maxSearchSnippets {
+		snippets = snippets[:maxSearchSnippets]
+	}
+	return snippets, nil
+}
+
+// scoreFile finds the regions of a file matching terms, merging nearby hits
+func scoreFile(path, content string, terms []string) []*searchSnippet {
+	lines := strings.Split(content, "\n")
+	lowerPath := strings.ToLower(path)
+
+	type hit struct {
+		line, score int
+		symbol      string
+	}
+	var hits []hit
+	for i, line := range lines {
+		lower := strings.ToLower(line)
+		score := 0
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				score++
+			}
+		}
+		if score == 0 {
+			continue
+		}
+
+		symbol := declaredFunction(line)
+		if symbol == "" {
+			if m := typeDeclPattern.FindStringSubmatch(line); m != nil {
+				symbol = m[1]
+			}
+		}
+		if symbol != "" {
+			for _, term := range terms {
+				if strings.Contains(strings.ToLower(symbol), term) {
+					score += 5
+				}
+			}
+		}
+		hits = append(hits, hit{line: i + 1, score: score, symbol: symbol})
+	}
+
+	var snippets []*searchSnippet
+	for _, h := range hits {
+		if n := len(snippets); n > 0 && h.line <= snippets[n-1].EndLine && h.line+searchSnippetRadius-snippets[n-1].StartLine < maxSnippetLines {
+			last := snippets[n-1]
+			last.score += h.score
+			last.EndLine = min(len(lines), h.line+searchSnippetRadius)
+			if last.Symbol == "" {
+				last.Symbol = h.symbol
+			}
+			continue
+		}
+		snippets = append(snippets, &searchSnippet{
+			Citation: Citation{
+				File:      path,
+				StartLine: max(1, h.line-searchSnippetRadius),
+				EndLine:   min(len(lines), h.line+searchSnippetRadius),
+				Symbol:    h.symbol,
+			},
+			score: h.score,
+		})
+	}
+
+	for _, snippet := range snippets {
+		for _, term := range terms {
+			if strings.Contains(lowerPath, term) {
+				snippet.score += 2
+			}
+		}
+		snippet.text = strings.Join(lines[snippet.StartLine-1:snippet.EndLine], "\n")
+	}
+	return snippets
+}
+
+// summarize asks the LLM to answer from the snippets, citing them as [n],
+// and returns the citations actually referenced
+func (s *SearchAgentImpl) summarize(ctx context.Context, question string, snippets []*searchSnippet) (string, []Citation, error) {
+	var excerpts strings.Builder
+	for i, snippet := range snippets {
+		fmt.Fprintf(&excerpts, "[%d] %s:%d-%d\n%s\n\n", i+1, snippet.File, snippet.StartLine, snippet.EndLine, snippet.text)
+	}
+
+	prompt := fmt.Sprintf(`Question: %s
+
+Code snippets:
+%s
+Answer the question using only these snippets. Cite every claim with the snippet number in brackets, e.g. [2].
+If the snippets don't contain the answer, say so.`, question, excerpts.String())
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You answer questions about a codebase precisely, citing the code you rely on."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	answer, err := s.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to answer question: %w", err)
+	}
+
+	citations := []Citation{}
+	cited := make(map[int]bool)
+	for _, m := range citationRef.FindAllStringSubmatch(answer, -1) {
+		n, _ := strconv.Atoi(m[1])
+		if n >= 1 && n <= len(snippets) && !cited[n] {
+			cited[n] = true
+			citations = append(citations, snippets[n-1].Citation)
+		}
+	}
+	return answer, citations, nil
+}
+
+// lookupSymbol returns the definitions of a symbol and optionally its
+// references; names without an exact match return similar symbols
+func (s *SearchAgentImpl) lookupSymbol(ctx context.Context, workspaceDir, name string, withReferences bool) (*TaskResult, error) {
+	index, err := s.symbols.Get(ctx, workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index symbols: %w", err)
+	}
+	definitions := index.Lookup(name)
+	if len(definitions) == 0 {
+		return &TaskResult{
+			Success: false,
+			Data:    Fields{"similar": index.Find(name, "", 10)},
+			Error:   fmt.Sprintf("symbol %s not found", name),
+		}, nil
+	}
+	data := Fields{"symbols": definitions}
+	if withReferences {
+		var references []SymbolReference
+		for _, def := range definitions {
+			refs, err := index.References(ctx, def, 200)
+			if err != nil {
+				return nil, err
+			}
+			references = append(references, refs...)
+		}
+		data["references"] = references
+	}
+	return &TaskResult{Success: true, Data: data}, nil
+}
+
+// answerSymbolQuestion answers "where is X defined/used" with citations, or
+// returns nil when X is not a known symbol
+func (s *SearchAgentImpl) answerSymbolQuestion(ctx context.Context, workspaceDir, name, verb string) *TaskResult {
+	index, err := s.symbols.Get(ctx, workspaceDir)
+	if err != nil {
+		s.logger.Debug("Symbol index unavailable", zap.Error(err))
+		return nil
+	}
+	definitions := index.Lookup(name)
+	if len(definitions) == 0 {
+		return nil
+	}
+
+	var answer strings.Builder
+	citations := []Citation{}
+	if verb == "defined" || verb == "declared" {
+		for _, def := range definitions {
+			citations = append(citations, Citation{File: def.File, StartLine: def.Line, EndLine: def.Line, Symbol: def.QualifiedName()})
+			fmt.Fprintf(&answer, "%s (%s) is defined in %s at line %d [%d].\n", def.QualifiedName(), def.Kind, def.File, def.Line, len(citations))
+		}
+	} else {
+		for _, def := range definitions {
+			refs, err := index.References(ctx, def, 50)
+			if err != nil {
+				s.logger.Debug("Failed to find references", zap.String("symbol", name), zap.Error(err))
+				return nil
+			}
+			fmt.Fprintf(&answer, "%s (%s, defined in %s:%d) has %d reference(s)", def.QualifiedName(), def.Kind, def.File, def.Line, len(refs))
+			if len(refs) == 0 {
+				answer.WriteString(".\n")
+				continue
+			}
+			answer.WriteString(":\n")
+			for _, ref := range refs {
+				citations = append(citations, Citation{File: ref.File, StartLine: ref.Line, EndLine: ref.Line, Symbol: def.QualifiedName()})
+				fmt.Fprintf(&answer, "- %s:%d `%s` [%d]\n", ref.File, ref.Line, truncateString(ref.Text, 120), len(citations))
+			}
+		}
+	}
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"answer": strings.TrimSpace(answer.String()), "citations": citations, "terms": []string{name}, "symbols": definitions},
+	}
+}
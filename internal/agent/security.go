@@ -0,0 +1,433 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// maxRemediations bounds the findings sent to the LLM for remediation
+const maxRemediations = 10
+
+// SecuritySeverity ranks security findings
+type SecuritySeverity string
+
+const (
+	SecurityLow      SecuritySeverity = "low"
+	SecurityMedium   SecuritySeverity = "medium"
+	SecurityHigh     SecuritySeverity = "high"
+	SecurityCritical SecuritySeverity = "critical"
+)
+
+// rank orders security severities for comparison
+func (s SecuritySeverity) rank() int {
+	switch s {
+	case SecurityCritical:
+		return 3
+	case SecurityHigh:
+		return 2
+	case SecurityMedium:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseSecuritySeverity maps scanner-specific severity names onto the common scale
+func parseSecuritySeverity(severity string) SecuritySeverity {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return SecurityCritical
+	case "high":
+		return SecurityHigh
+	case "medium", "moderate":
+		return SecurityMedium
+	default:
+		return SecurityLow
+	}
+}
+
+// SecurityFinding is a scanner finding in a scanner-independent schema.
+// Code findings set File and Line; dependency findings set Package.
+type SecurityFinding struct {
+	Scanner     string           `json:"scanner"`
+	RuleID      string           `json:"rule_id"`
+	Severity    SecuritySeverity `json:"severity"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	File        string           `json:"file,omitempty"`
+	Line        int              `json:"line,omitempty"`
+	Package     string           `json:"package,omitempty"`
+	Version     string           `json:"version,omitempty"`
+	FixedIn     string           `json:"fixed_in,omitempty"`
+	Remediation string           `json:"remediation,omitempty"`
+}
+
+// securityScanner is an external scanner and the parser for its JSON output
+type securityScanner struct {
+	name    string
+	command string
+	// applies reports whether the scanner is relevant to the workspace
+	applies func(workspaceDir string) bool
+	parse   func(output, workspaceDir string) ([]SecurityFinding, error)
+}
+
+var securityScanners = []securityScanner{
+	{"gosec", "gosec -quiet -fmt=json ./...", func(dir string) bool { return fileExists(dir, "go.mod") && onPath("gosec") }, parseGosec},
+	{"npm-audit", "npm audit --json", func(dir string) bool { return fileExists(dir, "package-lock.json") && onPath("npm") }, parseNpmAudit},
+	{"pip-audit", "pip-audit -f json", func(dir string) bool {
+		return (fileExists(dir, "requirements.txt") || fileExists(dir, "pyproject.toml")) && onPath("pip-audit")
+	}, parsePipAudit},
+	{"gitleaks", "gitleaks detect --no-git --no-banner --redact --report-format json --report-path -", func(dir string) bool { return onPath("gitleaks") }, parseGitleaks},
+}
+
+// SecurityScanAgent runs security scanners over the workspace and
+// normalizes their findings
+type SecurityScanAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	// scanners are the enabled scanner names; empty enables all
+	scanners []string
+	logger   *zap.Logger
+}
+
+// NewSecurityScanAgent creates a new security scan agent
+func NewSecurityScanAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, scanners []string, logger *zap.Logger) *SecurityScanAgentImpl {
+	return &SecurityScanAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		scanners:    scanners,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (s *SecurityScanAgentImpl) Type() AgentType {
+	return SecurityScanAgent
+}
+
+// Execute runs the applicable scanners ("scanners" narrows the configured
+// set). With "remediate" the most severe findings get LLM-proposed fixes;
+// with "fail_on" set to a severity the task fails if a finding reaches it.
+func (s *SecurityScanAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	s.logger.Info("Security scan agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+	enabled := s.scanners
+	if requested := stringList(task.Data, "scanners"); len(requested) > 0 {
+		enabled = requested
+	}
+
+	findings := []SecurityFinding{}
+	var ran, skipped []string
+	scanErrors := make(map[string]string)
+	for _, scanner := range securityScanners {
+		if len(enabled) > 0 && !slices.Contains(enabled, scanner.name) {
+			continue
+		}
+		if !scanner.applies(workspaceDir) {
+			skipped = append(skipped, scanner.name)
+			continue
+		}
+		ran = append(ran, scanner.name)
+
+		// Scanners exit non-zero when they find something, so only the
+		// output decides whether the run worked
+		result, err := s.commandExec.ExecuteCommand(ctx, scanner.command, workspaceDir, CommandOptions{})
+		if err != nil {
+			scanErrors[scanner.name] = err.Error()
+			continue
+		}
+		if result.Status == "timeout" || result.Status == "cancelled" {
+			scanErrors[scanner.name] = result.Error
+			continue
+		}
+		found, err := scanner.parse(result.Output, workspaceDir)
+		if err != nil {
+			scanErrors[scanner.name] = fmt.Sprintf("%v: %s", err, truncateString(result.Error, 500))
+			continue
+		}
+		findings = append(findings, found...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Severity.rank() > findings[j].Severity.rank() })
+
+	if remediate, _ := task.Data["remediate"].(bool); remediate {
+		for i := range findings {
+			if i == maxRemediations {
+				break
+			}
+			findings[i].Remediation = s.remediation(ctx, &findings[i], workspaceDir)
+		}
+	}
+
+	result := &TaskResult{
+		Success: true,
+		Data: Fields{
+			"findings": findings,
+			"scanners": ran,
+			"skipped":  skipped,
+			"errors":   scanErrors,
+			"summary":  summarizeFindings(findings),
+		},
+	}
+	if len(ran) == 0 {
+		result.Success = false
+		result.Error = "no applicable security scanners are installed"
+	} else if failOn := SecuritySeverity(stringField(task.Data, "fail_on")); failOn != "" {
+		for _, finding := range findings {
+			if finding.Severity.rank() >= failOn.rank() {
+				result.Success = false
+				result.Error = fmt.Sprintf("security scan found %s findings", finding.Severity)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// summarizeFindings counts findings per severity
+func summarizeFindings(findings []SecurityFinding) map[SecuritySeverity]int {
+	counts := make(map[SecuritySeverity]int)
+	for _, finding := range findings {
+		counts[finding.Severity]++
+	}
+	return counts
+}
+
+// remediation proposes a fix for a finding. Leaked secrets get fixed
+// advice without involving the LLM, so the secret never leaves the machine.
+func (s *SecurityScanAgentImpl) remediation(ctx context.Context, finding *SecurityFinding, workspaceDir string) string {
+	if finding.Scanner == "gitleaks" {
+		return "Revoke and rotate this credential now, remove it from the file (and from git history if it was committed), and load it from an environment variable or secret manager instead."
+	}
+
+	details := fmt.Sprintf("Scanner: %s\nRule: %s\nSeverity: %s\nTitle: %s\nDescription: %s\n",
+		finding.Scanner, finding.RuleID, finding.Severity, finding.Title, finding.Description)
+	if finding.Package != "" {
+		details += fmt.Sprintf("Package: %s %s (fixed in: %s)\n", finding.Package, finding.Version, finding.FixedIn)
+	}
+	if finding.File != "" && finding.Line > 0 {
+		if content, err := fileManagerFor(ctx, s.fileManager).ReadFile(filepath.Join(workspaceDir, finding.File)); err == nil {
+			details += fmt.Sprintf("Code around %s:%d:\n%s\n", finding.File, finding.Line, surroundingLines(content, finding.Line, 6))
+		}
+	}
+
+	prompt := fmt.Sprintf(`Propose a remediation for this security finding. Explain the risk in one or two sentences, then give the concrete fix (code change, upgrade command or configuration). If it is likely a false positive, say why.
+
+%s`, details)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an application security engineer who gives precise, minimal fixes."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := s.llmClient.Chat(ctx, messages)
+	if err != nil {
+		s.logger.Warn("Failed to generate remediation", zap.String("rule", finding.RuleID), zap.Error(err))
+		return ""
+	}
+	return strings.TrimSpace(response)
+}
+
+// surroundingLines returns the numbered lines within radius of line
+func surroundingLines(content string, line, radius int) string {
+	lines := strings.Split(content, "\n")
+	start, end := max(1, line-radius), min(len(lines), line+radius)
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&b, "%4d | %s\n", i, lines[i-1])
+	}
+	return b.String()
+}
+
+// relativeTo makes a scanner-reported path relative to the workspace
+func relativeTo(workspaceDir, path string) string {
+	if filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(absPath(workspaceDir), path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+func parseGosec(output, workspaceDir string) ([]SecurityFinding, error) {
+	var report struct {
+		Issues []struct {
+			Severity string `json:"severity"`
+			RuleID   string `json:"rule_id"`
+			Details  string `json:"details"`
+			File     string `json:"file"`
+			Line     string `json:"line"`
+			CWE      struct {
+				ID string `json:"id"`
+			} `json:"cwe"`
+		} `json:"Issues"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &report); err != nil {
+		return nil, fmt.Errorf("failed to parse gosec output: %w", err)
+	}
+	findings := make([]SecurityFinding, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		// Multi-line issues report "start-end"
+		start, _, _ := strings.Cut(issue.Line, "-")
+		line, _ := strconv.Atoi(start)
+		finding := SecurityFinding{
+			Scanner:  "gosec",
+			RuleID:   issue.RuleID,
+			Severity: parseSecuritySeverity(issue.Severity),
+			Title:    issue.Details,
+			File:     relativeTo(workspaceDir, issue.File),
+			Line:     line,
+		}
+		if issue.CWE.ID != "" {
+			finding.Description = "CWE-" + issue.CWE.ID
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+func parseNpmAudit(output, workspaceDir string) ([]SecurityFinding, error) {
+	var report struct {
+		Vulnerabilities map[string]struct {
+			Name         string            `json:"name"`
+			Severity     string            `json:"severity"`
+			Range        string            `json:"range"`
+			Via          []json.RawMessage `json:"via"`
+			FixAvailable json.RawMessage   `json:"fixAvailable"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &report); err != nil {
+		return nil, fmt.Errorf("failed to parse npm audit output: %w", err)
+	}
+
+	names := make([]string, 0, len(report.Vulnerabilities))
+	for name := range report.Vulnerabilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var findings []SecurityFinding
+	for _, name := range names {
+		vuln := report.Vulnerabilities[name]
+		var fix struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}
+		fixedIn := ""
+		if json.Unmarshal(vuln.FixAvailable, &fix) == nil && fix.Name != "" {
+			fixedIn = fix.Name + "@" + fix.Version
+		}
+		// "via" holds advisories, or names of vulnerable dependencies that
+		// are reported separately
+		for _, via := range vuln.Via {
+			var advisory struct {
+				Source int    `json:"source"`
+				Title  string `json:"title"`
+				URL    string `json:"url"`
+			}
+			if json.Unmarshal(via, &advisory) != nil || advisory.Title == "" {
+				continue
+			}
+			ruleID := advisory.URL
+			if ruleID == "" {
+				ruleID = strconv.Itoa(advisory.Source)
+			}
+			findings = append(findings, SecurityFinding{
+				Scanner:     "npm-audit",
+				RuleID:      ruleID,
+				Severity:    parseSecuritySeverity(vuln.Severity),
+				Title:       advisory.Title,
+				Description: advisory.URL,
+				Package:     vuln.Name,
+				Version:     vuln.Range,
+				FixedIn:     fixedIn,
+			})
+		}
+	}
+	return findings, nil
+}
+
+func parsePipAudit(output, workspaceDir string) ([]SecurityFinding, error) {
+	type dependency struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Vulns   []struct {
+			ID          string   `json:"id"`
+			FixVersions []string `json:"fix_versions"`
+			Description string   `json:"description"`
+			Aliases     []string `json:"aliases"`
+		} `json:"vulns"`
+	}
+	// Newer versions wrap the list in {"dependencies": [...]}
+	var report struct {
+		Dependencies []dependency `json:"dependencies"`
+	}
+	raw := []byte(extractJSON(output))
+	if err := json.Unmarshal(raw, &report); err != nil {
+		if err := json.Unmarshal(raw, &report.Dependencies); err != nil {
+			return nil, fmt.Errorf("failed to parse pip-audit output: %w", err)
+		}
+	}
+
+	var findings []SecurityFinding
+	for _, dep := range report.Dependencies {
+		for _, vuln := range dep.Vulns {
+			title := vuln.ID
+			if len(vuln.Aliases) > 0 {
+				title += " (" + strings.Join(vuln.Aliases, ", ") + ")"
+			}
+			// pip-audit doesn't report severity
+			findings = append(findings, SecurityFinding{
+				Scanner:     "pip-audit",
+				RuleID:      vuln.ID,
+				Severity:    SecurityMedium,
+				Title:       title,
+				Description: truncateString(vuln.Description, 1000),
+				Package:     dep.Name,
+				Version:     dep.Version,
+				FixedIn:     strings.Join(vuln.FixVersions, ", "),
+			})
+		}
+	}
+	return findings, nil
+}
+
+func parseGitleaks(output, workspaceDir string) ([]SecurityFinding, error) {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil, nil
+	}
+	var leaks []struct {
+		Description string `json:"Description"`
+		RuleID      string `json:"RuleID"`
+		File        string `json:"File"`
+		StartLine   int    `json:"StartLine"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &leaks); err != nil {
+		return nil, fmt.Errorf("failed to parse gitleaks output: %w", err)
+	}
+	findings := make([]SecurityFinding, 0, len(leaks))
+	for _, leak := range leaks {
+		findings = append(findings, SecurityFinding{
+			Scanner:  "gitleaks",
+			RuleID:   leak.RuleID,
+			Severity: SecurityCritical,
+			Title:    leak.Description,
+			File:     relativeTo(workspaceDir, leak.File),
+			Line:     leak.StartLine,
+		})
+	}
+	return findings, nil
+}
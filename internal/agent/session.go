@@ -0,0 +1,453 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxSessionMessages caps the history kept per session; older
+	// messages are discarded
+	maxSessionMessages = 200
+	// historyMessages is how many recent messages are offered to prompts
+	historyMessages = 20
+	// historyShare is the fraction of a model's context budget that
+	// conversation history may use
+	historyShare = 4
+)
+
+// ErrSessionNotFound is returned for unknown session IDs
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionIDPattern guards file names derived from session IDs
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// SessionMessage is one turn of a conversation
+type SessionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// TaskID links an assistant message to the task that produced it
+	TaskID    string    `json:"task_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a conversation whose requests share context
+type Session struct {
+	ID           string `json:"id"`
+	Title        string `json:"title,omitempty"`
+	WorkspaceDir string `json:"workspace_dir,omitempty"`
+	// Owner is the user the session belongs to when users are isolated
+	Owner     string           `json:"owner,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Messages  []SessionMessage `json:"messages"`
+}
+
+// SessionSummary describes a session without its messages
+type SessionSummary struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title,omitempty"`
+	WorkspaceDir string    `json:"workspace_dir,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	Messages     int       `json:"messages"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// HistoryConfig sets how sessions, the prompts and replies of the agent's
+// history, are kept: compressed with zstd on disk, and deleted once MaxAge
+// passes without activity or when more than MaxSessions are more recently
+// active. 0 keeps them.
+type HistoryConfig struct {
+	Compress    bool
+	MaxAge      time.Duration
+	MaxSessions int
+}
+
+// SessionStore keeps sessions in memory and, when it has a directory, as
+// one JSON file each so conversations survive restarts
+type SessionStore struct {
+	mu       sync.Mutex
+	dir      string
+	sessions map[string]*Session
+	history  HistoryConfig
+	// sealer encrypts session files; nil stores them in the clear
+	sealer *Sealer
+	logger *zap.Logger
+}
+
+// NewSessionStore loads the sessions stored under dataDir/sessions and
+// deletes those history no longer keeps; an empty dataDir keeps sessions
+// in memory only. Files written before compression was enabled stay
+// readable and are compressed when next written.
+func NewSessionStore(dataDir string, history HistoryConfig, sealer *Sealer, logger *zap.Logger) (*SessionStore, error) {
+	store := &SessionStore{sessions: make(map[string]*Session), history: history, sealer: sealer, logger: logger}
+	if dataDir == "" {
+		return store, nil
+	}
+	store.dir = filepath.Join(dataDir, "sessions")
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			data, err = sealer.Open(data)
+		}
+		if err == nil {
+			data, err = decompress(data)
+		}
+		if err != nil {
+			logger.Warn("Skipping unreadable session", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil || !sessionIDPattern.MatchString(session.ID) {
+			logger.Warn("Skipping unreadable session", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		store.sessions[session.ID] = &session
+	}
+	store.prune(time.Now())
+	return store, nil
+}
+
+// Create starts a new session belonging to owner, who may be empty
+func (s *SessionStore) Create(owner, title, workspaceDir string) (*Session, error) {
+	now := time.Now()
+	session := &Session{
+		ID:           fmt.Sprintf("session_%d", now.UnixNano()),
+		Title:        title,
+		WorkspaceDir: workspaceDir,
+		Owner:        owner,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Messages:     []SessionMessage{},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(session); err != nil {
+		return nil, err
+	}
+	s.sessions[session.ID] = session
+	s.prune(now)
+	return session.copy(), nil
+}
+
+// Get returns a copy of a session
+func (s *SessionStore) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.copy(), nil
+}
+
+// List returns the sessions of owner, or all sessions if owner is empty,
+// most recently active first
+func (s *SessionStore) List(owner string) []SessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make([]SessionSummary, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if owner != "" && session.Owner != owner {
+			continue
+		}
+		summaries = append(summaries, SessionSummary{
+			ID:           session.ID,
+			Title:        session.Title,
+			WorkspaceDir: session.WorkspaceDir,
+			Owner:        session.Owner,
+			Messages:     len(session.Messages),
+			CreatedAt:    session.CreatedAt,
+			UpdatedAt:    session.UpdatedAt,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt) })
+	return summaries
+}
+
+// Delete removes a session
+func (s *SessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	return s.remove(id)
+}
+
+// remove deletes a session and its file; s.mu is held
+func (s *SessionStore) remove(id string) error {
+	delete(s.sessions, id)
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// prune deletes the sessions inactive for longer than MaxAge and those
+// beyond the MaxSessions most recently active; s.mu is held
+func (s *SessionStore) prune(now time.Time) {
+	if s.history.MaxAge <= 0 && s.history.MaxSessions <= 0 {
+		return
+	}
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt) })
+	pruned := 0
+	for i, session := range sessions {
+		expired := s.history.MaxAge > 0 && now.Sub(session.UpdatedAt) > s.history.MaxAge
+		if !expired && (s.history.MaxSessions <= 0 || i < s.history.MaxSessions) {
+			continue
+		}
+		if err := s.remove(session.ID); err != nil {
+			s.logger.Warn("Failed to delete session", zap.String("session_id", session.ID), zap.Error(err))
+			continue
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		s.logger.Info("Deleted sessions past history retention", zap.Int("sessions", pruned))
+	}
+}
+
+// Append adds messages to a session
+func (s *SessionStore) Append(id string, messages ...SessionMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	now := time.Now()
+	for _, message := range messages {
+		if message.CreatedAt.IsZero() {
+			message.CreatedAt = now
+		}
+		session.Messages = append(session.Messages, message)
+	}
+	if len(session.Messages) > maxSessionMessages {
+		session.Messages = session.Messages[len(session.Messages)-maxSessionMessages:]
+	}
+	session.UpdatedAt = now
+	if session.Title == "" && len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleUser {
+		session.Title = truncateString(strings.SplitN(messages[0].Content, "\n", 2)[0], 80)
+	}
+	return s.save(session)
+}
+
+func (s *SessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *SessionStore) save(session *Session) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err == nil && s.history.Compress {
+		// Compress before sealing; sealed data does not compress
+		data = compress(data)
+	}
+	if err == nil {
+		data, err = s.sealer.Seal(data)
+	}
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half-written session
+	tmp := s.path(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return os.Rename(tmp, s.path(session.ID))
+}
+
+func (session *Session) copy() *Session {
+	c := *session
+	c.Messages = append([]SessionMessage(nil), session.Messages...)
+	return &c
+}
+
+// recent returns the last n messages
+func (session *Session) recent(n int) []SessionMessage {
+	if len(session.Messages) <= n {
+		return session.Messages
+	}
+	return session.Messages[len(session.Messages)-n:]
+}
+
+type sessionKey struct{}
+
+// ContextWithSession makes requests processed with ctx part of a session
+func ContextWithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// sessionFrom returns the session ID carried by ctx
+func sessionFrom(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+// CreateSession starts a session for the requester of ctx. When users are
+// isolated, the session belongs to the requester and its workspace must be
+// theirs.
+func (s *System) CreateSession(ctx context.Context, title, workspaceDir string) (*Session, error) {
+	workspaceDir, err := s.TenantWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	return s.sessions.Create(s.tenant(ctx), title, workspaceDir)
+}
+
+// Session returns a session the requester of ctx may see. When users are
+// isolated, other users' sessions are reported as not found.
+func (s *System) Session(ctx context.Context, id string) (*Session, error) {
+	session, err := s.sessions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if owner := s.tenant(ctx); owner != "" && session.Owner != owner {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions the requester of ctx may see, most
+// recently active first
+func (s *System) ListSessions(ctx context.Context) []SessionSummary {
+	return s.sessions.List(s.tenant(ctx))
+}
+
+// DeleteSession deletes a session the requester of ctx may see
+func (s *System) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.Session(ctx, id); err != nil {
+		return err
+	}
+	return s.sessions.Delete(id)
+}
+
+// sessionHistory formats the recent messages of a session for a prompt,
+// within a share of the model's context budget. Older turns are summarized
+// or dropped first.
+func (s *System) sessionHistory(ctx context.Context, session *Session) string {
+	messages := session.recent(historyMessages)
+	if len(messages) == 0 {
+		return ""
+	}
+	assembler := NewContextAssembler(s.budgets.For(s.llmClient.GetModel())/historyShare, s.llmClient, s.logger)
+	assembler.PreserveOrder()
+	for i, message := range messages {
+		assembler.Add(ContextPiece{
+			Label:     fmt.Sprintf("%d. %s", i+1, message.Role),
+			Content:   message.Content,
+			Priority:  PriorityLow + i,
+			Summarize: true,
+		})
+	}
+	history, _ := assembler.Assemble(ctx)
+	return history
+}
+
+// resultMessage summarizes a task result as the assistant's reply in a session
+func resultMessage(result *TaskResult) string {
+	if result == nil {
+		return ""
+	}
+	if !result.Success {
+		if result.Error != "" {
+			return "Failed: " + result.Error
+		}
+		return "Failed."
+	}
+	text := ""
+	switch data := result.Data.(type) {
+	case *PlanResult:
+		text = data.Plan
+	case *CommandResult:
+		text = data.Command
+	case *DebugResult:
+		text = data.Analysis
+	case Fields:
+		for _, key := range []string{"code", "plan", "answer", "explanation", "analysis", "command", "output"} {
+			if value, ok := data[key].(string); ok && value != "" {
+				text = value
+				break
+			}
+		}
+	}
+	if text != "" {
+		return truncateString(text, 8000)
+	}
+	data, err := json.Marshal(result.Data)
+	if err != nil {
+		return "Done."
+	}
+	return truncateString(string(data), 8000)
+}
+
+// Chat answers a message conversationally. Within a session, the reply
+// takes the session's history into account and both are recorded.
+func (s *System) Chat(ctx context.Context, message string) (string, error) {
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: SystemPrompt}}
+	sessionID := sessionFrom(ctx)
+	workspaceDir := ""
+	if sessionID != "" {
+		session, err := s.Session(ctx, sessionID)
+		if err != nil {
+			return "", err
+		}
+		workspaceDir = session.WorkspaceDir
+		if history := s.sessionHistory(ctx, session); history != "" {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "Conversation so far:\n" + history,
+			})
+		}
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: message})
+
+	workspaceDir, err := s.TenantWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return "", err
+	}
+	ctx = withWorkspace(ctx, workspaceDir)
+	if err := s.spend.Check(ctx); err != nil {
+		return "", err
+	}
+	reply, err := s.llmClient.Chat(s.withRules(ctx, workspaceDir), messages)
+	if err != nil {
+		return "", err
+	}
+	if sessionID != "" {
+		if err := s.sessions.Append(sessionID,
+			SessionMessage{Role: openai.ChatMessageRoleUser, Content: message},
+			SessionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply},
+		); err != nil {
+			s.logger.Warn("Failed to record chat in session", zap.String("session_id", sessionID), zap.Error(err))
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Shell identifies the shell used to run commands
+type Shell string
+
+const (
+	ShellSh         Shell = "sh"
+	ShellBash       Shell = "bash"
+	ShellCmd        Shell = "cmd"
+	ShellPowerShell Shell = "powershell"
+	ShellPwsh       Shell = "pwsh"
+)
+
+// DefaultShell returns the native shell for the current OS
+func DefaultShell() Shell {
+	if runtime.GOOS == "windows" {
+		return ShellPowerShell
+	}
+	return ShellSh
+}
+
+// ParseShell validates a shell name. An empty name selects DefaultShell.
+func ParseShell(name string) (Shell, error) {
+	switch shell := Shell(name); shell {
+	case "":
+		return DefaultShell(), nil
+	case ShellSh, ShellBash, ShellCmd, ShellPowerShell, ShellPwsh:
+		return shell, nil
+	default:
+		return "", fmt.Errorf("unsupported shell: %s", name)
+	}
+}
+
+// Command builds the exec.Cmd that runs command in this shell
+func (s Shell) Command(ctx context.Context, command string) *exec.Cmd {
+	switch s {
+	case ShellCmd:
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	case ShellPowerShell, ShellPwsh:
+		return exec.CommandContext(ctx, string(s), "-NoProfile", "-NonInteractive", "-Command", command)
+	default:
+		return exec.CommandContext(ctx, string(s), "-c", command)
+	}
+}
+
+// Quote quotes arg so the shell passes it to a program as a single argument
+func (s Shell) Quote(arg string) string {
+	switch s {
+	case ShellCmd:
+		return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+	case ShellPowerShell, ShellPwsh:
+		return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+	default:
+		return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+}
+
+// WorkspaceSettings holds per-workspace overrides read from .spilot/settings.json
+type WorkspaceSettings struct {
+	Shell string `json:"shell,omitempty"`
+}
+
+// workspaceSettingsFile is the settings file path relative to the workspace root
+const workspaceSettingsFile = ".spilot/settings.json"
+
+// LoadWorkspaceSettings reads the workspace settings file. A missing file
+// yields empty settings.
+func LoadWorkspaceSettings(workspaceDir string) (*WorkspaceSettings, error) {
+	var settings WorkspaceSettings
+	data, err := os.ReadFile(filepath.Join(workspaceDir, workspaceSettingsFile))
+	if os.IsNotExist(err) {
+		return &settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace settings: %w", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", workspaceSettingsFile, err)
+	}
+	return &settings, nil
+}
@@ -0,0 +1,354 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrBudgetExceeded is returned for work beyond a spend budget
+var ErrBudgetExceeded = errors.New("spend budget exceeded")
+
+// Budget periods
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Actions on work beyond a budget
+const (
+	BudgetReject = "reject"
+	BudgetQueue  = "queue"
+)
+
+// EachScope as a budget's requester or workspace applies the budget to each
+// requester or workspace separately
+const EachScope = "*"
+
+// SpendBudget limits the tokens or dollars spent on LLM calls per day or
+// month. Requester and Workspace restrict it to the usage of one requester
+// (API key) or workspace, or of each separately with EachScope; empty
+// matches all usage.
+type SpendBudget struct {
+	Name      string
+	Requester string
+	Workspace string
+	Period    string
+	// Tokens and Cost are the limits; 0 leaves one unlimited
+	Tokens int
+	Cost   float64
+	// Action is BudgetReject, the default, or BudgetQueue to hold work
+	// until the period ends
+	Action string
+}
+
+// SpendConfig holds the spend budgets and how to alert as they are used up
+type SpendConfig struct {
+	Budgets []SpendBudget
+	// AlertThresholds are the fractions of a budget at which to alert
+	AlertThresholds []float64
+	// AlertWebhook receives alerts as JSON POSTs; they are always logged
+	AlertWebhook string
+}
+
+// BudgetStatus is how much of a budget is used in the current period
+type BudgetStatus struct {
+	Budget    string    `json:"budget"`
+	Requester string    `json:"requester,omitempty"`
+	Workspace string    `json:"workspace,omitempty"`
+	Period    string    `json:"period"`
+	Resets    time.Time `json:"resets"`
+	Tokens    int       `json:"tokens"`
+	Cost      float64   `json:"cost"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
+	MaxCost   float64   `json:"max_cost,omitempty"`
+	// Used is the larger of the used fractions of the limits
+	Used float64 `json:"used"`
+}
+
+// SpendGuard enforces spend budgets and raises alerts. A nil SpendGuard
+// allows everything.
+type SpendGuard struct {
+	cfg           SpendConfig
+	usage         *UsageStore
+	notifications *Notifications
+	client        *http.Client
+	logger        *zap.Logger
+
+	mu sync.Mutex
+	// alerted holds the thresholds already alerted, per budget, scope and
+	// period
+	alerted map[string]bool
+}
+
+// NewSpendGuard creates a guard for the budgets in cfg, or nil if there are
+// none. Alerts also go to the notification channels.
+func NewSpendGuard(cfg SpendConfig, usage *UsageStore, notifications *Notifications, logger *zap.Logger) *SpendGuard {
+	if len(cfg.Budgets) == 0 {
+		return nil
+	}
+	for i := range cfg.Budgets {
+		if cfg.Budgets[i].Name == "" {
+			cfg.Budgets[i].Name = fmt.Sprintf("budget %d", i+1)
+		}
+		if cfg.Budgets[i].Period == "" {
+			cfg.Budgets[i].Period = PeriodDay
+		}
+		if workspace := cfg.Budgets[i].Workspace; workspace != "" && workspace != EachScope {
+			cfg.Budgets[i].Workspace = absPath(workspace)
+		}
+	}
+	sort.Float64s(cfg.AlertThresholds)
+	return &SpendGuard{
+		cfg:           cfg,
+		usage:         usage,
+		notifications: notifications,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		logger:        logger,
+		alerted:       make(map[string]bool),
+	}
+}
+
+// Check returns ErrBudgetExceeded if a budget that applies to the
+// requester and workspace of ctx is used up. Budgets that queue work make
+// Check wait until their period ends instead, or until ctx is done.
+func (g *SpendGuard) Check(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	requester, workspace := RequesterFrom(ctx), workspaceFrom(ctx)
+	for {
+		status, budget := g.exceeded(requester, workspace)
+		if status == nil {
+			return nil
+		}
+		err := fmt.Errorf("%w: %q used %s per %s, until %s", ErrBudgetExceeded,
+			status.Budget, status.describe(), status.Period, status.Resets.Format(time.RFC3339))
+		if budget.Action != BudgetQueue {
+			return err
+		}
+		g.logger.Info("Queueing work until the budget resets",
+			zap.String("budget", status.Budget), zap.Time("resets", status.Resets))
+		timer := time.NewTimer(time.Until(status.Resets))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// exceeded returns the status of the first used-up budget that applies
+func (g *SpendGuard) exceeded(requester, workspace string) (*BudgetStatus, *SpendBudget) {
+	for i := range g.cfg.Budgets {
+		budget := &g.cfg.Budgets[i]
+		if !budget.applies(requester, workspace) {
+			continue
+		}
+		if status := g.status(budget, requester, workspace, time.Now()); status.Used >= 1 {
+			return status, budget
+		}
+	}
+	return nil, nil
+}
+
+// recorded raises the alerts due after an LLM call
+func (g *SpendGuard) recorded(record *UsageRecord) {
+	if g == nil || len(g.cfg.AlertThresholds) == 0 {
+		return
+	}
+	for i := range g.cfg.Budgets {
+		budget := &g.cfg.Budgets[i]
+		if !budget.applies(record.Requester, record.Workspace) {
+			continue
+		}
+		status := g.status(budget, record.Requester, record.Workspace, record.Time)
+		// Alert once for the highest threshold reached
+		var reached float64
+		for _, threshold := range g.cfg.AlertThresholds {
+			if status.Used >= threshold {
+				reached = threshold
+			}
+		}
+		if reached == 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s|%g", budget.Name, status.Requester, status.Workspace, status.Resets, reached)
+		g.mu.Lock()
+		seen := g.alerted[key]
+		g.alerted[key] = true
+		g.mu.Unlock()
+		if !seen {
+			g.alert(status, reached)
+		}
+	}
+}
+
+func (g *SpendGuard) alert(status *BudgetStatus, threshold float64) {
+	g.logger.Warn("Spend budget threshold reached",
+		zap.String("budget", status.Budget),
+		zap.String("requester", status.Requester),
+		zap.String("workspace", status.Workspace),
+		zap.Float64("threshold", threshold),
+		zap.String("used", status.describe()),
+		zap.Time("resets", status.Resets))
+	g.notifications.budgetAlert(status, threshold)
+	if g.cfg.AlertWebhook == "" {
+		return
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event":     "budget_threshold",
+		"threshold": threshold,
+		"status":    status,
+	})
+	go func() {
+		resp, err := g.client.Post(g.cfg.AlertWebhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			g.logger.Error("Failed to send budget alert", zap.Error(err))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			g.logger.Error("Budget alert webhook failed", zap.String("status", resp.Status))
+		}
+	}()
+}
+
+// Status returns the status of every budget in the current period. Budgets
+// for each requester or workspace report those that used any of them.
+func (g *SpendGuard) Status() []BudgetStatus {
+	if g == nil {
+		return []BudgetStatus{}
+	}
+	now := time.Now()
+	statuses := []BudgetStatus{}
+	for i := range g.cfg.Budgets {
+		budget := &g.cfg.Budgets[i]
+		requesters, workspaces := []string{budget.Requester}, []string{budget.Workspace}
+		if budget.Requester == EachScope || budget.Workspace == EachScope {
+			requesters, workspaces = g.scopes(budget, now)
+		}
+		for j := range requesters {
+			statuses = append(statuses, *g.status(budget, requesters[j], workspaces[j], now))
+		}
+	}
+	return statuses
+}
+
+// scopes returns the requester and workspace pairs that used a budget
+// applying to each requester or workspace separately
+func (g *SpendGuard) scopes(budget *SpendBudget, now time.Time) ([]string, []string) {
+	start, _ := periodBounds(budget.Period, now)
+	seen := make(map[[2]string]bool)
+	var requesters, workspaces []string
+	for _, record := range g.usage.Records(UsageFilter{Since: start}) {
+		if !budget.applies(record.Requester, record.Workspace) {
+			continue
+		}
+		scope := [2]string{budget.Requester, budget.Workspace}
+		if budget.Requester == EachScope {
+			scope[0] = record.Requester
+		}
+		if budget.Workspace == EachScope {
+			scope[1] = record.Workspace
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			requesters = append(requesters, scope[0])
+			workspaces = append(workspaces, scope[1])
+		}
+	}
+	return requesters, workspaces
+}
+
+// status returns how much of budget the requester and workspace used in
+// the period containing at
+func (g *SpendGuard) status(budget *SpendBudget, requester, workspace string, at time.Time) *BudgetStatus {
+	start, end := periodBounds(budget.Period, at)
+	filter := UsageFilter{Since: start, Until: end}
+	status := &BudgetStatus{
+		Budget:    budget.Name,
+		Period:    budget.Period,
+		Resets:    end,
+		MaxTokens: budget.Tokens,
+		MaxCost:   budget.Cost,
+	}
+	if budget.Requester != "" {
+		filter.Requester, status.Requester = requester, requester
+	}
+	if budget.Workspace != "" {
+		filter.Workspace, status.Workspace = workspace, workspace
+	}
+	report, _ := g.usage.Report(filter, "")
+	status.Tokens, status.Cost = report.Total.TotalTokens, report.Total.Cost
+	if budget.Tokens > 0 {
+		status.Used = float64(status.Tokens) / float64(budget.Tokens)
+	}
+	if budget.Cost > 0 {
+		status.Used = max(status.Used, status.Cost/budget.Cost)
+	}
+	return status
+}
+
+func (s *BudgetStatus) describe() string {
+	var parts []string
+	if s.MaxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d tokens", s.Tokens, s.MaxTokens))
+	}
+	if s.MaxCost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f", s.Cost, s.MaxCost))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// applies reports whether the budget limits the usage of a requester in a
+// workspace
+func (b *SpendBudget) applies(requester, workspace string) bool {
+	if b.Requester != "" && b.Requester != EachScope && b.Requester != requester {
+		return false
+	}
+	if b.Workspace != "" && b.Workspace != EachScope && b.Workspace != workspace {
+		return false
+	}
+	return true
+}
+
+// periodBounds returns the start and end, in UTC, of the day or month
+// containing t
+func periodBounds(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if period == PeriodMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+type workspaceKey struct{}
+
+// withWorkspace attributes the LLM calls made with ctx to workspaceDir. A
+// workspace already in ctx, e.g. that of the request a task belongs to, is
+// kept.
+func withWorkspace(ctx context.Context, workspaceDir string) context.Context {
+	if workspaceDir == "" || workspaceFrom(ctx) != "" {
+		return ctx
+	}
+	return context.WithValue(ctx, workspaceKey{}, absPath(workspaceDir))
+}
+
+// workspaceFrom returns the workspace recorded in ctx, if any
+func workspaceFrom(ctx context.Context) string {
+	dir, _ := ctx.Value(workspaceKey{}).(string)
+	return dir
+}
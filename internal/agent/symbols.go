@@ -0,0 +1,493 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Symbol is a named declaration in the workspace
+type Symbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Container is the receiver type of a method or the enclosing scope
+	Container string `json:"container,omitempty"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	// Source is go (the Go parser), ctags or pattern (declaration regexps)
+	Source string `json:"source"`
+}
+
+// QualifiedName returns Container.Name, or Name for top-level symbols
+func (s Symbol) QualifiedName() string {
+	if s.Container == "" {
+		return s.Name
+	}
+	return s.Container + "." + s.Name
+}
+
+// SymbolReference is a use of a symbol
+type SymbolReference struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text"`
+	// Exact is set when the reference was resolved by the type checker
+	// rather than by matching the name as a word
+	Exact bool `json:"exact"`
+}
+
+// SymbolIndex holds the declarations of one workspace. Go files are parsed
+// with go/parser; other languages come from universal-ctags when it is
+// installed and from declaration patterns otherwise.
+type SymbolIndex struct {
+	root    string
+	builtAt time.Time
+	symbols []Symbol
+	byName  map[string][]int
+}
+
+// BuildSymbolIndex indexes the declarations under root, running ctags with
+// tools
+func BuildSymbolIndex(ctx context.Context, root string, tools *ToolRunner) (*SymbolIndex, error) {
+	root = absPath(root)
+	index := &SymbolIndex{root: root, builtAt: time.Now(), byName: make(map[string][]int)}
+	useCtags := onPath("ctags") && ctagsIsUniversal(ctx, tools)
+
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if path != root && (skipDir(d.Name()) || d.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case ext == ".go":
+			index.addGoFile(fset, path, rel)
+		case !useCtags && searchableExtensions[ext]:
+			index.addPatternFile(path, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if useCtags {
+		if err := index.addCtags(ctx, tools); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(index.symbols, func(i, j int) bool {
+		if index.symbols[i].File != index.symbols[j].File {
+			return index.symbols[i].File < index.symbols[j].File
+		}
+		return index.symbols[i].Line < index.symbols[j].Line
+	})
+	for i, sym := range index.symbols {
+		index.byName[sym.Name] = append(index.byName[sym.Name], i)
+	}
+	return index, nil
+}
+
+// addGoFile records the top-level declarations, methods and struct fields
+// of a Go file
+func (si *SymbolIndex) addGoFile(fset *token.FileSet, path, rel string) {
+	file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return
+	}
+	add := func(name, kind, container string, pos token.Pos) {
+		if name == "_" {
+			return
+		}
+		si.symbols = append(si.symbols, Symbol{
+			Name: name, Kind: kind, Container: container,
+			File: rel, Line: fset.Position(pos).Line, Source: "go",
+		})
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add(decl.Name.Name, "method", receiverTypeName(decl.Recv.List[0].Type), decl.Name.Pos())
+			} else {
+				add(decl.Name.Name, "func", "", decl.Name.Pos())
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					switch t := spec.Type.(type) {
+					case *ast.StructType:
+						kind = "struct"
+						for _, field := range t.Fields.List {
+							for _, name := range field.Names {
+								add(name.Name, "field", spec.Name.Name, name.Pos())
+							}
+						}
+					case *ast.InterfaceType:
+						kind = "interface"
+						for _, method := range t.Methods.List {
+							for _, name := range method.Names {
+								add(name.Name, "method", spec.Name.Name, name.Pos())
+							}
+						}
+					}
+					add(spec.Name.Name, kind, "", spec.Name.Pos())
+				case *ast.ValueSpec:
+					kind := "var"
+					if decl.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, name := range spec.Names {
+						add(name.Name, kind, "", name.Pos())
+					}
+				}
+			}
+		}
+	}
+}
+
+// receiverTypeName returns T for receivers of type T, *T or T[P]
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// addPatternFile records function and type declarations found by pattern
+func (si *SymbolIndex) addPatternFile(path, rel string) {
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) > maxIndexedFileBytes {
+		return
+	}
+	scanner := newLineScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if name := declaredFunction(text); name != "" {
+			si.symbols = append(si.symbols, Symbol{Name: name, Kind: "func", File: rel, Line: line, Source: "pattern"})
+		} else if m := typeDeclPattern.FindStringSubmatch(text); m != nil {
+			si.symbols = append(si.symbols, Symbol{Name: m[1], Kind: "type", File: rel, Line: line, Source: "pattern"})
+		}
+	}
+}
+
+// ctagsIsUniversal reports whether ctags is universal-ctags, the only
+// flavour with JSON output
+func ctagsIsUniversal(ctx context.Context, tools *ToolRunner) bool {
+	out, err := tools.run(ctx, "", "ctags", "--version")
+	return err == nil && bytes.Contains(out, []byte("Universal Ctags"))
+}
+
+// addCtags records the non-Go declarations reported by universal-ctags
+func (si *SymbolIndex) addCtags(ctx context.Context, tools *ToolRunner) error {
+	args := []string{"-R", "--output-format=json", "--fields=+nK", "--languages=-Go", "--exclude=testdata"}
+	for _, dir := range []string{".git", "node_modules", "vendor", "dist", "build", "target", "__pycache__", ".venv", "venv", ".spilot"} {
+		args = append(args, "--exclude="+dir)
+	}
+	args = append(args, "-f", "-", ".")
+	out, err := tools.run(ctx, si.root, "ctags", args...)
+	if err != nil {
+		return fmt.Errorf("ctags failed: %w", err)
+	}
+
+	scanner := newLineScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var tag struct {
+			Type  string `json:"_type"`
+			Name  string `json:"name"`
+			Path  string `json:"path"`
+			Line  int    `json:"line"`
+			Kind  string `json:"kind"`
+			Scope string `json:"scope"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &tag) != nil || tag.Type != "tag" {
+			continue
+		}
+		si.symbols = append(si.symbols, Symbol{
+			Name: tag.Name, Kind: tag.Kind, Container: tag.Scope,
+			File: filepath.ToSlash(filepath.Clean(tag.Path)), Line: tag.Line, Source: "ctags",
+		})
+	}
+	return nil
+}
+
+// Lookup returns the declarations of name, which may be qualified as
+// Container.Name (e.g. Server.Start)
+func (si *SymbolIndex) Lookup(name string) []Symbol {
+	container := ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		container, name = name[:i], name[i+1:]
+	}
+	var matches []Symbol
+	for _, i := range si.byName[name] {
+		if container == "" || si.symbols[i].Container == container {
+			matches = append(matches, si.symbols[i])
+		}
+	}
+	return matches
+}
+
+// Find returns symbols whose name contains query, case-insensitively,
+// exact and prefix matches first
+func (si *SymbolIndex) Find(query, kind string, limit int) []Symbol {
+	query = strings.ToLower(query)
+	type ranked struct {
+		symbol Symbol
+		rank   int
+	}
+	var matches []ranked
+	for _, sym := range si.symbols {
+		if kind != "" && sym.Kind != kind {
+			continue
+		}
+		name := strings.ToLower(sym.Name)
+		switch {
+		case name == query:
+			matches = append(matches, ranked{sym, 0})
+		case strings.HasPrefix(name, query):
+			matches = append(matches, ranked{sym, 1})
+		case strings.Contains(name, query):
+			matches = append(matches, ranked{sym, 2})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank < matches[j].rank })
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	symbols := make([]Symbol, len(matches))
+	for i, m := range matches {
+		symbols[i] = m.symbol
+	}
+	return symbols
+}
+
+// Len returns the number of indexed symbols
+func (si *SymbolIndex) Len() int {
+	return len(si.symbols)
+}
+
+// References finds the uses of a symbol. Go symbols are resolved with the
+// type checker, so only references to that exact declaration are returned;
+// for other languages the name is matched as a whole word.
+func (si *SymbolIndex) References(ctx context.Context, sym Symbol, limit int) ([]SymbolReference, error) {
+	if sym.Source == "go" {
+		refs, err := si.goReferences(sym)
+		if err == nil {
+			return truncateReferences(refs, limit), nil
+		}
+	}
+	refs, err := si.textReferences(ctx, sym, limit)
+	return refs, err
+}
+
+func truncateReferences(refs []SymbolReference, limit int) []SymbolReference {
+	if limit > 0 && len(refs) > limit {
+		return refs[:limit]
+	}
+	return refs
+}
+
+// goReferences collects the identifiers the type checker resolves to the
+// declaration of sym, excluding the declaration itself
+func (si *SymbolIndex) goReferences(sym Symbol) ([]SymbolReference, error) {
+	loader := newGoLoader(si.root)
+	file := filepath.Join(si.root, filepath.FromSlash(sym.File))
+	pkgs, err := loader.load(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	obj := findGoObject(loader.fset, pkgs, file, sym.Line, sym.Name)
+	if obj == nil {
+		return nil, fmt.Errorf("symbol %s not found in %s", sym.Name, sym.File)
+	}
+	target := loader.declKey(obj)
+
+	dirs := []string{filepath.Dir(file)}
+	if obj.Exported() || obj.Parent() == nil {
+		if dirs, err = goPackageDirs(si.root); err != nil {
+			return nil, err
+		}
+	}
+	var refs []SymbolReference
+	lines := make(map[string][]string)
+	for _, dir := range dirs {
+		if dir != filepath.Dir(file) && !dirMentions(dir, sym.Name) {
+			continue
+		}
+		dirPkgs := pkgs
+		if dir != filepath.Dir(file) {
+			if dirPkgs, err = loader.load(dir); err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range dirPkgs {
+			for ident, o := range p.info.Uses {
+				if o == nil || ident.Name != sym.Name || loader.declKey(o) != target {
+					continue
+				}
+				refs = append(refs, si.reference(loader.fset.Position(ident.Pos()), lines))
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].File != refs[j].File {
+			return refs[i].File < refs[j].File
+		}
+		return refs[i].Line < refs[j].Line
+	})
+	return refs, nil
+}
+
+// reference builds a reference at pos, caching file lines for its text
+func (si *SymbolIndex) reference(pos token.Position, lines map[string][]string) SymbolReference {
+	if _, ok := lines[pos.Filename]; !ok {
+		content, _ := os.ReadFile(pos.Filename)
+		lines[pos.Filename] = strings.Split(string(content), "\n")
+	}
+	text := ""
+	if fileLines := lines[pos.Filename]; pos.Line-1 < len(fileLines) {
+		text = strings.TrimSpace(fileLines[pos.Line-1])
+	}
+	rel, _ := filepath.Rel(si.root, pos.Filename)
+	return SymbolReference{File: filepath.ToSlash(rel), Line: pos.Line, Column: pos.Column, Text: text, Exact: true}
+}
+
+// textReferences finds whole-word occurrences of the symbol's name outside
+// its declaration
+func (si *SymbolIndex) textReferences(ctx context.Context, sym Symbol, limit int) ([]SymbolReference, error) {
+	word := regexp.MustCompile(`\b` + regexp.QuoteMeta(sym.Name) + `\b`)
+	var refs []SymbolReference
+	errLimit := fmt.Errorf("limit reached")
+	err := filepath.WalkDir(si.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if path != si.root && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !searchableExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer file.Close()
+		rel, _ := filepath.Rel(si.root, path)
+		rel = filepath.ToSlash(rel)
+		scanner := newLineScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			loc := word.FindStringIndex(scanner.Text())
+			if loc == nil || (rel == sym.File && line == sym.Line) {
+				continue
+			}
+			refs = append(refs, SymbolReference{File: rel, Line: line, Column: loc[0] + 1, Text: strings.TrimSpace(scanner.Text())})
+			if limit > 0 && len(refs) >= limit {
+				return errLimit
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// SymbolCache keeps a symbol index per workspace, rebuilding one when a
+// source file under it has changed since it was built
+type SymbolCache struct {
+	mu      sync.Mutex
+	indexes map[string]*SymbolIndex
+	tools   *ToolRunner
+	logger  *zap.Logger
+}
+
+// NewSymbolCache creates an empty symbol cache building indexes with tools
+func NewSymbolCache(tools *ToolRunner, logger *zap.Logger) *SymbolCache {
+	return &SymbolCache{indexes: make(map[string]*SymbolIndex), tools: tools, logger: logger}
+}
+
+// Get returns an up-to-date symbol index for the workspace
+func (c *SymbolCache) Get(ctx context.Context, workspaceDir string) (*SymbolIndex, error) {
+	root := absPath(workspaceDir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index, ok := c.indexes[root]; ok && !sourceChangedSince(root, index.builtAt) {
+		return index, nil
+	}
+	start := time.Now()
+	index, err := BuildSymbolIndex(ctx, root, c.tools)
+	if err != nil {
+		return nil, err
+	}
+	c.indexes[root] = index
+	c.logger.Debug("Built symbol index", zap.String("root", root), zap.Int("symbols", index.Len()), zap.Duration("duration", time.Since(start)))
+	return index, nil
+}
+
+// sourceChangedSince reports whether a source file or directory under root
+// was modified after t; directory times catch deletions and renames
+func sourceChangedSince(root string, t time.Time) bool {
+	errChanged := fmt.Errorf("changed")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != root && skipDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && !searchableExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !info.ModTime().Before(t) {
+			return errChanged
+		}
+		return nil
+	})
+	return err == errChanged
+}
@@ -2,10 +2,21 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+	"spilot-agent/internal/redact"
+	"spilot-agent/internal/telemetry"
+	"spilot-agent/internal/tickets"
+
+	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
 
@@ -25,55 +36,531 @@ func isTerminalIntent(input string) bool {
 }
 
 // NewSystem creates a new agent system
-func NewSystem(llmClient LLMClient, logger *zap.Logger) *System {
+func NewSystem(llmClient LLMClient, cfg *config.Config, redactor *redact.Redactor, logger *zap.Logger) (*System, error) {
+	shell, err := ParseShell(cfg.Shell)
+	if err != nil {
+		return nil, err
+	}
+	approvalLevel, err := ParseRiskLevel(cfg.ApprovalRiskLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	egress, err := NewEgress(EgressConfig(cfg.Egress), logger)
+	if err != nil {
+		return nil, fmt.Errorf("egress: %w", err)
+	}
+	execConfig := ExecutorConfig{
+		DefaultTimeout: cfg.CommandTimeout,
+		DefaultShell:   shell,
+		EnvAllowlist:   cfg.EnvAllowlist,
+		EnvDenylist:    cfg.EnvDenylist,
+		Limits:         ResourceLimits(cfg.Limits),
+		Egress:         egress,
+	}
+	var commandExec CommandExecutor
+	var sandbox *SandboxExecutor
+	switch cfg.Executor {
+	case "", "local":
+		commandExec = NewCommandExecutor(execConfig)
+	case "sandbox":
+		sandbox = NewSandboxExecutor(execConfig, SandboxConfig(cfg.Sandbox), logger).(*SandboxExecutor)
+		commandExec = sandbox
+	default:
+		return nil, fmt.Errorf("unknown executor: %s", cfg.Executor)
+	}
+
+	// Secrets are redacted before command output is audited or returned,
+	// and before prompts leave for the provider
+	var eventLog *EventLog
+	if cfg.RedactSecrets && redactor != nil {
+		redacted := func(source string) func(ctx context.Context, count int) {
+			return func(ctx context.Context, count int) {
+				if source != "prompt" {
+					// The client logs prompt redactions itself
+					logger.Warn("Redacted secrets from command output", zap.Int("count", count))
+				}
+				eventLog.Record(ctx, ActionSecretRedacted, map[string]interface{}{"source": source, "count": count})
+			}
+		}
+		commandExec = NewRedactingExecutor(commandExec, redactor, redacted("command_output"))
+		if client, ok := llmClient.(PromptRedactor); ok {
+			client.SetRedactor(redactor)
+			client.OnRedact(redacted("prompt"))
+		}
+	}
+
+	sealer, err := NewSealer(cfg.Encryption.Key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption.key: %w", err)
+	}
+
+	var auditLog CommandAuditLog
+	var fileManager FileManager = NewFileManager(cfg.MaxReadBytes, cfg.MaxWriteBytes)
+	if cfg.AuditLog {
+		fileLog, err := NewFileAuditLog(filepath.Join(cfg.DataDir, "audit", "commands.jsonl"), sealer)
+		if err != nil {
+			return nil, err
+		}
+		eventLog, err = NewEventLog(filepath.Join(cfg.DataDir, "audit", "events.jsonl"), sealer, logger)
+		if err != nil {
+			return nil, err
+		}
+		auditLog = fileLog
+		fileManager = &eventedFileManager{FileManager: fileManager, events: eventLog}
+		commandExec = NewAuditedExecutor(commandExec, &eventedAuditLog{CommandAuditLog: fileLog, events: eventLog}, func(err error) {
+			logger.Error("Failed to record command in audit log", zap.Error(err))
+		})
+	}
+
+	policy, err := NewPolicyGuard(PolicyConfig(cfg.Policy), logger)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	// git, formatters and ctags run with the same restrictions and auditing
+	// as commands
+	tools := NewToolRunner(execConfig, auditLog, policy, logger)
+
+	// Requests made with write confirmation hold each write until the user
+	// approves its diff, after the checks below allowed it
+	approvals, events := NewApprovalStore(), NewEventBus()
+	fileManager = &confirmingFileManager{FileManager: fileManager, approvals: approvals, events: events, tools: tools}
+
+	// Policy checks come first so denied actions are neither taken nor
+	// recorded as taken
+	if policy != nil {
+		fileManager = &policyFileManager{FileManager: fileManager, policy: policy}
+		commandExec = NewPolicyExecutor(commandExec, policy)
+	}
+	// API keys limit what their holders' tasks may change
+	fileManager = &scopedFileManager{FileManager: fileManager}
+	commandExec = &ScopedExecutor{CommandExecutor: commandExec}
+	// Plan executions stop at their blast-radius limits
+	fileManager = &blastRadiusFileManager{FileManager: fileManager}
+	commandExec = &BlastRadiusExecutor{CommandExecutor: commandExec}
+	apiKeys, err := NewAPIKeyStore(cfg.DataDir, sealer, logger)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := NewWebhookStore(cfg.DataDir, cfg.WebhookAllowedHosts, sealer, logger)
+	if err != nil {
+		return nil, err
+	}
+	planTokens, err := NewPlanTokens(cfg.PlanApproval.SigningKey, cfg.PlanApproval.TokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	tenants := make([]Tenant, len(cfg.Tenancy.Tenants))
+	for i, tenant := range cfg.Tenancy.Tenants {
+		tenants[i] = Tenant(tenant)
+	}
+	tenancy := NewTenancy(TenancyConfig{Root: cfg.Tenancy.Root, Tenants: tenants})
+	if tenancy != nil {
+		fileManager = &tenantFileManager{FileManager: fileManager, tenancy: tenancy}
+	}
+
+	sessions, err := NewSessionStore(cfg.DataDir, HistoryConfig(cfg.History), sealer, logger)
+	if err != nil {
+		return nil, err
+	}
+	prices := make([]ModelPrice, len(cfg.ModelPrices))
+	for i, price := range cfg.ModelPrices {
+		prices[i] = ModelPrice(price)
+	}
+	usage, err := NewUsageStore(cfg.DataDir, prices, sealer, logger)
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]NotificationChannelConfig, len(cfg.Notifications.Channels))
+	for i, channel := range cfg.Notifications.Channels {
+		channels[i] = NotificationChannelConfig(channel)
+	}
+	notifications, err := NewNotifications(NotificationsConfig{
+		MinDuration: cfg.Notifications.MinDuration,
+		Channels:    channels,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	budgets := make([]SpendBudget, len(cfg.Spend.Budgets))
+	for i, budget := range cfg.Spend.Budgets {
+		budgets[i] = SpendBudget(budget)
+	}
+	spend := NewSpendGuard(SpendConfig{
+		Budgets:         budgets,
+		AlertThresholds: cfg.Spend.AlertThresholds,
+		AlertWebhook:    cfg.Spend.AlertWebhook,
+	}, usage, notifications, logger)
+	if reporter, ok := llmClient.(UsageReporter); ok {
+		reporter.OnUsage(func(ctx context.Context, u llm.Usage) {
+			spend.recorded(usage.Record(ctx, u))
+		})
+	}
+	if router, ok := llmClient.(ModelRouter); ok {
+		router.SetRouting(llm.RoutingConfig(cfg.ModelRouting))
+	}
+
 	system := &System{
-		agents:      make(map[AgentType]Agent),
-		llmClient:   llmClient,
-		fileManager: NewFileManager(),
-		commandExec: NewCommandExecutor(),
-		taskQueue:   make(chan *Task, 100),
-		results:     make(map[string]*TaskResult),
-		logger:      logger,
+		agents:       make(map[AgentType]Agent),
+		llmClient:    llmClient,
+		fileManager:  fileManager,
+		commandExec:  commandExec,
+		taskQueue:    make(chan *Task, 100),
+		results:      make(map[string]*TaskResult),
+		approvals:    approvals,
+		processes:    NewProcessManager(execConfig, sandbox, auditLog, policy, tenancy, logger),
+		auditLog:     auditLog,
+		eventLog:     eventLog,
+		tenancy:      tenancy,
+		egress:       egress,
+		planTokens:   planTokens,
+		blastRadius:  BlastRadiusLimits(cfg.BlastRadius),
+		apiKeys:      apiKeys,
+		webhooks:     webhooks,
+		ptys:         NewPTYManager(execConfig, sandbox, auditLog, policy, logger),
+		tools:        tools,
+		events:       events,
+		hooks:        newHookRegistry(logger),
+		symbols:      NewSymbolCache(tools, logger),
+		profiles:     NewProfileStore(cfg.DataDir, logger),
+		budgets:      ContextBudgets{Default: cfg.ContextTokens, Models: make(map[string]int)},
+		sessions:     sessions,
+		usage:        usage,
+		spend:        spend,
+		rules:        NewRulesStore(cfg.Instructions, logger),
+		sealer:       sealer,
+		models:       cfg.ConfiguredModels(),
+		telemetry:    telemetry.New(telemetry.Config(cfg.Telemetry), cfg.DataDir, logger),
+		dataDir:      cfg.DataDir,
+		workspaceDir: cfg.WorkspaceDir,
+		handoffDepth: cfg.HandoffMaxDepth,
+		logger:       logger,
+	}
+
+	if system.remote, err = NewRemoteTasks(QueueConfig(cfg.Queue), events, system.profiles, logger); err != nil {
+		return nil, err
+	}
+
+	system.notifications = notifications
+	if system.outputs, err = NewOutputStore(cfg.DataDir, cfg.SpillBytes, sealer, logger); err != nil {
+		return nil, err
+	}
+	if system.tickets, err = tickets.New(tickets.Config(cfg.Tickets)); err != nil {
+		return nil, err
+	}
+
+	if cfg.WorkspaceMemory {
+		system.memory = NewMemoryStore(cfg.DataDir, llmClient, sealer, logger)
+		system.learnMemory.Store(cfg.MemoryLearning)
+	}
+	for _, budget := range cfg.ContextBudgets {
+		system.budgets.Models[budget.Model] = budget.Tokens
+	}
+
+	web, err := NewWebSearch(WebSearchConfig(cfg.WebSearch), llmClient, logger)
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialize agents
-	system.agents[PlanningAgent] = NewPlanningAgent(llmClient, logger)
-	system.agents[FileAgent] = NewFileAgent(system.fileManager, logger)
-	system.agents[TerminalAgent] = NewTerminalAgent(system.commandExec, llmClient, logger)
-	system.agents[DebugAgent] = NewDebugAgent(llmClient, system.fileManager, logger)
+	system.agents[PlanningAgent] = NewPlanningAgent(llmClient, web, system.profiles, system.memory, logger)
+	var formatter Formatter
+	if cfg.FormatOnWrite {
+		formatter = NewFormatter(cfg.Formatters, tools, logger)
+	}
+	system.agents[FileAgent] = NewFileAgent(system.fileManager, formatter, logger)
+	safety := NewSafetyChecker(llmClient, cfg.LLMRiskCheck, logger)
+	terminal := NewTerminalAgent(system.commandExec, llmClient, safety, system.approvals, approvalLevel, system.processes, system.events, system.profiles, logger)
+	system.agents[TerminalAgent] = terminal
+	system.agents[DebugAgent] = NewDebugAgent(llmClient, system.fileManager, system.commandExec, system.processes, system.events, cfg.DebugContextTokens, system.budgets, cfg.DebugDiagnostics, web, system.memory, terminal, tools, logger)
+	system.agents[GitAgent] = NewGitAgent(llmClient, system.tools, logger)
+	system.agents[TestAgent] = NewTestAgent(llmClient, system.fileManager, system.commandExec, logger)
+	system.agents[CodeReviewAgent] = NewCodeReviewAgent(llmClient, system.tools, logger)
+	system.agents[RefactorAgent] = NewRefactorAgent(llmClient, system.fileManager, tools, logger)
+	system.agents[DocsAgent] = NewDocsAgent(llmClient, system.fileManager, system.agents[FileAgent], tools, logger)
+	system.agents[SearchAgent] = NewSearchAgent(llmClient, system.fileManager, system.symbols, logger)
+	databases := make(map[string]DatabaseConfig, len(cfg.Databases))
+	for name, db := range cfg.Databases {
+		databases[name] = DatabaseConfig(db)
+	}
+	system.database = NewDatabaseAgent(llmClient, system.fileManager, system.approvals, system.events, databases, logger)
+	system.agents[DatabaseAgent] = system.database
+	system.agents[HTTPRequestAgent] = NewHTTPRequestAgent(llmClient, system.fileManager, cfg.HTTPAllowedHosts, logger)
+	system.agents[SecurityScanAgent] = NewSecurityScanAgent(llmClient, system.fileManager, system.commandExec, cfg.SecurityScanners, logger)
+	system.agents[LintAgent] = NewLintAgent(llmClient, system.fileManager, system.commandExec, logger)
+	system.agents[MigrationAgent] = NewMigrationAgent(llmClient, system.fileManager, system.commandExec, system.events, terminal, logger)
+	system.agents[ReleaseAgent] = NewReleaseAgent(llmClient, system.fileManager, system.approvals, system.events, system.tools, logger)
+	system.agents[BenchmarkAgent] = NewBenchmarkAgent(llmClient, system.fileManager, system.commandExec, system.tools, logger)
+	system.agents[KubernetesAgent] = NewKubernetesAgent(llmClient, system.fileManager, system.commandExec, system.approvals, system.events, system.agents[DebugAgent], KubernetesConfig(cfg.Kubernetes), logger)
+	if err := system.disableAgents(cfg.DisabledAgents); err != nil {
+		return nil, err
+	}
+
+	// Plugins may add agent types but not replace built-in ones
+	for _, pluginCfg := range cfg.Plugins {
+		plugin, err := LoadPlugin(context.Background(), PluginConfig(pluginCfg), execConfig, logger)
+		if err != nil {
+			system.Shutdown()
+			return nil, err
+		}
+		system.plugins = append(system.plugins, plugin)
+		if err := system.RegisterAgent(plugin); err != nil {
+			system.Shutdown()
+			return nil, err
+		}
+		logger.Info("Loaded agent plugin", zap.String("type", string(plugin.Type())), zap.String("command", pluginCfg.Command))
+	}
+
+	if cfg.Index.Enabled {
+		if err := system.startIndex(IndexConfig(cfg.Index), cfg.WorkspaceDir, cfg.DataDir); err != nil {
+			system.Shutdown()
+			return nil, err
+		}
+	}
+	if planner, ok := system.agents[PlanningAgent].(*PlanningAgentImpl); ok {
+		planner.extraAgents = system.extraAgents
+		planner.disabledAgents = system.DisabledAgents()
+	}
 
 	// Start task processor
 	go system.processTasks()
+	system.telemetry.Start()
+	system.webhooks.Start(system.events, system.tenancy.Owns)
+
+	return system, nil
+}
+
+// RegisterAgent adds an agent of a new type. Third parties use it to embed
+// custom agents; external executables are loaded as plugins instead.
+func (s *System) RegisterAgent(agent Agent) error {
+	agentType := agent.Type()
+	if agentType == "" {
+		return fmt.Errorf("agent has no type")
+	}
+	s.agentsMu.Lock()
+	defer s.agentsMu.Unlock()
+	if _, exists := s.agents[agentType]; exists {
+		return fmt.Errorf("agent type %s is already registered", agentType)
+	}
+	if s.disabled[agentType] {
+		return fmt.Errorf("agent type %s: %w", agentType, ErrAgentDisabled)
+	}
+	s.agents[agentType] = agent
+	return nil
+}
+
+// ErrAgentDisabled is returned for tasks of agent types disabled in the
+// configuration
+var ErrAgentDisabled = errors.New("disabled by configuration")
+
+// agentDependencies are the agents built-in agents use directly rather
+// than through handoffs, so disabling one disables its dependents
+var agentDependencies = map[AgentType][]AgentType{
+	DocsAgent:       {FileAgent},
+	KubernetesAgent: {DebugAgent},
+}
+
+// disableAgents removes the built-in agents named in names, and those that
+// depend on them
+func (s *System) disableAgents(names []string) error {
+	s.disabled = make(map[AgentType]bool)
+	for _, name := range names {
+		agentType := AgentType(strings.ToLower(strings.TrimSpace(name)))
+		if _, builtin := builtinCapabilities[agentType]; !builtin {
+			return fmt.Errorf("disabled_agents: unknown agent type %q", name)
+		}
+		s.disabled[agentType] = true
+	}
+	for agentType, dependencies := range agentDependencies {
+		for _, dependency := range dependencies {
+			if s.disabled[dependency] && !s.disabled[agentType] {
+				s.logger.Info("Disabling agent that depends on a disabled agent",
+					zap.String("type", string(agentType)), zap.String("dependency", string(dependency)))
+				s.disabled[agentType] = true
+			}
+		}
+	}
+	for agentType := range s.disabled {
+		delete(s.agents, agentType)
+	}
+	if len(s.disabled) > 0 {
+		s.logger.Info("Agents disabled", zap.Any("types", s.DisabledAgents()))
+	}
+	return nil
+}
+
+// DisabledAgents returns the agent types disabled in the configuration,
+// sorted
+func (s *System) DisabledAgents() []AgentType {
+	disabled := make([]AgentType, 0, len(s.disabled))
+	for agentType := range s.disabled {
+		disabled = append(disabled, agentType)
+	}
+	sort.Slice(disabled, func(i, j int) bool { return disabled[i] < disabled[j] })
+	return disabled
+}
+
+// AgentEnabled reports whether agents of a type are registered and enabled
+func (s *System) AgentEnabled(agentType AgentType) bool {
+	s.agentsMu.RLock()
+	defer s.agentsMu.RUnlock()
+	_, exists := s.agents[agentType]
+	return exists
+}
+
+// Agents describes every registered agent and every disabled built-in
+// agent, sorted by type
+func (s *System) Agents() []AgentCapabilities {
+	s.agentsMu.RLock()
+	defer s.agentsMu.RUnlock()
 
-	return system
+	agents := make([]AgentCapabilities, 0, len(s.agents)+len(s.disabled))
+	for agentType, agent := range s.agents {
+		capabilities, ok := builtinCapabilities[agentType]
+		if described, isDescribed := agent.(DescribedAgent); isDescribed {
+			capabilities, ok = described.Capabilities(), true
+		}
+		if !ok {
+			capabilities = AgentCapabilities{Description: "Custom agent"}
+		}
+		capabilities.Type = agentType
+		capabilities.Enabled = true
+		agents = append(agents, capabilities)
+	}
+	for agentType := range s.disabled {
+		capabilities := builtinCapabilities[agentType]
+		capabilities.Type = agentType
+		agents = append(agents, capabilities)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Type < agents[j].Type })
+	return agents
 }
 
-// ProcessUserRequest handles natural language requests from users
+// RunAgentTask runs a task with the given data on an agent of any
+// registered type, including custom and plugin agents
+func (s *System) RunAgentTask(ctx context.Context, agentType AgentType, workspaceDir string, data map[string]interface{}) (*TaskResult, error) {
+	workspaceDir, err := s.TenantWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        agentType,
+		Description: "Run " + string(agentType) + " agent",
+		Data: withOptions(data, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// extraAgents describes the registered agents that aren't built in, so the
+// planner can route tasks to them
+func (s *System) extraAgents() []AgentCapabilities {
+	var extra []AgentCapabilities
+	for _, capabilities := range s.Agents() {
+		if _, builtin := builtinCapabilities[capabilities.Type]; !builtin && capabilities.Enabled {
+			extra = append(extra, capabilities)
+		}
+	}
+	return extra
+}
+
+// ProcessUserRequest handles natural language requests from users. Within
+// a session (see ContextWithSession) the request sees the conversation so
+// far, and it and its result are added to the session. What a successful
+// request reveals about the workspace is learned in the background.
 func (s *System) ProcessUserRequest(ctx context.Context, request string, workspaceDir string) (*TaskResult, error) {
+	sessionID := sessionFrom(ctx)
+	history := ""
+	if sessionID != "" {
+		session, err := s.Session(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if workspaceDir == "" {
+			workspaceDir = session.WorkspaceDir
+		}
+		history = s.sessionHistory(ctx, session)
+	}
+	workspaceDir, err := s.TenantWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	// Fix the task ID up front so the session and memory can link to it
+	taskID := newTaskID(ctx)
+	ctx = ContextWithTaskID(ctx, taskID)
+	ctx = s.withRules(ctx, workspaceDir)
+	ctx = withWorkspace(ctx, workspaceDir)
+	if err := s.spend.Check(ctx); err != nil {
+		return nil, err
+	}
+	ticket, request, err := s.ticketData(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.processRequest(ctx, request, workspaceDir, history, ticket)
+	reply := resultMessage(result)
+	if err != nil {
+		reply = "Failed: " + err.Error()
+	} else if result.Success && s.learnMemory.Load() {
+		go s.memory.Learn(context.WithoutCancel(ctx), workspaceDir, request, reply, taskID)
+	}
+	if sessionID == "" {
+		return result, err
+	}
+	if err := s.sessions.Append(sessionID,
+		SessionMessage{Role: openai.ChatMessageRoleUser, Content: request},
+		SessionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply, TaskID: taskID},
+	); err != nil {
+		s.logger.Warn("Failed to record request in session", zap.String("session_id", sessionID), zap.Error(err))
+	}
+	return result, err
+}
+
+// processRequest routes a request given the conversation history before it.
+// Requests linked to an issue, with its ticket data, are always planned.
+func (s *System) processRequest(ctx context.Context, request, workspaceDir, history string, ticket map[string]interface{}) (*TaskResult, error) {
 	// Use intent classification to route terminal requests directly
-	if isTerminalIntent(request) {
+	if ticket == nil && isTerminalIntent(request) {
 		task := &Task{
-			ID:          generateTaskID(),
+			ID:          newTaskID(ctx),
 			Type:        TerminalAgent,
 			Description: "Execute terminal command (intent classified)",
 			Data: map[string]interface{}{
 				"instruction":   request,
 				"workspace_dir": workspaceDir,
+				"history":       history,
 			},
 			Status:    TaskPending,
 			CreatedAt: time.Now(),
 		}
 		return s.ExecuteTask(ctx, task)
 	}
+	// With a codebase index, code-generation requests are answered directly
+	// with the relevant project code in the prompt
+	if s.index != nil && ticket == nil {
+		intent, err := s.llmClient.ClassifyIntent(ctx, request)
+		if err != nil {
+			s.logger.Warn("Failed to classify request intent", zap.Error(err))
+		} else if strings.EqualFold(strings.Trim(strings.TrimSpace(intent), `."'`), "CODE") {
+			return s.generateCode(ctx, request, workspaceDir, history)
+		}
+	}
 	// Otherwise, create a planning task to break down the request
 	planningTask := &Task{
-		ID:          generateTaskID(),
+		ID:          newTaskID(ctx),
 		Type:        PlanningAgent,
 		Description: "Plan and execute user request",
-		Data: map[string]interface{}{
+		Data: withOptions(ticket, map[string]interface{}{
 			"request":       request,
 			"workspace_dir": workspaceDir,
-		},
+			"history":       history,
+		}),
 		Status:    TaskPending,
 		CreatedAt: time.Now(),
 	}
@@ -89,34 +576,177 @@ func (s *System) ProcessUserRequest(ctx context.Context, request string, workspa
 
 // ExecuteTask executes a single task
 func (s *System) ExecuteTask(ctx context.Context, task *Task) (*TaskResult, error) {
+	s.agentsMu.RLock()
 	agent, exists := s.agents[task.Type]
+	s.agentsMu.RUnlock()
 	if !exists {
+		if s.disabled[task.Type] {
+			return nil, fmt.Errorf("agent type %s: %w", task.Type, ErrAgentDisabled)
+		}
 		return nil, fmt.Errorf("agent type %s not found", task.Type)
 	}
 
+	// Planned tasks name their own workspace, so it is checked here too
+	if s.tenancy != nil {
+		workspaceDir, err := s.TenantWorkspace(ctx, stringField(task.Data, "workspace_dir"))
+		if err != nil {
+			return nil, err
+		}
+		if task.Data == nil {
+			task.Data = make(map[string]interface{})
+		}
+		task.Data["workspace_dir"] = workspaceDir
+		s.tenancy.claim(task.ID, RequesterFrom(ctx))
+	}
+	if s.remote.dispatches(ctx) {
+		return s.executeRemotely(ctx, task)
+	}
+	ctx = withWorkspace(ctx, stringField(task.Data, "workspace_dir"))
+	if err := s.spend.Check(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.hooks.runPre(ctx, task); err != nil {
+		s.logger.Warn("Task rejected by hook", zap.String("task_id", task.ID), zap.Error(err))
+		return s.failTask(task, err)
+	}
+
 	task.Status = TaskRunning
 	task.UpdatedAt = time.Now()
+	ctx = s.withRules(ctx, stringField(task.Data, "workspace_dir"))
+	ctx = withCommandOrigin(ctx, task.ID, taskInstruction(task))
+	ctx = withAgentType(ctx, task.Type)
+	ctx = s.withHandoff(ctx, task)
+	s.eventLog.Record(ctx, ActionTaskCreated, map[string]interface{}{
+		"agent":       string(task.Type),
+		"description": task.Description,
+	})
+	s.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventTaskStarted,
+		Data:   map[string]interface{}{"agent": string(task.Type), "description": task.Description},
+	})
 
+	started := time.Now()
 	result, err := agent.Execute(ctx, task)
+	s.hooks.runPost(ctx, task, result, err)
+	s.notifications.taskFinished(ctx, task, result, err, time.Since(started))
 	if err != nil {
-		task.Status = TaskFailed
-		task.Result = &TaskResult{
-			Success: false,
-			Error:   err.Error(),
-		}
-		return task.Result, err
+		return s.failTask(task, err)
 	}
 
+	s.recordOutcome(ctx, task, result)
+	if task.Type == PlanningAgent && result != nil && result.Success {
+		s.requestPlanApproval(ctx, task, result)
+	}
+	s.telemetry.Count(agentFeature(task.Type), result != nil && !result.Success)
+
+	// Report what the LLM calls of the task and its sub-tasks cost
+	if usage := s.usage.TaskUsage(task.ID); usage.Calls > 0 && result != nil {
+		result.Usage = &usage
+	}
+
+	// Results are kept; large outputs are kept on disk instead
+	result = s.outputs.spill(task.ID, result)
 	task.Status = TaskCompleted
 	task.Result = result
 	task.UpdatedAt = time.Now()
+	s.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventTaskCompleted,
+		Data:   map[string]interface{}{"success": result.Success},
+	})
 
 	// Store result
-	s.results[task.ID] = result
+	s.storeResult(task.ID, result)
 
 	return result, nil
 }
 
+// executeRemotely runs task on a worker; the worker publishes its events
+func (s *System) executeRemotely(ctx context.Context, task *Task) (*TaskResult, error) {
+	task.Status = TaskRunning
+	task.UpdatedAt = time.Now()
+	result, err := s.remote.Submit(ctx, task)
+	task.UpdatedAt = time.Now()
+	if err != nil {
+		task.Status = TaskFailed
+		task.Result = &TaskResult{Success: false, Error: err.Error()}
+		return task.Result, err
+	}
+	result = s.outputs.spill(task.ID, result)
+	task.Status = TaskCompleted
+	task.Result = result
+	s.storeResult(task.ID, result)
+	return result, nil
+}
+
+// failTask marks a task failed and publishes the failure
+func (s *System) failTask(task *Task, err error) (*TaskResult, error) {
+	s.telemetry.Count(agentFeature(task.Type), true)
+	task.Status = TaskFailed
+	task.UpdatedAt = time.Now()
+	task.Result = &TaskResult{
+		Success: false,
+		Error:   err.Error(),
+	}
+	s.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventTaskFailed,
+		Data:   map[string]interface{}{"error": err.Error()},
+	})
+	return task.Result, err
+}
+
+// recordOutcome records the plan a task generated or the fix it applied in
+// the event log
+func (s *System) recordOutcome(ctx context.Context, task *Task, result *TaskResult) {
+	if s.eventLog == nil || result == nil {
+		return
+	}
+	if generated := result.Plan(); generated != nil && task.Type == PlanningAgent {
+		// Project plans are structured; generic plans are JSON text
+		plan := generated.Plan
+		if generated.Project != nil {
+			data, _ := json.Marshal(generated.Project)
+			plan = string(data)
+		}
+		s.eventLog.Record(ctx, ActionPlanGenerated, map[string]interface{}{
+			"plan_sha256": contentHash(plan),
+			"plan":        truncateString(plan, auditOutputBytes),
+		})
+	}
+	applied, _ := result.Fields()["applied"].(*AppliedPatchSet)
+	if debug := result.Debug(); debug != nil {
+		applied = debug.Applied
+	}
+	if applied != nil {
+		changes := make([]map[string]interface{}, 0, len(applied.Files))
+		for _, path := range applied.Files {
+			changes = append(changes, fileChange(path, applied.originals[path], readForHash(path)))
+		}
+		s.eventLog.Record(ctx, ActionFixApplied, map[string]interface{}{
+			"agent":   string(task.Type),
+			"files":   changes,
+			"success": result.Success,
+		})
+	}
+}
+
+// agentFeature names an agent for telemetry. Custom and plugin agents are
+// named by users, so they are counted together.
+func agentFeature(agentType AgentType) string {
+	if _, builtin := builtinCapabilities[agentType]; builtin {
+		return "agent." + string(agentType)
+	}
+	return "agent.custom"
+}
+
+// Telemetry returns the usage telemetry reporter, or nil when it is disabled
+func (s *System) Telemetry() *telemetry.Reporter {
+	return s.telemetry
+}
+
 // ExecuteTaskChain executes a chain of tasks
 func (s *System) ExecuteTaskChain(ctx context.Context, tasks []*Task) ([]*TaskResult, error) {
 	var results []*TaskResult
@@ -154,24 +784,276 @@ func (s *System) QueueTask(task *Task) {
 	s.taskQueue <- task
 }
 
+// storeResult keeps the result of the task taskID; tasks finish
+// concurrently
+func (s *System) storeResult(taskID string, result *TaskResult) {
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+	s.results[taskID] = result
+}
+
 // GetTaskResult retrieves a task result by ID
 func (s *System) GetTaskResult(taskID string) (*TaskResult, bool) {
+	s.resultsMu.RLock()
+	defer s.resultsMu.RUnlock()
 	result, exists := s.results[taskID]
 	return result, exists
 }
 
+// Events returns the bus carrying task progress events
+func (s *System) Events() *EventBus {
+	return s.events
+}
+
+// Approvals returns the store of actions awaiting user approval
+func (s *System) Approvals() *ApprovalStore {
+	return s.approvals
+}
+
+// AuditLog returns the command audit log, or nil if auditing is disabled
+func (s *System) AuditLog() CommandAuditLog {
+	return s.auditLog
+}
+
+// CompareModels runs the standard prompt suite runs times against models,
+// or against the current and other configured models if none are given
+func (s *System) CompareModels(ctx context.Context, models []string, runs int) ([]llm.ModelBenchmark, error) {
+	benchmarker, ok := s.llmClient.(ModelBenchmarker)
+	if !ok {
+		return nil, fmt.Errorf("the LLM client cannot compare models")
+	}
+	if len(models) == 0 {
+		models = []string{s.llmClient.GetModel()}
+		for _, model := range s.models {
+			if model != models[0] {
+				models = append(models, model)
+			}
+		}
+	}
+	return benchmarker.Benchmark(ctx, models, llm.StandardSuite, runs), nil
+}
+
+// EventLog returns the log of agent actions, or nil if auditing is disabled
+func (s *System) EventLog() *EventLog {
+	return s.eventLog
+}
+
+// Memory returns the workspace memory, or nil when it is disabled
+func (s *System) Memory() *MemoryStore {
+	return s.memory
+}
+
+// Rules returns the rules of a workspace
+func (s *System) Rules(workspaceDir string) WorkspaceRules {
+	return s.rules.Get(workspaceDir)
+}
+
+// Sessions returns the store of conversation sessions
+func (s *System) Sessions() *SessionStore {
+	return s.sessions
+}
+
+// Profiles returns the store of detected project profiles
+func (s *System) Profiles() *ProfileStore {
+	return s.profiles
+}
+
+// Symbols returns the cache of per-workspace symbol indexes
+func (s *System) Symbols() *SymbolCache {
+	return s.symbols
+}
+
+// CodeIndex returns the codebase index, or nil when it is disabled
+func (s *System) CodeIndex() *CodeIndex {
+	return s.index
+}
+
+// startIndex opens the codebase index and brings it up to date in the
+// background, then keeps it current if watching is enabled
+func (s *System) startIndex(cfg IndexConfig, workspaceDir, dataDir string) error {
+	embedder, err := NewEmbedder(cfg.EmbeddingURL, cfg.EmbeddingAPIKey, cfg.EmbeddingModel)
+	if err != nil {
+		return err
+	}
+	store, err := NewVectorStore(cfg, dataDir, s.sealer)
+	if err != nil {
+		return fmt.Errorf("failed to open index store: %w", err)
+	}
+	s.index = NewCodeIndex(workspaceDir, store, embedder, cfg, dataDir, s.logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWatcher = cancel
+	go func() {
+		stats, err := s.index.Index(ctx)
+		if err != nil {
+			s.logger.Error("Failed to index workspace", zap.Error(err))
+		} else {
+			s.logger.Info("Indexed workspace", zap.Int("indexed", stats.Indexed), zap.Int("unchanged", stats.Unchanged), zap.Int("removed", stats.Removed), zap.Int("embedded", stats.Embedded))
+			if err := s.index.compact(ctx); err != nil {
+				s.logger.Warn("Failed to compact index", zap.Error(err))
+			}
+		}
+		if cfg.Watch {
+			if err := s.index.Watch(ctx); err != nil {
+				s.logger.Error("Failed to watch workspace", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// Usage returns the record of LLM token usage
+func (s *System) Usage() *UsageStore {
+	return s.usage
+}
+
+// SpendStatus returns how much of each spend budget is used
+func (s *System) SpendStatus() []BudgetStatus {
+	return s.spend.Status()
+}
+
+// Processes returns the manager of background processes
+func (s *System) Processes() *ProcessManager {
+	return s.processes
+}
+
+// APIKeys returns the store of API keys
+func (s *System) APIKeys() *APIKeyStore {
+	return s.apiKeys
+}
+
+// PTYs returns the manager of interactive terminal sessions
+func (s *System) PTYs() *PTYManager {
+	return s.ptys
+}
+
+// DefaultShell returns the shell commands run in unless overridden
+func (s *System) DefaultShell() Shell {
+	return s.commandExec.DefaultShell()
+}
+
+// Shutdown stops background processes and terminal sessions started by the agents
+func (s *System) Shutdown() {
+	s.telemetry.Close()
+	s.webhooks.Close()
+	s.remote.Close()
+	s.processes.StopAll()
+	s.egress.Close()
+	s.ptys.CloseAll()
+	s.database.Close()
+	s.outputs.Close()
+	if router, ok := s.llmClient.(ModelRouter); ok {
+		// Stops the probes
+		router.SetRouting(llm.RoutingConfig{})
+	}
+	for _, plugin := range s.plugins {
+		plugin.Close()
+	}
+	if s.index != nil {
+		s.stopWatcher()
+		s.index.Close()
+	}
+}
+
+// ApplyConfig applies the changes to the keys of cfg that can change while
+// running (see config.ReloadableKeys), so a reload needs no restart and
+// keeps queued tasks. Other keys are ignored.
+func (s *System) ApplyConfig(cfg *config.Config, changed []string) error {
+	// Validate before applying anything
+	approvalLevel, err := ParseRiskLevel(cfg.ApprovalRiskLevel)
+	if err != nil {
+		return err
+	}
+	terminal, _ := s.agents[TerminalAgent].(*TerminalAgentImpl)
+	for _, key := range changed {
+		switch key {
+		case "default_model":
+			s.llmClient.SetModel(cfg.DefaultModel)
+		case "model_routing":
+			if router, ok := s.llmClient.(ModelRouter); ok {
+				router.SetRouting(llm.RoutingConfig(cfg.ModelRouting))
+			}
+		case "approval_risk_level":
+			if terminal != nil {
+				terminal.SetApprovalLevel(approvalLevel)
+			}
+		case "llm_risk_check":
+			if terminal != nil {
+				terminal.safety.SetUseLLM(cfg.LLMRiskCheck)
+			}
+		case "instructions":
+			s.rules.SetInstructions(cfg.Instructions)
+		case "memory_learning":
+			s.learnMemory.Store(s.memory != nil && cfg.MemoryLearning)
+		}
+	}
+	return nil
+}
+
 // SetModel changes the model used by the LLM client
 func (s *System) SetModel(model string) {
 	s.llmClient.SetModel(model)
 }
 
-// HandleCommand handles special commands like /fix, /run, /explain, /create-project
-func (s *System) HandleCommand(ctx context.Context, command string, args string, workspaceDir string) (*TaskResult, error) {
+// ModelRouting is the rolling latency of the models requests are routed
+// between, and the model each routed request type goes to now
+type ModelRouting struct {
+	Models []llm.ModelLatency `json:"models"`
+	Routes map[string]string  `json:"routes"`
+}
+
+// ModelRouting returns the latencies and routes of latency-based model
+// routing, or nil if the LLM client cannot route
+func (s *System) ModelRouting() *ModelRouting {
+	router, ok := s.llmClient.(ModelRouter)
+	if !ok {
+		return nil
+	}
+	routing := &ModelRouting{Models: router.ModelLatencies(), Routes: make(map[string]string)}
+	for _, kind := range llm.RoutedRequests {
+		if model := router.RoutedModel(kind); model != "" {
+			routing.Routes[kind] = model
+		}
+	}
+	return routing
+}
+
+// HandleCommand handles special commands like /fix, /run, /git, /test, /review, /search, /db, /http, /scan, /lint, /migrate, /release, /bench, /k8s, /explain, /create-project
+// options carries command-specific settings (e.g. approval_id, shell) that are merged into the task data.
+func (s *System) HandleCommand(ctx context.Context, command string, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	workspaceDir, err := s.TenantWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return nil, err
+	}
 	switch command {
 	case "/fix":
-		return s.handleFixCommand(ctx, args, workspaceDir)
+		return s.handleFixCommand(ctx, args, workspaceDir, options)
 	case "/run":
-		return s.handleRunCommand(ctx, args, workspaceDir)
+		return s.handleRunCommand(ctx, args, workspaceDir, options)
+	case "/git":
+		return s.handleGitCommand(ctx, args, workspaceDir, options)
+	case "/test":
+		return s.handleTestCommand(ctx, args, workspaceDir, options)
+	case "/review":
+		return s.handleReviewCommand(ctx, args, workspaceDir, options)
+	case "/search":
+		return s.handleSearchCommand(ctx, args, workspaceDir)
+	case "/db":
+		return s.handleDatabaseCommand(ctx, args, workspaceDir, options)
+	case "/http":
+		return s.handleHTTPCommand(ctx, args, workspaceDir, options)
+	case "/scan":
+		return s.handleScanCommand(ctx, args, workspaceDir, options)
+	case "/lint":
+		return s.handleLintCommand(ctx, args, workspaceDir, options)
+	case "/migrate":
+		return s.handleMigrateCommand(ctx, args, workspaceDir, options)
+	case "/release":
+		return s.handleReleaseCommand(ctx, args, workspaceDir, options)
+	case "/bench":
+		return s.handleBenchCommand(ctx, args, workspaceDir, options)
+	case "/k8s":
+		return s.handleKubernetesCommand(ctx, args, workspaceDir, options)
 	case "/explain":
 		return s.handleExplainCommand(ctx, args, workspaceDir)
 	case "/create-project":
@@ -182,15 +1064,15 @@ func (s *System) HandleCommand(ctx context.Context, command string, args string,
 }
 
 // handleFixCommand handles the /fix command
-func (s *System) handleFixCommand(ctx context.Context, errorOutput string, workspaceDir string) (*TaskResult, error) {
+func (s *System) handleFixCommand(ctx context.Context, errorOutput string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
 	task := &Task{
-		ID:          generateTaskID(),
+		ID:          newTaskID(ctx),
 		Type:        DebugAgent,
 		Description: "Fix error in code",
-		Data: map[string]interface{}{
+		Data: withOptions(options, map[string]interface{}{
 			"error_output":  errorOutput,
 			"workspace_dir": workspaceDir,
-		},
+		}),
 		Status:    TaskPending,
 		CreatedAt: time.Now(),
 	}
@@ -199,14 +1081,89 @@ func (s *System) handleFixCommand(ctx context.Context, errorOutput string, works
 }
 
 // handleRunCommand handles the /run command
-func (s *System) handleRunCommand(ctx context.Context, instruction string, workspaceDir string) (*TaskResult, error) {
+func (s *System) handleRunCommand(ctx context.Context, instruction string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
 	task := &Task{
-		ID:          generateTaskID(),
+		ID:          newTaskID(ctx),
 		Type:        TerminalAgent,
 		Description: "Execute command",
-		Data: map[string]interface{}{
+		Data: withOptions(options, map[string]interface{}{
 			"instruction":   instruction,
 			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleGitCommand handles the /git command; args is the operation
+// (status, diff, branch, commit, stash, log)
+func (s *System) handleGitCommand(ctx context.Context, operation string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        GitAgent,
+		Description: "Git " + operation,
+		Data: withOptions(options, map[string]interface{}{
+			"operation":     strings.TrimSpace(operation),
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleTestCommand handles the /test command; args optionally selects a
+// target package, file or directory
+func (s *System) handleTestCommand(ctx context.Context, target string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        TestAgent,
+		Description: "Run tests",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if target = strings.TrimSpace(target); target != "" {
+		task.Data["target"] = target
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleReviewCommand handles the /review command; args optionally names
+// the base ref to review against
+func (s *System) handleReviewCommand(ctx context.Context, base string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        CodeReviewAgent,
+		Description: "Review changes",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if base = strings.TrimSpace(base); base != "" {
+		task.Data["base"] = base
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleSearchCommand handles the /search command
+func (s *System) handleSearchCommand(ctx context.Context, question string, workspaceDir string) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        SearchAgent,
+		Description: "Answer question about the codebase",
+		Data: map[string]interface{}{
+			"question":      question,
+			"workspace_dir": workspaceDir,
 		},
 		Status:    TaskPending,
 		CreatedAt: time.Now(),
@@ -215,10 +1172,207 @@ func (s *System) handleRunCommand(ctx context.Context, instruction string, works
 	return s.ExecuteTask(ctx, task)
 }
 
+// handleDatabaseCommand handles the /db command. args is an operation
+// (schema, query, migrate, explain) optionally followed by the SQL or a
+// natural language request.
+func (s *System) handleDatabaseCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	operation, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if operation == "" {
+		operation = "schema"
+	}
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        DatabaseAgent,
+		Description: "Database " + operation,
+		Data: withOptions(options, map[string]interface{}{
+			"operation":     operation,
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		// Raw SQL is passed through; anything else is a request for the LLM
+		if _, ok := task.Data["sql"]; !ok && looksLikeSQL(rest) {
+			task.Data["sql"] = rest
+		} else if _, ok := task.Data["request"]; !ok {
+			task.Data["request"] = rest
+		}
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleHTTPCommand handles the /http command. args is either
+// "METHOD URL" or a natural language description of the request.
+func (s *System) handleHTTPCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        HTTPRequestAgent,
+		Description: "HTTP request",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 2 && strings.Contains(fields[1], "://"):
+		task.Data["method"], task.Data["url"] = fields[0], fields[1]
+	case len(fields) == 1 && strings.Contains(fields[0], "://"):
+		task.Data["url"] = fields[0]
+	case len(fields) > 0:
+		task.Data["request"] = strings.TrimSpace(args)
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleScanCommand handles the /scan command; args optionally lists the
+// scanners to run
+func (s *System) handleScanCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        SecurityScanAgent,
+		Description: "Security scan",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if scanners := strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' }); len(scanners) > 0 {
+		names := make([]interface{}, len(scanners))
+		for i, name := range scanners {
+			names[i] = name
+		}
+		task.Data["scanners"] = names
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleLintCommand handles the /lint command; "/lint fix" also fixes the
+// violations
+func (s *System) handleLintCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        LintAgent,
+		Description: "Lint workspace",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if strings.TrimSpace(args) == "fix" {
+		task.Data["fix"] = true
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleMigrateCommand handles the /migrate command; args describes the
+// migration, e.g. "replace io/ioutil with os and io"
+func (s *System) handleMigrateCommand(ctx context.Context, request string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        MigrationAgent,
+		Description: "Migrate: " + truncateString(request, 80),
+		Data: withOptions(options, map[string]interface{}{
+			"request":       request,
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleReleaseCommand handles the /release command; args optionally is
+// the bump (major, minor, patch) or an explicit version
+func (s *System) handleReleaseCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        ReleaseAgent,
+		Description: "Prepare release",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	switch args = strings.TrimSpace(args); args {
+	case "":
+	case "major", "minor", "patch":
+		task.Data["bump"] = args
+	default:
+		task.Data["version"] = args
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleBenchCommand handles the /bench command. "/bench baseline" stores
+// a new baseline; otherwise args optionally is a benchmark name pattern.
+func (s *System) handleBenchCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        BenchmarkAgent,
+		Description: "Run benchmarks",
+		Data: withOptions(options, map[string]interface{}{
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	switch args = strings.TrimSpace(args); args {
+	case "":
+	case "baseline":
+		task.Data["operation"] = "baseline"
+		task.Description = "Store benchmark baseline"
+	default:
+		task.Data["pattern"] = args
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
+// handleKubernetesCommand handles the /k8s command. args is an operation
+// (generate, apply, diagnose) optionally followed by its target: the kind
+// (manifests, helm) for generate, a path for apply, a pod for diagnose.
+func (s *System) handleKubernetesCommand(ctx context.Context, args string, workspaceDir string, options map[string]interface{}) (*TaskResult, error) {
+	operation, target, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if operation == "" {
+		operation = "diagnose"
+	}
+	task := &Task{
+		ID:          newTaskID(ctx),
+		Type:        KubernetesAgent,
+		Description: "Kubernetes " + operation,
+		Data: withOptions(options, map[string]interface{}{
+			"operation":     operation,
+			"workspace_dir": workspaceDir,
+		}),
+		Status:    TaskPending,
+		CreatedAt: time.Now(),
+	}
+	if target = strings.TrimSpace(target); target != "" {
+		key := map[string]string{"generate": "kind", "apply": "path", "diagnose": "pod"}[operation]
+		if _, ok := task.Data[key]; key != "" && !ok {
+			task.Data[key] = target
+		}
+	}
+
+	return s.ExecuteTask(ctx, task)
+}
+
 // handleExplainCommand handles the /explain command
 func (s *System) handleExplainCommand(ctx context.Context, target string, workspaceDir string) (*TaskResult, error) {
 	task := &Task{
-		ID:          generateTaskID(),
+		ID:          newTaskID(ctx),
 		Type:        PlanningAgent,
 		Description: "Explain code or concept",
 		Data: map[string]interface{}{
@@ -235,10 +1389,11 @@ func (s *System) handleExplainCommand(ctx context.Context, target string, worksp
 // handleCreateProjectCommand handles the /create-project command
 func (s *System) handleCreateProjectCommand(ctx context.Context, description string, workspaceDir string) (*TaskResult, error) {
 	task := &Task{
-		ID:          generateTaskID(),
+		ID:          newTaskID(ctx),
 		Type:        PlanningAgent,
 		Description: "Create project from description",
 		Data: map[string]interface{}{
+			"request":       "/create-project " + description,
 			"description":   description,
 			"workspace_dir": workspaceDir,
 		},
@@ -249,6 +1404,34 @@ func (s *System) handleCreateProjectCommand(ctx context.Context, description str
 	return s.ExecuteTask(ctx, task)
 }
 
+// taskInstruction returns what the user asked for in a task, for auditing
+func taskInstruction(task *Task) string {
+	for _, key := range []string{"instruction", "request", "error_output"} {
+		if value, ok := task.Data[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return task.Description
+}
+
+// withOptions merges caller options into task data. Fields set by the
+// system take precedence over options with the same name.
+func withOptions(options, data map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(options)+len(data))
+	for k, v := range options {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return merged
+}
+
+// NewTaskID returns a new task ID, e.g. for ContextWithTaskID
+func NewTaskID() string {
+	return generateTaskID()
+}
+
 // generateTaskID generates a unique task ID
 func generateTaskID() string {
 	return fmt.Sprintf("task_%d", time.Now().UnixNano())
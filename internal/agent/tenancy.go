@@ -0,0 +1,259 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrOutsideTenant is returned for work on a workspace outside the
+// requester's workspace root
+var ErrOutsideTenant = errors.New("outside the tenant's workspace root")
+
+// tenantUserPattern guards directory names derived from users
+var tenantUserPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+// Tenant gives a user a workspace root of their own
+type Tenant struct {
+	User          string
+	WorkspaceRoot string
+}
+
+// TenancyConfig isolates users from each other. Tenants get the workspace
+// roots listed; other users get Root/<user> if Root is set and are
+// refused otherwise.
+type TenancyConfig struct {
+	Root    string
+	Tenants []Tenant
+}
+
+// Tenancy confines each user, identified by the requester of a context, to
+// their own workspace root, and remembers who started each task so task
+// histories stay separate. A nil Tenancy confines nobody.
+type Tenancy struct {
+	root  string
+	roots map[string]string
+
+	mu sync.Mutex
+	// owners maps task IDs to the user who started them
+	owners map[string]string
+}
+
+// NewTenancy creates the tenancy for cfg, or returns nil if it configures
+// no workspace roots
+func NewTenancy(cfg TenancyConfig) *Tenancy {
+	if cfg.Root == "" && len(cfg.Tenants) == 0 {
+		return nil
+	}
+	t := &Tenancy{roots: make(map[string]string), owners: make(map[string]string)}
+	if cfg.Root != "" {
+		t.root = absPath(cfg.Root)
+	}
+	for _, tenant := range cfg.Tenants {
+		t.roots[tenant.User] = absPath(tenant.WorkspaceRoot)
+	}
+	return t
+}
+
+// Root returns the workspace root of user, creating it if needed
+func (t *Tenancy) Root(user string) (string, error) {
+	root, ok := t.roots[user]
+	if !ok {
+		if t.root == "" || !tenantUserPattern.MatchString(user) {
+			return "", fmt.Errorf("%w: no workspace root for user %q", ErrOutsideTenant, user)
+		}
+		root = filepath.Join(t.root, user)
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", fmt.Errorf("failed to create workspace root: %w", err)
+	}
+	// Compare resolved paths so symlinks cannot lead out of the root
+	return filepath.EvalSymlinks(root)
+}
+
+// Workspace resolves the workspace directory user asked for: empty means
+// their workspace root and relative paths are relative to it. Directories
+// outside the root are refused with ErrOutsideTenant.
+func (t *Tenancy) Workspace(user, dir string) (string, error) {
+	root, err := t.Root(user)
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return root, nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	if !within(root, resolvePath(dir)) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideTenant, dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// Allows reports whether path is inside the workspace root of user
+func (t *Tenancy) Allows(user, path string) bool {
+	root, err := t.Root(user)
+	return err == nil && within(root, resolvePath(absPath(path)))
+}
+
+// claim records user as the owner of a task
+func (t *Tenancy) claim(taskID, user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.owners[taskID]; !ok {
+		t.owners[taskID] = user
+	}
+}
+
+// Owns reports whether user started the task. Sub-tasks belong to the
+// owner of the task that handed them off.
+func (t *Tenancy) Owns(user, taskID string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		if owner, ok := t.owners[taskID]; ok {
+			return owner == user
+		}
+		i := strings.LastIndex(taskID, ".")
+		if i < 0 {
+			return false
+		}
+		taskID = taskID[:i]
+	}
+}
+
+// resolvePath resolves the symlinks in the longest existing prefix of path,
+// so paths of files yet to be created can be checked too
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	var rest []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, rest...)...)
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// within reports whether path is root or inside it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Tenancy returns the tenancy, or nil if users are not isolated
+func (s *System) Tenancy() *Tenancy {
+	return s.tenancy
+}
+
+// tenant returns the user whose records the requester of ctx may see: the
+// requester when users are isolated, otherwise empty for everyone's
+func (s *System) tenant(ctx context.Context) string {
+	if s.tenancy == nil {
+		return ""
+	}
+	return RequesterFrom(ctx)
+}
+
+// OwnsTask reports whether the requester of ctx may see a task: always,
+// unless users are isolated and someone else started it
+func (s *System) OwnsTask(ctx context.Context, taskID string) bool {
+	return s.tenancy.Owns(RequesterFrom(ctx), taskID)
+}
+
+// TenantWorkspace resolves the workspace directory requested under ctx.
+// Without tenancy it is returned unchanged; with it, it must be inside the
+// requester's workspace root, which an empty directory stands for.
+func (s *System) TenantWorkspace(ctx context.Context, dir string) (string, error) {
+	if s.tenancy == nil {
+		return dir, nil
+	}
+	return s.tenancy.Workspace(RequesterFrom(ctx), dir)
+}
+
+// tenantFileManager refuses to touch files outside the workspace root of
+// the requester of its context (see fileManagerFor). Without a context it
+// touches nothing.
+type tenantFileManager struct {
+	FileManager
+	tenancy *Tenancy
+	ctx     context.Context
+}
+
+func (f *tenantFileManager) withContext(ctx context.Context) FileManager {
+	return &tenantFileManager{FileManager: fileManagerFor(ctx, f.FileManager), tenancy: f.tenancy, ctx: ctx}
+}
+
+func (f *tenantFileManager) check(path string) error {
+	if f.ctx == nil || !f.tenancy.Allows(RequesterFrom(f.ctx), path) {
+		return fmt.Errorf("%w: %s", ErrOutsideTenant, path)
+	}
+	return nil
+}
+
+func (f *tenantFileManager) CreateFile(path, content string) error {
+	if err := f.check(path); err != nil {
+		return err
+	}
+	return f.FileManager.CreateFile(path, content)
+}
+
+func (f *tenantFileManager) UpdateFile(path, content string) error {
+	if err := f.check(path); err != nil {
+		return err
+	}
+	return f.FileManager.UpdateFile(path, content)
+}
+
+func (f *tenantFileManager) DeleteFile(path string) error {
+	if err := f.check(path); err != nil {
+		return err
+	}
+	return f.FileManager.DeleteFile(path)
+}
+
+func (f *tenantFileManager) ReadFile(path string) (string, error) {
+	if err := f.check(path); err != nil {
+		return "", err
+	}
+	return f.FileManager.ReadFile(path)
+}
+
+func (f *tenantFileManager) ReadHead(path string, n int) (string, error) {
+	if err := f.check(path); err != nil {
+		return "", err
+	}
+	return f.FileManager.ReadHead(path, n)
+}
+
+func (f *tenantFileManager) ReadTail(path string, n int) (string, error) {
+	if err := f.check(path); err != nil {
+		return "", err
+	}
+	return f.FileManager.ReadTail(path, n)
+}
+
+func (f *tenantFileManager) FileExists(path string) bool {
+	return f.check(path) == nil && f.FileManager.FileExists(path)
+}
+
+func (f *tenantFileManager) ListFiles(dir string) ([]string, error) {
+	if err := f.check(dir); err != nil {
+		return nil, err
+	}
+	return f.FileManager.ListFiles(dir)
+}
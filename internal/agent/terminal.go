@@ -3,30 +3,74 @@ package agent
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
 
 type TerminalAgentImpl struct {
-	commandExec CommandExecutor
-	llmClient   LLMClient
-	logger      *zap.Logger
+	commandExec   CommandExecutor
+	llmClient     LLMClient
+	safety        *SafetyChecker
+	approvals     *ApprovalStore
+	levelMu       sync.RWMutex
+	approvalLevel RiskLevel
+	processes     *ProcessManager
+	events        *EventBus
+	// profiles describe the workspace to command generation
+	profiles *ProfileStore
+	logger   *zap.Logger
 }
 
-func NewTerminalAgent(commandExec CommandExecutor, llmClient LLMClient, logger *zap.Logger) *TerminalAgentImpl {
+// NewTerminalAgent creates a terminal agent. Generated commands at or above
+// approvalLevel are held until a user approves them.
+func NewTerminalAgent(commandExec CommandExecutor, llmClient LLMClient, safety *SafetyChecker, approvals *ApprovalStore, approvalLevel RiskLevel, processes *ProcessManager, events *EventBus, profiles *ProfileStore, logger *zap.Logger) *TerminalAgentImpl {
 	return &TerminalAgentImpl{
-		commandExec: commandExec,
-		llmClient:   llmClient,
-		logger:      logger,
+		commandExec:   commandExec,
+		llmClient:     llmClient,
+		safety:        safety,
+		approvals:     approvals,
+		approvalLevel: approvalLevel,
+		processes:     processes,
+		events:        events,
+		profiles:      profiles,
+		logger:        logger,
 	}
 }
 
+// SetApprovalLevel changes the risk level at which commands need approval
+func (t *TerminalAgentImpl) SetApprovalLevel(level RiskLevel) {
+	t.levelMu.Lock()
+	defer t.levelMu.Unlock()
+	t.approvalLevel = level
+}
+
+// needsApproval reports whether commands of a risk level need approval
+func (t *TerminalAgentImpl) needsApproval(level RiskLevel) bool {
+	t.levelMu.RLock()
+	defer t.levelMu.RUnlock()
+	return level.rank() >= t.approvalLevel.rank()
+}
+
 func (t *TerminalAgentImpl) Type() AgentType {
 	return TerminalAgent
 }
 
 func (t *TerminalAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
 	t.logger.Info("Terminal agent executing task", zap.String("task_id", task.ID))
+
+	// A previously approved command runs exactly as it was approved
+	if approvalID, ok := task.Data["approval_id"].(string); ok && approvalID != "" {
+		approval, err := t.approvals.Consume(approvalID, "command")
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		shell, _ := approval.Data["shell"].(string)
+		return t.runCommand(ctx, task, approval.Subject, approval.WorkingDir, Shell(shell), approval.Risk)
+	}
+
 	instruction, ok := task.Data["instruction"].(string)
 	if !ok {
 		return nil, fmt.Errorf("instruction not found in task data")
@@ -35,20 +79,219 @@ func (t *TerminalAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResul
 	if !ok {
 		workingDir = "."
 	}
-	command, err := t.llmClient.GenerateCommand(ctx, instruction)
+	shell, err := t.resolveShell(task, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	// The profile keeps generated commands to the project's own tooling
+	prompt := instruction
+	if profile := t.profiles.Prompt(workingDir); profile != "" {
+		prompt += "\n\n" + profile
+	}
+	if history := stringField(task.Data, "history"); history != "" {
+		prompt += "\n\nConversation so far, for resolving references in the instruction:\n" + history
+	}
+	command, err := t.llmClient.GenerateCommand(ctx, prompt, string(shell))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate command: %w", err)
 	}
-	result, err := t.commandExec.ExecuteCommand(command, workingDir)
+
+	risk := t.safety.Check(ctx, command)
+	if dryRun, _ := task.Data["dry_run"].(bool); dryRun {
+		return t.dryRun(ctx, command, workingDir, shell, risk)
+	}
+	if t.needsApproval(risk.Level) {
+		return t.requestApproval(task, command, workingDir, shell, risk), nil
+	}
+
+	return t.runCommand(ctx, task, command, workingDir, shell, risk)
+}
+
+// dryRun describes what a command would do without executing it
+func (t *TerminalAgentImpl) dryRun(ctx context.Context, command, workingDir string, shell Shell, risk *RiskAssessment) (*TaskResult, error) {
+	prompt := fmt.Sprintf(`Describe concisely what running this %s command in %s would do, listing files created, modified or deleted, network access, and installed packages:
+
+%s`, shell, workingDir, command)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a command-line expert. Predict the effects of shell commands without running them."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+
+	effects, err := t.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to predict command effects: %w", err)
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data: &CommandResult{
+			Command:          command,
+			Shell:            string(shell),
+			Risk:             risk,
+			DryRun:           true,
+			Effects:          effects,
+			RequiresApproval: t.needsApproval(risk.Level),
+		},
+	}, nil
+}
+
+// runInBackground decides whether a command is started as a managed
+// process: the task's "background" flag wins, otherwise known server and
+// watcher commands are detected
+func (t *TerminalAgentImpl) runInBackground(task *Task, command string) bool {
+	if background, ok := task.Data["background"].(bool); ok {
+		return background
+	}
+	return isLongRunning(command)
+}
+
+// startBackground starts a command as a managed background process
+func (t *TerminalAgentImpl) startBackground(ctx context.Context, task *Task, command, workingDir string, shell Shell, risk *RiskAssessment) (*TaskResult, error) {
+	if shell == "" {
+		shell = t.commandExec.DefaultShell()
+	}
+	proc, err := t.processes.Start(ctx, command, workingDir, shell, taskEnv(task))
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	return &TaskResult{
+		Success: true,
+		Data: &CommandResult{
+			Command:    command,
+			Shell:      string(shell),
+			Risk:       risk,
+			Background: true,
+			Process:    proc,
+		},
+	}, nil
+}
+
+// requestApproval holds a risky command until a user approves it
+func (t *TerminalAgentImpl) requestApproval(task *Task, command, workingDir string, shell Shell, risk *RiskAssessment) *TaskResult {
+	approval := t.holdCommand(task, command, workingDir, shell, risk)
+	return &TaskResult{
+		Success: false,
+		Error:   fmt.Sprintf("command requires approval (%s risk)", risk.Level),
+		Data: &CommandResult{
+			Command:          command,
+			Shell:            string(shell),
+			Risk:             risk,
+			RequiresApproval: true,
+			ApprovalID:       approval.ID,
+		},
+	}
+}
+
+// holdCommand requests approval for a risky command and announces it
+func (t *TerminalAgentImpl) holdCommand(task *Task, command, workingDir string, shell Shell, risk *RiskAssessment) *Approval {
+	approval := t.approvals.Request(&Approval{
+		TaskID:     task.ID,
+		Kind:       "command",
+		Subject:    command,
+		Risk:       risk,
+		WorkingDir: workingDir,
+		Data:       map[string]interface{}{"shell": string(shell)},
+	})
+	t.logger.Warn("Command requires approval",
+		zap.String("task_id", task.ID),
+		zap.String("approval_id", approval.ID),
+		zap.String("risk", string(risk.Level)))
+	t.events.Publish(TaskEvent{
+		TaskID: task.ID,
+		Type:   EventApprovalRequired,
+		Data:   map[string]interface{}{"approval_id": approval.ID, "command": command, "risk": risk},
+	})
+	return approval
+}
+
+// screenCommand gives a command that another agent runs for task, such as
+// a test command sent with it, the risk assessment of generated commands.
+// It returns nil if the command may run: its risk is below the approval
+// level, or the task carries the approval_id of its approved request,
+// which is consumed. Otherwise the command is held and the returned
+// approval is what the task waits for before being resubmitted.
+func (t *TerminalAgentImpl) screenCommand(ctx context.Context, task *Task, command, workingDir string) (*Approval, error) {
+	if approvalID := stringField(task.Data, "approval_id"); approvalID != "" {
+		if approval, ok := t.approvals.Get(approvalID); ok && approval.Subject != command {
+			return nil, fmt.Errorf("approval %s is for %q, not %q", approvalID, approval.Subject, command)
+		}
+		_, err := t.approvals.Consume(approvalID, "command")
+		return nil, err
+	}
+	risk := t.safety.Check(ctx, command)
+	if !t.needsApproval(risk.Level) {
+		return nil, nil
+	}
+	return t.holdCommand(task, command, workingDir, t.commandExec.DefaultShell(), risk), nil
+}
+
+// runCommand executes a command for a terminal task
+func (t *TerminalAgentImpl) runCommand(ctx context.Context, task *Task, command, workingDir string, shell Shell, risk *RiskAssessment) (*TaskResult, error) {
+	if t.runInBackground(task, command) {
+		return t.startBackground(ctx, task, command, workingDir, shell, risk)
+	}
+
+	opts := CommandOptions{Shell: shell, Env: taskEnv(task)}
+	if seconds, ok := task.Data["timeout_seconds"].(float64); ok && seconds > 0 {
+		opts.Timeout = time.Duration(seconds * float64(time.Second))
+	}
+	result, err := t.commandExec.ExecuteCommandStream(ctx, command, workingDir, opts,
+		t.outputPublisher(task.ID, "stdout"), t.outputPublisher(task.ID, "stderr"))
 	if err != nil {
 		return &TaskResult{Success: false, Error: err.Error()}, nil
 	}
 	return &TaskResult{
 		Success: result.Error == "",
-		Data: map[string]interface{}{
-			"command": command,
-			"output":  result.Output,
-			"error":   result.Error,
+		Data: &CommandResult{
+			Command:   command,
+			Shell:     string(shell),
+			Risk:      risk,
+			Status:    result.Status,
+			Output:    result.Output,
+			Error:     result.Error,
+			ExitCode:  result.ExitCode,
+			Truncated: result.Truncated,
 		},
 	}, nil
 }
+
+// resolveShell picks the shell for a task: the task's "shell" field, then
+// the workspace settings, then the executor default
+func (t *TerminalAgentImpl) resolveShell(task *Task, workingDir string) (Shell, error) {
+	if name, ok := task.Data["shell"].(string); ok && name != "" {
+		return ParseShell(name)
+	}
+	settings, err := LoadWorkspaceSettings(workingDir)
+	if err != nil {
+		return "", err
+	}
+	if settings.Shell != "" {
+		return ParseShell(settings.Shell)
+	}
+	return t.commandExec.DefaultShell(), nil
+}
+
+// taskEnv extracts the per-task environment variables from the "env" field
+func taskEnv(task *Task) map[string]string {
+	raw, ok := task.Data["env"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	env := make(map[string]string, len(raw))
+	for name, value := range raw {
+		env[name] = fmt.Sprint(value)
+	}
+	return env
+}
+
+// outputPublisher forwards command output chunks to the task event stream
+func (t *TerminalAgentImpl) outputPublisher(taskID, stream string) func(string) {
+	return func(chunk string) {
+		t.events.Publish(TaskEvent{
+			TaskID: taskID,
+			Type:   EventCommandOutput,
+			Data:   map[string]interface{}{"stream": stream, "chunk": chunk},
+		})
+	}
+}
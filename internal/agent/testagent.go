@@ -0,0 +1,466 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// TestFramework identifies a test runner
+type TestFramework string
+
+const (
+	FrameworkGo     TestFramework = "go"
+	FrameworkPytest TestFramework = "pytest"
+	FrameworkJest   TestFramework = "jest"
+	FrameworkVitest TestFramework = "vitest"
+	FrameworkCargo  TestFramework = "cargo"
+)
+
+// TestCaseResult is the outcome of a single test
+type TestCaseResult struct {
+	Name     string        `json:"name"`
+	Suite    string        `json:"suite,omitempty"`
+	Status   string        `json:"status"` // passed, failed or skipped
+	Duration time.Duration `json:"duration,omitempty"`
+	Output   string        `json:"output,omitempty"`
+}
+
+// TestReport is the structured result of a test run
+type TestReport struct {
+	Framework TestFramework    `json:"framework"`
+	Command   string           `json:"command"`
+	Passed    int              `json:"passed"`
+	Failed    int              `json:"failed"`
+	Skipped   int              `json:"skipped"`
+	Tests     []TestCaseResult `json:"tests"`
+	ExitCode  int              `json:"exit_code"`
+	// Output holds the raw runner output when no per-test results could be parsed
+	Output string `json:"output,omitempty"`
+}
+
+func (r *TestReport) add(test TestCaseResult) {
+	switch test.Status {
+	case "passed":
+		r.Passed++
+	case "failed":
+		r.Failed++
+	default:
+		r.Skipped++
+	}
+	r.Tests = append(r.Tests, test)
+}
+
+// maxTestOutputChars bounds the output kept per failed test
+const maxTestOutputChars = 4000
+
+// TestAgent discovers, runs and writes tests
+type TestAgentImpl struct {
+	llmClient   LLMClient
+	fileManager FileManager
+	commandExec CommandExecutor
+	logger      *zap.Logger
+}
+
+// NewTestAgent creates a new test agent
+func NewTestAgent(llmClient LLMClient, fileManager FileManager, commandExec CommandExecutor, logger *zap.Logger) *TestAgentImpl {
+	return &TestAgentImpl{
+		llmClient:   llmClient,
+		fileManager: fileManager,
+		commandExec: commandExec,
+		logger:      logger,
+	}
+}
+
+// Type returns the agent type
+func (t *TestAgentImpl) Type() AgentType {
+	return TestAgent
+}
+
+// Execute executes a test task. Operations: "detect", "run" (default) and "generate".
+func (t *TestAgentImpl) Execute(ctx context.Context, task *Task) (*TaskResult, error) {
+	t.logger.Info("Test agent executing task", zap.String("task_id", task.ID))
+
+	workspaceDir, ok := task.Data["workspace_dir"].(string)
+	if !ok {
+		workspaceDir = "."
+	}
+
+	operation, _ := task.Data["operation"].(string)
+	switch operation {
+	case "detect":
+		framework, err := DetectTestFramework(workspaceDir)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		return &TaskResult{Success: true, Data: Fields{"framework": framework}}, nil
+	case "", "run":
+		return t.handleRun(ctx, task, workspaceDir)
+	case "generate":
+		return t.handleGenerate(ctx, task, workspaceDir)
+	default:
+		return nil, fmt.Errorf("unknown test operation: %s", operation)
+	}
+}
+
+// DetectTestFramework picks the test runner from the project's marker files
+func DetectTestFramework(workspaceDir string) (TestFramework, error) {
+	switch {
+	case fileExists(workspaceDir, "go.mod"):
+		return FrameworkGo, nil
+	case fileExists(workspaceDir, "Cargo.toml"):
+		return FrameworkCargo, nil
+	case fileExists(workspaceDir, "package.json"):
+		data, _ := os.ReadFile(filepath.Join(workspaceDir, "package.json"))
+		if strings.Contains(string(data), `"vitest"`) {
+			return FrameworkVitest, nil
+		}
+		return FrameworkJest, nil
+	case fileExists(workspaceDir, "pyproject.toml"), fileExists(workspaceDir, "pytest.ini"),
+		fileExists(workspaceDir, "setup.py"), fileExists(workspaceDir, "requirements.txt"):
+		return FrameworkPytest, nil
+	}
+	return "", fmt.Errorf("could not detect a test framework in %s", workspaceDir)
+}
+
+// handleRun runs the suite, or the subset selected by "target" (a package,
+// file or directory) and "pattern" (a test name filter)
+func (t *TestAgentImpl) handleRun(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	framework := TestFramework(stringField(task.Data, "framework"))
+	if framework == "" {
+		detected, err := DetectTestFramework(workspaceDir)
+		if err != nil {
+			return &TaskResult{Success: false, Error: err.Error()}, nil
+		}
+		framework = detected
+	}
+
+	command, err := testCommand(t.commandExec.DefaultShell(), framework, stringField(task.Data, "target"), stringField(task.Data, "pattern"))
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := t.commandExec.ExecuteCommand(ctx, command, workspaceDir, CommandOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run tests: %w", err)
+	}
+	if run.Status == "timeout" || run.Status == "cancelled" {
+		return &TaskResult{Success: false, Error: fmt.Sprintf("test command %s", run.Status)}, nil
+	}
+
+	report := &TestReport{Framework: framework, Command: command, ExitCode: run.ExitCode}
+	parseTestOutput(report, run.Output, run.Error)
+	if len(report.Tests) == 0 {
+		report.Output = truncateString(run.Output+"\n"+run.Error, maxTestOutputChars)
+	}
+
+	result := &TaskResult{
+		Success: run.Status == "completed" && report.Failed == 0,
+		Data:    Fields{"report": report},
+	}
+	if !result.Success {
+		result.Error = fmt.Sprintf("%d test(s) failed", report.Failed)
+		if report.Failed == 0 {
+			result.Error = "test command failed"
+		}
+	}
+	return result, nil
+}
+
+// testCommand builds the runner invocation with machine-readable output
+// where available. A target or pattern starting with "-" is refused: the
+// runner would read it as an option, such as go test -exec, however quoted.
+func testCommand(shell Shell, framework TestFramework, target, pattern string) (string, error) {
+	if strings.HasPrefix(target, "-") {
+		return "", fmt.Errorf("invalid test target: %s", target)
+	}
+	if strings.HasPrefix(pattern, "-") {
+		return "", fmt.Errorf("invalid test pattern: %s", pattern)
+	}
+	var parts []string
+	switch framework {
+	case FrameworkGo:
+		if target == "" {
+			target = "./..."
+		}
+		parts = []string{"go test -json", shell.Quote(target)}
+		if pattern != "" {
+			parts = append(parts, "-run", shell.Quote(pattern))
+		}
+	case FrameworkPytest:
+		parts = []string{"pytest -rA -q"}
+		if target != "" {
+			parts = append(parts, shell.Quote(target))
+		}
+		if pattern != "" {
+			parts = append(parts, "-k", shell.Quote(pattern))
+		}
+	case FrameworkJest:
+		parts = []string{"npx jest --json"}
+		if target != "" {
+			parts = append(parts, shell.Quote(target))
+		}
+		if pattern != "" {
+			parts = append(parts, "-t", shell.Quote(pattern))
+		}
+	case FrameworkVitest:
+		parts = []string{"npx vitest run --reporter=json"}
+		if target != "" {
+			parts = append(parts, shell.Quote(target))
+		}
+		if pattern != "" {
+			parts = append(parts, "-t", shell.Quote(pattern))
+		}
+	case FrameworkCargo:
+		parts = []string{"cargo test"}
+		if pattern != "" {
+			parts = append(parts, shell.Quote(pattern))
+		} else if target != "" {
+			parts = append(parts, shell.Quote(target))
+		}
+	default:
+		return "", fmt.Errorf("unsupported test framework: %s", framework)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// parseTestOutput fills report with per-test results from runner output
+func parseTestOutput(report *TestReport, stdout, stderr string) {
+	switch report.Framework {
+	case FrameworkGo:
+		parseGoTestJSON(report, stdout)
+	case FrameworkJest, FrameworkVitest:
+		parseJestJSON(report, stdout)
+	case FrameworkPytest:
+		parsePytest(report, stdout+"\n"+stderr)
+	case FrameworkCargo:
+		parseCargoTest(report, stdout)
+	}
+}
+
+// parseGoTestJSON reads the event stream of `go test -json`
+func parseGoTestJSON(report *TestReport, output string) {
+	type event struct {
+		Action  string
+		Package string
+		Test    string
+		Elapsed float64
+		Output  string
+	}
+	outputs := make(map[string]*strings.Builder)
+
+	scanner := newLineScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Test == "" {
+			continue
+		}
+		key := e.Package + "." + e.Test
+		switch e.Action {
+		case "output":
+			if outputs[key] == nil {
+				outputs[key] = &strings.Builder{}
+			}
+			outputs[key].WriteString(e.Output)
+		case "pass", "fail", "skip":
+			test := TestCaseResult{
+				Name:     e.Test,
+				Suite:    e.Package,
+				Status:   map[string]string{"pass": "passed", "fail": "failed", "skip": "skipped"}[e.Action],
+				Duration: time.Duration(e.Elapsed * float64(time.Second)),
+			}
+			if e.Action == "fail" && outputs[key] != nil {
+				test.Output = truncateString(outputs[key].String(), maxTestOutputChars)
+			}
+			report.add(test)
+		}
+	}
+}
+
+// parseJestJSON reads the --json report shared by jest and vitest
+func parseJestJSON(report *TestReport, output string) {
+	var results struct {
+		TestResults []struct {
+			Name             string `json:"name"`
+			AssertionResults []struct {
+				FullName        string   `json:"fullName"`
+				Status          string   `json:"status"`
+				Duration        *float64 `json:"duration"`
+				FailureMessages []string `json:"failureMessages"`
+			} `json:"assertionResults"`
+		} `json:"testResults"`
+	}
+	if err := json.Unmarshal([]byte(extractJSON(output)), &results); err != nil {
+		return
+	}
+
+	for _, file := range results.TestResults {
+		for _, assertion := range file.AssertionResults {
+			test := TestCaseResult{Name: assertion.FullName, Suite: file.Name, Status: assertion.Status}
+			if test.Status != "passed" && test.Status != "failed" {
+				test.Status = "skipped"
+			}
+			if assertion.Duration != nil {
+				test.Duration = time.Duration(*assertion.Duration * float64(time.Millisecond))
+			}
+			if test.Status == "failed" {
+				test.Output = truncateString(strings.Join(assertion.FailureMessages, "\n"), maxTestOutputChars)
+			}
+			report.add(test)
+		}
+	}
+}
+
+// pytestResult matches the short test summary lines printed by `pytest -rA`
+var pytestResult = regexp.MustCompile(`^(PASSED|FAILED|ERROR|SKIPPED|XFAIL|XPASS)\s+(\S+)(?:\s+-\s+(.*))?$`)
+
+func parsePytest(report *TestReport, output string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		m := pytestResult.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		test := TestCaseResult{Name: m[2], Output: m[3]}
+		if suite, name, ok := strings.Cut(m[2], "::"); ok {
+			test.Suite, test.Name = suite, name
+		}
+		switch m[1] {
+		case "PASSED", "XPASS":
+			test.Status = "passed"
+		case "FAILED", "ERROR":
+			test.Status = "failed"
+		default:
+			test.Status = "skipped"
+		}
+		report.add(test)
+	}
+}
+
+// cargoResult matches `test path::name ... ok` lines of cargo test
+var cargoResult = regexp.MustCompile(`^test (\S+) \.\.\. (ok|FAILED|ignored)`)
+
+func parseCargoTest(report *TestReport, output string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		m := cargoResult.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		status := map[string]string{"ok": "passed", "FAILED": "failed", "ignored": "skipped"}[m[2]]
+		report.add(TestCaseResult{Name: m[1], Status: status})
+	}
+}
+
+// handleGenerate writes tests for the source file at "path". An existing
+// test file is only replaced when "overwrite" is true.
+func (t *TestAgentImpl) handleGenerate(ctx context.Context, task *Task, workspaceDir string) (*TaskResult, error) {
+	path, ok := task.Data["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("path not found in task data")
+	}
+	fullPath := filepath.Join(workspaceDir, path)
+	source, err := fileManagerFor(ctx, t.fileManager).ReadFile(fullPath)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	testPath, framework, err := testFileFor(workspaceDir, fullPath)
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+	overwrite, _ := task.Data["overwrite"].(bool)
+	if fileManagerFor(ctx, t.fileManager).FileExists(testPath) && !overwrite {
+		return &TaskResult{Success: false, Error: fmt.Sprintf("test file %s already exists; set overwrite to replace it", testPath)}, nil
+	}
+
+	prompt := fmt.Sprintf(`Write %s tests for the file %s, to be saved as %s.
+Cover the exported behavior, edge cases and error paths. Do not test private helpers directly unless necessary.
+Respond with only the complete test file.
+
+%s`, framework, filepath.Base(fullPath), filepath.Base(testPath), source)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are an expert at writing focused, idiomatic unit tests."},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	}
+	response, err := t.llmClient.Chat(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tests: %w", err)
+	}
+	content := stripCodeFence(response)
+
+	if fileManagerFor(ctx, t.fileManager).FileExists(testPath) {
+		err = fileManagerFor(ctx, t.fileManager).UpdateFile(testPath, content)
+	} else {
+		err = fileManagerFor(ctx, t.fileManager).CreateFile(testPath, content)
+	}
+	if err != nil {
+		return &TaskResult{Success: false, Error: err.Error()}, nil
+	}
+
+	return &TaskResult{
+		Success: true,
+		Data:    Fields{"path": testPath, "framework": framework, "content": content},
+	}, nil
+}
+
+// testFileFor returns where tests for source conventionally live
+func testFileFor(workspaceDir, source string) (string, TestFramework, error) {
+	ext := filepath.Ext(source)
+	base := strings.TrimSuffix(source, ext)
+	switch ext {
+	case ".go":
+		return base + "_test.go", FrameworkGo, nil
+	case ".py":
+		return filepath.Join(filepath.Dir(source), "test_"+filepath.Base(base)+".py"), FrameworkPytest, nil
+	case ".js", ".jsx", ".ts", ".tsx", ".mjs":
+		framework, err := DetectTestFramework(workspaceDir)
+		if err != nil || framework != FrameworkVitest {
+			framework = FrameworkJest
+		}
+		return base + ".test" + ext, framework, nil
+	default:
+		return "", "", fmt.Errorf("test generation is not supported for %s files", ext)
+	}
+}
+
+// stripCodeFence removes a Markdown code fence wrapped around an LLM response
+func stripCodeFence(response string) string {
+	text := strings.TrimSpace(response)
+	if !strings.HasPrefix(text, "```") {
+		return text + "\n"
+	}
+	if nl := strings.Index(text, "\n"); nl >= 0 {
+		text = text[nl+1:]
+	}
+	text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	return strings.TrimSpace(text) + "\n"
+}
+
+// stringField returns a string task field, or "" if absent
+func stringField(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
+
+// stringList returns the strings of a JSON array task field
+func stringList(data map[string]interface{}, key string) []string {
+	items, _ := data[key].([]interface{})
+	var values []string
+	for _, item := range items {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"spilot-agent/internal/tickets"
+
+	"go.uber.org/zap"
+)
+
+// ErrTicket is returned when the issue a request is linked to cannot be
+// read, or no issue tracker is configured
+var ErrTicket = errors.New("issue unavailable")
+
+// maxTicketContext caps the text of an issue given to the planner
+const maxTicketContext = 12000
+
+type ticketKey struct{}
+
+// ticketLink is the issue linked to the requests processed with a context
+type ticketLink struct {
+	key    string
+	create bool
+}
+
+// ContextWithTicket links the plans of requests processed with ctx to the
+// issue with key: the issue informs the plan, and a summary is posted on
+// it when the plan has been executed
+func ContextWithTicket(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, ticketKey{}, ticketLink{key: key})
+}
+
+// ContextCreatingTicket has the plans of requests processed with ctx
+// recorded in a new issue, which they are then linked to
+func ContextCreatingTicket(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ticketKey{}, ticketLink{create: true})
+}
+
+func ticketFrom(ctx context.Context) ticketLink {
+	link, _ := ctx.Value(ticketKey{}).(ticketLink)
+	return link
+}
+
+// Tickets returns the issue tracker, or nil if none is configured
+func (s *System) Tickets() tickets.Tracker {
+	return s.tickets
+}
+
+// ticketData returns the planning task data linking the plan of a request
+// to the issue ctx names, with the issue as context, or nil if ctx names
+// none. A request left empty becomes resolving the issue.
+func (s *System) ticketData(ctx context.Context, request string) (map[string]interface{}, string, error) {
+	link := ticketFrom(ctx)
+	if link.key == "" && !link.create {
+		return nil, request, nil
+	}
+	if s.tickets == nil {
+		return nil, request, fmt.Errorf("%w: no issue tracker is configured", ErrTicket)
+	}
+	if link.create {
+		return map[string]interface{}{"create_ticket": true}, request, nil
+	}
+	issue, err := s.tickets.Issue(ctx, link.key)
+	if err != nil {
+		return nil, request, fmt.Errorf("%w: %s: %w", ErrTicket, link.key, err)
+	}
+	if strings.TrimSpace(request) == "" {
+		request = fmt.Sprintf("Resolve issue %s: %s", issue.Key, issue.Title)
+	}
+	return map[string]interface{}{
+		"ticket":         issue.Key,
+		"ticket_context": fenceUntrusted("issue "+issue.Key, truncateString(issue.Text(), maxTicketContext)),
+	}, request, nil
+}
+
+// linkPlanTicket links a generated plan to the issue of its planning task,
+// creating the issue first if the task asks for one, and notes the issue in
+// the planning result. The link goes into the plan's approval data.
+func (s *System) linkPlanTicket(ctx context.Context, task *Task, plan *PlanResult) string {
+	key := stringField(task.Data, "ticket")
+	if create, _ := task.Data["create_ticket"].(bool); create && s.tickets != nil {
+		issue, err := s.tickets.Create(ctx, truncateSubject(taskInstruction(task)), "Planned by Spilot as task "+task.ID+":\n\n"+planSteps(plan.Plan))
+		if err != nil {
+			s.logger.Warn("Failed to create issue for plan", zap.String("task_id", task.ID), zap.Error(err))
+			plan.TicketError = err.Error()
+			return ""
+		}
+		key = issue.Key
+		plan.TicketURL = issue.URL
+	}
+	plan.Ticket = key
+	return key
+}
+
+// postPlanSummary posts on the issue with key what the execution of the
+// tasks of a plan linked to it did
+func (s *System) postPlanSummary(ctx context.Context, key, parentID string, tasks []*Task, results []*TaskResult, err error) {
+	body := fmt.Sprintf("Spilot executed the plan for this issue as task %s:\n\n%s", parentID, planOutcome(tasks, results, err))
+	if err := s.tickets.Comment(ctx, key, strings.TrimSpace(body)); err != nil {
+		s.logger.Warn("Failed to post plan summary on issue", zap.String("ticket", key), zap.String("task_id", parentID), zap.Error(err))
+	}
+}
+
+// planSteps renders a generated plan as a numbered list of its tasks, or
+// returns it as it is if it cannot be read
+func planSteps(plan string) string {
+	tasks, err := ParsePlan(plan)
+	if err != nil || len(tasks) == 0 {
+		return plan
+	}
+	var b strings.Builder
+	for i, task := range tasks {
+		fmt.Fprintf(&b, "%d. %s (%s)\n", i+1, task.Description, task.Type)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ToolRunner runs the programs agents call directly with explicit
+// arguments, such as git, formatters and ctags, rather than as shell
+// commands through the command executor. They get the executor's
+// environment filtering and network restrictions all the same, are
+// recorded in the audit log, and operations that change the repository are
+// held to the same API key scopes and policy as file writes and commands.
+type ToolRunner struct {
+	envFilter *envFilter
+	egress    *Egress
+	policy    *PolicyGuard
+	audit     CommandAuditLog
+	logger    *zap.Logger
+}
+
+// NewToolRunner creates a tool runner with the executor's environment
+// filtering and network restrictions, recording runs in audit and checking
+// changes against policy; both may be nil
+func NewToolRunner(cfg ExecutorConfig, audit CommandAuditLog, policy *PolicyGuard, logger *zap.Logger) *ToolRunner {
+	return &ToolRunner{
+		envFilter: newEnvFilter(cfg.EnvAllowlist, cfg.EnvDenylist),
+		egress:    cfg.Egress,
+		policy:    policy,
+		audit:     audit,
+		logger:    logger,
+	}
+}
+
+// run runs a program in dir and returns its stdout, which is also returned
+// when it fails. Errors carry the program's stderr. A nil ToolRunner runs
+// programs unrestricted and unrecorded.
+func (t *ToolRunner) run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	if t != nil {
+		cmd.Env = t.envFilter.environ(t.egress.env(nil))
+		if err := t.egress.apply(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	t.record(ctx, cmd, strings.Join(append([]string{name}, args...), " "), dir, start, err)
+	return stdout.Bytes(), err
+}
+
+// record writes a finished run to the audit log. Output is not recorded:
+// it is file content and diffs rather than what the program did.
+func (t *ToolRunner) record(ctx context.Context, cmd *exec.Cmd, command, dir string, start time.Time, err error) {
+	if t == nil || t.audit == nil {
+		return
+	}
+	origin := commandOriginFrom(ctx)
+	entry := &CommandAuditEntry{
+		ID:          fmt.Sprintf("tool_%d", start.UnixNano()),
+		TaskID:      origin.TaskID,
+		Requester:   RequesterFrom(ctx),
+		Instruction: origin.Instruction,
+		Command:     command,
+		WorkingDir:  absPath(dir),
+		Status:      "completed",
+		ExitCode:    exitCode(cmd, err),
+		Duration:    time.Since(start),
+		Timestamp:   start,
+	}
+	if err != nil {
+		entry.Status = "failed"
+		entry.Error = truncateString(err.Error(), auditOutputBytes)
+	}
+	if err := t.audit.Record(entry); err != nil {
+		t.logger.Error("Failed to record tool run in audit log", zap.Error(err))
+	}
+}
+
+// git runs git with explicit arguments, bypassing the shell so branch
+// names and commit messages need no quoting. It returns trimmed stdout.
+func (t *ToolRunner) git(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := t.run(ctx, dir, "git", args...)
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// gitChange runs a git operation that changes the repository, such as a
+// commit or a branch switch. It needs the files scope, like file writes,
+// and is checked against the policy as a command, since git hooks run
+// with it.
+func (t *ToolRunner) gitChange(ctx context.Context, dir string, args ...string) (string, error) {
+	if err := RequireScope(ctx, ScopeFiles); err != nil {
+		return "", err
+	}
+	command := "git " + strings.Join(args, " ")
+	if err := t.policy.Check(ctx, PolicyInput{Action: PolicyCommand, Command: command, WorkingDir: absPath(dir)}); err != nil {
+		return "", err
+	}
+	return t.git(ctx, dir, args...)
+}
+
+// checkGitRef rejects a ref that git would parse as an option, such as
+// diff's --output
+func checkGitRef(ref string) error {
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid git ref: %s", ref)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// transcriptResultBytes caps the result data written per task in Markdown
+const transcriptResultBytes = 8000
+
+// Transcript is an exportable record of a session or a task: the messages,
+// the commands run, the changes made and the results
+type Transcript struct {
+	Title        string           `json:"title"`
+	SessionID    string           `json:"session_id,omitempty"`
+	WorkspaceDir string           `json:"workspace_dir,omitempty"`
+	ExportedAt   time.Time        `json:"exported_at"`
+	Messages     []SessionMessage `json:"messages,omitempty"`
+	Tasks        []TaskTranscript `json:"tasks"`
+}
+
+// TaskTranscript is what a task and its subtasks did. Results are only
+// known for tasks run since the agent started; commands come from the
+// audit log when it is enabled.
+type TaskTranscript struct {
+	TaskID   string                 `json:"task_id"`
+	Result   *TaskResult            `json:"result,omitempty"`
+	Subtasks map[string]*TaskResult `json:"subtasks,omitempty"`
+	Commands []*CommandAuditEntry   `json:"commands,omitempty"`
+	Diffs    []string               `json:"diffs,omitempty"`
+}
+
+// SessionTranscript returns the transcript of a session
+func (s *System) SessionTranscript(ctx context.Context, id string) (*Transcript, error) {
+	session, err := s.Session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	transcript := &Transcript{
+		Title:        session.Title,
+		SessionID:    session.ID,
+		WorkspaceDir: session.WorkspaceDir,
+		ExportedAt:   time.Now(),
+		Messages:     session.Messages,
+		Tasks:        []TaskTranscript{},
+	}
+	if transcript.Title == "" {
+		transcript.Title = "Session " + session.ID
+	}
+	for _, message := range session.Messages {
+		if message.TaskID != "" {
+			transcript.Tasks = append(transcript.Tasks, s.taskTranscript(message.TaskID))
+		}
+	}
+	return transcript, nil
+}
+
+// TaskTranscript returns the transcript of a task, such as the execution of
+// a plan
+func (s *System) TaskTranscript(ctx context.Context, taskID string) (*Transcript, error) {
+	task := s.taskTranscript(taskID)
+	if task.Result == nil && len(task.Commands) == 0 || !s.OwnsTask(ctx, taskID) {
+		return nil, fmt.Errorf("task %s not found", taskID)
+	}
+	return &Transcript{
+		Title:      "Task " + taskID,
+		ExportedAt: time.Now(),
+		Tasks:      []TaskTranscript{task},
+	}, nil
+}
+
+func (s *System) taskTranscript(taskID string) TaskTranscript {
+	task := TaskTranscript{TaskID: taskID}
+	task.Result, _ = s.GetTaskResult(taskID)
+	task.Diffs = s.resultDiffs(task.Result)
+
+	// Subtasks handed off by the task are numbered after it
+	var subtaskIDs []string
+	subtasks := make(map[string]*TaskResult)
+	s.resultsMu.RLock()
+	for id, result := range s.results {
+		if strings.HasPrefix(id, taskID+".") {
+			subtaskIDs = append(subtaskIDs, id)
+			subtasks[id] = result
+		}
+	}
+	s.resultsMu.RUnlock()
+	sort.Strings(subtaskIDs)
+	for _, id := range subtaskIDs {
+		if task.Subtasks == nil {
+			task.Subtasks = make(map[string]*TaskResult)
+		}
+		task.Subtasks[id] = subtasks[id]
+		task.Diffs = append(task.Diffs, s.resultDiffs(subtasks[id])...)
+	}
+
+	if s.auditLog != nil {
+		for _, id := range append([]string{taskID}, subtaskIDs...) {
+			entries, err := s.auditLog.Query(AuditFilter{TaskID: id})
+			if err != nil {
+				s.logger.Warn("Failed to read commands for transcript", zap.String("task_id", id), zap.Error(err))
+				continue
+			}
+			task.Commands = append(task.Commands, entries...)
+		}
+	}
+	return task
+}
+
+// resultDiffs returns the changes recorded in a result, as a diff, which
+// may have been written to disk, or as patches
+func (s *System) resultDiffs(result *TaskResult) []string {
+	if result == nil {
+		return nil
+	}
+	var diffs []string
+	if diff := s.outputs.text(result.Fields()["diff"]); strings.TrimSpace(diff) != "" {
+		diffs = append(diffs, diff)
+	}
+	patches, _ := result.Fields()["patches"].([]FilePatch)
+	if debug := result.Debug(); debug != nil {
+		patches = debug.Patches
+	}
+	for _, patch := range patches {
+		diffs = append(diffs, patchDiff(patch))
+	}
+	return diffs
+}
+
+// patchDiff renders a patch in unified diff style, without line numbers
+func patchDiff(patch FilePatch) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", patch.Path, patch.Path)
+	removed, added := patch.Search, patch.Replace
+	if patch.Search == "" {
+		added = patch.Content
+	}
+	for _, line := range splitLines(removed) {
+		b.WriteString("-" + line + "\n")
+	}
+	for _, line := range splitLines(added) {
+		b.WriteString("+" + line + "\n")
+	}
+	return b.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Markdown renders the transcript for PRs and incident documents
+func (t *Transcript) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", t.Title)
+	if t.SessionID != "" {
+		fmt.Fprintf(&b, "- Session: `%s`\n", t.SessionID)
+	}
+	if t.WorkspaceDir != "" {
+		fmt.Fprintf(&b, "- Workspace: `%s`\n", t.WorkspaceDir)
+	}
+	fmt.Fprintf(&b, "- Exported: %s\n", t.ExportedAt.Format(time.RFC3339))
+
+	if len(t.Messages) > 0 {
+		b.WriteString("\n## Conversation\n")
+		for _, message := range t.Messages {
+			fmt.Fprintf(&b, "\n**%s** (%s)", message.Role, message.CreatedAt.Format(time.RFC3339))
+			if message.TaskID != "" {
+				fmt.Fprintf(&b, " · task `%s`", message.TaskID)
+			}
+			fmt.Fprintf(&b, "\n\n%s\n", message.Content)
+		}
+	}
+
+	for _, task := range t.Tasks {
+		fmt.Fprintf(&b, "\n## Task `%s`\n\n", task.TaskID)
+		b.WriteString("Result: " + resultStatus(task.Result) + "\n")
+		subtaskIDs := make([]string, 0, len(task.Subtasks))
+		for id := range task.Subtasks {
+			subtaskIDs = append(subtaskIDs, id)
+		}
+		sort.Strings(subtaskIDs)
+		for _, id := range subtaskIDs {
+			fmt.Fprintf(&b, "- Subtask `%s`: %s\n", id, resultStatus(task.Subtasks[id]))
+		}
+		if len(task.Commands) > 0 {
+			b.WriteString("\n### Commands\n")
+			for _, command := range task.Commands {
+				fmt.Fprintf(&b, "\n`%s` in `%s`: %s, exit %d, %s\n", command.Command, command.WorkingDir, command.Status, command.ExitCode, command.Duration.Round(time.Millisecond))
+				if output := strings.TrimSpace(command.Output); output != "" {
+					b.WriteString(fence("", output))
+				}
+			}
+		}
+		if len(task.Diffs) > 0 {
+			b.WriteString("\n### Changes\n\n")
+			b.WriteString(fence("diff", strings.Join(task.Diffs, "\n")))
+		}
+		if data := resultFields(task.Result); len(data) > 0 {
+			// Changes are shown above
+			delete(data, "diff")
+			delete(data, "patches")
+			if encoded, err := json.MarshalIndent(data, "", "  "); err == nil {
+				b.WriteString("\n### Result data\n\n")
+				b.WriteString(fence("json", truncateString(string(encoded), transcriptResultBytes)))
+			}
+		}
+	}
+	return b.String()
+}
+
+// resultFields returns the data of a result as JSON fields, whatever its
+// kind
+func resultFields(result *TaskResult) map[string]interface{} {
+	if result == nil || result.Data == nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	encoded, err := json.Marshal(result.Data)
+	if err == nil {
+		err = json.Unmarshal(encoded, &fields)
+	}
+	if err != nil {
+		return nil
+	}
+	return fields
+}
+
+func resultStatus(result *TaskResult) string {
+	switch {
+	case result == nil:
+		return "not available"
+	case result.Success:
+		return "succeeded"
+	case result.Error != "":
+		return "failed: " + result.Error
+	default:
+		return "failed"
+	}
+}
+
+// fence wraps text in a code fence longer than any backtick run inside it
+func fence(lang, text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return marker + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + marker + "\n"
+}
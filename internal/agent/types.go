@@ -2,8 +2,15 @@ package agent
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"spilot-agent/internal/llm"
+	"spilot-agent/internal/redact"
+	"spilot-agent/internal/telemetry"
+	"spilot-agent/internal/tickets"
+
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
@@ -12,10 +19,24 @@ import (
 type AgentType string
 
 const (
-	PlanningAgent AgentType = "planning"
-	FileAgent     AgentType = "file"
-	TerminalAgent AgentType = "terminal"
-	DebugAgent    AgentType = "debug"
+	PlanningAgent     AgentType = "planning"
+	FileAgent         AgentType = "file"
+	TerminalAgent     AgentType = "terminal"
+	DebugAgent        AgentType = "debug"
+	GitAgent          AgentType = "git"
+	TestAgent         AgentType = "test"
+	CodeReviewAgent   AgentType = "review"
+	RefactorAgent     AgentType = "refactor"
+	DocsAgent         AgentType = "docs"
+	SearchAgent       AgentType = "search"
+	DatabaseAgent     AgentType = "database"
+	HTTPRequestAgent  AgentType = "http"
+	SecurityScanAgent AgentType = "security"
+	LintAgent         AgentType = "lint"
+	KubernetesAgent   AgentType = "kubernetes"
+	BenchmarkAgent    AgentType = "benchmark"
+	MigrationAgent    AgentType = "migration"
+	ReleaseAgent      AgentType = "release"
 )
 
 // Task represents a task to be executed by an agent
@@ -40,22 +61,33 @@ const (
 	TaskFailed    TaskStatus = "failed"
 )
 
-// TaskResult represents the result of a task execution
+// TaskResult represents the result of a task execution. Data is the typed
+// result of the planning, file, terminal and debug agents, or Fields for
+// the others; in JSON, its kind is written next to it.
 type TaskResult struct {
-	Success bool                   `json:"success"`
-	Data    map[string]interface{} `json:"data"`
-	Error   string                 `json:"error,omitempty"`
+	Success bool
+	Data    ResultData
+	Error   string
+	// Usage is what the LLM calls of the task and its sub-tasks used
+	Usage *Usage
 }
 
 // Command represents a shell command to be executed
 type Command struct {
-	ID         string    `json:"id"`
-	Command    string    `json:"command"`
-	WorkingDir string    `json:"working_dir"`
-	Status     string    `json:"status"`
-	Output     string    `json:"output"`
-	Error      string    `json:"error"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         string        `json:"id"`
+	Command    string        `json:"command"`
+	WorkingDir string        `json:"working_dir"`
+	Status     string        `json:"status"`
+	Output     string        `json:"output"`
+	Error      string        `json:"error"`
+	ExitCode   int           `json:"exit_code"`
+	Truncated  bool          `json:"truncated,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	CreatedAt  time.Time     `json:"created_at"`
+	// OutputID and ErrorID are set when a large Output or Error was
+	// written to disk, leaving a preview (see OutputRef)
+	OutputID string `json:"output_id,omitempty"`
+	ErrorID  string `json:"error_id,omitempty"`
 }
 
 // FileOperation represents a file operation
@@ -101,36 +133,123 @@ type LLMClient interface {
 	Chat(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error)
 	ClassifyIntent(ctx context.Context, request string) (string, error)
 	AnalyzeError(ctx context.Context, errorOutput, fileContent string) (string, error)
-	GenerateCommand(ctx context.Context, instruction string) (string, error)
+	GenerateCommand(ctx context.Context, instruction, shell string) (string, error)
 	PlanProject(ctx context.Context, description string) (string, error)
 	GenerateCode(ctx context.Context, requirements, context string) (string, error)
 	SetModel(model string)
 	GetModel() string
 }
 
+// UsageReporter is implemented by LLM clients that report the tokens each
+// call used
+type UsageReporter interface {
+	OnUsage(fn func(ctx context.Context, usage llm.Usage))
+}
+
+// PromptRedactor is implemented by LLM clients that can remove secrets
+// from prompts and report when they do
+type PromptRedactor interface {
+	SetRedactor(redactor *redact.Redactor)
+	OnRedact(fn func(ctx context.Context, count int))
+}
+
+// ModelBenchmarker is implemented by LLM clients that can compare how
+// models perform on a prompt suite
+type ModelBenchmarker interface {
+	Benchmark(ctx context.Context, models []string, suite []llm.BenchmarkPrompt, runs int) []llm.ModelBenchmark
+}
+
+// ModelRouter is implemented by LLM clients that can route interactive
+// requests to the fastest model meeting a latency budget
+type ModelRouter interface {
+	SetRouting(cfg llm.RoutingConfig)
+	ModelLatencies() []llm.ModelLatency
+	RoutedModel(kind string) string
+}
+
 // FileManager interface for file operations
 type FileManager interface {
 	CreateFile(path, content string) error
 	UpdateFile(path, content string) error
 	DeleteFile(path string) error
 	ReadFile(path string) (string, error)
+	ReadHead(path string, n int) (string, error)
+	ReadTail(path string, n int) (string, error)
 	FileExists(path string) bool
 	ListFiles(dir string) ([]string, error)
 }
 
 // CommandExecutor interface for command execution
 type CommandExecutor interface {
-	ExecuteCommand(command, workingDir string) (*Command, error)
-	ExecuteCommands(commands []string, workingDir string) ([]*Command, error)
+	ExecuteCommand(ctx context.Context, command, workingDir string, opts CommandOptions) (*Command, error)
+	ExecuteCommandStream(ctx context.Context, command, workingDir string, opts CommandOptions, onStdout, onStderr func(chunk string)) (*Command, error)
+	ExecuteCommands(ctx context.Context, commands []string, workingDir string, opts CommandOptions) ([]*Command, error)
+	DefaultShell() Shell
 }
 
 // System represents the main agent system
 type System struct {
-	agents      map[AgentType]Agent
+	agentsMu sync.RWMutex
+	agents   map[AgentType]Agent
+	// disabled are the built-in agent types disabled in the configuration
+	disabled    map[AgentType]bool
+	plugins     []*PluginAgent
 	llmClient   LLMClient
 	fileManager FileManager
 	commandExec CommandExecutor
 	taskQueue   chan *Task
+	resultsMu   sync.RWMutex
 	results     map[string]*TaskResult
+	approvals   *ApprovalStore
+	processes   *ProcessManager
+	ptys        *PTYManager
+	tools       *ToolRunner
+	auditLog    CommandAuditLog
+	eventLog    *EventLog
+	events      *EventBus
+	database    *DatabaseAgentImpl
+	hooks       *hookRegistry
+	symbols     *SymbolCache
+	profiles    *ProfileStore
+	budgets     ContextBudgets
+	sessions    *SessionStore
+	usage       *UsageStore
+	spend       *SpendGuard
+	// tenancy is nil unless users are isolated from each other
+	tenancy *Tenancy
+	// egress is nil unless commands' network access is restricted
+	egress     *Egress
+	planTokens *PlanTokens
+	// blastRadius limits each plan execution
+	blastRadius BlastRadiusLimits
+	apiKeys     *APIKeyStore
+	webhooks    *WebhookStore
+	rules       *RulesStore
+	// notifications is nil unless notification channels are configured
+	notifications *Notifications
+	// outputs is nil unless large task outputs are written to disk
+	outputs *OutputStore
+	// remote is nil unless tasks are distributed to workers over a queue
+	remote *RemoteTasks
+	// tickets is nil unless an issue tracker is configured
+	tickets tickets.Tracker
+	// sealer encrypts data stored at rest; nil stores it in the clear
+	sealer *Sealer
+	// telemetry is nil unless usage telemetry is enabled
+	telemetry *telemetry.Reporter
+	// models are the configured models, compared by CompareModels
+	models []string
+	// dataDir and workspaceDir are checked for readiness
+	dataDir      string
+	workspaceDir string
+	health       healthCache
+	// memory is nil when workspace memory is disabled
+	memory      *MemoryStore
+	learnMemory atomic.Bool
+	// handoffDepth bounds nested agent-to-agent handoffs
+	handoffDepth int
+	// index is nil unless the codebase index is enabled
+	index       *CodeIndex
+	stopWatcher context.CancelFunc
 	logger      *zap.Logger
 }
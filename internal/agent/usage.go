@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/llm"
+
+	"go.uber.org/zap"
+)
+
+// ModelPrice is what a model costs, in USD per million tokens. Cached is
+// the price of prompt tokens read from the provider's prompt cache; 0
+// charges them as other prompt tokens.
+type ModelPrice struct {
+	Model      string
+	Prompt     float64
+	Completion float64
+	Cached     float64
+}
+
+// UsageRecord is the tokens used by one LLM call and what they cost
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Model            string    `json:"model"`
+	TaskID           string    `json:"task_id,omitempty"`
+	SessionID        string    `json:"session_id,omitempty"`
+	Requester        string    `json:"requester,omitempty"`
+	Workspace        string    `json:"workspace,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CachedTokens     int       `json:"cached_tokens,omitempty"`
+	Cost             float64   `json:"cost"`
+	// CacheSavings is what the cached prompt tokens would have cost more
+	// had they not been cached
+	CacheSavings float64 `json:"cache_savings,omitempty"`
+}
+
+// Usage totals LLM calls
+type Usage struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	Cost             float64 `json:"cost"`
+	CacheSavings     float64 `json:"cache_savings"`
+}
+
+func (u *Usage) add(record *UsageRecord) {
+	u.Calls++
+	u.PromptTokens += record.PromptTokens
+	u.CompletionTokens += record.CompletionTokens
+	u.TotalTokens += record.PromptTokens + record.CompletionTokens
+	u.CachedTokens += record.CachedTokens
+	u.Cost += record.Cost
+	u.CacheSavings += record.CacheSavings
+}
+
+// UsageFilter selects usage records. Zero fields match everything; TaskID
+// also matches the task's sub-tasks.
+type UsageFilter struct {
+	TaskID    string
+	SessionID string
+	Requester string
+	Workspace string
+	Model     string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f UsageFilter) matches(record *UsageRecord) bool {
+	switch {
+	case f.TaskID != "" && record.TaskID != f.TaskID && !strings.HasPrefix(record.TaskID, f.TaskID+"."):
+		return false
+	case f.SessionID != "" && record.SessionID != f.SessionID:
+		return false
+	case f.Requester != "" && record.Requester != f.Requester:
+		return false
+	case f.Workspace != "" && record.Workspace != f.Workspace:
+		return false
+	case f.Model != "" && record.Model != f.Model:
+		return false
+	case !f.Since.IsZero() && record.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !record.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// UsageGroupings are the ways usage reports can be broken down
+var UsageGroupings = []string{"model", "task", "session", "requester", "workspace", "day"}
+
+// UsageReport totals the usage matching a filter, optionally broken down
+type UsageReport struct {
+	Total   Usage            `json:"total"`
+	GroupBy string           `json:"group_by,omitempty"`
+	Groups  map[string]Usage `json:"groups,omitempty"`
+}
+
+// UsageStore records the tokens used by every LLM call, in memory and, when
+// it has a directory, in DataDir/usage/usage.jsonl so reports survive
+// restarts
+type UsageStore struct {
+	mu      sync.RWMutex
+	path    string
+	records []*UsageRecord
+	// tasks totals the usage of each task, including its sub-tasks
+	tasks  map[string]*Usage
+	prices map[string]ModelPrice
+	sealer *Sealer
+	logger *zap.Logger
+}
+
+// NewUsageStore loads the usage recorded under dataDir; an empty dataDir
+// keeps usage in memory only
+func NewUsageStore(dataDir string, prices []ModelPrice, sealer *Sealer, logger *zap.Logger) (*UsageStore, error) {
+	store := &UsageStore{
+		tasks:  make(map[string]*Usage),
+		prices: make(map[string]ModelPrice, len(prices)),
+		sealer: sealer,
+		logger: logger,
+	}
+	for _, price := range prices {
+		store.prices[price.Model] = price
+	}
+	if dataDir == "" {
+		return store, nil
+	}
+	store.path = filepath.Join(dataDir, "usage", "usage.jsonl")
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer file.Close()
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		line, err := sealer.Open(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage log: %w", err)
+		}
+		var record UsageRecord
+		if json.Unmarshal(line, &record) == nil {
+			store.add(&record)
+		}
+	}
+	return store, scanner.Err()
+}
+
+// Cost returns what the tokens of an LLM call cost and what reading its
+// cached prompt tokens from the provider's cache saved, 0 for models
+// without a price
+func (s *UsageStore) Cost(usage llm.Usage) (cost, saved float64) {
+	price, ok := s.prices[usage.Model]
+	if !ok {
+		return 0, 0
+	}
+	cached := min(usage.CachedTokens, usage.PromptTokens)
+	cost = (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+	if price.Cached > 0 {
+		saved = float64(cached) * (price.Prompt - price.Cached) / 1e6
+	}
+	return cost - saved, saved
+}
+
+// Record stores the usage of an LLM call made with ctx, attributing it to
+// the task, session, requester and workspace ctx carries
+func (s *UsageStore) Record(ctx context.Context, usage llm.Usage) *UsageRecord {
+	record := &UsageRecord{
+		Time:             time.Now(),
+		Model:            usage.Model,
+		TaskID:           commandOriginFrom(ctx).TaskID,
+		SessionID:        sessionFrom(ctx),
+		Requester:        RequesterFrom(ctx),
+		Workspace:        workspaceFrom(ctx),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CachedTokens:     usage.CachedTokens,
+	}
+	record.Cost, record.CacheSavings = s.Cost(usage)
+	if record.TaskID == "" {
+		record.TaskID, _ = ctx.Value(taskIDKey{}).(string)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(record)
+	if err := s.append(record); err != nil {
+		s.logger.Warn("Failed to persist LLM usage", zap.Error(err))
+	}
+	return record
+}
+
+// add keeps a record in memory
+func (s *UsageStore) add(record *UsageRecord) {
+	s.records = append(s.records, record)
+	// Count the record towards its task and every task above it
+	for id := record.TaskID; id != ""; {
+		total, ok := s.tasks[id]
+		if !ok {
+			total = &Usage{}
+			s.tasks[id] = total
+		}
+		total.add(record)
+		cut := strings.LastIndex(id, ".")
+		if cut < 0 {
+			break
+		}
+		id = id[:cut]
+	}
+}
+
+// TaskUsage returns the usage of a task and its sub-tasks
+func (s *UsageStore) TaskUsage(taskID string) Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if total, ok := s.tasks[taskID]; ok {
+		return *total
+	}
+	return Usage{}
+}
+
+func (s *UsageStore) append(record *UsageRecord) error {
+	if s.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		line, err = s.sealer.Seal(line)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Records returns the usage records matching filter
+func (s *UsageStore) Records(filter UsageFilter) []UsageRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var records []UsageRecord
+	for _, record := range s.records {
+		if filter.matches(record) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// Report totals the usage matching filter, broken down by groupBy, one of
+// UsageGroupings, unless it is empty
+func (s *UsageStore) Report(filter UsageFilter, groupBy string) (*UsageReport, error) {
+	var key func(*UsageRecord) string
+	switch groupBy {
+	case "":
+	case "model":
+		key = func(r *UsageRecord) string { return r.Model }
+	case "task":
+		// Sub-tasks count towards the task they belong to
+		key = func(r *UsageRecord) string { id, _, _ := strings.Cut(r.TaskID, "."); return id }
+	case "session":
+		key = func(r *UsageRecord) string { return r.SessionID }
+	case "requester":
+		key = func(r *UsageRecord) string { return r.Requester }
+	case "workspace":
+		key = func(r *UsageRecord) string { return r.Workspace }
+	case "day":
+		key = func(r *UsageRecord) string { return r.Time.UTC().Format("2006-01-02") }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q; use %s", groupBy, strings.Join(UsageGroupings, ", "))
+	}
+
+	report := &UsageReport{GroupBy: groupBy}
+	if key != nil {
+		report.Groups = make(map[string]Usage)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, record := range s.records {
+		if !filter.matches(record) {
+			continue
+		}
+		report.Total.add(record)
+		if key != nil {
+			group := report.Groups[key(record)]
+			group.add(record)
+			report.Groups[key(record)] = group
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,694 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Embedder turns texts into embedding vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// openAIEmbedder calls an OpenAI-compatible embeddings endpoint
+type openAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+// NewEmbedder creates an embedder for an OpenAI-compatible API
+func NewEmbedder(baseURL, apiKey, model string) (Embedder, error) {
+	if model == "" {
+		return nil, fmt.Errorf("an embedding model is required")
+	}
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	return &openAIEmbedder{client: openai.NewClientWithConfig(config), model: model}, nil
+}
+
+// Embed returns one vector per text, in order
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range resp.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+// CodeChunk is an indexed span of a workspace file
+type CodeChunk struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Content   string `json:"content"`
+	// FileHash is the hash of the whole file when the chunk was indexed
+	FileHash string    `json:"-"`
+	Vector   []float32 `json:"-"`
+}
+
+// embeddingText is the text the chunk is embedded from. The path gives the
+// embedding model context the code lacks.
+func (c CodeChunk) embeddingText() string {
+	return c.Path + "\n" + c.Content
+}
+
+// id derives a stable UUID-formatted identifier for the chunk
+func (c CodeChunk) id() string {
+	sum := sha1.Sum([]byte(c.Path + ":" + strconv.Itoa(c.StartLine)))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// ScoredChunk is a search hit with its cosine similarity to the query
+type ScoredChunk struct {
+	CodeChunk
+	Score float64 `json:"score"`
+}
+
+// VectorStore persists chunk embeddings and finds the nearest ones
+type VectorStore interface {
+	// Upsert stores chunks, replacing ones with the same path and start line
+	Upsert(ctx context.Context, chunks []CodeChunk) error
+	// DeleteFile removes every chunk of a file
+	DeleteFile(ctx context.Context, path string) error
+	// FileChunks returns the chunks of a file with their vectors
+	FileChunks(ctx context.Context, path string) ([]CodeChunk, error)
+	// Search returns the k chunks most similar to vector, best first
+	Search(ctx context.Context, vector []float32, k int) ([]ScoredChunk, error)
+	// FileHashes returns the indexed files and the hashes they were indexed at
+	FileHashes(ctx context.Context) (map[string]string, error)
+	// Ping checks that the store can be reached
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// NewVectorStore opens the configured store: sqlite (a local file, the
+// default), qdrant or pgvector. The sqlite store encrypts chunk contents
+// with sealer; the other stores are servers that must protect their data.
+func NewVectorStore(cfg IndexConfig, dataDir string, sealer *Sealer) (VectorStore, error) {
+	collection := cfg.Collection
+	if collection == "" {
+		collection = "spilot_chunks"
+	}
+	switch cfg.Store {
+	case "", "sqlite":
+		path := cfg.DSN
+		if path == "" {
+			path = filepath.Join(dataDir, "index", "chunks.db")
+		}
+		return newSQLiteVectorStore(path, sealer)
+	case "qdrant":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("index.url is required for qdrant")
+		}
+		return &qdrantVectorStore{
+			baseURL:    strings.TrimRight(cfg.URL, "/"),
+			apiKey:     cfg.APIKey,
+			collection: collection,
+			client:     &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	case "pgvector":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("index.dsn is required for pgvector")
+		}
+		return newPgVectorStore(cfg.DSN, collection)
+	default:
+		return nil, fmt.Errorf("unknown index store: %s", cfg.Store)
+	}
+}
+
+// sqliteVectorStore keeps vectors in a local SQLite file and scans them
+// for each search, which is fast enough for single repositories
+type sqliteVectorStore struct {
+	db     *sql.DB
+	sealer *Sealer
+}
+
+func newSQLiteVectorStore(path string, sealer *Sealer) (*sqliteVectorStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS chunks (
+		id TEXT PRIMARY KEY, path TEXT NOT NULL, start_line INTEGER, end_line INTEGER,
+		content TEXT, file_hash TEXT, vector BLOB)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create index table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS chunks_path ON chunks (path)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteVectorStore{db: db, sealer: sealer}, nil
+}
+
+func (s *sqliteVectorStore) Upsert(ctx context.Context, chunks []CodeChunk) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range chunks {
+		content, err := s.sealer.sealString(c.Content)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO chunks (id, path, start_line, end_line, content, file_hash, vector)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, c.id(), c.Path, c.StartLine, c.EndLine, content, c.FileHash, encodeVector(c.Vector)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteVectorStore) DeleteFile(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM chunks WHERE path = ?`, path)
+	return err
+}
+
+func (s *sqliteVectorStore) FileChunks(ctx context.Context, path string) ([]CodeChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT start_line, end_line, content, file_hash, vector FROM chunks WHERE path = ?`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []CodeChunk
+	for rows.Next() {
+		c := CodeChunk{Path: path}
+		var blob []byte
+		if err := rows.Scan(&c.StartLine, &c.EndLine, &c.Content, &c.FileHash, &blob); err != nil {
+			return nil, err
+		}
+		if c.Content, err = s.sealer.openString(c.Content); err != nil {
+			return nil, err
+		}
+		c.Vector = decodeVector(blob)
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// Compact rebuilds the database file without the pages freed by replaced
+// and removed chunks
+func (s *sqliteVectorStore) Compact(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+func (s *sqliteVectorStore) Search(ctx context.Context, vector []float32, k int) ([]ScoredChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT path, start_line, end_line, content, vector FROM chunks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []ScoredChunk
+	for rows.Next() {
+		var hit ScoredChunk
+		var blob []byte
+		if err := rows.Scan(&hit.Path, &hit.StartLine, &hit.EndLine, &hit.Content, &blob); err != nil {
+			return nil, err
+		}
+		hit.Score = cosineSimilarity(vector, decodeVector(blob))
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	// Only the hits returned need decrypting
+	for i := range hits {
+		content, err := s.sealer.openString(hits[i].Content)
+		if err != nil {
+			return nil, err
+		}
+		hits[i].Content = content
+	}
+	return hits, nil
+}
+
+func (s *sqliteVectorStore) FileHashes(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT path, file_hash FROM chunks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err != nil {
+			return nil, err
+		}
+		hashes[path] = hash
+	}
+	return hashes, rows.Err()
+}
+
+func (s *sqliteVectorStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteVectorStore) Close() error {
+	return s.db.Close()
+}
+
+// pgVectorStore stores chunks in PostgreSQL with the pgvector extension
+type pgVectorStore struct {
+	db    *sql.DB
+	table string
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func newPgVectorStore(dsn, table string) (*pgVectorStore, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid index collection name: %s", table)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range []string{
+		`CREATE EXTENSION IF NOT EXISTS vector`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id TEXT PRIMARY KEY, path TEXT NOT NULL, start_line INTEGER, end_line INTEGER,
+			content TEXT, file_hash TEXT, embedding vector)`, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_path ON %s (path)`, table, table),
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to prepare pgvector table: %w", err)
+		}
+	}
+	return &pgVectorStore{db: db, table: table}, nil
+}
+
+func (p *pgVectorStore) Upsert(ctx context.Context, chunks []CodeChunk) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	statement := fmt.Sprintf(`INSERT INTO %s (id, path, start_line, end_line, content, file_hash, embedding)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::vector)
+		ON CONFLICT (id) DO UPDATE SET end_line = EXCLUDED.end_line, content = EXCLUDED.content,
+		file_hash = EXCLUDED.file_hash, embedding = EXCLUDED.embedding`, p.table)
+	for _, c := range chunks {
+		if _, err := tx.ExecContext(ctx, statement, c.id(), c.Path, c.StartLine, c.EndLine, c.Content, c.FileHash, vectorLiteral(c.Vector)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *pgVectorStore) DeleteFile(ctx context.Context, path string) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1`, p.table), path)
+	return err
+}
+
+func (p *pgVectorStore) FileChunks(ctx context.Context, path string) ([]CodeChunk, error) {
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT start_line, end_line, content, file_hash, embedding::text FROM %s WHERE path = $1`, p.table), path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []CodeChunk
+	for rows.Next() {
+		c := CodeChunk{Path: path}
+		var literal string
+		if err := rows.Scan(&c.StartLine, &c.EndLine, &c.Content, &c.FileHash, &literal); err != nil {
+			return nil, err
+		}
+		if c.Vector, err = parseVectorLiteral(literal); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (p *pgVectorStore) Search(ctx context.Context, vector []float32, k int) ([]ScoredChunk, error) {
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT path, start_line, end_line, content, 1 - (embedding <=> $1::vector)
+		FROM %s ORDER BY embedding <=> $1::vector LIMIT $2`, p.table), vectorLiteral(vector), k)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []ScoredChunk
+	for rows.Next() {
+		var hit ScoredChunk
+		if err := rows.Scan(&hit.Path, &hit.StartLine, &hit.EndLine, &hit.Content, &hit.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+func (p *pgVectorStore) FileHashes(ctx context.Context) (map[string]string, error) {
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT path, file_hash FROM %s`, p.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err != nil {
+			return nil, err
+		}
+		hashes[path] = hash
+	}
+	return hashes, rows.Err()
+}
+
+func (p *pgVectorStore) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *pgVectorStore) Close() error {
+	return p.db.Close()
+}
+
+// qdrantVectorStore talks to a Qdrant server over its REST API
+type qdrantVectorStore struct {
+	baseURL    string
+	apiKey     string
+	collection string
+	client     *http.Client
+	// ready is set once the collection is known to exist
+	ready bool
+}
+
+// call sends a JSON request to Qdrant and decodes the "result" field
+func (q *qdrantVectorStore) call(ctx context.Context, method, path string, body, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, q.baseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.apiKey != "" {
+		req.Header.Set("api-key", q.apiKey)
+	}
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("qdrant request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("qdrant %s %s: %s: %s", method, path, resp.Status, truncateString(string(data), 500))
+	}
+	if result == nil {
+		return resp.StatusCode, nil
+	}
+	envelope := struct {
+		Result interface{} `json:"result"`
+	}{Result: result}
+	return resp.StatusCode, json.Unmarshal(data, &envelope)
+}
+
+func (q *qdrantVectorStore) collectionPath() string {
+	return "/collections/" + url.PathEscape(q.collection)
+}
+
+// ensureCollection creates the collection for vectors of the given size
+func (q *qdrantVectorStore) ensureCollection(ctx context.Context, size int) error {
+	if q.ready {
+		return nil
+	}
+	status, err := q.call(ctx, http.MethodGet, q.collectionPath(), nil, nil)
+	if status == http.StatusNotFound {
+		_, err = q.call(ctx, http.MethodPut, q.collectionPath(), map[string]interface{}{
+			"vectors": map[string]interface{}{"size": size, "distance": "Cosine"},
+		}, nil)
+		if err == nil {
+			_, err = q.call(ctx, http.MethodPut, q.collectionPath()+"/index", map[string]interface{}{
+				"field_name": "path", "field_schema": "keyword",
+			}, nil)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	q.ready = true
+	return nil
+}
+
+func (q *qdrantVectorStore) Upsert(ctx context.Context, chunks []CodeChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if err := q.ensureCollection(ctx, len(chunks[0].Vector)); err != nil {
+		return err
+	}
+	points := make([]map[string]interface{}, len(chunks))
+	for i, c := range chunks {
+		points[i] = map[string]interface{}{
+			"id":     c.id(),
+			"vector": c.Vector,
+			"payload": map[string]interface{}{
+				"path": c.Path, "start_line": c.StartLine, "end_line": c.EndLine,
+				"content": c.Content, "file_hash": c.FileHash,
+			},
+		}
+	}
+	_, err := q.call(ctx, http.MethodPut, q.collectionPath()+"/points?wait=true", map[string]interface{}{"points": points}, nil)
+	return err
+}
+
+func (q *qdrantVectorStore) DeleteFile(ctx context.Context, path string) error {
+	status, err := q.call(ctx, http.MethodPost, q.collectionPath()+"/points/delete?wait=true", map[string]interface{}{
+		"filter": map[string]interface{}{"must": []interface{}{
+			map[string]interface{}{"key": "path", "match": map[string]interface{}{"value": path}},
+		}},
+	}, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (q *qdrantVectorStore) FileChunks(ctx context.Context, path string) ([]CodeChunk, error) {
+	var chunks []CodeChunk
+	var offset interface{}
+	for {
+		var page struct {
+			Points []struct {
+				Payload qdrantPayload `json:"payload"`
+				Vector  []float32     `json:"vector"`
+			} `json:"points"`
+			NextPageOffset interface{} `json:"next_page_offset"`
+		}
+		body := map[string]interface{}{
+			"limit": 1000, "with_payload": true, "with_vector": true,
+			"filter": map[string]interface{}{"must": []interface{}{
+				map[string]interface{}{"key": "path", "match": map[string]interface{}{"value": path}},
+			}},
+		}
+		if offset != nil {
+			body["offset"] = offset
+		}
+		status, err := q.call(ctx, http.MethodPost, q.collectionPath()+"/points/scroll", body, &page)
+		if status == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Points {
+			chunks = append(chunks, CodeChunk{
+				Path: p.Payload.Path, StartLine: p.Payload.StartLine, EndLine: p.Payload.EndLine,
+				Content: p.Payload.Content, FileHash: p.Payload.FileHash, Vector: p.Vector,
+			})
+		}
+		if page.NextPageOffset == nil {
+			return chunks, nil
+		}
+		offset = page.NextPageOffset
+	}
+}
+
+type qdrantPayload struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Content   string `json:"content"`
+	FileHash  string `json:"file_hash"`
+}
+
+func (q *qdrantVectorStore) Search(ctx context.Context, vector []float32, k int) ([]ScoredChunk, error) {
+	var points []struct {
+		Score   float64       `json:"score"`
+		Payload qdrantPayload `json:"payload"`
+	}
+	status, err := q.call(ctx, http.MethodPost, q.collectionPath()+"/points/search", map[string]interface{}{
+		"vector": vector, "limit": k, "with_payload": true,
+	}, &points)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]ScoredChunk, len(points))
+	for i, p := range points {
+		hits[i] = ScoredChunk{
+			CodeChunk: CodeChunk{Path: p.Payload.Path, StartLine: p.Payload.StartLine, EndLine: p.Payload.EndLine, Content: p.Payload.Content},
+			Score:     p.Score,
+		}
+	}
+	return hits, nil
+}
+
+func (q *qdrantVectorStore) FileHashes(ctx context.Context) (map[string]string, error) {
+	hashes := make(map[string]string)
+	var offset interface{}
+	for {
+		var page struct {
+			Points []struct {
+				Payload qdrantPayload `json:"payload"`
+			} `json:"points"`
+			NextPageOffset interface{} `json:"next_page_offset"`
+		}
+		body := map[string]interface{}{"limit": 1000, "with_payload": []string{"path", "file_hash"}, "with_vector": false}
+		if offset != nil {
+			body["offset"] = offset
+		}
+		status, err := q.call(ctx, http.MethodPost, q.collectionPath()+"/points/scroll", body, &page)
+		if status == http.StatusNotFound {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Points {
+			hashes[p.Payload.Path] = p.Payload.FileHash
+		}
+		if page.NextPageOffset == nil {
+			return hashes, nil
+		}
+		offset = page.NextPageOffset
+	}
+}
+
+func (q *qdrantVectorStore) Ping(ctx context.Context) error {
+	_, err := q.call(ctx, http.MethodGet, "/", nil, nil)
+	return err
+}
+
+func (q *qdrantVectorStore) Close() error {
+	return nil
+}
+
+// encodeVector packs a vector as little-endian float32s
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
+
+// vectorLiteral formats a vector as pgvector text input
+func vectorLiteral(vector []float32) string {
+	parts := make([]string, len(vector))
+	for i, v := range vector {
+		parts[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// parseVectorLiteral reads a vector in pgvector text output
+func parseVectorLiteral(literal string) ([]float32, error) {
+	literal = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(literal), "["), "]")
+	if literal == "" {
+		return nil, nil
+	}
+	parts := strings.Split(literal, ",")
+	vector := make([]float32, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector: %w", err)
+		}
+		vector[i] = float32(v)
+	}
+	return vector, nil
+}
+
+// cosineSimilarity compares two vectors; mismatched sizes score 0
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
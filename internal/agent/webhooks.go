@@ -0,0 +1,445 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WebhookEvents are the task lifecycle events webhooks can receive
+var WebhookEvents = []TaskEventType{EventTaskStarted, EventTaskCompleted, EventTaskFailed, EventApprovalRequired}
+
+// webhookSecretPrefix starts every generated signing secret
+const webhookSecretPrefix = "whsec_"
+
+// Deliveries are tried webhookAttempts times, waiting twice as long after
+// each failure, starting at webhookRetryDelay
+const (
+	webhookAttempts   = 3
+	webhookRetryDelay = 2 * time.Second
+	webhookTimeout    = 10 * time.Second
+	// webhookResolveTimeout bounds looking up a webhook's host when it is
+	// registered
+	webhookResolveTimeout = 5 * time.Second
+)
+
+// maxWebhooks bounds the webhooks of one owner
+const maxWebhooks = 20
+
+// ErrWebhookNotFound is returned for unknown webhooks
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook receives task lifecycle events as signed JSON POSTs. Each
+// delivery carries X-Spilot-Event, X-Spilot-Delivery and
+// X-Spilot-Signature-256, "sha256=" and the hex HMAC-SHA256 of the body
+// keyed with Secret.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events are the event types delivered; empty means all WebhookEvents
+	Events []TaskEventType `json:"events,omitempty"`
+	// Owner is the requester that registered the webhook; with tenancy it
+	// only receives events of the owner's tasks
+	Owner     string    `json:"owner,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wants reports whether the webhook receives events of eventType
+func (h *Webhook) wants(eventType TaskEventType) bool {
+	if len(h.Events) == 0 {
+		return isWebhookEvent(eventType)
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// public returns a copy of the webhook without its secret
+func (h *Webhook) public() *Webhook {
+	c := *h
+	c.Secret = ""
+	c.Events = append([]TaskEventType(nil), h.Events...)
+	return &c
+}
+
+func isWebhookEvent(eventType TaskEventType) bool {
+	for _, t := range WebhookEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDelivery is the body of a delivery
+type webhookDelivery struct {
+	ID string `json:"id"`
+	TaskEvent
+}
+
+// WebhookStore keeps webhooks in DataDir/webhooks.json and delivers task
+// events to them
+type WebhookStore struct {
+	mu     sync.Mutex
+	path   string
+	hooks  map[string]*Webhook
+	sealer *Sealer
+	client *http.Client
+	stop   func()
+	logger *zap.Logger
+	// allowedHosts may be reached at internal addresses
+	allowedHosts []string
+}
+
+// webhookAllowedKey marks the context of a delivery to an allowed host
+type webhookAllowedKey struct{}
+
+// NewWebhookStore loads the webhooks kept in dataDir. An empty dataDir
+// keeps webhooks in memory only. Webhooks cannot reach loopback, private
+// or link-local addresses, such as the cloud metadata service, unless
+// their host is one of allowedHosts: names or "*.domain" patterns as for
+// the HTTP request agent.
+func NewWebhookStore(dataDir string, allowedHosts []string, sealer *Sealer, logger *zap.Logger) (*WebhookStore, error) {
+	// Deliveries connect directly, never through a proxy, so the address
+	// they reach is the one checked; a host can resolve differently by the
+	// time it is delivered to than when it was registered. Redirects are
+	// not followed since they could lead anywhere.
+	dialer := &net.Dialer{Timeout: webhookTimeout, ControlContext: checkWebhookDial}
+	store := &WebhookStore{
+		hooks:  make(map[string]*Webhook),
+		sealer: sealer,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger:       logger,
+		allowedHosts: allowedHosts,
+	}
+	if dataDir == "" {
+		return store, nil
+	}
+	store.path = filepath.Join(dataDir, "webhooks.json")
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err == nil {
+		data, err = sealer.Open(data)
+	}
+	var hooks []*Webhook
+	if err == nil {
+		err = json.Unmarshal(data, &hooks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	for _, hook := range hooks {
+		store.hooks[hook.ID] = hook
+	}
+	return store, nil
+}
+
+// Register adds a webhook of owner for events (all WebhookEvents if
+// empty). An empty secret is generated. It returns the webhook's
+// description and its secret, which is not shown again.
+func (s *WebhookStore) Register(ctx context.Context, owner, target string, events []TaskEventType, secret string) (*Webhook, string, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", fmt.Errorf("webhook URL must be an http or https URL, got %q", target)
+	}
+	if err := s.checkHost(ctx, u.Hostname()); err != nil {
+		return nil, "", err
+	}
+	for _, t := range events {
+		if !isWebhookEvent(t) {
+			return nil, "", fmt.Errorf("unknown webhook event %q; use task_started, task_completed, task_failed or approval_required", t)
+		}
+	}
+	if secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, "", err
+		}
+		secret = webhookSecretPrefix + hex.EncodeToString(random)
+	}
+	now := time.Now()
+	hook := &Webhook{
+		ID:        fmt.Sprintf("wh_%d", now.UnixNano()),
+		URL:       target,
+		Events:    events,
+		Owner:     owner,
+		Secret:    secret,
+		CreatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owned := 0
+	for _, h := range s.hooks {
+		if h.Owner == owner {
+			owned++
+		}
+	}
+	if owned >= maxWebhooks {
+		return nil, "", fmt.Errorf("at most %d webhooks can be registered", maxWebhooks)
+	}
+	s.hooks[hook.ID] = hook
+	if err := s.save(); err != nil {
+		delete(s.hooks, hook.ID)
+		return nil, "", err
+	}
+	return hook.public(), secret, nil
+}
+
+// checkHost rejects a webhook host that resolves to an internal address,
+// unless it is allowed
+func (s *WebhookStore) checkHost(ctx context.Context, host string) error {
+	if hostMatches(host, s.allowedHosts) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("webhook host %s cannot be resolved: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if internalAddress(addr.IP) {
+			return fmt.Errorf("webhook host %s is at internal address %s; add it to webhook_allowed_hosts to allow it", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// checkWebhookDial refuses connections to internal addresses, unless the
+// delivery is to an allowed host
+func checkWebhookDial(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	if allowed, _ := ctx.Value(webhookAllowedKey{}).(bool); allowed {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalAddress(ip) {
+		return fmt.Errorf("webhook address %s is internal", host)
+	}
+	return nil
+}
+
+// internalAddress reports whether ip is a loopback, private, link-local or
+// unspecified address, which webhooks must not reach by default
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// List returns every webhook, oldest first
+func (s *WebhookStore) List() []*Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := make([]*Webhook, 0, len(s.hooks))
+	for _, hook := range s.hooks {
+		hooks = append(hooks, hook.public())
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+// Get returns a webhook by ID
+func (s *WebhookStore) Get(id string) (*Webhook, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook, ok := s.hooks[id]
+	if !ok {
+		return nil, false
+	}
+	return hook.public(), true
+}
+
+// Delete removes a webhook
+func (s *WebhookStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hook, ok := s.hooks[id]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.hooks, id)
+	if err := s.save(); err != nil {
+		s.hooks[id] = hook
+		return err
+	}
+	return nil
+}
+
+// save writes every webhook; the caller holds s.mu
+func (s *WebhookStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	hooks := make([]*Webhook, 0, len(s.hooks))
+	for _, hook := range s.hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err == nil {
+		data, err = s.sealer.Seal(data)
+	}
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half-written file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Start delivers the events published on bus to the webhooks that want
+// them, until Close. owns tells whether a webhook's owner may see a task.
+func (s *WebhookStore) Start(bus *EventBus, owns func(owner, taskID string) bool) {
+	events, unsubscribe := bus.Subscribe("")
+	s.stop = unsubscribe
+	go func() {
+		for event := range events {
+			if !isWebhookEvent(event.Type) {
+				continue
+			}
+			s.mu.Lock()
+			var targets []*Webhook
+			for _, hook := range s.hooks {
+				if hook.wants(event.Type) {
+					targets = append(targets, hook)
+				}
+			}
+			s.mu.Unlock()
+			for _, hook := range targets {
+				if owns(hook.Owner, event.TaskID) {
+					go s.deliver(hook, event)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops delivering events
+func (s *WebhookStore) Close() {
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// deliver posts event to hook, retrying failed attempts. Deliveries are
+// concurrent, so receivers should order events by their timestamp.
+func (s *WebhookStore) deliver(hook *Webhook, event TaskEvent) {
+	id := fmt.Sprintf("%s_%d", hook.ID, time.Now().UnixNano())
+	body, err := json.Marshal(webhookDelivery{ID: id, TaskEvent: event})
+	if err != nil {
+		s.logger.Error("Failed to encode webhook delivery", zap.Error(err))
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.post(hook.URL, body, map[string]string{
+			"X-Spilot-Event":         string(event.Type),
+			"X-Spilot-Delivery":      id,
+			"X-Spilot-Signature-256": signature,
+		})
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			s.logger.Warn("Webhook delivery failed",
+				zap.String("webhook", hook.ID), zap.String("event", string(event.Type)),
+				zap.String("task_id", event.TaskID), zap.Int("attempts", attempt), zap.Error(err))
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (s *WebhookStore) post(target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if hostMatches(req.URL.Hostname(), s.allowedHosts) {
+		req = req.WithContext(context.WithValue(req.Context(), webhookAllowedKey{}, true))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Webhooks returns the webhooks the requester of ctx registered, or every
+// webhook without tenancy
+func (s *System) Webhooks(ctx context.Context) []*Webhook {
+	hooks := []*Webhook{}
+	for _, hook := range s.webhooks.List() {
+		if s.ownsWebhook(ctx, hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// RegisterWebhook registers a webhook for the requester of ctx (see
+// WebhookStore.Register)
+func (s *System) RegisterWebhook(ctx context.Context, target string, events []TaskEventType, secret string) (*Webhook, string, error) {
+	return s.webhooks.Register(ctx, RequesterFrom(ctx), target, events, secret)
+}
+
+// DeleteWebhook removes a webhook the requester of ctx may see
+func (s *System) DeleteWebhook(ctx context.Context, id string) error {
+	hook, ok := s.webhooks.Get(id)
+	if !ok || !s.ownsWebhook(ctx, hook) {
+		return ErrWebhookNotFound
+	}
+	return s.webhooks.Delete(id)
+}
+
+func (s *System) ownsWebhook(ctx context.Context, hook *Webhook) bool {
+	return s.tenancy == nil || hook.Owner == RequesterFrom(ctx)
+}
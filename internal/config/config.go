@@ -3,17 +3,558 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // Config holds all configuration for the application
 type Config struct {
+	// Profile names the entry of Profiles applied over the rest of the
+	// file, e.g. dev, staging or prod
+	Profile  string                            `mapstructure:"profile"`
+	Profiles map[string]map[string]interface{} `mapstructure:"profiles"`
+
 	GroqAPIKey   string `mapstructure:"groq_api_key"`
 	DefaultModel string `mapstructure:"default_model"`
-	LogLevel     string `mapstructure:"log_level"`
-	WorkspaceDir string `mapstructure:"workspace_dir"`
-	Port         string `mapstructure:"port"`
+	// LLMTransport routes provider requests through a proxy
+	LLMTransport LLMTransportConfig `mapstructure:"llm_transport"`
+	LogLevel     string             `mapstructure:"log_level"`
+	WorkspaceDir string             `mapstructure:"workspace_dir"`
+	Port         string             `mapstructure:"port"`
+
+	// LogFormat is json, for log collectors, or console, for people
+	LogFormat   string            `mapstructure:"log_format"`
+	LogSampling LogSamplingConfig `mapstructure:"log_sampling"`
+	// AccessLog logs every HTTP request
+	AccessLog bool `mapstructure:"access_log"`
+	// UI serves the embedded web UI at /; agent -desktop turns it on
+	UI bool `mapstructure:"ui"`
+	// RedactPatterns are regular expressions for secrets to remove from
+	// logs, in addition to common API key formats and configured credentials
+	RedactPatterns []string `mapstructure:"redact_patterns"`
+
+	// Formatters maps a file extension (without the leading dot) to the
+	// formatter command run on files written by the FileAgent. The file path
+	// is appended as the last argument.
+	Formatters    map[string]string `mapstructure:"formatters"`
+	FormatOnWrite bool              `mapstructure:"format_on_write"`
+
+	// MaxReadBytes and MaxWriteBytes cap the size of files the agents read
+	// whole or write. Larger files must be read with head/tail operations.
+	MaxReadBytes  int64 `mapstructure:"max_read_bytes"`
+	MaxWriteBytes int64 `mapstructure:"max_write_bytes"`
+	// SpillBytes is the size from which task outputs, such as LLM answers
+	// and command logs, are written to temporary files in DataDir/outputs
+	// and results hold a reference and a preview instead. 0 keeps them in
+	// memory.
+	SpillBytes int64 `mapstructure:"spill_bytes"`
+
+	// CommandTimeout is the default limit for executed commands; terminal
+	// tasks may override it with "timeout_seconds"
+	CommandTimeout time.Duration `mapstructure:"command_timeout"`
+
+	// Shell is the default shell for executed commands (sh, bash, cmd,
+	// powershell, pwsh). Empty selects the OS default.
+	Shell string `mapstructure:"shell"`
+
+	// EnvAllowlist and EnvDenylist are glob patterns selecting which server
+	// environment variables executed commands inherit. The denylist wins.
+	EnvAllowlist []string `mapstructure:"env_allowlist"`
+	EnvDenylist  []string `mapstructure:"env_denylist"`
+
+	// ApprovalRiskLevel is the lowest command risk (low, medium, high) that
+	// requires explicit user approval. LLMRiskCheck additionally asks the
+	// LLM to classify commands the built-in rules consider safe.
+	ApprovalRiskLevel string `mapstructure:"approval_risk_level"`
+	LLMRiskCheck      bool   `mapstructure:"llm_risk_check"`
+
+	// Executor selects how commands run: "local" or "sandbox" (a throwaway
+	// container configured by Sandbox)
+	Executor string        `mapstructure:"executor"`
+	Sandbox  SandboxConfig `mapstructure:"sandbox"`
+
+	// Limits bounds resources used by each executed command
+	Limits LimitsConfig `mapstructure:"limits"`
+	// Egress restricts the network access of executed commands
+	Egress EgressConfig `mapstructure:"egress"`
+
+	// DataDir holds the agent's persistent state, such as the audit log
+	DataDir string `mapstructure:"data_dir"`
+	// AuditLog records every executed command, and every task, plan, file
+	// write and fix, in DataDir/audit
+	AuditLog bool `mapstructure:"audit_log"`
+	// Encryption encrypts the sessions, memory, audit log and local index
+	// kept in DataDir
+	Encryption EncryptionConfig `mapstructure:"encryption"`
+	// History compresses the sessions kept in DataDir and bounds how long
+	// they are kept
+	History HistoryConfig `mapstructure:"history"`
+
+	// DebugContextTokens is the approximate token budget for related files
+	// (callers, imports) gathered when analyzing an error. 0 disables it.
+	DebugContextTokens int `mapstructure:"debug_context_tokens"`
+	// DebugDiagnostics runs native checkers (go vet, tsc, pyflakes) before
+	// error analysis; /fix can turn it off per request
+	DebugDiagnostics bool `mapstructure:"debug_diagnostics"`
+
+	// ContextTokens is the approximate token budget for code and other
+	// context assembled into a prompt; ContextBudgets overrides it per model
+	ContextTokens  int                   `mapstructure:"context_tokens"`
+	ContextBudgets []ContextBudgetConfig `mapstructure:"context_budgets"`
+
+	// ModelPrices give the cost of each model's tokens, for usage reports
+	ModelPrices []ModelPriceConfig `mapstructure:"model_prices"`
+	// ModelRouting sends interactive requests to the fastest model meeting
+	// their latency budget
+	ModelRouting ModelRoutingConfig `mapstructure:"model_routing"`
+	// Spend limits what LLM calls may cost and alerts as limits approach
+	Spend SpendConfig `mapstructure:"spend"`
+
+	// Notifications tell people by email or Discord when long-running
+	// work finishes and when budgets reach an alert threshold
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+
+	// RedactSecrets replaces secrets in LLM prompts and command output
+	RedactSecrets bool `mapstructure:"redact_secrets"`
+
+	// Policy is checked before every file write and command
+	Policy PolicyConfig `mapstructure:"policy"`
+
+	// Telemetry reports anonymous, aggregate feature usage; off by default
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+
+	// Admin serves the administrative endpoints apart from the agent API
+	Admin AdminConfig `mapstructure:"admin"`
+	// APIKeysRequired rejects agent API requests without a valid API key.
+	// Keys are managed through the admin API; a key presented while this
+	// is off still limits the request to its scopes.
+	APIKeysRequired bool `mapstructure:"api_keys_required"`
+
+	// PlanApproval signs the tokens that let approved plans execute
+	PlanApproval PlanApprovalConfig `mapstructure:"plan_approval"`
+	// BlastRadius caps what a single plan execution may change
+	BlastRadius BlastRadiusConfig `mapstructure:"blast_radius"`
+
+	// Tenancy gives each user a workspace root of their own
+	Tenancy TenancyConfig `mapstructure:"tenancy"`
+
+	// Instructions are added to every system prompt, alongside the
+	// .spilot/rules.md file of the workspace if it has one
+	Instructions string `mapstructure:"instructions"`
+
+	// WorkspaceMemory keeps decisions, conventions and fixed errors per
+	// workspace in DataDir/memory and consults them before planning and
+	// debugging. MemoryLearning extracts new entries from finished requests.
+	WorkspaceMemory bool `mapstructure:"workspace_memory"`
+	MemoryLearning  bool `mapstructure:"memory_learning"`
+
+	// DisabledAgents are agent types not registered at all, e.g. "terminal"
+	// in read-only deployments
+	DisabledAgents []string `mapstructure:"disabled_agents"`
+
+	// Databases are the connections available to the DatabaseAgent, by name
+	Databases map[string]DatabaseConfig `mapstructure:"databases"`
+
+	// HTTPAllowedHosts are the hosts the HTTPRequestAgent may call: exact
+	// names or "*.domain" patterns, "*" for any host
+	HTTPAllowedHosts []string `mapstructure:"http_allowed_hosts"`
+
+	// WebhookAllowedHosts are the hosts webhooks may reach at loopback,
+	// private or link-local addresses, as patterns like HTTPAllowedHosts
+	WebhookAllowedHosts []string `mapstructure:"webhook_allowed_hosts"`
+
+	// WebSearch lets planning and debugging consult current documentation
+	WebSearch WebSearchConfig `mapstructure:"web_search"`
+
+	// SecurityScanners are the scanners the SecurityScanAgent may run
+	// (gosec, npm-audit, pip-audit, gitleaks). Empty enables all of them;
+	// scanners that aren't installed are skipped.
+	SecurityScanners []string `mapstructure:"security_scanners"`
+
+	// Plugins are external agent executables loaded at startup
+	Plugins []PluginConfig `mapstructure:"plugins"`
+
+	// HandoffMaxDepth bounds how deeply agents may hand sub-tasks to other
+	// agents while executing
+	HandoffMaxDepth int `mapstructure:"handoff_max_depth"`
+
+	// Kubernetes selects the cluster the KubernetesAgent deploys to
+	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
+
+	// Index embeds the workspace for retrieval by the agents
+	Index IndexConfig `mapstructure:"index"`
+
+	// GitHub turns labeled issues and "/spilot" comments into tasks whose
+	// changes come back as pull requests
+	GitHub GitHubConfig `mapstructure:"github"`
+
+	// GitLab triages failed GitLab CI pipelines
+	GitLab GitLabConfig `mapstructure:"gitlab"`
+
+	// Tickets links plans to Jira or Linear issues
+	Tickets TicketsConfig `mapstructure:"tickets"`
+
+	// Queue hands tasks to remote workers (agent -worker) over NATS or Redis
+	Queue QueueConfig `mapstructure:"queue"`
+
+	// Secrets locates the backends of secret references in other values
+	Secrets SecretsConfig `mapstructure:"secrets"`
+
+	// secretKeys are the keys resolved from secret references
+	secretKeys []string
+}
+
+// SandboxConfig configures the containerized command executor
+type SandboxConfig struct {
+	Runtime string `mapstructure:"runtime"`
+	Image   string `mapstructure:"image"`
+	CPUs    string `mapstructure:"cpus"`
+	Memory  string `mapstructure:"memory"`
+	Network string `mapstructure:"network"`
+}
+
+// EgressConfig sets command network access. Mode is open, none (no network
+// at all, the only enforced restriction) or proxy (proxy variables pointing
+// at a proxy that only reaches AllowedHosts, which commands can ignore).
+type EgressConfig struct {
+	Mode         string   `mapstructure:"mode"`
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	ProxyListen  string   `mapstructure:"proxy_listen"`
+	ProxyURL     string   `mapstructure:"proxy_url"`
+}
+
+// AdminConfig configures the admin API listener. Listen is a TCP address
+// or unix:<path>; empty means a Unix socket at DataDir/admin.sock. Token is
+// required on TCP listeners.
+type AdminConfig struct {
+	Listen string `mapstructure:"listen"`
+	Token  string `mapstructure:"token"`
+}
+
+// AdminListen returns the admin API address, defaulting to a socket in
+// DataDir
+func (c *Config) AdminListen() string {
+	if c.Admin.Listen != "" {
+		return c.Admin.Listen
+	}
+	return "unix:" + filepath.Join(c.DataDir, "admin.sock")
+}
+
+// DatabaseConfig describes a database connection. Driver is postgres,
+// mysql or sqlite; DSN is in that driver's format.
+type DatabaseConfig struct {
+	Driver string `mapstructure:"driver"`
+	DSN    string `mapstructure:"dsn"`
+	// ReadOnly rejects any statement that could modify the database
+	ReadOnly bool `mapstructure:"read_only"`
+	// MaxRows caps the rows returned by a query (default 100)
+	MaxRows int `mapstructure:"max_rows"`
+}
+
+// WebSearchConfig configures web search and documentation retrieval.
+// Provider is brave or searxng; an empty provider disables retrieval.
+type WebSearchConfig struct {
+	Provider string `mapstructure:"provider"`
+	Endpoint string `mapstructure:"endpoint"`
+	APIKey   string `mapstructure:"api_key"`
+	// AllowedDomains restricts results and fetched pages (empty allows any)
+	AllowedDomains []string `mapstructure:"allowed_domains"`
+	MaxResults     int      `mapstructure:"max_results"`
+}
+
+// PluginConfig describes an external agent executable speaking JSON-RPC
+// on stdin/stdout
+type PluginConfig struct {
+	Command string            `mapstructure:"command"`
+	Args    []string          `mapstructure:"args"`
+	Env     map[string]string `mapstructure:"env"`
+}
+
+// KubernetesConfig names a kubeconfig context and namespace. Deploying and
+// diagnosing are disabled while Context is empty.
+type KubernetesConfig struct {
+	Context   string `mapstructure:"context"`
+	Namespace string `mapstructure:"namespace"`
+}
+
+// IndexConfig configures the codebase embeddings index. Store is sqlite
+// (DSN is a file path, default DataDir/index/chunks.db), qdrant (URL and
+// APIKey) or pgvector (DSN is a PostgreSQL connection string). Embeddings
+// come from an OpenAI-compatible endpoint.
+type IndexConfig struct {
+	Enabled         bool   `mapstructure:"enabled"`
+	Store           string `mapstructure:"store"`
+	DSN             string `mapstructure:"dsn"`
+	URL             string `mapstructure:"url"`
+	APIKey          string `mapstructure:"api_key"`
+	Collection      string `mapstructure:"collection"`
+	EmbeddingURL    string `mapstructure:"embedding_url"`
+	EmbeddingAPIKey string `mapstructure:"embedding_api_key"`
+	EmbeddingModel  string `mapstructure:"embedding_model"`
+	// Watch re-indexes files as they change
+	Watch      bool `mapstructure:"watch"`
+	ChunkLines int  `mapstructure:"chunk_lines"`
+	// Chunks are embedded in batches of at most EmbeddingBatchSize chunks
+	// and EmbeddingBatchBytes of text, EmbeddingConcurrency requests at a
+	// time and at most EmbeddingRateLimit requests a minute (0 is no limit)
+	EmbeddingBatchSize   int `mapstructure:"embedding_batch_size"`
+	EmbeddingBatchBytes  int `mapstructure:"embedding_batch_bytes"`
+	EmbeddingConcurrency int `mapstructure:"embedding_concurrency"`
+	EmbeddingRateLimit   int `mapstructure:"embedding_rate_limit"`
+}
+
+// GitHubConfig connects a GitHub App or repository webhook to the agent.
+// WebhookSecret enables it and verifies deliveries. The API is called with
+// Token, or as the App AppID with PrivateKey (PEM) when Token is empty.
+// Issues labeled Label, and comments starting with /spilot from
+// collaborators, become tasks on clones kept in WorkDir (default
+// DataDir/github). Failed GitHub Actions runs, delivered as workflow_run
+// events, are triaged by the DebugAgent; CIFix also proposes its fix as a
+// pull request.
+type GitHubConfig struct {
+	WebhookSecret string `mapstructure:"webhook_secret"`
+	Token         string `mapstructure:"token"`
+	AppID         int64  `mapstructure:"app_id"`
+	PrivateKey    string `mapstructure:"private_key"`
+	APIURL        string `mapstructure:"api_url"`
+	Label         string `mapstructure:"label"`
+	WorkDir       string `mapstructure:"work_dir"`
+	CIFix         bool   `mapstructure:"ci_fix"`
+}
+
+// GitLabConfig connects a GitLab project's pipeline webhook to the agent.
+// WebhookToken enables it and authenticates deliveries. Failed pipelines
+// are triaged by the DebugAgent on clones kept in WorkDir (default
+// DataDir/gitlab), calling the API of the instance at URL with Token. The
+// analysis is posted on the merge request or commit; CIFix also proposes
+// the fix as a merge request.
+type GitLabConfig struct {
+	WebhookToken string `mapstructure:"webhook_token"`
+	Token        string `mapstructure:"token"`
+	URL          string `mapstructure:"url"`
+	WorkDir      string `mapstructure:"work_dir"`
+	CIFix        bool   `mapstructure:"ci_fix"`
+}
+
+// TicketsConfig connects an issue tracker: Provider "jira" or "linear"
+// enables it. The Jira site at URL is called as Email with the API token
+// Token, or with Token as a personal access token without Email; Linear's
+// API, at URL if set, with Token as an API key. Plans can pull issues in as
+// context or create an issue in Project, a Jira project key or Linear team
+// key, with Jira issues of type IssueType. Executed plans post a summary on
+// their issue.
+type TicketsConfig struct {
+	Provider  string `mapstructure:"provider"`
+	URL       string `mapstructure:"url"`
+	Email     string `mapstructure:"email"`
+	Token     string `mapstructure:"token"`
+	Project   string `mapstructure:"project"`
+	IssueType string `mapstructure:"issue_type"`
+}
+
+// QueueConfig distributes tasks to workers over a message broker. Driver
+// "nats" or "redis" enables it, connecting to URL. Tasks go to the pool
+// Pools maps the primary language of their workspace to, or "default";
+// workers take tasks from their WorkerPools, WorkerConcurrency at a time
+// per pool. TaskTimeout bounds the wait for a worker's result.
+type QueueConfig struct {
+	Driver            string            `mapstructure:"driver"`
+	URL               string            `mapstructure:"url"`
+	Prefix            string            `mapstructure:"prefix"`
+	Pools             map[string]string `mapstructure:"pools"`
+	WorkerPools       []string          `mapstructure:"worker_pools"`
+	WorkerConcurrency int               `mapstructure:"worker_concurrency"`
+	TaskTimeout       time.Duration     `mapstructure:"task_timeout"`
+}
+
+// ModelPriceConfig is what a model costs, in USD per million prompt and
+// completion tokens, and per million prompt tokens read from the provider's
+// prompt cache. Prices are a list because model names contain dots.
+type ModelPriceConfig struct {
+	Model      string  `mapstructure:"model"`
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+	Cached     float64 `mapstructure:"cached"`
+}
+
+// ModelRoutingConfig routes the request types given a latency budget in
+// Budgets (classify, command) to the fastest of Models and the default
+// model whose rolling 95th percentile latency is within budget. Models are
+// probed with a minimal request every ProbeInterval (0 never) to keep their
+// latencies current. Its fields are in the order of llm.RoutingConfig,
+// which it converts to.
+type ModelRoutingConfig struct {
+	Models        []string                 `mapstructure:"models"`
+	Budgets       map[string]time.Duration `mapstructure:"budgets"`
+	ProbeInterval time.Duration            `mapstructure:"probe_interval"`
+}
+
+// SpendConfig holds daily or monthly budgets for LLM tokens or dollars.
+// Alerts are logged, and posted to AlertWebhook if set, as each budget
+// reaches AlertThresholds.
+type SpendConfig struct {
+	Budgets         []SpendBudgetConfig `mapstructure:"budgets"`
+	AlertThresholds []float64           `mapstructure:"alert_thresholds"`
+	AlertWebhook    string              `mapstructure:"alert_webhook"`
+}
+
+// NotificationsConfig holds the notification channels. Tasks and plans
+// taking at least MinDuration notify as they finish.
+type NotificationsConfig struct {
+	MinDuration time.Duration               `mapstructure:"min_duration"`
+	Channels    []NotificationChannelConfig `mapstructure:"channels"`
+}
+
+// NotificationChannelConfig is an email (Type "email") or Discord ("discord")
+// channel receiving Events (task_completed, task_failed, budget_alert; all
+// when empty) for work in Workspaces, or anywhere when empty. Email goes
+// through the SMTP server at SMTPHost:SMTPPort from From to To, logging in
+// as Username if set; Discord messages to the channel's WebhookURL.
+type NotificationChannelConfig struct {
+	Name       string   `mapstructure:"name"`
+	Type       string   `mapstructure:"type"`
+	Events     []string `mapstructure:"events"`
+	Workspaces []string `mapstructure:"workspaces"`
+	WebhookURL string   `mapstructure:"webhook_url"`
+	SMTPHost   string   `mapstructure:"smtp_host"`
+	SMTPPort   int      `mapstructure:"smtp_port"`
+	Username   string   `mapstructure:"username"`
+	Password   string   `mapstructure:"password"`
+	From       string   `mapstructure:"from"`
+	To         []string `mapstructure:"to"`
+}
+
+// SpendBudgetConfig limits the tokens or cost of one requester (API key)
+// or workspace, of each with "*", or of everything when both are empty.
+// Action is reject, the default, or queue to hold work until the period
+// (day or month, in UTC) ends.
+type SpendBudgetConfig struct {
+	Name      string  `mapstructure:"name"`
+	Requester string  `mapstructure:"requester"`
+	Workspace string  `mapstructure:"workspace"`
+	Period    string  `mapstructure:"period"`
+	Tokens    int     `mapstructure:"tokens"`
+	Cost      float64 `mapstructure:"cost"`
+	Action    string  `mapstructure:"action"`
+}
+
+// PolicyConfig selects an OPA policy deciding on file writes and commands:
+// an OPA server at URL, or the Rego files in Dir evaluated with the opa
+// binary. Query names the rule listing reasons to deny (default
+// data.spilot.deny). FailOpen allows actions when the policy cannot be
+// evaluated; by default they are denied.
+type PolicyConfig struct {
+	URL      string `mapstructure:"url"`
+	Dir      string `mapstructure:"dir"`
+	Query    string `mapstructure:"query"`
+	FailOpen bool   `mapstructure:"fail_open"`
+}
+
+// PlanApprovalConfig configures plan execution tokens: approving a plan
+// issues a token valid for TokenTTL, signed with SigningKey, that executes
+// exactly that plan once. Without a key, a random one is used and tokens
+// do not survive a restart.
+type PlanApprovalConfig struct {
+	SigningKey string        `mapstructure:"signing_key"`
+	TokenTTL   time.Duration `mapstructure:"token_ttl"`
+}
+
+// BlastRadiusConfig limits the files modified, bytes written and commands
+// executed by the tasks of one plan. A plan reaching a limit pauses until
+// its continuation is approved. 0 leaves a quantity unlimited.
+type BlastRadiusConfig struct {
+	MaxFiles    int   `mapstructure:"max_files"`
+	MaxBytes    int64 `mapstructure:"max_bytes"`
+	MaxCommands int   `mapstructure:"max_commands"`
+}
+
+// TenancyConfig isolates users, identified by the owners of their API keys,
+// from each other: each works only in their own workspace root and sees
+// only their own sessions, tasks and history. Tenants lists users with a
+// given root; other users get Root/<user> if Root is set and are refused
+// otherwise.
+type TenancyConfig struct {
+	Root    string         `mapstructure:"root"`
+	Tenants []TenantConfig `mapstructure:"tenants"`
+}
+
+// TenantConfig gives a user a workspace root
+type TenantConfig struct {
+	User          string `mapstructure:"user"`
+	WorkspaceRoot string `mapstructure:"workspace_root"`
+}
+
+// TelemetryConfig opts in to posting, every Interval, how often each agent
+// and API endpoint was used and failed to Endpoint. Reports carry a random
+// install ID but no code, prompts, paths or other content.
+type TelemetryConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Endpoint string        `mapstructure:"endpoint"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// LLMTransportConfig configures how requests reach the LLM provider.
+// ProxyURL is an http, https or socks5 URL; without it HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY apply. CAFile adds trusted CAs in PEM form. The
+// pool settings and timeouts default, when 0, to those of llm.NewHTTPClient;
+// MaxConnsPerHost and ResponseHeaderTimeout are unlimited then. Its fields
+// are in the order of llm.TransportConfig, which it converts to.
+type LLMTransportConfig struct {
+	ProxyURL              string        `mapstructure:"proxy_url"`
+	CAFile                string        `mapstructure:"ca_file"`
+	MaxIdleConns          int           `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout       time.Duration `mapstructure:"idle_conn_timeout"`
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `mapstructure:"tls_handshake_timeout"`
+	KeepAlive             time.Duration `mapstructure:"keep_alive"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	DisableHTTP2          bool          `mapstructure:"disable_http2"`
+}
+
+// HistoryConfig sets how sessions, the prompts and replies of the agent's
+// history, are kept in DataDir: compressed with zstd, and deleted once
+// MaxAge passes without activity or when more than MaxSessions are more
+// recently active, at startup and as sessions are created. 0 keeps them.
+// Its fields are in the order of agent.HistoryConfig, which it converts
+// to.
+type HistoryConfig struct {
+	Compress    bool          `mapstructure:"compress"`
+	MaxAge      time.Duration `mapstructure:"max_age"`
+	MaxSessions int           `mapstructure:"max_sessions"`
+}
+
+// EncryptionConfig holds the AES-256-GCM key for data at rest: the base64
+// encoding of 32 random bytes, usually a secret reference. Empty stores data
+// in the clear.
+type EncryptionConfig struct {
+	Key string `mapstructure:"key"`
+}
+
+// LogSamplingConfig caps repeated log messages: each second, the first
+// Initial entries with the same level and message are logged, then every
+// Thereafter-th. Initial 0 logs everything.
+type LogSamplingConfig struct {
+	Initial    int `mapstructure:"initial"`
+	Thereafter int `mapstructure:"thereafter"`
+}
+
+// ContextBudgetConfig sets the prompt context budget of one model. Budgets
+// are a list rather than a map because model names contain dots.
+type ContextBudgetConfig struct {
+	Model  string `mapstructure:"model"`
+	Tokens int    `mapstructure:"tokens"`
+}
+
+// LimitsConfig holds per-command resource limits. Zero disables a limit.
+type LimitsConfig struct {
+	CPUSeconds     int   `mapstructure:"cpu_seconds"`
+	MemoryMB       int   `mapstructure:"memory_mb"`
+	MaxProcesses   int   `mapstructure:"max_processes"`
+	MaxOutputBytes int64 `mapstructure:"max_output_bytes"`
 }
 
 // Load reads configuration from file or environment variables
@@ -27,37 +568,109 @@ func Load() (*Config, error) {
 	// Allowed models: deepseek-r1-distill-llama-70b, meta-llama/llama-4-maverick-17b-128e-instruct, llama-3.1-8b-instant
 	viper.SetDefault("default_model", "llama-3.1-8b-instant")
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "json")
+	viper.SetDefault("log_sampling.initial", 100)
+	viper.SetDefault("log_sampling.thereafter", 100)
+	viper.SetDefault("access_log", true)
+	viper.SetDefault("spend.alert_thresholds", []float64{0.8, 1.0})
+	viper.SetDefault("redact_secrets", true)
+	viper.SetDefault("plan_approval.token_ttl", 15*time.Minute)
+	viper.SetDefault("blast_radius.max_files", 50)
+	viper.SetDefault("blast_radius.max_bytes", 10<<20)
+	viper.SetDefault("blast_radius.max_commands", 50)
+	viper.SetDefault("telemetry.enabled", false)
+	viper.SetDefault("telemetry.interval", 24*time.Hour)
+	viper.SetDefault("model_routing.probe_interval", 5*time.Minute)
 	viper.SetDefault("port", "8080")
+	viper.SetDefault("format_on_write", true)
+	viper.SetDefault("max_read_bytes", 1<<20)
+	viper.SetDefault("max_write_bytes", 5<<20)
+	viper.SetDefault("spill_bytes", 256<<10)
+	viper.SetDefault("history.compress", true)
+	viper.SetDefault("command_timeout", 10*time.Minute)
+	viper.SetDefault("approval_risk_level", "high")
+	viper.SetDefault("executor", "local")
+	viper.SetDefault("sandbox.runtime", "docker")
+	viper.SetDefault("sandbox.image", "alpine:3")
+	viper.SetDefault("sandbox.cpus", "1")
+	viper.SetDefault("sandbox.memory", "1g")
+	viper.SetDefault("sandbox.network", "none")
+	viper.SetDefault("limits.max_output_bytes", 1<<20)
+	viper.SetDefault("egress.mode", "open")
+	viper.SetDefault("egress.proxy_listen", "127.0.0.1:0")
+	viper.SetDefault("data_dir", defaultDataDir())
+	viper.SetDefault("audit_log", true)
+	viper.SetDefault("debug_context_tokens", 4000)
+	viper.SetDefault("debug_diagnostics", true)
+	viper.SetDefault("context_tokens", 8000)
+	viper.SetDefault("workspace_memory", true)
+	viper.SetDefault("memory_learning", true)
+	viper.SetDefault("web_search.max_results", 5)
+	viper.SetDefault("kubernetes.namespace", "default")
+	viper.SetDefault("handoff_max_depth", 3)
+	viper.SetDefault("index.store", "sqlite")
+	viper.SetDefault("index.collection", "spilot_chunks")
+	viper.SetDefault("index.embedding_url", "https://api.openai.com/v1")
+	viper.SetDefault("index.embedding_model", "text-embedding-3-small")
+	viper.SetDefault("index.watch", true)
+	viper.SetDefault("index.chunk_lines", 60)
+	viper.SetDefault("index.embedding_batch_size", 128)
+	viper.SetDefault("index.embedding_batch_bytes", 512<<10)
+	viper.SetDefault("index.embedding_concurrency", 4)
+	viper.SetDefault("github.api_url", "https://api.github.com")
+	viper.SetDefault("github.label", "spilot")
+	viper.SetDefault("gitlab.url", "https://gitlab.com")
+	viper.SetDefault("notifications.min_duration", time.Minute)
+	viper.SetDefault("tickets.issue_type", "Task")
+	viper.SetDefault("queue.prefix", "spilot")
+	viper.SetDefault("queue.worker_pools", []string{"default"})
+	viper.SetDefault("queue.worker_concurrency", 4)
+	viper.SetDefault("queue.task_timeout", 30*time.Minute)
+	viper.SetDefault("secrets.refresh_interval", 5*time.Minute)
+	viper.SetDefault("http_allowed_hosts", []string{"localhost", "127.0.0.1", "::1"})
+	viper.SetDefault("env_denylist", []string{"GROQ_API_KEY", "SPILOT_*", "*_API_KEY", "*_SECRET", "*_SECRET_KEY", "*_TOKEN", "*_PASSWORD"})
+	viper.SetDefault("formatters", map[string]string{
+		"go":  "gofmt -w",
+		"py":  "black -q",
+		"js":  "prettier --write",
+		"jsx": "prettier --write",
+		"ts":  "prettier --write",
+		"tsx": "prettier --write",
+		"rs":  "rustfmt",
+	})
 
-	// Read environment variables
-	viper.AutomaticEnv()
+	// Every key can be set from the environment as SPILOT_<KEY>
+	if err := bindEnv(); err != nil {
+		return nil, fmt.Errorf("failed to bind environment variables: %w", err)
+	}
 
 	// Try to read config file
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
+	if err := readConfig(); err != nil {
+		return nil, err
 	}
 
+	return unmarshal()
+}
+
+// unmarshal decodes and validates the configuration read by viper
+func unmarshal() (*Config, error) {
+	// Unknown keys are usually typos that would otherwise be ignored silently
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	if err := viper.UnmarshalExact(&config); err != nil {
+		return nil, fmt.Errorf("invalid configuration (check for misspelled or unsupported keys): %w", err)
+	}
+	if err := config.resolveSecrets(); err != nil {
+		return nil, err
 	}
 
 	// Validate required fields
 	if config.GroqAPIKey == "" {
-		config.GroqAPIKey = os.Getenv("GROQ_API_KEY")
-		if config.GroqAPIKey == "" {
-			return nil, fmt.Errorf("GROQ_API_KEY is required")
-		}
+		return nil, fmt.Errorf("groq_api_key is required: set %s or GROQ_API_KEY", EnvName("groq_api_key"))
 	}
 
 	// Set workspace directory
 	if config.WorkspaceDir == "" {
-		config.WorkspaceDir = os.Getenv("WORKSPACE_DIR")
-		if config.WorkspaceDir == "" {
-			config.WorkspaceDir = "."
-		}
+		config.WorkspaceDir = "."
 	}
 
 	// Set port if not specified
@@ -65,5 +678,29 @@ func Load() (*Config, error) {
 		config.Port = "8080"
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
 	return &config, nil
 }
+
+// defaultDataDir returns ~/.spilot, or a local directory if there is no home
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".spilot"
+	}
+	return filepath.Join(home, ".spilot")
+}
+
+// ConfiguredModels returns the default model followed by the other models
+// the configuration prices, for comparing them
+func (c *Config) ConfiguredModels() []string {
+	models := []string{c.DefaultModel}
+	for _, price := range c.ModelPrices {
+		if price.Model != c.DefaultModel {
+			models = append(models, price.Model)
+		}
+	}
+	return models
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix prefixes the environment variables that set configuration keys
+const EnvPrefix = "SPILOT"
+
+// legacyEnv are unprefixed variables still honored for some keys
+var legacyEnv = map[string][]string{
+	"groq_api_key":  {"GROQ_API_KEY"},
+	"workspace_dir": {"WORKSPACE_DIR"},
+}
+
+// EnvName returns the environment variable that sets a configuration key,
+// e.g. SPILOT_INDEX_EMBEDDING_MODEL for index.embedding_model
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// bindEnv binds every configuration key to its environment variable so
+// that the whole configuration can be given in the environment. Maps and
+// lists of objects are given as JSON, e.g.
+// SPILOT_CONTEXT_BUDGETS='[{"model": "llama-3.1-8b-instant", "tokens": 4000}]';
+// lists of strings may also be comma-separated.
+func bindEnv() error {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	return bindStruct(reflect.TypeOf(Config{}), "")
+}
+
+func bindStruct(t reflect.Type, prefix string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + tag
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			if err := bindStruct(field.Type, key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		envs := append([]string{EnvName(key)}, legacyEnv[key]...)
+		if err := viper.BindEnv(append([]string{key}, envs...)...); err != nil {
+			return err
+		}
+		if err := decodeJSONEnv(key, field.Type, envs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJSONEnv sets key from the JSON in its environment variable when
+// the key's type cannot be decoded from a plain string
+func decodeJSONEnv(key string, t reflect.Type, envs []string) error {
+	structured := t.Kind() == reflect.Map ||
+		t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.String
+	for _, env := range envs {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !structured && !(t.Kind() == reflect.Slice && strings.HasPrefix(value, "[")) {
+			return nil
+		}
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+			return fmt.Errorf("%s must be JSON: %w", env, err)
+		}
+		viper.Set(key, decoded)
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SelectProfile chooses the profile applied by Load, overriding the
+// profile key and SPILOT_PROFILE
+func SelectProfile(name string) {
+	viper.Set("profile", name)
+}
+
+// readConfig reads the configuration file, if there is one, and applies
+// the selected profile over it
+func readConfig() error {
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	return applyProfile()
+}
+
+// applyProfile merges the keys of the selected profile over those of the
+// file. Environment variables still take precedence over both.
+func applyProfile() error {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil
+	}
+	profiles := viper.GetStringMap("profiles")
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q is not defined under profiles in the config file (defined: %s)", name, strings.Join(names, ", "))
+	}
+	if _, nested := profile["profiles"]; nested {
+		return fmt.Errorf("profile %q cannot define profiles", name)
+	}
+	return viper.MergeConfigMap(profile)
+}
+
+// SetFile makes Load read the configuration from path instead of looking
+// for config.yaml
+func SetFile(path string) {
+	viper.SetConfigFile(path)
+}
+
+// Set overrides key for Load, over the file, the profile and the
+// environment
+func Set(key string, value interface{}) {
+	viper.Set(key, value)
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ReloadableKeys are the keys a running server applies when the
+// configuration is reloaded; changes to other keys need a restart
+var ReloadableKeys = []string{
+	// Profiles only act through the keys they set, which are checked
+	// themselves
+	"profile",
+	"profiles",
+	"groq_api_key",
+	"log_level",
+	"redact_patterns",
+	"default_model",
+	"model_routing",
+	"approval_risk_level",
+	"llm_risk_check",
+	"instructions",
+	"memory_learning",
+}
+
+// Reload re-reads the configuration file found by Load and applies the
+// same profile. Environment variables still override the file.
+func Reload() (*Config, error) {
+	if err := readConfig(); err != nil {
+		return nil, err
+	}
+	return unmarshal()
+}
+
+// Watch calls onChange whenever the configuration file found by Load is
+// written. It does nothing if there is no configuration file.
+func Watch(onChange func()) {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+	viper.OnConfigChange(func(fsnotify.Event) { onChange() })
+	viper.WatchConfig()
+}
+
+// Changed returns the top-level keys whose values differ between a and b
+func Changed(a, b *Config) []string {
+	var keys []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		key := strings.Split(va.Type().Field(i).Tag.Get("mapstructure"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Reloadable reports whether a running server applies changes to key
+func Reloadable(key string) bool {
+	for _, k := range ReloadableKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
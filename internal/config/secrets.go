@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"spilot-agent/internal/secrets"
+)
+
+// secretsTimeout bounds resolving all secret references of a configuration
+const secretsTimeout = 30 * time.Second
+
+// SecretsConfig locates the backends of secret references. Any string
+// value in the configuration may be a reference such as
+// "vault://secret/data/spilot#groq_api_key",
+// "awssm://prod/spilot#groq_api_key" or "file:///run/secrets/groq_api_key".
+type SecretsConfig struct {
+	VaultAddr      string `mapstructure:"vault_addr"`
+	VaultToken     string `mapstructure:"vault_token"`
+	VaultNamespace string `mapstructure:"vault_namespace"`
+	AWSRegion      string `mapstructure:"aws_region"`
+	// RefreshInterval is how often references are resolved again so that
+	// rotated secrets are picked up; 0 disables it
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// SecretKeys returns the keys whose values were resolved from secret
+// references
+func (c *Config) SecretKeys() []string {
+	return c.secretKeys
+}
+
+// credentialSuffixes end the names of keys that hold credentials
+var credentialSuffixes = []string{"api_key", "token", "password", "key", "dsn"}
+
+// SecretValues returns the credentials in the configuration, to be kept
+// out of logs: values resolved from secret references and the values of
+// keys such as groq_api_key, vault_token or a database dsn
+func (c *Config) SecretValues() []string {
+	resolved := make(map[string]bool, len(c.secretKeys))
+	for _, key := range c.secretKeys {
+		resolved[key] = true
+	}
+	values := []string{c.Secrets.VaultToken}
+	walkStrings(reflect.ValueOf(c).Elem(), "", func(key, value string) string {
+		name := key[strings.LastIndex(key, ".")+1:]
+		for _, suffix := range credentialSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				resolved[key] = true
+			}
+		}
+		if resolved[key] && value != "" {
+			values = append(values, value)
+		}
+		return value
+	})
+	return values
+}
+
+// resolveSecrets replaces the secret references in c with their values
+func (c *Config) resolveSecrets() error {
+	resolver := secrets.NewResolver(secrets.Config{
+		VaultAddr:      c.Secrets.VaultAddr,
+		VaultToken:     c.Secrets.VaultToken,
+		VaultNamespace: c.Secrets.VaultNamespace,
+		AWSRegion:      c.Secrets.AWSRegion,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+
+	var problems []error
+	c.secretKeys = nil
+	walkStrings(reflect.ValueOf(c).Elem(), "", func(key, value string) string {
+		if !secrets.IsReference(value) {
+			return value
+		}
+		resolved, err := resolver.Resolve(ctx, value)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: failed to resolve secret: %w", key, err))
+			return value
+		}
+		c.secretKeys = append(c.secretKeys, key)
+		return resolved
+	})
+	return errors.Join(problems...)
+}
+
+// walkStrings replaces every string in v, a configuration value at key,
+// with fn(key, string)
+func walkStrings(v reflect.Value, key string, fn func(key, value string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fn(key, v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(v.Type().Field(i).Tag.Get("mapstructure"), ",")[0]
+			if tag == "" || tag == "-" || tag == "secrets" {
+				continue
+			}
+			walkStrings(v.Field(i), joinKey(key, tag), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", key, i), fn)
+		}
+	case reflect.Map:
+		// Map values are not addressable, so each is copied, walked and
+		// stored back
+		for _, k := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			walkStrings(elem, joinKey(key, fmt.Sprint(k.Interface())), fn)
+			v.SetMapIndex(k, elem)
+		}
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
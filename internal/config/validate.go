@@ -0,0 +1,375 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration for values that would fail at run
+// time, reporting every problem with the key to fix
+func (c *Config) Validate() error {
+	var problems []error
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf("%s: %s (set it in config.yaml or %s)", key, fmt.Sprintf(format, args...), EnvName(key)))
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "error", "dpanic", "panic", "fatal":
+	default:
+		problem("log_level", "unknown level %q; use debug, info, warn or error", c.LogLevel)
+	}
+	if c.LLMTransport.ProxyURL != "" {
+		proxy, err := url.Parse(c.LLMTransport.ProxyURL)
+		switch {
+		case err != nil:
+			problem("llm_transport.proxy_url", "%v", err)
+		case proxy.Host == "" || !strings.Contains(" http https socks5 socks5h ", " "+proxy.Scheme+" "):
+			problem("llm_transport.proxy_url", "%q is not an http, https or socks5 URL with a host", proxy.Redacted())
+		}
+	}
+	if c.LLMTransport.CAFile != "" {
+		if _, err := os.Stat(c.LLMTransport.CAFile); err != nil {
+			problem("llm_transport.ca_file", "%v", err)
+		}
+	}
+	if t := c.LLMTransport; t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 {
+		problem("llm_transport", "max_idle_conns, max_idle_conns_per_host and max_conns_per_host must not be negative")
+	}
+	if t := c.LLMTransport; t.IdleConnTimeout < 0 || t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.KeepAlive < 0 || t.ResponseHeaderTimeout < 0 {
+		problem("llm_transport", "timeouts must not be negative")
+	}
+	if c.Encryption.Key != "" {
+		if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(c.Encryption.Key)); err != nil || len(key) != 32 {
+			problem("encryption.key", "must be the base64 encoding of 32 bytes, e.g. from openssl rand -base64 32")
+		}
+	}
+	switch c.LogFormat {
+	case "", "json", "console":
+	default:
+		problem("log_format", "unknown format %q; use json or console", c.LogFormat)
+	}
+	for _, pattern := range c.RedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problem("redact_patterns", "%v", err)
+		}
+	}
+	if c.LogSampling.Initial < 0 || c.LogSampling.Thereafter < 0 {
+		problem("log_sampling", "initial and thereafter must not be negative")
+	}
+	if c.SpillBytes < 0 {
+		problem("spill_bytes", "must not be negative, got %d", c.SpillBytes)
+	}
+	if c.History.MaxAge < 0 {
+		problem("history.max_age", "must not be negative, got %s", c.History.MaxAge)
+	}
+	if c.History.MaxSessions < 0 {
+		problem("history.max_sessions", "must not be negative, got %d", c.History.MaxSessions)
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problem("port", "%q is not a port number between 1 and 65535", c.Port)
+	}
+	if err := checkWritableDir(c.WorkspaceDir, false); err != nil {
+		problem("workspace_dir", "%v", err)
+	}
+	if c.DataDir != "" {
+		if err := checkWritableDir(c.DataDir, true); err != nil {
+			problem("data_dir", "%v", err)
+		}
+	}
+	switch strings.ToLower(c.ApprovalRiskLevel) {
+	case "low", "medium", "high":
+	default:
+		problem("approval_risk_level", "unknown level %q; use low, medium or high", c.ApprovalRiskLevel)
+	}
+	switch c.Executor {
+	case "", "local", "sandbox":
+	default:
+		problem("executor", "unknown executor %q; use local or sandbox", c.Executor)
+	}
+	switch c.Egress.Mode {
+	case "", "open", "none":
+	case "proxy":
+		// proxy only steers commands that honour proxy variables, so it
+		// is never a reason to consider commands cut off; none is
+		if len(c.Egress.AllowedHosts) == 0 {
+			problem("egress.allowed_hosts", "is empty, so the proxy would refuse every host while commands ignoring it still reach any; use mode: none to keep commands off the network")
+		}
+		if c.Egress.ProxyURL != "" {
+			if u, err := url.Parse(c.Egress.ProxyURL); err != nil || u.Scheme != "http" || u.Host == "" {
+				problem("egress.proxy_url", "must be an http URL, got %q", c.Egress.ProxyURL)
+			}
+		}
+	default:
+		problem("egress.mode", "unknown mode %q; use open, none or proxy", c.Egress.Mode)
+	}
+	for _, host := range c.Egress.AllowedHosts {
+		if _, err := path.Match(host, ""); err != nil {
+			problem("egress.allowed_hosts", "%q is not a valid pattern", host)
+		}
+	}
+	if c.ContextTokens <= 0 {
+		problem("context_tokens", "must be positive, got %d", c.ContextTokens)
+	}
+	for i, budget := range c.ContextBudgets {
+		if budget.Model == "" || budget.Tokens <= 0 {
+			problem("context_budgets", "entry %d needs a model and a positive number of tokens", i+1)
+		}
+	}
+	for kind, budget := range c.ModelRouting.Budgets {
+		switch kind {
+		case "classify", "command":
+		default:
+			problem("model_routing.budgets", "unknown request type %q; use classify or command", kind)
+		}
+		if budget <= 0 {
+			problem("model_routing.budgets", "%s must be positive, got %s", kind, budget)
+		}
+	}
+	if c.ModelRouting.ProbeInterval < 0 {
+		problem("model_routing.probe_interval", "must not be negative, got %s", c.ModelRouting.ProbeInterval)
+	}
+	for i, price := range c.ModelPrices {
+		if price.Model == "" || price.Prompt < 0 || price.Completion < 0 || price.Cached < 0 {
+			problem("model_prices", "entry %d needs a model and prices that are not negative", i+1)
+		}
+	}
+	for i, budget := range c.Spend.Budgets {
+		switch budget.Period {
+		case "", "day", "month":
+		default:
+			problem("spend.budgets", "entry %d has unknown period %q; use day or month", i+1, budget.Period)
+		}
+		switch budget.Action {
+		case "", "reject", "queue":
+		default:
+			problem("spend.budgets", "entry %d has unknown action %q; use reject or queue", i+1, budget.Action)
+		}
+		if budget.Tokens < 0 || budget.Cost < 0 || budget.Tokens == 0 && budget.Cost == 0 {
+			problem("spend.budgets", "entry %d needs a positive tokens or cost limit", i+1)
+		}
+	}
+	for _, threshold := range c.Spend.AlertThresholds {
+		if threshold <= 0 {
+			problem("spend.alert_thresholds", "must be positive fractions of a budget, got %g", threshold)
+		}
+	}
+	if c.Spend.AlertWebhook != "" {
+		if u, err := url.Parse(c.Spend.AlertWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("spend.alert_webhook", "must be an http or https URL, got %q", c.Spend.AlertWebhook)
+		}
+	}
+	if c.Notifications.MinDuration < 0 {
+		problem("notifications.min_duration", "must not be negative, got %s", c.Notifications.MinDuration)
+	}
+	for i, channel := range c.Notifications.Channels {
+		switch channel.Type {
+		case "email":
+			if channel.SMTPHost == "" || channel.From == "" || len(channel.To) == 0 {
+				problem("notifications.channels", "entry %d needs smtp_host, from and to", i+1)
+			}
+			if channel.SMTPPort < 0 || channel.SMTPPort > 65535 {
+				problem("notifications.channels", "entry %d has invalid smtp_port %d", i+1, channel.SMTPPort)
+			}
+		case "discord":
+			if u, err := url.Parse(channel.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				problem("notifications.channels", "entry %d needs an https webhook_url", i+1)
+			}
+		default:
+			problem("notifications.channels", "entry %d has unknown type %q; use email or discord", i+1, channel.Type)
+		}
+		for _, event := range channel.Events {
+			switch event {
+			case "task_completed", "task_failed", "budget_alert":
+			default:
+				problem("notifications.channels", "entry %d has unknown event %q; use task_completed, task_failed or budget_alert", i+1, event)
+			}
+		}
+	}
+	if c.Policy.URL != "" && c.Policy.Dir != "" {
+		problem("policy", "set url or dir, not both")
+	}
+	if c.Policy.URL != "" {
+		if u, err := url.Parse(c.Policy.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("policy.url", "must be an http or https URL, got %q", c.Policy.URL)
+		}
+	}
+	if c.Policy.Dir != "" {
+		if info, err := os.Stat(c.Policy.Dir); err != nil || !info.IsDir() {
+			problem("policy.dir", "%s is not a directory", c.Policy.Dir)
+		}
+	}
+	if c.Policy.Query != "" && !strings.HasPrefix(c.Policy.Query, "data.") {
+		problem("policy.query", "must be a rule under data, e.g. data.spilot.deny, got %q", c.Policy.Query)
+	}
+	if listen := c.Admin.Listen; listen != "" && !strings.HasPrefix(listen, "unix:") {
+		if _, _, err := net.SplitHostPort(listen); err != nil {
+			problem("admin.listen", "must be host:port or unix:<path>, got %q", listen)
+		}
+		if c.Admin.Token == "" {
+			problem("admin.token", "is required when the admin API listens on TCP")
+		}
+	}
+	if c.PlanApproval.TokenTTL <= 0 {
+		problem("plan_approval.token_ttl", "must be positive, got %s", c.PlanApproval.TokenTTL)
+	}
+	if c.PlanApproval.SigningKey != "" && len(c.PlanApproval.SigningKey) < 32 {
+		problem("plan_approval.signing_key", "must be at least 32 characters")
+	}
+	if c.BlastRadius.MaxFiles < 0 {
+		problem("blast_radius.max_files", "must not be negative, got %d", c.BlastRadius.MaxFiles)
+	}
+	if c.BlastRadius.MaxBytes < 0 {
+		problem("blast_radius.max_bytes", "must not be negative, got %d", c.BlastRadius.MaxBytes)
+	}
+	if c.BlastRadius.MaxCommands < 0 {
+		problem("blast_radius.max_commands", "must not be negative, got %d", c.BlastRadius.MaxCommands)
+	}
+	if c.GitHub.WebhookSecret != "" {
+		if c.GitHub.Token == "" && (c.GitHub.AppID == 0 || c.GitHub.PrivateKey == "") {
+			problem("github", "needs a token, or an app_id and private_key")
+		}
+		if u, err := url.Parse(c.GitHub.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("github.api_url", "must be an http or https URL, got %q", c.GitHub.APIURL)
+		}
+		if c.GitHub.Label == "" {
+			problem("github.label", "must not be empty")
+		}
+	}
+	if c.GitLab.WebhookToken != "" {
+		if c.GitLab.Token == "" {
+			problem("gitlab.token", "is required with a webhook_token")
+		}
+		if u, err := url.Parse(c.GitLab.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("gitlab.url", "must be an http or https URL, got %q", c.GitLab.URL)
+		}
+	}
+	switch c.Tickets.Provider {
+	case "":
+	case "jira", "linear":
+		if c.Tickets.Token == "" {
+			problem("tickets.token", "is required with a provider")
+		}
+		if c.Tickets.URL != "" || c.Tickets.Provider == "jira" {
+			if u, err := url.Parse(c.Tickets.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problem("tickets.url", "must be an http or https URL, got %q", c.Tickets.URL)
+			}
+		}
+	default:
+		problem("tickets.provider", "must be jira or linear, got %q", c.Tickets.Provider)
+	}
+	switch c.Queue.Driver {
+	case "":
+	case "nats", "redis":
+		if c.Queue.URL == "" {
+			problem("queue.url", "is required with a driver")
+		}
+		if c.Queue.Prefix == "" {
+			problem("queue.prefix", "must not be empty")
+		}
+		if c.Queue.WorkerConcurrency < 1 {
+			problem("queue.worker_concurrency", "must be at least 1, got %d", c.Queue.WorkerConcurrency)
+		}
+		if c.Queue.TaskTimeout <= 0 {
+			problem("queue.task_timeout", "must be positive, got %s", c.Queue.TaskTimeout)
+		}
+	default:
+		problem("queue.driver", "must be nats or redis, got %q", c.Queue.Driver)
+	}
+	if c.Tenancy.Root != "" || len(c.Tenancy.Tenants) > 0 {
+		// Local commands and the index could reach beyond a tenant's root
+		if c.Executor != "sandbox" {
+			problem("tenancy", "needs executor: sandbox so commands cannot reach other tenants' workspaces")
+		}
+		// Without keys, users would be whoever they claim to be
+		if !c.APIKeysRequired {
+			problem("tenancy", "needs api_keys_required so users are identified by their API keys")
+		}
+		if c.Index.Enabled {
+			problem("tenancy", "cannot be used with the codebase index, which covers a single workspace")
+		}
+	}
+	users := make(map[string]bool)
+	for i, tenant := range c.Tenancy.Tenants {
+		key := fmt.Sprintf("tenancy.tenants[%d]", i)
+		if tenant.User == "" {
+			problem(key+".user", "is required")
+		} else if users[tenant.User] {
+			problem(key+".user", "%q is listed twice", tenant.User)
+		}
+		users[tenant.User] = true
+		if tenant.WorkspaceRoot == "" {
+			problem(key+".workspace_root", "is required")
+		}
+	}
+	if c.Telemetry.Enabled {
+		if u, err := url.Parse(c.Telemetry.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("telemetry.endpoint", "must be an http or https URL when telemetry is enabled, got %q", c.Telemetry.Endpoint)
+		}
+		if c.Telemetry.Interval < time.Minute {
+			problem("telemetry.interval", "must be at least 1m, got %s", c.Telemetry.Interval)
+		}
+	}
+	for name, db := range c.Databases {
+		switch db.Driver {
+		case "postgres", "postgresql", "mysql", "sqlite", "sqlite3":
+		default:
+			problem("databases", "%s has unknown driver %q; use postgres, mysql or sqlite", name, db.Driver)
+		}
+		if db.DSN == "" {
+			problem("databases", "%s has no dsn", name)
+		}
+	}
+	if c.Index.Enabled {
+		switch c.Index.Store {
+		case "sqlite", "qdrant", "pgvector":
+		default:
+			problem("index", "unknown store %q; use sqlite, qdrant or pgvector", c.Index.Store)
+		}
+		if c.Index.Store == "qdrant" && c.Index.URL == "" {
+			problem("index", "the qdrant store needs index.url")
+		}
+		if c.Index.Store == "pgvector" && c.Index.DSN == "" {
+			problem("index", "the pgvector store needs index.dsn")
+		}
+		if c.Index.EmbeddingBatchSize < 0 || c.Index.EmbeddingBatchBytes < 0 || c.Index.EmbeddingConcurrency < 0 || c.Index.EmbeddingRateLimit < 0 {
+			problem("index", "embedding_batch_size, embedding_batch_bytes, embedding_concurrency and embedding_rate_limit must not be negative")
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// checkWritableDir checks that dir is a directory the agent can write to,
+// creating it first if create is set
+func checkWritableDir(dir string, create bool) error {
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cannot create %q: %v", dir, err)
+		}
+	}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%q does not exist; create it or point to an existing directory", dir)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".spilot-write-check-*")
+	if err != nil {
+		return fmt.Errorf("%q is not writable by this user", dir)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
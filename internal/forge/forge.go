@@ -0,0 +1,214 @@
+// Package forge holds what the integrations with code hosting services,
+// GitHub and GitLab, share: working on clones of their repositories and
+// formatting what is posted back to them.
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Commits are made as this author
+const (
+	commitName  = "Spilot"
+	commitEmail = "spilot@localhost"
+)
+
+// Clone is a clone of a repository on the branch a task works on
+type Clone struct {
+	Dir    string
+	Branch string
+}
+
+// NewClone clones cloneURL into dir and creates branch at commit, or at the
+// tip of base if commit is empty. credentials are "user:token", or empty
+// for public repositories. Agent state in .spilot is kept out of commits.
+func NewClone(ctx context.Context, cloneURL, credentials, base, commit, dir, branch string) (*Clone, error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, err
+	}
+	c := &Clone{Dir: dir, Branch: branch}
+	if _, err := Git(ctx, "", credentials, "clone", "-q", "--depth", "50", "--branch", base, cloneURL, dir); err != nil {
+		c.Remove()
+		return nil, err
+	}
+	start := "HEAD"
+	if commit != "" {
+		// The commit may be behind the tip, or on another branch
+		if _, err := Git(ctx, dir, credentials, "fetch", "-q", "--depth", "50", "origin", commit); err != nil {
+			c.Remove()
+			return nil, err
+		}
+		start = commit
+	}
+	if _, err := Git(ctx, dir, "", "checkout", "-q", "-b", branch, start); err != nil {
+		c.Remove()
+		return nil, err
+	}
+	if err := appendLine(filepath.Join(dir, ".git", "info", "exclude"), ".spilot/"); err != nil {
+		c.Remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Remove deletes the clone
+func (c *Clone) Remove() {
+	os.RemoveAll(c.Dir)
+}
+
+// Commit commits every change in the clone with message and returns its
+// diff, or "" if nothing changed
+func (c *Clone) Commit(ctx context.Context, message string) (string, error) {
+	status, err := Git(ctx, c.Dir, "", "status", "--porcelain")
+	if err != nil || status == "" {
+		return "", err
+	}
+	if _, err := Git(ctx, c.Dir, "", "add", "-A"); err != nil {
+		return "", err
+	}
+	if _, err := Git(ctx, c.Dir, "", "-c", "user.name="+commitName, "-c", "user.email="+commitEmail, "commit", "-q", "-m", message); err != nil {
+		return "", err
+	}
+	return Git(ctx, c.Dir, "", "show", "--format=", "HEAD")
+}
+
+// Push pushes the clone's branch to origin
+func (c *Clone) Push(ctx context.Context, credentials string) error {
+	_, err := Git(ctx, c.Dir, credentials, "push", "-q", "origin", c.Branch)
+	return err
+}
+
+// Git runs git in dir, authenticating with credentials ("user:token") if
+// set. They are passed as a header, so they are not stored in the clone.
+func Git(ctx context.Context, dir, credentials string, args ...string) (string, error) {
+	if credentials != "" {
+		header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		args = append([]string{"-c", "http.extraHeader=" + header}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		// The first argument that is not an option names the operation
+		operation := "git"
+		for k := 0; k < len(args); k++ {
+			if args[k] == "-c" {
+				k++
+				continue
+			}
+			operation = "git " + args[k]
+			break
+		}
+		return "", errors.New(operation + ": " + message)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString("\n" + line + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Details folds content under a summary line
+func Details(summary, content string) string {
+	return "<details><summary>" + summary + "</summary>\n\n" + content + "\n\n</details>\n\n"
+}
+
+// Fence wraps text in a code block fenced with more backticks than it
+// contains in a row
+func Fence(lang, text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return marker + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + marker
+}
+
+// Truncate cuts text to limit bytes, noting that it did
+func Truncate(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	return text[:limit] + "\n… (truncated)"
+}
+
+var (
+	ansiEscape   = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	logTimestamp = regexp.MustCompile(`(?m)^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z ?`)
+	// sectionMarker matches GitLab's collapsible section lines
+	sectionMarker = regexp.MustCompile(`section_(start|end):\d+:\S*\r?`)
+)
+
+// ReadTail reads r to the end and returns its last limit bytes at most
+func ReadTail(r io.Reader, limit int) ([]byte, error) {
+	buf := make([]byte, 0, 2*limit)
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		// Dropping the start only once the buffer doubled keeps copying
+		// linear in the length of r
+		if len(buf) > 2*limit {
+			buf = append(buf[:0], buf[len(buf)-limit:]...)
+		}
+		if err != nil {
+			if len(buf) > limit {
+				buf = buf[len(buf)-limit:]
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			return buf, err
+		}
+	}
+}
+
+// LogTail returns the end of a CI job log, where builds report what
+// failed, without the colors, timestamps and section markers CI services
+// add. At most limit bytes are kept, cut at a line boundary.
+func LogTail(log string, limit int) string {
+	log = ansiEscape.ReplaceAllString(log, "")
+	log = logTimestamp.ReplaceAllString(log, "")
+	log = sectionMarker.ReplaceAllString(log, "")
+	log = strings.ReplaceAll(log, "\r\n", "\n")
+	log = strings.TrimSpace(log)
+	if len(log) <= limit {
+		return log
+	}
+	log = log[len(log)-limit:]
+	if i := strings.IndexByte(log, '\n'); i >= 0 {
+		log = log[i+1:]
+	}
+	return "… (earlier output omitted)\n" + log
+}
@@ -0,0 +1,169 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/forge"
+
+	"go.uber.org/zap"
+)
+
+// maxFailureLogBytes is how much of the end of each failed job's log the
+// DebugAgent is given
+const maxFailureLogBytes = 12000
+
+// maxTriagedJobs bounds the failed jobs of one run whose logs are analyzed
+const maxTriagedJobs = 3
+
+// workflowRun is a failed GitHub Actions run to triage
+type workflowRun struct {
+	job
+	id       int64
+	workflow string
+	url      string
+	// fixable is whether a fix can be proposed on the run's branch, which
+	// it cannot for runs of pull requests from forks
+	fixable bool
+}
+
+// failedRun returns the run a workflow_run delivery reports as failed
+func (i *Integration) failedRun(payload []byte, event *webhookEvent) (*workflowRun, bool) {
+	var delivery struct {
+		Action      string `json:"action"`
+		WorkflowRun struct {
+			ID           int64  `json:"id"`
+			Name         string `json:"name"`
+			HeadBranch   string `json:"head_branch"`
+			HeadSHA      string `json:"head_sha"`
+			Conclusion   string `json:"conclusion"`
+			HTMLURL      string `json:"html_url"`
+			PullRequests []struct {
+				Number int `json:"number"`
+			} `json:"pull_requests"`
+			HeadRepository struct {
+				FullName string `json:"full_name"`
+			} `json:"head_repository"`
+		} `json:"workflow_run"`
+	}
+	// Runs started by bots or on the branches of fixes proposed here are
+	// left alone so failures do not loop
+	if err := json.Unmarshal(payload, &delivery); err != nil || event.Sender.Type == "Bot" {
+		return nil, false
+	}
+	wr := delivery.WorkflowRun
+	if delivery.Action != "completed" || wr.Conclusion != "failure" && wr.Conclusion != "timed_out" ||
+		strings.HasPrefix(wr.HeadBranch, "spilot/") {
+		return nil, false
+	}
+
+	fromFork := wr.HeadRepository.FullName != "" && wr.HeadRepository.FullName != event.Repository.FullName
+	run := &workflowRun{
+		job: job{
+			command:  "triage",
+			repo:     event.Repository.FullName,
+			cloneURL: event.Repository.CloneURL,
+			base:     wr.HeadBranch,
+			title:    "fix failed " + wr.Name + " run",
+			sender:   event.Sender.Login,
+			commit:   wr.HeadSHA,
+			source:   fmt.Sprintf("Fixes the failed [%s run](%s) of %s.", wr.Name, wr.HTMLURL, shortSHA(wr.HeadSHA)),
+		},
+		id:       wr.ID,
+		workflow: wr.Name,
+		url:      wr.HTMLURL,
+		fixable:  i.cfg.CIFix && !fromFork,
+	}
+	if fromFork {
+		// The fork's branch is not in the repository; its commit is
+		run.base = event.Repository.DefaultBranch
+	}
+	if len(wr.PullRequests) > 0 {
+		run.number = wr.PullRequests[0].Number
+	}
+	if event.Installation != nil {
+		run.installation = event.Installation.ID
+	}
+	return run, true
+}
+
+// triage has the DebugAgent analyze the logs of the failed jobs of a run on
+// a clone of the failing commit, and posts the analysis on the pull request
+// or commit. With CIFix, the fix is applied and proposed as a pull request
+// into the run's branch.
+func (i *Integration) triage(ctx context.Context, token string, run *workflowRun) error {
+	jobs, err := i.client.failedJobs(ctx, token, run.repo, run.id)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, job := range jobs {
+		if len(failures) == maxTriagedJobs {
+			break
+		}
+		log, err := i.client.jobLog(ctx, token, run.repo, job.ID)
+		if err != nil {
+			i.logger.Warn("Failed to download job log", zap.String("repo", run.repo), zap.Int64("job_id", job.ID), zap.Error(err))
+			continue
+		}
+		header := "Job " + job.Name + " failed"
+		for _, step := range job.Steps {
+			if step.Conclusion == "failure" {
+				header += " in step " + step.Name
+				break
+			}
+		}
+		failures = append(failures, header+":\n"+forge.LogTail(log, maxFailureLogBytes))
+	}
+	if len(failures) == 0 {
+		return errors.New("no log of a failed job could be downloaded")
+	}
+
+	taskID := agent.NewTaskID()
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	w, err := i.checkout(ctx, token, run.job, taskID)
+	if err != nil {
+		return err
+	}
+	result, err := i.system.HandleCommand(ctx, "/fix", strings.Join(failures, "\n\n"), w.Dir, map[string]interface{}{"apply": run.fixable})
+	if err != nil {
+		w.remove()
+		return err
+	}
+
+	i.report(ctx, token, run.job, triageReport(run.workflow, run.url, taskID, result, !run.fixable))
+	if !run.fixable || !result.Success {
+		w.remove()
+		return nil
+	}
+	return i.publish(ctx, token, w, run.job, taskID, result, "")
+}
+
+// triageReport describes the analysis of a failed run, with the suggested
+// fix unless it is applied as a pull request
+func triageReport(workflow, url, taskID string, result *agent.TaskResult, withFix bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Spilot triaged the failed [%s run](%s) as task `%s`.\n\n", workflow, url, taskID)
+	debug := result.Debug()
+	if debug != nil && debug.Analysis != "" {
+		b.WriteString(forge.Truncate(strings.TrimSpace(debug.Analysis), maxPlanBytes) + "\n\n")
+	}
+	if debug != nil && withFix && debug.Fix != "" {
+		b.WriteString(forge.Details("Suggested fix", forge.Fence("", forge.Truncate(debug.Fix, maxDiffBytes))))
+	}
+	if !result.Success && result.Error != "" {
+		b.WriteString("The fix could not be applied: " + result.Error + "\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,275 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/forge"
+)
+
+// apiTimeout bounds one GitHub API call
+const apiTimeout = 30 * time.Second
+
+// maxLogBytes is how much of the end of a job log is downloaded
+const maxLogBytes = 1 << 20
+
+// client calls the GitHub REST API with a token, or as a GitHub App with
+// the tokens of its installations
+type client struct {
+	apiURL string
+	token  string
+	appID  int64
+	key    *rsa.PrivateKey
+	http   *http.Client
+
+	mu     sync.Mutex
+	tokens map[int64]installationToken
+}
+
+// installationToken is a token of an App installation, cached until
+// shortly before it expires
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newClient(cfg Config) (*client, error) {
+	c := &client{
+		apiURL: strings.TrimRight(cfg.APIURL, "/"),
+		token:  cfg.Token,
+		appID:  cfg.AppID,
+		http:   &http.Client{Timeout: apiTimeout},
+		tokens: make(map[int64]installationToken),
+	}
+	if c.token == "" {
+		key, err := parsePrivateKey(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub App private key: %w", err)
+		}
+		c.key = key
+	}
+	return c, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// tokenFor returns the token for calls about a repository the App is
+// installed on with installationID, or the configured token
+func (c *client) tokenFor(ctx context.Context, installationID int64) (string, error) {
+	if c.key == nil {
+		return c.token, nil
+	}
+	if installationID == 0 {
+		return "", errors.New("webhook delivery has no App installation")
+	}
+	c.mu.Lock()
+	cached, ok := c.tokens[installationID]
+	c.mu.Unlock()
+	if ok && time.Until(cached.ExpiresAt) > 5*time.Minute {
+		return cached.Token, nil
+	}
+
+	jwt, err := c.appJWT()
+	if err != nil {
+		return "", err
+	}
+	var issued installationToken
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+	if err := c.call(ctx, "Bearer "+jwt, http.MethodPost, path, nil, &issued); err != nil {
+		return "", fmt.Errorf("failed to get installation token: %w", err)
+	}
+	c.mu.Lock()
+	c.tokens[installationID] = issued
+	c.mu.Unlock()
+	return issued.Token, nil
+}
+
+// appJWT returns the short-lived JWT that authenticates the App itself
+func (c *client) appJWT() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		// Backdated against clock drift, as GitHub recommends
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": c.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// do calls the API with token and decodes the response into out, if not
+// nil. A *[]byte out receives the response as it is, such as a log.
+func (c *client) do(ctx context.Context, token, method, path string, body, out interface{}) error {
+	return c.call(ctx, "token "+token, method, path, body, out)
+}
+
+func (c *client) call(ctx context.Context, authorization, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, failure.Message)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out, err = forge.ReadTail(resp.Body, maxLogBytes)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// comment adds a comment to an issue or pull request
+func (c *client) comment(ctx context.Context, token, repo string, number int, body string) error {
+	path := fmt.Sprintf("/repos/%s/issues/%d/comments", repo, number)
+	return c.do(ctx, token, http.MethodPost, path, map[string]string{"body": forge.Truncate(body, maxBodyBytes)}, nil)
+}
+
+// commitComment comments on a commit
+func (c *client) commitComment(ctx context.Context, token, repo, sha, body string) error {
+	path := fmt.Sprintf("/repos/%s/commits/%s/comments", repo, sha)
+	return c.do(ctx, token, http.MethodPost, path, map[string]string{"body": forge.Truncate(body, maxBodyBytes)}, nil)
+}
+
+// actionsJob is the part of a workflow job triage uses
+type actionsJob struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL    string `json:"html_url"`
+	Steps      []struct {
+		Name       string `json:"name"`
+		Conclusion string `json:"conclusion"`
+	} `json:"steps"`
+}
+
+// failedJobs returns the jobs of the latest attempt of a workflow run that
+// failed
+func (c *client) failedJobs(ctx context.Context, token, repo string, runID int64) ([]actionsJob, error) {
+	var page struct {
+		Jobs []actionsJob `json:"jobs"`
+	}
+	path := fmt.Sprintf("/repos/%s/actions/runs/%d/jobs?filter=latest&per_page=100", repo, runID)
+	if err := c.do(ctx, token, http.MethodGet, path, nil, &page); err != nil {
+		return nil, err
+	}
+	var failed []actionsJob
+	for _, job := range page.Jobs {
+		if job.Conclusion == "failure" || job.Conclusion == "timed_out" {
+			failed = append(failed, job)
+		}
+	}
+	return failed, nil
+}
+
+// jobLog downloads the log of a workflow job
+func (c *client) jobLog(ctx context.Context, token, repo string, jobID int64) (string, error) {
+	var log []byte
+	path := fmt.Sprintf("/repos/%s/actions/jobs/%d/logs", repo, jobID)
+	if err := c.do(ctx, token, http.MethodGet, path, nil, &log); err != nil {
+		return "", err
+	}
+	return string(log), nil
+}
+
+// pullRequest is the part of a pull request the integration uses
+type pullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref  string `json:"ref"`
+		Repo struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (c *client) pullRequest(ctx context.Context, token, repo string, number int) (*pullRequest, error) {
+	var pr pullRequest
+	path := fmt.Sprintf("/repos/%s/pulls/%d", repo, number)
+	if err := c.do(ctx, token, http.MethodGet, path, nil, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// openPullRequest proposes merging head into base
+func (c *client) openPullRequest(ctx context.Context, token, repo, head, base, title, body string) (*pullRequest, error) {
+	var pr pullRequest
+	path := fmt.Sprintf("/repos/%s/pulls", repo)
+	request := map[string]string{"title": title, "head": head, "base": base, "body": forge.Truncate(body, maxBodyBytes)}
+	if err := c.do(ctx, token, http.MethodPost, path, request, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
@@ -0,0 +1,415 @@
+// Package github turns GitHub issues and comments into agent tasks. Issues
+// given a label, and "/spilot" comments from collaborators, run on a clone
+// of the repository; the changes come back as a branch and a pull request
+// carrying the diff, the plan and the transcript of the task.
+package github
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/forge"
+
+	"go.uber.org/zap"
+)
+
+// Requester is the requester audited for tasks started from GitHub
+const Requester = "github"
+
+// maxPayloadBytes is the largest webhook delivery GitHub sends
+const maxPayloadBytes = 25 << 20
+
+// commandPrefix starts the comments that are instructions to the agent
+const commandPrefix = "/spilot"
+
+// trustedAssociations are the authors whose comments are acted on; anyone
+// else could otherwise run commands on the server
+var trustedAssociations = map[string]bool{"OWNER": true, "MEMBER": true, "COLLABORATOR": true}
+
+// Config enables the integration when WebhookSecret is set. The API is
+// called with Token, or as the App AppID with PrivateKey when Token is
+// empty. Issues labeled Label start tasks; clones are kept in WorkDir.
+// Failed workflow runs are triaged, and fixed with a pull request if CIFix
+// is set.
+type Config struct {
+	WebhookSecret string
+	Token         string
+	AppID         int64
+	PrivateKey    string
+	APIURL        string
+	Label         string
+	WorkDir       string
+	CIFix         bool
+}
+
+// Integration receives GitHub webhooks and runs the tasks they ask for
+type Integration struct {
+	cfg    Config
+	system *agent.System
+	client *client
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// plans are the plans awaiting "/spilot approve", by issue
+	plans map[string]*pendingPlan
+}
+
+// pendingPlan is a plan proposed on an issue, with the clone it is for
+type pendingPlan struct {
+	job        job
+	plan       string
+	approvalID string
+	workspace  *workspace
+}
+
+// New creates the integration, or returns nil if it is not configured.
+// Clones default to dataDir/github.
+func New(cfg Config, dataDir string, system *agent.System, logger *zap.Logger) (*Integration, error) {
+	if cfg.WebhookSecret == "" {
+		return nil, nil
+	}
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = filepath.Join(dataDir, "github")
+	}
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Integration{cfg: cfg, system: system, client: client, logger: logger, plans: make(map[string]*pendingPlan)}, nil
+}
+
+// webhookEvent is the part of issues and issue_comment deliveries the
+// integration uses
+type webhookEvent struct {
+	Action string `json:"action"`
+	Label  *struct {
+		Name string `json:"name"`
+	} `json:"label"`
+	Issue *struct {
+		Number      int       `json:"number"`
+		Title       string    `json:"title"`
+		Body        string    `json:"body"`
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	Comment *struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+	} `json:"comment"`
+	Repository struct {
+		FullName      string `json:"full_name"`
+		CloneURL      string `json:"clone_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	} `json:"sender"`
+	Installation *struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+// job is one instruction given on an issue or pull request
+type job struct {
+	// command is fix, approve, reject or request
+	command      string
+	instruction  string
+	repo         string
+	cloneURL     string
+	base         string
+	number       int
+	title        string
+	pullRequest  bool
+	sender       string
+	installation int64
+	// commit is where the work starts instead of the tip of base, and is
+	// commented on if there is no issue or pull request
+	commit string
+	// source says what asked for the work, atop the pull request
+	source string
+}
+
+func (j job) key() string {
+	return fmt.Sprintf("%s#%d", j.repo, j.number)
+}
+
+// ServeHTTP receives a webhook delivery. Deliveries are verified with the
+// webhook secret and acknowledged at once; their tasks run afterwards.
+func (i *Integration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadBytes))
+	if err != nil {
+		http.Error(w, "failed to read delivery", http.StatusBadRequest)
+		return
+	}
+	if !i.verify(payload, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	eventType := r.Header.Get("X-GitHub-Event")
+	j, ok := i.jobFor(eventType, &event)
+	var run *workflowRun
+	if !ok && eventType == "workflow_run" {
+		run, ok = i.failedRun(payload, &event)
+		if ok {
+			j = run.job
+		}
+	}
+	if ok {
+		i.logger.Info("GitHub task requested",
+			zap.String("repo", j.repo), zap.Int("number", j.number),
+			zap.String("command", j.command), zap.String("sender", j.sender))
+		go i.run(j, run)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]bool{"accepted": ok})
+}
+
+// verify checks the HMAC-SHA256 signature GitHub sends of payload
+func (i *Integration) verify(payload []byte, signature string) bool {
+	signature, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(i.cfg.WebhookSecret))
+	mac.Write(payload)
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+// jobFor returns the job a delivery asks for, if any: an issue given the
+// label, or a command comment by a collaborator
+func (i *Integration) jobFor(eventType string, event *webhookEvent) (job, bool) {
+	if event.Issue == nil || event.Sender.Type == "Bot" {
+		return job{}, false
+	}
+	j := job{
+		repo:        event.Repository.FullName,
+		cloneURL:    event.Repository.CloneURL,
+		base:        event.Repository.DefaultBranch,
+		number:      event.Issue.Number,
+		title:       event.Issue.Title,
+		pullRequest: event.Issue.PullRequest != nil,
+		sender:      event.Sender.Login,
+	}
+	if event.Installation != nil {
+		j.installation = event.Installation.ID
+	}
+
+	switch {
+	case eventType == "issues" && event.Action == "labeled":
+		if event.Label == nil || event.Label.Name != i.cfg.Label {
+			return job{}, false
+		}
+		j.command = "request"
+		j.instruction = issueText(event.Issue.Title, event.Issue.Body)
+		j.source = fmt.Sprintf("Closes #%d.", j.number)
+		return j, true
+	case eventType == "issue_comment" && event.Action == "created" && event.Comment != nil:
+		if !trustedAssociations[event.Comment.AuthorAssociation] {
+			return job{}, false
+		}
+		command, ok := parseCommand(event.Comment.Body)
+		if !ok {
+			return job{}, false
+		}
+		j.command, j.instruction = command, ""
+		j.source = fmt.Sprintf("Closes #%d.", j.number)
+		if j.pullRequest {
+			j.source = fmt.Sprintf("Changes requested in #%d by @%s.", j.number, j.sender)
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(event.Comment.Body), commandPrefix))
+		switch command {
+		case "fix":
+			j.instruction = strings.TrimSpace(strings.TrimPrefix(rest, "fix"))
+			if j.instruction == "" {
+				j.instruction = issueText(event.Issue.Title, event.Issue.Body)
+			}
+		case "request":
+			j.instruction = rest + "\n\nContext, from " + kindOf(j) + " #" + fmt.Sprint(j.number) + ":\n" + issueText(event.Issue.Title, event.Issue.Body)
+		}
+		return j, true
+	}
+	return job{}, false
+}
+
+// parseCommand returns the command of a /spilot comment: fix, approve,
+// reject, or request for any other instruction
+func parseCommand(body string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(body), commandPrefix)
+	if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\n' && rest[0] != '\r' {
+		return "", false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", false
+	}
+	switch fields[0] {
+	case "fix", "approve", "reject":
+		return fields[0], true
+	}
+	return "request", true
+}
+
+func issueText(title, body string) string {
+	if strings.TrimSpace(body) == "" {
+		return title
+	}
+	return title + "\n\n" + body
+}
+
+func kindOf(j job) string {
+	if j.pullRequest {
+		return "pull request"
+	}
+	return "issue"
+}
+
+// run carries out a job, or the triage of a failed workflow run, and
+// reports back on the issue, pull request or commit
+func (i *Integration) run(j job, run *workflowRun) {
+	ctx := agent.ContextWithRequester(context.Background(), Requester)
+	token, err := i.client.tokenFor(ctx, j.installation)
+	if err != nil {
+		i.logger.Error("GitHub task failed", zap.String("repo", j.repo), zap.Int("number", j.number), zap.Error(err))
+		return
+	}
+	if run != nil {
+		err = i.triage(ctx, token, run)
+	} else {
+		err = i.handle(ctx, token, j)
+	}
+	if err != nil {
+		i.logger.Warn("GitHub task failed", zap.String("repo", j.repo), zap.Int("number", j.number), zap.Error(err))
+		i.report(ctx, token, j, "Spilot could not complete this: "+err.Error())
+	}
+}
+
+func (i *Integration) handle(ctx context.Context, token string, j job) error {
+	switch j.command {
+	case "approve":
+		return i.approve(ctx, token, j)
+	case "reject":
+		return i.reject(ctx, token, j)
+	}
+
+	taskID := agent.NewTaskID()
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	w, err := i.checkout(ctx, token, j, taskID)
+	if err != nil {
+		return err
+	}
+	i.report(ctx, token, j, fmt.Sprintf("Working on it as task `%s`.", taskID))
+
+	var result *agent.TaskResult
+	if j.command == "fix" {
+		result, err = i.system.HandleCommand(ctx, "/fix", j.instruction, w.Dir, map[string]interface{}{"apply": true})
+	} else {
+		result, err = i.system.ProcessUserRequest(ctx, j.instruction, w.Dir)
+	}
+	if err != nil {
+		w.remove()
+		return err
+	}
+
+	// A generated plan waits for "/spilot approve" in its clone
+	if plan := result.Plan(); plan != nil && plan.Plan != "" && plan.ApprovalID != "" {
+		i.mu.Lock()
+		if previous, ok := i.plans[j.key()]; ok {
+			previous.workspace.remove()
+		}
+		i.plans[j.key()] = &pendingPlan{job: j, plan: plan.Plan, approvalID: plan.ApprovalID, workspace: w}
+		i.mu.Unlock()
+		i.report(ctx, token, j, "Proposed plan:\n\n"+forge.Fence("json", plan.Plan)+
+			"\n\nComment `/spilot approve` to carry it out, or `/spilot reject` to drop it.")
+		return nil
+	}
+	return i.publish(ctx, token, w, j, taskID, result, "")
+}
+
+// approve executes the plan proposed on an issue, or its continuation past
+// a blast-radius limit
+func (i *Integration) approve(ctx context.Context, token string, j job) error {
+	i.mu.Lock()
+	pending, ok := i.plans[j.key()]
+	delete(i.plans, j.key())
+	i.mu.Unlock()
+	if !ok {
+		return errors.New("there is no plan waiting for approval here")
+	}
+	_, execToken, _, err := i.system.DecideApproval(pending.approvalID, true)
+	if err != nil {
+		pending.workspace.remove()
+		return err
+	}
+
+	taskID := agent.NewTaskID()
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	i.report(ctx, token, j, fmt.Sprintf("Executing the plan as task `%s`.", taskID))
+	results, err := i.system.ExecutePlan(ctx, pending.plan, execToken, pending.workspace.Dir)
+	var paused *agent.BlastRadiusError
+	if errors.As(err, &paused) {
+		pending.approvalID = paused.ApprovalID
+		i.mu.Lock()
+		i.plans[j.key()] = pending
+		i.mu.Unlock()
+		i.report(ctx, token, j, fmt.Sprintf("Paused: %s. Comment `/spilot approve` to continue without limits, or `/spilot reject` to stop.", paused.Reason))
+		return nil
+	}
+	if err != nil {
+		pending.workspace.remove()
+		return err
+	}
+	var last *agent.TaskResult
+	if len(results) > 0 {
+		last = results[len(results)-1]
+	}
+	return i.publish(ctx, token, pending.workspace, pending.job, taskID, last, pending.plan)
+}
+
+// reject drops the plan proposed on an issue
+func (i *Integration) reject(ctx context.Context, token string, j job) error {
+	i.mu.Lock()
+	pending, ok := i.plans[j.key()]
+	delete(i.plans, j.key())
+	i.mu.Unlock()
+	if !ok {
+		return errors.New("there is no plan waiting for approval here")
+	}
+	if _, _, _, err := i.system.DecideApproval(pending.approvalID, false); err != nil {
+		i.logger.Warn("Failed to reject plan", zap.String("approval_id", pending.approvalID), zap.Error(err))
+	}
+	pending.workspace.remove()
+	i.report(ctx, token, j, "Plan dropped.")
+	return nil
+}
+
+// report comments on the issue or pull request of a job, or else on its
+// commit
+func (i *Integration) report(ctx context.Context, token string, j job, body string) {
+	var err error
+	if j.number == 0 {
+		err = i.client.commitComment(ctx, token, j.repo, j.commit, body)
+	} else {
+		err = i.client.comment(ctx, token, j.repo, j.number, body)
+	}
+	if err != nil {
+		i.logger.Warn("Failed to comment on GitHub", zap.String("repo", j.repo), zap.Int("number", j.number), zap.Error(err))
+	}
+}
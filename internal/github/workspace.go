@@ -0,0 +1,150 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/forge"
+)
+
+// GitHub refuses issue and pull request bodies longer than 65536
+// characters; the sections of a body are cut to fit
+const (
+	maxBodyBytes       = 60000
+	maxDiffBytes       = 30000
+	maxTranscriptBytes = 20000
+	maxPlanBytes       = 8000
+)
+
+// workspace is a clone a task works on
+type workspace struct {
+	*forge.Clone
+	// base is the branch the pull request goes into
+	base string
+}
+
+func (w *workspace) remove() {
+	w.Clone.Remove()
+}
+
+// credentials authenticate git to GitHub with an API token
+func credentials(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "x-access-token:" + token
+}
+
+// checkout clones the repository of a job and creates the task's branch.
+// Work asked for on a pull request builds on its branch, so the result can
+// be merged into it; work on an issue starts from the default branch, and
+// work on a failed run from the commit that failed.
+func (i *Integration) checkout(ctx context.Context, token string, j job, taskID string) (*workspace, error) {
+	base := j.base
+	if j.pullRequest {
+		pr, err := i.client.pullRequest(ctx, token, j.repo, j.number)
+		if err != nil {
+			return nil, err
+		}
+		base = pr.Base.Ref
+		if pr.Head.Repo.FullName == j.repo {
+			base = pr.Head.Ref
+		}
+	}
+
+	root := i.cfg.WorkDir
+	if i.system.Tenancy() != nil {
+		// Clones must be inside the workspace root of the requester
+		tenantRoot, err := i.system.TenantWorkspace(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		root = filepath.Join(tenantRoot, "github")
+	}
+	dir := filepath.Join(root, strings.ReplaceAll(j.repo, "/", "_"), taskID)
+	branch := fmt.Sprintf("spilot/%d-%s", j.number, taskID)
+	if j.number == 0 {
+		branch = "spilot/" + taskID
+	}
+	clone, err := forge.NewClone(ctx, j.cloneURL, credentials(token), base, j.commit, dir, branch)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace{Clone: clone, base: base}, nil
+}
+
+// publish commits what a task changed, pushes it and opens a pull request
+// with the diff, the plan if there was one and the task's transcript. The
+// clone is removed afterwards.
+func (i *Integration) publish(ctx context.Context, token string, w *workspace, j job, taskID string, result *agent.TaskResult, plan string) error {
+	defer w.remove()
+	summary := resultSummary(result)
+	if result != nil && !result.Success {
+		return fmt.Errorf("%s\n\n%s", result.Error, summary)
+	}
+	message := "Spilot: " + j.title
+	if j.number > 0 {
+		message += fmt.Sprintf(" (#%d)", j.number)
+	}
+	diff, err := w.Commit(ctx, message+"\n\nTask "+taskID)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		i.report(ctx, token, j, strings.TrimSpace("Done; no files were changed.\n\n"+summary))
+		return nil
+	}
+	if err := w.Push(ctx, credentials(token)); err != nil {
+		return err
+	}
+
+	transcript := ""
+	if t, err := i.system.TaskTranscript(ctx, taskID); err == nil {
+		transcript = t.Markdown()
+	}
+	body := pullRequestBody(j, summary, plan, diff, transcript)
+	pr, err := i.client.openPullRequest(ctx, token, j.repo, w.Branch, w.base, "Spilot: "+j.title, body)
+	if err != nil {
+		return err
+	}
+	i.report(ctx, token, j, fmt.Sprintf("Opened #%d with the changes: %s", pr.Number, pr.HTMLURL))
+	return nil
+}
+
+func pullRequestBody(j job, summary, plan, diff, transcript string) string {
+	var b strings.Builder
+	b.WriteString(j.source + "\n\n")
+	if summary != "" {
+		b.WriteString(summary + "\n\n")
+	}
+	if plan != "" {
+		b.WriteString(forge.Details("Plan", forge.Fence("json", forge.Truncate(plan, maxPlanBytes))))
+	}
+	b.WriteString(forge.Details("Diff", forge.Fence("diff", forge.Truncate(diff, maxDiffBytes))))
+	if transcript != "" {
+		b.WriteString(forge.Details("Transcript", forge.Truncate(transcript, maxTranscriptBytes)))
+	}
+	return b.String()
+}
+
+// resultFields are the result fields that describe what a task did, in
+// the order they are looked for
+var resultFields = []string{"message", "response", "answer", "explanation", "summary", "analysis"}
+
+func resultSummary(result *agent.TaskResult) string {
+	if result == nil {
+		return ""
+	}
+	if debug := result.Debug(); debug != nil && strings.TrimSpace(debug.Analysis) != "" {
+		return forge.Truncate(strings.TrimSpace(debug.Analysis), maxPlanBytes)
+	}
+	for _, field := range resultFields {
+		if text, ok := result.Fields()[field].(string); ok && strings.TrimSpace(text) != "" {
+			return forge.Truncate(strings.TrimSpace(text), maxPlanBytes)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,315 @@
+// Package gitlab triages failed GitLab CI pipelines: the DebugAgent
+// analyzes the logs of their failed jobs on a clone of the failing commit,
+// and the analysis, or a merge request with the fix, is posted back on the
+// merge request or commit.
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/forge"
+
+	"go.uber.org/zap"
+)
+
+// Requester is the requester audited for tasks started from GitLab
+const Requester = "gitlab"
+
+// Limits of what is downloaded, analyzed and posted
+const (
+	maxPayloadBytes    = 25 << 20
+	maxLogBytes        = 1 << 20
+	maxFailureLogBytes = 12000
+	maxTriagedJobs     = 3
+	maxTextBytes       = 8000
+	maxDiffBytes       = 30000
+	maxTranscriptBytes = 20000
+)
+
+// apiTimeout bounds one GitLab API call
+const apiTimeout = 30 * time.Second
+
+// Config enables triage when WebhookToken, the secret token of the
+// project's pipeline webhook, is set. The API at URL is called with Token,
+// which needs the api scope. Clones are kept in WorkDir; with CIFix the fix
+// is proposed as a merge request.
+type Config struct {
+	WebhookToken string
+	Token        string
+	URL          string
+	WorkDir      string
+	CIFix        bool
+}
+
+// Integration receives GitLab pipeline webhooks and triages failures
+type Integration struct {
+	cfg    Config
+	system *agent.System
+	http   *http.Client
+	logger *zap.Logger
+}
+
+// New creates the integration, or returns nil if it is not configured.
+// Clones default to dataDir/gitlab.
+func New(cfg Config, dataDir string, system *agent.System, logger *zap.Logger) *Integration {
+	if cfg.WebhookToken == "" {
+		return nil
+	}
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = filepath.Join(dataDir, "gitlab")
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &Integration{cfg: cfg, system: system, http: &http.Client{Timeout: apiTimeout}, logger: logger}
+}
+
+// pipelineEvent is the part of a pipeline hook delivery triage uses
+type pipelineEvent struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		ID     int64  `json:"id"`
+		Ref    string `json:"ref"`
+		Tag    bool   `json:"tag"`
+		SHA    string `json:"sha"`
+		Status string `json:"status"`
+		URL    string `json:"url"`
+	} `json:"object_attributes"`
+	MergeRequest *struct {
+		IID             int    `json:"iid"`
+		SourceBranch    string `json:"source_branch"`
+		SourceProjectID int64  `json:"source_project_id"`
+	} `json:"merge_request"`
+	Project struct {
+		ID                int64  `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+	} `json:"project"`
+	Builds []struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Stage  string `json:"stage"`
+		Status string `json:"status"`
+	} `json:"builds"`
+}
+
+// ServeHTTP receives a webhook delivery. Deliveries must carry the secret
+// token and are acknowledged at once; failed pipelines are triaged
+// afterwards.
+func (i *Integration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(i.cfg.WebhookToken)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	var event pipelineEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxPayloadBytes)).Decode(&event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	accepted := r.Header.Get("X-Gitlab-Event") == "Pipeline Hook" && event.ObjectKind == "pipeline" &&
+		event.ObjectAttributes.Status == "failed" &&
+		// Pipelines of the fixes proposed here are left alone so failures
+		// do not loop
+		!strings.HasPrefix(event.ObjectAttributes.Ref, "spilot/")
+	if accepted {
+		i.logger.Info("GitLab pipeline triage requested",
+			zap.String("project", event.Project.PathWithNamespace), zap.Int64("pipeline_id", event.ObjectAttributes.ID))
+		go func() {
+			if err := i.triage(&event); err != nil {
+				i.logger.Warn("GitLab pipeline triage failed",
+					zap.String("project", event.Project.PathWithNamespace), zap.Int64("pipeline_id", event.ObjectAttributes.ID), zap.Error(err))
+			}
+		}()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]bool{"accepted": accepted})
+}
+
+// triage has the DebugAgent analyze the logs of a pipeline's failed jobs
+// and posts the analysis; with CIFix, its fix goes into a merge request
+func (i *Integration) triage(event *pipelineEvent) error {
+	ctx := agent.ContextWithRequester(context.Background(), Requester)
+	pipeline, project := event.ObjectAttributes, event.Project
+
+	var failures []string
+	for _, build := range event.Builds {
+		if build.Status != "failed" || len(failures) == maxTriagedJobs {
+			continue
+		}
+		var log []byte
+		path := fmt.Sprintf("/projects/%d/jobs/%d/trace", project.ID, build.ID)
+		if err := i.call(ctx, http.MethodGet, path, nil, &log); err != nil {
+			i.logger.Warn("Failed to download job log", zap.String("project", project.PathWithNamespace), zap.Int64("job_id", build.ID), zap.Error(err))
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("Job %s failed in stage %s:\n%s", build.Name, build.Stage, forge.LogTail(string(log), maxFailureLogBytes)))
+	}
+	if len(failures) == 0 {
+		return errors.New("no log of a failed job could be downloaded")
+	}
+
+	ref := pipeline.Ref
+	if event.MergeRequest != nil {
+		ref = event.MergeRequest.SourceBranch
+	}
+	// Fixes go onto the pipeline's branch, which tags and forks are not
+	fixable := i.cfg.CIFix && !pipeline.Tag &&
+		(event.MergeRequest == nil || event.MergeRequest.SourceProjectID == project.ID)
+
+	taskID := agent.NewTaskID()
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	root := i.cfg.WorkDir
+	if i.system.Tenancy() != nil {
+		// Clones must be inside the workspace root of the requester
+		tenantRoot, err := i.system.TenantWorkspace(ctx, "")
+		if err != nil {
+			return err
+		}
+		root = filepath.Join(tenantRoot, "gitlab")
+	}
+	dir := filepath.Join(root, strings.ReplaceAll(project.PathWithNamespace, "/", "_"), taskID)
+	clone, err := forge.NewClone(ctx, project.GitHTTPURL, i.credentials(), ref, pipeline.SHA, dir, "spilot/"+taskID)
+	if err != nil {
+		return err
+	}
+	defer clone.Remove()
+
+	result, err := i.system.HandleCommand(ctx, "/fix", strings.Join(failures, "\n\n"), clone.Dir, map[string]interface{}{"apply": fixable})
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Spilot triaged the [failed pipeline](%s) as task `%s`.\n\n", pipeline.URL, taskID)
+	debug := result.Debug()
+	if debug != nil && debug.Analysis != "" {
+		b.WriteString(forge.Truncate(strings.TrimSpace(debug.Analysis), maxTextBytes) + "\n\n")
+	}
+	if debug != nil && !fixable && debug.Fix != "" {
+		b.WriteString(forge.Details("Suggested fix", forge.Fence("", forge.Truncate(debug.Fix, maxDiffBytes))))
+	}
+	if !result.Success && result.Error != "" {
+		b.WriteString("The fix could not be applied: " + result.Error + "\n")
+	}
+	if fixable && result.Success {
+		link, err := i.proposeFix(ctx, event, clone, ref, taskID)
+		if err != nil {
+			b.WriteString("The fix could not be proposed: " + err.Error() + "\n")
+		} else if link != "" {
+			b.WriteString("Proposed the fix in " + link + "\n")
+		}
+	}
+	return i.note(ctx, event, strings.TrimSpace(b.String()))
+}
+
+// proposeFix commits the fix, pushes it and opens a merge request into
+// ref, returning a link to it, or "" if nothing changed
+func (i *Integration) proposeFix(ctx context.Context, event *pipelineEvent, clone *forge.Clone, ref, taskID string) (string, error) {
+	diff, err := clone.Commit(ctx, "Spilot: fix failed pipeline "+fmt.Sprint(event.ObjectAttributes.ID)+"\n\nTask "+taskID)
+	if err != nil || diff == "" {
+		return "", err
+	}
+	if err := clone.Push(ctx, i.credentials()); err != nil {
+		return "", err
+	}
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "Fixes the [failed pipeline](%s) of %s.\n\n", event.ObjectAttributes.URL, shortSHA(event.ObjectAttributes.SHA))
+	description.WriteString(forge.Details("Diff", forge.Fence("diff", forge.Truncate(diff, maxDiffBytes))))
+	if t, err := i.system.TaskTranscript(ctx, taskID); err == nil {
+		description.WriteString(forge.Details("Transcript", forge.Truncate(t.Markdown(), maxTranscriptBytes)))
+	}
+	var mr struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	path := fmt.Sprintf("/projects/%d/merge_requests", event.Project.ID)
+	request := map[string]interface{}{
+		"source_branch":        clone.Branch,
+		"target_branch":        ref,
+		"title":                "Spilot: fix failed pipeline",
+		"description":          description.String(),
+		"remove_source_branch": true,
+	}
+	if err := i.call(ctx, http.MethodPost, path, request, &mr); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("[!%d](%s)", mr.IID, mr.WebURL), nil
+}
+
+// note posts body on the merge request of the pipeline, or on its commit
+func (i *Integration) note(ctx context.Context, event *pipelineEvent, body string) error {
+	if event.MergeRequest != nil {
+		path := fmt.Sprintf("/projects/%d/merge_requests/%d/notes", event.Project.ID, event.MergeRequest.IID)
+		return i.call(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+	}
+	path := fmt.Sprintf("/projects/%d/repository/commits/%s/comments", event.Project.ID, url.PathEscape(event.ObjectAttributes.SHA))
+	return i.call(ctx, http.MethodPost, path, map[string]string{"note": body}, nil)
+}
+
+// credentials authenticate git with the API token
+func (i *Integration) credentials() string {
+	return "oauth2:" + i.cfg.Token
+}
+
+// call calls the API and decodes the response into out, if not nil. A
+// *[]byte out receives the end of the response as it is, such as a log.
+func (i *Integration) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, i.cfg.URL+"/api/v4"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", i.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := i.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message interface{} `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		return fmt.Errorf("%s %s: %s: %v", method, path, resp.Status, failure.Message)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out, err = forge.ReadTail(resp.Body, maxLogBytes)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
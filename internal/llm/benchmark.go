@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// BenchmarkPrompt is one prompt of a benchmark suite. WantJSON prompts ask
+// for JSON and count towards the JSON validity rate.
+type BenchmarkPrompt struct {
+	Name     string
+	System   string
+	User     string
+	WantJSON bool
+}
+
+// StandardSuite resembles the requests the agent makes: intent
+// classification, command generation, planning, code generation and
+// structured error analysis
+var StandardSuite = []BenchmarkPrompt{
+	{
+		Name:   "classify",
+		System: "You classify requests.",
+		User:   `Is "run the tests in terminal" asking to execute a terminal command, for code, or something else? Respond with only one word: TERMINAL, CODE or GENERAL.`,
+	},
+	{
+		Name:   "command",
+		System: "You translate instructions into shell commands. Respond with the command only.",
+		User:   "Find every Go file under the current directory changed in the last day.",
+	},
+	{
+		Name:     "plan",
+		System:   "You plan tasks for a coding agent. Respond with JSON only.",
+		User:     `Break "add a /version endpoint to the Go HTTP server in server.go" into a JSON array of tasks, each with "type", "description" and "data".`,
+		WantJSON: true,
+	},
+	{
+		Name:   "code",
+		System: "You are an expert Go programmer. Respond with code only.",
+		User:   "Write a Go function that returns the n-th Fibonacci number iteratively.",
+	},
+	{
+		Name:     "analyze",
+		System:   "You analyze build errors. Respond with JSON only.",
+		User:     "main.go:12:2: undefined: fmt.Printn\nRespond with a JSON object with \"cause\" and \"fix\".",
+		WantJSON: true,
+	},
+}
+
+// ModelBenchmark is how one model performed on a benchmark suite
+type ModelBenchmark struct {
+	Model  string `json:"model"`
+	Calls  int    `json:"calls"`
+	Errors int    `json:"errors"`
+	// Latencies are of successful calls, in milliseconds
+	MeanLatencyMS int64 `json:"mean_latency_ms"`
+	P50LatencyMS  int64 `json:"p50_latency_ms"`
+	P95LatencyMS  int64 `json:"p95_latency_ms"`
+	// TokensPerSecond is completion tokens over total successful latency
+	TokensPerSecond  float64 `json:"tokens_per_second"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	// JSONValidRate is the share of answers to WantJSON prompts that parse
+	JSONValidRate float64  `json:"json_valid_rate"`
+	LastError     string   `json:"last_error,omitempty"`
+	Prompts       []string `json:"prompts"`
+}
+
+// Benchmark runs suite against each model runs times, one call at a time
+// so latencies are not skewed by rate limiting. Calls are reported to
+// OnUsage like any other.
+func (g *GroqClient) Benchmark(ctx context.Context, models []string, suite []BenchmarkPrompt, runs int) []ModelBenchmark {
+	if runs < 1 {
+		runs = 1
+	}
+	results := make([]ModelBenchmark, 0, len(models))
+	for _, model := range models {
+		result := ModelBenchmark{Model: model}
+		var latencies []time.Duration
+		var total time.Duration
+		var jsonCalls, jsonValid int
+		for _, prompt := range suite {
+			result.Prompts = append(result.Prompts, prompt.Name)
+		}
+		for run := 0; run < runs && ctx.Err() == nil; run++ {
+			for _, prompt := range suite {
+				messages := []openai.ChatCompletionMessage{
+					{Role: openai.ChatMessageRoleSystem, Content: prompt.System},
+					{Role: openai.ChatMessageRoleUser, Content: prompt.User},
+				}
+				start := time.Now()
+				resp, err := g.complete(ctx, model, messages)
+				latency := time.Since(start)
+				result.Calls++
+				if err == nil && len(resp.Choices) == 0 {
+					err = errNoChoices
+				}
+				if err != nil {
+					result.Errors++
+					result.LastError = err.Error()
+					continue
+				}
+				latencies = append(latencies, latency)
+				total += latency
+				result.PromptTokens += resp.Usage.PromptTokens
+				result.CompletionTokens += resp.Usage.CompletionTokens
+				if prompt.WantJSON {
+					jsonCalls++
+					if validJSON(resp.Choices[0].Message.Content) {
+						jsonValid++
+					}
+				}
+			}
+		}
+		if len(latencies) > 0 {
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			result.MeanLatencyMS = (total / time.Duration(len(latencies))).Milliseconds()
+			result.P50LatencyMS = percentile(latencies, 0.50).Milliseconds()
+			result.P95LatencyMS = percentile(latencies, 0.95).Milliseconds()
+			result.TokensPerSecond = float64(result.CompletionTokens) / total.Seconds()
+		}
+		if jsonCalls > 0 {
+			result.JSONValidRate = float64(jsonValid) / float64(jsonCalls)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+// validJSON reports whether an answer is JSON, allowing a Markdown code
+// fence around it since the agent strips those
+func validJSON(answer string) bool {
+	answer = strings.TrimSpace(answer)
+	if strings.HasPrefix(answer, "```") {
+		answer = strings.TrimPrefix(answer, "```json")
+		answer = strings.TrimPrefix(answer, "```")
+		answer = strings.TrimSuffix(strings.TrimSpace(answer), "```")
+	}
+	return json.Valid([]byte(strings.TrimSpace(answer)))
+}
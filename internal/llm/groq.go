@@ -2,35 +2,145 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"spilot-agent/internal/redact"
 
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
 
+// ErrUnknownModel is returned for models the provider does not serve
+var ErrUnknownModel = errors.New("the provider does not serve model")
+
+var errNoChoices = errors.New("no response from model")
+
 // GroqClient wraps the OpenAI client for Groq API
 type GroqClient struct {
-	client *openai.Client
-	model  string
+	mu         sync.RWMutex
+	client     *openai.Client
+	httpClient *http.Client
+	model      string
+	// onUsage receives the tokens used by each completion; nil discards them
+	onUsage func(ctx context.Context, usage Usage)
+	// redactor removes secrets from prompts; nil sends them unchanged
+	redactor *redact.Redactor
+	onRedact func(ctx context.Context, count int)
+	// inflight shares the responses of identical concurrent requests
+	inflight inflight
+	// router picks models for requests with a latency budget
+	router router
 	logger *zap.Logger
 }
 
-// NewGroqClient creates a new Groq client
-func NewGroqClient(apiKey, model string) (*GroqClient, error) {
+// Usage is the number of tokens a completion used
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	// CachedTokens are the prompt tokens the provider read from its prompt
+	// cache, which providers that cache prompts charge less for
+	CachedTokens int
+}
+
+// NewGroqClient creates a new Groq client reaching the API as transport
+// says
+func NewGroqClient(apiKey, model string, transport TransportConfig) (*GroqClient, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("API key is required")
 	}
+	httpClient, err := NewHTTPClient(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GroqClient{
+		client:     newGroqAPIClient(apiKey, httpClient),
+		httpClient: httpClient,
+		model:      model,
+		logger:     zap.NewNop(),
+	}, nil
+}
 
+func newGroqAPIClient(apiKey string, httpClient *http.Client) *openai.Client {
 	config := openai.DefaultConfig(apiKey)
 	config.BaseURL = "https://api.groq.com/openai/v1"
+	config.HTTPClient = httpClient
+	return openai.NewClientWithConfig(config)
+}
 
-	client := openai.NewClientWithConfig(config)
+// SetAPIKey switches to a new API key, e.g. after the secret was rotated.
+// Requests in flight finish with the old key.
+func (g *GroqClient) SetAPIKey(apiKey string) error {
+	if apiKey == "" {
+		return fmt.Errorf("API key is required")
+	}
+	client := newGroqAPIClient(apiKey, g.httpClient)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.client = client
+	return nil
+}
 
-	return &GroqClient{
-		client: client,
-		model:  model,
-		logger: zap.NewNop(),
-	}, nil
+func (g *GroqClient) apiClient() *openai.Client {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.client
+}
+
+// OnUsage makes the client report the tokens used by each completion to
+// fn, called with the context of the request
+func (g *GroqClient) OnUsage(fn func(ctx context.Context, usage Usage)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onUsage = fn
+}
+
+// SetRedactor makes the client replace the secrets in prompts, such as
+// keys in file contents or command output, before sending them
+func (g *GroqClient) SetRedactor(redactor *redact.Redactor) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.redactor = redactor
+}
+
+// OnRedact makes the client report how many secrets it removed from a
+// prompt to fn, called with the context of the request
+func (g *GroqClient) OnRedact(fn func(ctx context.Context, count int)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onRedact = fn
+}
+
+// redact returns messages with their secrets replaced
+func (g *GroqClient) redact(ctx context.Context, messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	g.mu.RLock()
+	redactor, onRedact := g.redactor, g.onRedact
+	g.mu.RUnlock()
+	if redactor == nil {
+		return messages
+	}
+	redacted := make([]openai.ChatCompletionMessage, len(messages))
+	total := 0
+	for i, message := range messages {
+		var count int
+		message.Content, count = redactor.Redact(message.Content)
+		redacted[i] = message
+		total += count
+	}
+	if total > 0 {
+		g.logger.Warn("Redacted secrets from prompt", zap.Int("count", total))
+		if onRedact != nil {
+			onRedact(ctx, total)
+		}
+	}
+	return redacted
 }
 
 // SetLogger sets the logger for the client
@@ -38,25 +148,72 @@ func (g *GroqClient) SetLogger(logger *zap.Logger) {
 	g.logger = logger
 }
 
-// Chat sends a chat completion request to Groq
+// Chat sends a chat completion request to Groq, adding any instructions
+// carried by ctx (see WithInstructions) to the system prompt
 func (g *GroqClient) Chat(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
-	resp, err := g.client.CreateChatCompletion(
+	resp, err := g.complete(ctx, g.model, withInstructions(ctx, messages))
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", errNoChoices
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// complete sends a chat completion request for model and reports the
+// tokens it used. Byte-identical requests made while one is in flight share
+// its response instead of calling the provider again, and only the caller
+// that made the call reports its tokens.
+func (g *GroqClient) complete(ctx context.Context, model string, messages []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
+	messages = g.redact(ctx, messages)
+	key, err := requestKey(model, messages)
+	if err != nil {
+		return g.send(ctx, model, messages)
+	}
+	resp, shared, err := g.inflight.do(ctx, key, func(ctx context.Context) (openai.ChatCompletionResponse, error) {
+		return g.send(ctx, model, messages)
+	})
+	if shared && err == nil {
+		g.logger.Debug("Shared an identical completion in flight", zap.String("model", model))
+	}
+	if err != nil && err == ctx.Err() {
+		// the caller gave up waiting
+		return resp, fmt.Errorf("failed to create chat completion: %w", err)
+	}
+	return resp, err
+}
+
+// send makes a chat completion request to the provider and reports the
+// tokens it used
+func (g *GroqClient) send(ctx context.Context, model string, messages []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
+	resp, err := g.apiClient().CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model:    g.model,
+			Model:    model,
 			Messages: messages,
 		},
 	)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to create chat completion: %w", err)
+		return resp, fmt.Errorf("failed to create chat completion: %w", err)
 	}
-
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from model")
+	g.mu.RLock()
+	onUsage := g.onUsage
+	g.mu.RUnlock()
+	if onUsage != nil {
+		if resp.Model != "" {
+			model = resp.Model
+		}
+		usage := Usage{Model: model, PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+		if details := resp.Usage.PromptTokensDetails; details != nil {
+			usage.CachedTokens = details.CachedTokens
+		}
+		onUsage(ctx, usage)
 	}
-
-	return resp.Choices[0].Message.Content, nil
+	return resp, nil
 }
 
 // ClassifyIntent uses the LLM to classify the user's intent.
@@ -76,7 +233,7 @@ Respond with only one of the following words: "TERMINAL", "CODE", or "GENERAL".`
 		},
 	}
 
-	return g.Chat(ctx, messages)
+	return g.chatAs(ctx, RequestClassify, messages)
 }
 
 // AnalyzeError analyzes a terminal error and suggests fixes
@@ -110,18 +267,20 @@ Respond in a clear, actionable format.`, errorOutput, fileContent)
 	return g.Chat(ctx, messages)
 }
 
-// GenerateCommand converts natural language to shell commands
-func (g *GroqClient) GenerateCommand(ctx context.Context, instruction string) (string, error) {
-	prompt := fmt.Sprintf(`Convert this natural language instruction to a shell command:
+// GenerateCommand converts natural language to a command for the given shell
+func (g *GroqClient) GenerateCommand(ctx context.Context, instruction, shell string) (string, error) {
+	prompt := fmt.Sprintf(`Convert this natural language instruction to a %s command:
 
 Instruction: %s
+Operating system: %s
+Shell: %s
 
-Provide only the shell command, no explanations. If multiple commands are needed, separate them with && or ; as appropriate.`, instruction)
+Provide only the command, no explanations. Use syntax valid for this shell. If multiple commands are needed, chain them the way this shell expects.`, shell, instruction, runtime.GOOS, shell)
 
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: "You are a command-line expert. Convert natural language to exact shell commands.",
+			Content: "You are a command-line expert. Convert natural language to exact commands for the requested shell.",
 		},
 		{
 			Role:    openai.ChatMessageRoleUser,
@@ -129,7 +288,7 @@ Provide only the shell command, no explanations. If multiple commands are needed
 		},
 	}
 
-	return g.Chat(ctx, messages)
+	return g.chatAs(ctx, RequestCommand, messages)
 }
 
 // PlanProject creates a project plan from natural language description
@@ -179,13 +338,13 @@ Format as JSON with the following structure:
 
 // GenerateCode generates code based on requirements
 func (g *GroqClient) GenerateCode(ctx context.Context, requirements, context string) (string, error) {
-	prompt := fmt.Sprintf(`Generate code based on these requirements:
-
-Requirements: %s
+	prompt := fmt.Sprintf(`Generate code based on these requirements.
 
 Context: %s
 
-Provide only the code, no explanations unless specifically requested.`, requirements, context)
+Requirements: %s
+
+Provide only the code, no explanations unless specifically requested.`, context, requirements)
 
 	messages := []openai.ChatCompletionMessage{
 		{
@@ -201,6 +360,34 @@ Provide only the code, no explanations unless specifically requested.`, requirem
 	return g.Chat(ctx, messages)
 }
 
+// ListModels returns the IDs of the models the provider serves
+func (g *GroqClient) ListModels(ctx context.Context) ([]string, error) {
+	models, err := g.apiClient().ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	ids := make([]string, 0, len(models.Models))
+	for _, model := range models.Models {
+		ids = append(ids, model.ID)
+	}
+	return ids, nil
+}
+
+// CheckModel returns an error if the provider does not serve model
+func (g *GroqClient) CheckModel(ctx context.Context, model string) error {
+	ids, err := g.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == model {
+			return nil
+		}
+	}
+	sort.Strings(ids)
+	return fmt.Errorf("%w %q; available models: %s", ErrUnknownModel, model, strings.Join(ids, ", "))
+}
+
 // SetModel changes the model used for requests
 func (g *GroqClient) SetModel(model string) {
 	g.model = model
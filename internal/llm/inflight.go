@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// inflight collapses concurrent identical completion requests, such as
+// those of a client retrying, into one provider call whose response every
+// caller shares. The zero value is ready to use.
+type inflight struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// inflightCall is a provider call and the callers waiting for it
+type inflightCall struct {
+	done    chan struct{}
+	resp    openai.ChatCompletionResponse
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// requestKey identifies the completion request for model and messages
+func requestKey(model string, messages []openai.ChatCompletionMessage) (string, error) {
+	data, err := json.Marshal(openai.ChatCompletionRequest{Model: model, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// do returns the response of the call for key in flight, or of fn if none
+// is; shared reports whether another caller started the call. fn runs with
+// the values of ctx and is cancelled only once every caller waiting for it
+// has given up, so one caller's cancellation does not fail the others.
+func (f *inflight) do(ctx context.Context, key string, fn func(ctx context.Context) (openai.ChatCompletionResponse, error)) (resp openai.ChatCompletionResponse, shared bool, err error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*inflightCall)
+	}
+	c, shared := f.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &inflightCall{done: make(chan struct{}), cancel: cancel}
+		f.calls[key] = c
+		go func() {
+			c.resp, c.err = fn(callCtx)
+			cancel()
+			f.forget(key, c)
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.resp, shared, c.err
+	case <-ctx.Done():
+		f.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if f.calls[key] == c {
+				delete(f.calls, key)
+			}
+		}
+		f.mu.Unlock()
+		return openai.ChatCompletionResponse{}, shared, ctx.Err()
+	}
+}
+
+// forget stops later callers from joining call c for key
+func (f *inflight) forget(key string, c *inflightCall) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls[key] == c {
+		delete(f.calls, key)
+	}
+}
@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// Request types that can be routed to the fastest model meeting their
+// latency budget. Both are interactive and answered in a few tokens, so
+// their latency says how fast a model is rather than how long the answer.
+const (
+	RequestClassify = "classify"
+	RequestCommand  = "command"
+)
+
+// RoutedRequests are the request types a latency budget can be set for
+var RoutedRequests = []string{RequestClassify, RequestCommand}
+
+const (
+	// latencySamples is how many recent calls of a model its latency is
+	// computed over
+	latencySamples = 50
+	// latencyWindow is how old samples may be and still count
+	latencyWindow = 15 * time.Minute
+	// minLatencySamples is how many samples a model needs to be routed to
+	minLatencySamples = 3
+	// probePrompt is the request probes time: the shortest useful answer
+	probePrompt = "Respond with only the word OK."
+	// probeTimeout is how long a probe may take before it counts as failed
+	probeTimeout = 30 * time.Second
+)
+
+// RoutingConfig routes the request types with a latency budget in Budgets
+// to the fastest of Models whose rolling 95th percentile latency is within
+// budget, or the fastest of all if none is. Models are probed every
+// ProbeInterval, so the latencies of those not routed to stay current and
+// their connections warm; 0 does not probe, and only models requests are
+// routed to are measured.
+type RoutingConfig struct {
+	Models        []string
+	Budgets       map[string]time.Duration
+	ProbeInterval time.Duration
+}
+
+// ModelLatency is the rolling latency of a model's routed calls and
+// probes. A failed call counts as slower than any budget; a percentile it
+// decides is -1.
+type ModelLatency struct {
+	Model    string `json:"model"`
+	Samples  int    `json:"samples"`
+	Failures int    `json:"failures"`
+	P50MS    int64  `json:"p50_ms"`
+	P95MS    int64  `json:"p95_ms"`
+	p95      time.Duration
+}
+
+// failedLatency stands for the latency of a failed call
+const failedLatency = time.Duration(1<<63 - 1)
+
+// router tracks the latency of models and picks one per request type
+type router struct {
+	mu      sync.Mutex
+	cfg     RoutingConfig
+	samples map[string][]latencySample
+	// stopProbes ends the probes of the current configuration
+	stopProbes context.CancelFunc
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// SetRouting routes requests by latency as cfg says, replacing the
+// previous routing. A cfg without budgets routes nothing.
+func (g *GroqClient) SetRouting(cfg RoutingConfig) {
+	r := &g.router
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopProbes != nil {
+		r.stopProbes()
+		r.stopProbes = nil
+	}
+	r.cfg = cfg
+	if len(cfg.Budgets) > 0 && cfg.ProbeInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.stopProbes = cancel
+		go g.probe(ctx, cfg.ProbeInterval)
+	}
+}
+
+// ModelLatencies returns the rolling latency of each model measured
+func (g *GroqClient) ModelLatencies() []ModelLatency {
+	r := &g.router
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latencies := make([]ModelLatency, 0, len(r.samples))
+	now := time.Now()
+	for model := range r.samples {
+		latencies = append(latencies, r.latency(model, now))
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i].Model < latencies[j].Model })
+	return latencies
+}
+
+// RoutedModel returns the model requests of type kind go to now, given
+// the default model
+func (g *GroqClient) RoutedModel(kind string) string {
+	return g.router.choose(kind, g.GetModel())
+}
+
+// chatAs sends a chat completion of request type kind to the model routed
+// to, timing it, or as Chat does if kind has no latency budget. A routed
+// model that fails is retried with the default one.
+func (g *GroqClient) chatAs(ctx context.Context, kind string, messages []openai.ChatCompletionMessage) (string, error) {
+	fallback := g.GetModel()
+	model := g.router.choose(kind, fallback)
+	if model == "" {
+		return g.Chat(ctx, messages)
+	}
+	text, err := g.timedChat(ctx, model, messages)
+	if err != nil && model != fallback && ctx.Err() == nil {
+		g.logger.Warn("Routed model failed; retrying with the default model", zap.String("model", model), zap.String("request", kind), zap.Error(err))
+		return g.timedChat(ctx, fallback, messages)
+	}
+	return text, err
+}
+
+// timedChat sends a chat completion to model and records its latency
+func (g *GroqClient) timedChat(ctx context.Context, model string, messages []openai.ChatCompletionMessage) (string, error) {
+	start := time.Now()
+	resp, err := g.complete(ctx, model, withInstructions(ctx, messages))
+	if err == nil && len(resp.Choices) == 0 {
+		err = errNoChoices
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		// A caller giving up says nothing about the model, but a deadline
+		// passing does
+		g.router.observe(model, time.Since(start), err != nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// probe times a minimal request to every routed model each interval until
+// ctx is done
+func (g *GroqClient) probe(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, model := range g.router.models(g.GetModel()) {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			g.timedChat(probeCtx, model, []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: probePrompt}})
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// models returns the models routed between: the configured ones and the
+// default model
+func (r *router) models(fallback string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	models := append([]string(nil), r.cfg.Models...)
+	for _, model := range models {
+		if model == fallback {
+			return models
+		}
+	}
+	return append(models, fallback)
+}
+
+// choose returns the model for a request of type kind: the fastest one
+// meeting its budget, else the fastest one, else fallback until models
+// have enough samples. It returns "" if kind has no budget.
+func (r *router) choose(kind, fallback string) string {
+	models := r.models(fallback)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	budget, ok := r.cfg.Budgets[kind]
+	if !ok || budget <= 0 {
+		return ""
+	}
+	now := time.Now()
+	best, bestMeets := "", false
+	var bestP95 time.Duration
+	for _, model := range models {
+		latency := r.latency(model, now)
+		if latency.Samples < minLatencySamples {
+			continue
+		}
+		meets := latency.p95 <= budget
+		if best == "" || (meets && !bestMeets) || (meets == bestMeets && latency.p95 < bestP95) {
+			best, bestMeets, bestP95 = model, meets, latency.p95
+		}
+	}
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+// observe records the latency of a call to model
+func (r *router) observe(model string, latency time.Duration, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.samples == nil {
+		r.samples = make(map[string][]latencySample)
+	}
+	samples := append(r.samples[model], latencySample{at: time.Now(), latency: latency, failed: failed})
+	if len(samples) > latencySamples {
+		samples = samples[len(samples)-latencySamples:]
+	}
+	r.samples[model] = samples
+}
+
+// latency computes the rolling latency of model; r.mu is held. Failures
+// sort after every latency.
+func (r *router) latency(model string, now time.Time) ModelLatency {
+	var latencies []time.Duration
+	failures := 0
+	for _, sample := range r.samples[model] {
+		if now.Sub(sample.at) > latencyWindow {
+			continue
+		}
+		if sample.failed {
+			failures++
+			latencies = append(latencies, failedLatency)
+			continue
+		}
+		latencies = append(latencies, sample.latency)
+	}
+	result := ModelLatency{Model: model, Samples: len(latencies), Failures: failures}
+	if len(latencies) == 0 {
+		return result
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p50 := latencies[(len(latencies)-1)*50/100]
+	result.p95 = latencies[(len(latencies)-1)*95/100]
+	result.P50MS, result.P95MS = milliseconds(p50), milliseconds(result.p95)
+	return result
+}
+
+func milliseconds(latency time.Duration) int64 {
+	if latency == failedLatency {
+		return -1
+	}
+	return latency.Milliseconds()
+}
@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Connection pool defaults. Go's default of two idle connections per host
+// makes concurrent completions open, and then close, a connection each;
+// the provider is a single host, so most connections are kept.
+const (
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 32
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialTimeout           = 10 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultExpectContinueTimeout = time.Second
+)
+
+// TransportConfig configures how requests reach the provider
+type TransportConfig struct {
+	// ProxyURL is an http, https or socks5 proxy. Empty uses HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY from the environment.
+	ProxyURL string
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones, e.g. those of a TLS-intercepting proxy
+	CAFile string
+	// MaxIdleConns and MaxIdleConnsPerHost cap the connections kept open
+	// between requests, and MaxConnsPerHost those open at once (0 is no
+	// limit). IdleConnTimeout closes connections idle for that long.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	// DialTimeout and TLSHandshakeTimeout bound establishing a connection,
+	// and KeepAlive is the interval of TCP keep-alive probes
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	KeepAlive           time.Duration
+	// ResponseHeaderTimeout bounds the wait for a response once a request
+	// is sent; 0 waits as long as the request's context allows, which
+	// non-streamed completions of long answers need
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 speaks HTTP/1.1 only, for proxies that mishandle HTTP/2.
+	// Otherwise requests share multiplexed HTTP/2 connections.
+	DisableHTTP2 bool
+}
+
+// NewHTTPClient creates the HTTP client for provider requests. Its pool of
+// connections is meant to be shared by every request to the provider, so
+// create one per provider and keep it. Zero fields of cfg take defaults.
+func NewHTTPClient(cfg TransportConfig) (*http.Client, error) {
+	cfg = cfg.withDefaults()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	transport.ExpectContinueTimeout = defaultExpectContinueTimeout
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map is how net/http is told not to upgrade
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// Also with the custom TLS configuration below
+		transport.ForceAttemptHTTP2 = true
+	}
+	if cfg.ProxyURL != "" {
+		proxy, err := ParseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if cfg.CAFile != "" {
+		pool, err := certPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// withDefaults returns cfg with its zero pool settings and timeouts set to
+// the defaults
+func (cfg TransportConfig) withDefaults() TransportConfig {
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout == 0 {
+		cfg.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.TLSHandshakeTimeout == 0 {
+		cfg.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if cfg.KeepAlive == 0 {
+		cfg.KeepAlive = defaultKeepAlive
+	}
+	return cfg
+}
+
+// ParseProxyURL parses a proxy URL, rejecting schemes net/http cannot use
+func ParseProxyURL(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https, socks5 or socks5h", proxy.Redacted())
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: no host", proxy.Redacted())
+	}
+	return proxy, nil
+}
+
+// certPool returns the system certificate authorities plus those in file
+func certPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,151 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"spilot-agent/internal/agent"
+
+	"go.uber.org/zap"
+)
+
+var (
+	harnessOnce sync.Once
+	harness     *Harness
+	harnessErr  error
+)
+
+// TestMain closes the harness shared by the benchmarks
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if harness != nil {
+		harness.Close()
+	}
+	os.Exit(code)
+}
+
+// benchmarkHarness returns the Harness the benchmarks share, whose mock
+// LLM answers at once, starting it on first use outside the timing
+func benchmarkHarness(b *testing.B) *Harness {
+	harnessOnce.Do(func() {
+		harness, harnessErr = NewHarness(NewMockLLM(0, MockPlan(5, 1024)), zap.NewNop())
+	})
+	if harnessErr != nil {
+		b.Fatal(harnessErr)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	return harness
+}
+
+// BenchmarkParsePlan reads a plan of twenty tasks
+func BenchmarkParsePlan(b *testing.B) {
+	b.ReportAllocs()
+	plan := MockPlan(18, 1024)
+	b.SetBytes(int64(len(plan)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := agent.ParsePlan(plan); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkQueueTask queues tasks that do nothing, measuring the queue and
+// what the system does around every task
+func BenchmarkQueueTask(b *testing.B) {
+	h := benchmarkHarness(b)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			<-h.queued
+		}
+		close(done)
+	}()
+	for i := 0; i < b.N; i++ {
+		h.System.QueueTask(&agent.Task{
+			ID:        agent.NewTaskID(),
+			Type:      signalAgentType,
+			Status:    agent.TaskPending,
+			CreatedAt: time.Now(),
+		})
+	}
+	<-done
+}
+
+// BenchmarkFileCreate creates 4 KiB files through the file agent
+func BenchmarkFileCreate(b *testing.B) {
+	h := benchmarkHarness(b)
+	workspaceDir := benchmarkWorkspace(b, h, "file-create")
+	content := string(make([]byte, 4096))
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runFileTask(b, h, workspaceDir, map[string]interface{}{
+			"operation": "create",
+			"path":      filepath.Join("files", strconv.Itoa(i%1000)+".txt"),
+			"content":   content,
+		})
+	}
+}
+
+// BenchmarkFileRead reads a 64 KiB file through the file agent
+func BenchmarkFileRead(b *testing.B) {
+	h := benchmarkHarness(b)
+	workspaceDir := benchmarkWorkspace(b, h, "file-read")
+	content := make([]byte, 64<<10)
+	if err := os.WriteFile(filepath.Join(workspaceDir, "read.txt"), content, 0644); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runFileTask(b, h, workspaceDir, map[string]interface{}{"operation": "read", "path": "read.txt"})
+	}
+}
+
+// BenchmarkRequest runs a request creating five files through planning,
+// approval and execution
+func BenchmarkRequest(b *testing.B) {
+	h := benchmarkHarness(b)
+	h.LLM.Plan = MockPlan(5, 1024)
+	for i := 0; i < b.N; i++ {
+		workspaceDir := benchmarkWorkspace(b, h, "request")
+		if err := h.Request(context.Background(), "Create the load test files", workspaceDir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkWorkspace returns a new workspace for the benchmark; every run
+// of a benchmark, with a growing b.N, gets its own
+func benchmarkWorkspace(b *testing.B, h *Harness, name string) string {
+	workspaceDir, err := h.Workspace("bench-" + name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return workspaceDir
+}
+
+func runFileTask(b *testing.B, h *Harness, workspaceDir string, data map[string]interface{}) {
+	data["workspace_dir"] = workspaceDir
+	result, err := h.System.ExecuteTask(context.Background(), &agent.Task{
+		ID:        agent.NewTaskID(),
+		Type:      agent.FileAgent,
+		Data:      data,
+		Status:    agent.TaskPending,
+		CreatedAt: time.Now(),
+	})
+	if err == nil && !result.Success {
+		err = fmt.Errorf("%s", result.Error)
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,225 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/agent"
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/redact"
+
+	"go.uber.org/zap"
+)
+
+// Harness is an agent system answered by a MockLLM, working in a temporary
+// directory
+type Harness struct {
+	System *agent.System
+	LLM    *MockLLM
+	dir    string
+	// queued receives the tasks of the queue benchmark as they run
+	queued chan struct{}
+}
+
+// NewHarness starts an agent system on the configuration Load reads, with
+// its workspace and data in a temporary directory and llm in place of the
+// provider. Formatting, the codebase index, memory learning and telemetry
+// are turned off, as they would measure external tools.
+func NewHarness(llm *MockLLM, logger *zap.Logger) (*Harness, error) {
+	dir, err := os.MkdirTemp("", "spilot-loadtest-")
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range map[string]interface{}{
+		"groq_api_key":      "loadtest",
+		"workspace_dir":     filepath.Join(dir, "workspace"),
+		"data_dir":          filepath.Join(dir, "data"),
+		"format_on_write":   false,
+		"index.enabled":     false,
+		"memory_learning":   false,
+		"telemetry.enabled": false,
+	} {
+		config.Set(key, value)
+	}
+	h := &Harness{LLM: llm, dir: dir, queued: make(chan struct{}, 1024)}
+	if err := os.MkdirAll(filepath.Join(dir, "workspace"), 0755); err != nil {
+		h.Close()
+		return nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	redactor, err := redact.New(cfg.RedactPatterns)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("invalid redact_patterns: %w", err)
+	}
+	if h.System, err = agent.NewSystem(llm, cfg, redactor, logger); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to initialize agent system: %w", err)
+	}
+	if err := h.System.RegisterAgent(signalAgent{done: h.queued}); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close shuts the system down and removes the temporary directory
+func (h *Harness) Close() {
+	if h.System != nil {
+		h.System.Shutdown()
+	}
+	os.RemoveAll(h.dir)
+}
+
+// Workspace returns a new empty workspace whose name starts with prefix
+func (h *Harness) Workspace(prefix string) (string, error) {
+	return os.MkdirTemp(filepath.Join(h.dir, "workspace"), prefix+"-")
+}
+
+// Request runs a request through the whole pipeline, as a client of the
+// API would: it is planned, the plan approved, and its tasks executed in
+// workspaceDir
+func (h *Harness) Request(ctx context.Context, request, workspaceDir string) error {
+	result, err := h.System.ProcessUserRequest(ctx, request, workspaceDir)
+	if err != nil {
+		return err
+	}
+	plan := result.Plan()
+	if plan == nil || plan.Plan == "" || plan.ApprovalID == "" {
+		return errors.New("the request was not planned")
+	}
+	_, token, _, err := h.System.DecideApproval(plan.ApprovalID, true)
+	if err != nil {
+		return err
+	}
+	results, err := h.System.ExecutePlan(ctx, plan.Plan, token, workspaceDir)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result == nil || !result.Success {
+			return fmt.Errorf("a task of the plan failed: %s", result.Error)
+		}
+	}
+	return nil
+}
+
+// Options shape a load test
+type Options struct {
+	// Requests is how many requests are made, Concurrency how many at once
+	Requests    int
+	Concurrency int
+}
+
+// Report is the outcome of a load test. Latencies are of whole requests.
+type Report struct {
+	Requests int
+	Failed   int
+	// FirstError is the error of the first request that failed
+	FirstError string
+	LLMCalls   int64
+	Elapsed    time.Duration
+	P50        time.Duration
+	P95        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// Throughput returns the requests completed per second
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests-r.Failed) / r.Elapsed.Seconds()
+}
+
+// Run makes opts.Requests requests through the pipeline, each in its own
+// workspace, opts.Concurrency at a time
+func (h *Harness) Run(ctx context.Context, opts Options) Report {
+	concurrency := max(opts.Concurrency, 1)
+	latencies := make([]time.Duration, opts.Requests)
+	errs := make([]error, opts.Requests)
+	calls := h.LLM.Calls()
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := time.Now()
+				workspaceDir, err := h.Workspace("request")
+				if err == nil {
+					err = h.Request(ctx, "Create the load test files", workspaceDir)
+				}
+				latencies[i], errs[i] = time.Since(start), err
+			}
+		}()
+	}
+	for i := 0; i < opts.Requests && ctx.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	report := Report{Requests: opts.Requests, Elapsed: time.Since(started), LLMCalls: h.LLM.Calls() - calls}
+	var done []time.Duration
+	for i, err := range errs {
+		switch {
+		case err != nil:
+			report.Failed++
+			if report.FirstError == "" {
+				report.FirstError = err.Error()
+			}
+		case latencies[i] == 0:
+			// Not made before ctx was done
+			report.Failed++
+		default:
+			done = append(done, latencies[i])
+		}
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i] < done[j] })
+	if len(done) > 0 {
+		report.P50 = percentile(done, 50)
+		report.P95 = percentile(done, 95)
+		report.P99 = percentile(done, 99)
+		report.Max = done[len(done)-1]
+	}
+	return report
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// signalAgentType is the type of the tasks of the queue benchmark
+const signalAgentType agent.AgentType = "loadtest"
+
+// signalAgent does nothing but signal that it ran, so the benchmark of the
+// task queue knows when its tasks are done
+type signalAgent struct {
+	done chan<- struct{}
+}
+
+func (a signalAgent) Type() agent.AgentType {
+	return signalAgentType
+}
+
+func (a signalAgent) Execute(ctx context.Context, task *agent.Task) (*agent.TaskResult, error) {
+	a.done <- struct{}{}
+	return &agent.TaskResult{Success: true}, nil
+}
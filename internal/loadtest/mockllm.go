@@ -0,0 +1,144 @@
+// Package loadtest measures the task pipeline without a provider: a mock
+// LLM answers every call after a fixed latency, so what is measured is the
+// agent's own work of planning, queueing, executing tasks and touching
+// files. cmd/loadtest runs its benchmarks and load tests.
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// planPrompt marks the prompts of the planning agent
+const planPrompt = "Generate a JSON array of tasks"
+
+// MockLLM is an agent.LLMClient answering like the provider, after Latency,
+// without calling it. Planning prompts are answered with Plan.
+type MockLLM struct {
+	// Latency is how long each call takes
+	Latency time.Duration
+	// Plan is the answer to planning prompts, e.g. from MockPlan
+	Plan string
+
+	mu    sync.Mutex
+	model string
+	calls atomic.Int64
+}
+
+// NewMockLLM creates a mock answering planning prompts with plan
+func NewMockLLM(latency time.Duration, plan string) *MockLLM {
+	return &MockLLM{Latency: latency, Plan: plan, model: "mock"}
+}
+
+// Calls returns how many calls the mock answered
+func (m *MockLLM) Calls() int64 {
+	return m.calls.Load()
+}
+
+// answer waits for Latency, or until ctx is done, and returns text
+func (m *MockLLM) answer(ctx context.Context, text string) (string, error) {
+	m.calls.Add(1)
+	if m.Latency > 0 {
+		timer := time.NewTimer(m.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return text, nil
+}
+
+// Chat answers planning prompts with the plan and others with a sentence
+func (m *MockLLM) Chat(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+	for _, message := range messages {
+		if strings.Contains(message.Content, planPrompt) {
+			return m.answer(ctx, m.Plan)
+		}
+	}
+	return m.answer(ctx, "Done.")
+}
+
+// ClassifyIntent classifies every request as general, so it is planned
+func (m *MockLLM) ClassifyIntent(ctx context.Context, request string) (string, error) {
+	return m.answer(ctx, "GENERAL")
+}
+
+// AnalyzeError returns an analysis without a fix
+func (m *MockLLM) AnalyzeError(ctx context.Context, errorOutput, fileContent string) (string, error) {
+	return m.answer(ctx, `{"analysis": "The error is expected under load testing.", "fix": ""}`)
+}
+
+// GenerateCommand returns a command doing nothing
+func (m *MockLLM) GenerateCommand(ctx context.Context, instruction, shell string) (string, error) {
+	return m.answer(ctx, "true")
+}
+
+// PlanProject returns a project of one file
+func (m *MockLLM) PlanProject(ctx context.Context, description string) (string, error) {
+	return m.answer(ctx, `{"name": "loadtest", "description": "Load test project", "files": [{"path": "README.md", "content": ""}]}`)
+}
+
+// GenerateCode returns a comment
+func (m *MockLLM) GenerateCode(ctx context.Context, requirements, context string) (string, error) {
+	return m.answer(ctx, "// Generated under load testing\n")
+}
+
+// SetModel sets the model GetModel returns
+func (m *MockLLM) SetModel(model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.model = model
+}
+
+// GetModel returns the model set last
+func (m *MockLLM) GetModel() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.model
+}
+
+// MockPlan returns a plan creating files files of size bytes under
+// loadtest/, then updating and reading the first, as the planning agent
+// would answer it: in a code fence after a sentence
+func MockPlan(files, size int) string {
+	type task struct {
+		Type        string                 `json:"type"`
+		Description string                 `json:"description"`
+		Data        map[string]interface{} `json:"data"`
+	}
+	content := strings.Repeat("load test line\n", size/15+1)[:size]
+	var tasks []task
+	for i := 0; i < files; i++ {
+		path := fmt.Sprintf("loadtest/file-%d.txt", i)
+		tasks = append(tasks, task{
+			Type:        "file",
+			Description: "Create " + path,
+			Data:        map[string]interface{}{"operation": "create", "path": path, "content": content},
+		})
+	}
+	if files > 0 {
+		tasks = append(tasks,
+			task{
+				Type:        "file",
+				Description: "Update loadtest/file-0.txt",
+				Data:        map[string]interface{}{"operation": "update", "path": "loadtest/file-0.txt", "content": "updated\n" + content},
+			},
+			task{
+				Type:        "file",
+				Description: "Read loadtest/file-0.txt",
+				Data:        map[string]interface{}{"operation": "read", "path": "loadtest/file-0.txt"},
+			},
+		)
+	}
+	data, _ := json.MarshalIndent(tasks, "", "  ")
+	return "Here is the plan:\n```json\n" + string(data) + "\n```"
+}
@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxDiscordContent is the longest message Discord accepts
+const maxDiscordContent = 2000
+
+// discord posts to a Discord channel's webhook
+type discord struct {
+	url    string
+	client *http.Client
+}
+
+func newDiscord(url string) *discord {
+	return &discord{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (d *discord) Send(ctx context.Context, msg Message) error {
+	content := []rune("**" + msg.Subject + "**\n" + msg.Text)
+	if len(content) > maxDiscordContent {
+		content = append(content[:maxDiscordContent-1], '…')
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"content": string(content),
+		// Task output must not ping anyone
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// The webhook URL holds its token; keep it out of logs
+		return urlErr.Err
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("discord webhook responded %s", resp.Status)
+	}
+	return nil
+}
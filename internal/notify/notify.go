@@ -0,0 +1,60 @@
+// Package notify sends short messages to people over notification
+// channels: email through an SMTP server, or a Discord channel's webhook.
+package notify
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a notification
+type Message struct {
+	// Subject is a one-line summary, such as an email's subject
+	Subject string
+	// Text is the plain-text body
+	Text string
+}
+
+// Channel delivers messages
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config describes a channel. The fields of other types are ignored.
+type Config struct {
+	// Type is "email" or "discord"
+	Type string
+
+	// WebhookURL is the Discord channel's webhook
+	WebhookURL string
+
+	// SMTPHost and SMTPPort are the mail server's; port 465 uses implicit
+	// TLS, other ports STARTTLS when the server offers it
+	SMTPHost string
+	SMTPPort int
+	// Username and Password authenticate with PLAIN auth if set
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// New returns the channel cfg describes
+func New(cfg Config) (Channel, error) {
+	switch cfg.Type {
+	case "email":
+		if cfg.SMTPHost == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("email needs smtp_host, from and to")
+		}
+		if cfg.SMTPPort == 0 {
+			cfg.SMTPPort = 587
+		}
+		return &email{cfg: cfg}, nil
+	case "discord":
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("discord needs webhook_url")
+		}
+		return newDiscord(cfg.WebhookURL), nil
+	}
+	return nil, fmt.Errorf("unknown notification channel type %q; use email or discord", cfg.Type)
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsGroup is the queue group workers consume in, so each message goes
+// to one of them
+const natsGroup = "spilot-workers"
+
+// natsAckTimeout bounds waiting for a worker to take a message
+const natsAckTimeout = 5 * time.Second
+
+// natsBroker uses core NATS. Messages are not persisted: a message is
+// enqueued as a request the consumer acknowledges on receipt, so enqueueing
+// with no worker connected fails with ErrNoConsumers instead of being lost.
+type natsBroker struct {
+	conn *nats.Conn
+}
+
+func newNATS(url string) (*natsBroker, error) {
+	conn, err := nats.Connect(url, nats.Name("spilot"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsBroker{conn: conn}, nil
+}
+
+func (b *natsBroker) Enqueue(ctx context.Context, queue string, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, natsAckTimeout)
+	defer cancel()
+	_, err := b.conn.RequestWithContext(ctx, queue, msg)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return ErrNoConsumers
+	}
+	return err
+}
+
+func (b *natsBroker) Consume(ctx context.Context, queue string, handle func([]byte)) error {
+	sub, err := b.conn.QueueSubscribeSync(queue, natsGroup)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		msg.Respond(nil)
+		handle(msg.Data)
+	}
+}
+
+func (b *natsBroker) Publish(ctx context.Context, topic string, msg []byte) error {
+	return b.conn.Publish(topic, msg)
+}
+
+func (b *natsBroker) Subscribe(topic string, handle func([]byte)) (func(), error) {
+	sub, err := b.conn.Subscribe(topic, func(msg *nats.Msg) { handle(msg.Data) })
+	if err != nil {
+		return nil, err
+	}
+	return func() { sub.Unsubscribe() }, nil
+}
+
+func (b *natsBroker) Close() error {
+	return b.conn.Drain()
+}
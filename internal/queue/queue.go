@@ -0,0 +1,48 @@
+// Package queue carries tasks between the server and remote workers over
+// a message broker, NATS or Redis. Work queues hand each message to one
+// consumer; topics fan messages out to every subscriber.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoConsumers is returned when a message is enqueued on a queue no
+// worker consumes, for brokers that can tell
+var ErrNoConsumers = errors.New("no worker consumes the queue")
+
+// Broker is a message broker
+type Broker interface {
+	// Enqueue hands msg to one of the consumers of queue
+	Enqueue(ctx context.Context, queue string, msg []byte) error
+	// Consume calls handle with the messages of queue, one at a time,
+	// until ctx is done
+	Consume(ctx context.Context, queue string, handle func([]byte)) error
+	// Publish sends msg to every subscriber of topic
+	Publish(ctx context.Context, topic string, msg []byte) error
+	// Subscribe calls handle with the messages published on topic until
+	// the returned function is called
+	Subscribe(topic string, handle func([]byte)) (func(), error)
+	Close() error
+}
+
+// Config selects and configures the broker
+type Config struct {
+	// Driver is "nats" or "redis"
+	Driver string
+	// URL is a nats:// or redis:// URL
+	URL string
+}
+
+// New connects to the broker cfg describes
+func New(cfg Config) (Broker, error) {
+	switch cfg.Driver {
+	case "nats":
+		return newNATS(cfg.URL)
+	case "redis":
+		return newRedis(cfg.URL)
+	}
+	return nil, fmt.Errorf("unknown queue driver %q; use nats or redis", cfg.Driver)
+}
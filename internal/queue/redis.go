@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPollTimeout bounds each blocking pop, so consumers notice when
+// they are stopped
+const redisPollTimeout = 5 * time.Second
+
+// redisBroker keeps work queues in Redis lists, so messages wait for a
+// worker to connect, and publishes topics with Redis pub/sub
+type redisBroker struct {
+	client *redis.Client
+}
+
+func newRedis(url string) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisPollTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisBroker{client: client}, nil
+}
+
+func (b *redisBroker) Enqueue(ctx context.Context, queue string, msg []byte) error {
+	return b.client.LPush(ctx, queue, msg).Err()
+}
+
+func (b *redisBroker) Consume(ctx context.Context, queue string, handle func([]byte)) error {
+	for ctx.Err() == nil {
+		popped, err := b.client.BRPop(ctx, redisPollTimeout, queue).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		// BRPOP answers the list's name, then the message
+		handle([]byte(popped[1]))
+	}
+	return nil
+}
+
+func (b *redisBroker) Publish(ctx context.Context, topic string, msg []byte) error {
+	return b.client.Publish(ctx, topic, msg).Err()
+}
+
+func (b *redisBroker) Subscribe(topic string, handle func([]byte)) (func(), error) {
+	sub := b.client.Subscribe(context.Background(), topic)
+	// Wait for the subscription, so nothing published after Subscribe
+	// returns is missed
+	if _, err := sub.Receive(context.Background()); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			handle([]byte(msg.Payload))
+		}
+	}()
+	return func() { sub.Close() }, nil
+}
+
+func (b *redisBroker) Close() error {
+	return b.client.Close()
+}
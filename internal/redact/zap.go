@@ -0,0 +1,55 @@
+package redact
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core wraps a logger core so that messages and string and error fields
+// are redacted before they are written. Use it with zap.WrapCore.
+func Core(core zapcore.Core, r *Redactor) zapcore.Core {
+	return &redactingCore{Core: core, redactor: r}
+}
+
+type redactingCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.fields(fields)), redactor: c.redactor}
+}
+
+// Check asks the wrapped core, which may sample, whether to log the entry,
+// then makes sure the entry is written through this core
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Check(entry, nil) == nil {
+		return checked
+	}
+	return checked.AddCore(entry, c)
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.redactor.String(entry.Message)
+	return c.Core.Write(entry, c.fields(fields))
+}
+
+func (c *redactingCore) fields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = c.redactor.String(field.String)
+		case zapcore.ByteStringType:
+			if b, ok := field.Interface.([]byte); ok {
+				field.Interface = []byte(c.redactor.String(string(b)))
+			}
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok && err != nil {
+				field = zap.String(field.Key, c.redactor.String(err.Error()))
+			}
+		}
+		redacted[i] = field
+	}
+	return redacted
+}
@@ -0,0 +1,248 @@
+// Package secrets resolves references to secrets kept outside the
+// configuration: in files such as mounted Kubernetes or Docker secrets, in
+// HashiCorp Vault or in AWS Secrets Manager.
+//
+// References look like
+//
+//	file:///run/secrets/groq_api_key
+//	file:///run/secrets/app.json#groq_api_key
+//	vault://secret/data/spilot#groq_api_key
+//	awssm://prod/spilot#groq_api_key
+//
+// The part after # selects a field of a JSON secret; without it the whole
+// secret is used.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reference schemes
+const (
+	schemeFile  = "file://"
+	schemeVault = "vault://"
+	schemeAWS   = "awssm://"
+)
+
+// Config locates the secret backends. Empty fields fall back to the
+// standard environment variables (VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE,
+// AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN).
+type Config struct {
+	VaultAddr      string
+	VaultToken     string
+	VaultNamespace string
+	AWSRegion      string
+}
+
+// Resolver resolves secret references
+type Resolver struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewResolver creates a resolver for the backends in cfg
+func NewResolver(cfg Config) *Resolver {
+	cfg.VaultAddr = firstNonEmpty(cfg.VaultAddr, os.Getenv("VAULT_ADDR"))
+	cfg.VaultToken = firstNonEmpty(cfg.VaultToken, os.Getenv("VAULT_TOKEN"))
+	cfg.VaultNamespace = firstNonEmpty(cfg.VaultNamespace, os.Getenv("VAULT_NAMESPACE"))
+	cfg.AWSRegion = firstNonEmpty(cfg.AWSRegion, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	return &Resolver{cfg: cfg, http: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// IsReference reports whether value refers to a secret
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, schemeFile) ||
+		strings.HasPrefix(value, schemeVault) ||
+		strings.HasPrefix(value, schemeAWS)
+}
+
+// Resolve returns the secret value refers to
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	location, field, _ := strings.Cut(ref, "#")
+	var secret string
+	var err error
+	switch {
+	case strings.HasPrefix(location, schemeFile):
+		secret, err = readFile(strings.TrimPrefix(location, schemeFile))
+	case strings.HasPrefix(location, schemeVault):
+		secret, err = r.vault(ctx, strings.TrimPrefix(location, schemeVault), field)
+		field = ""
+	case strings.HasPrefix(location, schemeAWS):
+		secret, err = r.aws(ctx, strings.TrimPrefix(location, schemeAWS))
+	default:
+		return "", fmt.Errorf("not a secret reference: %s", ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", location, err)
+	}
+	if field == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("%s: secret is not a JSON object, so it has no field %q", location, field)
+	}
+	return stringField(fields, field, location)
+}
+
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// vault reads a field of a secret from Vault's KV engine, version 1 or 2
+func (r *Resolver) vault(ctx context.Context, path, field string) (string, error) {
+	if r.cfg.VaultAddr == "" || r.cfg.VaultToken == "" {
+		return "", fmt.Errorf("vault address and token are not configured (secrets.vault_addr/vault_token or VAULT_ADDR/VAULT_TOKEN)")
+	}
+	if field == "" {
+		return "", fmt.Errorf("vault references need a #field")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(r.cfg.VaultAddr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.cfg.VaultToken)
+	if r.cfg.VaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.cfg.VaultNamespace)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := r.do(req, &body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	// KV version 2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	return stringField(data, field, "secret")
+}
+
+// aws reads a secret string from AWS Secrets Manager
+func (r *Resolver) aws(ctx context.Context, id string) (string, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if r.cfg.AWSRegion == "" || accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS region and credentials are not configured (secrets.aws_region or AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	}
+	payload, _ := json.Marshal(map[string]string{"SecretId": id})
+	host := "secretsmanager." + r.cfg.AWSRegion + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWS(req, payload, host, r.cfg.AWSRegion, "secretsmanager", accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), time.Now().UTC())
+
+	var body struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := r.do(req, &body); err != nil {
+		return "", err
+	}
+	if body.SecretString == nil {
+		return "", fmt.Errorf("binary secrets are not supported")
+	}
+	return *body.SecretString, nil
+}
+
+// signAWS signs a request with AWS Signature Version 4
+func signAWS(req *http.Request, payload []byte, host, region, service, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(req.Header.Get(name)))
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+	// net/http sends Host from the URL, not the header map
+	req.Header.Del("Host")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// do sends req and decodes its JSON response into v
+func (r *Resolver) do(req *http.Request, v interface{}) error {
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func stringField(fields map[string]interface{}, field, location string) (string, error) {
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %q", location, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxRequestIDLength bounds request IDs supplied by clients
+const maxRequestIDLength = 128
+
+// probePaths are the health check endpoints
+var probePaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true}
+
+// Webhook deliveries are received at these paths
+const (
+	githubWebhookPath = "/api/github/webhook"
+	gitlabWebhookPath = "/api/gitlab/webhook"
+)
+
+// accessEntry collects what a request's access log line reports beyond the
+// request itself
+type accessEntry struct {
+	requestID string
+	taskID    string
+}
+
+type accessEntryKey struct{}
+
+// noteTaskID records the task a request runs, for its access log line
+func noteTaskID(ctx context.Context, taskID string) {
+	if entry, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.taskID = taskID
+	}
+}
+
+// accessLogMiddleware logs each request's method, path, status, latency,
+// request ID and task ID. The request ID comes from the X-Request-ID
+// header or is generated, and is returned in the same header; the task ID
+// is returned in X-Task-ID. The logger redacts secrets in the query.
+func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{requestID: r.Header.Get("X-Request-ID")}
+		if entry.requestID == "" || len(entry.requestID) > maxRequestIDLength {
+			entry.requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", entry.requestID)
+		recorder := &statusRecorder{ResponseWriter: w, entry: entry}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", recorder.statusCode()),
+			zap.Duration("latency", time.Since(start)),
+			zap.Int64("bytes", recorder.bytes),
+			zap.String("request_id", entry.requestID),
+			zap.String("requester", requester(r)),
+		}
+		if r.URL.RawQuery != "" {
+			fields = append(fields, zap.String("query", r.URL.RawQuery))
+		}
+		if entry.taskID != "" {
+			fields = append(fields, zap.String("task_id", entry.taskID))
+		}
+		// Passing health probes arrive every few seconds; log them only
+		// when debugging
+		if probePaths[r.URL.Path] && recorder.statusCode() < 400 {
+			s.logger.Debug("HTTP request", fields...)
+			return
+		}
+		s.logger.Info("HTTP request", fields...)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "req_" + hex.EncodeToString(b)
+}
+
+// statusRecorder records the status and size of a response. It keeps the
+// streaming and WebSocket endpoints working by passing through Flush and
+// Hijack.
+type statusRecorder struct {
+	http.ResponseWriter
+	// entry, if set, gets the task ID sent in X-Task-ID
+	entry    *accessEntry
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		if r.entry != nil && r.entry.taskID != "" {
+			r.Header().Set("X-Task-ID", r.entry.taskID)
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	r.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) statusCode() int {
+	switch {
+	case r.hijacked:
+		return http.StatusSwitchingProtocols
+	case r.status == 0:
+		return http.StatusOK
+	default:
+		return r.status
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"spilot-agent/internal/agent"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AdminOptions configure the admin API, which is served apart from the
+// agent API
+type AdminOptions struct {
+	// Listen is a TCP address such as 127.0.0.1:9090, or unix:<path> for a
+	// Unix socket only the server's user can connect to
+	Listen string
+	// Token, when set, must be sent as "Authorization: Bearer <token>"
+	Token string
+	// Reload re-reads the configuration, returning the keys applied and
+	// the keys that need a restart
+	Reload func() (applied, restart []string, err error)
+}
+
+// StartAdmin serves the admin API until Shutdown
+func (s *Server) StartAdmin() error {
+	listener, err := adminListener(s.options.Admin.Listen)
+	if err != nil {
+		return err
+	}
+	s.admin = &http.Server{
+		Handler:      s.setupAdminRoutes(),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 5 * time.Minute,
+		IdleTimeout:  60 * time.Second,
+	}
+	s.logger.Info("Starting admin server", zap.String("listen", s.options.Admin.Listen))
+	return s.admin.Serve(listener)
+}
+
+// adminListener listens on a TCP address or, for unix:<path>, on a socket
+// readable and writable by the owner only
+func adminListener(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	// A socket left by an earlier run would make the listen fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// setupAdminRoutes sets up the admin API routes
+func (s *Server) setupAdminRoutes() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/log-level", s.handleLogLevel).Methods("GET", "PUT")
+	router.HandleFunc("/admin/compare-models", s.handleCompareModels).Methods("POST")
+	router.HandleFunc("/admin/reload", s.handleReload).Methods("POST")
+	router.HandleFunc("/admin/metrics", s.handleMetrics).Methods("GET")
+	router.HandleFunc("/admin/api-keys", s.handleListAPIKeys).Methods("GET")
+	router.HandleFunc("/admin/api-keys", s.handleCreateAPIKey).Methods("POST")
+	router.HandleFunc("/admin/api-keys/{id}", s.handleGetAPIKey).Methods("GET")
+	router.HandleFunc("/admin/api-keys/{id}", s.handleUpdateAPIKey).Methods("PATCH")
+	router.HandleFunc("/admin/api-keys/{id}", s.handleDeleteAPIKey).Methods("DELETE")
+
+	if s.options.AccessLog {
+		router.Use(s.accessLogMiddleware)
+	}
+	router.Use(s.adminAuthMiddleware)
+	return router
+}
+
+// adminAuthMiddleware rejects requests without the admin token or an API
+// key with the admin scope, if a token is configured
+func (s *Server) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := s.options.Admin.Token; token != "" {
+			given := apiKeyFromRequest(r)
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				key, err := s.agentSystem.APIKeys().Authenticate(given)
+				if err != nil || !key.Allows(agent.ScopeAdmin) {
+					s.sendError(w, "admin token or API key with the admin scope required", http.StatusUnauthorized)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleReload re-reads the configuration file, as SIGHUP does
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.options.Admin.Reload == nil {
+		s.sendError(w, "reloading is not available", http.StatusNotImplemented)
+		return
+	}
+	applied, restart, err := s.options.Admin.Reload()
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"applied": applied, "restart_required": restart},
+	})
+}
+
+// handleMetrics reports process and agent counters
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	pending := 0
+	for _, approval := range s.agentSystem.Approvals().List() {
+		if approval.Status == agent.ApprovalPending {
+			pending++
+		}
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"uptime_seconds":    int64(time.Since(s.started).Seconds()),
+			"goroutines":        runtime.NumGoroutine(),
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"gc_runs":           mem.NumGC,
+			"processes":         len(s.agentSystem.Processes().List()),
+			"pty_sessions":      len(s.agentSystem.PTYs().List()),
+			"pending_approvals": pending,
+			"log_level":         s.options.LogLevel.Level().String(),
+		},
+	})
+}
+
+// handleLogLevel reports the log level or, for PUT with {"level": "debug"},
+// changes it until the process restarts or log_level changes in the
+// configuration
+func (s *Server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		level, err := zapcore.ParseLevel(req.Level)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if previous := s.options.LogLevel.Level(); previous != level {
+			// Logged before the change so raising the level does not hide it
+			s.logger.Info("Changing log level", zap.Stringer("from", previous), zap.Stringer("to", level))
+			s.options.LogLevel.SetLevel(level)
+		}
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"level": s.options.LogLevel.Level().String()},
+	})
+}
+
+// maxCompareRuns bounds the runs of one model comparison, since every run
+// calls each model once per prompt
+const maxCompareRuns = 10
+
+// handleCompareModels runs the standard prompt suite against models and
+// reports their latency, throughput and JSON validity. The body may give
+// "models" (default: the configured ones) and "runs" (default 1).
+func (s *Server) handleCompareModels(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Models []string `json:"models"`
+		Runs   int      `json:"runs"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Runs < 0 || req.Runs > maxCompareRuns {
+		s.sendError(w, fmt.Sprintf("runs must be between 1 and %d", maxCompareRuns), http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.agentSystem.CompareModels(r.Context(), req.Models, req.Runs)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"models": results},
+	})
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"spilot-agent/internal/agent"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyFromRequest returns the API key sent as "Authorization: Bearer"
+// or in the X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key
+}
+
+// apiKeyMiddleware authenticates API keys and limits requests to the
+// chat scope every use of the agent API needs. Requests without a key are
+// refused when keys are required; health probes are always let through, as
+// are GitHub and GitLab webhooks, which carry a signature or token instead,
+// and the UI's page and assets, whose API calls carry the key. While users
+// are isolated the X-Spilot-User header is dropped, so tenants are only ever
+// who their key says they are.
+func (s *Server) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.agentSystem.Tenancy() != nil {
+			r.Header.Del("X-Spilot-User")
+		}
+		if probePaths[r.URL.Path] || r.Method == http.MethodOptions ||
+			s.webhooks()[r.URL.Path] != nil || (s.options.UI && isUIPath(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := apiKeyFromRequest(r)
+		if secret == "" {
+			if s.options.APIKeysRequired {
+				s.sendError(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := s.agentSystem.APIKeys().Authenticate(secret)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := agent.ContextWithAPIKey(r.Context(), key)
+		if err := agent.RequireScope(ctx, agent.ScopeChat); err != nil {
+			s.sendError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiKeyRequest is the body of key creation and update requests
+type apiKeyRequest struct {
+	Name   *string  `json:"name"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	// TTL is a duration such as "720h"; ExpiresAt an RFC 3339 time, or ""
+	// to remove the expiry
+	TTL       string  `json:"ttl"`
+	ExpiresAt *string `json:"expires_at"`
+}
+
+func (req apiKeyRequest) scopes() ([]agent.APIKeyScope, error) {
+	if req.Scopes == nil {
+		return nil, nil
+	}
+	scopes := make([]agent.APIKeyScope, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		scope, err := agent.ParseAPIKeyScope(name)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// handleListAPIKeys lists API keys, without the keys themselves
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"keys": s.agentSystem.APIKeys().List()},
+	})
+}
+
+// handleCreateAPIKey creates a key from {"name", "owner", "scopes", "ttl"}.
+// The response is the only time the key is shown.
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	scopes, err := req.scopes()
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			s.sendError(w, "ttl must be a positive duration such as 720h", http.StatusBadRequest)
+			return
+		}
+	}
+	name := ""
+	if req.Name != nil {
+		name = *req.Name
+	}
+	key, secret, err := s.agentSystem.APIKeys().Create(name, req.Owner, scopes, ttl)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    map[string]interface{}{"key": key, "secret": secret},
+	})
+}
+
+// handleGetAPIKey describes one API key
+func (s *Server) handleGetAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.agentSystem.APIKeys().Get(mux.Vars(r)["id"])
+	if !ok {
+		s.sendError(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"key": key}})
+}
+
+// handleUpdateAPIKey changes the name, scopes or expiry of a key
+func (s *Server) handleUpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	scopes, err := req.scopes()
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	update := agent.APIKeyUpdate{Name: req.Name, Scopes: scopes}
+	if req.ExpiresAt != nil {
+		var expires time.Time
+		if *req.ExpiresAt != "" {
+			if expires, err = time.Parse(time.RFC3339, *req.ExpiresAt); err != nil {
+				s.sendError(w, "expires_at must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+		update.ExpiresAt = &expires
+	}
+	id := mux.Vars(r)["id"]
+	if _, ok := s.agentSystem.APIKeys().Get(id); !ok {
+		s.sendError(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	key, err := s.agentSystem.APIKeys().Update(id, update)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"key": key}})
+}
+
+// handleDeleteAPIKey revokes a key
+func (s *Server) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.agentSystem.APIKeys().Delete(id); err != nil {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"id": id, "deleted": true}})
+}
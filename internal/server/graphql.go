@@ -0,0 +1,641 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"spilot-agent/internal/agent"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"go.uber.org/zap"
+)
+
+// maxGraphQLBodyBytes bounds a GraphQL request
+const maxGraphQLBodyBytes = 1 << 20
+
+// graphQLRequest is a GraphQL operation, as a POST body or, for queries,
+// GET parameters
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// handleGraphQL runs GraphQL queries over tasks, sessions, workspace files
+// and usage. A WebSocket upgrade serves subscriptions to task events with
+// the graphql-transport-ws protocol.
+func (s *Server) handleGraphQL(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			s.serveGraphQLSubscriptions(schema, w, r)
+			return
+		}
+		var req graphQLRequest
+		if r.Method == http.MethodGet {
+			query := r.URL.Query()
+			req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+			if variables := query.Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					s.sendError(w, "Invalid variables", http.StatusBadRequest)
+					return
+				}
+			}
+		} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBodyBytes)).Decode(&req); err != nil {
+			s.sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        callerContext(r),
+		})
+		// GraphQL reports errors in the result, as clients expect
+		s.sendJSON(w, result)
+	}
+}
+
+// graphQLMessage is a message of the graphql-transport-ws protocol
+type graphQLMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// serveGraphQLSubscriptions runs the operations a client subscribes to,
+// each until it completes or the client stops it or disconnects
+func (s *Server) serveGraphQLSubscriptions(schema graphql.Schema, w http.ResponseWriter, r *http.Request) {
+	upgrader := upgrader
+	upgrader.Subprotocols = []string{"graphql-transport-ws"}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warn("Failed to upgrade GraphQL connection", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(callerContext(r))
+	defer cancel()
+	var writeMu sync.Mutex
+	send := func(message graphQLMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteJSON(message)
+	}
+	var mu sync.Mutex
+	operations := make(map[string]context.CancelFunc)
+
+	for {
+		var message graphQLMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		switch message.Type {
+		case "connection_init":
+			send(graphQLMessage{Type: "connection_ack"})
+		case "ping":
+			send(graphQLMessage{Type: "pong"})
+		case "subscribe":
+			var req graphQLRequest
+			if err := json.Unmarshal(message.Payload, &req); err != nil || message.ID == "" {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4400, "invalid subscribe message"), time.Now().Add(time.Second))
+				return
+			}
+			mu.Lock()
+			if _, running := operations[message.ID]; running {
+				mu.Unlock()
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4409, "subscriber for "+message.ID+" already exists"), time.Now().Add(time.Second))
+				return
+			}
+			opCtx, stop := context.WithCancel(ctx)
+			operations[message.ID] = stop
+			mu.Unlock()
+
+			go func(id string) {
+				defer func() {
+					mu.Lock()
+					delete(operations, id)
+					mu.Unlock()
+					stop()
+				}()
+				results := graphql.Subscribe(graphql.Params{
+					Schema:         schema,
+					RequestString:  req.Query,
+					OperationName:  req.OperationName,
+					VariableValues: req.Variables,
+					Context:        opCtx,
+				})
+				for result := range results {
+					payload, _ := json.Marshal(result)
+					send(graphQLMessage{ID: id, Type: "next", Payload: payload})
+				}
+				if opCtx.Err() == nil {
+					send(graphQLMessage{ID: id, Type: "complete"})
+				}
+			}(message.ID)
+		case "complete":
+			mu.Lock()
+			if stop, ok := operations[message.ID]; ok {
+				stop()
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// jsonScalar passes any JSON value through, for free-form task data
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:         "JSON",
+	Description:  "Any JSON value",
+	Serialize:    func(value interface{}) interface{} { return value },
+	ParseValue:   func(value interface{}) interface{} { return value },
+	ParseLiteral: func(value ast.Value) interface{} { return value.GetValue() },
+})
+
+// graphQLTask is a task as the GraphQL API shows it. What it did is read
+// from its transcript, once.
+type graphQLTask struct {
+	ID          string
+	Agent       string
+	Description string
+	Requester   string
+	Workspace   string
+	CreatedAt   time.Time
+
+	once       sync.Once
+	transcript *agent.TaskTranscript
+}
+
+func (t *graphQLTask) record(ctx context.Context, system *agent.System) *agent.TaskTranscript {
+	t.once.Do(func() {
+		if transcript, err := system.TaskTranscript(ctx, t.ID); err == nil {
+			t.transcript = &transcript.Tasks[0]
+		}
+	})
+	return t.transcript
+}
+
+func taskFromEvent(event *agent.ActionEvent) *graphQLTask {
+	agentType, _ := event.Data["agent"].(string)
+	description, _ := event.Data["description"].(string)
+	return &graphQLTask{
+		ID:          event.TaskID,
+		Agent:       agentType,
+		Description: description,
+		Requester:   event.Requester,
+		Workspace:   event.Workspace,
+		CreatedAt:   event.Timestamp,
+	}
+}
+
+// graphQLSchema builds the schema of the GraphQL API. It only reads; work
+// is started through the REST routes.
+func (s *Server) graphQLSchema() (graphql.Schema, error) {
+	usageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Usage",
+		Fields: graphql.Fields{
+			"calls":            &graphql.Field{Type: graphql.Int},
+			"promptTokens":     &graphql.Field{Type: graphql.Int},
+			"completionTokens": &graphql.Field{Type: graphql.Int},
+			"totalTokens":      &graphql.Field{Type: graphql.Int},
+			"cachedTokens":     &graphql.Field{Type: graphql.Int},
+			"cost":             &graphql.Field{Type: graphql.Float},
+			"cacheSavings":     &graphql.Field{Type: graphql.Float},
+		},
+	})
+	usageGroupType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UsageGroup",
+		Fields: graphql.Fields{
+			"key":   &graphql.Field{Type: graphql.String},
+			"usage": &graphql.Field{Type: usageType},
+		},
+	})
+	usageReportType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UsageReport",
+		Fields: graphql.Fields{
+			"total":   &graphql.Field{Type: usageType},
+			"groupBy": &graphql.Field{Type: graphql.String},
+			"groups": &graphql.Field{
+				Type: graphql.NewList(usageGroupType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					report := p.Source.(*agent.UsageReport)
+					groups := make([]map[string]interface{}, 0, len(report.Groups))
+					for key, usage := range report.Groups {
+						groups = append(groups, map[string]interface{}{"key": key, "usage": usage})
+					}
+					sort.Slice(groups, func(i, j int) bool { return groups[i]["key"].(string) < groups[j]["key"].(string) })
+					return groups, nil
+				},
+			},
+		},
+	})
+	resultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskResult",
+		Fields: graphql.Fields{
+			"success": &graphql.Field{Type: graphql.Boolean},
+			"data":    &graphql.Field{Type: jsonScalar},
+			"error":   &graphql.Field{Type: graphql.String},
+		},
+	})
+	subtaskType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subtask",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.ID},
+			"result": &graphql.Field{Type: resultType},
+		},
+	})
+	commandType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Command",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.ID},
+			"command":     &graphql.Field{Type: graphql.String},
+			"instruction": &graphql.Field{Type: graphql.String},
+			"workingDir":  &graphql.Field{Type: graphql.String},
+			"status":      &graphql.Field{Type: graphql.String},
+			"exitCode":    &graphql.Field{Type: graphql.Int},
+			"durationMs": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return float64(p.Source.(*agent.CommandAuditEntry).Duration) / float64(time.Millisecond), nil
+				},
+			},
+			"output":    &graphql.Field{Type: graphql.String},
+			"error":     &graphql.Field{Type: graphql.String},
+			"timestamp": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	// transcriptField resolves a field from the transcript of a task
+	transcriptField := func(fieldType graphql.Output, value func(*agent.TaskTranscript) interface{}) *graphql.Field {
+		return &graphql.Field{
+			Type: fieldType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				transcript := p.Source.(*graphQLTask).record(p.Context, s.agentSystem)
+				if transcript == nil {
+					return nil, nil
+				}
+				return value(transcript), nil
+			},
+		}
+	}
+	taskType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Task",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"agent":       &graphql.Field{Type: graphql.String},
+			"description": &graphql.Field{Type: graphql.String},
+			"requester":   &graphql.Field{Type: graphql.String},
+			"workspace":   &graphql.Field{Type: graphql.String},
+			"createdAt":   &graphql.Field{Type: graphql.DateTime},
+			"result": transcriptField(resultType, func(t *agent.TaskTranscript) interface{} {
+				return t.Result
+			}),
+			"subtasks": transcriptField(graphql.NewList(subtaskType), func(t *agent.TaskTranscript) interface{} {
+				subtasks := []map[string]interface{}{}
+				for id, result := range t.Subtasks {
+					subtasks = append(subtasks, map[string]interface{}{"id": id, "result": result})
+				}
+				sort.Slice(subtasks, func(i, j int) bool { return subtasks[i]["id"].(string) < subtasks[j]["id"].(string) })
+				return subtasks
+			}),
+			"commands": transcriptField(graphql.NewList(commandType), func(t *agent.TaskTranscript) interface{} {
+				return t.Commands
+			}),
+			"diffs": transcriptField(graphql.NewList(graphql.String), func(t *agent.TaskTranscript) interface{} {
+				return t.Diffs
+			}),
+			"usage": &graphql.Field{
+				Type: usageType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					report, err := s.agentSystem.Usage().Report(agent.UsageFilter{TaskID: p.Source.(*graphQLTask).ID}, "")
+					if err != nil {
+						return nil, err
+					}
+					return report.Total, nil
+				},
+			},
+		},
+	})
+	messageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Message",
+		Fields: graphql.Fields{
+			"role":      &graphql.Field{Type: graphql.String},
+			"content":   &graphql.Field{Type: graphql.String},
+			"taskId":    &graphql.Field{Type: graphql.ID},
+			"createdAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	sessionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Session",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"title":        &graphql.Field{Type: graphql.String},
+			"workspaceDir": &graphql.Field{Type: graphql.String},
+			"owner":        &graphql.Field{Type: graphql.String},
+			"createdAt":    &graphql.Field{Type: graphql.DateTime},
+			"updatedAt":    &graphql.Field{Type: graphql.DateTime},
+			"messageCount": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(agent.SessionSummary).Messages, nil
+				},
+			},
+			"messages": &graphql.Field{
+				Type: graphql.NewList(messageType),
+				Args: graphql.FieldConfigArgument{
+					// last limits the messages to the most recent ones
+					"last": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					session, err := s.agentSystem.Session(p.Context, p.Source.(agent.SessionSummary).ID)
+					if err != nil {
+						return nil, err
+					}
+					messages := session.Messages
+					if last, ok := p.Args["last"].(int); ok && last >= 0 && last < len(messages) {
+						messages = messages[len(messages)-last:]
+					}
+					return messages, nil
+				},
+			},
+			"usage": &graphql.Field{
+				Type: usageType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					report, err := s.agentSystem.Usage().Report(agent.UsageFilter{SessionID: p.Source.(agent.SessionSummary).ID}, "")
+					if err != nil {
+						return nil, err
+					}
+					return report.Total, nil
+				},
+			},
+		},
+	})
+	fileType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "File",
+		Fields: graphql.Fields{
+			"path":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"content": &graphql.Field{Type: graphql.String},
+		},
+	})
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskEvent",
+		Fields: graphql.Fields{
+			"taskId":    &graphql.Field{Type: graphql.ID},
+			"type":      &graphql.Field{Type: graphql.String},
+			"data":      &graphql.Field{Type: jsonScalar},
+			"timestamp": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"tasks": &graphql.Field{
+				Type:        graphql.NewList(taskType),
+				Description: "Tasks, newest first, from the audit event log",
+				Args: graphql.FieldConfigArgument{
+					"agent":     &graphql.ArgumentConfig{Type: graphql.String},
+					"requester": &graphql.ArgumentConfig{Type: graphql.String},
+					"since":     &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"limit":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 50},
+				},
+				Resolve: s.resolveTasks,
+			},
+			"task": &graphql.Field{
+				Type: taskType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: s.resolveTask,
+			},
+			"sessions": &graphql.Field{
+				Type: graphql.NewList(sessionType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.agentSystem.ListSessions(p.Context), nil
+				},
+			},
+			"session": &graphql.Field{
+				Type: sessionType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, _ := p.Args["id"].(string)
+					for _, session := range s.agentSystem.ListSessions(p.Context) {
+						if session.ID == id {
+							return session, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+			"files": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Files under dir in the workspace, relative to dir",
+				Args: graphql.FieldConfigArgument{
+					"workspaceDir": &graphql.ArgumentConfig{Type: graphql.String},
+					"dir":          &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "."},
+					"limit":        &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1000},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					workspaceDir, err := s.graphQLWorkspace(p)
+					if err != nil {
+						return nil, err
+					}
+					dir, _ := p.Args["dir"].(string)
+					files, err := s.agentSystem.ListWorkspaceFiles(p.Context, workspaceDir, dir)
+					if err != nil {
+						return nil, err
+					}
+					sort.Strings(files)
+					if limit, _ := p.Args["limit"].(int); limit >= 0 && len(files) > limit {
+						files = files[:limit]
+					}
+					return files, nil
+				},
+			},
+			"file": &graphql.Field{
+				Type: fileType,
+				Args: graphql.FieldConfigArgument{
+					"workspaceDir": &graphql.ArgumentConfig{Type: graphql.String},
+					"path":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					workspaceDir, err := s.graphQLWorkspace(p)
+					if err != nil {
+						return nil, err
+					}
+					path, _ := p.Args["path"].(string)
+					content, err := s.agentSystem.ReadWorkspaceFile(p.Context, workspaceDir, path)
+					if err != nil {
+						return nil, err
+					}
+					return map[string]interface{}{"path": path, "content": content}, nil
+				},
+			},
+			"usage": &graphql.Field{
+				Type:        usageReportType,
+				Description: "LLM token usage and cost; groupBy is model, task, session, requester, workspace or day",
+				Args: graphql.FieldConfigArgument{
+					"taskId":    &graphql.ArgumentConfig{Type: graphql.ID},
+					"sessionId": &graphql.ArgumentConfig{Type: graphql.ID},
+					"requester": &graphql.ArgumentConfig{Type: graphql.String},
+					"workspace": &graphql.ArgumentConfig{Type: graphql.String},
+					"model":     &graphql.ArgumentConfig{Type: graphql.String},
+					"since":     &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"until":     &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"groupBy":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					filter := agent.UsageFilter{Requester: s.graphQLRequester(p)}
+					filter.TaskID, _ = p.Args["taskId"].(string)
+					filter.SessionID, _ = p.Args["sessionId"].(string)
+					filter.Workspace, _ = p.Args["workspace"].(string)
+					filter.Model, _ = p.Args["model"].(string)
+					filter.Since, _ = p.Args["since"].(time.Time)
+					filter.Until, _ = p.Args["until"].(time.Time)
+					groupBy, _ := p.Args["groupBy"].(string)
+					return s.agentSystem.Usage().Report(filter, groupBy)
+				},
+			},
+		},
+	})
+
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"taskEvents": &graphql.Field{
+				Type:        eventType,
+				Description: "Progress events of the caller's tasks, or of one task",
+				Args: graphql.FieldConfigArgument{
+					"taskId": &graphql.ArgumentConfig{Type: graphql.ID},
+					"types":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Subscribe: s.subscribeTaskEvents,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Subscription: subscription})
+}
+
+// resolveTasks lists tasks from the event log, leaving out sub-tasks
+func (s *Server) resolveTasks(p graphql.ResolveParams) (interface{}, error) {
+	eventLog := s.agentSystem.EventLog()
+	if eventLog == nil {
+		return nil, errors.New("the audit log is disabled; tasks are listed from its events")
+	}
+	filter := agent.EventFilter{Type: agent.ActionTaskCreated, Requester: s.graphQLRequester(p)}
+	filter.Since, _ = p.Args["since"].(time.Time)
+	events, err := eventLog.Query(filter)
+	if err != nil {
+		return nil, err
+	}
+	agentType, _ := p.Args["agent"].(string)
+	limit, _ := p.Args["limit"].(int)
+	tasks := []*graphQLTask{}
+	for _, event := range events {
+		task := taskFromEvent(event)
+		if strings.Contains(task.ID, ".") || agentType != "" && task.Agent != agentType || !s.agentSystem.OwnsTask(p.Context, task.ID) {
+			continue
+		}
+		if len(tasks) == limit {
+			break
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// resolveTask returns one task the caller owns, described by the event log
+// when it is enabled
+func (s *Server) resolveTask(p graphql.ResolveParams) (interface{}, error) {
+	id, _ := p.Args["id"].(string)
+	if !s.agentSystem.OwnsTask(p.Context, id) {
+		return nil, nil
+	}
+	if eventLog := s.agentSystem.EventLog(); eventLog != nil {
+		events, err := eventLog.Query(agent.EventFilter{Type: agent.ActionTaskCreated, TaskID: id})
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if event.TaskID == id {
+				return taskFromEvent(event), nil
+			}
+		}
+	}
+	task := &graphQLTask{ID: id}
+	if task.record(p.Context, s.agentSystem) == nil {
+		return nil, nil
+	}
+	return task, nil
+}
+
+// subscribeTaskEvents streams the task events the caller may see until the
+// subscription's context ends
+func (s *Server) subscribeTaskEvents(p graphql.ResolveParams) (interface{}, error) {
+	taskID, _ := p.Args["taskId"].(string)
+	types := make(map[string]bool)
+	if list, ok := p.Args["types"].([]interface{}); ok {
+		for _, t := range list {
+			types[t.(string)] = true
+		}
+	}
+	events, unsubscribe := s.agentSystem.Events().Subscribe(taskID)
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case event := <-events:
+				if len(types) > 0 && !types[string(event.Type)] || !s.agentSystem.OwnsTask(p.Context, event.TaskID) {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// graphQLWorkspace resolves the workspaceDir argument like workspaceParam
+func (s *Server) graphQLWorkspace(p graphql.ResolveParams) (string, error) {
+	dir, _ := p.Args["workspaceDir"].(string)
+	if s.agentSystem.Tenancy() == nil {
+		if dir == "" {
+			dir = "."
+		}
+		return dir, nil
+	}
+	return s.agentSystem.TenantWorkspace(p.Context, dir)
+}
+
+// graphQLRequester returns the requester whose records a query may return,
+// like scopedRequester
+func (s *Server) graphQLRequester(p graphql.ResolveParams) string {
+	if s.agentSystem.Tenancy() != nil {
+		return agent.RequesterFrom(p.Context)
+	}
+	requester, _ := p.Args["requester"].(string)
+	return requester
+}
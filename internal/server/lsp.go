@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"spilot-agent/internal/agent"
+)
+
+// lspRequester is the requester audited for requests of the language server
+const lspRequester = "lsp"
+
+// Commands of the code actions offered by the language server
+const (
+	lspFixDiagnostic    = "spilot.fixDiagnostic"
+	lspExplainSelection = "spilot.explainSelection"
+)
+
+// LSP message types of window/showMessage
+const (
+	lspMessageError = 1
+	lspMessageInfo  = 3
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange        `json:"range"`
+	Severity int             `json:"severity,omitempty"`
+	Code     json.RawMessage `json:"code,omitempty"`
+	Source   string          `json:"source,omitempty"`
+	Message  string          `json:"message"`
+}
+
+type lspCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
+}
+
+type lspCodeAction struct {
+	Title       string          `json:"title"`
+	Kind        string          `json:"kind"`
+	Diagnostics []lspDiagnostic `json:"diagnostics,omitempty"`
+	Command     *lspCommand     `json:"command"`
+}
+
+// lspActionArgs is the argument of the commands of Spilot code actions
+type lspActionArgs struct {
+	URI        string         `json:"uri"`
+	Range      lspRange       `json:"range"`
+	Diagnostic *lspDiagnostic `json:"diagnostic,omitempty"`
+}
+
+// lspSession is the state of one language client: the workspace and the
+// text of the documents it has open
+type lspSession struct {
+	server *Server
+	conn   *rpcConn
+
+	mu        sync.Mutex
+	root      string
+	documents map[string]string
+}
+
+// ServeLSP serves the agent as a minimal Language Server Protocol server
+// over in and out, so any LSP-capable editor can use it without a plugin.
+// It offers two code actions, "Spilot: fix this diagnostic", which applies
+// a fix of the diagnostic to the workspace, and "Spilot: explain
+// selection", which shows an explanation of the selected code. ServeLSP
+// returns when the client exits, in ends or ctx is done.
+func (s *Server) ServeLSP(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, exit := context.WithCancel(agent.ContextWithRequester(ctx, lspRequester))
+	defer exit()
+	session := &lspSession{server: s, documents: make(map[string]string)}
+	session.conn = newRPCConn(s, out, session.methods())
+	session.conn.framed = true
+	session.conn.serial = map[string]bool{"initialize": true, "shutdown": true}
+	session.conn.notifications["$/cancelRequest"] = session.conn.cancelParams
+	session.conn.notifications["exit"] = func(json.RawMessage) { exit() }
+	session.conn.notifications["textDocument/didOpen"] = session.didOpen
+	session.conn.notifications["textDocument/didChange"] = session.didChange
+	session.conn.notifications["textDocument/didClose"] = session.didClose
+
+	reader := textproto.NewReader(bufio.NewReader(in))
+	err := session.conn.serve(ctx, func() ([]byte, error) {
+		return readLSPMessage(reader)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// readLSPMessage reads one message framed by a Content-Length header
+func readLSPMessage(reader *textproto.Reader) ([]byte, error) {
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if length > maxStdioMessageBytes {
+		return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", length, maxStdioMessageBytes)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(reader.R, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (l *lspSession) methods() map[string]rpcMethod {
+	return map[string]rpcMethod{
+		"initialize":               l.initialize,
+		"shutdown":                 func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+		"textDocument/codeAction":  l.codeAction,
+		"workspace/executeCommand": l.executeCommand,
+	}
+}
+
+func (l *lspSession) initialize(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req struct {
+		RootURI          string `json:"rootUri"`
+		RootPath         string `json:"rootPath"`
+		WorkspaceFolders []struct {
+			URI string `json:"uri"`
+		} `json:"workspaceFolders"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcParamsError{err}
+	}
+	root := req.RootPath
+	switch {
+	case len(req.WorkspaceFolders) > 0:
+		root = uriPath(req.WorkspaceFolders[0].URI)
+	case req.RootURI != "":
+		root = uriPath(req.RootURI)
+	}
+	l.mu.Lock()
+	l.root = root
+	l.mu.Unlock()
+
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			// Full text on every change; documents are small enough
+			"textDocumentSync":   1,
+			"codeActionProvider": map[string]interface{}{"codeActionKinds": []string{"quickfix", "refactor"}},
+			"executeCommandProvider": map[string]interface{}{
+				"commands": []string{lspFixDiagnostic, lspExplainSelection},
+			},
+		},
+		"serverInfo": map[string]string{"name": "spilot"},
+	}, nil
+}
+
+func (l *lspSession) didOpen(params json.RawMessage) {
+	var req struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+	}
+	if json.Unmarshal(params, &req) == nil {
+		l.mu.Lock()
+		l.documents[req.TextDocument.URI] = req.TextDocument.Text
+		l.mu.Unlock()
+	}
+}
+
+func (l *lspSession) didChange(params json.RawMessage) {
+	var req struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if json.Unmarshal(params, &req) == nil && len(req.ContentChanges) > 0 {
+		l.mu.Lock()
+		l.documents[req.TextDocument.URI] = req.ContentChanges[len(req.ContentChanges)-1].Text
+		l.mu.Unlock()
+	}
+}
+
+func (l *lspSession) didClose(params json.RawMessage) {
+	var req struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	}
+	if json.Unmarshal(params, &req) == nil {
+		l.mu.Lock()
+		delete(l.documents, req.TextDocument.URI)
+		l.mu.Unlock()
+	}
+}
+
+// codeAction offers a fix for each diagnostic in the range and, for a
+// selection, its explanation
+func (l *lspSession) codeAction(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Range   lspRange `json:"range"`
+		Context struct {
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcParamsError{err}
+	}
+	uri := req.TextDocument.URI
+
+	actions := []lspCodeAction{}
+	for i := range req.Context.Diagnostics {
+		diagnostic := req.Context.Diagnostics[i]
+		actions = append(actions, lspCodeAction{
+			Title:       "Spilot: fix this diagnostic",
+			Kind:        "quickfix",
+			Diagnostics: []lspDiagnostic{diagnostic},
+			Command: &lspCommand{
+				Title:     "Spilot: fix this diagnostic",
+				Command:   lspFixDiagnostic,
+				Arguments: []interface{}{lspActionArgs{URI: uri, Range: diagnostic.Range, Diagnostic: &diagnostic}},
+			},
+		})
+	}
+	if req.Range.Start != req.Range.End {
+		actions = append(actions, lspCodeAction{
+			Title: "Spilot: explain selection",
+			Kind:  "refactor",
+			Command: &lspCommand{
+				Title:     "Spilot: explain selection",
+				Command:   lspExplainSelection,
+				Arguments: []interface{}{lspActionArgs{URI: uri, Range: req.Range}},
+			},
+		})
+	}
+	return actions, nil
+}
+
+// executeCommand runs the command of a code action and shows its outcome
+// with window/showMessage
+func (l *lspSession) executeCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req struct {
+		Command   string          `json:"command"`
+		Arguments []lspActionArgs `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &rpcParamsError{err}
+	}
+	if len(req.Arguments) != 1 {
+		return nil, &rpcParamsError{errors.New("one argument is required")}
+	}
+	args := req.Arguments[0]
+
+	l.mu.Lock()
+	root := l.root
+	text, open := l.documents[args.URI]
+	l.mu.Unlock()
+	path := relativeTo(root, uriPath(args.URI))
+	ctx = agent.ContextWithTaskID(ctx, agent.NewTaskID())
+
+	var result *agent.TaskResult
+	var err error
+	var message string
+	switch req.Command {
+	case lspFixDiagnostic:
+		if args.Diagnostic == nil {
+			return nil, &rpcParamsError{errors.New("diagnostic is required")}
+		}
+		d := args.Diagnostic
+		errorOutput := fmt.Sprintf("%s:%d:%d: %s", path, d.Range.Start.Line+1, d.Range.Start.Character+1, d.Message)
+		if d.Source != "" {
+			errorOutput += " (" + d.Source + ")"
+		}
+		result, err = l.server.agentSystem.HandleCommand(ctx, "/fix", errorOutput, root, map[string]interface{}{"apply": true})
+		if err == nil {
+			if debug := result.Debug(); debug != nil {
+				message = debug.Analysis
+			}
+		}
+	case lspExplainSelection:
+		target := path
+		if open {
+			target = fmt.Sprintf("this code from %s:\n%s", path, textRange(text, args.Range))
+		}
+		result, err = l.server.agentSystem.HandleCommand(ctx, "/explain", target, root, nil)
+		if err == nil {
+			message, _ = result.Fields()["explanation"].(string)
+		}
+	default:
+		return nil, &rpcParamsError{fmt.Errorf("unknown command %s", req.Command)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !result.Success:
+		l.showMessage(lspMessageError, "Spilot: "+result.Error)
+	case message != "":
+		l.showMessage(lspMessageInfo, message)
+	}
+	return result, nil
+}
+
+func (l *lspSession) showMessage(kind int, message string) {
+	l.conn.write(rpcMessage{
+		JSONRPC: "2.0",
+		Method:  "window/showMessage",
+		Params:  mustMarshal(map[string]interface{}{"type": kind, "message": message}),
+	})
+}
+
+// uriPath returns the file path of a file:// URI, or uri itself if it is
+// not one
+func uriPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(parsed.Path)
+}
+
+// relativeTo returns path relative to root when it is inside it
+func relativeTo(root, path string) string {
+	if root == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// textRange returns the text between two LSP positions, whose characters
+// count UTF-16 code units
+func textRange(text string, r lspRange) string {
+	start, end := textOffset(text, r.Start), textOffset(text, r.End)
+	if end < start {
+		start, end = end, start
+	}
+	return text[start:end]
+}
+
+func textOffset(text string, pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		next := strings.IndexByte(text[offset:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		offset += next + 1
+	}
+	for units := 0; units < pos.Character && offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units++
+		if r > 0xFFFF {
+			// Outside the basic plane, a surrogate pair
+			units++
+		}
+		offset += size
+	}
+	return offset
+}
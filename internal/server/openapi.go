@@ -0,0 +1,47 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate go run ../../cmd/tsclient -spec openapi.yaml -out ../../src/api/client.ts
+
+// openAPISpec describes the API; the editor extension's client is
+// generated from it
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// apiVersionHeader carries the version of the spec the server implements,
+// so clients generated from another major version can warn
+const apiVersionHeader = "X-Spilot-API-Version"
+
+// apiVersion is the version of openAPISpec
+var apiVersion = func() string {
+	var spec struct {
+		Info struct {
+			Version string `yaml:"version"`
+		} `yaml:"info"`
+	}
+	if err := yaml.Unmarshal(openAPISpec, &spec); err != nil {
+		panic("server: invalid openapi.yaml: " + err.Error())
+	}
+	return spec.Info.Version
+}()
+
+// handleOpenAPI serves the OpenAPI spec of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+// apiVersionMiddleware reports the API version on every response
+func (s *Server) apiVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, apiVersion)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"spilot-agent/internal/agent"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// upgrader accepts connections from the server's own origin, such as the
+// web UI's, and from clients that send no Origin header. Unlike the rest
+// of the API a WebSocket is not protected by CORS, so any page the user
+// visits could otherwise open a shell.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// ptyMessage is a control or input message sent by the client. Output is
+// sent back as binary frames; the final frame is a text "exit" message.
+type ptyMessage struct {
+	Type string `json:"type"` // input, resize, exit
+	Data string `json:"data,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Code int    `json:"code,omitempty"`
+}
+
+// handlePTY upgrades the connection to a WebSocket driving an interactive
+// terminal. Query parameters: workspace_dir, shell, command, rows, cols.
+func (s *Server) handlePTY(w http.ResponseWriter, r *http.Request) {
+	// Interactive shells run commands just like the terminal agent
+	if !s.agentSystem.AgentEnabled(agent.TerminalAgent) {
+		s.sendError(w, "terminal agent is disabled; interactive shells are unavailable", http.StatusForbidden)
+		return
+	}
+	if err := agent.RequireScope(r.Context(), agent.ScopeTerminal); err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	// Interactive shells can reach any workspace
+	if s.agentSystem.Tenancy() != nil {
+		s.sendError(w, "interactive shells are unavailable while users are isolated", http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+
+	shell := s.agentSystem.DefaultShell()
+	if name := query.Get("shell"); name != "" {
+		parsed, err := agent.ParseShell(name)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shell = parsed
+	}
+	workingDir := query.Get("workspace_dir")
+	if workingDir == "" {
+		workingDir = "."
+	}
+	rows, cols := parseWinsize(query.Get("rows"), 24), parseWinsize(query.Get("cols"), 80)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warn("Failed to upgrade PTY connection", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	session, err := s.agentSystem.PTYs().Start(callerContext(r), shell, workingDir, query.Get("command"), rows, cols)
+	if err != nil {
+		conn.WriteJSON(ptyMessage{Type: "exit", Data: err.Error(), Code: -1})
+		return
+	}
+	defer s.agentSystem.PTYs().Close(session.ID)
+
+	// Terminal output -> client
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := session.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Client input -> terminal
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				s.agentSystem.PTYs().Close(session.ID)
+				return
+			}
+			var msg ptyMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				session.Write([]byte(msg.Data))
+			case "resize":
+				if err := session.Resize(msg.Rows, msg.Cols); err != nil {
+					s.logger.Debug("Failed to resize PTY", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	<-session.Done()
+	<-outputDone
+	conn.WriteJSON(ptyMessage{Type: "exit", Code: session.ExitCode()})
+}
+
+// handleListPTYs lists open terminal sessions
+func (s *Server) handleListPTYs(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"sessions": s.agentSystem.PTYs().List()},
+	})
+}
+
+// parseWinsize parses a terminal dimension, falling back to def
+func parseWinsize(value string, def uint16) uint16 {
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || n == 0 {
+		return def
+	}
+	return uint16(n)
+}
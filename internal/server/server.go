@@ -3,10 +3,16 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"spilot-agent/internal/agent"
+	"spilot-agent/internal/tickets"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
@@ -15,18 +21,56 @@ import (
 // Server represents the HTTP server
 type Server struct {
 	agentSystem *agent.System
+	options     Options
 	logger      *zap.Logger
 	server      *http.Server
+	// admin serves the admin API on its own listener
+	admin   *http.Server
+	started time.Time
+}
+
+// Options configure the server
+type Options struct {
+	// LogLevel is the level of the server's logger, changeable through the
+	// admin API
+	LogLevel zap.AtomicLevel
+	// AccessLog logs every request
+	AccessLog bool
+	// APIKeysRequired refuses agent API requests without an API key
+	APIKeysRequired bool
+	Admin           AdminOptions
+	// GitHub, when set, receives GitHub webhook deliveries at
+	// githubWebhookPath. Deliveries are authenticated by their signature
+	// rather than an API key.
+	GitHub http.Handler
+	// GitLab, when set, receives GitLab webhook deliveries at
+	// gitlabWebhookPath, authenticated by their secret token
+	GitLab http.Handler
+	// UI serves the embedded web UI at /
+	UI bool
 }
 
 // Request represents an incoming request
 type Request struct {
-	Type         string                 `json:"type"`
-	Command      string                 `json:"command,omitempty"`
-	Args         string                 `json:"args,omitempty"`
-	Request      string                 `json:"request,omitempty"`
-	WorkspaceDir string                 `json:"workspace_dir,omitempty"`
-	Model        string                 `json:"model,omitempty"`
+	Type         string `json:"type"`
+	Command      string `json:"command,omitempty"`
+	Args         string `json:"args,omitempty"`
+	Request      string `json:"request,omitempty"`
+	WorkspaceDir string `json:"workspace_dir,omitempty"`
+	Model        string `json:"model,omitempty"`
+	TaskID       string `json:"task_id,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Plan         string `json:"plan,omitempty"`
+	Token        string `json:"token,omitempty"`
+	// ConfirmWrites holds each file write of the request until its diff
+	// preview is approved through the approvals API
+	ConfirmWrites bool `json:"confirm_writes,omitempty"`
+	// Ticket links the plan of the request to an issue of the configured
+	// tracker, such as PROJ-123; CreateTicket records it in a new one
+	Ticket       string                 `json:"ticket,omitempty"`
+	CreateTicket bool                   `json:"create_ticket,omitempty"`
 	Data         map[string]interface{} `json:"data,omitempty"`
 }
 
@@ -38,48 +82,134 @@ type Response struct {
 }
 
 // New creates a new server
-func New(agentSystem *agent.System, logger *zap.Logger) *Server {
+func New(agentSystem *agent.System, options Options, logger *zap.Logger) *Server {
 	return &Server{
 		agentSystem: agentSystem,
+		options:     options,
 		logger:      logger,
+		started:     time.Now(),
 	}
 }
 
 // Start starts the HTTP server
 func (s *Server) Start(port string) error {
-	router := s.setupRoutes()
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Starting server", zap.String("port", port))
+	return s.Serve(listener)
+}
 
+// Serve serves the agent API on listener, for example on a loopback port
+// when a client runs the agent in-process
+func (s *Server) Serve(listener net.Listener) error {
 	s.server = &http.Server{
-		Addr:         ":" + port,
-		Handler:      router,
+		Handler:      s.setupRoutes(),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
-
-	s.logger.Info("Starting server", zap.String("port", port))
-	return s.server.ListenAndServe()
+	return s.server.Serve(listener)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server and the admin server
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
 	return s.server.Shutdown(ctx)
 }
 
+// webhooks returns the configured webhook handlers by path
+func (s *Server) webhooks() map[string]http.Handler {
+	webhooks := make(map[string]http.Handler)
+	if s.options.GitHub != nil {
+		webhooks[githubWebhookPath] = s.options.GitHub
+	}
+	if s.options.GitLab != nil {
+		webhooks[gitlabWebhookPath] = s.options.GitLab
+	}
+	return webhooks
+}
+
 // setupRoutes sets up the HTTP routes
 func (s *Server) setupRoutes() *mux.Router {
 	router := mux.NewRouter()
 
-	// Health check
+	// Health checks; /health is kept for existing probes
 	router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	router.HandleFunc("/healthz", s.handleHealth).Methods("GET")
+	router.HandleFunc("/readyz", s.handleReady).Methods("GET")
+	router.HandleFunc("/api/openapi.yaml", s.handleOpenAPI).Methods("GET")
 
 	// Agent endpoints
 	router.HandleFunc("/api/process", s.handleProcessRequest).Methods("POST")
 	router.HandleFunc("/api/command", s.handleCommand).Methods("POST")
 	router.HandleFunc("/api/chat", s.handleChat).Methods("POST")
+	router.HandleFunc("/api/tasks/events", s.handleTaskEvents).Methods("GET")
+	router.HandleFunc("/api/processes", s.handleListProcesses).Methods("GET")
+	router.HandleFunc("/api/processes/{id}", s.handleGetProcess).Methods("GET")
+	router.HandleFunc("/api/processes/{id}/logs", s.handleProcessLogs).Methods("GET")
+	router.HandleFunc("/api/processes/{id}/stop", s.handleStopProcess).Methods("POST")
+	router.HandleFunc("/api/pty", s.handlePTY).Methods("GET")
+	router.HandleFunc("/api/pty/sessions", s.handleListPTYs).Methods("GET")
+	router.HandleFunc("/api/agents", s.handleListAgents).Methods("GET")
+	router.HandleFunc("/api/agents/{type}/tasks", s.handleAgentTask).Methods("POST")
+	router.HandleFunc("/api/plans/execute", s.handleExecutePlan).Methods("POST")
+	router.HandleFunc("/api/approvals", s.handleListApprovals).Methods("GET")
+	router.HandleFunc("/api/audit/commands", s.handleCommandAudit).Methods("GET")
+	router.HandleFunc("/api/events", s.handleEvents).Methods("GET")
+	router.HandleFunc("/api/usage", s.handleUsage).Methods("GET")
+	router.HandleFunc("/api/spend", s.handleSpend).Methods("GET")
+	router.HandleFunc("/api/models/latency", s.handleModelLatency).Methods("GET")
+	router.HandleFunc("/api/index", s.handleIndexStatus).Methods("GET")
+	router.HandleFunc("/api/index", s.handleReindex).Methods("POST")
+	router.HandleFunc("/api/index/search", s.handleIndexSearch).Methods("GET")
+	router.HandleFunc("/api/symbols", s.handleSymbols).Methods("GET")
+	router.HandleFunc("/api/profile", s.handleProfile(false)).Methods("GET")
+	router.HandleFunc("/api/profile", s.handleProfile(true)).Methods("POST")
+	router.HandleFunc("/api/rules", s.handleRules).Methods("GET")
+	router.HandleFunc("/api/memory", s.handleListMemory).Methods("GET")
+	router.HandleFunc("/api/memory", s.handleAddMemory).Methods("POST")
+	router.HandleFunc("/api/memory/{id}", s.handleDeleteMemory).Methods("DELETE")
+	router.HandleFunc("/api/sessions", s.handleCreateSession).Methods("POST")
+	router.HandleFunc("/api/sessions", s.handleListSessions).Methods("GET")
+	router.HandleFunc("/api/sessions/{id}", s.handleGetSession).Methods("GET")
+	router.HandleFunc("/api/sessions/{id}", s.handleDeleteSession).Methods("DELETE")
+	router.HandleFunc("/api/sessions/{id}/export", s.handleExport(true)).Methods("GET")
+	router.HandleFunc("/api/tasks/{id}/export", s.handleExport(false)).Methods("GET")
+	router.HandleFunc("/api/outputs/{id}", s.handleOutput).Methods("GET")
+	router.HandleFunc("/api/approvals/{id}/approve", s.handleDecideApproval(true)).Methods("POST")
+	router.HandleFunc("/api/approvals/{id}/reject", s.handleDecideApproval(false)).Methods("POST")
+	router.HandleFunc("/api/webhooks", s.handleListWebhooks).Methods("GET")
+	router.HandleFunc("/api/webhooks", s.handleRegisterWebhook).Methods("POST")
+	router.HandleFunc("/api/webhooks/{id}", s.handleDeleteWebhook).Methods("DELETE")
+	if schema, err := s.graphQLSchema(); err != nil {
+		s.logger.Error("Failed to build the GraphQL schema", zap.Error(err))
+	} else {
+		router.HandleFunc("/api/graphql", s.handleGraphQL(schema)).Methods("GET", "POST")
+	}
+	for path, handler := range s.webhooks() {
+		router.Handle(path, handler).Methods("POST")
+	}
+	if s.options.UI {
+		router.HandleFunc("/", s.handleUI).Methods("GET")
+		router.PathPrefix(uiPath).Handler(uiAssets()).Methods("GET")
+	}
 
-	// Add CORS middleware
+	// Add access logging and CORS middleware
+	if s.options.AccessLog {
+		router.Use(s.accessLogMiddleware)
+	}
 	router.Use(s.corsMiddleware)
+	router.Use(s.apiVersionMiddleware)
+	router.Use(s.apiKeyMiddleware)
+	if s.agentSystem.Telemetry() != nil {
+		router.Use(s.telemetryMiddleware)
+	}
 
 	return router
 }
@@ -89,7 +219,8 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Task-ID, "+apiVersionHeader)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -100,13 +231,33 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// handleHealth handles health check requests
+// handleHealth reports that the server is alive, without checking its
+// dependencies
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// readyTimeout bounds the dependency checks of a readiness probe
+const readyTimeout = 5 * time.Second
+
+// handleReady reports whether the server can serve requests: 200 when
+// every dependency check passes, 503 otherwise, with each check's result
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	checks, ready := s.agentSystem.CheckReadiness(ctx)
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
+
 // handleProcessRequest handles general processing requests
 func (s *Server) handleProcessRequest(w http.ResponseWriter, r *http.Request) {
 	var req Request
@@ -114,14 +265,39 @@ func (s *Server) handleProcessRequest(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if req.Ticket != "" && req.CreateTicket {
+		s.sendError(w, "ticket and create_ticket are exclusive", http.StatusBadRequest)
+		return
+	}
 
 	// Set the model if provided in the request
 	if req.Model != "" {
 		s.agentSystem.SetModel(req.Model)
 	}
 
-	ctx := r.Context()
+	s.awaitUser(w, req)
+	ctx := s.taskContext(r, req)
 	result, err := s.agentSystem.ProcessUserRequest(ctx, req.Request, req.WorkspaceDir)
+	if errors.Is(err, agent.ErrSessionNotFound) || errors.Is(err, tickets.ErrNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, agent.ErrTicket) {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, agent.ErrAgentDisabled) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, agent.ErrBudgetExceeded) {
+		s.sendError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		s.sendError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -138,8 +314,21 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-	result, err := s.agentSystem.HandleCommand(ctx, req.Command, req.Args, req.WorkspaceDir)
+	s.awaitUser(w, req)
+	ctx := s.taskContext(r, req)
+	result, err := s.agentSystem.HandleCommand(ctx, req.Command, req.Args, req.WorkspaceDir, req.Data)
+	if errors.Is(err, agent.ErrAgentDisabled) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, agent.ErrBudgetExceeded) {
+		s.sendError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		s.sendError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -148,35 +337,907 @@ func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
 	s.sendResponse(w, result)
 }
 
-// handleChat handles chat requests
+// handleChat answers a chat message, taken from message or request. With a
+// session_id the reply follows on from the session's conversation.
 func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	var req Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.sendError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	message := req.Message
+	if message == "" {
+		message = req.Request
+	}
+	if message == "" {
+		s.sendError(w, "message is required", http.StatusBadRequest)
+		return
+	}
 
-	// For chat, we'll use the LLM client directly
-	// This is a simplified implementation
-	response := Response{
+	reply, err := s.agentSystem.Chat(s.taskContext(r, req), message)
+	if errors.Is(err, agent.ErrSessionNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, agent.ErrBudgetExceeded) {
+		s.sendError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{"message": reply}
+	if req.SessionID != "" {
+		data["session_id"] = req.SessionID
+	}
+	s.sendJSON(w, Response{Success: true, Data: data})
+}
+
+// handleRules returns the rules injected into prompts about the
+// workspace_dir query parameter
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	workspaceDir, ok := s.workspaceParam(w, r, r.URL.Query().Get("workspace_dir"))
+	if !ok {
+		return
+	}
+	rules := s.agentSystem.Rules(workspaceDir)
+	s.sendJSON(w, Response{
 		Success: true,
-		Data: map[string]interface{}{
-			"message": "Chat functionality will be implemented here",
-		},
+		Data:    map[string]interface{}{"rules": rules, "prompt": rules.Prompt()},
+	})
+}
+
+// handleListMemory lists what is remembered about the workspace_dir query
+// parameter. With q, only the entries relevant to q are returned.
+func (s *Server) handleListMemory(w http.ResponseWriter, r *http.Request) {
+	memory := s.agentSystem.Memory()
+	if memory == nil {
+		s.sendError(w, "Workspace memory is disabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	workspaceDir, ok := s.workspaceParam(w, r, query.Get("workspace_dir"))
+	if !ok {
+		return
+	}
+	entries := memory.List(workspaceDir)
+	if q := query.Get("q"); q != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		entries = memory.Relevant(workspaceDir, q, limit)
 	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"entries": entries},
+	})
+}
 
-	s.sendJSON(w, response)
+// handleAddMemory records an entry. Body: workspace_dir, kind (decision,
+// convention or fixed_error) and content.
+func (s *Server) handleAddMemory(w http.ResponseWriter, r *http.Request) {
+	memory := s.agentSystem.Memory()
+	if memory == nil {
+		s.sendError(w, "Workspace memory is disabled", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		WorkspaceDir string `json:"workspace_dir"`
+		Kind         string `json:"kind"`
+		Content      string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	workspaceDir, ok := s.workspaceParam(w, r, req.WorkspaceDir)
+	if !ok {
+		return
+	}
+	entry, err := memory.Add(workspaceDir, agent.MemoryEntry{Kind: req.Kind, Content: req.Content})
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"entry": entry},
+	})
 }
 
-// sendResponse sends a task result as a response
-func (s *Server) sendResponse(w http.ResponseWriter, result *agent.TaskResult) {
-	response := Response{
-		Success: result.Success,
-		Data:    result.Data,
-		Error:   result.Error,
+// handleDeleteMemory forgets an entry of the workspace_dir query parameter
+func (s *Server) handleDeleteMemory(w http.ResponseWriter, r *http.Request) {
+	memory := s.agentSystem.Memory()
+	if memory == nil {
+		s.sendError(w, "Workspace memory is disabled", http.StatusNotFound)
+		return
+	}
+	workspaceDir, ok := s.workspaceParam(w, r, r.URL.Query().Get("workspace_dir"))
+	if !ok {
+		return
+	}
+	err := memory.Delete(workspaceDir, mux.Vars(r)["id"])
+	if errors.Is(err, agent.ErrMemoryNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{Success: true})
+}
+
+// handleExport downloads the transcript of a session, or of a task such as
+// a plan execution, with its messages, commands, changes and results. The
+// format query parameter is markdown (default) or json.
+func (s *Server) handleExport(session bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		export := s.agentSystem.TaskTranscript
+		if session {
+			export = s.agentSystem.SessionTranscript
+		}
+		transcript, err := export(callerContext(r), id)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "markdown", "md":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".md"))
+			io.WriteString(w, transcript.Markdown())
+		case "json":
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".json"))
+			s.sendJSON(w, transcript)
+		default:
+			s.sendError(w, "Unknown format "+format, http.StatusBadRequest)
+		}
+	}
+}
+
+// handleOutput streams a task output that was written to disk rather than
+// kept in the task's result
+func (s *Server) handleOutput(w http.ResponseWriter, r *http.Request) {
+	output, err := s.agentSystem.ReadOutput(callerContext(r), mux.Vars(r)["id"])
+	if errors.Is(err, agent.ErrOutputNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer output.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, output)
+}
+
+// handleCreateSession starts a session. The body's title and
+// workspace_dir are optional; requests in the session without a
+// workspace_dir use the session's.
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	session, err := s.agentSystem.CreateSession(callerContext(r), req.Title, req.WorkspaceDir)
+	if errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"session": session},
+	})
+}
+
+// handleListSessions lists sessions, most recently active first
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"sessions": s.agentSystem.ListSessions(callerContext(r))},
+	})
+}
+
+// handleGetSession returns a session with its message history
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.agentSystem.Session(callerContext(r), mux.Vars(r)["id"])
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"session": session},
+	})
+}
+
+// handleDeleteSession deletes a session and its history
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	err := s.agentSystem.DeleteSession(callerContext(r), mux.Vars(r)["id"])
+	if errors.Is(err, agent.ErrSessionNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{Success: true})
+}
+
+// handleTaskEvents streams task events as Server-Sent Events. The optional
+// task_id query parameter restricts the stream to a single task; clients
+// pass the same task_id in their request to follow it live.
+func (s *Server) handleTaskEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Event streams outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Warn("Failed to clear write deadline for event stream", zap.Error(err))
+	}
+
+	ctx := callerContext(r)
+	events, unsubscribe := s.agentSystem.Events().Subscribe(r.URL.Query().Get("task_id"))
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if !s.agentSystem.OwnsTask(ctx, event.TaskID) {
+				continue
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Error("Failed to encode task event", zap.Error(err))
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleListAgents lists the registered agents and their capabilities
+func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"agents": s.agentSystem.Agents()},
+	})
+}
+
+// handleAgentTask runs a task on one agent, passing the request's data
+// through as the task data
+func (s *Server) handleAgentTask(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	s.sendJSON(w, response)
+	s.awaitUser(w, req)
+	ctx := s.taskContext(r, req)
+	result, err := s.agentSystem.RunAgentTask(ctx, agent.AgentType(mux.Vars(r)["type"]), req.WorkspaceDir, req.Data)
+	if errors.Is(err, agent.ErrAgentDisabled) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, agent.ErrBudgetExceeded) {
+		s.sendError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.sendResponse(w, result)
+}
+
+// handleExecutePlan executes an approved plan. Body: plan, exactly as
+// generated, the token returned when its approval was approved, and an
+// optional workspace_dir, which must be that of the plan. A plan stopped at
+// a blast-radius limit answers 409 with the approval to continue it.
+func (s *Server) handleExecutePlan(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Plan == "" || req.Token == "" {
+		s.sendError(w, "plan and token are required", http.StatusBadRequest)
+		return
+	}
+
+	s.awaitUser(w, req)
+	results, err := s.agentSystem.ExecutePlan(s.taskContext(r, req), req.Plan, req.Token, req.WorkspaceDir)
+	var paused *agent.BlastRadiusError
+	if errors.As(err, &paused) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Data: map[string]interface{}{
+				"results":     results,
+				"paused":      paused.Reason,
+				"approval_id": paused.ApprovalID,
+			},
+			Error: paused.Error(),
+		})
+		return
+	}
+	if errors.Is(err, agent.ErrPlanToken) || errors.Is(err, agent.ErrOutsideTenant) || errors.Is(err, agent.ErrScopeDenied) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, agent.ErrAgentDisabled) {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, agent.ErrBudgetExceeded) {
+		s.sendError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+	s.sendJSON(w, Response{
+		Success: success,
+		Data:    map[string]interface{}{"results": results},
+	})
+}
+
+// handleListApprovals lists the approval requests of the caller's tasks
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+	approvals := []*agent.Approval{}
+	for _, approval := range s.agentSystem.Approvals().List() {
+		if s.agentSystem.OwnsTask(ctx, approval.TaskID) {
+			approvals = append(approvals, approval)
+		}
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"approvals": approvals},
+	})
+}
+
+// handleDecideApproval approves or rejects a pending approval. Approved
+// commands are executed by resubmitting /run with the approval_id.
+func (s *Server) handleDecideApproval(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if pending, ok := s.agentSystem.Approvals().Get(id); ok && !s.agentSystem.OwnsTask(callerContext(r), pending.TaskID) {
+			s.sendError(w, "approval not found", http.StatusNotFound)
+			return
+		}
+		approval, token, expires, err := s.agentSystem.DecideApproval(id, approve)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data := map[string]interface{}{"approval": approval}
+		if token != "" {
+			data["execution_token"] = token
+			data["expires_at"] = expires
+		}
+		s.sendJSON(w, Response{Success: true, Data: data})
+	}
+}
+
+// handleListProcesses lists managed background processes
+func (s *Server) handleListProcesses(w http.ResponseWriter, r *http.Request) {
+	if err := agent.RequireScope(r.Context(), agent.ScopeTerminal); err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	processes := []*agent.ManagedProcess{}
+	for _, proc := range s.agentSystem.Processes().List() {
+		if processVisible(r, proc) {
+			processes = append(processes, proc)
+		}
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"processes": processes},
+	})
+}
+
+// handleGetProcess returns a single background process
+func (s *Server) handleGetProcess(w http.ResponseWriter, r *http.Request) {
+	if err := agent.RequireScope(r.Context(), agent.ScopeTerminal); err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	proc, ok := s.agentSystem.Processes().Get(mux.Vars(r)["id"])
+	if !ok || !processVisible(r, proc) {
+		s.sendError(w, "Process not found", http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"process": proc},
+	})
+}
+
+// handleProcessLogs returns the captured logs of a background process.
+// The optional tail query parameter limits the number of lines.
+func (s *Server) handleProcessLogs(w http.ResponseWriter, r *http.Request) {
+	if err := agent.RequireScope(r.Context(), agent.ScopeTerminal); err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if proc, ok := s.agentSystem.Processes().Get(id); !ok || !processVisible(r, proc) {
+		s.sendError(w, fmt.Sprintf("process %s not found", id), http.StatusNotFound)
+		return
+	}
+	tail, _ := strconv.Atoi(r.URL.Query().Get("tail"))
+	logs, err := s.agentSystem.Processes().Logs(id, tail)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"logs": logs},
+	})
+}
+
+// handleStopProcess stops a background process
+func (s *Server) handleStopProcess(w http.ResponseWriter, r *http.Request) {
+	if err := agent.RequireScope(r.Context(), agent.ScopeTerminal); err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if proc, ok := s.agentSystem.Processes().Get(id); ok && !processVisible(r, proc) {
+		s.sendError(w, fmt.Sprintf("process %s not found", id), http.StatusBadRequest)
+		return
+	}
+	if err := s.agentSystem.Processes().Stop(id); err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"id": id, "stopped": true},
+	})
+}
+
+// processVisible reports whether the caller may see proc. A caller known
+// by its API key or X-Spilot-User only sees the processes it started; the
+// commands and logs of others may hold their secrets.
+func processVisible(r *http.Request, proc *agent.ManagedProcess) bool {
+	if agent.APIKeyFrom(r.Context()) == nil && r.Header.Get("X-Spilot-User") == "" {
+		return true
+	}
+	return proc.Requester == requester(r)
+}
+
+// taskContext returns the request context, carrying the requester, the
+// client-chosen task ID and the session if any
+func (s *Server) taskContext(r *http.Request, req Request) context.Context {
+	ctx := callerContext(r)
+	// Fix the task ID here so the access log and the X-Task-ID header can
+	// report it
+	taskID := req.TaskID
+	if taskID == "" {
+		taskID = agent.NewTaskID()
+	}
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	noteTaskID(ctx, taskID)
+	if req.SessionID != "" {
+		ctx = agent.ContextWithSession(ctx, req.SessionID)
+	}
+	if req.ConfirmWrites {
+		ctx = agent.ContextWithWriteConfirmation(ctx)
+	}
+	return withTicket(ctx, req)
+}
+
+// withTicket links the plan of a request to the issue it names, or to a
+// new one if it asks for one
+func withTicket(ctx context.Context, req Request) context.Context {
+	if req.Ticket != "" {
+		return agent.ContextWithTicket(ctx, req.Ticket)
+	}
+	if req.CreateTicket {
+		return agent.ContextCreatingTicket(ctx)
+	}
+	return ctx
+}
+
+// awaitUser lifts the write timeout of requests confirming their writes,
+// since they wait for the user as long as it takes
+func (s *Server) awaitUser(w http.ResponseWriter, req Request) {
+	if !req.ConfirmWrites {
+		return
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Warn("Failed to clear write deadline for confirmed request", zap.Error(err))
+	}
+}
+
+// workspaceParam resolves a workspace_dir parameter, "." by default. When
+// users are isolated it must be in the requester's workspace root, their
+// root by default; otherwise a 403 is sent and ok is false.
+func (s *Server) workspaceParam(w http.ResponseWriter, r *http.Request, dir string) (workspaceDir string, ok bool) {
+	if s.agentSystem.Tenancy() == nil {
+		if dir == "" {
+			dir = "."
+		}
+		return dir, true
+	}
+	workspaceDir, err := s.agentSystem.TenantWorkspace(callerContext(r), dir)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusForbidden)
+		return "", false
+	}
+	return workspaceDir, true
+}
+
+// scopedRequester returns the requester whose records a history query may
+// return: the caller when users are isolated, otherwise the requester query
+// parameter
+func (s *Server) scopedRequester(r *http.Request) string {
+	if s.agentSystem.Tenancy() != nil {
+		return requester(r)
+	}
+	return r.URL.Query().Get("requester")
+}
+
+// callerContext returns the request context carrying the requester
+func callerContext(r *http.Request) context.Context {
+	return agent.ContextWithRequester(r.Context(), requester(r))
+}
+
+// requester identifies the caller for auditing: the owner of the API key
+// the request was made with, the X-Spilot-User header if set (never under
+// a tenancy), otherwise the remote address
+func requester(r *http.Request) string {
+	if key := agent.APIKeyFrom(r.Context()); key != nil {
+		return key.Requester()
+	}
+	if user := r.Header.Get("X-Spilot-User"); user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// handleUsage reports LLM token usage and cost. Query parameters: task_id,
+// session_id, requester, workspace, model, since and until (RFC 3339), and
+// group_by (model, task, session, requester, workspace or day).
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := agent.UsageFilter{
+		TaskID:    query.Get("task_id"),
+		SessionID: query.Get("session_id"),
+		Requester: s.scopedRequester(r),
+		Workspace: query.Get("workspace"),
+		Model:     query.Get("model"),
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				s.sendError(w, "Invalid "+name+" timestamp", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	report, err := s.agentSystem.Usage().Report(filter, query.Get("group_by"))
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"usage": report},
+	})
+}
+
+// handleModelLatency reports the rolling latency of the models
+// interactive requests are routed between, and where each goes now
+func (s *Server) handleModelLatency(w http.ResponseWriter, r *http.Request) {
+	routing := s.agentSystem.ModelRouting()
+	if routing == nil {
+		s.sendError(w, "The LLM client does not route by latency", http.StatusNotImplemented)
+		return
+	}
+	s.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"models": routing.Models, "routes": routing.Routes}})
+}
+
+// handleSpend reports how much of each spend budget is used. When users
+// are isolated, budgets of other requesters are left out.
+func (s *Server) handleSpend(w http.ResponseWriter, r *http.Request) {
+	budgets := s.agentSystem.SpendStatus()
+	if s.agentSystem.Tenancy() != nil {
+		caller := requester(r)
+		mine := []agent.BudgetStatus{}
+		for _, budget := range budgets {
+			if budget.Requester == "" || budget.Requester == caller {
+				mine = append(mine, budget)
+			}
+		}
+		budgets = mine
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"budgets": budgets},
+	})
+}
+
+// handleCommandAudit queries the command audit log. Query parameters:
+// task_id, requester, since (RFC 3339) and limit.
+func (s *Server) handleCommandAudit(w http.ResponseWriter, r *http.Request) {
+	auditLog := s.agentSystem.AuditLog()
+	if auditLog == nil {
+		s.sendError(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := agent.AuditFilter{
+		TaskID:    query.Get("task_id"),
+		Requester: s.scopedRequester(r),
+		Limit:     100,
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			s.sendError(w, "Invalid since timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+	}
+
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"entries": entries},
+	})
+}
+
+// handleEvents queries the log of agent actions. Query parameters: type,
+// task_id (including sub-tasks), requester, since and until (RFC 3339),
+// after (a sequence number, to page or poll) and limit.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	eventLog := s.agentSystem.EventLog()
+	if eventLog == nil {
+		s.sendError(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := agent.EventFilter{
+		Type:      agent.ActionType(query.Get("type")),
+		TaskID:    query.Get("task_id"),
+		Requester: s.scopedRequester(r),
+		Limit:     100,
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				s.sendError(w, "Invalid "+name+" timestamp", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if after := query.Get("after"); after != "" {
+		seq, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			s.sendError(w, "Invalid after sequence number", http.StatusBadRequest)
+			return
+		}
+		filter.After = seq
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+	}
+
+	events, err := eventLog.Query(filter)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"events": events},
+	})
+}
+
+// handleIndexStatus reports the state of the codebase index
+func (s *Server) handleIndexStatus(w http.ResponseWriter, r *http.Request) {
+	index := s.agentSystem.CodeIndex()
+	if index == nil {
+		s.sendError(w, "Codebase index is disabled", http.StatusNotFound)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"index": index.Status()},
+	})
+}
+
+// handleReindex brings the codebase index up to date
+func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
+	index := s.agentSystem.CodeIndex()
+	if index == nil {
+		s.sendError(w, "Codebase index is disabled", http.StatusNotFound)
+		return
+	}
+	stats, err := index.Index(r.Context())
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"stats": stats, "index": index.Status()},
+	})
+}
+
+// handleIndexSearch finds the indexed code most relevant to q. Query
+// parameters: q and k (default 10).
+func (s *Server) handleIndexSearch(w http.ResponseWriter, r *http.Request) {
+	index := s.agentSystem.CodeIndex()
+	if index == nil {
+		s.sendError(w, "Codebase index is disabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		s.sendError(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	k, _ := strconv.Atoi(r.URL.Query().Get("k"))
+	chunks, err := index.Search(r.Context(), query, k)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{
+		Success: true,
+		Data:    map[string]interface{}{"chunks": chunks},
+	})
+}
+
+// handleProfile returns the detected profile of the workspace_dir query
+// parameter, re-detecting it first when refresh is set
+func (s *Server) handleProfile(refresh bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspaceDir, ok := s.workspaceParam(w, r, r.URL.Query().Get("workspace_dir"))
+		if !ok {
+			return
+		}
+		profiles := s.agentSystem.Profiles()
+		get := profiles.Get
+		if refresh {
+			get = profiles.Refresh
+		}
+		profile, err := get(workspaceDir)
+		if err != nil {
+			s.sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.sendJSON(w, Response{
+			Success: true,
+			Data:    map[string]interface{}{"profile": profile},
+		})
+	}
+}
+
+// handleSymbols looks up symbols in a workspace. Query parameters: q (a
+// name, optionally qualified as Type.Method), workspace_dir, kind, limit
+// (default 50) and references=true to include the uses of exact matches.
+// Names without an exact match return symbols containing q.
+func (s *Server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("q")
+	if name == "" {
+		s.sendError(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	workspaceDir, ok := s.workspaceParam(w, r, query.Get("workspace_dir"))
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+
+	index, err := s.agentSystem.Symbols().Get(r.Context(), workspaceDir)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	kind := query.Get("kind")
+	var exact []agent.Symbol
+	for _, symbol := range index.Lookup(name) {
+		if kind == "" || symbol.Kind == kind {
+			exact = append(exact, symbol)
+		}
+	}
+	if len(exact) == 0 {
+		s.sendJSON(w, Response{
+			Success: true,
+			Data:    map[string]interface{}{"symbols": index.Find(name, kind, limit), "exact": false},
+		})
+		return
+	}
+
+	data := map[string]interface{}{"symbols": exact, "exact": true}
+	if query.Get("references") == "true" {
+		references := []agent.SymbolReference{}
+		for _, symbol := range exact {
+			refs, err := index.References(r.Context(), symbol, limit)
+			if err != nil {
+				s.sendError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			references = append(references, refs...)
+		}
+		data["references"] = references
+	}
+	s.sendJSON(w, Response{Success: true, Data: data})
+}
+
+// sendResponse sends a task result as a response. It is written as the
+// envelope, with the kind of its data and its usage besides.
+func (s *Server) sendResponse(w http.ResponseWriter, result *agent.TaskResult) {
+	s.sendJSON(w, result)
 }
 
 // sendError sends an error response
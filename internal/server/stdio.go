@@ -0,0 +1,438 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"spilot-agent/internal/agent"
+
+	"go.uber.org/zap"
+)
+
+// maxStdioMessageBytes bounds a single JSON-RPC message from the editor
+const maxStdioMessageBytes = 16 << 20
+
+// stdioRequester is the requester audited for requests over stdio
+const stdioRequester = "stdio"
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// rpcRequestFailed is for requests the agent refused or failed
+	rpcRequestFailed = -32000
+	// rpcForbidden is for requests outside the caller's workspace or scopes
+	rpcForbidden = -32001
+	// rpcCancelled is for requests ended by a "cancel" notification
+	rpcCancelled = -32800
+)
+
+type rpcMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcMethod handles the params of a request and returns its result
+type rpcMethod func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// rpcConn is one JSON-RPC connection with an editor
+type rpcConn struct {
+	server *Server
+	out    io.Writer
+	// framed sends messages with LSP Content-Length headers instead of one
+	// per line
+	framed  bool
+	methods map[string]rpcMethod
+	// notifications handle the messages without ID
+	notifications map[string]func(params json.RawMessage)
+	// serial are the methods answered before the next message is read,
+	// for requests others depend on
+	serial map[string]bool
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	// running cancels the requests in flight, by their raw ID
+	running map[string]context.CancelFunc
+}
+
+// ServeStdio serves the agent as JSON-RPC 2.0 over in and out, one message
+// per line, for editors running it as a child process instead of
+// connecting to a port. Methods take the same fields as the HTTP API:
+//
+//   - "process", "command", "chat", "agentTask" and "executePlan" run
+//     requests like /api/process, /api/command, /api/chat,
+//     /api/agents/{type}/tasks (with "type") and /api/plans/execute
+//   - "approvals" lists approvals; "approve" and "reject" take {"id"}
+//   - "createSession" takes {"title", "workspace_dir"}; "agents" lists agents
+//   - the "cancel" notification {"id": <request id>} aborts a request
+//
+// Task events are sent as "event" notifications. Requests run
+// concurrently; ServeStdio returns when in ends or ctx is done.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(agent.ContextWithRequester(ctx, stdioRequester))
+	defer cancel()
+	conn := newRPCConn(s, out, s.stdioMethods())
+	conn.notifications["cancel"] = conn.cancelParams
+
+	events, unsubscribe := s.agentSystem.Events().Subscribe("")
+	defer unsubscribe()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				conn.write(rpcMessage{JSONRPC: "2.0", Method: "event", Params: mustMarshal(event)})
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxStdioMessageBytes)
+	return conn.serve(ctx, func() ([]byte, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return append([]byte(nil), scanner.Bytes()...), nil
+	})
+}
+
+func newRPCConn(s *Server, out io.Writer, methods map[string]rpcMethod) *rpcConn {
+	return &rpcConn{
+		server:        s,
+		out:           out,
+		methods:       methods,
+		notifications: make(map[string]func(json.RawMessage)),
+		running:       make(map[string]context.CancelFunc),
+	}
+}
+
+// serve handles the messages returned by read until it fails or ctx is
+// done. The end of input, io.EOF, is not an error.
+func (c *rpcConn) serve(ctx context.Context, read func() ([]byte, error)) error {
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			message, err := read()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer c.cancelAll()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case message := <-messages:
+			if len(message) > 0 {
+				c.dispatch(ctx, message, &wg)
+			}
+		}
+	}
+}
+
+// dispatch handles one message, starting requests in goroutines tracked by
+// wg. Requests are registered before the next message is read, so a
+// cancellation right after a request finds it.
+func (c *rpcConn) dispatch(ctx context.Context, line []byte, wg *sync.WaitGroup) {
+	var msg rpcMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		c.write(rpcMessage{JSONRPC: "2.0", ID: rawNull(), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+		return
+	}
+	if msg.ID == nil {
+		if notify, ok := c.notifications[msg.Method]; ok {
+			notify(msg.Params)
+		}
+		return
+	}
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		c.write(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}})
+		return
+	}
+	method, ok := c.methods[msg.Method]
+	if !ok {
+		c.write(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + msg.Method}})
+		return
+	}
+
+	id := string(*msg.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.running[id] = cancel
+	c.mu.Unlock()
+
+	respond := func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.running, id)
+			c.mu.Unlock()
+			cancel()
+		}()
+
+		result, err := method(ctx, msg.Params)
+		if err != nil {
+			c.write(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: c.server.rpcErrorFor(ctx, err)})
+			return
+		}
+		if result == nil {
+			result = struct{}{}
+		}
+		c.write(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+	if c.serial[msg.Method] {
+		respond()
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		respond()
+	}()
+}
+
+// cancelParams cancels the request {"id"}
+func (c *rpcConn) cancelParams(params json.RawMessage) {
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(params, &req) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.running[string(req.ID)]; ok {
+		cancel()
+	}
+}
+
+func (c *rpcConn) cancelAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cancel := range c.running {
+		cancel()
+	}
+}
+
+// write sends one message
+func (c *rpcConn) write(msg rpcMessage) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		c.server.logger.Error("Failed to encode JSON-RPC message", zap.Error(err))
+		return
+	}
+	if c.framed {
+		line = append([]byte(fmt.Sprintf("Content-Length: %d\r\n\r\n", len(line))), line...)
+	} else {
+		line = append(line, '\n')
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.out.Write(line); err != nil {
+		c.server.logger.Warn("Failed to write JSON-RPC message", zap.Error(err))
+	}
+}
+
+// rpcErrorFor maps an error to its JSON-RPC error, as the HTTP handlers
+// map errors to status codes
+func (s *Server) rpcErrorFor(ctx context.Context, err error) *rpcError {
+	var params *rpcParamsError
+	switch {
+	case errors.As(err, &params):
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	case ctx.Err() == context.Canceled:
+		return &rpcError{Code: rpcCancelled, Message: "request cancelled"}
+	case errors.Is(err, agent.ErrOutsideTenant), errors.Is(err, agent.ErrScopeDenied),
+		errors.Is(err, agent.ErrAgentDisabled), errors.Is(err, agent.ErrPlanToken):
+		return &rpcError{Code: rpcForbidden, Message: err.Error()}
+	case errors.Is(err, agent.ErrBudgetExceeded), errors.Is(err, agent.ErrSessionNotFound),
+		errors.Is(err, agent.ErrBlastRadius), errors.Is(err, agent.ErrTicket):
+		return &rpcError{Code: rpcRequestFailed, Message: err.Error()}
+	}
+	s.logger.Warn("JSON-RPC request failed", zap.Error(err))
+	return &rpcError{Code: rpcInternalError, Message: err.Error()}
+}
+
+// rpcParamsError reports params that do not fit the method
+type rpcParamsError struct{ err error }
+
+func (e *rpcParamsError) Error() string { return "invalid params: " + e.err.Error() }
+
+// stdioRequest decodes params as a Request and returns the context its
+// task runs in
+func stdioRequest(ctx context.Context, params json.RawMessage) (Request, context.Context, error) {
+	var req Request
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &req); err != nil {
+			return req, ctx, &rpcParamsError{err}
+		}
+	}
+	taskID := req.TaskID
+	if taskID == "" {
+		taskID = agent.NewTaskID()
+	}
+	ctx = agent.ContextWithTaskID(ctx, taskID)
+	if req.SessionID != "" {
+		ctx = agent.ContextWithSession(ctx, req.SessionID)
+	}
+	if req.ConfirmWrites {
+		ctx = agent.ContextWithWriteConfirmation(ctx)
+	}
+	return req, withTicket(ctx, req), nil
+}
+
+// stdioMethods are the JSON-RPC methods of ServeStdio
+func (s *Server) stdioMethods() map[string]rpcMethod {
+	return map[string]rpcMethod{
+		"process": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			if req.Model != "" {
+				s.agentSystem.SetModel(req.Model)
+			}
+			return s.agentSystem.ProcessUserRequest(ctx, req.Request, req.WorkspaceDir)
+		},
+		"command": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			return s.agentSystem.HandleCommand(ctx, req.Command, req.Args, req.WorkspaceDir, req.Data)
+		},
+		"agentTask": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			return s.agentSystem.RunAgentTask(ctx, agent.AgentType(req.Type), req.WorkspaceDir, req.Data)
+		},
+		"chat": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			message := req.Message
+			if message == "" {
+				message = req.Request
+			}
+			if message == "" {
+				return nil, &rpcParamsError{errors.New("message is required")}
+			}
+			reply, err := s.agentSystem.Chat(ctx, message)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"message": reply, "session_id": req.SessionID}, nil
+		},
+		"executePlan": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			if req.Plan == "" || req.Token == "" {
+				return nil, &rpcParamsError{errors.New("plan and token are required")}
+			}
+			results, err := s.agentSystem.ExecutePlan(ctx, req.Plan, req.Token, req.WorkspaceDir)
+			var paused *agent.BlastRadiusError
+			if errors.As(err, &paused) {
+				return map[string]interface{}{"results": results, "paused": paused.Reason, "approval_id": paused.ApprovalID}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"results": results}, nil
+		},
+		"approvals": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"approvals": s.agentSystem.Approvals().List()}, nil
+		},
+		"approve": s.stdioDecide(true),
+		"reject":  s.stdioDecide(false),
+		"createSession": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			req, ctx, err := stdioRequest(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			session, err := s.agentSystem.CreateSession(ctx, req.Title, req.WorkspaceDir)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"session": session}, nil
+		},
+		"agents": func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"agents": s.agentSystem.Agents()}, nil
+		},
+	}
+}
+
+// stdioDecide approves or rejects the approval {"id"}
+func (s *Server) stdioDecide(approve bool) rpcMethod {
+	return func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(params, &req); err != nil || req.ID == "" {
+			return nil, &rpcParamsError{errors.New("id is required")}
+		}
+		approval, token, expires, err := s.agentSystem.DecideApproval(req.ID, approve)
+		if err != nil {
+			return nil, &rpcParamsError{err}
+		}
+		data := map[string]interface{}{"approval": approval}
+		if token != "" {
+			data["execution_token"] = token
+			data["expires_at"] = expires
+		}
+		return data, nil
+	}
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(fmt.Sprintf("%q", err.Error()))
+	}
+	return data
+}
+
+func rawNull() *json.RawMessage {
+	null := json.RawMessage("null")
+	return &null
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// telemetryMiddleware counts the requests to each endpoint, and those that
+// failed with a server error, for usage telemetry. Endpoints are named by
+// their route template, never by the path requested; health probes are
+// not counted.
+func (s *Server) telemetryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		s.agentSystem.Telemetry().Count("api."+r.Method+" "+template, recorder.statusCode() >= 500)
+	})
+}
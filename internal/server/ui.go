@@ -0,0 +1,41 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// uiFiles is the embedded web UI: chat, task history with the changes of
+// each task, and approvals, for users without an editor extension
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiPath serves the UI's assets
+const uiPath = "/ui/"
+
+// isUIPath reports whether path is the UI's page or one of its assets,
+// which hold no data and are served without an API key
+func isUIPath(path string) bool {
+	return path == "/" || strings.HasPrefix(path, uiPath)
+}
+
+// handleUI serves the UI's page
+func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
+	page, err := uiFiles.ReadFile("ui/index.html")
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	w.Write(page)
+}
+
+// uiAssets serves the UI's scripts and styles
+func uiAssets() http.Handler {
+	assets, _ := fs.Sub(uiFiles, "ui")
+	return http.StripPrefix(uiPath, http.FileServer(http.FS(assets)))
+}
@@ -0,0 +1,394 @@
+// The embedded UI: chat sessions, plans to approve and run, task history
+// with the changes each task made, and pending approvals. It talks to the
+// same API as the other clients; nothing here is privileged.
+'use strict';
+
+const state = {
+  apiKey: localStorage.getItem('spilot.apiKey') || '',
+  workspace: localStorage.getItem('spilot.workspace') || '',
+  session: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+function el(tag, props, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props || {});
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      node.append(child);
+    }
+  }
+  return node;
+}
+
+function headers(json) {
+  const h = {};
+  if (json) {
+    h['Content-Type'] = 'application/json';
+  }
+  if (state.apiKey) {
+    h['X-API-Key'] = state.apiKey;
+  }
+  return h;
+}
+
+// api calls the agent API and returns the response's data, throwing its
+// error
+async function api(method, path, body) {
+  const res = await fetch(path, {
+    method,
+    headers: headers(body !== undefined),
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const payload = await res.json().catch(() => ({}));
+  if (!res.ok || payload.success === false) {
+    const err = new Error(payload.error || `${res.status} ${res.statusText}`);
+    err.data = payload.data;
+    throw err;
+  }
+  return payload.data || {};
+}
+
+async function graphql(query, variables) {
+  const res = await fetch('/api/graphql', {
+    method: 'POST',
+    headers: headers(true),
+    body: JSON.stringify({ query, variables }),
+  });
+  const payload = await res.json();
+  if (payload.errors && payload.errors.length) {
+    throw new Error(payload.errors[0].message);
+  }
+  return payload.data;
+}
+
+function setStatus(text) {
+  $('status').textContent = text;
+}
+
+// resultText picks the text a result is best shown as
+function resultText(data) {
+  for (const field of ['message', 'response', 'answer', 'explanation', 'summary', 'analysis']) {
+    if (typeof data[field] === 'string' && data[field]) {
+      return data[field];
+    }
+  }
+  return JSON.stringify(data, null, 2);
+}
+
+// Chat
+
+function addMessage(role, text) {
+  const node = el('div', { className: `message ${role}` }, text);
+  $('messages').append(node);
+  node.scrollIntoView({ block: 'end' });
+  return node;
+}
+
+async function loadSessions() {
+  const list = $('sessions');
+  try {
+    const { sessions } = await api('GET', '/api/sessions');
+    list.replaceChildren(...sessions.map((session) => {
+      const item = el('li', { title: session.workspace_dir || '' },
+        session.title || session.id,
+        el('small', {}, `${session.messages} messages, ${new Date(session.updated_at).toLocaleString()}`));
+      item.classList.toggle('active', state.session === session.id);
+      item.onclick = () => openSession(session.id);
+      return item;
+    }));
+  } catch (err) {
+    list.replaceChildren(el('li', { className: 'empty' }, err.message));
+  }
+}
+
+async function openSession(id) {
+  state.session = id;
+  const { session } = await api('GET', `/api/sessions/${encodeURIComponent(id)}`);
+  if (session.workspace_dir) {
+    $('workspace').value = session.workspace_dir;
+  }
+  $('messages').replaceChildren();
+  for (const message of session.messages) {
+    addMessage(message.role === 'user' ? 'user' : 'assistant', message.content);
+  }
+  loadSessions();
+}
+
+async function newSession() {
+  const { session } = await api('POST', '/api/sessions', { workspace_dir: workspace() });
+  state.session = session.id;
+  $('messages').replaceChildren();
+  loadSessions();
+}
+
+function workspace() {
+  return $('workspace').value.trim() || undefined;
+}
+
+async function send(event) {
+  event.preventDefault();
+  const input = $('input');
+  const text = input.value.trim();
+  if (!text) {
+    return;
+  }
+  input.value = '';
+  addMessage('user', text);
+  const pending = addMessage('assistant', '…');
+  try {
+    // Conversations are kept as sessions, so they can be reopened
+    if (!state.session) {
+      const { session } = await api('POST', '/api/sessions', { title: text.slice(0, 60), workspace_dir: workspace() });
+      state.session = session.id;
+    }
+    if ($('mode').value === 'run') {
+      await run(text, pending);
+    } else {
+      const data = await api('POST', '/api/chat', { message: text, session_id: state.session || undefined, workspace_dir: workspace() });
+      pending.textContent = data.message;
+    }
+  } catch (err) {
+    pending.className = 'message error';
+    pending.textContent = err.message;
+  }
+  loadSessions();
+}
+
+// run has the planning agent handle a request. Plans are shown with a
+// button approving and executing them.
+async function run(request, node) {
+  const ticket = $('ticket').value.trim() || undefined;
+  const data = await api('POST', '/api/process', { request, ticket, session_id: state.session || undefined, workspace_dir: workspace() });
+  if (!data.plan || !data.approval_id) {
+    node.textContent = resultText(data);
+    return;
+  }
+  const approve = el('button', { className: 'primary', type: 'button' }, 'Approve and run');
+  const reject = el('button', { type: 'button' }, 'Reject');
+  const linked = data.ticket ? `Linked to ${data.ticket}` : null;
+  node.replaceChildren(data.plan, el('div', { className: 'actions' }, approve, reject, linked));
+  reject.onclick = async () => {
+    await api('POST', `/api/approvals/${encodeURIComponent(data.approval_id)}/reject`);
+    node.replaceChildren(data.plan, el('div', { className: 'actions' }, 'Rejected'));
+  };
+  approve.onclick = async () => {
+    approve.disabled = reject.disabled = true;
+    const output = addMessage('assistant', 'Running the plan…');
+    try {
+      const decision = await api('POST', `/api/approvals/${encodeURIComponent(data.approval_id)}/approve`);
+      const result = await api('POST', '/api/plans/execute', { plan: data.plan, token: decision.execution_token, workspace_dir: workspace() });
+      output.textContent = result.results.map((step, i) =>
+        `${i + 1}. ${step.success ? 'done' : 'failed'}${step.error ? ': ' + step.error : ''}`).join('\n');
+    } catch (err) {
+      output.className = 'message error';
+      output.textContent = err.message + (err.data && err.data.paused ? '\nApprove the pending blast-radius request to continue.' : '');
+    }
+    loadTasks();
+  };
+}
+
+// Tasks and their changes
+
+async function loadTasks() {
+  const list = $('tasks');
+  try {
+    const { tasks } = await graphql('{ tasks(limit: 100) { id agent description createdAt result { success error } } }');
+    if (!tasks.length) {
+      list.replaceChildren(el('li', { className: 'empty' }, 'No tasks yet.'));
+      return;
+    }
+    list.replaceChildren(...tasks.map((task) => {
+      const failed = task.result && !task.result.success;
+      const item = el('li', { className: failed ? 'failed' : '', title: task.description || '' },
+        task.description || task.id,
+        el('small', {}, `${task.agent} · ${new Date(task.createdAt).toLocaleString()}${failed ? ' · failed' : ''}`));
+      item.onclick = () => {
+        for (const other of list.children) {
+          other.classList.remove('active');
+        }
+        item.classList.add('active');
+        showTask(task.id);
+      };
+      return item;
+    }));
+  } catch (err) {
+    list.replaceChildren(el('li', { className: 'empty' }, err.message));
+  }
+}
+
+async function showTask(id) {
+  const detail = $('detail');
+  detail.replaceChildren(el('p', { className: 'empty' }, 'Loading…'));
+  try {
+    const { task } = await graphql(`query ($id: ID!) {
+      task(id: $id) { id description result { success error } diffs commands { command exitCode output } }
+    }`, { id });
+    $('detail-title').textContent = task.description || task.id;
+    const parts = [];
+    if (task.result && task.result.error) {
+      parts.push(el('p', {}, task.result.error));
+    }
+    for (const diff of task.diffs || []) {
+      parts.push(renderDiff(diff));
+    }
+    for (const command of task.commands || []) {
+      parts.push(el('p', {}, `$ ${command.command} (exit ${command.exitCode})`));
+      if (command.output) {
+        parts.push(el('pre', { className: 'output' }, command.output));
+      }
+    }
+    detail.replaceChildren(...(parts.length ? parts : [el('p', { className: 'empty' }, 'The task changed nothing.')]));
+  } catch (err) {
+    detail.replaceChildren(el('p', { className: 'empty' }, err.message));
+  }
+}
+
+function renderDiff(diff) {
+  const node = el('div', { className: 'diff' });
+  for (const line of diff.split('\n')) {
+    let kind = '';
+    if (line.startsWith('+++') || line.startsWith('---') || line.startsWith('diff ')) {
+      kind = 'file';
+    } else if (line.startsWith('@@')) {
+      kind = 'hunk';
+    } else if (line.startsWith('+')) {
+      kind = 'add';
+    } else if (line.startsWith('-')) {
+      kind = 'del';
+    }
+    node.append(el('div', { className: kind }, line || ' '));
+  }
+  return node;
+}
+
+// Approvals
+
+async function loadApprovals() {
+  const box = $('approvals');
+  try {
+    const { approvals } = await api('GET', '/api/approvals');
+    const pending = approvals.filter((approval) => approval.status === 'pending');
+    if (!pending.length) {
+      box.replaceChildren(el('p', { className: 'empty' }, 'Nothing waits for approval.'));
+      return;
+    }
+    box.replaceChildren(...pending.map((approval) => {
+      const approve = el('button', { className: 'primary', type: 'button' }, 'Approve');
+      const reject = el('button', { type: 'button' }, 'Reject');
+      approve.onclick = () => decide(approval.id, 'approve');
+      reject.onclick = () => decide(approval.id, 'reject');
+      const data = approval.data || {};
+      return el('div', { className: 'approval' },
+        el('strong', {}, approval.kind), ' ', approval.subject,
+        data.diff ? renderDiff(data.diff) : null,
+        el('div', { className: 'actions' }, approve, reject));
+    }));
+  } catch (err) {
+    box.replaceChildren(el('p', { className: 'empty' }, err.message));
+  }
+}
+
+async function decide(id, decision) {
+  try {
+    await api('POST', `/api/approvals/${encodeURIComponent(id)}/${decision}`);
+  } catch (err) {
+    setStatus(err.message);
+  }
+  loadApprovals();
+}
+
+// Events keep the approvals and the task history current. fetch is used
+// rather than EventSource so the API key can be sent.
+async function watchEvents() {
+  for (;;) {
+    try {
+      const res = await fetch('/api/tasks/events', { headers: headers(false) });
+      if (!res.ok) {
+        throw new Error(`${res.status} ${res.statusText}`);
+      }
+      setStatus('Connected');
+      const reader = res.body.getReader();
+      const decoder = new TextDecoder();
+      let buffer = '';
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) {
+          break;
+        }
+        buffer += decoder.decode(value, { stream: true });
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          const block = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          const data = block.split('\n').filter((line) => line.startsWith('data:')).map((line) => line.slice(5)).join('\n');
+          if (data) {
+            onEvent(JSON.parse(data));
+          }
+        }
+      }
+    } catch (err) {
+      setStatus(`Disconnected: ${err.message}`);
+    }
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+  }
+}
+
+function onEvent(event) {
+  switch (event.type) {
+    case 'task_started':
+      setStatus(`${event.data.agent}: ${event.data.description}`);
+      break;
+    case 'approval_required':
+      loadApprovals();
+      break;
+    case 'task_completed':
+    case 'task_failed':
+      setStatus('Connected');
+      if (!event.task_id.includes('.')) {
+        loadTasks();
+      }
+      break;
+  }
+}
+
+function init() {
+  $('workspace').value = state.workspace;
+  $('workspace').onchange = () => localStorage.setItem('spilot.workspace', $('workspace').value.trim());
+  $('set-key').onclick = () => {
+    const key = prompt('API key (leave empty if the server needs none)', state.apiKey);
+    if (key !== null) {
+      state.apiKey = key.trim();
+      localStorage.setItem('spilot.apiKey', state.apiKey);
+      refresh();
+    }
+  };
+  for (const tab of document.querySelectorAll('.tabs button')) {
+    tab.onclick = () => {
+      for (const other of document.querySelectorAll('.tabs button')) {
+        other.classList.toggle('active', other === tab);
+      }
+      $('sessions-tab').hidden = tab.dataset.tab !== 'sessions';
+      $('tasks-tab').hidden = tab.dataset.tab !== 'tasks';
+    };
+  }
+  $('new-session').onclick = newSession;
+  $('refresh-tasks').onclick = loadTasks;
+  $('composer').onsubmit = send;
+  $('input').onkeydown = (event) => {
+    if (event.key === 'Enter' && (event.metaKey || event.ctrlKey)) {
+      $('composer').requestSubmit();
+    }
+  };
+  refresh();
+  watchEvents();
+}
+
+function refresh() {
+  loadSessions();
+  loadTasks();
+  loadApprovals();
+}
+
+init();
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"spilot-agent/internal/agent"
+
+	"github.com/gorilla/mux"
+)
+
+// handleListWebhooks lists the caller's webhooks, without their secrets
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	s.sendJSON(w, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"webhooks": s.agentSystem.Webhooks(callerContext(r)),
+			"events":   agent.WebhookEvents,
+		},
+	})
+}
+
+// handleRegisterWebhook registers a webhook from {"url", "events",
+// "secret"}; without a secret one is generated. The response is the only
+// time the secret is shown.
+func (s *Server) handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string                `json:"url"`
+		Events []agent.TaskEventType `json:"events"`
+		Secret string                `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	hook, secret, err := s.agentSystem.RegisterWebhook(callerContext(r), req.URL, req.Events, req.Secret)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    map[string]interface{}{"webhook": hook, "secret": secret},
+	})
+}
+
+// handleDeleteWebhook removes one of the caller's webhooks
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := s.agentSystem.DeleteWebhook(callerContext(r), id)
+	if errors.Is(err, agent.ErrWebhookNotFound) {
+		s.sendError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.sendJSON(w, Response{Success: true, Data: map[string]interface{}{"id": id, "deleted": true}})
+}
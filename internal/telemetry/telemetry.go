@@ -0,0 +1,230 @@
+// Package telemetry reports anonymous, aggregate usage: how often each
+// feature is used and how often it fails. It is off unless enabled and
+// never reports code, prompts, paths or other content.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// sendTimeout bounds one report, including the last one on shutdown
+const sendTimeout = 10 * time.Second
+
+// Config enables telemetry. Reports are posted to Endpoint every Interval.
+type Config struct {
+	Enabled  bool
+	Endpoint string
+	Interval time.Duration
+}
+
+// Counts is how often a feature was used and failed
+type Counts struct {
+	Count  int `json:"count"`
+	Errors int `json:"errors"`
+}
+
+// Report is what one interval's report contains, in full
+type Report struct {
+	// InstallID is random and identifies nothing but this installation
+	InstallID   string            `json:"install_id"`
+	Version     string            `json:"version"`
+	GoVersion   string            `json:"go_version"`
+	OS          string            `json:"os"`
+	Arch        string            `json:"arch"`
+	PeriodStart time.Time         `json:"period_start"`
+	PeriodEnd   time.Time         `json:"period_end"`
+	Features    map[string]Counts `json:"features"`
+}
+
+// Reporter counts feature usage and sends it periodically. A nil Reporter,
+// as returned when telemetry is disabled, counts nothing.
+type Reporter struct {
+	cfg       Config
+	installID string
+	client    *http.Client
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	since    time.Time
+	features map[string]Counts
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a reporter, or returns nil if telemetry is disabled. The
+// install ID is kept in dataDir/telemetry/id so reports from one
+// installation can be told apart.
+func New(cfg Config, dataDir string, logger *zap.Logger) *Reporter {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Reporter{
+		cfg:       cfg,
+		installID: installID(dataDir, logger),
+		client:    &http.Client{Timeout: sendTimeout},
+		logger:    logger,
+		since:     time.Now(),
+		features:  make(map[string]Counts),
+	}
+}
+
+// installID reads the install ID from dataDir, creating it the first time
+func installID(dataDir string, logger *zap.Logger) string {
+	if dataDir != "" {
+		path := filepath.Join(dataDir, "telemetry", "id")
+		if data, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(data)) > 0 {
+			return string(bytes.TrimSpace(data))
+		}
+		id := randomID()
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = os.WriteFile(path, []byte(id+"\n"), 0600)
+		}
+		if err != nil {
+			logger.Warn("Failed to store telemetry install ID", zap.Error(err))
+		}
+		return id
+	}
+	return randomID()
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Count records a use of feature, e.g. "agent.debug" or "api.POST
+// /api/process". Feature names must not contain user content.
+func (r *Reporter) Count(feature string, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := r.features[feature]
+	counts.Count++
+	if failed {
+		counts.Errors++
+	}
+	r.features[feature] = counts
+}
+
+// Start sends a report every interval until Close
+func (r *Reporter) Start() {
+	if r == nil {
+		return
+	}
+	r.stop, r.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.flush()
+			case <-r.stop:
+				r.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Close sends what was counted since the last report and stops reporting
+func (r *Reporter) Close() {
+	if r == nil || r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
+
+// flush sends the counts collected so far. Counts that cannot be sent are
+// kept for the next report.
+func (r *Reporter) flush() {
+	r.mu.Lock()
+	report := r.report(time.Now())
+	r.features = make(map[string]Counts)
+	r.since = report.PeriodEnd
+	r.mu.Unlock()
+	if len(report.Features) == 0 {
+		return
+	}
+
+	if err := r.send(report); err != nil {
+		r.logger.Debug("Failed to send telemetry", zap.Error(err))
+		r.mu.Lock()
+		for feature, counts := range report.Features {
+			kept := r.features[feature]
+			kept.Count += counts.Count
+			kept.Errors += counts.Errors
+			r.features[feature] = kept
+		}
+		r.since = report.PeriodStart
+		r.mu.Unlock()
+	}
+}
+
+// report builds the report of the counts so far; r.mu must be held
+func (r *Reporter) report(now time.Time) *Report {
+	return &Report{
+		InstallID:   r.installID,
+		Version:     version(),
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		PeriodStart: r.since,
+		PeriodEnd:   now,
+		Features:    r.features,
+	}
+}
+
+func (r *Reporter) send(report *Report) error {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// version returns the version the binary was built at
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+	return strings.TrimPrefix(info.Main.Version, "v")
+}
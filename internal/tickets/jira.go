@@ -0,0 +1,123 @@
+package tickets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// jira calls version 2 of the Jira REST API, whose texts are wiki markup
+// rather than the documents of version 3, on Jira Cloud or Data Center
+type jira struct {
+	cfg  Config
+	http *http.Client
+}
+
+func (j *jira) Issue(ctx context.Context, key string) (*Issue, error) {
+	var issue struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary     string `json:"summary"`
+			Description string `json:"description"`
+			Status      struct {
+				Name string `json:"name"`
+			} `json:"status"`
+			Comment struct {
+				Comments []struct {
+					Author struct {
+						DisplayName string `json:"displayName"`
+					} `json:"author"`
+					Body string `json:"body"`
+				} `json:"comments"`
+			} `json:"comment"`
+		} `json:"fields"`
+	}
+	path := "/issue/" + url.PathEscape(key) + "?fields=summary,description,status,comment"
+	if err := j.call(ctx, http.MethodGet, path, nil, &issue); err != nil {
+		return nil, err
+	}
+	result := &Issue{
+		Key:         issue.Key,
+		Title:       issue.Fields.Summary,
+		Description: issue.Fields.Description,
+		Status:      issue.Fields.Status.Name,
+		URL:         j.browse(issue.Key),
+	}
+	comments := issue.Fields.Comment.Comments
+	if len(comments) > maxComments {
+		comments = comments[len(comments)-maxComments:]
+	}
+	for _, comment := range comments {
+		result.Comments = append(result.Comments, Comment{Author: comment.Author.DisplayName, Body: comment.Body})
+	}
+	return result, nil
+}
+
+func (j *jira) Create(ctx context.Context, title, description string) (*Issue, error) {
+	if j.cfg.Project == "" {
+		return nil, errors.New("no Jira project to create issues in is configured")
+	}
+	request := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.cfg.Project},
+			"issuetype":   map[string]string{"name": j.cfg.IssueType},
+			"summary":     title,
+			"description": description,
+		},
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := j.call(ctx, http.MethodPost, "/issue", request, &created); err != nil {
+		return nil, err
+	}
+	return &Issue{Key: created.Key, Title: title, Description: description, URL: j.browse(created.Key)}, nil
+}
+
+func (j *jira) Comment(ctx context.Context, key, body string) error {
+	return j.call(ctx, http.MethodPost, "/issue/"+url.PathEscape(key)+"/comment", map[string]string{"body": body}, nil)
+}
+
+// browse returns the web page of the issue with key
+func (j *jira) browse(key string) string {
+	return j.cfg.URL + "/browse/" + key
+}
+
+func (j *jira) call(ctx context.Context, method, path string, body, out interface{}) error {
+	return call(ctx, j.http, method, j.cfg.URL+"/rest/api/2"+path, j.authorize, body, out, describeJiraError)
+}
+
+// authorize uses basic authentication with an API token on Jira Cloud, and
+// a bearer personal access token on Data Center
+func (j *jira) authorize(req *http.Request) {
+	if j.cfg.Email != "" {
+		req.SetBasicAuth(j.cfg.Email, j.cfg.Token)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+j.cfg.Token)
+}
+
+// describeJiraError joins the messages of a Jira error response
+func describeJiraError(body []byte) string {
+	var failure struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(body, &failure) != nil {
+		return strings.TrimSpace(string(body))
+	}
+	messages := failure.ErrorMessages
+	fields := make([]string, 0, len(failure.Errors))
+	for field := range failure.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, field+": "+failure.Errors[field])
+	}
+	return strings.Join(messages, "; ")
+}
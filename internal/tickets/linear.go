@@ -0,0 +1,195 @@
+package tickets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// linearAPI is Linear's GraphQL endpoint
+const linearAPI = "https://api.linear.app/graphql"
+
+// linear calls Linear's GraphQL API, whose texts are Markdown
+type linear struct {
+	cfg  Config
+	http *http.Client
+}
+
+func (l *linear) Issue(ctx context.Context, key string) (*Issue, error) {
+	var data struct {
+		Issue *struct {
+			Identifier  string `json:"identifier"`
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			URL         string `json:"url"`
+			State       struct {
+				Name string `json:"name"`
+			} `json:"state"`
+			Comments struct {
+				Nodes []struct {
+					Body string `json:"body"`
+					User *struct {
+						Name string `json:"name"`
+					} `json:"user"`
+				} `json:"nodes"`
+			} `json:"comments"`
+		} `json:"issue"`
+	}
+	query := `query ($id: String!, $comments: Int!) {
+  issue(id: $id) {
+    identifier title description url
+    state { name }
+    comments(last: $comments) { nodes { body user { name } } }
+  }
+}`
+	if err := l.query(ctx, query, map[string]interface{}{"id": key, "comments": maxComments}, &data); err != nil {
+		return nil, err
+	}
+	if data.Issue == nil {
+		return nil, ErrNotFound
+	}
+	issue := &Issue{
+		Key:         data.Issue.Identifier,
+		Title:       data.Issue.Title,
+		Description: data.Issue.Description,
+		Status:      data.Issue.State.Name,
+		URL:         data.Issue.URL,
+	}
+	for _, comment := range data.Issue.Comments.Nodes {
+		author := "an integration"
+		if comment.User != nil {
+			author = comment.User.Name
+		}
+		issue.Comments = append(issue.Comments, Comment{Author: author, Body: comment.Body})
+	}
+	return issue, nil
+}
+
+func (l *linear) Create(ctx context.Context, title, description string) (*Issue, error) {
+	if l.cfg.Project == "" {
+		return nil, errors.New("no Linear team to create issues in is configured")
+	}
+	var teams struct {
+		Teams struct {
+			Nodes []struct {
+				ID string `json:"id"`
+			} `json:"nodes"`
+		} `json:"teams"`
+	}
+	query := `query ($key: String!) { teams(filter: { key: { eq: $key } }) { nodes { id } } }`
+	if err := l.query(ctx, query, map[string]interface{}{"key": l.cfg.Project}, &teams); err != nil {
+		return nil, err
+	}
+	if len(teams.Teams.Nodes) == 0 {
+		return nil, fmt.Errorf("no Linear team has the key %s", l.cfg.Project)
+	}
+
+	var created struct {
+		IssueCreate struct {
+			Success bool `json:"success"`
+			Issue   struct {
+				Identifier string `json:"identifier"`
+				URL        string `json:"url"`
+			} `json:"issue"`
+		} `json:"issueCreate"`
+	}
+	mutation := `mutation ($input: IssueCreateInput!) {
+  issueCreate(input: $input) { success issue { identifier url } }
+}`
+	input := map[string]interface{}{"teamId": teams.Teams.Nodes[0].ID, "title": title, "description": description}
+	if err := l.query(ctx, mutation, map[string]interface{}{"input": input}, &created); err != nil {
+		return nil, err
+	}
+	if !created.IssueCreate.Success {
+		return nil, errors.New("linear did not create the issue")
+	}
+	issue := created.IssueCreate.Issue
+	return &Issue{Key: issue.Identifier, Title: title, Description: description, URL: issue.URL}, nil
+}
+
+func (l *linear) Comment(ctx context.Context, key, body string) error {
+	var data struct {
+		Issue *struct {
+			ID string `json:"id"`
+		} `json:"issue"`
+	}
+	if err := l.query(ctx, `query ($id: String!) { issue(id: $id) { id } }`, map[string]interface{}{"id": key}, &data); err != nil {
+		return err
+	}
+	if data.Issue == nil {
+		return ErrNotFound
+	}
+	var created struct {
+		CommentCreate struct {
+			Success bool `json:"success"`
+		} `json:"commentCreate"`
+	}
+	mutation := `mutation ($input: CommentCreateInput!) { commentCreate(input: $input) { success } }`
+	input := map[string]interface{}{"issueId": data.Issue.ID, "body": body}
+	if err := l.query(ctx, mutation, map[string]interface{}{"input": input}, &created); err != nil {
+		return err
+	}
+	if !created.CommentCreate.Success {
+		return errors.New("linear did not create the comment")
+	}
+	return nil
+}
+
+// graphQLError is an error of a GraphQL response
+type graphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		UserPresentableMessage string `json:"userPresentableMessage"`
+	} `json:"extensions"`
+}
+
+func (e graphQLError) String() string {
+	if e.Extensions.UserPresentableMessage != "" {
+		return e.Extensions.UserPresentableMessage
+	}
+	return e.Message
+}
+
+// query runs a GraphQL query or mutation and decodes its data into out
+func (l *linear) query(ctx context.Context, query string, variables map[string]interface{}, out interface{}) error {
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphQLError  `json:"errors"`
+	}
+	request := map[string]interface{}{"query": query, "variables": variables}
+	if err := call(ctx, l.http, http.MethodPost, l.cfg.URL, l.authorize, request, &response, describeGraphQLError); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		if strings.Contains(strings.ToLower(response.Errors[0].Message), "not found") {
+			return ErrNotFound
+		}
+		return fmt.Errorf("linear: %s", response.Errors[0])
+	}
+	return json.Unmarshal(response.Data, out)
+}
+
+// authorize sends personal API keys as they are and OAuth tokens as
+// bearer tokens
+func (l *linear) authorize(req *http.Request) {
+	if strings.HasPrefix(l.cfg.Token, "lin_api_") {
+		req.Header.Set("Authorization", l.cfg.Token)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+l.cfg.Token)
+}
+
+// describeGraphQLError returns the first error of a failed GraphQL
+// response
+func describeGraphQLError(body []byte) string {
+	var failure struct {
+		Errors []graphQLError `json:"errors"`
+	}
+	if json.Unmarshal(body, &failure) != nil || len(failure.Errors) == 0 {
+		return strings.TrimSpace(string(body))
+	}
+	return failure.Errors[0].String()
+}
@@ -0,0 +1,147 @@
+// Package tickets reads, creates and comments on the issues of an issue
+// tracker, Jira or Linear, so plans can be linked to the work they are for.
+package tickets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for issues the tracker does not have, or does
+// not show with the configured credentials
+var ErrNotFound = errors.New("issue not found")
+
+// apiTimeout bounds one tracker API call
+const apiTimeout = 30 * time.Second
+
+// maxComments caps the comments read with an issue, the latest ones
+const maxComments = 20
+
+// Issue is an issue of the tracker
+type Issue struct {
+	Key         string    `json:"key"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	URL         string    `json:"url"`
+	Comments    []Comment `json:"comments,omitempty"`
+}
+
+// Comment is a comment on an issue
+type Comment struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+}
+
+// Text renders the issue as plain text, for a prompt
+func (i *Issue) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n", i.Key, i.Title)
+	if i.Status != "" {
+		fmt.Fprintf(&b, "Status: %s\n", i.Status)
+	}
+	if description := strings.TrimSpace(i.Description); description != "" {
+		b.WriteString("\n" + description + "\n")
+	}
+	for _, comment := range i.Comments {
+		fmt.Fprintf(&b, "\nComment by %s:\n%s\n", comment.Author, strings.TrimSpace(comment.Body))
+	}
+	return b.String()
+}
+
+// Tracker is an issue tracker
+type Tracker interface {
+	// Issue returns the issue with key, such as PROJ-123, and its latest
+	// comments
+	Issue(ctx context.Context, key string) (*Issue, error)
+	// Create creates an issue in the configured project or team
+	Create(ctx context.Context, title, description string) (*Issue, error)
+	// Comment posts body on the issue with key
+	Comment(ctx context.Context, key, body string) error
+}
+
+// Config selects and configures the tracker
+type Config struct {
+	// Provider is "jira" or "linear"
+	Provider string
+	// URL is the Jira site, such as https://example.atlassian.net, or the
+	// Linear API, https://api.linear.app/graphql by default
+	URL string
+	// Email, with Jira Cloud, is the account Token is an API token of;
+	// without it Token is sent as a personal access token
+	Email string
+	Token string
+	// Project is the Jira project key or Linear team key new issues are
+	// created in
+	Project string
+	// IssueType is the type of the Jira issues created
+	IssueType string
+}
+
+// New returns the tracker cfg describes, or nil if no provider is set
+func New(cfg Config) (Tracker, error) {
+	client := &http.Client{Timeout: apiTimeout}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "jira":
+		if cfg.URL == "" {
+			return nil, errors.New("jira needs the URL of the site")
+		}
+		return &jira{cfg: cfg, http: client}, nil
+	case "linear":
+		if cfg.URL == "" {
+			cfg.URL = linearAPI
+		}
+		return &linear{cfg: cfg, http: client}, nil
+	}
+	return nil, fmt.Errorf("unknown issue tracker %q; use jira or linear", cfg.Provider)
+}
+
+// call sends body as JSON to url, authorized by authorize, and decodes the
+// response into out, if not nil. describe turns the body of a failed
+// response into an error message.
+func call(ctx context.Context, client *http.Client, method, url string, authorize func(*http.Request), body, out interface{}, describe func([]byte) string) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	authorize(req)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		failure, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, describe(failure))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
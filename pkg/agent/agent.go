@@ -0,0 +1,310 @@
+// Package agent embeds Spilot in Go programs. New builds the agents from the
+// same configuration the server reads, without serving the HTTP API, and
+// the methods of Agent run requests and commands and return typed results.
+//
+//	a, err := agent.New(agent.WithWorkspace("."), agent.WithAPIKey(key))
+//	if err != nil {
+//		return err
+//	}
+//	defer a.Close()
+//	fix, err := a.Fix(ctx, testOutput, false)
+//
+// The configuration is process-wide, as for the server: create one Agent
+// per process and use Workspace for other workspaces.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	core "spilot-agent/internal/agent"
+	"spilot-agent/internal/config"
+	"spilot-agent/internal/llm"
+	"spilot-agent/internal/redact"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Agent runs Spilot's agents in-process
+type Agent struct {
+	system    *core.System
+	workspace string
+	logger    *zap.Logger
+}
+
+// Option configures New
+type Option func(*options)
+
+type options struct {
+	configFile string
+	profile    string
+	settings   map[string]interface{}
+	logger     *zap.Logger
+}
+
+// WithConfigFile reads the configuration from path instead of looking for
+// config.yaml in the working directory
+func WithConfigFile(path string) Option {
+	return func(o *options) { o.configFile = path }
+}
+
+// WithProfile applies a profile of the configuration file
+func WithProfile(name string) Option {
+	return func(o *options) { o.profile = name }
+}
+
+// WithSetting sets a configuration key, such as "audit_log" or
+// "executor", over the file and the environment
+func WithSetting(key string, value interface{}) Option {
+	return func(o *options) { o.settings[key] = value }
+}
+
+// WithAPIKey sets the key of the LLM provider
+func WithAPIKey(key string) Option {
+	return WithSetting("groq_api_key", key)
+}
+
+// WithModel sets the default model
+func WithModel(model string) Option {
+	return WithSetting("default_model", model)
+}
+
+// WithWorkspace sets the directory the agents work in by default
+func WithWorkspace(dir string) Option {
+	return WithSetting("workspace_dir", dir)
+}
+
+// WithDataDir sets where sessions, audit logs and other state are kept
+func WithDataDir(dir string) Option {
+	return WithSetting("data_dir", dir)
+}
+
+// WithLogger logs through logger, with secrets redacted. Without it
+// nothing is logged.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// New loads the configuration and starts the agents
+func New(opts ...Option) (*Agent, error) {
+	o := options{settings: make(map[string]interface{}), logger: zap.NewNop()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.configFile != "" {
+		config.SetFile(o.configFile)
+	}
+	if o.profile != "" {
+		config.SelectProfile(o.profile)
+	}
+	for key, value := range o.settings {
+		config.Set(key, value)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	redactor, err := redact.New(cfg.RedactPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact_patterns: %w", err)
+	}
+	redactor.SetSecrets(cfg.SecretValues())
+	logger := o.logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return redact.Core(c, redactor)
+	}))
+
+	llmClient, err := llm.NewGroqClient(cfg.GroqAPIKey, cfg.DefaultModel, llm.TransportConfig(cfg.LLMTransport))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize LLM client: %w", err)
+	}
+	system, err := core.NewSystem(llmClient, cfg, redactor, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize agent system: %w", err)
+	}
+	return &Agent{system: system, workspace: cfg.WorkspaceDir, logger: logger}, nil
+}
+
+// Close stops the background processes and terminals the agents started
+func (a *Agent) Close() {
+	a.system.Shutdown()
+	a.logger.Sync()
+}
+
+// Workspace returns an Agent sharing a's agents that works in dir
+func (a *Agent) Workspace(dir string) *Agent {
+	c := *a
+	c.workspace = dir
+	return &c
+}
+
+// Request has the planning agent handle a request in plain words. Requests
+// that change the workspace return a plan to approve and execute; others
+// are answered directly.
+func (a *Agent) Request(ctx context.Context, request string) (*Plan, error) {
+	ctx, taskID := withTask(ctx)
+	result, err := a.system.ProcessUserRequest(ctx, request, a.workspace)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Result: newResult(taskID, result)}
+	if generated := result.Plan(); generated != nil {
+		plan.Plan, plan.ApprovalID, plan.Ticket = generated.Plan, generated.ApprovalID, generated.Ticket
+	}
+	return plan, nil
+}
+
+// Approve approves a pending approval, such as that of a plan, returning
+// the token executing an approved plan needs
+func (a *Agent) Approve(id string) (string, error) {
+	_, token, _, err := a.system.DecideApproval(id, true)
+	return token, err
+}
+
+// Reject rejects a pending approval
+func (a *Agent) Reject(id string) error {
+	_, _, _, err := a.system.DecideApproval(id, false)
+	return err
+}
+
+// ExecutePlan executes an approved plan with the token Approve returned,
+// returning the results of its steps. A step beyond the plan's blast-radius
+// limits stops it with a *BlastRadiusError.
+func (a *Agent) ExecutePlan(ctx context.Context, plan *Plan, token string) ([]*Result, error) {
+	ctx, taskID := withTask(ctx)
+	results, err := a.system.ExecutePlan(ctx, plan.Plan, token, a.workspace)
+	steps := make([]*Result, len(results))
+	for i, result := range results {
+		step := newResult(fmt.Sprintf("%s.%d", taskID, i+1), result)
+		steps[i] = &step
+	}
+	return steps, blastRadiusError(err)
+}
+
+// Command runs a slash command such as "/test" or "/review" with args,
+// as the chat and the command-line client do. options are the command's
+// options, such as {"apply": true}.
+func (a *Agent) Command(ctx context.Context, command, args string, options map[string]interface{}) (*Result, error) {
+	ctx, taskID := withTask(ctx)
+	result, err := a.system.HandleCommand(ctx, command, args, a.workspace, options)
+	if err != nil {
+		return nil, err
+	}
+	r := newResult(taskID, result)
+	return &r, nil
+}
+
+// Fix has the debug agent analyze errorOutput, such as a failing build or
+// test, and propose a fix, which apply writes to the workspace
+func (a *Agent) Fix(ctx context.Context, errorOutput string, apply bool) (*Fix, error) {
+	result, err := a.Command(ctx, "/fix", errorOutput, map[string]interface{}{"apply": apply})
+	if err != nil {
+		return nil, err
+	}
+	fix := &Fix{Result: *result, Applied: apply && result.Success}
+	if debug, ok := result.Data.(*DebugResult); ok {
+		fix.Analysis, fix.Fix = debug.Analysis, debug.Fix
+	}
+	return fix, nil
+}
+
+// Explain explains target: a file, a symbol or a snippet of code
+func (a *Agent) Explain(ctx context.Context, target string) (*Explanation, error) {
+	result, err := a.Command(ctx, "/explain", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	explanation := &Explanation{Result: *result}
+	fields, _ := result.Data.(Fields)
+	explanation.Explanation, _ = fields["explanation"].(string)
+	return explanation, nil
+}
+
+// Chat answers a message conversationally, taking the history of the
+// session of ctx into account (see WithSession)
+func (a *Agent) Chat(ctx context.Context, message string) (string, error) {
+	return a.system.Chat(ctx, message)
+}
+
+// NewSession starts a conversation in the workspace whose requests share
+// context, returning its ID
+func (a *Agent) NewSession(ctx context.Context, title string) (string, error) {
+	session, err := a.system.CreateSession(ctx, title, a.workspace)
+	if err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// WithSession makes the requests made with ctx part of a session
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return core.ContextWithSession(ctx, sessionID)
+}
+
+// WithTicket links the plans of the requests made with ctx to the issue
+// with key, such as PROJ-123, of the tracker configured under tickets. The
+// issue informs the plan and gets a summary once the plan has run.
+func WithTicket(ctx context.Context, key string) context.Context {
+	return core.ContextWithTicket(ctx, key)
+}
+
+// CreatingTicket has the plans of the requests made with ctx recorded in a
+// new issue of the configured tracker, which they are then linked to
+func CreatingTicket(ctx context.Context) context.Context {
+	return core.ContextCreatingTicket(ctx)
+}
+
+// ErrTicket is returned when the issue a request is linked to cannot be
+// read, or no tracker is configured
+var ErrTicket = core.ErrTicket
+
+// WithTaskID chooses the ID of the task a request made with ctx runs as,
+// so its events can be subscribed to before it starts
+func WithTaskID(ctx context.Context, taskID string) context.Context {
+	return core.ContextWithTaskID(ctx, taskID)
+}
+
+// NewTaskID returns a new task ID for WithTaskID
+func NewTaskID() string {
+	return core.NewTaskID()
+}
+
+// Subscribe returns the events of the task taskID, or of every task if it
+// is empty, and a function ending the subscription. Events a slow
+// subscriber does not take in time are dropped.
+func (a *Agent) Subscribe(taskID string) (<-chan Event, func()) {
+	events, unsubscribe := a.system.Events().Subscribe(taskID)
+	out := make(chan Event, cap(events))
+	go func() {
+		defer close(out)
+		for event := range events {
+			select {
+			case out <- Event{TaskID: event.TaskID, Type: string(event.Type), Data: event.Data, Timestamp: event.Timestamp}:
+			default:
+			}
+		}
+	}()
+	return out, unsubscribe
+}
+
+// ReadOutput returns an output too large to keep in a result, by the
+// OutputID of its OutputRef or of a command in Data. The caller closes it.
+func (a *Agent) ReadOutput(ctx context.Context, id string) (io.ReadCloser, error) {
+	return a.system.ReadOutput(ctx, id)
+}
+
+// ErrOutputNotFound is returned by ReadOutput for outputs that do not exist
+// or were removed when the Agent was closed
+var ErrOutputNotFound = core.ErrOutputNotFound
+
+// withTask returns ctx with the ID of the task a request runs as, keeping
+// one chosen with WithTaskID
+func withTask(ctx context.Context) (context.Context, string) {
+	if taskID := core.TaskIDFrom(ctx); taskID != "" {
+		return ctx, taskID
+	}
+	taskID := core.NewTaskID()
+	return core.ContextWithTaskID(ctx, taskID), taskID
+}
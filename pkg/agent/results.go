@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	core "spilot-agent/internal/agent"
+)
+
+// Result is the outcome of a task
+type Result struct {
+	TaskID  string
+	Success bool
+	// Error says why the task failed
+	Error string
+	// Text is what the agent answered or did, in words
+	Text string
+	// Usage is what the task's LLM calls used
+	Usage Usage
+	// Data holds everything the agent returned: a *PlanResult,
+	// *FileOpResult, *CommandResult or *DebugResult, or Fields for the
+	// other agents, whose outputs larger than spill_bytes are OutputRefs
+	Data ResultData
+}
+
+// ResultData is the payload of a Result; its Kind says which it is
+type ResultData = core.ResultData
+
+// ResultKind names the payloads of results
+type ResultKind = core.ResultKind
+
+// The kinds of result payloads
+const (
+	ResultPlan    = core.ResultPlan
+	ResultFileOp  = core.ResultFileOp
+	ResultCommand = core.ResultCommand
+	ResultDebug   = core.ResultDebug
+	ResultFields  = core.ResultFields
+)
+
+// The typed payloads of the planning, file, terminal and debug agents, and
+// the fields of the others
+type (
+	PlanResult    = core.PlanResult
+	FileOpResult  = core.FileOpResult
+	CommandResult = core.CommandResult
+	DebugResult   = core.DebugResult
+	Fields        = core.Fields
+)
+
+// OutputRef stands in Data for an output written to disk; Agent.ReadOutput
+// returns it whole
+type OutputRef = core.OutputRef
+
+// Usage totals LLM calls
+type Usage struct {
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	// CachedTokens are the prompt tokens read from the provider's prompt
+	// cache, and CacheSavings what that saved
+	CachedTokens int
+	Cost         float64
+	CacheSavings float64
+}
+
+// Plan is the result of a request. Plan and ApprovalID are set when the
+// request needs a plan approved before it is executed. Ticket is the issue
+// the plan is linked to, if any.
+type Plan struct {
+	Result
+	Plan       string
+	ApprovalID string
+	Ticket     string
+}
+
+// Fix is the debug agent's analysis of an error and its fix
+type Fix struct {
+	Result
+	Analysis string
+	Fix      string
+	// Applied tells whether the fix was written to the workspace
+	Applied bool
+}
+
+// Explanation explains code
+type Explanation struct {
+	Result
+	Explanation string
+}
+
+// Event is a progress notification of a task, such as "task_started",
+// "task_completed", "task_failed" or "approval_required"
+type Event struct {
+	TaskID    string
+	Type      string
+	Data      map[string]interface{}
+	Timestamp time.Time
+}
+
+// ErrBlastRadius is wrapped by BlastRadiusError
+var ErrBlastRadius = core.ErrBlastRadius
+
+// BlastRadiusError reports that a plan stopped at one of its blast-radius
+// limits. Approving ApprovalID lets it continue.
+type BlastRadiusError struct {
+	Reason     string
+	Task       string
+	ApprovalID string
+	err        error
+}
+
+func (e *BlastRadiusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *BlastRadiusError) Unwrap() error {
+	return ErrBlastRadius
+}
+
+func blastRadiusError(err error) error {
+	var limit *core.BlastRadiusError
+	if errors.As(err, &limit) {
+		return &BlastRadiusError{Reason: limit.Reason, Task: limit.Task, ApprovalID: limit.ApprovalID, err: err}
+	}
+	return err
+}
+
+// textFields are the result fields that describe what a task did, in the
+// order they are looked for
+var textFields = []string{"message", "response", "answer", "explanation", "summary", "analysis"}
+
+func newResult(taskID string, result *core.TaskResult) Result {
+	if result == nil {
+		return Result{TaskID: taskID}
+	}
+	r := Result{TaskID: taskID, Success: result.Success, Error: result.Error, Data: result.Data}
+	if debug := result.Debug(); debug != nil {
+		r.Text = strings.TrimSpace(debug.Analysis)
+	}
+	fields := result.Fields()
+	for _, field := range textFields {
+		if r.Text != "" {
+			break
+		}
+		text, _ := fields[field].(string)
+		if ref, spilled := fields[field].(core.OutputRef); spilled {
+			text = ref.Preview
+		}
+		r.Text = strings.TrimSpace(text)
+	}
+	if result.Usage != nil {
+		r.Usage = Usage(*result.Usage)
+	}
+	return r
+}
@@ -0,0 +1,49 @@
+# Example policy for Spilot. Each rule adds a reason to deny; an action is
+# allowed when deny is empty. Input fields: action (file_write or command),
+# operation (create, update, delete), path, command, working_dir, agent,
+# user, workspace and task_id.
+package spilot
+
+import rego.v1
+
+# Infrastructure is changed through reviewed pull requests only
+deny contains msg if {
+	input.action == "file_write"
+	contains(input.path, "/infra/")
+	msg := sprintf("files under infra/ may not be modified: %s", [input.path])
+}
+
+deny contains msg if {
+	input.action == "file_write"
+	regex.match(`(^|/)\.github/workflows/`, input.path)
+	msg := "CI workflows may not be modified"
+}
+
+deny contains msg if {
+	input.action == "file_write"
+	input.operation == "delete"
+	endswith(input.path, ".sql")
+	msg := sprintf("migrations may not be deleted: %s", [input.path])
+}
+
+deny contains msg if {
+	input.action == "command"
+	regex.match(`git\s+push\s+.*(--force|-f)\b`, input.command)
+	msg := "force pushes are not allowed"
+}
+
+deny contains msg if {
+	input.action == "command"
+	regex.match(`\b(terraform|pulumi)\s+(apply|destroy|up)\b`, input.command)
+	msg := "infrastructure changes must go through CI"
+}
+
+# Only the release team may publish releases
+release_team := {"alice", "bob"}
+
+deny contains msg if {
+	input.agent == "release"
+	input.action == "command"
+	not input.user in release_team
+	msg := sprintf("%s may not run release commands", [input.user])
+}
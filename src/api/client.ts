@@ -0,0 +1,617 @@
+// Code generated by cmd/tsclient from the OpenAPI spec of the Spilot agent API 1.2.0. DO NOT EDIT.
+// Change internal/server/openapi.yaml and run `go generate ./internal/server` instead.
+
+/** The version of the API this client was generated for */
+export const API_VERSION = '1.2.0';
+
+/** The body of agent requests; each route reads the fields it needs */
+export interface Request {
+  type?: string;
+  command?: string;
+  args?: string;
+  request?: string;
+  workspace_dir?: string;
+  model?: string;
+  /** The ID the task runs as, so its events can be subscribed to before it starts */
+  task_id?: string;
+  session_id?: string;
+  title?: string;
+  message?: string;
+  plan?: string;
+  token?: string;
+  /** Holds each file write until its diff preview is approved */
+  confirm_writes?: boolean;
+  /** An issue of the configured tracker, such as PROJ-123, that informs the plan and gets a summary once it has run */
+  ticket?: string;
+  /** Records the plan in a new issue, which it is then linked to */
+  create_ticket?: boolean;
+  data?: Record<string, unknown>;
+}
+
+/** The envelope of every JSON response */
+export interface Response {
+  success: boolean;
+  data?: Record<string, unknown>;
+  error?: string;
+}
+
+export interface Health {
+  status: string;
+}
+
+/** The result of a task. Kind says which payload data is: the typed results of the planning, file, terminal and debug agents, or the agent-specific fields of the others. */
+export type TaskResult = PlanTaskResult | FileOpTaskResult | CommandTaskResult | DebugTaskResult | FieldsTaskResult;
+
+export type TaskResultType = TaskResult['kind'];
+
+/** A plan generated by the planning agent */
+export interface PlanTaskResult {
+  success: boolean;
+  kind: 'plan';
+  data: PlanResult;
+  error?: string;
+  usage?: Usage;
+}
+
+/** A file agent operation */
+export interface FileOpTaskResult {
+  success: boolean;
+  kind: 'file';
+  data: FileOpResult;
+  error?: string;
+  usage?: Usage;
+}
+
+/** A command of the terminal agent */
+export interface CommandTaskResult {
+  success: boolean;
+  kind: 'command';
+  data: CommandResult;
+  error?: string;
+  usage?: Usage;
+}
+
+/** An analysis of the debug agent */
+export interface DebugTaskResult {
+  success: boolean;
+  kind: 'debug';
+  data: DebugResult;
+  error?: string;
+  usage?: Usage;
+}
+
+/** The result of an agent without a typed result */
+export interface FieldsTaskResult {
+  success: boolean;
+  kind: 'fields';
+  data: Record<string, unknown>;
+  error?: string;
+  usage?: Usage;
+}
+
+export interface PlanResult {
+  /** The steps of the request, as JSON text */
+  plan?: string;
+  /** The project of /create-project */
+  project?: Record<string, unknown>;
+  plan_sha256?: string;
+  /** The approval the plan waits for before it is executed */
+  approval_id?: string;
+  ticket?: string;
+  ticket_url?: string;
+  ticket_error?: string;
+  web_sources?: Record<string, unknown>[];
+}
+
+export interface FileOpResult {
+  operation: 'create' | 'update' | 'delete' | 'read' | 'head' | 'tail';
+  path: string;
+  created?: boolean;
+  updated?: boolean;
+  deleted?: boolean;
+  formatted?: boolean;
+  content?: string;
+  /** Set when content is a preview of a large file; /api/outputs/{id} returns all of it */
+  content_id?: string;
+  lines?: number;
+}
+
+export interface CommandResult {
+  command: string;
+  shell?: string;
+  risk?: Record<string, unknown>;
+  status?: string;
+  output?: string;
+  error?: string;
+  exit_code: number;
+  truncated?: boolean;
+  /** Set when output is a preview of a large output; /api/outputs/{id} returns all of it */
+  output_id?: string;
+  /** Set when error is a preview of a large output */
+  error_id?: string;
+  dry_run?: boolean;
+  /** What a dry run predicts the command does */
+  effects?: string;
+  background?: boolean;
+  process?: Record<string, unknown>;
+  requires_approval?: boolean;
+  approval_id?: string;
+}
+
+export interface DebugResult {
+  analysis: string;
+  fix?: string;
+  candidates?: Record<string, unknown>[];
+  file?: string;
+  locations?: Record<string, unknown>[];
+  diagnostics?: Record<string, unknown>[];
+  /** The Go panic or data race analyzed */
+  crash?: Record<string, unknown>;
+  web_sources?: Record<string, unknown>[];
+  injection_warnings?: Record<string, unknown>[];
+  patches?: Record<string, unknown>[];
+  applied?: Record<string, unknown>;
+  regression?: Record<string, unknown>;
+  verification?: Record<string, unknown>;
+  verified?: boolean;
+  /** Set when the fix waits for its verification command to be approved; resubmit the task with approval_id once it is */
+  requires_approval?: boolean;
+  approval_id?: string;
+}
+
+/** What the LLM calls of a task and its sub-tasks used */
+export interface Usage {
+  calls: number;
+  prompt_tokens: number;
+  completion_tokens: number;
+  total_tokens: number;
+  cached_tokens: number;
+  cost: number;
+  cache_savings: number;
+}
+
+export type ChatResponse = Response & {
+  data?: {
+    message: string;
+    session_id?: string;
+  };
+};
+
+export type PlanResponse = Response & {
+  data?: {
+    results: TaskResult[];
+    /** Why execution paused at a blast-radius limit */
+    paused?: string;
+    approval_id?: string;
+  };
+};
+
+export type ApprovalStatus = 'pending' | 'approved' | 'rejected' | 'consumed';
+
+export interface Approval {
+  id: string;
+  task_id: string;
+  kind: string;
+  subject: string;
+  risk?: Record<string, unknown>;
+  data?: Record<string, unknown>;
+  status: ApprovalStatus;
+  created_at: string;
+  decided_at?: string;
+  working_dir?: string;
+}
+
+export type ApprovalsResponse = Response & {
+  data?: {
+    approvals: Approval[];
+  };
+};
+
+export type DecisionResponse = Response & {
+  data?: {
+    approval: Approval;
+    execution_token?: string;
+    expires_at?: string;
+  };
+};
+
+export interface SessionMessage {
+  role: string;
+  content: string;
+  task_id?: string;
+  created_at: string;
+}
+
+export interface Session {
+  id: string;
+  title?: string;
+  workspace_dir?: string;
+  owner?: string;
+  created_at: string;
+  updated_at: string;
+  messages: SessionMessage[];
+}
+
+export interface SessionSummary {
+  id: string;
+  title?: string;
+  workspace_dir?: string;
+  owner?: string;
+  /** The number of messages */
+  messages: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export type SessionResponse = Response & {
+  data?: {
+    session: Session;
+  };
+};
+
+export type SessionsResponse = Response & {
+  data?: {
+    sessions: SessionSummary[];
+  };
+};
+
+export type TaskEvent = TaskStartedEvent | TaskCompletedEvent | TaskFailedEvent | CommandOutputEvent | ApprovalRequiredEvent | RepairIterationEvent | MigrationBatchEvent | HandoffEvent;
+
+export type TaskEventType = TaskEvent['type'];
+
+export interface TaskStartedEvent {
+  task_id: string;
+  type: 'task_started';
+  data: {
+    agent: string;
+    description: string;
+  };
+  timestamp: string;
+}
+
+export interface TaskCompletedEvent {
+  task_id: string;
+  type: 'task_completed';
+  data: {
+    success: boolean;
+  };
+  timestamp: string;
+}
+
+export interface TaskFailedEvent {
+  task_id: string;
+  type: 'task_failed';
+  data: {
+    error: string;
+  };
+  timestamp: string;
+}
+
+export interface CommandOutputEvent {
+  task_id: string;
+  type: 'command_output';
+  data: {
+    stream: 'stdout' | 'stderr';
+    chunk: string;
+  };
+  timestamp: string;
+}
+
+/** An action waits for approval. Besides approval_id, data describes the action: a command and its risk, a plan's plan_sha256, a file write's path and diff, a release or a SQL statement. */
+export interface ApprovalRequiredEvent {
+  task_id: string;
+  type: 'approval_required';
+  data: {
+    approval_id: string;
+    kind?: string;
+    reason?: string;
+    command?: string;
+    path?: string;
+    diff?: string;
+    plan_sha256?: string;
+    [key: string]: unknown;
+  };
+  timestamp: string;
+}
+
+export interface RepairIterationEvent {
+  task_id: string;
+  type: 'repair_iteration';
+  data: {
+    iteration: Record<string, unknown>;
+  };
+  timestamp: string;
+}
+
+export interface MigrationBatchEvent {
+  task_id: string;
+  type: 'migration_batch';
+  data: {
+    batch: number;
+    of: number;
+    result: Record<string, unknown>;
+  };
+  timestamp: string;
+}
+
+export interface HandoffEvent {
+  task_id: string;
+  type: 'handoff';
+  data: {
+    subtask_id: string;
+    agent: string;
+    description: string;
+  };
+  timestamp: string;
+}
+
+/** The fetch implementations the client works with: the global fetch or node-fetch */
+export type FetchLike = (url: string, init: {
+  method: string;
+  headers: Record<string, string>;
+  body?: string;
+  signal?: AbortSignal;
+}) => Promise<FetchResponse>;
+
+export interface FetchResponse {
+  ok: boolean;
+  status: number;
+  statusText: string;
+  headers: { get(name: string): string | null };
+  text(): Promise<string>;
+  body: unknown;
+}
+
+export interface ClientOptions {
+  /** The server's URL, such as http://localhost:8080 */
+  baseUrl: string;
+  /** Sent as X-API-Key when the server requires API keys */
+  apiKey?: string;
+  /** Sent as X-Spilot-User: the user requests are made for */
+  user?: string;
+  /** Defaults to the global fetch */
+  fetch?: FetchLike;
+  /**
+   * Called once when the server implements another major version of the
+   * API than the client; by default a warning is logged
+   */
+  onVersionMismatch?: (serverVersion: string, clientVersion: string) => void;
+}
+
+export interface RequestOptions {
+  signal?: AbortSignal;
+}
+
+/** SpilotApiError is thrown for responses with an error status */
+export class SpilotApiError extends Error {
+  constructor(
+    readonly status: number,
+    /** The response's envelope, whose data some errors carry, such as a paused plan's results */
+    readonly response: Response | undefined,
+    message: string,
+  ) {
+    super(message);
+    this.name = 'SpilotApiError';
+  }
+}
+
+type Query = Record<string, string | number | boolean | undefined>;
+
+class BaseClient {
+  private readonly fetch: FetchLike;
+  private versionChecked = false;
+
+  constructor(private readonly options: ClientOptions) {
+    const fetchImpl = options.fetch ?? (globalThis.fetch as unknown as FetchLike | undefined);
+    if (!fetchImpl) {
+      throw new Error('SpilotClient needs a fetch implementation');
+    }
+    this.fetch = fetchImpl;
+  }
+
+  protected async request<T>(method: string, path: string, body: unknown, query: Query | undefined, options?: RequestOptions): Promise<T> {
+    const res = await this.send(method, path, body, query, options);
+    const text = await res.text();
+    let payload: unknown;
+    if (text) {
+      try {
+        payload = JSON.parse(text);
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+    }
+    if (!res.ok) {
+      const envelope = payload as Response | undefined;
+      throw new SpilotApiError(res.status, envelope, envelope?.error || `${res.status} ${res.statusText}`);
+    }
+    return payload as T;
+  }
+
+  protected async text(path: string, query: Query | undefined, options?: RequestOptions): Promise<string> {
+    const res = await this.send('GET', path, undefined, query, options);
+    const text = await res.text();
+    if (!res.ok) {
+      let envelope: Response | undefined;
+      try {
+        envelope = JSON.parse(text) as Response;
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+      throw new SpilotApiError(res.status, envelope, envelope?.error || `${res.status} ${res.statusText}`);
+    }
+    return text;
+  }
+
+  protected async *stream<T>(path: string, query: Query | undefined, options?: RequestOptions): AsyncGenerator<T> {
+    const res = await this.send('GET', path, undefined, query, options);
+    if (!res.ok) {
+      const text = await res.text();
+      let envelope: Response | undefined;
+      try {
+        envelope = JSON.parse(text) as Response;
+      } catch {
+        // Not JSON; the status says what went wrong
+      }
+      throw new SpilotApiError(res.status, envelope, envelope?.error || `${res.status} ${res.statusText}`);
+    }
+    let buffer = '';
+    for await (const chunk of textChunks(res.body)) {
+      buffer += chunk.replace(/\r\n/g, '\n');
+      let end: number;
+      while ((end = buffer.indexOf('\n\n')) >= 0) {
+        const data = buffer.slice(0, end).split('\n')
+          .filter((line) => line.startsWith('data:'))
+          .map((line) => line.slice(5).trimStart())
+          .join('\n');
+        buffer = buffer.slice(end + 2);
+        if (data) {
+          yield JSON.parse(data) as T;
+        }
+      }
+    }
+  }
+
+  private async send(method: string, path: string, body: unknown, query: Query | undefined, options?: RequestOptions): Promise<FetchResponse> {
+    let url = this.options.baseUrl.replace(/\/+$/, '') + path;
+    const params = Object.entries(query ?? {})
+      .filter((entry): entry is [string, string | number | boolean] => entry[1] !== undefined)
+      .map(([name, value]) => `${encodeURIComponent(name)}=${encodeURIComponent(String(value))}`);
+    if (params.length > 0) {
+      url += '?' + params.join('&');
+    }
+    const headers: Record<string, string> = { Accept: 'application/json' };
+    if (body !== undefined) {
+      headers['Content-Type'] = 'application/json';
+    }
+    if (this.options.apiKey) {
+      headers['X-API-Key'] = this.options.apiKey;
+    }
+    if (this.options.user) {
+      headers['X-Spilot-User'] = this.options.user;
+    }
+    const res = await this.fetch(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      signal: options?.signal,
+    });
+    this.checkVersion(res.headers.get('X-Spilot-API-Version'));
+    return res;
+  }
+
+  private checkVersion(serverVersion: string | null) {
+    if (this.versionChecked || !serverVersion) {
+      return;
+    }
+    this.versionChecked = true;
+    if (serverVersion.split('.')[0] === API_VERSION.split('.')[0]) {
+      return;
+    }
+    if (this.options.onVersionMismatch) {
+      this.options.onVersionMismatch(serverVersion, API_VERSION);
+    } else {
+      console.warn(`Spilot server implements API ${serverVersion}, this client ${API_VERSION}`);
+    }
+  }
+}
+
+/** textChunks decodes a web or Node.js response body as it arrives */
+async function* textChunks(body: unknown): AsyncGenerator<string> {
+  const decoder = new TextDecoder();
+  const web = body as { getReader?: () => ReadableStreamDefaultReader<Uint8Array> };
+  if (typeof web?.getReader === 'function') {
+    const reader = web.getReader();
+    try {
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) {
+          return;
+        }
+        yield decoder.decode(value, { stream: true });
+      }
+    } finally {
+      reader.releaseLock();
+    }
+  }
+  for await (const chunk of body as AsyncIterable<Uint8Array | string>) {
+    yield typeof chunk === 'string' ? chunk : decoder.decode(chunk, { stream: true });
+  }
+}
+
+/** SpilotClient calls the agent API */
+export class SpilotClient extends BaseClient {
+  /** Reports that the server is alive */
+  health(options?: RequestOptions): Promise<Health> {
+    return this.request<Health>('GET', '/health', undefined, undefined, options);
+  }
+
+  /** Has the planning agent handle a request in plain words */
+  process(body: Request, options?: RequestOptions): Promise<TaskResult> {
+    return this.request<TaskResult>('POST', '/api/process', body, undefined, options);
+  }
+
+  /** Runs a slash command such as /test or /review */
+  command(body: Request, options?: RequestOptions): Promise<TaskResult> {
+    return this.request<TaskResult>('POST', '/api/command', body, undefined, options);
+  }
+
+  /** Answers a message conversationally */
+  chat(body: Request, options?: RequestOptions): Promise<ChatResponse> {
+    return this.request<ChatResponse>('POST', '/api/chat', body, undefined, options);
+  }
+
+  /** Runs a task on one agent with the task's data */
+  agentTask(type: string, body: Request, options?: RequestOptions): Promise<TaskResult> {
+    return this.request<TaskResult>('POST', `/api/agents/${encodeURIComponent(type)}/tasks`, body, undefined, options);
+  }
+
+  /** Executes an approved plan with its execution token. A step beyond the plan's blast-radius limits pauses it with a 409 whose data has the results so far and the approval_id to continue. */
+  executePlan(body: Request, options?: RequestOptions): Promise<PlanResponse> {
+    return this.request<PlanResponse>('POST', '/api/plans/execute', body, undefined, options);
+  }
+
+  /** Streams task events as server-sent events */
+  taskEvents(query?: { task_id?: string }, options?: RequestOptions): AsyncGenerator<TaskEvent> {
+    return this.stream<TaskEvent>('/api/tasks/events', query, options);
+  }
+
+  /** Returns a task output too large to keep in results, by the output_id of its reference, the output_id or error_id of a command or the content_id of a file read */
+  getOutput(id: string, options?: RequestOptions): Promise<string> {
+    return this.text(`/api/outputs/${encodeURIComponent(id)}`, undefined, options);
+  }
+
+  /** Lists the approval requests of the caller's tasks */
+  listApprovals(options?: RequestOptions): Promise<ApprovalsResponse> {
+    return this.request<ApprovalsResponse>('GET', '/api/approvals', undefined, undefined, options);
+  }
+
+  /** Approves a pending approval. Approving a plan also returns the token its execution needs. */
+  approve(id: string, options?: RequestOptions): Promise<DecisionResponse> {
+    return this.request<DecisionResponse>('POST', `/api/approvals/${encodeURIComponent(id)}/approve`, undefined, undefined, options);
+  }
+
+  /** Rejects a pending approval */
+  reject(id: string, options?: RequestOptions): Promise<DecisionResponse> {
+    return this.request<DecisionResponse>('POST', `/api/approvals/${encodeURIComponent(id)}/reject`, undefined, undefined, options);
+  }
+
+  /** Lists the caller's sessions */
+  listSessions(options?: RequestOptions): Promise<SessionsResponse> {
+    return this.request<SessionsResponse>('GET', '/api/sessions', undefined, undefined, options);
+  }
+
+  /** Starts a conversation whose requests share context */
+  createSession(body?: Request, options?: RequestOptions): Promise<SessionResponse> {
+    return this.request<SessionResponse>('POST', '/api/sessions', body, undefined, options);
+  }
+
+  /** Returns a session with its messages */
+  getSession(id: string, options?: RequestOptions): Promise<SessionResponse> {
+    return this.request<SessionResponse>('GET', `/api/sessions/${encodeURIComponent(id)}`, undefined, undefined, options);
+  }
+
+  /** Deletes a session */
+  deleteSession(id: string, options?: RequestOptions): Promise<Response> {
+    return this.request<Response>('DELETE', `/api/sessions/${encodeURIComponent(id)}`, undefined, undefined, options);
+  }
+}